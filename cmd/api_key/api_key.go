@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/helinwang/dex/pkg/dex"
+	"github.com/urfave/cli"
+)
+
+var keysPath string
+
+func openStore() (*dex.APIKeyStore, error) {
+	if keysPath == "" {
+		return nil, fmt.Errorf("please specify the API key file with -keys")
+	}
+
+	return dex.OpenAPIKeyStore(keysPath)
+}
+
+func createKey(c *cli.Context) error {
+	s, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	q := dex.Quota{Rate: c.Float64("rate"), Burst: c.Int("burst"), MaxConns: c.Int("conns")}
+	key, err := s.Create(strings.Split(c.String("perm"), ","), q)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("created API key, the secret is only shown once:")
+	fmt.Printf("ID: %s\n", key.ID)
+	fmt.Printf("Secret: %s\n", key.Secret)
+	return nil
+}
+
+func revokeKey(c *cli.Context) error {
+	id := c.Args().First()
+	if id == "" {
+		return fmt.Errorf("please specify the ID of the API key")
+	}
+
+	s, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	return s.Revoke(id)
+}
+
+func listKeys(c *cli.Context) error {
+	s, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintln(w, "ID\tPermissions\tRate\tBurst\tConns\tCreated\tRevoked\t")
+	for _, k := range s.Keys() {
+		fmt.Fprintf(w, "%s\t%s\t%v\t%d\t%d\t%s\t%v\t\n", k.ID, strings.Join(k.Permissions, ","), k.Rate, k.Burst, k.MaxConns, k.Created.Format("2006-01-02 15:04:05"), k.Revoked)
+	}
+	return w.Flush()
+}
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "DEX API key manager"
+	app.Usage = "manage the API keys of the node's wallet RPC, the node reloads the key file on change"
+
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:        "keys",
+			Usage:       "path to the API key file, the node's -api-keys flag",
+			Destination: &keysPath,
+		},
+	}
+
+	app.Commands = []cli.Command{
+		{
+			Name:   "create",
+			Usage:  "Create an API key: ./api_key -keys keys.json create -perm read,trade -rate 20 -burst 40 -conns 4",
+			Action: createKey,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "perm",
+					Value: dex.PermRead,
+					Usage: "comma separated permissions, possible values: read, trade",
+				},
+				cli.Float64Flag{
+					Name:  "rate",
+					Value: 50,
+					Usage: "max calls per second, 0 means no limit",
+				},
+				cli.IntFlag{
+					Name:  "burst",
+					Value: 100,
+					Usage: "max burst of the calls",
+				},
+				cli.IntFlag{
+					Name:  "conns",
+					Value: 16,
+					Usage: "max connections, 0 means no limit",
+				},
+			},
+		},
+		{
+			Name:   "revoke",
+			Usage:  "Revoke an API key, its connections are closed on their next call: ./api_key -keys keys.json revoke <ID>",
+			Action: revokeKey,
+		},
+		{
+			Name:   "list",
+			Usage:  "List the API keys: ./api_key -keys keys.json list",
+			Action: listKeys,
+		},
+	}
+
+	err := app.Run(os.Args)
+	if err != nil {
+		fmt.Printf("command failed with error: %v\n", err)
+	}
+}
@@ -8,13 +8,20 @@ import (
 	"fmt"
 	"io/ioutil"
 
+	"github.com/helinwang/dex/pkg/consensus"
 	"github.com/helinwang/dex/pkg/dex"
 )
 
 func main() {
 	c := flag.String("c", "", "path to the node credential file")
+	network := flag.String("network", "devnet", "the network of the address, possible values: mainnet, testnet, devnet")
 	flag.Parse()
 
+	networkID, err := consensus.NetworkByName(*network)
+	if err != nil {
+		panic(err)
+	}
+
 	b, err := ioutil.ReadFile(*c)
 	if err != nil {
 		panic(err)
@@ -34,6 +41,5 @@ func main() {
 	pkStr := base64.StdEncoding.EncodeToString(credential.PK)
 	fmt.Printf("PK: %s\n", pkStr)
 
-	addr := credential.PK.Addr()
-	fmt.Printf("Addr: %x\n", addr[:])
+	fmt.Printf("Addr: %s\n", credential.PK.Addr().Encode(networkID))
 }
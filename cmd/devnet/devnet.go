@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/gob"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/helinwang/dex/pkg/dex"
+	"github.com/helinwang/log15"
+)
+
+// devnet runs a local network of in-process validator nodes with
+// short rounds and pre-funded accounts. The credentials of the
+// accounts are written to a directory, so the wallet can trade with
+// them through the RPC endpoint of the first node.
+func main() {
+	numNode := flag.Int("N", 4, "number of validator nodes")
+	numGroup := flag.Int("g", 4, "number of groups")
+	groupSize := flag.Int("n", 3, "group size")
+	threshold := flag.Int("t", 2, "group signature threshold size")
+	numAccount := flag.Int("accounts", 10, "number of pre-funded accounts, the first account is the governor")
+	credentialsDir := flag.String("dir", "./devnet-credentials", "output directory of the pre-funded account credentials")
+	host := flag.String("host", "127.0.0.1", "address the nodes listen connections on")
+	port := flag.Int("port", 11001, "port of the first node, the other nodes use the following ports")
+	rpcAddr := flag.String("rpc-addr", ":12001", "rpc address of the first node used to serve wallet RPC calls")
+	blockTime := flag.Duration("block-time", 200*time.Millisecond, "round interval")
+	lvl := flag.String("lvl", "warn", "log level, possible values: debug, info, warn, error, crit")
+	flag.Parse()
+
+	l, err := log15.LvlFromString(*lvl)
+	if err != nil {
+		panic(err)
+	}
+	log15.Root().SetHandler(log15.LvlFilterHandler(l, log15.StdoutHandler))
+	dex.SetNetworkID(consensus.Devnet)
+
+	err = os.MkdirAll(*credentialsDir, os.ModePerm)
+	if err != nil {
+		panic(err)
+	}
+
+	owners := make([]dex.PK, *numAccount)
+	for i := range owners {
+		pk, sk := dex.RandKeyPair()
+		owners[i] = pk
+		writeCredential(path.Join(*credentialsDir, fmt.Sprintf("node-%d", i)), dex.Credential{PK: pk, SK: sk})
+	}
+
+	state := dex.CreateGenesisState(owners, nil)
+	state.UpdateGovernor(owners[0].Addr())
+	stateBlob, err := state.Serialize()
+	if err != nil {
+		panic(err)
+	}
+
+	groups := consensus.MakeGenesisGroups(*numNode, *numGroup, *groupSize, *threshold, consensus.Rand(consensus.SHA3([]byte("dex-devnet"))))
+	genesis := consensus.Genesis{
+		NetworkID: consensus.Devnet,
+		Block: consensus.Block{
+			StateRoot: state.Hash(),
+			SysTxns:   groups.SysTxns,
+		},
+		State: stateBlob,
+	}
+
+	cfg := consensus.Config{
+		BlockTime:      *blockTime,
+		GroupSize:      *groupSize,
+		GroupThreshold: *threshold,
+		NetworkID:      consensus.Devnet,
+	}
+
+	nodes := make([]*consensus.Node, *numNode)
+	server := dex.NewRPCServer()
+	for i, c := range groups.Nodes {
+		var u consensus.Updater = nopUpdater{}
+		if i == 0 {
+			u = server
+		}
+
+		s := dex.NewState(ethdb.NewMemDatabase())
+		pk, _ := dex.RandKeyPair()
+		nodes[i] = consensus.MakeNode(c, cfg, genesis, s, dex.NewTxnPool(s), u, pk)
+	}
+
+	server.SetSender(nodes[0])
+	server.SetStater(nodes[0].Chain())
+	err = server.Start(*rpcAddr)
+	if err != nil {
+		panic(err)
+	}
+
+	seed := ""
+	for i, n := range nodes {
+		err = n.Start(*host, *port+i, seed)
+		if err != nil {
+			panic(err)
+		}
+		seed = fmt.Sprintf("%s:%d", *host, *port)
+	}
+
+	for _, n := range nodes {
+		n.EndRound(0)
+	}
+
+	fmt.Printf("devnet of %d nodes started, wallet RPC endpoint: %s, pre-funded account credentials: %s\n", *numNode, *rpcAddr, *credentialsDir)
+	select {}
+}
+
+type nopUpdater struct{}
+
+func (nopUpdater) Update(consensus.State) {}
+
+func writeCredential(p string, c dex.Credential) {
+	f, err := os.Create(p)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	err = gob.NewEncoder(f).Encode(c)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 func main() {
 	num := flag.Int("N", 1000, "number of credentials to generate")
 	dir := flag.String("dir", "./credentials", "output directory name")
+	ed25519 := flag.Bool("ed25519", false, "generate ed25519 rather than secp256k1 credentials")
 	flag.Parse()
 
 	err := os.MkdirAll(*dir, os.ModePerm)
@@ -22,6 +23,9 @@ func main() {
 	credentials := make([]dex.Credential, *num)
 	for i := 0; i < *num; i++ {
 		pk, sk := dex.RandKeyPair()
+		if *ed25519 {
+			pk, sk = dex.RandEd25519KeyPair()
+		}
 		credentials[i].SK = sk
 		credentials[i].PK = pk
 	}
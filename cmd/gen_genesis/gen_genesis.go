@@ -14,38 +14,10 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/dfinity/go-dfinity-crypto/bls"
 	"github.com/helinwang/dex/pkg/consensus"
 	"github.com/helinwang/dex/pkg/dex"
 )
 
-func getMasterSecretKey(sk bls.SecretKey, k int, rand consensus.Rand) ([]bls.SecretKey, consensus.Rand) {
-	msk := make([]bls.SecretKey, k)
-	msk[0] = sk
-	for i := 1; i < k; i++ {
-		msk[i] = rand.SK().MustGet()
-		rand = rand.Derive(rand[:])
-	}
-	return msk, rand
-}
-
-func makeShares(t int, idVec []bls.ID, rand consensus.Rand) (bls.PublicKey, []bls.SecretKey, consensus.Rand) {
-	sk := rand.SK().MustGet()
-	rand = rand.Derive(rand[:])
-
-	msk, rand := getMasterSecretKey(sk, t, rand)
-	skShares := make([]bls.SecretKey, len(idVec))
-
-	for i := range skShares {
-		err := skShares[i].Set(msk, &idVec[i])
-		if err != nil {
-			panic(err)
-		}
-	}
-
-	return *sk.GetPublicKey(), skShares, rand
-}
-
 func loadCredentials(dir string) ([]dex.PK, error) {
 	var r []dex.PK
 	files, err := ioutil.ReadDir(dir)
@@ -90,9 +62,17 @@ func main() {
 	outDir := flag.String("dir", "./genesis", "output directoy name")
 	distributeTo := flag.String("distribute-to", "./credentials", "the native token (and the optionally created tokens) will be evenly distributed to all credentials in this folder")
 	seed := flag.String("seed", "dex-genesis-group", "random seed")
+	network := flag.String("network", "devnet", "the network of the genesis block, possible values: mainnet, testnet, devnet")
+	bridgeGroup := flag.Int("bridge-group", -1, "the index of the group that signs the ERC-20 bridge transactions, the bridge is disabled if negative")
+	governor := flag.Int("governor", -1, "the index of the credential in the distribute-to folder whose account is the governor, governance is disabled if negative")
 	additionalTokenPath := flag.String("tokens", "", "path to the file which contains additional tokens to evenly distribute, each row is in format SYMBOL,QUANTITY,DECIMALS. BNB does not have to be in this file, it's distributed by default")
 	flag.Parse()
 
+	networkID, err := consensus.NetworkByName(*network)
+	if err != nil {
+		panic(err)
+	}
+
 	var additionalTokens []dex.TokenInfo
 	if *additionalTokenPath != "" {
 		b, err := ioutil.ReadFile(*additionalTokenPath)
@@ -143,6 +123,11 @@ func main() {
 		return
 	}
 
+	if *governor >= len(owners) {
+		fmt.Printf("governor index %d is out of range, number of credentials: %d\n", *governor, len(owners))
+		return
+	}
+
 	rand := consensus.Rand(consensus.SHA3([]byte(*seed)))
 	nodeDir := path.Join(*outDir, "nodes")
 
@@ -151,73 +136,24 @@ func main() {
 		panic(err)
 	}
 
-	var sysTxns []consensus.SysTxn
-	nodePKs := make([]consensus.PK, *numNode)
-	nodes := make([]consensus.NodeCredentials, *numNode)
-	for i := 0; i < *numNode; i++ {
-		sk := rand.SK()
-		nodePKs[i] = sk.MustPK()
-		nodes[i].SK = sk
-		rand = rand.Derive(rand[:])
-
-		txn := consensus.ReadyJoinGroupTxn{
-			ID: i,
-			PK: nodePKs[i],
-		}
-		sysTxns = append(sysTxns, consensus.SysTxn{
-			Type: consensus.ReadyJoinGroup,
-			Data: gobEncode(txn),
-		})
-	}
-
-	groupIDs := make([]int, *numGroup)
-	for i := range groupIDs {
-		idxs := make([]int, *groupSize)
-		for j := range idxs {
-			idxs[j] = (i**groupSize + j) % *numNode
-		}
-		idVec := make([]bls.ID, *groupSize)
-		for i := range idVec {
-			pk := nodePKs[idxs[i]]
-			idVec[i] = pk.Addr().ID()
-		}
-		var groupPK bls.PublicKey
-		var shares []bls.SecretKey
-		groupPK, shares, rand = makeShares(*threshold, idVec, rand)
-		groupIDs[i] = i
-
-		memberVVec := make([]consensus.PK, len(shares))
-		for i := range memberVVec {
-			memberVVec[i] = shares[i].GetPublicKey().Serialize()
-		}
-
-		for j := range shares {
-			nodes[idxs[j]].GroupShares = append(nodes[idxs[j]].GroupShares, shares[j].GetLittleEndian())
-			nodes[idxs[j]].Groups = append(nodes[idxs[j]].Groups, i)
-		}
-
-		txn := consensus.RegGroupTxn{
-			ID:         i,
-			PK:         consensus.PK(groupPK.Serialize()),
-			MemberIDs:  idxs,
-			MemberVVec: memberVVec,
-		}
-		sysTxns = append(sysTxns, consensus.SysTxn{
-			Type: consensus.RegGroup,
-			Data: gobEncode(txn),
-		})
+	if *bridgeGroup >= *numGroup {
+		fmt.Printf("bridge group index %d is out of range, number of groups: %d\n", *bridgeGroup, *numGroup)
+		return
 	}
 
-	l := consensus.ListGroupsTxn{
-		GroupIDs: groupIDs,
+	groups := consensus.MakeGenesisGroups(*numNode, *numGroup, *groupSize, *threshold, rand)
+	var bridgeGroupPK consensus.PK
+	if *bridgeGroup >= 0 {
+		bridgeGroupPK = groups.GroupPKs[*bridgeGroup]
 	}
 
-	sysTxns = append(sysTxns, consensus.SysTxn{
-		Type: consensus.ListGroups,
-		Data: gobEncode(l),
-	})
-
 	state := dex.CreateGenesisState(owners, additionalTokens)
+	if bridgeGroupPK != nil {
+		state.UpdateBridgeGroup(bridgeGroupPK)
+	}
+	if *governor >= 0 {
+		state.UpdateGovernor(owners[*governor].Addr())
+	}
 	stateBlob, err := state.Serialize()
 	if err != nil {
 		panic(err)
@@ -225,11 +161,12 @@ func main() {
 
 	genesisBlock := consensus.Block{
 		StateRoot: state.Hash(),
-		SysTxns:   sysTxns,
+		SysTxns:   groups.SysTxns,
 	}
 	genesis := consensus.Genesis{
-		Block: genesisBlock,
-		State: stateBlob,
+		NetworkID: networkID,
+		Block:     genesisBlock,
+		State:     stateBlob,
 	}
 	f, err := os.Create(path.Join(*outDir, "genesis.gob"))
 	if err != nil {
@@ -243,7 +180,7 @@ func main() {
 		panic(err)
 	}
 
-	for i, n := range nodes {
+	for i, n := range groups.Nodes {
 		f, err := os.Create(fmt.Sprintf("%s/node-%d", nodeDir, i))
 		if err != nil {
 			panic(err)
@@ -261,13 +198,3 @@ func main() {
 		}
 	}
 }
-
-func gobEncode(v interface{}) []byte {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(v)
-	if err != nil {
-		panic(err)
-	}
-	return buf.Bytes()
-}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/helinwang/dex/pkg/dex"
+)
+
+func main() {
+	auditPath := flag.String("audit-log", "", "path to the audit log written by the node with -audit-log")
+	format := flag.String("format", dex.LedgerCSV, "output format, csv or beancount")
+	from := flag.Uint64("from", 0, "the first round to export")
+	to := flag.Uint64("to", 0, "the last round to export, 0 means no limit")
+	rpcAddr := flag.String("rpc-addr", "", "the node's wallet RPC address to get the token symbols, the token IDs are used if empty")
+	date := flag.String("date", time.Now().Format("2006-01-02"), "the date of the Beancount txns, the audit log has no time")
+	flag.Parse()
+
+	err := export(*auditPath, *format, *from, *to, *rpcAddr, *date)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func export(auditPath, format string, from, to uint64, rpcAddr, date string) error {
+	if auditPath == "" {
+		return fmt.Errorf("please specify the audit log with -audit-log")
+	}
+
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return fmt.Errorf("parse date error: %v", err)
+	}
+
+	f, err := os.Open(auditPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries, err := dex.ReadAuditLog(f)
+	if err != nil {
+		return err
+	}
+
+	var inRange []dex.AuditEntry
+	for _, e := range entries {
+		if e.Round >= from && (to == 0 || e.Round <= to) {
+			inRange = append(inRange, e)
+		}
+	}
+
+	symbols := make(map[dex.TokenID]dex.TokenSymbol)
+	if rpcAddr != "" {
+		client, err := dex.DialRPC(rpcAddr, nil)
+		if err != nil {
+			return err
+		}
+
+		var state dex.TokenState
+		err = client.Call("WalletService.Tokens", 0, &state)
+		if err != nil {
+			return err
+		}
+
+		for _, t := range state.Tokens {
+			symbols[t.ID] = t.Symbol
+		}
+	}
+
+	txns := dex.LedgerTxns(inRange)
+	switch format {
+	case dex.LedgerCSV:
+		return dex.WriteLedgerCSV(os.Stdout, txns, symbols)
+	case dex.LedgerBeancount:
+		return dex.WriteBeancount(os.Stdout, txns, symbols, day)
+	default:
+		return fmt.Errorf("unknown format: %s", format)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/helinwang/dex/pkg/dex"
+)
+
+const maxCPUProfileDur = time.Minute
+
+// newDebugMux returns the handler of the operator debug endpoint. It
+// does not use net/http/pprof, which registers the profiles on the
+// default mux, exposing them on any server that falls back to it.
+func newDebugMux(n *consensus.Node, surveillance *dex.Surveillance) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/debug/pprof/")
+		if name == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, p := range pprof.Profiles() {
+				fmt.Fprintf(w, "%d\t%s\n", p.Count(), p.Name())
+			}
+			fmt.Fprintln(w, "\tprofile (CPU, ?seconds=N)")
+			return
+		}
+
+		if name == "profile" {
+			serveCPUProfile(w, r)
+			return
+		}
+
+		p := pprof.Lookup(name)
+		if p == nil {
+			http.Error(w, "unknown profile: "+name, http.StatusNotFound)
+			return
+		}
+
+		// debug=2 dumps the goroutine stacks in the panic
+		// format.
+		debug, _ := strconv.Atoi(r.FormValue("debug"))
+		if debug > 0 {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		p.WriteTo(w, debug)
+	})
+
+	mux.HandleFunc("/debug/consensus", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(n.DebugState())
+	})
+
+	mux.HandleFunc("/debug/committees", func(w http.ResponseWriter, r *http.Request) {
+		round := n.Chain().Round()
+		if v := r.FormValue("round"); v != "" {
+			var err error
+			round, err = strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid round: "+v, http.StatusBadRequest)
+				return
+			}
+		}
+
+		a, err := n.CommitteeAssignment(round)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(a)
+	})
+
+	mux.HandleFunc("/debug/txn_errors", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(dex.TxnErrorCounts())
+	})
+
+	mux.HandleFunc("/debug/surveillance", func(w http.ResponseWriter, r *http.Request) {
+		var minScore uint64
+		if v := r.FormValue("min_score"); v != "" {
+			var err error
+			minScore, err = strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				http.Error(w, "invalid min score: "+v, http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(surveillance.Report(minScore))
+	})
+	return mux
+}
+
+func serveCPUProfile(w http.ResponseWriter, r *http.Request) {
+	dur := 30 * time.Second
+	if s, err := strconv.Atoi(r.FormValue("seconds")); err == nil && s > 0 {
+		dur = time.Duration(s) * time.Second
+	}
+
+	if dur > maxCPUProfileDur {
+		dur = maxCPUProfileDur
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	err := pprof.StartCPUProfile(w)
+	if err != nil {
+		// the -profile-dur profile or another request is
+		// running.
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	select {
+	case <-time.After(dur):
+	case <-r.Context().Done():
+	}
+	pprof.StopCPUProfile()
+}
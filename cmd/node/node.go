@@ -6,6 +6,7 @@ import (
 	"flag"
 	"io/ioutil"
 	"math/rand"
+	"net/http"
 	"os"
 	"runtime/pprof"
 	"time"
@@ -30,10 +31,28 @@ func decodeFromFile(path string, v interface{}) {
 	}
 }
 
-func createNode(c consensus.NodeCredentials, genesis consensus.Genesis, u consensus.Updater, cfg consensus.Config) *consensus.Node {
+func createNode(c consensus.NodeCredentials, genesis consensus.Genesis, server *dex.RPCServer, cfg consensus.Config, auditLog *dex.AuditLog, orderQuota dex.Quota) *consensus.Node {
 	state := dex.NewState(ethdb.NewMemDatabase())
+	state.SetAuditLog(auditLog)
+	pool := dex.NewTxnPool(state)
+	pool.SetConflictHandler(server.ReportTxnConflict)
+	pool.SetOrderRateLimit(orderQuota.Rate, orderQuota.Burst)
 	pk, _ := dex.RandKeyPair()
-	return consensus.MakeNode(c, cfg, genesis, state, dex.NewTxnPool(state), u, pk)
+	return consensus.MakeNode(c, cfg, genesis, state, pool, server, pk)
+}
+
+func createNodeFromSnapshot(c consensus.NodeCredentials, snapshot *consensus.Snapshot, server *dex.RPCServer, cfg consensus.Config, auditLog *dex.AuditLog, orderQuota dex.Quota) *consensus.Node {
+	state := dex.NewState(ethdb.NewMemDatabase())
+	state.SetAuditLog(auditLog)
+	pool := dex.NewTxnPool(state)
+	pool.SetConflictHandler(server.ReportTxnConflict)
+	pool.SetOrderRateLimit(orderQuota.Rate, orderQuota.Burst)
+	pk, _ := dex.RandKeyPair()
+	n, err := consensus.MakeNodeFromSnapshot(c, cfg, snapshot, state, pool, server, pk)
+	if err != nil {
+		panic(err)
+	}
+	return n
 }
 
 func main() {
@@ -43,11 +62,30 @@ func main() {
 	profileDur := flag.Duration("profile-dur", 0, "profile duration")
 	lvl := flag.String("lvl", "info", "log level, possible values: debug, info, warn, error, crit")
 	c := flag.String("c", "./genesis", "path to the node credential file")
+	replica := flag.Bool("replica", false, "run as a read-only replica that follows the chain and serves the wallet RPC, without a credential file and never participating in the consensus")
 	host := flag.String("host", "127.0.0.1", "node address to listen connection on")
 	port := flag.Int("port", 11001, "node address to listen connection on")
 	seedNode := flag.String("seed", "", "seed node address")
 	g := flag.String("genesis", "", "path to the genesis block file")
+	network := flag.String("network", "devnet", "the network to join, possible values: mainnet, testnet, devnet")
+	snapshotPath := flag.String("snapshot", "", "path to the snapshot file to bootstrap the node from instead of the genesis block file")
 	rpcAddr := flag.String("rpc-addr", ":12001", "rpc address used to serve wallet RPC calls")
+	rpcRate := flag.Float64("rpc-rate", 50, "max wallet RPC calls per second of each client IP, 0 means no limit")
+	rpcBurst := flag.Int("rpc-burst", 100, "max burst of the wallet RPC calls of each client IP")
+	orderRate := flag.Float64("order-rate", 0, "max order txns per second of each account accepted into the txn pool, the txns over the limit are not relayed or proposed by the node, 0 means no limit")
+	orderBurst := flag.Int("order-burst", 100, "max burst of the order txns of each account accepted into the txn pool")
+	rpcConns := flag.Int("rpc-conns", 16, "max wallet RPC connections of each client IP, 0 means no limit")
+	depthBuffer := flag.Int("depth-buffer", dex.DefaultDepthBuffer, "number of the recent deltas retained for each depth feed, a subscriber further behind recovers from a snapshot, 0 means the default")
+	hideOwners := flag.Bool("hide-order-owners", false, "hide the owners of the orders and the trades from the wallet RPC")
+	apiKeys := flag.String("api-keys", "", "path to the API key file created by the api_key tool, API keys are disabled if empty")
+	requireKey := flag.Bool("rpc-require-key", false, "reject the wallet RPC clients without an API key")
+	maxBlockBytes := flag.Int("max-block-bytes", 4<<20, "max total size of the txns in a block proposal, 0 means no limit")
+	ntThreshold := flag.Int("nt-threshold", 0, "number of the notarization shares of the distinct notaries needed to notarize a block, between the group signature threshold and the group size, 0 means the group signature threshold")
+	rankEnforcementRound := flag.Uint64("rank-enforcement-round", 0, "round from which the blocks outranked by a notarized block of the same round and parent count as empty blocks in the fork choice, 0 means never")
+	coldDir := flag.String("cold-dir", "", "directory to archive the old finalized blocks in, blocks are kept in memory if empty")
+	auditPath := flag.String("audit-log", "", "path to the append-only audit log of the balance mutations in JSON lines, disabled if empty")
+	debugAddr := flag.String("debug-addr", "", "operator-only address to serve pprof and the consensus debug state on, e.g., 127.0.0.1:6060, disabled if empty")
+	surveillanceWindow := flag.Uint64("surveillance-window", dex.DefaultSurveillanceWindow, "number of the recent blocks that the trades are analyzed over for the wash trading patterns, served on the debug address")
 	flag.Parse()
 
 	if *profileDur > 0 {
@@ -72,36 +110,88 @@ func main() {
 	}
 
 	log15.Root().SetHandler(log15.LvlFilterHandler(l, log15.StdoutHandler))
-	var genesis consensus.Genesis
-	decodeFromFile(*g, &genesis)
-
-	cb, err := ioutil.ReadFile(*c)
+	networkID, err := consensus.NetworkByName(*network)
 	if err != nil {
 		panic(err)
 	}
+	dex.SetNetworkID(networkID)
 
 	var credential consensus.NodeCredentials
-	dec := gob.NewDecoder(bytes.NewReader(cb))
-	err = dec.Decode(&credential)
+	if *replica {
+		credential = consensus.ReplicaCredentials()
+	} else {
+		decodeFromFile(*c, &credential)
+	}
+
+	cfg := consensus.Config{
+		BlockTime:             time.Second,
+		GroupSize:             *groupSize,
+		GroupThreshold:        *threshold,
+		NetworkID:             networkID,
+		ColdStorageDir:        *coldDir,
+		MaxBlockTxnBytes:      *maxBlockBytes,
+		RankEnforcementRound:  *rankEnforcementRound,
+		NotarizationThreshold: *ntThreshold,
+	}
+	err = cfg.Validate()
 	if err != nil {
 		panic(err)
 	}
 
-	cfg := consensus.Config{
-		BlockTime:      time.Second,
-		GroupSize:      *groupSize,
-		GroupThreshold: *threshold,
+	var auditLog *dex.AuditLog
+	if *auditPath != "" {
+		auditLog, err = dex.OpenAuditLog(*auditPath)
+		if err != nil {
+			panic(err)
+		}
 	}
 
+	orderQuota := dex.Quota{Rate: *orderRate, Burst: *orderBurst}
 	server := dex.NewRPCServer()
-	n := createNode(credential, genesis, server, cfg)
+	var n *consensus.Node
+	if *snapshotPath != "" {
+		var snapshot consensus.Snapshot
+		decodeFromFile(*snapshotPath, &snapshot)
+		n = createNodeFromSnapshot(credential, &snapshot, server, cfg, auditLog, orderQuota)
+	} else {
+		var genesis consensus.Genesis
+		decodeFromFile(*g, &genesis)
+		n = createNode(credential, genesis, server, cfg, auditLog, orderQuota)
+	}
 	server.SetSender(n)
 	server.SetStater(n.Chain())
+	server.SetRateLimit(*rpcRate, *rpcBurst, *rpcConns)
+	server.SetDepthBuffer(*depthBuffer)
+	if *hideOwners {
+		server.HideOrderOwners()
+	}
+	if *apiKeys != "" {
+		keys, err := dex.OpenAPIKeyStore(*apiKeys)
+		if err != nil {
+			panic(err)
+		}
+		server.SetAPIKeys(keys, *requireKey)
+	} else if *requireKey {
+		panic("-rpc-require-key requires -api-keys")
+	}
+	var surveillance *dex.Surveillance
+	if *debugAddr != "" {
+		surveillance = server.EnableSurveillance(*surveillanceWindow)
+	}
 	err = server.Start(*rpcAddr)
 	if err != nil {
 		log15.Warn("can not start wallet service", "err", err)
 	}
 
+	if *debugAddr != "" {
+		go func() {
+			err := http.ListenAndServe(*debugAddr, newDebugMux(n, surveillance))
+			if err != nil {
+				log15.Error("error serving debug endpoint", "err", err)
+			}
+		}()
+	}
+
 	err = n.Start(*host, *port, *seedNode)
 	if err != nil {
 		log15.Error("can not connect to seed node", "seed", *seedNode, "err", err)
@@ -109,8 +199,8 @@ func main() {
 	}
 
 	pk := credential.SK.MustPK()
-	log15.Info("node info", "addr", pk.Addr(), "member of groups", credential.Groups)
-	n.EndRound(0)
+	log15.Info("node info", "addr", pk.Addr().Encode(networkID), "replica", n.Replica(), "member of groups", credential.Groups)
+	n.EndRound(n.Chain().FinalizedRound())
 
 	select {}
 }
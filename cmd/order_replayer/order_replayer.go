@@ -91,7 +91,14 @@ func main() {
 	credentialsPath := flag.String("c", "", "path to the directory contains node credentials")
 	orderPath := flag.String("path", "", "path to the order file to replay")
 	addr := flag.String("addr", ":12001", "node's wallet RPC endpoint")
+	network := flag.String("network", "devnet", "the network of the node, possible values: mainnet, testnet, devnet")
 	flag.Parse()
+
+	networkID, err := consensus.NetworkByName(*network)
+	if err != nil {
+		panic(err)
+	}
+	dex.SetNetworkID(networkID)
 	rand.Seed(time.Now().UnixNano())
 
 	client, err := rpc.DialHTTP("tcp", *addr)
@@ -28,10 +28,58 @@ const (
 
 var rpcAddr string
 var credentialPath string
+var networkName string
+var networkID consensus.NetworkID
+var apiKeyID string
+var apiSecret string
+var validFor uint64
+var lane uint
 
+// dial connects to the node's wallet RPC, authenticated by the API
+// key if it's provided.
+func dial() (*rpc.Client, error) {
+	if apiKeyID == "" {
+		return dex.DialRPC(rpcAddr, nil)
+	}
+
+	return dex.DialRPC(rpcAddr, &dex.APIKeyCredential{ID: apiKeyID, Secret: apiSecret})
+}
+
+// withOptions returns the txn signed again in the nonce lane of
+// -lane, to expire validFor rounds after the current round if
+// -valid-for is set.
+func withOptions(client *rpc.Client, sk dex.SK, txn []byte) ([]byte, error) {
+	if lane == 0 && validFor == 0 {
+		return txn, nil
+	}
+
+	opts := dex.TxnOptions{Lane: uint8(lane)}
+	if validFor > 0 {
+		var round uint64
+		err := client.Call("WalletService.Round", 0, &round)
+		if err != nil {
+			return nil, err
+		}
+		opts.ValidUntil = round + validFor
+	}
+
+	return dex.WithOptions(sk, txn, opts)
+}
+
+func sendTxn(client *rpc.Client, sk dex.SK, txn []byte) error {
+	txn, err := withOptions(client, sk, txn)
+	if err != nil {
+		return err
+	}
+
+	return client.Call("WalletService.SendTxn", txn, nil)
+}
+
+// nonce returns the nonce of the next txn of the account in the
+// nonce lane of -lane.
 func nonce(client *rpc.Client, addr consensus.Addr) (uint64, error) {
 	var nonce uint64
-	err := client.Call("WalletService.Nonce", addr, &nonce)
+	err := client.Call("WalletService.LaneNonce", dex.LaneNonceArgs{Addr: addr, Lane: uint8(lane)}, &nonce)
 	if err != nil {
 		return 0, err
 	}
@@ -40,8 +88,7 @@ func nonce(client *rpc.Client, addr consensus.Addr) (uint64, error) {
 }
 
 func getTokens(client *rpc.Client) ([]dex.Token, error) {
-	var tokens dex.TokenState
-	err := client.Call("WalletService.Tokens", 0, &tokens)
+	tokens, err := getTokenState(client)
 	if err != nil {
 		return nil, err
 	}
@@ -49,14 +96,37 @@ func getTokens(client *rpc.Client) ([]dex.Token, error) {
 	return tokens.Tokens, nil
 }
 
-func parseAddr(accountAddr string) (consensus.Addr, error) {
-	var addr consensus.Addr
-	b, err := hex.DecodeString(accountAddr)
-	if err != nil {
-		return addr, err
+func getTokenState(client *rpc.Client) (dex.TokenState, error) {
+	var tokens dex.TokenState
+	err := client.Call("WalletService.Tokens", 0, &tokens)
+	return tokens, err
+}
+
+// issuerStr describes the issuer of the token, the tokens issued by a
+// user without the governor's attestation could be impostors.
+func issuerStr(tokens dex.TokenState, id dex.TokenID) string {
+	if v, ok := tokens.Verified[id]; ok {
+		return v.Name
+	}
+
+	if _, ok := tokens.Issuers[id]; ok {
+		return "UNVERIFIED"
+	}
+
+	return "genesis"
+}
+
+// statusStr describes whether the markets of the token are open.
+func statusStr(tokens dex.TokenState, id dex.TokenID) string {
+	if d, ok := tokens.Delisted[id]; ok {
+		return fmt.Sprintf("delisted, retires at round %d", d.RetireRound)
 	}
-	copy(addr[:], b)
-	return addr, nil
+
+	if tokens.Restricted[id] {
+		return "restricted"
+	}
+
+	return "listed"
 }
 
 func frozenToStr(fs []dex.Frozen, decimals int) string {
@@ -68,6 +138,21 @@ func frozenToStr(fs []dex.Frozen, decimals int) string {
 	return strings.Join(strs, ",")
 }
 
+// parseAddr parses the address of the network (e.g., ddex1...), or
+// the base64 encoded public key.
+func parseAddr(str string) (consensus.Addr, error) {
+	if strings.HasPrefix(strings.ToLower(str), networkID.AddrPrefix()+"1") {
+		return consensus.DecodeAddr(networkID, str)
+	}
+
+	pkStr, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return consensus.Addr{}, fmt.Errorf("%s is neither an address of network %v (%s1...) nor a base64 encoded public key", str, networkID, networkID.AddrPrefix())
+	}
+
+	return consensus.PK(pkStr).Addr(), nil
+}
+
 func printAccount(c *cli.Context) error {
 	var addr consensus.Addr
 	accountAddr := c.Args().First()
@@ -80,23 +165,13 @@ func printAccount(c *cli.Context) error {
 		addr = c.PK.Addr()
 	} else {
 		var err error
-		if len(accountAddr) == len(consensus.ZeroAddr.Hex()) {
-			addr, err = parseAddr(accountAddr)
-			if err != nil {
-				return err
-			}
-		} else {
-			pkStr, err := base64.StdEncoding.DecodeString(accountAddr)
-			if err != nil {
-				return err
-			}
-
-			pk := consensus.PK(pkStr)
-			addr = pk.Addr()
+		addr, err = parseAddr(accountAddr)
+		if err != nil {
+			return err
 		}
 	}
 
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
@@ -117,7 +192,7 @@ func printAccount(c *cli.Context) error {
 		return err
 	}
 
-	fmt.Printf("Addr:\n%x\n", addr[:])
+	fmt.Printf("Addr:\n%s\n", addr.Encode(networkID))
 	fmt.Println("\nBalances:")
 	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
 	_, err = fmt.Fprintln(tw, "\tSymbol\tAvailable\tPending\tFrozen\t")
@@ -242,23 +317,27 @@ func sendToken(c *cli.Context) error {
 
 	recipient := args[0]
 	symbol := args[1]
-	b, err := base64.StdEncoding.DecodeString(recipient)
-	if err != nil {
-		return fmt.Errorf("PUB_KEY (%s) must be encoded in base64, err: %v", recipient, err)
+	var pk dex.PK
+	depositAddr, depositErr := dex.DecodeDepositAddr(networkID, recipient)
+	if depositErr != nil {
+		b, err := base64.StdEncoding.DecodeString(recipient)
+		if err != nil {
+			return fmt.Errorf("recipient (%s) must be a base64 encoded PUB_KEY or a deposit address, err: %v", recipient, err)
+		}
+		pk = dex.PK(b)
 	}
 
-	pk := dex.PK(b)
 	quant, err := strconv.ParseFloat(args[2], 64)
 	if err != nil {
 		return err
 	}
 
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
 
-	tokens, err := getTokens(client)
+	tokens, err := getTokenState(client)
 	if err != nil {
 		return err
 	}
@@ -266,7 +345,7 @@ func sendToken(c *cli.Context) error {
 	var tokenID dex.TokenID
 	var mul float64
 	found := false
-	for _, t := range tokens {
+	for _, t := range tokens.Tokens {
 		if strings.ToLower(string(t.Symbol)) == strings.ToLower(symbol) {
 			tokenID = t.ID
 			mul = math.Pow10(int(t.Decimals))
@@ -279,13 +358,23 @@ func sendToken(c *cli.Context) error {
 		return fmt.Errorf("symbol not found: %s", symbol)
 	}
 
+	if issuerStr(tokens, tokenID) == "UNVERIFIED" {
+		fmt.Printf("warning: the issuer of %s is not verified, it could be an impostor token\n", symbol)
+	}
+
 	n, err := nonce(client, credential.PK.Addr())
 	if err != nil {
 		return err
 	}
 
-	txn := dex.MakeSendTokenTxn(credential.SK, credential.PK.Addr(), pk, tokenID, uint64(quant*mul), n)
-	err = client.Call("WalletService.SendTxn", txn, nil)
+	var txn []byte
+	if depositErr == nil {
+		t := dex.SendToDepositTxn{TokenID: tokenID, To: depositAddr, Quant: uint64(quant * mul)}
+		txn = dex.MakeSendToDepositTxn(credential.SK, credential.PK.Addr(), t, n)
+	} else {
+		txn = dex.MakeSendTokenTxn(credential.SK, credential.PK.Addr(), pk, tokenID, uint64(quant*mul), n)
+	}
+	err = sendTxn(client, credential.SK, txn)
 	if err != nil {
 		return err
 	}
@@ -294,26 +383,26 @@ func sendToken(c *cli.Context) error {
 }
 
 func listToken(c *cli.Context) error {
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
 
-	tokens, err := getTokens(client)
+	tokens, err := getTokenState(client)
 	if err != nil {
 		return err
 	}
 
 	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.AlignRight|tabwriter.Debug)
-	_, err = fmt.Fprintln(tw, "\tSymbol\tTotal Supply\tDecimals\t")
+	_, err = fmt.Fprintln(tw, "\tSymbol\tTotal Supply\tDecimals\tIssuer\tStatus\t")
 	if err != nil {
 		return err
 	}
 
-	for _, t := range tokens {
+	for _, t := range tokens.Tokens {
 		decimals := int(t.Decimals)
 		supply := quantToStr(t.TotalUnits, decimals)
-		_, err = fmt.Fprintf(tw, "\t%s\t%s\t%d\t\n", string(t.Symbol), supply, decimals)
+		_, err = fmt.Fprintf(tw, "\t%s\t%s\t%d\t%s\t%s\t\n", string(t.Symbol), supply, decimals, issuerStr(tokens, t.ID), statusStr(tokens, t.ID))
 		if err != nil {
 			return err
 		}
@@ -326,8 +415,192 @@ func listToken(c *cli.Context) error {
 	return nil
 }
 
+func listTicker(c *cli.Context) error {
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	idToToken := make(map[dex.TokenID]dex.TokenInfo)
+	for _, t := range tokens {
+		idToToken[t.ID] = t.TokenInfo
+	}
+
+	var state dex.TickerState
+	err = client.Call("WalletService.Tickers", 0, &state)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.AlignRight|tabwriter.Debug)
+	_, err = fmt.Fprintln(tw, "\tMarket\tLast\t24h High\t24h Low\t24h Volume\tBest Bid\tBest Ask\t")
+	if err != nil {
+		return err
+	}
+
+	price := func(p uint64) string {
+		return quantToStr(p, dex.OrderPriceDecimals)
+	}
+
+	for _, t := range state.Tickers {
+		base := idToToken[t.Market.Base]
+		market := base.Symbol + "_" + idToToken[t.Market.Quote].Symbol
+		volume := quantToStr(t.Volume, int(base.Decimals))
+		_, err = fmt.Fprintf(tw, "\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", market, price(t.Last), price(t.High), price(t.Low), volume, price(t.BestBid), price(t.BestAsk))
+		if err != nil {
+			return err
+		}
+	}
+	err = tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func streamAccount(c *cli.Context) error {
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	idToToken := make(map[dex.TokenID]dex.TokenInfo)
+	for _, t := range tokens {
+		idToToken[t.ID] = t.TokenInfo
+	}
+
+	addr := credential.PK.Addr()
+	var challenge []byte
+	err = client.Call("WalletService.AccountChallenge", addr, &challenge)
+	if err != nil {
+		return err
+	}
+
+	var session string
+	args := dex.SubscribeAccountArgs{Addr: addr, Challenge: challenge, Sig: credential.SK.Sign(dex.AccountStreamMsg(challenge))}
+	err = client.Call("WalletService.SubscribeAccount", args, &session)
+	if err != nil {
+		return err
+	}
+
+	var after uint64
+	for {
+		var events dex.AccountEvents
+		err = client.Call("WalletService.PollAccount", dex.PollAccountArgs{Session: session, After: after, Wait: dex.MaxAccountPollWait}, &events)
+		if err != nil {
+			return err
+		}
+
+		if events.Gap {
+			fmt.Println("missed events, please check the account using ./wallet account")
+		}
+
+		for _, e := range events.Events {
+			after = e.Seq
+			switch e.Type {
+			case dex.OrderAckEvent, dex.OrderClosedEvent:
+				market := idToToken[e.Order.ID.Market.Base].Symbol + "_" + idToToken[e.Order.ID.Market.Quote].Symbol
+				quant := quantToStr(e.Order.Quant, int(idToToken[e.Order.ID.Market.Base].Decimals))
+				fmt.Printf("%s: order %d %s sell: %t price: %s amount: %s\n", e.Type, e.Order.ID.ID, market, e.Order.SellSide, quantToStr(e.Order.Price, dex.OrderPriceDecimals), quant)
+			case dex.FillEvent:
+				market := idToToken[e.Fill.ID.Market.Base].Symbol + "_" + idToToken[e.Fill.ID.Market.Quote].Symbol
+				quant := quantToStr(e.Fill.Quant, int(idToToken[e.Fill.ID.Market.Base].Decimals))
+				fmt.Printf("%s: order %d %s block: %d price: %s amount: %s\n", e.Type, e.Fill.ID.ID, market, e.Fill.Round, quantToStr(e.Fill.TradePrice, dex.OrderPriceDecimals), quant)
+			case dex.BalanceEvent:
+				decimals := int(idToToken[e.Token].Decimals)
+				fmt.Printf("%s: %s available: %s pending: %s frozen: %s\n", e.Type, idToToken[e.Token].Symbol, quantToStr(e.Balance.Available, decimals), quantToStr(e.Balance.Pending, decimals), frozenToStr(e.Balance.Frozen, decimals))
+			case dex.DepositEvent:
+				info := idToToken[e.Deposit.TokenID]
+				fmt.Printf("%s: index: %d %s %s from: %s\n", e.Type, e.Deposit.Index, quantToStr(e.Deposit.Quant, int(info.Decimals)), info.Symbol, e.Deposit.From.Encode(networkID))
+			case dex.ConflictEvent:
+				fmt.Printf("%s: two different txns of lane %d nonce %d: %x and %x, the key may be compromised, consider the kill switch\n", e.Type, e.Conflict.Lane, e.Conflict.Nonce, e.Conflict.First[:], e.Conflict.Second[:])
+			}
+		}
+	}
+}
+
+func txnProof(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 1 {
+		return fmt.Errorf("txn_proof needs 1 argument (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	b, err := hex.DecodeString(args[0])
+	if err != nil || len(b) != len(consensus.Hash{}) {
+		return fmt.Errorf("invalid txn hash: %s", args[0])
+	}
+
+	var h consensus.Hash
+	copy(h[:], b)
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	var proof consensus.TxnProof
+	err = client.Call("WalletService.TxnProof", h, &proof)
+	if err != nil {
+		return err
+	}
+
+	if proof.Txn != h || !proof.Verify() {
+		return fmt.Errorf("invalid proof of txn %s from the node", h)
+	}
+
+	fmt.Printf("txn %s is included in block %d %s, finalized: %t\n", h, proof.Header.Round, proof.Block, proof.Finalized)
+	fmt.Printf("txn root: %s, txn index: %d, proof length: %d\n", proof.Header.TxnRoot, proof.Index, len(proof.Path))
+	return nil
+}
+
+func watchHeads(c *cli.Context) error {
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	var after uint64
+	for {
+		var events dex.HeadEvents
+		err = client.Call("WalletService.HeadEvents", dex.HeadEventsArgs{After: after, Wait: dex.MaxHeadPollWait}, &events)
+		if err != nil {
+			return err
+		}
+
+		if events.Gap {
+			fmt.Println("missed events, the unfinalized blocks seen before may be orphaned")
+		}
+
+		for _, e := range events.Events {
+			after = e.Seq
+			switch e.Type {
+			case consensus.ReorgEvent:
+				fmt.Printf("%s: block: %d old tip: %s new tip: %s common ancestor: %d %s\n", e.Type, e.Round, e.OldTip, e.Block, e.AncestorRound, e.Ancestor)
+			default:
+				fmt.Printf("%s: block: %d %s\n", e.Type, e.Round, e.Block)
+			}
+		}
+	}
+}
+
 func printStatus(c *cli.Context) error {
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
@@ -423,6 +696,11 @@ func issueToken(c *cli.Context) error {
 	}
 
 	symbol := args[0]
+	err := dex.ValidateSymbol(dex.TokenSymbol(symbol))
+	if err != nil {
+		return err
+	}
+
 	supply, err := strconv.ParseUint(args[1], 10, 64)
 	if err != nil {
 		return err
@@ -435,7 +713,7 @@ func issueToken(c *cli.Context) error {
 
 	units := supply * uint64(math.Pow10(int(decimals)))
 
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
@@ -448,8 +726,8 @@ func issueToken(c *cli.Context) error {
 	for _, t := range tokens {
 		// do client side check to provide a better error
 		// message (block chain still checks).
-		if strings.ToLower(symbol) == strings.ToLower(string(t.Symbol)) {
-			return fmt.Errorf("token symbol %s already exists", symbol)
+		if dex.SymbolConflicts(dex.TokenSymbol(symbol), t.Symbol) {
+			return fmt.Errorf("token symbol %s conflicts with the existing token %s", symbol, t.Symbol)
 		}
 	}
 
@@ -470,7 +748,7 @@ func issueToken(c *cli.Context) error {
 	}
 
 	txn := dex.MakeIssueTokenTxn(credential.SK, credential.PK.Addr(), tokenInfo, n)
-	err = client.Call("WalletService.SendTxn", txn, nil)
+	err = sendTxn(client, credential.SK, txn)
 	if err != nil {
 		return err
 	}
@@ -489,7 +767,7 @@ func chainStatus(client *rpc.Client) (consensus.ChainStatus, error) {
 }
 
 func printGraphviz(c *cli.Context) error {
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
@@ -504,6 +782,39 @@ func printGraphviz(c *cli.Context) error {
 	return nil
 }
 
+func exportSnapshot(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 1 {
+		return fmt.Errorf("export snapshot needs 1 argument (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	var snapshot consensus.Snapshot
+	err = client.Call("WalletService.Snapshot", 0, &snapshot)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err = enc.Encode(snapshot)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(args[0], buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("exported the snapshot of round %d to %s\n", snapshot.Block.Round, args[0])
+	return nil
+}
+
 func burnToken(c *cli.Context) error {
 	args := c.Args()
 	if len(args) < 2 {
@@ -521,7 +832,7 @@ func burnToken(c *cli.Context) error {
 		return fmt.Errorf("error parse freeze token amount: %v", err)
 	}
 
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
@@ -554,7 +865,7 @@ func burnToken(c *cli.Context) error {
 
 	t := dex.BurnTokenTxn{ID: tokenID, Quant: uint64(quant * mul)}
 	txn := dex.MakeBurnTokenTxn(credential.SK, credential.PK.Addr(), t, n)
-	err = client.Call("WalletService.SendTxn", txn, nil)
+	err = sendTxn(client, credential.SK, txn)
 	if err != nil {
 		return err
 	}
@@ -562,10 +873,10 @@ func burnToken(c *cli.Context) error {
 	return nil
 }
 
-func freezeToken(c *cli.Context) error {
+func verifyIssuer(c *cli.Context) error {
 	args := c.Args()
-	if len(args) < 3 {
-		return fmt.Errorf("freeze token needs 3 arguments (received: %d), please check usage using ./wallet -h", len(args))
+	if len(args) < 2 && !(len(args) == 1 && c.Bool("revoke")) {
+		return fmt.Errorf("verify issuer needs 2 arguments (received: %d), please check usage using ./wallet -h", len(args))
 	}
 
 	credential, err := loadCredential(credentialPath)
@@ -573,17 +884,7 @@ func freezeToken(c *cli.Context) error {
 		return err
 	}
 
-	symbol := args[0]
-	quant, err := strconv.ParseFloat(args[1], 64)
-	if err != nil {
-		return fmt.Errorf("error parse freeze token amount: %v", err)
-	}
-	availableHeight, err := strconv.ParseUint(args[2], 10, 64)
-	if err != nil {
-		return fmt.Errorf("error parse freeze token available height: %v", err)
-	}
-
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
@@ -593,13 +894,12 @@ func freezeToken(c *cli.Context) error {
 		return err
 	}
 
+	symbol := args[0]
 	var tokenID dex.TokenID
-	var mul float64
 	found := false
 	for _, t := range tokens {
 		if strings.ToLower(string(t.Symbol)) == strings.ToLower(symbol) {
 			tokenID = t.ID
-			mul = math.Pow10(int(t.Decimals))
 			found = true
 			break
 		}
@@ -614,22 +914,20 @@ func freezeToken(c *cli.Context) error {
 		return err
 	}
 
-	t := dex.FreezeTokenTxn{TokenID: tokenID, AvailableRound: availableHeight, Quant: uint64(quant * mul)}
-	txn := dex.MakeFreezeTokenTxn(credential.SK, credential.PK.Addr(), t, n)
-	err = client.Call("WalletService.SendTxn", txn, nil)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	t := dex.VerifyIssuerTxn{Token: tokenID, Name: strings.Join(args[1:], " "), Revoke: c.Bool("revoke")}
+	txn := dex.MakeVerifyIssuerTxn(credential.SK, credential.PK.Addr(), t, n)
+	return sendTxn(client, credential.SK, txn)
 }
 
-func cancelOrder(c *cli.Context) error {
-	orderID := c.Args().First()
-	var id dex.OrderID
-	err := id.Decode(orderID)
+func delist(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 2 {
+		return fmt.Errorf("delist needs 2 arguments (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	retireRound, err := strconv.ParseUint(args[1], 10, 64)
 	if err != nil {
-		return err
+		return fmt.Errorf("error parse retire round: %v", err)
 	}
 
 	credential, err := loadCredential(credentialPath)
@@ -637,28 +935,1460 @@ func cancelOrder(c *cli.Context) error {
 		return err
 	}
 
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
 
-	n, err := nonce(client, credential.PK.Addr())
+	tokens, err := getTokens(client)
 	if err != nil {
 		return err
 	}
 
-	txn := dex.MakeCancelOrderTxn(credential.SK, credential.PK.Addr(), id, n)
-	err = client.Call("WalletService.SendTxn", txn, nil)
+	symbol := args[0]
+	var tokenID dex.TokenID
+	found := false
+	for _, t := range tokens {
+		if strings.ToLower(string(t.Symbol)) == strings.ToLower(symbol) {
+			tokenID = t.ID
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("symbol not found: %s", symbol)
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
 	if err != nil {
 		return err
 	}
 
-	return nil
+	t := dex.DelistTxn{Token: tokenID, RetireRound: retireRound}
+	txn := dex.MakeDelistTxn(credential.SK, credential.PK.Addr(), t, n)
+	return sendTxn(client, credential.SK, txn)
 }
 
-func placeOrder(c *cli.Context) error {
-	args := c.Args()
-	if len(args) < 5 {
+func blockAddress(c *cli.Context) error {
+	str := c.Args().First()
+	if str == "" {
+		return fmt.Errorf("block_address needs 1 argument, please check usage using ./wallet -h")
+	}
+
+	addr, err := parseAddr(str)
+	if err != nil {
+		return err
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	t := dex.BlockAddressTxn{Addr: addr, Block: !c.Bool("unblock"), Reason: c.String("reason")}
+	txn := dex.MakeBlockAddressTxn(credential.SK, credential.PK.Addr(), t, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+func bustTrade(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 3 {
+		return fmt.Errorf("bust needs 3 arguments (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	round, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("error parse round: %v", err)
+	}
+
+	idx, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		return fmt.Errorf("error parse trade index: %v", err)
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	t := dex.BustTradeTxn{Round: round, Index: uint32(idx), Justification: strings.Join(args[2:], " ")}
+	txn := dex.MakeBustTradeTxn(credential.SK, credential.PK.Addr(), t, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+func registerValidator(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 2 {
+		return fmt.Errorf("register_validator needs 2 arguments (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	node, err := consensus.LoadCredential(args[0])
+	if err != nil {
+		return fmt.Errorf("load node credential error: %v", err)
+	}
+
+	bond, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return fmt.Errorf("parse bond error: %v", err)
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	var native *dex.Token
+	for i := range tokens {
+		if tokens[i].ID == 0 {
+			native = &tokens[i]
+			break
+		}
+	}
+
+	if native == nil {
+		return fmt.Errorf("native token not found")
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	pk := node.SK.MustPK()
+	owner := credential.PK.Addr()
+	t := dex.RegisterValidatorTxn{
+		PK:   pk,
+		Bond: uint64(bond * math.Pow10(int(native.Decimals))),
+		PoP:  node.SK.Sign(dex.ValidatorPoPMsg(owner, pk)),
+	}
+	txn := dex.MakeRegisterValidatorTxn(credential.SK, owner, t, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+func printValidatorQueue(c *cli.Context) error {
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	var queue []dex.ValidatorRegistration
+	err = client.Call("WalletService.ValidatorQueue", 0, &queue)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+	fmt.Fprintln(w, "Owner\tNode\tBond\tBlock\tEpoch\t")
+	for _, r := range queue {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t\n", r.Owner.Encode(networkID), r.PK.Addr(), r.Bond, r.Round, r.Epoch)
+	}
+	return w.Flush()
+}
+
+func verifyBooks(c *cli.Context) error {
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	idToToken := make(map[dex.TokenID]dex.TokenInfo)
+	for _, t := range tokens {
+		idToToken[t.ID] = t.TokenInfo
+	}
+
+	var checks []dex.BookCheck
+	err = client.Call("WalletService.VerifyBooks", 0, &checks)
+	if err != nil {
+		return err
+	}
+
+	corrupted := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+	fmt.Fprintln(w, "Market\tDigest\tStatus\t")
+	for _, r := range checks {
+		base, quote := idToToken[r.Market.Base], idToToken[r.Market.Quote]
+		status := "ok"
+		if r.Err != "" {
+			status = r.Err
+			corrupted++
+		}
+		fmt.Fprintf(w, "%s_%s\t%x\t%s\t\n", base.Symbol, quote.Symbol, r.Committed[:8], status)
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if corrupted > 0 {
+		return fmt.Errorf("%d of %d order books are corrupted", corrupted, len(checks))
+	}
+	return nil
+}
+
+func printTradeRecords(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 1 {
+		return fmt.Errorf("trades needs 1 argument (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	round, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("error parse round: %v", err)
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	idToToken := make(map[dex.TokenID]dex.TokenInfo)
+	for _, t := range tokens {
+		idToToken[t.ID] = t.TokenInfo
+	}
+
+	var records []dex.TradeRecord
+	err = client.Call("WalletService.TradeRecords", dex.TradeRecordsArgs{Round: round}, &records)
+	if err != nil {
+		return err
+	}
+
+	for i, r := range records {
+		base, quote := idToToken[r.Market.Base], idToToken[r.Market.Quote]
+		fmt.Printf("%d: %s_%s price: %s amount: %s buyer: %s seller: %s\n", i, base.Symbol, quote.Symbol, quantToStr(r.Price, dex.OrderPriceDecimals), quantToStr(r.Quant, int(base.Decimals)), r.Buyer.Encode(networkID), r.Seller.Encode(networkID))
+		if r.Busted {
+			fmt.Printf("   busted at block %d: %s\n", r.BustRound, r.Justification)
+		}
+	}
+	return nil
+}
+
+func tokenWhitelist(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 1 {
+		return fmt.Errorf("whitelist needs at least 1 argument (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	addrs := make([]consensus.Addr, len(args)-1)
+	for i, str := range args[1:] {
+		addr, err := parseAddr(str)
+		if err != nil {
+			return err
+		}
+		addrs[i] = addr
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokenState(client)
+	if err != nil {
+		return err
+	}
+
+	symbol := args[0]
+	var tokenID dex.TokenID
+	found := false
+	for _, t := range tokens.Tokens {
+		if strings.ToLower(string(t.Symbol)) == strings.ToLower(symbol) {
+			tokenID = t.ID
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("symbol not found: %s", symbol)
+	}
+
+	t := dex.TokenWhitelistTxn{Token: tokenID, Restricted: tokens.Restricted[tokenID]}
+	switch c.String("restrict") {
+	case "":
+	case "on":
+		t.Restricted = true
+	case "off":
+		t.Restricted = false
+	default:
+		return fmt.Errorf("unknown restrict value: %s, possible values: on, off", c.String("restrict"))
+	}
+
+	if c.Bool("revoke") {
+		t.Revoke = addrs
+	} else {
+		t.Approve = addrs
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	txn := dex.MakeTokenWhitelistTxn(credential.SK, credential.PK.Addr(), t, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+func setGuardian(c *cli.Context) error {
+	var guardian consensus.Addr
+	if !c.Bool("remove") {
+		str := c.Args().First()
+		if str == "" {
+			return fmt.Errorf("guardian needs 1 argument, please check usage using ./wallet -h")
+		}
+
+		addr, err := parseAddr(str)
+		if err != nil {
+			return err
+		}
+		guardian = addr
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	txn := dex.MakeSetGuardianTxn(credential.SK, credential.PK.Addr(), dex.SetGuardianTxn{Guardian: guardian}, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+func setAllowList(c *cli.Context) error {
+	list := dex.AllowList{Enabled: !c.Bool("disable")}
+	if list.Enabled {
+		for _, str := range c.Args() {
+			addr, err := parseAddr(str)
+			if err != nil {
+				return err
+			}
+			list.Addrs = append(list.Addrs, addr)
+		}
+
+		sort.Slice(list.Addrs, func(i, j int) bool {
+			return bytes.Compare(list.Addrs[i][:], list.Addrs[j][:]) < 0
+		})
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	txn := dex.MakeSetAllowListTxn(credential.SK, credential.PK.Addr(), dex.SetAllowListTxn{List: list}, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+func setTransferThreshold(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 2 {
+		return fmt.Errorf("transfer_threshold needs 2 arguments (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	threshold, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return err
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	token, err := findToken(tokens, args[0])
+	if err != nil {
+		return err
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	t := dex.SetTransferThresholdTxn{
+		TokenID:   token.ID,
+		Threshold: uint64(threshold * math.Pow10(int(token.Decimals))),
+	}
+	txn := dex.MakeSetTransferThresholdTxn(credential.SK, credential.PK.Addr(), t, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+func announceTransfer(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 3 {
+		return fmt.Errorf("announce_transfer needs 3 arguments (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	b, err := base64.StdEncoding.DecodeString(args[0])
+	if err != nil {
+		return fmt.Errorf("recipient (%s) must be a base64 encoded PUB_KEY, err: %v", args[0], err)
+	}
+
+	quant, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return err
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	token, err := findToken(tokens, args[1])
+	if err != nil {
+		return err
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	t := dex.AnnounceTransferTxn{
+		TokenID: token.ID,
+		To:      dex.PK(b),
+		Quant:   uint64(quant * math.Pow10(int(token.Decimals))),
+	}
+	txn := dex.MakeAnnounceTransferTxn(credential.SK, credential.PK.Addr(), t, n)
+	err = sendTxn(client, credential.SK, txn)
+	if err != nil {
+		return err
+	}
+
+	id := dex.TransferID(credential.PK.Addr(), n)
+	fmt.Printf("transfer ID: %x, it can be executed after %d blocks\n", id[:], dex.LargeTransferDelay)
+	return nil
+}
+
+func parseTransferID(str string) (consensus.Hash, error) {
+	var id consensus.Hash
+	b, err := hex.DecodeString(str)
+	if err != nil || len(b) != len(id) {
+		return id, fmt.Errorf("invalid transfer ID: %s", str)
+	}
+
+	copy(id[:], b)
+	return id, nil
+}
+
+func executeTransfer(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 1 {
+		return fmt.Errorf("execute_transfer needs 1 argument (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	id, err := parseTransferID(args[0])
+	if err != nil {
+		return err
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	txn := dex.MakeExecuteTransferTxn(credential.SK, credential.PK.Addr(), dex.ExecuteTransferTxn{ID: id}, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+func vetoTransfer(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 2 {
+		return fmt.Errorf("veto_transfer needs 2 arguments (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	owner, err := parseAddr(args[0])
+	if err != nil {
+		return err
+	}
+
+	id, err := parseTransferID(args[1])
+	if err != nil {
+		return err
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	txn := dex.MakeVetoTransferTxn(credential.SK, credential.PK.Addr(), dex.VetoTransferTxn{Owner: owner, ID: id}, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+func printPendingTransfers(c *cli.Context) error {
+	var addr consensus.Addr
+	if str := c.Args().First(); str == "" {
+		credential, err := loadCredential(credentialPath)
+		if err != nil {
+			return err
+		}
+
+		addr = credential.PK.Addr()
+	} else {
+		var err error
+		addr, err = parseAddr(str)
+		if err != nil {
+			return err
+		}
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	idToToken := make(map[dex.TokenID]dex.TokenInfo)
+	for _, t := range tokens {
+		idToToken[t.ID] = t.TokenInfo
+	}
+
+	var transfers []dex.PendingTransfer
+	err = client.Call("WalletService.PendingTransfers", addr, &transfers)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+	fmt.Fprintln(w, "ID\tTo\tAmount\tExecutable Block\t")
+	for _, p := range transfers {
+		info := idToToken[p.TokenID]
+		fmt.Fprintf(w, "%x\t%s\t%s %s\t%d\t\n", p.ID[:], p.To.Addr().Encode(networkID), quantToStr(p.Quant, int(info.Decimals)), info.Symbol, p.ExecRound)
+	}
+	return w.Flush()
+}
+
+func killSwitch(c *cli.Context) error {
+	str := c.Args().First()
+	if str == "" {
+		return fmt.Errorf("kill_switch needs 1 argument, please check usage using ./wallet -h")
+	}
+
+	addr, err := parseAddr(str)
+	if err != nil {
+		return err
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	t := dex.KillSwitchTxn{Account: addr, Disable: !c.Bool("enable")}
+	txn := dex.MakeKillSwitchTxn(credential.SK, credential.PK.Addr(), t, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+func printDepositAddr(c *cli.Context) error {
+	index, err := strconv.ParseUint(c.Args().First(), 10, 32)
+	if err != nil {
+		return fmt.Errorf("deposit_addr needs the INDEX argument, please check usage using ./wallet -h: %v", err)
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	d := dex.DepositAddr{Addr: credential.PK.Addr(), Index: uint32(index)}
+	fmt.Println(d.Encode(networkID))
+	return nil
+}
+
+func listDeposits(c *cli.Context) error {
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	idToToken := make(map[dex.TokenID]dex.TokenInfo)
+	for _, t := range tokens {
+		idToToken[t.ID] = t.TokenInfo
+	}
+
+	args := dex.DepositsArgs{Addr: credential.PK.Addr()}
+	for {
+		var p dex.DepositPage
+		err = client.Call("WalletService.Deposits", args, &p)
+		if err != nil {
+			return err
+		}
+
+		for _, d := range p.Deposits {
+			info := idToToken[d.TokenID]
+			fmt.Printf("index: %d block: %d %s %s from: %s\n", d.Index, d.Round, quantToStr(d.Quant, int(info.Decimals)), info.Symbol, d.From.Encode(networkID))
+		}
+
+		if !p.More {
+			return nil
+		}
+		args.Cursor = p.NextCursor
+	}
+}
+
+// findMarket returns the market of the symbol, e.g., ETH_BTC, and
+// its base and quote tokens.
+func findMarket(tokens []dex.Token, symbol string) (dex.MarketSymbol, dex.Token, dex.Token, error) {
+	var baseToken, quoteToken dex.Token
+	pair := strings.Split(symbol, "_")
+	if len(pair) != 2 {
+		return dex.MarketSymbol{}, baseToken, quoteToken, fmt.Errorf("symbol not in correct format, expecting BASE_QUOTE (e.g., ETH_BTC), received: %s", symbol)
+	}
+
+	var baseFound, quoteFound bool
+	for _, t := range tokens {
+		switch strings.ToLower(string(t.Symbol)) {
+		case strings.ToLower(pair[0]):
+			baseFound = true
+			baseToken = t
+		case strings.ToLower(pair[1]):
+			quoteFound = true
+			quoteToken = t
+		}
+	}
+
+	if !baseFound || !quoteFound {
+		return dex.MarketSymbol{}, baseToken, quoteToken, fmt.Errorf("token in the market symbol %s is not found in the chain", symbol)
+	}
+
+	return dex.MarketSymbol{Base: baseToken.ID, Quote: quoteToken.ID}, baseToken, quoteToken, nil
+}
+
+func findToken(tokens []dex.Token, symbol string) (dex.Token, error) {
+	for _, t := range tokens {
+		if strings.ToLower(string(t.Symbol)) == strings.ToLower(symbol) {
+			return t, nil
+		}
+	}
+
+	return dex.Token{}, fmt.Errorf("symbol not found: %s", symbol)
+}
+
+func setRiskLimit(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 3 {
+		return fmt.Errorf("risk_limit needs 3 arguments (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	maxOrder, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return fmt.Errorf("parse max order amount error: %v", err)
+	}
+
+	maxNotional, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return fmt.Errorf("parse max open notional error: %v", err)
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	market, base, quote, err := findMarket(tokens, args[0])
+	if err != nil {
+		return err
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	t := dex.SetRiskLimitTxn{
+		Market: market,
+		Limit: dex.RiskLimit{
+			MaxOrderQuant:   uint64(maxOrder * math.Pow10(int(base.Decimals))),
+			MaxOpenNotional: uint64(maxNotional * math.Pow10(int(quote.Decimals))),
+		},
+	}
+	txn := dex.MakeSetRiskLimitTxn(credential.SK, credential.PK.Addr(), t, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+func configMarket(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 1 {
+		return fmt.Errorf("market_config needs 1 argument (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	market, _, _, err := findMarket(tokens, args[0])
+	if err != nil {
+		return err
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	t := dex.MarketConfigTxn{
+		Market: market,
+		MarketConfigInfo: dex.MarketConfigInfo{
+			BatchAuction: c.Bool("batch-auction"),
+			// the band in percent to parts per million.
+			PriceBand: uint64(c.Float64("price-band") * 10000),
+			ProRata:   c.Bool("pro-rata"),
+		},
+	}
+	txn := dex.MakeMarketConfigTxn(credential.SK, credential.PK.Addr(), t, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+// parseSessions parses the comma separated windows of rounds, e.g.,
+// 0-432000,604800-1036800.
+func parseSessions(str string) ([]dex.TradingSession, error) {
+	if str == "" {
+		return nil, nil
+	}
+
+	var r []dex.TradingSession
+	for _, w := range strings.Split(str, ",") {
+		ss := strings.Split(w, "-")
+		if len(ss) != 2 {
+			return nil, fmt.Errorf("invalid window %q, should be OPEN-CLOSE", w)
+		}
+
+		open, err := strconv.ParseUint(ss[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse window %q error: %v", w, err)
+		}
+
+		close, err := strconv.ParseUint(ss[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse window %q error: %v", w, err)
+		}
+
+		r = append(r, dex.TradingSession{Open: open, Close: close})
+	}
+	return r, nil
+}
+
+func setTradingCalendar(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 1 {
+		return fmt.Errorf("trading_calendar needs 1 argument (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	sessions, err := parseSessions(c.String("sessions"))
+	if err != nil {
+		return err
+	}
+
+	maintenance, err := parseSessions(c.String("maintenance"))
+	if err != nil {
+		return err
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	market, _, _, err := findMarket(tokens, args[0])
+	if err != nil {
+		return err
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	t := dex.SetTradingCalendarTxn{
+		Market: market,
+		Calendar: dex.TradingCalendar{
+			Start:       c.Uint64("start"),
+			Period:      c.Uint64("period"),
+			Sessions:    sessions,
+			Maintenance: maintenance,
+		},
+	}
+	txn := dex.MakeSetTradingCalendarTxn(credential.SK, credential.PK.Addr(), t, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+func printPortfolio(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 1 {
+		return fmt.Errorf("portfolio needs at least 1 argument (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	var addr consensus.Addr
+	if len(args) < 2 {
+		c, err := loadCredential(credentialPath)
+		if err != nil {
+			return err
+		}
+
+		addr = c.PK.Addr()
+	} else {
+		var err error
+		addr, err = parseAddr(args[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	idToToken := make(map[dex.TokenID]dex.TokenInfo)
+	var quote dex.Token
+	quoteFound := false
+	for _, t := range tokens {
+		idToToken[t.ID] = t.TokenInfo
+		if strings.ToLower(string(t.Symbol)) == strings.ToLower(args[0]) {
+			quote = t
+			quoteFound = true
+		}
+	}
+
+	if !quoteFound {
+		return fmt.Errorf("token %s is not found in the chain", args[0])
+	}
+
+	var p dex.Portfolio
+	err = client.Call("WalletService.Portfolio", dex.PortfolioArgs{Addr: addr, Quote: quote.ID}, &p)
+	if err != nil {
+		return err
+	}
+
+	valueStr := func(v uint64, priced bool) string {
+		if !priced {
+			return "-"
+		}
+		return quantToStr(v, int(quote.Decimals))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+	fmt.Fprintln(w, "Token\tQuant\tPrice\tValue\t")
+	for _, b := range p.Balances {
+		info := idToToken[b.Token]
+		price := "-"
+		if b.Priced {
+			price = quantToStr(b.Price, dex.OrderPriceDecimals)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", info.Symbol, quantToStr(b.Quant, int(info.Decimals)), price, valueStr(b.Value, b.Priced))
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if len(p.Orders) > 0 {
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+		fmt.Fprintln(w, "Order\tLocked\tValue\t")
+		for _, o := range p.Orders {
+			info := idToToken[o.Token]
+			fmt.Fprintf(w, "%s\t%s %s\t%s\t\n", o.ID.Encode(), quantToStr(o.Locked, int(info.Decimals)), info.Symbol, valueStr(o.Value, o.Priced))
+		}
+		err = w.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("\nTotal: %s %s at block %d\n", quantToStr(p.Total, int(quote.Decimals)), quote.Symbol, p.Round)
+	return nil
+}
+
+func setIndex(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 1 {
+		return fmt.Errorf("set_index needs at least 1 argument (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	idx := dex.Index{Name: args[0]}
+	for _, arg := range args[1:] {
+		ss := strings.Split(arg, ":")
+		if len(ss) != 2 {
+			return fmt.Errorf("invalid index component %q, should be MARKET_SYMBOL:WEIGHT_PERCENT", arg)
+		}
+
+		market, _, _, err := findMarket(tokens, ss[0])
+		if err != nil {
+			return err
+		}
+
+		percent, err := strconv.ParseFloat(ss[1], 64)
+		if err != nil {
+			return fmt.Errorf("invalid weight %q: %v", ss[1], err)
+		}
+
+		// the percent is converted to the weight in the
+		// millionths of the market's price.
+		w := uint64(percent * dex.IndexWeightDenominator / 100)
+		idx.Components = append(idx.Components, dex.IndexComponent{Market: market, Weight: w})
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	txn := dex.MakeSetIndexTxn(credential.SK, credential.PK.Addr(), dex.SetIndexTxn{Index: idx}, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+func printIndexes(c *cli.Context) error {
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	idToToken := make(map[dex.TokenID]dex.TokenInfo)
+	for _, t := range tokens {
+		idToToken[t.ID] = t.TokenInfo
+	}
+
+	var indexes []dex.IndexState
+	err = client.Call("WalletService.Indexes", 0, &indexes)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+	fmt.Fprintln(w, "Name	Value	Block	Components	")
+	for _, idx := range indexes {
+		var cs []string
+		for _, comp := range idx.Components {
+			base, quote := idToToken[comp.Market.Base], idToToken[comp.Market.Quote]
+			cs = append(cs, fmt.Sprintf("%s_%s:%s", base.Symbol, quote.Symbol, quantToStr(comp.Weight, 4)))
+		}
+
+		value := "-"
+		if idx.Price.Round > 0 {
+			value = quantToStr(idx.Price.Value, dex.OrderPriceDecimals)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t\n", idx.Name, value, idx.Price.Round, strings.Join(cs, ","))
+	}
+	return w.Flush()
+}
+
+func setMMProgram(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 6 && !(len(args) == 1 && c.Bool("end")) {
+		return fmt.Errorf("mm_program needs 6 arguments (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	market, base, _, err := findMarket(tokens, args[0])
+	if err != nil {
+		return err
+	}
+
+	var program dex.MMProgram
+	if !c.Bool("end") {
+		var reward *dex.Token
+		for i := range tokens {
+			if strings.ToLower(string(tokens[i].Symbol)) == strings.ToLower(args[1]) {
+				reward = &tokens[i]
+				break
+			}
+		}
+		if reward == nil {
+			return fmt.Errorf("symbol not found: %s", args[1])
+		}
+
+		budget, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return fmt.Errorf("parse budget error: %v", err)
+		}
+
+		epoch, err := strconv.ParseUint(args[3], 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse epoch blocks error: %v", err)
+		}
+
+		spread, err := strconv.ParseFloat(args[4], 64)
+		if err != nil {
+			return fmt.Errorf("parse max spread error: %v", err)
+		}
+
+		minAmount, err := strconv.ParseFloat(args[5], 64)
+		if err != nil {
+			return fmt.Errorf("parse min amount error: %v", err)
+		}
+
+		program = dex.MMProgram{
+			RewardToken: reward.ID,
+			Budget:      uint64(budget * math.Pow10(int(reward.Decimals))),
+			EpochRounds: epoch,
+			// the spread in percent to parts per million.
+			MaxSpread: uint64(spread * 10000),
+			MinQuant:  uint64(minAmount * math.Pow10(int(base.Decimals))),
+		}
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	t := dex.SetMMProgramTxn{Market: market, Program: program}
+	txn := dex.MakeSetMMProgramTxn(credential.SK, credential.PK.Addr(), t, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+func registerMarketMaker(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 1 {
+		return fmt.Errorf("mm_register needs 1 argument (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	market, _, _, err := findMarket(tokens, args[0])
+	if err != nil {
+		return err
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	txn := dex.MakeRegisterMarketMakerTxn(credential.SK, credential.PK.Addr(), dex.RegisterMarketMakerTxn{Market: market}, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+func printMMLedger(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 1 {
+		return fmt.Errorf("mm_ledger needs 1 argument (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	market, _, _, err := findMarket(tokens, args[0])
+	if err != nil {
+		return err
+	}
+
+	var r dex.MMLedgerState
+	err = client.Call("WalletService.MMLedger", dex.MMLedgerArgs{Market: market}, &r)
+	if err != nil {
+		return err
+	}
+
+	var reward dex.Token
+	for _, t := range tokens {
+		if t.ID == r.Program.RewardToken {
+			reward = t
+		}
+	}
+
+	if r.Enabled {
+		fmt.Printf("program: budget %s %s every %d blocks, max spread %.4f%%\n", quantToStr(r.Program.Budget, int(reward.Decimals)), reward.Symbol, r.Program.EpochRounds, float64(r.Program.MaxSpread)/10000)
+	} else {
+		fmt.Println("program: ended")
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+	_, err = fmt.Fprintln(tw, "\tMarket Maker\tUptime\tScore\tLast Epoch Reward\t")
+	if err != nil {
+		return err
+	}
+
+	rewards := make(map[consensus.Addr]uint64)
+	for _, v := range r.Ledger.Rewards {
+		rewards[v.Addr] = v.Reward
+	}
+
+	for _, m := range r.Ledger.Makers {
+		_, err = fmt.Fprintf(tw, "\t%s\t%d\t%d\t%s\t\n", m.Addr.Encode(networkID), m.Uptime, m.Score, quantToStr(rewards[m.Addr], int(reward.Decimals)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+func freezeToken(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 3 {
+		return fmt.Errorf("freeze token needs 3 arguments (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	symbol := args[0]
+	quant, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return fmt.Errorf("error parse freeze token amount: %v", err)
+	}
+	availableHeight, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("error parse freeze token available height: %v", err)
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	var tokenID dex.TokenID
+	var mul float64
+	found := false
+	for _, t := range tokens {
+		if strings.ToLower(string(t.Symbol)) == strings.ToLower(symbol) {
+			tokenID = t.ID
+			mul = math.Pow10(int(t.Decimals))
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("symbol not found: %s", symbol)
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	t := dex.FreezeTokenTxn{TokenID: tokenID, AvailableRound: availableHeight, Quant: uint64(quant * mul)}
+	txn := dex.MakeFreezeTokenTxn(credential.SK, credential.PK.Addr(), t, n)
+	err = sendTxn(client, credential.SK, txn)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func cancelOrder(c *cli.Context) error {
+	all := c.Bool("all")
+	var id dex.OrderID
+	if !all {
+		err := id.Decode(c.Args().First())
+		if err != nil {
+			return err
+		}
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	txn := dex.MakeCancelOrderTxn(credential.SK, credential.PK.Addr(), id, n)
+	if all {
+		txn = dex.MakeCancelAllOrdersTxn(credential.SK, credential.PK.Addr(), dex.CancelAllOrdersTxn{}, n)
+	}
+	err = sendTxn(client, credential.SK, txn)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func reduceOrder(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 2 {
+		return fmt.Errorf("reduce needs 2 arguments (received: %d), please check usage using ./wallet -h", len(args))
+	}
+
+	var id dex.OrderID
+	err := id.Decode(args[0])
+	if err != nil {
+		return err
+	}
+
+	amount, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return fmt.Errorf("parse amount error: %v", err)
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	var base *dex.Token
+	for i := range tokens {
+		if tokens[i].ID == id.Market.Base {
+			base = &tokens[i]
+			break
+		}
+	}
+	if base == nil {
+		return fmt.Errorf("token not found: %d", id.Market.Base)
+	}
+
+	n, err := nonce(client, credential.PK.Addr())
+	if err != nil {
+		return err
+	}
+
+	t := dex.ReduceOrderTxn{ID: id, Quant: uint64(amount * math.Pow10(int(base.Decimals)))}
+	txn := dex.MakeReduceOrderTxn(credential.SK, credential.PK.Addr(), t, n)
+	return sendTxn(client, credential.SK, txn)
+}
+
+func placeOrder(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 5 {
 		return fmt.Errorf("send needs 5 arguments (received: %d), please check usage using ./wallet -h", len(args))
 	}
 
@@ -696,7 +2426,7 @@ func placeOrder(c *cli.Context) error {
 		return fmt.Errorf("parse expiry time error: %v", err)
 	}
 
-	client, err := rpc.DialHTTP("tcp", rpcAddr)
+	client, err := dial()
 	if err != nil {
 		return err
 	}
@@ -750,15 +2480,127 @@ func placeOrder(c *cli.Context) error {
 		ExpireRound: expireRound,
 		Market:      market,
 	}
-	txn := dex.MakePlaceOrderTxn(credential.SK, credential.PK.Addr(), placeOrderTxn, n)
+	txn, err := withOptions(client, credential.SK, dex.MakePlaceOrderTxn(credential.SK, credential.PK.Addr(), placeOrderTxn, n))
+	if err != nil {
+		return err
+	}
+
 	err = client.Call("WalletService.SendTxn", txn, nil)
 	if err != nil {
 		return err
 	}
 
+	fmt.Printf("txn hash: %x\n", consensus.SHA3(txn))
 	return nil
 }
 
+// rpcSweepClient is the sweep client using the wallet RPC.
+type rpcSweepClient struct {
+	client *rpc.Client
+}
+
+func (r *rpcSweepClient) WalletState(addr consensus.Addr) (w dex.WalletState, err error) {
+	err = r.client.Call("WalletService.WalletState", addr, &w)
+	return
+}
+
+func (r *rpcSweepClient) FinalizedWalletState(addr consensus.Addr) (w dex.WalletState, err error) {
+	err = r.client.Call("WalletService.FinalizedWalletState", addr, &w)
+	return
+}
+
+func (r *rpcSweepClient) SendTxn(txn []byte) error {
+	return r.client.Call("WalletService.SendTxn", txn, nil)
+}
+
+func sweep(c *cli.Context) error {
+	args := c.Args()
+	if len(args) < 3 || len(args)%2 != 1 {
+		return fmt.Errorf("sweep needs a public key followed by the SYMBOL THRESHOLD pairs (received: %d arguments), please check usage using ./wallet -h", len(args))
+	}
+
+	credential, err := loadCredential(credentialPath)
+	if err != nil {
+		return err
+	}
+
+	b, err := base64.StdEncoding.DecodeString(args[0])
+	if err != nil {
+		return fmt.Errorf("COLD_PUB_KEY (%s) must be encoded in base64, err: %v", args[0], err)
+	}
+
+	client, err := dial()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := getTokens(client)
+	if err != nil {
+		return err
+	}
+
+	symbols := make(map[dex.TokenID]dex.Token)
+	var rules []dex.SweepRule
+	for i := 1; i < len(args); i += 2 {
+		symbol := args[i]
+		threshold, err := strconv.ParseFloat(args[i+1], 64)
+		if err != nil {
+			return fmt.Errorf("error parse sweep threshold: %v", err)
+		}
+
+		found := false
+		for _, t := range tokens {
+			if strings.ToLower(string(t.Symbol)) == strings.ToLower(symbol) {
+				mul := math.Pow10(int(t.Decimals))
+				rules = append(rules, dex.SweepRule{
+					Token:     t.ID,
+					Threshold: uint64(threshold * mul),
+					MinQuant:  uint64(c.Float64("min-amount") * mul),
+				})
+				symbols[t.ID] = t
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("symbol not found: %s", symbol)
+		}
+	}
+
+	printSweep := func(prefix string, s dex.Sweep) {
+		t := symbols[s.Token]
+		fmt.Printf("%s %s %s, nonce: %d, txn: %x\n", prefix, quantToStr(s.Quant, int(t.Decimals)), t.Symbol, s.Nonce, s.Txn[:])
+	}
+
+	sweeper, err := dex.NewSweeper(&rpcSweepClient{client: client}, credential.SK, credential.PK, dex.SweepConfig{
+		Cold:         dex.PK(b),
+		Rules:        rules,
+		MinInterval:  c.Duration("min-interval"),
+		MaxPerWindow: c.Int("max-per-hour"),
+		Window:       time.Hour,
+		OnConfirm: func(s dex.Sweep) {
+			printSweep("finalized sweep of", s)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		sent, err := sweeper.Sweep(time.Now())
+		if err != nil {
+			fmt.Printf("sweep failed with error: %v\n", err)
+		}
+
+		for _, s := range sent {
+			printSweep("sent sweep of", s)
+		}
+
+		time.Sleep(c.Duration("interval"))
+	}
+}
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "DEX wallet"
@@ -776,6 +2618,46 @@ func main() {
 			Usage:       "node's wallet RPC endpoint",
 			Destination: &rpcAddr,
 		},
+		cli.StringFlag{
+			Name:        "network",
+			Value:       "devnet",
+			Usage:       "the network of the node, possible values: mainnet, testnet, devnet",
+			Destination: &networkName,
+		},
+		cli.StringFlag{
+			Name:        "api-key",
+			Usage:       "ID of the API key of the node's wallet RPC, the RPC is accessed without a key if empty",
+			Destination: &apiKeyID,
+		},
+		cli.StringFlag{
+			Name:        "api-secret",
+			Usage:       "hex encoded secret of the API key",
+			Destination: &apiSecret,
+		},
+		cli.Uint64Flag{
+			Name:        "valid-for",
+			Usage:       "number of the rounds after the current round that the sent txn can be included in, so that it can not be replayed later, 0 means no limit",
+			Destination: &validFor,
+		},
+		cli.UintFlag{
+			Name:        "lane",
+			Usage:       "the nonce lane of the sent txn, each lane has its own sequential nonce, so that a trading engine can keep the orders of different lanes in flight independently",
+			Destination: &lane,
+		},
+	}
+
+	app.Before = func(c *cli.Context) error {
+		var err error
+		networkID, err = consensus.NetworkByName(networkName)
+		if err != nil {
+			return err
+		}
+
+		dex.SetNetworkID(networkID)
+		if lane >= dex.NonceLanes {
+			return fmt.Errorf("invalid nonce lane %d, there are %d lanes", lane, dex.NonceLanes)
+		}
+		return nil
 	}
 
 	app.Commands = []cli.Command{
@@ -784,6 +2666,16 @@ func main() {
 			Usage:  "Print the chain status: ./wallet status",
 			Action: printStatus,
 		},
+		{
+			Name:   "txn_proof",
+			Usage:  "Verify that the txn is included in a block of the heaviest chain: ./wallet txn_proof TXN_HASH",
+			Action: txnProof,
+		},
+		{
+			Name:   "heads",
+			Usage:  "Print the new chain heads, the finalized blocks and the reorgs as they happen, until interrupted: ./wallet heads",
+			Action: watchHeads,
+		},
 		{
 			Name:   "graphviz",
 			Usage:  "Print the chain visualization in graphviz format, please go to http://www.webgraphviz.com/ for visualization",
@@ -794,21 +2686,175 @@ func main() {
 			Usage:  "Print the information of every token: ./wallet token",
 			Action: listToken,
 		},
+		{
+			Name:   "ticker",
+			Usage:  "Print the last price, 24h high, low, volume and the best bid and ask of every traded market: ./wallet ticker",
+			Action: listTicker,
+		},
 		{
 			Name:   "issue_token",
 			Usage:  "Issue new token: ./wallet issue_token SYMBOL TOTAL_SUPPLY DECIMALS",
 			Action: issueToken,
 		},
+		{
+			Name:   "verify_issuer",
+			Usage:  "Attest the issuer of the token, the credential must be the governor's: ./wallet verify_issuer SYMBOL ISSUER_NAME, or revoke the attestation: ./wallet verify_issuer -revoke SYMBOL",
+			Action: verifyIssuer,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "revoke",
+					Usage: "revoke the attestation",
+				},
+			},
+		},
+		{
+			Name:   "delist",
+			Usage:  "Delist the token, its markets stop accepting new orders, the resting orders are cancelled at the retire round, the credential must be the governor's: ./wallet delist SYMBOL RETIRE_ROUND",
+			Action: delist,
+		},
+		{
+			Name:   "block_address",
+			Usage:  "Block the address, cancelling its orders and rejecting its txns and the tokens sent to it, the credential must be the governor's: ./wallet block_address -reason REASON ADDRESS, or unblock it: ./wallet block_address -unblock ADDRESS",
+			Action: blockAddress,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "reason",
+					Usage: "reason of the block recorded on chain, e.g., the sanctions list entry",
+				},
+				cli.BoolFlag{
+					Name:  "unblock",
+					Usage: "remove the address from the blocklist",
+				},
+			},
+		},
+		{
+			Name:   "bust",
+			Usage:  fmt.Sprintf("Bust the trade, returning the traded tokens to the buyer and the seller, within %d blocks of the trade, the credential must be the governor's: ./wallet bust BLOCK TRADE_INDEX JUSTIFICATION", dex.TradeBustWindow),
+			Action: bustTrade,
+		},
+		{
+			Name:   "register_validator",
+			Usage:  fmt.Sprintf("Queue the node for the DKG of the next epoch of %d blocks, locking the bond (at least %d in the native token's smallest unit): ./wallet -c CREDENTIAL_FILE_PATH register_validator NODE_CREDENTIAL_FILE_PATH BOND (in the native token)", dex.ValidatorEpochRounds, dex.MinValidatorBond),
+			Action: registerValidator,
+		},
+		{
+			Name:   "validators",
+			Usage:  "Print the validators queued for the DKG: ./wallet validators",
+			Action: printValidatorQueue,
+		},
+		{
+			Name:   "trades",
+			Usage:  "Print the matched trades of the block with their indexes, within the bust window: ./wallet trades BLOCK",
+			Action: printTradeRecords,
+		},
+		{
+			Name:   "verify_books",
+			Usage:  "Verify the stored order books against their digests committed in the state: ./wallet verify_books",
+			Action: verifyBooks,
+		},
+		{
+			Name:   "whitelist",
+			Usage:  "Approve the holders of the restricted token, the credential must be the issuer's: ./wallet whitelist -restrict on SYMBOL ADDRESS..., or revoke them: ./wallet whitelist -revoke SYMBOL ADDRESS...",
+			Action: tokenWhitelist,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "restrict",
+					Usage: "restrict the token to the whitelisted holders, possible values: on, off, the current mode is kept if not specified",
+				},
+				cli.BoolFlag{
+					Name:  "revoke",
+					Usage: "revoke the holders instead of approving them",
+				},
+			},
+		},
+		{
+			Name:   "guardian",
+			Usage:  fmt.Sprintf("Set the guardian that can disable the account with the kill switch: ./wallet guardian ADDRESS, or remove it: ./wallet guardian -remove, a change or removal applies after %d blocks", dex.GuardianChangeDelay),
+			Action: setGuardian,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "remove",
+					Usage: "remove the guardian",
+				},
+			},
+		},
+		{
+			Name:   "allow_list",
+			Usage:  fmt.Sprintf("Restrict the addresses that the account can send tokens to: ./wallet allow_list ADDRESS..., or lift the restriction: ./wallet allow_list -disable, a looser allow-list applies after %d blocks", dex.AllowListLooseningDelay),
+			Action: setAllowList,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "disable",
+					Usage: "allow sending to any address",
+				},
+			},
+		},
+		{
+			Name:   "transfer_threshold",
+			Usage:  fmt.Sprintf("Require the outflow above the amount in %d blocks to be announced: ./wallet transfer_threshold SYMBOL AMOUNT, 0 removes the threshold, a higher threshold or the removal applies after %d blocks", dex.LargeTransferWindow, dex.LargeTransferDelay),
+			Action: setTransferThreshold,
+		},
+		{
+			Name:   "announce_transfer",
+			Usage:  fmt.Sprintf("Announce a transfer above the threshold, it can be executed after %d blocks unless the guardian vetoes it: ./wallet announce_transfer PUB_KEY SYMBOL AMOUNT", dex.LargeTransferDelay),
+			Action: announceTransfer,
+		},
+		{
+			Name:   "execute_transfer",
+			Usage:  "Execute the announced transfer: ./wallet execute_transfer TRANSFER_ID",
+			Action: executeTransfer,
+		},
+		{
+			Name:   "veto_transfer",
+			Usage:  "Veto the announced transfer and refund it, the credential must be the owner's or its guardian's: ./wallet veto_transfer OWNER_ADDRESS TRANSFER_ID",
+			Action: vetoTransfer,
+		},
+		{
+			Name:   "pending_transfers",
+			Usage:  "Print the announced transfers of the account: ./wallet pending_transfers ADDRESS, or, ./wallet -c CREDENTIAL_FILE_PATH pending_transfers",
+			Action: printPendingTransfers,
+		},
+		{
+			Name:   "kill_switch",
+			Usage:  "Cancel all the orders of the account and reject its txns, the credential must be the guardian's: ./wallet kill_switch ADDRESS, or re-enable it: ./wallet kill_switch -enable ADDRESS",
+			Action: killSwitch,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "enable",
+					Usage: "re-enable the account instead of disabling it",
+				},
+			},
+		},
 		{
 			Name:   "send",
-			Usage:  "Send native coin or token to recipient's public key: ./wallet send PUB_KEY SYMBOL AMOUNT (BNB is the native token symbol, PUB_KEY is the recipient's base64 encoded public key)",
+			Usage:  "Send native coin or token to recipient's public key or deposit address: ./wallet send PUB_KEY SYMBOL AMOUNT (BNB is the native token symbol, PUB_KEY is the recipient's base64 encoded public key, or a deposit address)",
 			Action: sendToken,
 		},
+		{
+			Name:   "deposit_addr",
+			Usage:  "Print the receive-only deposit address of the account with the index, e.g., one per customer: ./wallet -c NODE_CREDENTIAL_FILE_PATH deposit_addr INDEX",
+			Action: printDepositAddr,
+		},
+		{
+			Name:   "deposits",
+			Usage:  "Print the deposits to the deposit addresses of the account: ./wallet -c NODE_CREDENTIAL_FILE_PATH deposits",
+			Action: listDeposits,
+		},
 		{
 			Name:   "account",
-			Usage:  "Print account information: ./wallet account PUB_KEY (or ADDRESS), or, ./wallet -c NODE_CREDENTIAL_FILE_PATH account",
+			Usage:  "Print account information: ./wallet account PUB_KEY (or ADDRESS, e.g., ddex1...), or, ./wallet -c NODE_CREDENTIAL_FILE_PATH account",
 			Action: printAccount,
 		},
+		{
+			Name:   "portfolio",
+			Usage:  "Print the value of the account's balances and open orders in the quote token at the reference prices: ./wallet portfolio QUOTE_SYMBOL (e.g., BTC) ADDRESS, or, ./wallet -c CREDENTIAL_FILE_PATH portfolio QUOTE_SYMBOL",
+			Action: printPortfolio,
+		},
+		{
+			Name:   "stream",
+			Usage:  "Print the order acks, fills, closed orders and balance changes of the account as they happen, until interrupted: ./wallet -c NODE_CREDENTIAL_FILE_PATH stream",
+			Action: streamAccount,
+		},
 		{
 			Name:   "order",
 			Usage:  "Place an order: ./wallet -c NODE_CREDENTIAL_FILE_PATH order MARKET_SYMBOL (e.g,. ETH_BTC, ETH is the base asset, BTC is the quote asset) SIDE (buy or sell) PRICE (price=base_asset_value/quote_asset_value) AMOUNT (quantity of base asset) EXPIRY_TIME (in blocks: 0 means won't expire, 1 means expires at the next block, effectively an IOC order)",
@@ -816,8 +2862,97 @@ func main() {
 		},
 		{
 			Name:   "cancel",
-			Usage:  "Cancel an order: ./wallet -c NODE_CREDENTIAL_FILE_PATH cancel ORDER_ID",
+			Usage:  "Cancel an order: ./wallet -c NODE_CREDENTIAL_FILE_PATH cancel ORDER_ID, or all the orders: ./wallet -c NODE_CREDENTIAL_FILE_PATH cancel -all",
 			Action: cancelOrder,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "all",
+					Usage: "cancel all the pending orders",
+				},
+			},
+		},
+		{
+			Name:   "reduce",
+			Usage:  "Reduce the remaining amount of an order keeping its priority: ./wallet -c NODE_CREDENTIAL_FILE_PATH reduce ORDER_ID AMOUNT (in base asset)",
+			Action: reduceOrder,
+		},
+		{
+			Name:   "risk_limit",
+			Usage:  fmt.Sprintf("Set the risk limit of the market: ./wallet -c NODE_CREDENTIAL_FILE_PATH risk_limit MARKET_SYMBOL (e.g,. ETH_BTC) MAX_ORDER_AMOUNT (in base asset) MAX_OPEN_NOTIONAL (in quote asset), 0 means no limit, a looser limit applies after %d blocks", dex.RiskLimitLooseningDelay),
+			Action: setRiskLimit,
+		},
+		{
+			Name:   "market_config",
+			Usage:  "Configure the market, the credential must be the base token issuer's: ./wallet -c NODE_CREDENTIAL_FILE_PATH market_config [-batch-auction] [-price-band PERCENT] [-pro-rata] MARKET_SYMBOL (e.g,. ETH_BTC)",
+			Action: configMarket,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "batch-auction",
+					Usage: "clear the orders of each block at a single price",
+				},
+				cli.Float64Flag{
+					Name:  "price-band",
+					Usage: "reject the orders priced more than the percent away from the reference price, 0 means no band",
+				},
+				cli.BoolFlag{
+					Name:  "pro-rata",
+					Usage: "fill the orders at a price in proportion to their sizes instead of in time priority",
+				},
+			},
+		},
+		{
+			Name:   "mm_program",
+			Usage:  "Set the market maker incentive program of the market, the credential must be the governor's: ./wallet mm_program MARKET_SYMBOL (e.g,. ETH_BTC) REWARD_SYMBOL BUDGET (reward of each epoch, paid from the fee pool) EPOCH_BLOCKS MAX_SPREAD (in percent of the mid price) MIN_AMOUNT (of a quoting order in base asset), or end it: ./wallet mm_program -end MARKET_SYMBOL",
+			Action: setMMProgram,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "end",
+					Usage: "end the program",
+				},
+			},
+		},
+		{
+			Name:   "set_index",
+			Usage:  "Define the index as the weighted sum of the markets' prices, the credential must be the governor's: ./wallet set_index NAME MARKET_SYMBOL:WEIGHT_PERCENT... (e.g,. CRYPTO ETH_BTC:50 XRP_BTC:50), or remove it: ./wallet set_index NAME",
+			Action: setIndex,
+		},
+		{
+			Name:   "indexes",
+			Usage:  "Print the indexes with their latest values: ./wallet indexes",
+			Action: printIndexes,
+		},
+		{
+			Name:   "trading_calendar",
+			Usage:  "Set the trading sessions of the market, the credential must be the governor's: ./wallet trading_calendar -start BLOCK -period BLOCKS -sessions OPEN-CLOSE,... [-maintenance OPEN-CLOSE,...] MARKET_SYMBOL (e.g,. ETH_BTC), or remove them: ./wallet trading_calendar MARKET_SYMBOL",
+			Action: setTradingCalendar,
+			Flags: []cli.Flag{
+				cli.Uint64Flag{
+					Name:  "start",
+					Usage: "block the calendar starts at",
+				},
+				cli.Uint64Flag{
+					Name:  "period",
+					Usage: "number of the blocks the sessions repeat every, e.g., a week",
+				},
+				cli.StringFlag{
+					Name:  "sessions",
+					Usage: "comma separated trading sessions, in the blocks from the start of each period",
+				},
+				cli.StringFlag{
+					Name:  "maintenance",
+					Usage: "comma separated maintenance windows closing the market, in absolute blocks",
+				},
+			},
+		},
+		{
+			Name:   "mm_register",
+			Usage:  "Register as a market maker of the market's incentive program: ./wallet -c NODE_CREDENTIAL_FILE_PATH mm_register MARKET_SYMBOL",
+			Action: registerMarketMaker,
+		},
+		{
+			Name:   "mm_ledger",
+			Usage:  "Print the market maker incentive program of the market, the uptime and scores of the current epoch and the rewards of the last epoch: ./wallet mm_ledger MARKET_SYMBOL",
+			Action: printMMLedger,
 		},
 		{
 			Name:   "freeze",
@@ -829,6 +2964,37 @@ func main() {
 			Usage:  "Burn token: ./wallet -c NODE_CREDENTIAL_FILE_PATH burn SYMBOL AMOUNT",
 			Action: burnToken,
 		},
+		{
+			Name:   "export_snapshot",
+			Usage:  "Export the snapshot of the latest finalized block and its state, a new node can bootstrap from it using the -snapshot flag: ./wallet export_snapshot PATH",
+			Action: exportSnapshot,
+		},
+		{
+			Name:   "sweep",
+			Usage:  "Keep sweeping the balances above the thresholds from the hot wallet to the cold public key, until interrupted: ./wallet -c HOT_CREDENTIAL_FILE_PATH sweep COLD_PUB_KEY SYMBOL THRESHOLD [SYMBOL THRESHOLD ...]",
+			Action: sweep,
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:  "interval",
+					Value: 10 * time.Second,
+					Usage: "the interval between two balance checks",
+				},
+				cli.DurationFlag{
+					Name:  "min-interval",
+					Value: 10 * time.Minute,
+					Usage: "the min interval between two sweeps of the same token",
+				},
+				cli.IntFlag{
+					Name:  "max-per-hour",
+					Value: 6,
+					Usage: "the max number of the sweeps per hour, 0 means no limit",
+				},
+				cli.Float64Flag{
+					Name:  "min-amount",
+					Usage: "the min amount of a sweep",
+				},
+			},
+		},
 	}
 
 	err := app.Run(os.Args)
@@ -0,0 +1,169 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The text encoding of the addresses is bech32 (BIP 173): a human
+// readable prefix naming the network, the separator "1", the address
+// in base32 and a 6 character checksum. A mistyped character is
+// detected by the checksum, and an address of another network is
+// rejected by the prefix.
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Gen = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+var addrPrefixes = map[NetworkID]string{
+	Mainnet: "dex",
+	Testnet: "tdex",
+	Devnet:  "ddex",
+}
+
+// AddrPrefix returns the human readable prefix of the addresses of
+// the network.
+func (id NetworkID) AddrPrefix() string {
+	if p, ok := addrPrefixes[id]; ok {
+		return p
+	}
+	return fmt.Sprintf("dexnet%d", uint32(id))
+}
+
+// Encode returns the bech32 encoding of the address on the network.
+func (a Addr) Encode(network NetworkID) string {
+	return EncodeBech32(network, a[:])
+}
+
+// DecodeAddr decodes the bech32 encoded address of the network.
+func DecodeAddr(network NetworkID, str string) (Addr, error) {
+	var addr Addr
+	b, err := DecodeBech32(network, str)
+	if err != nil {
+		return addr, err
+	}
+
+	if len(b) != addrBytes {
+		return addr, fmt.Errorf("invalid address length: %s", str)
+	}
+
+	copy(addr[:], b)
+	return addr, nil
+}
+
+// EncodeBech32 returns the bech32 encoding of the data with the
+// address prefix of the network.
+func EncodeBech32(network NetworkID, b []byte) string {
+	prefix := network.AddrPrefix()
+	data := convertBits(b, 8, 5)
+	values := append(data, bech32Checksum(prefix, data)...)
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	sb.WriteByte('1')
+	for _, v := range values {
+		sb.WriteByte(bech32Charset[v])
+	}
+	return sb.String()
+}
+
+// DecodeBech32 decodes the bech32 encoded data with the address
+// prefix of the network.
+func DecodeBech32(network NetworkID, str string) ([]byte, error) {
+	if strings.ToLower(str) != str && strings.ToUpper(str) != str {
+		return nil, errors.New("address must not be mixed case")
+	}
+
+	str = strings.ToLower(str)
+	sep := strings.LastIndexByte(str, '1')
+	if sep < 1 || len(str)-sep-1 < 6 {
+		return nil, fmt.Errorf("invalid address: %s", str)
+	}
+
+	prefix := str[:sep]
+	if prefix != network.AddrPrefix() {
+		return nil, fmt.Errorf("address %s is not of network %v, the address prefix should be %s", str, network, network.AddrPrefix())
+	}
+
+	values := make([]byte, len(str)-sep-1)
+	for i := range values {
+		v := strings.IndexByte(bech32Charset, str[sep+1+i])
+		if v < 0 {
+			return nil, fmt.Errorf("invalid character %q in address %s", str[sep+1+i], str)
+		}
+		values[i] = byte(v)
+	}
+
+	if bech32Polymod(append(bech32ExpandPrefix(prefix), values...)) != 1 {
+		return nil, fmt.Errorf("invalid address checksum: %s", str)
+	}
+
+	data := values[:len(values)-6]
+	b := convertBits(data, 5, 8)
+	// the padding bits of the last group must be zero and fewer
+	// than 5, i.e., the data is the encoding of len(b) bytes.
+	if len(data) != (len(b)*8+4)/5 {
+		return nil, fmt.Errorf("invalid address length: %s", str)
+	}
+
+	return b, nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= bech32Gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32ExpandPrefix(prefix string) []byte {
+	r := make([]byte, 0, len(prefix)*2+1)
+	for i := 0; i < len(prefix); i++ {
+		r = append(r, prefix[i]>>5)
+	}
+	r = append(r, 0)
+	for i := 0; i < len(prefix); i++ {
+		r = append(r, prefix[i]&31)
+	}
+	return r
+}
+
+func bech32Checksum(prefix string, data []byte) []byte {
+	values := append(bech32ExpandPrefix(prefix), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	mod := bech32Polymod(values) ^ 1
+	r := make([]byte, 6)
+	for i := range r {
+		r[i] = byte(mod>>uint(5*(5-i))) & 31
+	}
+	return r
+}
+
+// convertBits regroups the bits of data from groups of from bits to
+// groups of to bits. When encoding to the smaller groups, the last
+// group is padded with zeros, when decoding the padding is dropped.
+func convertBits(data []byte, from, to uint) []byte {
+	var acc uint32
+	var bits uint
+	max := uint32(1)<<to - 1
+	var r []byte
+	for _, v := range data {
+		acc = acc<<from | uint32(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			r = append(r, byte(acc>>bits&max))
+		}
+	}
+	if bits > 0 && from > to {
+		r = append(r, byte(acc<<(to-bits)&max))
+	}
+	return r
+}
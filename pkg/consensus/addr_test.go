@@ -0,0 +1,73 @@
+package consensus
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBech32Checksum(t *testing.T) {
+	// the valid checksums from BIP 173.
+	for _, str := range []string{
+		"a12uel5l",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+	} {
+		sep := strings.LastIndexByte(str, '1')
+		values := []byte(str[sep+1:])
+		for i, c := range values {
+			values[i] = byte(strings.IndexByte(bech32Charset, c))
+		}
+		assert.Equal(t, uint32(1), bech32Polymod(append(bech32ExpandPrefix(str[:sep]), values...)), str)
+	}
+}
+
+func TestAddrEncodeVector(t *testing.T) {
+	var addr Addr
+	b, _ := hex.DecodeString("9278552d23bb4cad6e9b1210853f6b9af107f720")
+	copy(addr[:], b)
+	assert.Equal(t, "ddex1jfu92tfrhdx26m5mzggg20mtntcs0aeqedk8fh", addr.Encode(Devnet))
+}
+
+func TestAddrEncode(t *testing.T) {
+	var addr Addr
+	for i := range addr {
+		addr[i] = byte(i * 13)
+	}
+
+	for _, network := range []NetworkID{Mainnet, Testnet, Devnet, 100} {
+		str := addr.Encode(network)
+		assert.True(t, strings.HasPrefix(str, network.AddrPrefix()+"1"))
+		decoded, err := DecodeAddr(network, str)
+		assert.Nil(t, err)
+		assert.Equal(t, addr, decoded)
+		decoded, err = DecodeAddr(network, strings.ToUpper(str))
+		assert.Nil(t, err)
+		assert.Equal(t, addr, decoded)
+	}
+
+	str := addr.Encode(Mainnet)
+	_, err := DecodeAddr(Testnet, str)
+	assert.NotNil(t, err, "address of another network")
+
+	// every single character typo is detected.
+	for i := len(Mainnet.AddrPrefix()) + 1; i < len(str); i++ {
+		for _, c := range bech32Charset {
+			if byte(c) == str[i] {
+				continue
+			}
+			typo := str[:i] + string(c) + str[i+1:]
+			_, err := DecodeAddr(Mainnet, typo)
+			assert.NotNil(t, err, typo)
+		}
+	}
+
+	_, err = DecodeAddr(Mainnet, str[:len(str)-1])
+	assert.NotNil(t, err, "truncated address")
+	_, err = DecodeAddr(Mainnet, str[:5]+strings.ToUpper(str[5:]))
+	assert.NotNil(t, err, "mixed case")
+	_, err = DecodeAddr(Mainnet, addr.Hex())
+	assert.NotNil(t, err, "hex address")
+}
@@ -1,7 +1,9 @@
 package consensus
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/dfinity/go-dfinity-crypto/bls"
 	"github.com/ethereum/go-ethereum/rlp"
@@ -9,6 +11,9 @@ import (
 
 const (
 	addrBytes = 20
+	// maxClockSkew is how far a block proposal's timestamp may be
+	// ahead of the local clock.
+	maxClockSkew = 5 * time.Second
 )
 
 var ZeroAddr = Addr{}
@@ -136,6 +141,8 @@ type BlockProposal struct {
 	PrevBlock Hash
 	Txns      []byte
 	Owner     Addr
+	// Timestamp is the proposing time in Unix milliseconds.
+	Timestamp uint64
 	// The signature of the gob serialized BlockProposal with
 	// OwnerSig set to nil.
 	OwnerSig Sig
@@ -161,21 +168,92 @@ func (bp *BlockProposal) Hash() Hash {
 	return SHA3(bp.Encode(true))
 }
 
+// EmptyBlockProposal returns the block proposal without txns that
+// the notaries notarize when no block proposal of the round is
+// received before the timeout, so the chain keeps advancing during
+// the proposer outages. It has no owner and no signature, every node
+// derives the same proposal from the round and the previous block,
+// the timestamp is the previous block's.
+func EmptyBlockProposal(round uint64, prev *Block) *BlockProposal {
+	return &BlockProposal{Round: round, PrevBlock: prev.Hash(), Timestamp: prev.Timestamp}
+}
+
+// Empty returns true if the block proposal is the empty block
+// proposal.
+func (bp *BlockProposal) Empty() bool {
+	return bp.Owner == Addr{} && len(bp.Txns) == 0 && len(bp.OwnerSig) == 0
+}
+
+// validateEmptyProposal checks that the received empty block proposal
+// of the hash is exactly the one derived from the previous block. It
+// has no owner signature to verify, so no other empty proposal is
+// accepted.
+func validateEmptyProposal(bp *BlockProposal, hash Hash, prev *Block) error {
+	if hash != EmptyBlockProposal(bp.Round, prev).Hash() {
+		return errors.New("empty block proposal is not the one derived from the prev block")
+	}
+
+	return nil
+}
+
+// validateTimestamp checks that the block proposal's timestamp does
+// not go back from the previous block's, and is not ahead of the local
+// clock by more than maxClockSkew. The empty block proposal keeps the
+// previous block's timestamp.
+func validateTimestamp(bp *BlockProposal, prev *Block, now time.Time) error {
+	if bp.Empty() {
+		if bp.Timestamp != prev.Timestamp {
+			return errors.New("empty block proposal timestamp is not the prev block's")
+		}
+		return nil
+	}
+
+	if bp.Timestamp < prev.Timestamp {
+		return fmt.Errorf("block proposal timestamp %d is before prev block timestamp %d", bp.Timestamp, prev.Timestamp)
+	}
+
+	if bp.Timestamp > unixMillis(now.Add(maxClockSkew)) {
+		return fmt.Errorf("block proposal timestamp %d is ahead of local clock %d", bp.Timestamp, unixMillis(now))
+	}
+
+	return nil
+}
+
+func unixMillis(t time.Time) uint64 {
+	return uint64(t.UnixNano() / int64(time.Millisecond))
+}
+
 // Genesis is the genesis block and the serialized genesis state.
 type Genesis struct {
-	Block Block
-	State TrieBlob
+	NetworkID NetworkID
+	Block     Block
+	State     TrieBlob
 }
 
 // Block is the block generated by the notary group.
 type Block struct {
-	Owner         Addr
-	Round         uint64
-	StateRoot     Hash
-	BlockProposal Hash
-	PrevBlock     Hash
-	SysTxns       []SysTxn
-	Notarization  Sig
+	Owner     Addr
+	Round     uint64
+	StateRoot Hash
+	// TxnRoot is the Merkle root of the txns of the block
+	// proposal, see TxnRoot.
+	TxnRoot Hash
+	// RandBeacon is the hash of the random beacon signature of
+	// the round, the output that selected the committees of the
+	// round. RandCheckpoint is the hash of the latest random
+	// beacon checkpoint not after the round, zero before the
+	// first checkpoint. They bind the block to the random beacon
+	// chain, a light client verifies the committee selection
+	// from the checkpoint.
+	RandBeacon     Hash
+	RandCheckpoint Hash
+	BlockProposal  Hash
+	PrevBlock      Hash
+	// Timestamp is the timestamp of the block proposal in Unix
+	// milliseconds.
+	Timestamp    uint64
+	SysTxns      []SysTxn
+	Notarization Sig
 }
 
 // Encode encodes the block.
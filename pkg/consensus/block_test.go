@@ -2,6 +2,7 @@ package consensus
 
 import (
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/stretchr/testify/assert"
@@ -149,3 +150,58 @@ func TestEncodeSlice(t *testing.T) {
 	// differently.
 	assert.NotEqual(t, b, b0)
 }
+
+func TestEmptyBlockProposal(t *testing.T) {
+	prev := &Block{Round: 1, Timestamp: 1000}
+	bp := EmptyBlockProposal(2, prev)
+	assert.True(t, bp.Empty())
+	assert.Equal(t, uint64(1000), bp.Timestamp)
+	assert.Equal(t, bp.Hash(), EmptyBlockProposal(2, prev).Hash())
+	assert.NotEqual(t, bp.Hash(), EmptyBlockProposal(2, &Block{Round: 1, Owner: Addr{1}}).Hash())
+
+	bp.Owner = Addr{1}
+	assert.False(t, bp.Empty())
+
+	assert.True(t, emptyBlockWeight > 0)
+	assert.True(t, emptyBlockWeight < rankToWeight(1000))
+}
+
+func TestValidateEmptyProposal(t *testing.T) {
+	prev := &Block{Round: 1, Timestamp: 1000}
+	bp := EmptyBlockProposal(2, prev)
+	assert.Nil(t, validateEmptyProposal(bp, bp.Hash(), prev))
+
+	// an empty proposal of another prev block.
+	other := EmptyBlockProposal(2, &Block{Round: 1, Owner: Addr{1}})
+	assert.NotNil(t, validateEmptyProposal(other, other.Hash(), prev))
+
+	bp.Txns = []byte{}
+	assert.True(t, bp.Empty())
+	assert.Nil(t, validateEmptyProposal(bp, bp.Hash(), prev))
+	assert.NotNil(t, validateEmptyProposal(bp, SHA3([]byte("other")), prev))
+}
+
+func TestValidateTimestamp(t *testing.T) {
+	now := time.Unix(100, 0)
+	prev := &Block{Round: 1, Timestamp: unixMillis(now) - 2000}
+	bp := &BlockProposal{Round: 2, Owner: Addr{1}, Timestamp: unixMillis(now)}
+	assert.Nil(t, validateTimestamp(bp, prev, now))
+
+	bp.Timestamp = prev.Timestamp
+	assert.Nil(t, validateTimestamp(bp, prev, now))
+
+	bp.Timestamp = prev.Timestamp - 1
+	assert.NotNil(t, validateTimestamp(bp, prev, now))
+
+	bp.Timestamp = unixMillis(now.Add(maxClockSkew))
+	assert.Nil(t, validateTimestamp(bp, prev, now))
+
+	bp.Timestamp++
+	assert.NotNil(t, validateTimestamp(bp, prev, now))
+
+	empty := EmptyBlockProposal(2, prev)
+	assert.Nil(t, validateTimestamp(empty, prev, now))
+
+	empty.Timestamp++
+	assert.NotNil(t, validateTimestamp(empty, prev, now))
+}
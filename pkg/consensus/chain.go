@@ -16,6 +16,10 @@ const (
 	sysTxnNotImplemented = "system transaction not implemented, will be implemented when open participation is necessary, however, the DEX is fully functional"
 )
 
+// lowRankPenalty is the factor of the weight of a block whose
+// proposer is outranked by a valid block proposal of the same round.
+const lowRankPenalty = 0.5
+
 type blockNode struct {
 	Block  Hash
 	Weight float64
@@ -23,6 +27,19 @@ type blockNode struct {
 	// parent is nil if its parent is finalized
 	parent        *blockNode
 	blockChildren []*blockNode
+	// outranked is true if a sibling has a better ranked proposer
+	// and the rank is enforced, the block then counts as an
+	// empty block in the fork choice.
+	outranked bool
+}
+
+// forkWeight returns the weight of the block in the fork choice.
+func (n *blockNode) forkWeight() float64 {
+	if n.outranked {
+		return emptyBlockWeight
+	}
+
+	return n.Weight
 }
 
 // ChainStatus is the chain consensus state.
@@ -30,6 +47,10 @@ type ChainStatus struct {
 	Round           uint64
 	RandBeaconDepth uint64
 	RoundMetrics    []RoundMetric
+	// Reorgs is the number of times that the heaviest chain
+	// switched to a block not extending the previous heaviest
+	// chain.
+	Reorgs uint64
 }
 
 func (s *ChainStatus) InSync() bool {
@@ -51,6 +72,7 @@ type Chain struct {
 	store        *storage
 	txnPool      TxnPool
 	updater      Updater
+	exec         *execCache
 
 	mu               sync.RWMutex
 	roundMetrics     []RoundMetric
@@ -62,6 +84,23 @@ type Chain struct {
 	fork                  []*blockNode
 	unFinalizedState      map[Hash]State
 	roundWaitCh           map[uint64]chan struct{}
+	// tip is the block of the heaviest chain that the updater
+	// is last updated with.
+	tip    Hash
+	reorgs uint64
+	// headEvents is the latest head events, headNotify is closed
+	// when a new event is added.
+	headEvents []HeadEvent
+	headSeq    uint64
+	headNotify chan struct{}
+	// txnBlocks indexes the blocks that include the txn, and
+	// roundTxns the txns of the round, for the latest
+	// txnIndexRounds rounds.
+	txnBlocks map[Hash][]txnBlock
+	roundTxns map[uint64][]Hash
+	// bestRanks is the best rank of the valid block proposals
+	// seen of the unfinalized rounds.
+	bestRanks map[uint64]uint16
 }
 
 // Updater updates the application layer (DEX) about the current
@@ -76,17 +115,8 @@ func NewChain(genesis *Block, genesisState State, seed Rand, cfg Config, txnPool
 		panic(fmt.Errorf("genesis state hash and block state root does not match, state hash: %v, blocks state root: %v", genesisState.Hash(), genesis.StateRoot))
 	}
 
-	sysState := NewSysState()
-	t := sysState.Transition()
-	for _, txn := range genesis.SysTxns {
-		valid := t.Record(txn)
-		if !valid {
-			panic("sys txn in genesis is invalid")
-		}
-	}
-
+	sysState := genesisSysState(genesis)
 	u.Update(genesisState)
-	sysState = t.Commit()
 	gh := genesis.Hash()
 	store.AddBlock(genesis, gh)
 	return &Chain{
@@ -100,11 +130,30 @@ func NewChain(genesis *Block, genesisState State, seed Rand, cfg Config, txnPool
 		lastFinalizedState:    genesisState,
 		lastFinalizedSysState: sysState,
 		unFinalizedState:      make(map[Hash]State),
+		bestRanks:             make(map[uint64]uint16),
 		roundWaitCh:           make(map[uint64]chan struct{}),
+		exec:                  newExecCache(),
 		lastEndRoundTime:      time.Now(),
+		tip:                   gh,
+		headNotify:            make(chan struct{}),
+		txnBlocks:             make(map[Hash][]txnBlock),
+		roundTxns:             make(map[uint64][]Hash),
 	}
 }
 
+func genesisSysState(genesis *Block) *SysState {
+	sysState := NewSysState()
+	t := sysState.Transition()
+	for _, txn := range genesis.SysTxns {
+		valid := t.Record(txn)
+		if !valid {
+			panic("sys txn in genesis is invalid")
+		}
+	}
+
+	return t.Commit()
+}
+
 // Genesis returns the hash of the genesis block.
 func (c *Chain) Genesis() Hash {
 	c.mu.Lock()
@@ -122,6 +171,7 @@ func (c *Chain) ChainStatus() ChainStatus {
 	s.RandBeaconDepth = c.randomBeacon.Round()
 	s.RoundMetrics = make([]RoundMetric, len(c.roundMetrics))
 	copy(s.RoundMetrics, c.roundMetrics)
+	s.Reorgs = c.reorgs
 	return s
 }
 
@@ -161,39 +211,126 @@ func (c *Chain) ProposeBlock(ctx context.Context, sk SK, round uint64) *BlockPro
 		return nil
 	}
 
-	trans := state.Transition(round, c.proposerPK)
-	recorded := 0
-loop:
-	for i := range txns {
+	valid := txns[:0]
+	for _, txn := range txns {
+		if txn.Expired(round) {
+			c.txnPool.Remove(SHA3(txn.Raw))
+			continue
+		}
+		valid = append(valid, txn)
+	}
+	txns = valid
+
+	// record the txns in the canonical order, otherwise the
+	// block proposal will be rejected.
+	SortTxns(txns, c.randomBeacon.TxnOrderSeed(round))
+	// the timestamp is taken before recording the txns, since
+	// the txns could read it.
+	timestamp := unixMillis(time.Now())
+	if timestamp < block.Timestamp {
+		// the local clock is behind the previous proposer's,
+		// keep the timestamps monotonic.
+		timestamp = block.Timestamp
+	}
+	trans := state.Transition(round, timestamp, c.proposerPK)
+	start := time.Now()
+	end := StartSpan("propose_block", "round", round)
+	r := recordTxns(ctx, trans, txns, c.cfg.MaxBlockTxnBytes, c.txnPool.Remove)
+	end(nil)
+	log.Debug("txns recorded for block proposal", "round", round, "recorded", r.recorded, "bytes", r.bytes, "over size limit", r.oversized, "left for deadline", r.unvisited, "dur", time.Since(start))
+
+	pk := sk.MustPK()
+	txnsBytes := trans.Txns()
+	bp := BlockProposal{
+		Round:     round,
+		PrevBlock: block.Hash(),
+		Txns:      txnsBytes,
+		Owner:     pk.Addr(),
+		Timestamp: timestamp,
+	}
+
+	bp.OwnerSig = sk.Sign(bp.Encode(false))
+	return &bp
+}
+
+// recordResult is the outcome of the txn selection of a block
+// proposal.
+type recordResult struct {
+	recorded int
+	bytes    int
+	// oversized is the number of the txns skipped because they
+	// do not fit in the size limit.
+	oversized int
+	// unvisited is the number of the txns not tried before the
+	// deadline, they stay in the pool for the next proposal.
+	unvisited int
+}
+
+// recordTxns records the txns in order into the transition until the
+// deadline of ctx, it skips the txns that would take the recorded
+// txns over maxBytes (0 means no limit). The invalid txns are removed
+// with remove. The caller finalizes the proposal with whatever is
+// recorded, so a late proposer still proposes in time.
+func recordTxns(ctx context.Context, trans Transition, txns []*Txn, maxBytes int, remove func(Hash)) recordResult {
+	var r recordResult
+	for i, txn := range txns {
 		select {
 		case <-ctx.Done():
-			break loop
+			r.unvisited = len(txns) - i
+			return r
 		default:
 		}
 
-		err := trans.Record(txns[i])
+		if maxBytes > 0 && r.bytes+len(txn.Raw) > maxBytes {
+			// a smaller txn later in the order may still
+			// fit.
+			r.oversized++
+			continue
+		}
+
+		err := trans.Record(txn)
 		if err == nil {
-			recorded++
+			r.recorded++
+			r.bytes += len(txn.Raw)
+			continue
 		}
 
-		if err != nil && err != ErrTxnNonceTooBig {
-			log.Warn("error record txn", "err", err, "miner", txns[i].MinerFeeTxn)
+		if err != ErrTxnNonceTooBig {
+			h := SHA3(txn.Raw)
+			log.Warn("error record txn", "txn", h, "err", err, "miner", txn.MinerFeeTxn)
 			// TODO: handle "lost" txn due to reorg.
-			c.txnPool.Remove(SHA3(txns[i].Raw))
+			remove(h)
 		}
 	}
+	return r
+}
 
-	pk := sk.MustPK()
-	txnsBytes := trans.Txns()
-	bp := BlockProposal{
-		Round:     round,
-		PrevBlock: block.Hash(),
-		Txns:      txnsBytes,
-		Owner:     pk.Addr(),
+// Finality is the latest finalized block. The finalized blocks are
+// never reorged, the txns up to the round are irreversible.
+type Finality struct {
+	Round     uint64
+	Block     Hash
+	StateRoot Hash
+}
+
+// Finality returns the latest finalized block.
+func (c *Chain) Finality() Finality {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h := c.finalized[len(c.finalized)-1]
+	return Finality{
+		Round:     uint64(len(c.finalized) - 1),
+		Block:     h,
+		StateRoot: c.store.Block(h).StateRoot,
 	}
+}
 
-	bp.OwnerSig = sk.Sign(bp.Encode(false))
-	return &bp
+// FinalizedState returns the state of the latest finalized block.
+func (c *Chain) FinalizedState() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastFinalizedState
 }
 
 // FinalizedRound returns the latest finalized round.
@@ -203,6 +340,23 @@ func (c *Chain) FinalizedRound() uint64 {
 	return uint64(len(c.finalized) - 1)
 }
 
+// FinalizedBlocks returns the hashes of the finalized blocks of the
+// rounds in [from, to], to is capped at the latest finalized round.
+func (c *Chain) FinalizedBlocks(from, to uint64) []Hash {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if last := uint64(len(c.finalized) - 1); to > last {
+		to = last
+	}
+
+	if from > to {
+		return nil
+	}
+
+	return append([]Hash(nil), c.finalized[from:to+1]...)
+}
+
 func (c *Chain) round() uint64 {
 	round := len(c.finalized)
 	round += maxHeight(c.fork)
@@ -229,10 +383,10 @@ func maxHeight(ns []*blockNode) int {
 }
 
 func weight(n *blockNode) float64 {
-	w := n.Weight
+	w := n.forkWeight()
 	prev := n.parent
 	for ; prev != nil; prev = prev.parent {
-		w += prev.Weight
+		w += prev.forkWeight()
 	}
 
 	return w
@@ -252,7 +406,7 @@ func heaviestFork(fork []*blockNode, depth int) *blockNode {
 	var r *blockNode
 	for _, n := range nodes {
 		w := weight(n)
-		if w > maxWeight {
+		if r == nil || w > maxWeight {
 			r = n
 			maxWeight = w
 		}
@@ -312,10 +466,56 @@ func (c *Chain) blockState(h Hash) State {
 	return c.unFinalizedState[h]
 }
 
+// seeProposal records the rank of a valid block proposal of the
+// round.
+func (c *Chain) seeProposal(round uint64, rank uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if best, ok := c.bestRanks[round]; !ok || rank < best {
+		c.bestRanks[round] = rank
+	}
+}
+
+// blockWeight returns the weight of the block of the proposer's
+// rank, empty is true for the empty block. The weight is penalized
+// if a better ranked block proposal of the round was seen, so the
+// chain prefers the best proposer's block.
+func (c *Chain) blockWeight(round uint64, rank uint16, empty bool) float64 {
+	if empty {
+		return emptyBlockWeight
+	}
+
+	c.mu.RLock()
+	best, ok := c.bestRanks[round]
+	c.mu.RUnlock()
+
+	w := rankToWeight(rank)
+	if ok && best < rank {
+		w *= lowRankPenalty
+	}
+	return w
+}
+
+// enforceRank marks the node or its siblings that are outranked by a
+// heavier sibling, i.e., a sibling with a better ranked proposer, or
+// a non-empty sibling of an empty block. The outranked blocks are
+// still added, so the chain can follow the network if it builds on
+// them, and the result does not depend on the blocks' arrival order.
+func enforceRank(node *blockNode, siblings []*blockNode) {
+	for _, n := range siblings {
+		if n.Weight > node.Weight {
+			node.outranked = true
+		} else if n.Weight < node.Weight {
+			n.outranked = true
+		}
+	}
+}
+
 // AddBlock adds a block to the chain.
 func (c *Chain) AddBlock(b *Block, s State, weight float64, txnCount int) (bool, error) {
 	hash := b.Hash()
-	log.Debug("add block to chain", "hash", hash)
+	log.Debug("add block to chain", "round", b.Round, "hash", hash)
 	if saved := c.store.Block(hash); saved != nil {
 		return false, nil
 	}
@@ -328,11 +528,16 @@ func (c *Chain) AddBlock(b *Block, s State, weight float64, txnCount int) (bool,
 		return false, fmt.Errorf("block's round is already finalized, round: %d, last finalized round: %d", b.Round, finalizedRound)
 	}
 
+	rankEnforced := c.cfg.RankEnforcementRound > 0 && b.Round >= c.cfg.RankEnforcementRound
 	node := &blockNode{Block: hash, Weight: weight}
 	if b.Round == finalizedRound+1 {
 		if b.PrevBlock != c.finalized[len(c.finalized)-1] {
 			return false, errors.New("block's prev round is finalized, but prev block is not the finalized block")
 		}
+
+		if rankEnforced {
+			enforceRank(node, c.fork)
+		}
 		c.fork = append(c.fork, node)
 		c.unFinalizedState[node.Block] = s
 	} else {
@@ -351,13 +556,18 @@ func (c *Chain) AddBlock(b *Block, s State, weight float64, txnCount int) (bool,
 			panic(fmt.Errorf("should never happen: can not find prev block %v, it should be already synced", b.PrevBlock))
 		}
 
+		if rankEnforced {
+			enforceRank(node, prev.blockChildren)
+		}
 		node.parent = prev
 		prev.blockChildren = append(prev.blockChildren, node)
 	}
 
 	c.store.AddBlock(b, hash)
+	c.indexTxns(b, hash)
 	c.unFinalizedState[node.Block] = s
-	_, leaderState, _ := c.leader()
+	leader, leaderState, _ := c.leader()
+	c.updateTip(leader.Hash())
 
 	round := c.round()
 	if startingRound == b.Round && startingRound+1 == round {
@@ -485,15 +695,91 @@ func (c *Chain) finalize(round uint64) {
 	}
 
 	c.finalized = append(c.finalized, root.Block)
+	c.addHeadEvent(HeadEvent{Type: FinalizedEvent, Round: uint64(len(c.finalized) - 1), Block: root.Block})
+	if n := len(c.finalized); n > hotRounds {
+		h := c.finalized[n-1-hotRounds]
+		if err := c.store.Archive(h); err != nil {
+			log.Error("archive block error", "hash", h, "err", err)
+		}
+	}
+	if n := uint64(len(c.finalized)); n > txnIndexRounds {
+		c.pruneTxnIndex(n - 1 - txnIndexRounds)
+	}
 	c.lastFinalizedState = c.unFinalizedState[root.Block]
 	delete(c.unFinalizedState, root.Block)
+	if f, ok := c.lastFinalizedState.(Finalizer); ok {
+		f.Finalized()
+	}
+	for _, b := range c.fork {
+		if b != root {
+			c.removeBranch(b)
+		}
+	}
 	c.fork = root.blockChildren
 
 	for i := range c.fork {
 		c.fork[i].parent = nil
 	}
 
-	// TODO: delete the state/block/bp of the removed branches from the map
+	for r := range c.bestRanks {
+		if r <= round {
+			delete(c.bestRanks, r)
+		}
+	}
+}
+
+// removeBranch removes the states of the blocks of the branch that
+// can no longer be finalized.
+func (c *Chain) removeBranch(n *blockNode) {
+	delete(c.unFinalizedState, n.Block)
+	for _, child := range n.blockChildren {
+		c.removeBranch(child)
+	}
+}
+
+// updateTip records the block of the heaviest chain, the switch to a
+// block not extending the previous tip is a reorg. The states of the
+// blocks are immutable, so the reorg rolls back the order books, the
+// order expirations and the frozen token schedules of the abandoned
+// branch by switching to the state of the new tip.
+//
+// must be called with mutex held
+func (c *Chain) updateTip(h Hash) {
+	if h == c.tip {
+		return
+	}
+
+	var round uint64
+	if b := c.store.Block(h); b != nil {
+		round = b.Round
+	}
+
+	if !c.extends(h, c.tip) {
+		log.Info("chain reorg", "from", c.tip, "to", h)
+		c.reorgs++
+		e := HeadEvent{Type: ReorgEvent, Round: round, Block: h, OldTip: c.tip}
+		e.Ancestor, e.AncestorRound, _ = c.commonAncestor(c.tip, h)
+		c.addHeadEvent(e)
+	}
+	c.tip = h
+	c.addHeadEvent(HeadEvent{Type: NewHeadEvent, Round: round, Block: h})
+}
+
+// extends returns true if the block is a descendant of the ancestor
+// block.
+func (c *Chain) extends(h, ancestor Hash) bool {
+	a := c.store.Block(ancestor)
+	if a == nil {
+		return false
+	}
+
+	for b := c.store.Block(h); b != nil && b.Round > a.Round; b = c.store.Block(b.PrevBlock) {
+		if b.PrevBlock == ancestor {
+			return true
+		}
+	}
+
+	return false
 }
 
 // Graphviz returns the Graphviz format encoded chain visualization.
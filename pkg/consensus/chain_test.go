@@ -1,7 +1,10 @@
 package consensus
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -22,7 +25,7 @@ func (s *myState) Hash() Hash {
 func (s *myState) CommitCache() {
 }
 
-func (s *myState) Transition(uint64, []byte) Transition {
+func (s *myState) Transition(uint64, uint64, []byte) Transition {
 	return nil
 }
 
@@ -34,7 +37,7 @@ func (s *myState) Deserialize(TrieBlob) error {
 	return nil
 }
 
-func (s *myState) CommitTxns([]byte, TxnPool, uint64) (State, int, error) {
+func (s *myState) CommitTxns([]byte, TxnPool, uint64, uint64, Rand) (State, int, error) {
 	return nil, 0, nil
 }
 
@@ -57,9 +60,9 @@ func TestGraphviz(t *testing.T) {
 	assert.Equal(t, `digraph chain {
 rankdir=LR;
 size="12,8"
-node [shape = rect, style=filled, color = chartreuse2]; block_26df block_0100 block_0200 block_0300 block_0400
+node [shape = rect, style=filled, color = chartreuse2]; block_da6b block_0100 block_0200 block_0300 block_0400
 node [shape = rect, style=filled, color = aquamarine]; block_0700 block_0800 block_0900 block_0c00 block_0d00
-block_26df -> block_0100 -> block_0200 -> block_0300 -> block_0400
+block_da6b -> block_0100 -> block_0200 -> block_0300 -> block_0400
 block_0400 -> block_0700
 block_0700 -> block_0800
 block_0700 -> block_0900
@@ -118,3 +121,245 @@ func TestHeaviestFork(t *testing.T) {
 	assert.Equal(t, n1, r)
 	assert.Equal(t, 4, maxHeight(fork))
 }
+
+func TestChainReorg(t *testing.T) {
+	genesis := &Block{}
+	chain := NewChain(genesis, &myState{}, Rand{}, Config{}, nil, &myUpdater{}, newStorage(), nil)
+	b1 := &Block{Round: 1, PrevBlock: genesis.Hash()}
+	b2 := &Block{Round: 2, PrevBlock: b1.Hash()}
+	b2Fork := &Block{Round: 2, PrevBlock: b1.Hash(), Owner: Addr{1}}
+	b3 := &Block{Round: 3, PrevBlock: b2.Hash()}
+	for _, b := range []*Block{b1, b2, b2Fork, b3} {
+		chain.store.AddBlock(b, b.Hash())
+	}
+
+	chain.updateTip(b1.Hash())
+	chain.updateTip(b2.Hash())
+	assert.Equal(t, 0, int(chain.ChainStatus().Reorgs))
+	chain.updateTip(b2Fork.Hash())
+	assert.Equal(t, 1, int(chain.ChainStatus().Reorgs))
+	chain.updateTip(b3.Hash())
+	assert.Equal(t, 2, int(chain.ChainStatus().Reorgs))
+	assert.True(t, chain.extends(b3.Hash(), b1.Hash()))
+	assert.False(t, chain.extends(b3.Hash(), b2Fork.Hash()))
+}
+
+func TestChainHeadEvents(t *testing.T) {
+	genesis := &Block{}
+	chain := NewChain(genesis, &myState{}, Rand{}, Config{}, nil, &myUpdater{}, newStorage(), nil)
+	b1 := &Block{Round: 1, PrevBlock: genesis.Hash()}
+	b2 := &Block{Round: 2, PrevBlock: b1.Hash()}
+	b2Fork := &Block{Round: 2, PrevBlock: b1.Hash(), Owner: Addr{1}}
+	b3Fork := &Block{Round: 3, PrevBlock: b2Fork.Hash()}
+	for _, b := range []*Block{b1, b2, b2Fork, b3Fork} {
+		chain.store.AddBlock(b, b.Hash())
+	}
+
+	events, gap, err := chain.HeadEvents(0, 0)
+	assert.Nil(t, err)
+	assert.False(t, gap)
+	assert.Empty(t, events)
+
+	chain.updateTip(b1.Hash())
+	chain.updateTip(b2.Hash())
+	chain.updateTip(b3Fork.Hash())
+	events, gap, err = chain.HeadEvents(1, time.Second)
+	assert.Nil(t, err)
+	assert.False(t, gap)
+	assert.Equal(t, []HeadEvent{
+		{Seq: 2, Type: NewHeadEvent, Round: 2, Block: b2.Hash()},
+		{Seq: 3, Type: ReorgEvent, Round: 3, Block: b3Fork.Hash(), OldTip: b2.Hash(), Ancestor: b1.Hash(), AncestorRound: 1},
+		{Seq: 4, Type: NewHeadEvent, Round: 3, Block: b3Fork.Hash()},
+	}, events)
+
+	_, _, err = chain.HeadEvents(5, 0)
+	assert.NotNil(t, err, "sequence number not reached")
+
+	// a poll waits for the next event.
+	done := make(chan []HeadEvent)
+	go func() {
+		events, _, _ := chain.HeadEvents(4, time.Minute)
+		done <- events
+	}()
+	time.Sleep(10 * time.Millisecond)
+	chain.mu.Lock()
+	chain.addHeadEvent(HeadEvent{Type: FinalizedEvent, Round: 1, Block: b1.Hash()})
+	chain.mu.Unlock()
+	assert.Equal(t, []HeadEvent{{Seq: 5, Type: FinalizedEvent, Round: 1, Block: b1.Hash()}}, <-done)
+
+	// the events no longer kept are a gap.
+	chain.headEvents = chain.headEvents[2:]
+	_, gap, err = chain.HeadEvents(1, 0)
+	assert.Nil(t, err)
+	assert.True(t, gap)
+}
+
+func TestChainFinalizeRemovesBranches(t *testing.T) {
+	chain := NewChain(&Block{}, &myState{}, Rand{}, Config{}, nil, &myUpdater{}, newStorage(), nil)
+	n1 := &blockNode{Block: Hash{1}}
+	n1Fork := &blockNode{Block: Hash{2}}
+	n1Fork.blockChildren = []*blockNode{{Block: Hash{3}, parent: n1Fork}}
+	n2 := &blockNode{Block: Hash{4}, parent: n1}
+	n1.blockChildren = []*blockNode{n2}
+	n2.blockChildren = []*blockNode{{Block: Hash{5}, parent: n2}}
+	chain.fork = []*blockNode{n1, n1Fork}
+	for i := 1; i <= 5; i++ {
+		chain.unFinalizedState[Hash{byte(i)}] = &myState{}
+	}
+
+	// n1 is the only ancestor of the blocks at depth 2.
+	chain.finalize(3)
+	assert.Equal(t, Hash{1}, chain.finalized[1])
+	assert.Equal(t, []HeadEvent{{Seq: 1, Type: FinalizedEvent, Round: 1, Block: Hash{1}}}, chain.headEvents)
+	assert.Equal(t, 2, len(chain.unFinalizedState))
+	assert.NotNil(t, chain.unFinalizedState[Hash{4}])
+	assert.NotNil(t, chain.unFinalizedState[Hash{5}])
+}
+
+func TestChainFinality(t *testing.T) {
+	genesis := &Block{Owner: Addr{1}}
+	s := &myState{}
+	chain := NewChain(genesis, s, Rand{}, Config{}, nil, &myUpdater{}, newStorage(), nil)
+	assert.Equal(t, Finality{Round: 0, Block: genesis.Hash()}, chain.Finality())
+	assert.Equal(t, s, chain.FinalizedState())
+}
+
+// recordingTransition records the txns, it rejects the txns whose
+// first byte is 0 and cancels ctx after the limit of txns.
+type recordingTransition struct {
+	txns   []*Txn
+	limit  int
+	cancel func()
+}
+
+func (t *recordingTransition) Record(txn *Txn) error {
+	if txn.Raw[0] == 0 {
+		return errors.New("invalid txn")
+	}
+
+	t.txns = append(t.txns, txn)
+	if len(t.txns) == t.limit {
+		t.cancel()
+	}
+	return nil
+}
+
+func (t *recordingTransition) Txns() []byte { return nil }
+
+func (t *recordingTransition) Commit() State { return nil }
+
+func (t *recordingTransition) StateHash() Hash { return Hash{} }
+
+func TestRecordTxns(t *testing.T) {
+	txns := []*Txn{
+		{Raw: []byte{1, 1}},
+		{Raw: []byte{0}},
+		{Raw: []byte{1, 1, 1}},
+		{Raw: []byte{1}},
+		{Raw: []byte{1}},
+		{Raw: []byte{1}},
+	}
+
+	var removed []Hash
+	remove := func(h Hash) { removed = append(removed, h) }
+	ctx, cancel := context.WithCancel(context.Background())
+	trans := &recordingTransition{limit: 2, cancel: cancel}
+	r := recordTxns(ctx, trans, txns, 4, remove)
+	// the 3 bytes txn does not fit in the size limit, and the
+	// deadline passes after 2 txns are recorded.
+	assert.Equal(t, recordResult{recorded: 2, bytes: 3, oversized: 1, unvisited: 2}, r)
+	assert.Equal(t, []*Txn{txns[0], txns[3]}, trans.txns)
+	assert.Equal(t, []Hash{SHA3(txns[1].Raw)}, removed)
+
+	// a passed deadline proposes an empty block.
+	r = recordTxns(ctx, &recordingTransition{}, txns, 0, remove)
+	assert.Equal(t, recordResult{unvisited: len(txns)}, r)
+}
+
+func TestBlockWeight(t *testing.T) {
+	chain := NewChain(&Block{}, &myState{}, Rand{}, Config{}, nil, &myUpdater{}, newStorage(), nil)
+	assert.Equal(t, rankToWeight(2), chain.blockWeight(1, 2, false))
+	assert.Equal(t, emptyBlockWeight, chain.blockWeight(1, 0, true))
+
+	chain.seeProposal(1, 3)
+	chain.seeProposal(1, 1)
+	chain.seeProposal(1, 2)
+	assert.Equal(t, rankToWeight(1), chain.blockWeight(1, 1, false))
+	assert.Equal(t, rankToWeight(2)*lowRankPenalty, chain.blockWeight(1, 2, false))
+	assert.Equal(t, rankToWeight(2), chain.blockWeight(2, 2, false), "no proposal seen of round 2")
+}
+
+func TestChainRankEnforcement(t *testing.T) {
+	genesis := &Block{}
+	chain := NewChain(genesis, &myState{}, Rand{}, Config{RankEnforcementRound: 1}, nil, &myUpdater{}, newStorage(), nil)
+	outranked := &Block{Round: 1, PrevBlock: genesis.Hash(), Owner: Addr{1}}
+	chain.store.AddBlock(outranked, outranked.Hash())
+	outrankedNode := &blockNode{Block: outranked.Hash(), Weight: rankToWeight(1)}
+	chain.fork = []*blockNode{outrankedNode}
+	chain.unFinalizedState[outranked.Hash()] = &myState{}
+
+	// the better ranked block arriving later outranks the added
+	// sibling, the outranked blocks count as empty blocks.
+	best := &Block{Round: 1, PrevBlock: genesis.Hash()}
+	added, err := chain.AddBlock(best, &myState{}, rankToWeight(0), 0)
+	assert.Nil(t, err)
+	assert.True(t, added)
+	assert.True(t, outrankedNode.outranked)
+	assert.Equal(t, emptyBlockWeight, weight(outrankedNode))
+	assert.Equal(t, best.Hash(), chain.tip)
+
+	added, err = chain.AddBlock(&Block{Round: 1, PrevBlock: genesis.Hash(), Owner: Addr{2}}, &myState{}, rankToWeight(2), 0)
+	assert.Nil(t, err)
+	assert.True(t, added)
+	assert.True(t, chain.fork[2].outranked)
+	assert.False(t, chain.fork[1].outranked)
+	assert.Equal(t, best.Hash(), chain.tip)
+
+	// the network can build on an outranked block.
+	bestNode := chain.fork[1]
+	bestChild := &Block{Round: 2, PrevBlock: best.Hash()}
+	chain.store.AddBlock(bestChild, bestChild.Hash())
+	bestNode.blockChildren = []*blockNode{{Block: bestChild.Hash(), Weight: rankToWeight(1), parent: bestNode}}
+	chain.unFinalizedState[bestChild.Hash()] = &myState{}
+	child := &Block{Round: 2, PrevBlock: outranked.Hash(), Owner: Addr{1}}
+	added, err = chain.AddBlock(child, &myState{}, rankToWeight(0), 0)
+	assert.Nil(t, err)
+	assert.True(t, added)
+	assert.Equal(t, bestChild.Hash(), chain.tip)
+
+	// no enforcement before the round.
+	chain.cfg.RankEnforcementRound = 3
+	added, err = chain.AddBlock(&Block{Round: 2, PrevBlock: best.Hash(), Owner: Addr{3}}, &myState{}, rankToWeight(2), 0)
+	assert.Nil(t, err)
+	assert.True(t, added)
+	assert.False(t, bestNode.blockChildren[1].outranked)
+}
+
+func TestChainReorgSwitchesState(t *testing.T) {
+	genesis := &Block{StateRoot: (&simState{}).Hash()}
+	chain := NewChain(genesis, &simState{}, Rand{}, Config{}, nil, &myUpdater{}, newStorage(), nil)
+	b1 := &Block{Round: 1, PrevBlock: genesis.Hash(), Owner: Addr{1}}
+	b2 := &Block{Round: 2, PrevBlock: b1.Hash(), Owner: Addr{1}}
+	s2 := &simState{round: 2}
+	n1 := &blockNode{Block: b1.Hash(), Weight: 1}
+	n1.blockChildren = []*blockNode{{Block: b2.Hash(), Weight: 1, parent: n1}}
+	chain.fork = []*blockNode{n1}
+	for _, b := range []*Block{b1, b2} {
+		chain.store.AddBlock(b, b.Hash())
+	}
+	chain.unFinalizedState[b1.Hash()] = &simState{round: 1}
+	chain.unFinalizedState[b2.Hash()] = s2
+	chain.tip = b2.Hash()
+
+	// the heavier branch wins, its state replaces the state of
+	// the abandoned block.
+	s2Fork := &simState{round: 102}
+	b2Fork := &Block{Round: 2, PrevBlock: b1.Hash(), Owner: Addr{2}}
+	_, err := chain.AddBlock(b2Fork, s2Fork, 2, 0)
+	assert.Nil(t, err)
+	leader, state, _ := chain.Leader()
+	assert.Equal(t, b2Fork.Hash(), leader.Hash())
+	assert.Equal(t, s2Fork, state)
+	assert.Equal(t, 1, int(chain.ChainStatus().Reorgs))
+	assert.Equal(t, s2, chain.BlockState(b2.Hash()), "the abandoned state is kept until finalization")
+}
@@ -0,0 +1,220 @@
+package consensus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	coldDataFile  = "blocks.dat"
+	coldIndexFile = "blocks.idx"
+	// indexEntrySize is the size of an index entry: the hash
+	// followed by the big endian offset of the record.
+	indexEntrySize = 32 + 8
+	// hotRounds is the number of the most recent finalized rounds
+	// whose blocks are kept in memory, the blocks of the older
+	// rounds are moved to the cold storage.
+	hotRounds = 64
+)
+
+// record kinds of the cold storage data file.
+const (
+	blockRecord byte = iota
+	blockProposalRecord
+)
+
+// coldStore stores the immutable blocks and block proposals of the
+// finalized rounds in a flat append-only data file. Each record is
+// the kind byte, the big endian uint32 length and the rlp encoded
+// item. The offsets of the records are appended to the index file,
+// which is loaded into memory when the store is opened.
+type coldStore struct {
+	mu      sync.Mutex
+	data    *os.File
+	index   *os.File
+	size    int64
+	offsets map[Hash]int64
+}
+
+func openColdStore(dir string) (*coldStore, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.OpenFile(filepath.Join(dir, coldDataFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := os.OpenFile(filepath.Join(dir, coldIndexFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+
+	s := &coldStore{data: data, index: index, offsets: make(map[Hash]int64)}
+	err = s.load()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load reads the index, the partial index entry and the records not
+// indexed (e.g., interrupted by a crash) are truncated.
+func (s *coldStore) load() error {
+	b, err := ioutil.ReadAll(s.index)
+	if err != nil {
+		return err
+	}
+
+	complete := len(b) - len(b)%indexEntrySize
+	err = s.index.Truncate(int64(complete))
+	if err != nil {
+		return err
+	}
+
+	var end int64
+	for i := 0; i < complete; i += indexEntrySize {
+		var h Hash
+		copy(h[:], b[i:i+32])
+		offset := int64(binary.BigEndian.Uint64(b[i+32 : i+indexEntrySize]))
+		s.offsets[h] = offset
+
+		_, size, err := s.readHeader(offset)
+		if err != nil {
+			return fmt.Errorf("cold storage index points to invalid record: %v", err)
+		}
+
+		if e := offset + 5 + int64(size); e > end {
+			end = e
+		}
+	}
+
+	s.size = end
+	return s.data.Truncate(end)
+}
+
+func (s *coldStore) readHeader(offset int64) (byte, uint32, error) {
+	var header [5]byte
+	_, err := s.data.ReadAt(header[:], offset)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return header[0], binary.BigEndian.Uint32(header[1:]), nil
+}
+
+func (s *coldStore) put(kind byte, h Hash, v interface{}) error {
+	b, err := rlp.EncodeToBytes(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.offsets[h]; ok {
+		return nil
+	}
+
+	record := make([]byte, 5+len(b))
+	record[0] = kind
+	binary.BigEndian.PutUint32(record[1:], uint32(len(b)))
+	copy(record[5:], b)
+	_, err = s.data.WriteAt(record, s.size)
+	if err != nil {
+		return err
+	}
+
+	var entry [indexEntrySize]byte
+	copy(entry[:], h[:])
+	binary.BigEndian.PutUint64(entry[32:], uint64(s.size))
+	_, err = s.index.Write(entry[:])
+	if err != nil {
+		return err
+	}
+
+	s.offsets[h] = s.size
+	s.size += int64(len(record))
+	return nil
+}
+
+func (s *coldStore) get(kind byte, h Hash, v interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offset, ok := s.offsets[h]
+	if !ok {
+		return false, nil
+	}
+
+	k, size, err := s.readHeader(offset)
+	if err != nil {
+		return false, err
+	}
+
+	if k != kind {
+		return false, errors.New("cold storage record kind mismatch")
+	}
+
+	b := make([]byte, size)
+	_, err = s.data.ReadAt(b, offset+5)
+	if err != nil {
+		return false, err
+	}
+
+	return true, rlp.DecodeBytes(b, v)
+}
+
+// PutBlock appends the block to the cold storage.
+func (s *coldStore) PutBlock(b *Block, h Hash) error {
+	return s.put(blockRecord, h, b)
+}
+
+// PutBlockProposal appends the block proposal to the cold storage.
+func (s *coldStore) PutBlockProposal(bp *BlockProposal, h Hash) error {
+	return s.put(blockProposalRecord, h, bp)
+}
+
+// Block returns the block of the hash, nil if it is not stored.
+func (s *coldStore) Block(h Hash) (*Block, error) {
+	var b Block
+	ok, err := s.get(blockRecord, h, &b)
+	if !ok || err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+// BlockProposal returns the block proposal of the hash, nil if it is
+// not stored.
+func (s *coldStore) BlockProposal(h Hash) (*BlockProposal, error) {
+	var bp BlockProposal
+	ok, err := s.get(blockProposalRecord, h, &bp)
+	if !ok || err != nil {
+		return nil, err
+	}
+
+	return &bp, nil
+}
+
+// Close closes the files of the cold storage.
+func (s *coldStore) Close() error {
+	err := s.data.Close()
+	if e := s.index.Close(); err == nil {
+		err = e
+	}
+	return err
+}
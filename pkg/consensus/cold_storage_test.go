@@ -0,0 +1,67 @@
+package consensus
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColdStorageArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cold")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cold, err := openColdStore(dir)
+	if err != nil {
+		panic(err)
+	}
+
+	s := newStorage()
+	s.cold = cold
+	bp := &BlockProposal{Round: 1, PrevBlock: Hash{1}, Txns: []byte{1, 2}, Owner: Addr{2}, Timestamp: 3}
+	b := &Block{Round: 1, PrevBlock: Hash{1}, BlockProposal: bp.Hash(), Notarization: Sig{4}}
+	s.AddBlockProposal(bp, bp.Hash())
+	s.AddBlock(b, b.Hash())
+
+	err = s.Archive(b.Hash())
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Nil(t, s.blocks[b.Hash()])
+	assert.Nil(t, s.blockProposals[bp.Hash()])
+	assert.Equal(t, b.Hash(), s.Block(b.Hash()).Hash())
+	assert.Equal(t, bp.Hash(), s.BlockProposal(bp.Hash()).Hash())
+	assert.Nil(t, s.Block(Hash{9}))
+
+	// an unindexed partial record is truncated on reopen.
+	_, err = cold.data.WriteAt([]byte{blockRecord, 0, 0}, cold.size)
+	if err != nil {
+		panic(err)
+	}
+	err = cold.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	cold, err = openColdStore(dir)
+	if err != nil {
+		panic(err)
+	}
+	defer cold.Close()
+
+	info, err := os.Stat(filepath.Join(dir, coldDataFile))
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, cold.size, info.Size())
+
+	restored, err := cold.Block(b.Hash())
+	assert.Nil(t, err)
+	assert.Equal(t, b.Hash(), restored.Hash())
+}
@@ -1,6 +1,7 @@
 package consensus
 
 import (
+	"sort"
 	"sync"
 
 	lru "github.com/hashicorp/golang-lru"
@@ -15,6 +16,18 @@ type collector struct {
 	mu         sync.Mutex
 	mergeItems map[Hash][]Hash
 	items      map[Hash]interface{}
+	// signers is the signers of the collected items by target,
+	// a signer's item is only counted once towards the
+	// threshold.
+	signers map[Hash]map[Addr]bool
+}
+
+// ShareProgress is the number of the shares collected for a target,
+// e.g., a block proposal waiting for its notarization.
+type ShareProgress struct {
+	Target    string
+	Shares    int
+	Threshold int
 }
 
 func newCollector(threshold int) *collector {
@@ -28,6 +41,7 @@ func newCollector(threshold int) *collector {
 		merged:     c,
 		mergeItems: make(map[Hash][]Hash),
 		items:      make(map[Hash]interface{}),
+		signers:    make(map[Hash]map[Addr]bool),
 	}
 }
 
@@ -38,10 +52,14 @@ func (c *collector) Remove(target Hash) {
 		delete(c.items, current[i])
 	}
 	delete(c.mergeItems, target)
+	delete(c.signers, target)
 	c.mu.Unlock()
 }
 
-func (c *collector) Add(target Hash, itemHash Hash, item interface{}) ([]interface{}, bool) {
+// Add adds the item of the signer, it returns the items to merge
+// once the threshold is reached, and whether the item is new and
+// should be broadcasted.
+func (c *collector) Add(target Hash, itemHash Hash, signer Addr, item interface{}) ([]interface{}, bool) {
 	if c.merged.Contains(target) {
 		// already merged before
 		return nil, false
@@ -54,6 +72,13 @@ func (c *collector) Add(target Hash, itemHash Hash, item interface{}) ([]interfa
 		return nil, false
 	}
 
+	signers := c.signers[target]
+	if signers[signer] {
+		// a different item of the same signer
+		c.mu.Unlock()
+		return nil, false
+	}
+
 	current := c.mergeItems[target]
 	if len(current)+1 >= c.threshold {
 		items := make([]interface{}, c.threshold)
@@ -69,10 +94,31 @@ func (c *collector) Add(target Hash, itemHash Hash, item interface{}) ([]interfa
 
 	c.mergeItems[target] = append(current, itemHash)
 	c.items[itemHash] = item
+	if signers == nil {
+		signers = make(map[Addr]bool)
+		c.signers[target] = signers
+	}
+	signers[signer] = true
 	c.mu.Unlock()
 	return nil, true
 }
 
+// Progress returns the targets that have not reached the threshold
+// yet, sorted by the target.
+func (c *collector) Progress() []ShareProgress {
+	c.mu.Lock()
+	r := make([]ShareProgress, 0, len(c.mergeItems))
+	for target, items := range c.mergeItems {
+		r = append(r, ShareProgress{Target: target.Hex(), Shares: len(items), Threshold: c.threshold})
+	}
+	c.mu.Unlock()
+
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Target < r[j].Target
+	})
+	return r
+}
+
 func (c *collector) Get(itemHash Hash) interface{} {
 	c.mu.Lock()
 	r := c.items[itemHash]
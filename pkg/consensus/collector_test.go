@@ -0,0 +1,53 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectorDedupSigner(t *testing.T) {
+	c := newCollector(3)
+	target := SHA3([]byte("bp"))
+	a := SHA3([]byte("a")).Addr()
+	b := SHA3([]byte("b")).Addr()
+	d := SHA3([]byte("d")).Addr()
+
+	items, broadcast := c.Add(target, SHA3([]byte("a0")), a, "a0")
+	assert.Nil(t, items)
+	assert.True(t, broadcast)
+
+	// the same item and a different item of the same signer are
+	// not counted again.
+	items, broadcast = c.Add(target, SHA3([]byte("a0")), a, "a0")
+	assert.Nil(t, items)
+	assert.False(t, broadcast)
+	items, broadcast = c.Add(target, SHA3([]byte("a1")), a, "a1")
+	assert.Nil(t, items)
+	assert.False(t, broadcast)
+
+	items, _ = c.Add(target, SHA3([]byte("b0")), b, "b0")
+	assert.Nil(t, items)
+	assert.Equal(t, []ShareProgress{{Target: target.Hex(), Shares: 2, Threshold: 3}}, c.Progress())
+
+	items, _ = c.Add(target, SHA3([]byte("d0")), d, "d0")
+	assert.Equal(t, []interface{}{"d0", "a0", "b0"}, items)
+
+	c.Remove(target)
+	assert.Empty(t, c.Progress())
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := Config{GroupSize: 5, GroupThreshold: 3}
+	assert.Nil(t, cfg.Validate())
+	assert.Equal(t, 3, cfg.ntThreshold())
+
+	cfg.NotarizationThreshold = 4
+	assert.Nil(t, cfg.Validate())
+	assert.Equal(t, 4, cfg.ntThreshold())
+
+	cfg.NotarizationThreshold = 2
+	assert.NotNil(t, cfg.Validate())
+	cfg.NotarizationThreshold = 6
+	assert.NotNil(t, cfg.Validate())
+}
@@ -21,6 +21,8 @@ func init() {
 	var i []unicastAddr
 	var j ack
 	var k *NtShare
+	var l HistoryRequest
+	var m *HistoryResponse
 
 	gob.Register(a)
 	gob.Register(b)
@@ -33,6 +35,8 @@ func init() {
 	gob.Register(i)
 	gob.Register(j)
 	gob.Register(k)
+	gob.Register(l)
+	gob.Register(m)
 }
 
 type packet struct {
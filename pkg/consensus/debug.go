@@ -0,0 +1,182 @@
+package consensus
+
+import (
+	"fmt"
+	"sort"
+)
+
+// debugCommitteeRounds is the max number of the recent rounds whose
+// committees are included in the debug state.
+const debugCommitteeRounds = 8
+
+// DebugState is the consensus progress of the node, it's for the
+// operators diagnosing stuck rounds.
+type DebugState struct {
+	Addr            string
+	Round           uint64
+	RandBeaconDepth uint64
+	FinalizedRound  uint64
+	// Replica is true if the node is a read-only replica.
+	Replica bool
+	// Groups is the groups that the node is a member of.
+	Groups []int
+	// Committees is the committees of the recent rounds after
+	// the last finalized round.
+	Committees []RoundCommittees
+	// Proposals is the block proposals of the latest round that
+	// the node has received.
+	Proposals []ProposalInfo
+	// QueuedProposals is the number of the proposals queued for
+	// the notarization of the rounds not started yet, by round.
+	QueuedProposals map[uint64]int
+	// NotarizingRounds is the rounds that the node is notarizing.
+	NotarizingRounds []uint64
+	// NtShares and RandBeaconShares are the numbers of the
+	// shares of the latest round that the node has received.
+	NtShares         int
+	RandBeaconShares int
+	// NtThreshold is the number of the notarization shares
+	// needed to notarize a block proposal.
+	NtThreshold int
+	// NtShareProgress is the notarization shares collected for
+	// the block proposals not notarized yet, by the distinct
+	// notaries.
+	NtShareProgress []ShareProgress
+	Peers           []string
+}
+
+// RoundCommittees is the groups selected for a round.
+type RoundCommittees struct {
+	Round        uint64
+	RandBeacon   int
+	Proposal     int
+	Notarization int
+}
+
+// ProposalInfo describes a received block proposal.
+type ProposalInfo struct {
+	Round     uint64
+	Hash      string
+	Owner     string
+	PrevBlock string
+	// TxnBytes is the size of the serialized txns.
+	TxnBytes int
+}
+
+// CommitteeAssignment is the committees of a round with their
+// members, it's for the operators verifying the duties of the node.
+type CommitteeAssignment struct {
+	Round             uint64
+	RandBeaconGroup   int
+	RandBeacon        []CommitteeMember
+	ProposalGroup     int
+	Proposal          []CommitteeMember
+	NotarizationGroup int
+	Notarization      []CommitteeMember
+}
+
+// CommitteeMember is a member of a committee. Self is true if the
+// member is the node.
+type CommitteeMember struct {
+	Addr string
+	Self bool
+	// Rank is the rank of the block proposer, the proposal of
+	// rank 0 is preferred. It's only set in the block proposal
+	// committee, which is sorted by the rank.
+	Rank uint16
+}
+
+// CommitteeAssignment returns the committees of the round, the
+// round must be reached by the random beacon and not pruned.
+func (n *Node) CommitteeAssignment(round uint64) (*CommitteeAssignment, error) {
+	rb := n.chain.randomBeacon
+	if !rb.HasRound(round) {
+		return nil, fmt.Errorf("committees of round %d are unknown, random beacon round: %d", round, rb.Round())
+	}
+
+	a := &CommitteeAssignment{Round: round}
+	a.RandBeaconGroup, a.ProposalGroup, a.NotarizationGroup = rb.Committees(round)
+	members := func(groupID int) []CommitteeMember {
+		var r []CommitteeMember
+		for _, addr := range rb.groups[groupID].Members {
+			r = append(r, CommitteeMember{Addr: addr.Encode(n.cfg.NetworkID), Self: addr == n.addr})
+		}
+		return r
+	}
+
+	a.RandBeacon = members(a.RandBeaconGroup)
+	a.Notarization = members(a.NotarizationGroup)
+	if round > 0 {
+		for _, addr := range rb.groups[a.ProposalGroup].Members {
+			rank, err := rb.Rank(addr, round)
+			if err != nil {
+				return nil, err
+			}
+
+			a.Proposal = append(a.Proposal, CommitteeMember{Addr: addr.Encode(n.cfg.NetworkID), Self: addr == n.addr, Rank: rank})
+		}
+		sort.Slice(a.Proposal, func(i, j int) bool {
+			return a.Proposal[i].Rank < a.Proposal[j].Rank
+		})
+	}
+	return a, nil
+}
+
+// DebugState returns the current debug state of the node.
+func (n *Node) DebugState() *DebugState {
+	s := &DebugState{
+		Addr:            n.addr.Encode(n.cfg.NetworkID),
+		RandBeaconDepth: n.chain.randomBeacon.Round(),
+		FinalizedRound:  n.chain.FinalizedRound(),
+		QueuedProposals: make(map[uint64]int),
+		Replica:         n.Replica(),
+	}
+
+	n.mu.Lock()
+	s.Round = n.round
+	for _, m := range n.memberships {
+		s.Groups = append(s.Groups, m.groupID)
+	}
+	for round, bps := range n.bpForNotary {
+		s.QueuedProposals[round] = len(bps)
+	}
+	for round := range n.notarizeChs {
+		s.NotarizingRounds = append(s.NotarizingRounds, round)
+	}
+	n.mu.Unlock()
+	sort.Slice(s.NotarizingRounds, func(i, j int) bool {
+		return s.NotarizingRounds[i] < s.NotarizingRounds[j]
+	})
+
+	from := s.FinalizedRound + 1
+	if s.RandBeaconDepth >= debugCommitteeRounds && from < s.RandBeaconDepth-debugCommitteeRounds+1 {
+		from = s.RandBeaconDepth - debugCommitteeRounds + 1
+	}
+	for round := from; round <= s.RandBeaconDepth; round++ {
+		rb, bp, nt := n.chain.randomBeacon.Committees(round)
+		s.Committees = append(s.Committees, RoundCommittees{Round: round, RandBeacon: rb, Proposal: bp, Notarization: nt})
+	}
+
+	for _, bp := range n.store.LastRoundBlockProposals() {
+		s.Proposals = append(s.Proposals, ProposalInfo{
+			Round:     bp.Round,
+			Hash:      bp.Hash().Hex(),
+			Owner:     bp.Owner.Encode(n.cfg.NetworkID),
+			PrevBlock: bp.PrevBlock.Hex(),
+			TxnBytes:  len(bp.Txns),
+		})
+	}
+	sort.Slice(s.Proposals, func(i, j int) bool {
+		return s.Proposals[i].Hash < s.Proposals[j].Hash
+	})
+
+	s.NtShares = len(n.store.LastRoundNtShares())
+	s.RandBeaconShares = len(n.store.LastRoundRandBeaconSigShares())
+	s.NtThreshold = n.cfg.ntThreshold()
+	s.NtShareProgress = n.gateway.ntShareCollector.Progress()
+	for _, p := range n.gateway.net.Peers() {
+		s.Peers = append(s.Peers, p.Addr)
+	}
+	sort.Strings(s.Peers)
+	return s
+}
@@ -0,0 +1,152 @@
+package consensus
+
+import (
+	"sync"
+
+	log "github.com/helinwang/log15"
+)
+
+// execCacheSize is the max number of the executed block proposals
+// kept in the cache.
+const execCacheSize = 64
+
+// execKey identifies the execution of the txns of a block proposal,
+// the result is deterministic given the parent state, the round, the
+// timestamp and the txns.
+type execKey struct {
+	Parent    Hash
+	Round     uint64
+	Timestamp uint64
+	Txns      Hash
+}
+
+type execResult struct {
+	done  chan struct{}
+	state State
+	count int
+	err   error
+	// removed is the txns that the execution removed from the
+	// pool.
+	removed []Hash
+}
+
+// deferredPool defers the removals from the txn pool. A speculative
+// execution must not remove the txns of a proposal that may not win
+// the round, the removals are applied when the execution is used.
+type deferredPool struct {
+	TxnPool
+	removed []Hash
+}
+
+func (p *deferredPool) Remove(hash Hash) {
+	p.removed = append(p.removed, hash)
+}
+
+// execCache caches the states produced by executing the txns of the
+// block proposals. A proposal is executed by the notary, by the
+// syncer when its block arrives, and speculatively when it is
+// received, the cache makes them share a single execution.
+type execCache struct {
+	mu    sync.Mutex
+	m     map[execKey]*execResult
+	order []execKey
+	// best is the best rank of the proposals pre-executed for
+	// each round.
+	best map[uint64]uint16
+}
+
+func newExecCache() *execCache {
+	return &execCache{m: make(map[execKey]*execResult), best: make(map[uint64]uint16)}
+}
+
+// execute returns the result of applying the txns to the parent
+// state. Concurrent calls with the same key wait for the first one.
+func (c *execCache) execute(parent State, txns []byte, pool TxnPool, round, timestamp uint64, seed Rand) *execResult {
+	key := execKey{Parent: parent.Hash(), Round: round, Timestamp: timestamp, Txns: SHA3(txns)}
+	c.mu.Lock()
+	r, ok := c.m[key]
+	if ok {
+		c.mu.Unlock()
+		<-r.done
+		return r
+	}
+
+	r = &execResult{done: make(chan struct{})}
+	c.m[key] = r
+	c.order = append(c.order, key)
+	if len(c.order) > execCacheSize {
+		delete(c.m, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.mu.Unlock()
+
+	p := &deferredPool{TxnPool: pool}
+	r.state, r.count, r.err = parent.CommitTxns(txns, p, round, timestamp, seed)
+	r.removed = p.removed
+	close(r.done)
+	return r
+}
+
+// shouldPreExecute returns true if the proposal of the rank is better
+// than the proposals pre-executed for the round. Only the likely
+// winner is pre-executed, to avoid executing every proposal on the
+// nodes that would only execute the notarized block.
+func (c *execCache) shouldPreExecute(round uint64, rank uint16) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	best, ok := c.best[round]
+	if ok && best <= rank {
+		return false
+	}
+
+	c.best[round] = rank
+	for r := range c.best {
+		if r+execCacheSize < round {
+			delete(c.best, r)
+		}
+	}
+	return true
+}
+
+// preExecute executes the txns of the block proposal in the
+// background before it is notarized, so that the notarization and
+// the block validation reuse the result.
+func (c *Chain) preExecute(bp *BlockProposal) {
+	if bp.Empty() {
+		return
+	}
+
+	rank, err := c.randomBeacon.Rank(bp.Owner, bp.Round)
+	if err != nil || !c.exec.shouldPreExecute(bp.Round, rank) {
+		return
+	}
+
+	state := c.BlockState(bp.PrevBlock)
+	if state == nil {
+		return
+	}
+
+	end := StartSpan("pre_execute", "round", bp.Round, "block", bp.Hash())
+	r := c.exec.execute(state, bp.Txns, c.txnPool, bp.Round, bp.Timestamp, c.randomBeacon.TxnOrderSeed(bp.Round))
+	end(r.err)
+	if r.err != nil {
+		log.Debug("pre-executing block proposal failed", "round", bp.Round, "block", bp.Hash(), "err", r.err)
+	}
+}
+
+// commitTxns applies the txns of the block proposal to the parent
+// state, reusing the result of a previous execution.
+func (c *Chain) commitTxns(parent State, bp *BlockProposal, pool TxnPool) (State, int, error) {
+	end := StartSpan("commit_txns", "round", bp.Round, "block", bp.Hash())
+	r := c.exec.execute(parent, bp.Txns, pool, bp.Round, bp.Timestamp, c.randomBeacon.TxnOrderSeed(bp.Round))
+	end(r.err)
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+
+	for _, h := range r.removed {
+		pool.Remove(h)
+	}
+	return r.state, r.count, nil
+}
@@ -0,0 +1,76 @@
+package consensus
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingState counts the executions, it removes the txns from the
+// pool like the application state does.
+type countingState struct {
+	simState
+	mu    sync.Mutex
+	execs int
+}
+
+func (s *countingState) CommitTxns(txns []byte, pool TxnPool, round, timestamp uint64, seed Rand) (State, int, error) {
+	s.mu.Lock()
+	s.execs++
+	s.mu.Unlock()
+	pool.Remove(SHA3(txns))
+	return &simState{round: round}, 1, nil
+}
+
+type removalPool struct {
+	simTxnPool
+	removed []Hash
+}
+
+func (p *removalPool) Remove(hash Hash) {
+	p.removed = append(p.removed, hash)
+}
+
+func TestExecCache(t *testing.T) {
+	c := newExecCache()
+	parent := &countingState{}
+	pool := &removalPool{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := c.execute(parent, []byte{1}, pool, 1, 0, Rand{})
+			assert.Nil(t, r.err)
+			assert.Equal(t, (&simState{round: 1}).Hash(), r.state.Hash())
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, parent.execs)
+	// the removals are deferred to the user of the result.
+	assert.Empty(t, pool.removed)
+	assert.Equal(t, []Hash{SHA3([]byte{1})}, c.execute(parent, []byte{1}, pool, 1, 0, Rand{}).removed)
+
+	c.execute(parent, []byte{2}, pool, 1, 0, Rand{})
+	c.execute(parent, []byte{1}, pool, 2, 0, Rand{})
+	// the txns could read the timestamp.
+	c.execute(parent, []byte{1}, pool, 1, 1000, Rand{})
+	assert.Equal(t, 4, parent.execs)
+
+	for i := 0; i < execCacheSize; i++ {
+		c.execute(parent, []byte{3}, pool, uint64(i+3), 0, Rand{})
+	}
+	c.execute(parent, []byte{1}, pool, 1, 0, Rand{})
+	assert.Equal(t, 4+execCacheSize+1, parent.execs, "evicted")
+}
+
+func TestExecCacheShouldPreExecute(t *testing.T) {
+	c := newExecCache()
+	assert.True(t, c.shouldPreExecute(1, 2))
+	assert.False(t, c.shouldPreExecute(1, 2))
+	assert.False(t, c.shouldPreExecute(1, 3))
+	assert.True(t, c.shouldPreExecute(1, 0))
+	assert.True(t, c.shouldPreExecute(2, 5))
+}
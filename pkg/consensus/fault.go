@@ -0,0 +1,162 @@
+//go:build chaos
+// +build chaos
+
+package consensus
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+// Fault is the faults injected into the consensus components, for
+// exercising the liveness and the recovery of the notarization and
+// the random beacon layers in the tests. It's only available in the
+// builds with the chaos tag, the hooks are no-ops otherwise.
+type Fault struct {
+	// Nodes are the nodes that the faults are injected into, all
+	// the nodes if empty.
+	Nodes []Addr
+	// DropSharePercent is the percent of the notarization shares
+	// and the random beacon signature shares produced by the
+	// nodes that are dropped before sent.
+	DropSharePercent int
+	// CorruptSharePercent is the percent of the shares whose
+	// signature share is corrupted, the owner's signature stays
+	// valid so the share reaches the collectors.
+	CorruptSharePercent int
+	// ProposalDelay delays the block proposals of the nodes.
+	ProposalDelay time.Duration
+	// Seed seeds the random decisions of the drops and the
+	// corruptions.
+	Seed int64
+}
+
+// FaultStats is the number of the injected faults.
+type FaultStats struct {
+	DroppedShares    int
+	CorruptedShares  int
+	DelayedProposals int
+}
+
+var faults struct {
+	mu    sync.Mutex
+	f     Fault
+	nodes map[Addr]bool
+	rand  *rand.Rand
+	stats FaultStats
+}
+
+// InjectFault replaces the injected faults with f, and resets the
+// stats.
+func InjectFault(f Fault) {
+	faults.mu.Lock()
+	defer faults.mu.Unlock()
+
+	faults.f = f
+	faults.nodes = make(map[Addr]bool)
+	for _, n := range f.Nodes {
+		faults.nodes[n] = true
+	}
+	faults.rand = rand.New(rand.NewSource(f.Seed))
+	faults.stats = FaultStats{}
+}
+
+// ClearFault stops injecting the faults, it returns the stats of the
+// faults injected.
+func ClearFault() FaultStats {
+	faults.mu.Lock()
+	defer faults.mu.Unlock()
+
+	stats := faults.stats
+	faults.f = Fault{}
+	faults.nodes = nil
+	faults.stats = FaultStats{}
+	return stats
+}
+
+// InjectedFaults returns the stats of the faults injected.
+func InjectedFaults() FaultStats {
+	faults.mu.Lock()
+	defer faults.mu.Unlock()
+
+	return faults.stats
+}
+
+// must be called with faults.mu held
+func faultTargeted(owner Addr) bool {
+	return len(faults.nodes) == 0 || faults.nodes[owner]
+}
+
+// must be called with faults.mu held
+func faultHit(percent int) bool {
+	return percent > 0 && faults.rand.Intn(100) < percent
+}
+
+func faultDropShare(owner Addr) bool {
+	faults.mu.Lock()
+	defer faults.mu.Unlock()
+
+	if !faultTargeted(owner) || !faultHit(faults.f.DropSharePercent) {
+		return false
+	}
+
+	faults.stats.DroppedShares++
+	log.Warn("fault injected: share dropped", "owner", owner)
+	return true
+}
+
+// faultCorruptShare returns if the share of the owner is to be
+// corrupted.
+func faultCorruptShare(owner Addr) bool {
+	faults.mu.Lock()
+	defer faults.mu.Unlock()
+
+	if !faultTargeted(owner) || !faultHit(faults.f.CorruptSharePercent) {
+		return false
+	}
+
+	faults.stats.CorruptedShares++
+	log.Warn("fault injected: share corrupted", "owner", owner)
+	return true
+}
+
+func corruptSig(s Sig) Sig {
+	c := append(Sig(nil), s...)
+	if len(c) > 0 {
+		c[len(c)-1] ^= 0xff
+	}
+	return c
+}
+
+func faultCorruptNtShare(sk SK, s *NtShare) {
+	if !faultCorruptShare(s.Owner) {
+		return
+	}
+
+	s.SigShare = corruptSig(s.SigShare)
+	s.Sig = sk.Sign(s.Encode(false))
+}
+
+func faultCorruptRandBeaconSigShare(sk SK, s *RandBeaconSigShare) {
+	if !faultCorruptShare(s.Owner) {
+		return
+	}
+
+	s.Share = corruptSig(s.Share)
+	s.OwnerSig = sk.Sign(s.Encode(false))
+}
+
+func faultProposalDelay(owner Addr) time.Duration {
+	faults.mu.Lock()
+	defer faults.mu.Unlock()
+
+	if !faultTargeted(owner) || faults.f.ProposalDelay <= 0 {
+		return 0
+	}
+
+	faults.stats.DelayedProposals++
+	return faults.f.ProposalDelay
+}
@@ -0,0 +1,17 @@
+//go:build !chaos
+// +build !chaos
+
+package consensus
+
+import "time"
+
+// the fault injection hooks are no-ops without the chaos build tag,
+// see fault.go.
+
+func faultDropShare(owner Addr) bool { return false }
+
+func faultCorruptNtShare(sk SK, s *NtShare) {}
+
+func faultCorruptRandBeaconSigShare(sk SK, s *RandBeaconSigShare) {}
+
+func faultProposalDelay(owner Addr) time.Duration { return 0 }
@@ -0,0 +1,49 @@
+//go:build chaos
+// +build chaos
+
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// run with: go test -tags chaos -run TestChaos ./pkg/consensus
+
+func TestChaosFaultyShares(t *testing.T) {
+	net := newSimNet(7)
+	defer net.stop()
+	net.latency = time.Millisecond
+	nodes := makeSimNodes(net, 4)
+	defer ClearFault()
+
+	// a node below the threshold dropping or corrupting its
+	// shares does not stall the chain.
+	InjectFault(Fault{Nodes: []Addr{nodes[0].addr}, DropSharePercent: 100, Seed: 1})
+	assert.True(t, waitRound(nodes, 5, 20*time.Second))
+	assert.True(t, InjectedFaults().DroppedShares > 0)
+
+	InjectFault(Fault{Nodes: []Addr{nodes[1].addr}, CorruptSharePercent: 100, Seed: 1})
+	assert.True(t, waitRound(nodes, nodes[0].Chain().FinalizedRound()+3, 20*time.Second))
+	assert.True(t, ClearFault().CorruptedShares > 0)
+
+	// the chain keeps going with the faults cleared.
+	assert.True(t, waitRound(nodes, nodes[0].Chain().FinalizedRound()+3, 20*time.Second))
+}
+
+func TestChaosDelayedProposals(t *testing.T) {
+	net := newSimNet(8)
+	defer net.stop()
+	net.latency = time.Millisecond
+	nodes := makeSimNodes(net, 4)
+	defer ClearFault()
+
+	// the proposals arriving after the round timeout are
+	// replaced by the empty block proposals.
+	InjectFault(Fault{ProposalDelay: time.Second})
+	assert.True(t, waitRound(nodes, 4, 20*time.Second))
+	assert.True(t, ClearFault().DelayedProposals > 0)
+	assert.True(t, waitRound(nodes, nodes[0].Chain().FinalizedRound()+3, 20*time.Second))
+}
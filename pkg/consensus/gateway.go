@@ -14,7 +14,7 @@ import (
 // in the network.
 type gateway struct {
 	addr                     unicastAddr
-	net                      *network
+	net                      transport
 	chain                    *Chain
 	syncer                   *syncer
 	blockCache               *lru.Cache
@@ -30,6 +30,8 @@ type gateway struct {
 	blockWaiters   map[Hash][]chan *Block
 	bpWaiters      map[Hash][]chan *BlockProposal
 	requestingItem map[Item]bool
+	historyWaiters map[uint64]chan *HistoryResponse
+	historyReqID   uint64
 }
 
 // Item is the identification of an item that the current node owns.
@@ -87,7 +89,7 @@ func (i itemType) String() string {
 	}
 }
 
-func newGateway(net *network, chain *Chain, store *storage, groupThreshold int) *gateway {
+func newGateway(net transport, chain *Chain, store *storage, groupThreshold, ntThreshold int) *gateway {
 	bCache, err := lru.New(1024)
 	if err != nil {
 		panic(err)
@@ -114,7 +116,8 @@ func newGateway(net *network, chain *Chain, store *storage, groupThreshold int)
 		blockWaiters:             make(map[Hash][]chan *Block),
 		bpWaiters:                make(map[Hash][]chan *BlockProposal),
 		requestingItem:           make(map[Item]bool),
-		ntShareCollector:         newCollector(groupThreshold),
+		historyWaiters:           make(map[uint64]chan *HistoryResponse),
+		ntShareCollector:         newCollector(ntThreshold),
 		randBeaconShareCollector: newCollector(groupThreshold),
 	}
 
@@ -313,6 +316,10 @@ func (n *gateway) recvData() {
 			go n.recvInventory(addr, v)
 		case itemRequest:
 			go n.serveData(addr, Item(v))
+		case HistoryRequest:
+			go n.serveHistory(addr, v)
+		case *HistoryResponse:
+			go n.recvHistory(v)
 		default:
 			panic(fmt.Errorf("received unsupported data type: %T", pac.Data))
 		}
@@ -324,7 +331,13 @@ func (n *gateway) broadcast(item Item) {
 }
 
 func (n *gateway) recvTxn(t []byte) {
-	_, broadcast := n.chain.txnPool.Add(t)
+	txn, broadcast := n.chain.txnPool.Add(t)
+	if txn != nil && txn.Expired(n.chain.Round()) {
+		// a stale txn is not kept or relayed.
+		n.chain.txnPool.Remove(SHA3(t))
+		return
+	}
+
 	if broadcast {
 		go n.broadcast(Item{T: txnItem, Hash: SHA3(t)})
 	}
@@ -391,7 +404,7 @@ func (n *gateway) validateNtShare(addr unicastAddr, r *NtShare) bool {
 		go n.broadcast(Item{T: blockProposalItem, Hash: r.BP})
 	}
 
-	b := ntToBlock(r, bp, r.BP)
+	b := ntToBlock(r, bp, r.BP, n.chain.randomBeacon)
 	msg := b.Encode(false)
 	if !r.SigShare.Verify(sharePK, msg) {
 		return false
@@ -401,7 +414,13 @@ func (n *gateway) validateNtShare(addr unicastAddr, r *NtShare) bool {
 }
 
 func (n *gateway) validateRandBeaconSigShare(r *RandBeaconSigShare) (int, bool) {
-	if h := SHA3(n.chain.randomBeacon.sigHistory[r.Round-1].Sig); h != r.LastSigHash {
+	last := n.chain.randomBeacon.RandBeaconSig(r.Round - 1)
+	if last == nil {
+		log.Warn("validate random beacon share of pruned round", "round", r.Round)
+		return 0, false
+	}
+
+	if h := SHA3(last.Sig); h != r.LastSigHash {
 		log.Warn("validate random beacon share last sig error", "hash", r.LastSigHash, "expected", h)
 		return 0, false
 	}
@@ -425,7 +444,7 @@ func (n *gateway) validateRandBeaconSigShare(r *RandBeaconSigShare) (int, bool)
 		return 0, false
 	}
 
-	msg := randBeaconSigMsg(r.Round, r.LastSigHash)
+	msg := n.chain.randomBeacon.sigMsg(r.Round, r.LastSigHash)
 	if !r.Share.Verify(sharePK, msg) {
 		log.Warn("validate random beacon sig share error")
 		return 0, false
@@ -448,7 +467,7 @@ func (n *gateway) recvRandBeaconSigShare(addr unicastAddr, r *RandBeaconSigShare
 		return
 	}
 
-	shares, broadcast := n.randBeaconShareCollector.Add(r.LastSigHash, h, r)
+	shares, broadcast := n.randBeaconShareCollector.Add(r.LastSigHash, h, r.Owner, r)
 	if shares != nil {
 		n.randBeaconShareCollector.Remove(r.LastSigHash)
 		s := make([]*RandBeaconSigShare, len(shares))
@@ -525,7 +544,7 @@ func (n *gateway) recvNtShare(addr unicastAddr, s *NtShare, h Hash) {
 		return
 	}
 
-	shares, broadcastNt := n.ntShareCollector.Add(s.BP, h, s)
+	shares, broadcastNt := n.ntShareCollector.Add(s.BP, h, s.Owner, s)
 	if shares != nil {
 		ss := make([]*NtShare, len(shares))
 		for i := range ss {
@@ -556,13 +575,23 @@ func (n *gateway) recvNtShare(addr unicastAddr, s *NtShare, h Hash) {
 	n.store.KeepLastRoundNtShare(s, h)
 }
 
-func ntToBlock(nt *NtShare, bp *BlockProposal, bpHash Hash) *Block {
+func ntToBlock(nt *NtShare, bp *BlockProposal, bpHash Hash, rb *RandomBeacon) *Block {
+	// the txns of a notarized block proposal always decode, and
+	// the random beacon of the round is reached before the nt
+	// share is validated, the notaries do not notarize it
+	// otherwise.
+	txnRoot, _ := TxnRoot(bp.Txns)
+	beacon, checkpoint, _ := rb.BlockBeacon(bp.Round)
 	b := &Block{
-		Owner:         bp.Owner,
-		Round:         bp.Round,
-		StateRoot:     nt.StateRoot,
-		BlockProposal: bpHash,
-		PrevBlock:     bp.PrevBlock,
+		Owner:          bp.Owner,
+		Round:          bp.Round,
+		StateRoot:      nt.StateRoot,
+		TxnRoot:        txnRoot,
+		RandBeacon:     beacon,
+		RandCheckpoint: checkpoint,
+		BlockProposal:  bpHash,
+		PrevBlock:      bp.PrevBlock,
+		Timestamp:      bp.Timestamp,
 	}
 	return b
 }
@@ -577,7 +606,7 @@ func recoverBlock(shares []*NtShare, bp *BlockProposal, bpHash Hash, rb *RandomB
 
 	_, _, ntGroup := rb.Committees(bp.Round)
 
-	b := ntToBlock(shares[0], bp, bpHash)
+	b := ntToBlock(shares[0], bp, bpHash, rb)
 	msg := b.Encode(false)
 	if !sig.Verify(rb.groups[ntGroup].PK, msg) {
 		panic(fmt.Errorf("should never happen: group %d sig not valid", ntGroup))
@@ -682,12 +711,11 @@ func (n *gateway) serveData(addr unicastAddr, item Item) {
 		}
 		go n.net.Send(addr, packet{Data: share})
 	case randBeaconSigItem:
-		history := n.chain.randomBeacon.History()
-		if item.Round >= uint64(len(history)) {
+		r := n.chain.randomBeacon.RandBeaconSig(item.Round)
+		if r == nil {
 			return
 		}
 
-		r := history[item.Round]
 		go n.net.Send(addr, packet{Data: r})
 	default:
 		panic(fmt.Errorf("unknow requested item type: %v", item.T))
@@ -0,0 +1,130 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/dfinity/go-dfinity-crypto/bls"
+)
+
+// GenesisGroups is the nodes and groups registered in the genesis
+// block.
+type GenesisGroups struct {
+	// SysTxns registers the nodes and the groups.
+	SysTxns []SysTxn
+	// Nodes is the credentials of the registered nodes.
+	Nodes []NodeCredentials
+	// GroupPKs is the group public keys by group ID.
+	GroupPKs []PK
+}
+
+// MakeGenesisGroups makes numNode nodes and numGroup groups, each
+// group has groupSize members and signs with threshold signature
+// shares. The keys are derived from rand.
+func MakeGenesisGroups(numNode, numGroup, groupSize, threshold int, rand Rand) GenesisGroups {
+	var r GenesisGroups
+	nodePKs := make([]PK, numNode)
+	r.Nodes = make([]NodeCredentials, numNode)
+	for i := 0; i < numNode; i++ {
+		sk := rand.SK()
+		nodePKs[i] = sk.MustPK()
+		r.Nodes[i].SK = sk
+		rand = rand.Derive(rand[:])
+
+		txn := ReadyJoinGroupTxn{
+			ID: i,
+			PK: nodePKs[i],
+		}
+		r.SysTxns = append(r.SysTxns, SysTxn{
+			Type: ReadyJoinGroup,
+			Data: gobEncode(txn),
+		})
+	}
+
+	groupIDs := make([]int, numGroup)
+	for i := range groupIDs {
+		idxs := make([]int, groupSize)
+		for j := range idxs {
+			idxs[j] = (i*groupSize + j) % numNode
+		}
+		idVec := make([]bls.ID, groupSize)
+		for i := range idVec {
+			pk := nodePKs[idxs[i]]
+			idVec[i] = pk.Addr().ID()
+		}
+		var groupPK bls.PublicKey
+		var shares []bls.SecretKey
+		groupPK, shares, rand = makeShares(threshold, idVec, rand)
+		groupIDs[i] = i
+		r.GroupPKs = append(r.GroupPKs, PK(groupPK.Serialize()))
+
+		memberVVec := make([]PK, len(shares))
+		for i := range memberVVec {
+			memberVVec[i] = shares[i].GetPublicKey().Serialize()
+		}
+
+		for j := range shares {
+			r.Nodes[idxs[j]].GroupShares = append(r.Nodes[idxs[j]].GroupShares, shares[j].GetLittleEndian())
+			r.Nodes[idxs[j]].Groups = append(r.Nodes[idxs[j]].Groups, i)
+		}
+
+		txn := RegGroupTxn{
+			ID:         i,
+			PK:         PK(groupPK.Serialize()),
+			MemberIDs:  idxs,
+			MemberVVec: memberVVec,
+		}
+		r.SysTxns = append(r.SysTxns, SysTxn{
+			Type: RegGroup,
+			Data: gobEncode(txn),
+		})
+	}
+
+	l := ListGroupsTxn{
+		GroupIDs: groupIDs,
+	}
+
+	r.SysTxns = append(r.SysTxns, SysTxn{
+		Type: ListGroups,
+		Data: gobEncode(l),
+	})
+	return r
+}
+
+func getMasterSecretKey(sk bls.SecretKey, k int, rand Rand) ([]bls.SecretKey, Rand) {
+	msk := make([]bls.SecretKey, k)
+	msk[0] = sk
+	for i := 1; i < k; i++ {
+		msk[i] = rand.SK().MustGet()
+		rand = rand.Derive(rand[:])
+	}
+	return msk, rand
+}
+
+func makeShares(t int, idVec []bls.ID, rand Rand) (bls.PublicKey, []bls.SecretKey, Rand) {
+	sk := rand.SK().MustGet()
+	rand = rand.Derive(rand[:])
+
+	msk, rand := getMasterSecretKey(sk, t, rand)
+	skShares := make([]bls.SecretKey, len(idVec))
+
+	for i := range skShares {
+		err := skShares[i].Set(msk, &idVec[i])
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return *sk.GetPublicKey(), skShares, rand
+}
+
+func gobEncode(v interface{}) []byte {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	err := enc.Encode(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,111 @@
+package consensus
+
+import (
+	"errors"
+	"time"
+)
+
+// the types of the chain head events.
+const (
+	// NewHeadEvent is sent when the heaviest chain has a new tip.
+	NewHeadEvent = "new_head"
+	// FinalizedEvent is sent when a block is finalized, it never
+	// reorgs.
+	FinalizedEvent = "finalized"
+	// ReorgEvent is sent when the new tip does not extend the old
+	// tip, the blocks after the common ancestor on the old branch
+	// are orphaned. It's followed by the new head event of the new
+	// tip.
+	ReorgEvent = "reorg"
+)
+
+// maxHeadEvents is the number of the latest head events kept for the
+// subscribers.
+const maxHeadEvents = 10000
+
+// HeadEvent is a change of the chain head.
+type HeadEvent struct {
+	// Seq is the sequence number of the event, starting from 1.
+	// It's local to the node.
+	Seq   uint64
+	Type  string
+	Round uint64
+	// Block is the new tip or the finalized block.
+	Block Hash
+	// OldTip, Ancestor and AncestorRound are set for a reorg.
+	OldTip        Hash
+	Ancestor      Hash
+	AncestorRound uint64
+}
+
+// must be called with mutex held
+func (c *Chain) addHeadEvent(e HeadEvent) {
+	c.headSeq++
+	e.Seq = c.headSeq
+	c.headEvents = append(c.headEvents, e)
+	if len(c.headEvents) > maxHeadEvents {
+		c.headEvents = c.headEvents[len(c.headEvents)-maxHeadEvents:]
+	}
+
+	close(c.headNotify)
+	c.headNotify = make(chan struct{})
+}
+
+// commonAncestor returns the latest block that both blocks extend or
+// are.
+//
+// must be called with mutex held
+func (c *Chain) commonAncestor(a, b Hash) (Hash, uint64, bool) {
+	for a != b {
+		ba, bb := c.store.Block(a), c.store.Block(b)
+		if ba == nil || bb == nil {
+			return Hash{}, 0, false
+		}
+
+		if ba.Round >= bb.Round {
+			a = ba.PrevBlock
+		}
+		if bb.Round >= ba.Round {
+			b = bb.PrevBlock
+		}
+	}
+
+	block := c.store.Block(a)
+	if block == nil {
+		return Hash{}, 0, false
+	}
+	return a, block.Round, true
+}
+
+// HeadEvents returns the head events after the sequence number after,
+// it blocks until there are new events or wait passes. gap is true
+// when the events after after are no longer kept.
+func (c *Chain) HeadEvents(after uint64, wait time.Duration) (events []HeadEvent, gap bool, err error) {
+	timeout := time.After(wait)
+	for {
+		c.mu.Lock()
+		if after > c.headSeq {
+			c.mu.Unlock()
+			return nil, false, errors.New("sequence number not reached, the node restarted")
+		}
+
+		i := len(c.headEvents)
+		for i > 0 && c.headEvents[i-1].Seq > after {
+			i--
+		}
+		notify := c.headNotify
+		if i < len(c.headEvents) || wait <= 0 {
+			events = append([]HeadEvent(nil), c.headEvents[i:]...)
+			gap = after < c.headSeq && (len(events) == 0 || events[0].Seq > after+1)
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-timeout:
+			wait = 0
+		}
+	}
+}
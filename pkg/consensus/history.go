@@ -0,0 +1,196 @@
+package consensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// maxHistoryBlocks is the maximum number of blocks served for a
+// history request.
+const maxHistoryBlocks = 256
+
+// HistoryRequest requests the historical blocks from a peer. When
+// Hash is not set, the finalized blocks of the rounds in [From, To]
+// are requested, otherwise Count blocks ending at the block of Hash,
+// following the prev blocks. The blocks are the headers, the block
+// proposals are the bodies, they are only served when Bodies is true.
+// The genesis block is known to every node and never served.
+type HistoryRequest struct {
+	ID     uint64
+	From   uint64
+	To     uint64
+	Hash   Hash
+	Count  uint64
+	Bodies bool
+	// RandBeaconSigs requests the random beacon signatures of the
+	// rounds of the blocks.
+	RandBeaconSigs bool
+}
+
+// HistoryResponse is the response of a history request, the blocks
+// are sorted by round in ascending order. BlockProposals and
+// RandBeaconSigs are parallel to Blocks when requested.
+type HistoryResponse struct {
+	ID             uint64
+	Blocks         []*Block
+	BlockProposals []*BlockProposal
+	RandBeaconSigs []*RandBeaconSig
+}
+
+// validate checks that the blocks form a chain, and the block
+// proposals and the random beacon signatures match the blocks.
+func (r *HistoryResponse) validate(req HistoryRequest) error {
+	if len(r.Blocks) > maxHistoryBlocks {
+		return fmt.Errorf("too many blocks in history response: %d", len(r.Blocks))
+	}
+
+	if req.Bodies && len(r.BlockProposals) != len(r.Blocks) {
+		return errors.New("history response block proposal count does not match block count")
+	}
+
+	if req.RandBeaconSigs && len(r.RandBeaconSigs) != len(r.Blocks) {
+		return errors.New("history response random beacon signature count does not match block count")
+	}
+
+	for i, b := range r.Blocks {
+		if b == nil {
+			return errors.New("nil block in history response")
+		}
+
+		if i > 0 && b.PrevBlock != r.Blocks[i-1].Hash() {
+			return fmt.Errorf("history response block of round %d does not extend the prev block", b.Round)
+		}
+
+		if req.Bodies && (r.BlockProposals[i] == nil || r.BlockProposals[i].Hash() != b.BlockProposal) {
+			return fmt.Errorf("history response block proposal of round %d does not match the block", b.Round)
+		}
+
+		if req.RandBeaconSigs && (r.RandBeaconSigs[i] == nil || r.RandBeaconSigs[i].Round != b.Round) {
+			return fmt.Errorf("history response random beacon signature of round %d does not match the block", b.Round)
+		}
+	}
+
+	if len(r.Blocks) > 0 && req.Hash != (Hash{}) && r.Blocks[len(r.Blocks)-1].Hash() != req.Hash {
+		return errors.New("history response does not end at the requested block")
+	}
+
+	return nil
+}
+
+// history returns the locally known history for the request.
+func (n *gateway) history(req HistoryRequest) *HistoryResponse {
+	var hashes []Hash
+	if req.Hash == (Hash{}) {
+		to := req.To
+		if to >= req.From+maxHistoryBlocks {
+			to = req.From + maxHistoryBlocks - 1
+		}
+		hashes = n.chain.FinalizedBlocks(req.From, to)
+	} else {
+		count := req.Count
+		if count > maxHistoryBlocks {
+			count = maxHistoryBlocks
+		}
+
+		h := req.Hash
+		for i := uint64(0); i < count; i++ {
+			b := n.store.Block(h)
+			if b == nil || b.Round == 0 {
+				break
+			}
+			hashes = append(hashes, h)
+			h = b.PrevBlock
+		}
+
+		for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+			hashes[i], hashes[j] = hashes[j], hashes[i]
+		}
+	}
+
+	resp := &HistoryResponse{ID: req.ID}
+	for _, h := range hashes {
+		b := n.store.Block(h)
+		if b == nil {
+			break
+		}
+
+		if b.Round == 0 {
+			continue
+		}
+
+		var bp *BlockProposal
+		if req.Bodies {
+			bp = n.store.BlockProposal(b.BlockProposal)
+			if bp == nil {
+				break
+			}
+		}
+
+		var sig *RandBeaconSig
+		if req.RandBeaconSigs {
+			sig = n.chain.randomBeacon.RandBeaconSig(b.Round)
+			if sig == nil {
+				break
+			}
+		}
+
+		resp.Blocks = append(resp.Blocks, b)
+		if req.Bodies {
+			resp.BlockProposals = append(resp.BlockProposals, bp)
+		}
+		if req.RandBeaconSigs {
+			resp.RandBeaconSigs = append(resp.RandBeaconSigs, sig)
+		}
+	}
+
+	return resp
+}
+
+func (n *gateway) serveHistory(addr unicastAddr, req HistoryRequest) {
+	resp := n.history(req)
+	go n.net.Send(addr, packet{Data: resp})
+}
+
+func (n *gateway) recvHistory(r *HistoryResponse) {
+	n.mu.Lock()
+	c, ok := n.historyWaiters[r.ID]
+	delete(n.historyWaiters, r.ID)
+	n.mu.Unlock()
+
+	if ok {
+		c <- r
+	}
+}
+
+// RequestHistory requests the historical blocks from the peer, the
+// response is validated to form a chain and to match the request.
+func (n *gateway) RequestHistory(ctx context.Context, addr unicastAddr, req HistoryRequest) (*HistoryResponse, error) {
+	c := make(chan *HistoryResponse, 1)
+	n.mu.Lock()
+	n.historyReqID++
+	req.ID = n.historyReqID
+	n.historyWaiters[req.ID] = c
+	n.mu.Unlock()
+
+	err := n.net.Send(addr, packet{Data: req})
+	if err != nil {
+		n.mu.Lock()
+		delete(n.historyWaiters, req.ID)
+		n.mu.Unlock()
+		return nil, err
+	}
+
+	select {
+	case r := <-c:
+		if err := r.validate(req); err != nil {
+			return nil, err
+		}
+		return r, nil
+	case <-ctx.Done():
+		n.mu.Lock()
+		delete(n.historyWaiters, req.ID)
+		n.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,47 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	genesis := &Block{Owner: Addr{1}}
+	store := newStorage()
+	chain := NewChain(genesis, &myState{}, Rand{}, Config{}, nil, &myUpdater{}, store, nil)
+	g := newGateway(nil, chain, store, 1, 1)
+
+	prev := genesis.Hash()
+	var blocks []*Block
+	for round := uint64(1); round <= 3; round++ {
+		bp := &BlockProposal{Round: round, PrevBlock: prev, Owner: Addr{2}}
+		b := &Block{Round: round, PrevBlock: prev, BlockProposal: bp.Hash()}
+		store.AddBlockProposal(bp, bp.Hash())
+		store.AddBlock(b, b.Hash())
+		chain.finalized = append(chain.finalized, b.Hash())
+		blocks = append(blocks, b)
+		prev = b.Hash()
+	}
+
+	req := HistoryRequest{ID: 1, From: 0, To: 10, Bodies: true}
+	resp := g.history(req)
+	assert.Equal(t, uint64(1), resp.ID)
+	assert.Equal(t, blocks, resp.Blocks)
+	assert.Equal(t, 3, len(resp.BlockProposals))
+	assert.Nil(t, resp.validate(req))
+
+	req = HistoryRequest{Hash: blocks[2].Hash(), Count: 2}
+	resp = g.history(req)
+	assert.Equal(t, blocks[1:], resp.Blocks)
+	assert.Nil(t, resp.BlockProposals)
+	assert.Nil(t, resp.validate(req))
+
+	resp.Blocks[0], resp.Blocks[1] = resp.Blocks[1], resp.Blocks[0]
+	assert.NotNil(t, resp.validate(req))
+
+	req = HistoryRequest{From: 1, To: 2, Bodies: true}
+	resp = g.history(req)
+	resp.BlockProposals[1] = resp.BlockProposals[0]
+	assert.NotNil(t, resp.validate(req))
+}
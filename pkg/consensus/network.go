@@ -34,8 +34,19 @@ type packetAndAddr struct {
 	A unicastAddr
 }
 
+// transport sends and receives the packets between the peers.
+type transport interface {
+	Start(host string, port int) (unicastAddr, error)
+	ConnectSeed(addr string) error
+	Send(addr netAddr, p packet) error
+	Recv() (unicastAddr, packet)
+	// Peers returns the connected peers.
+	Peers() []unicastAddr
+}
+
 type network struct {
 	sk            SK
+	networkID     NetworkID
 	port          uint16
 	ch            chan packetAndAddr
 	onPeerConnect func(addr unicastAddr)
@@ -46,11 +57,12 @@ type network struct {
 	publicNodes []unicastAddr
 }
 
-func newNetwork(sk SK) *network {
+func newNetwork(sk SK, networkID NetworkID) *network {
 	return &network{
-		sk:    sk,
-		ch:    make(chan packetAndAddr, 100),
-		conns: make(map[unicastAddr]*conn),
+		sk:        sk,
+		networkID: networkID,
+		ch:        make(chan packetAndAddr, 100),
+		conns:     make(map[unicastAddr]*conn),
 	}
 }
 
@@ -73,6 +85,12 @@ func (n *network) acceptPeerOrDisconnect(c net.Conn) {
 			return
 		}
 
+		if v.NetworkID != n.networkID {
+			log.Warn("connect request from a different network", "network", v.NetworkID, "expected", n.networkID)
+			conn.Close()
+			return
+		}
+
 		recv = v
 	case ack:
 		// acknowlege receiving the request (so remote could
@@ -108,7 +126,7 @@ func (n *network) acceptPeerOrDisconnect(c net.Conn) {
 
 	// send a connect reuqest just to tell the other node about my
 	// public key.
-	req := &connectRequest{}
+	req := &connectRequest{NetworkID: n.networkID}
 	req.PK = n.sk.MustPK()
 	req.Sig = n.sk.Sign(req.ByteToSign())
 	conn.Write(packet{Data: req})
@@ -248,7 +266,7 @@ func (n *network) getAddrsFromSeed(ctx context.Context, addr string) (PK, []unic
 	}
 
 	conn := newConn(c)
-	req := &connectRequest{GetNodesOnly: true, Port: n.port}
+	req := &connectRequest{GetNodesOnly: true, Port: n.port, NetworkID: n.networkID}
 	req.PK = n.sk.MustPK()
 	req.Sig = n.sk.Sign(req.ByteToSign())
 	err = conn.Write(packet{Data: req})
@@ -293,6 +311,11 @@ func (n *network) getAddrsFromSeed(ctx context.Context, addr string) (PK, []unic
 			return
 		}
 
+		if req.NetworkID != n.networkID {
+			ch <- result{err: fmt.Errorf("peer is on network %v, expected %v", req.NetworkID, n.networkID)}
+			return
+		}
+
 		ch <- result{addrs: addrs, pk: req.PK}
 	}()
 
@@ -320,7 +343,7 @@ func (n *network) connect(addr unicastAddr, pk PK) error {
 	}
 
 	conn := newConn(c)
-	req := &connectRequest{Port: n.port}
+	req := &connectRequest{Port: n.port, NetworkID: n.networkID}
 	req.PK = n.sk.MustPK()
 	req.Sig = n.sk.Sign(req.ByteToSign())
 	err = conn.Write(packet{Data: req})
@@ -402,9 +425,21 @@ func (n *network) Recv() (unicastAddr, packet) {
 	return p.A, p.P
 }
 
+func (n *network) Peers() []unicastAddr {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	r := make([]unicastAddr, 0, len(n.conns))
+	for addr := range n.conns {
+		r = append(r, addr)
+	}
+	return r
+}
+
 type connectRequest struct {
 	Port         uint16
 	GetNodesOnly bool
+	NetworkID    NetworkID
 	PK           PK
 	Sig          Sig
 }
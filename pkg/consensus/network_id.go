@@ -0,0 +1,39 @@
+package consensus
+
+import "fmt"
+
+// NetworkID identifies a network. The nodes of different networks
+// refuse to connect, and the txns signed for one network are invalid
+// on the others.
+type NetworkID uint32
+
+// the preset networks
+const (
+	Mainnet NetworkID = iota + 1
+	Testnet
+	Devnet
+)
+
+var networkNames = map[string]NetworkID{
+	"mainnet": Mainnet,
+	"testnet": Testnet,
+	"devnet":  Devnet,
+}
+
+// NetworkByName returns the ID of the preset network of the name.
+func NetworkByName(name string) (NetworkID, error) {
+	id, ok := networkNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown network: %s, should be one of mainnet, testnet and devnet", name)
+	}
+	return id, nil
+}
+
+func (id NetworkID) String() string {
+	for name, v := range networkNames {
+		if v == id {
+			return name
+		}
+	}
+	return fmt.Sprintf("network-%d", uint32(id))
+}
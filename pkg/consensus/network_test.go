@@ -9,7 +9,7 @@ import (
 
 func makeNetwork() *network {
 	sk := RandSK()
-	return newNetwork(sk)
+	return newNetwork(sk, Devnet)
 }
 
 func TestNetworkConnectSeed(t *testing.T) {
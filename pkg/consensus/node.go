@@ -29,6 +29,11 @@ type Node struct {
 	round          uint64
 	recvBlockTime  map[uint64]time.Time
 	cancelNotarize map[uint64]func()
+	// deadlines is the notarization deadline of the rounds,
+	// latencies is the time from the deadline to receiving the
+	// block of the recent rounds.
+	deadlines map[uint64]time.Time
+	latencies []time.Duration
 }
 
 // NodeCredentials stores the credentials of the node.
@@ -38,6 +43,14 @@ type NodeCredentials struct {
 	GroupShares []SK
 }
 
+// ReplicaCredentials returns the credentials of a read-only replica. It
+// has a throwaway key identifying the node to its peers and no group
+// shares, so the node follows the chain and maintains the state
+// without ever participating in the consensus.
+func ReplicaCredentials() NodeCredentials {
+	return NodeCredentials{SK: RandSK()}
+}
+
 type membership struct {
 	skShare SK
 	groupID int
@@ -48,6 +61,51 @@ type Config struct {
 	BlockTime      time.Duration
 	GroupSize      int
 	GroupThreshold int
+	NetworkID      NetworkID
+	// ColdStorageDir is the directory of the cold storage of the
+	// old finalized blocks, they are kept in memory if it is
+	// empty.
+	ColdStorageDir string
+	// MaxBlockTxnBytes is the max total size of the txns in a
+	// block proposal, 0 means no limit.
+	MaxBlockTxnBytes int
+	// RankEnforcementRound is the round from which a notarized
+	// block counts as an empty block in the fork choice if a
+	// notarized block of the same round and parent has a better
+	// ranked proposer, 0 disables it. The blocks before the round
+	// are only penalized by lowRankPenalty, giving the nodes a
+	// grace period to upgrade.
+	RankEnforcementRound uint64
+	// NotarizationThreshold is the number of the notarization
+	// shares of the distinct notaries recovered into a block's
+	// notarization, 0 means GroupThreshold.
+	NotarizationThreshold int
+	// RandBeaconCheckpointInterval is the number of the rounds
+	// between two random beacon checkpoints, 0 means
+	// DefaultRandBeaconCheckpointInterval. It must be the same
+	// on all the nodes.
+	RandBeaconCheckpointInterval uint64
+}
+
+// Validate returns an error if the configuration is invalid.
+func (c Config) Validate() error {
+	if c.GroupThreshold <= 0 || c.GroupThreshold > c.GroupSize {
+		return fmt.Errorf("group threshold %d must be in [1, %d]", c.GroupThreshold, c.GroupSize)
+	}
+
+	if c.NotarizationThreshold != 0 && (c.NotarizationThreshold < c.GroupThreshold || c.NotarizationThreshold > c.GroupSize) {
+		return fmt.Errorf("notarization threshold %d must be in [%d, %d]", c.NotarizationThreshold, c.GroupThreshold, c.GroupSize)
+	}
+
+	return nil
+}
+
+func (c Config) ntThreshold() int {
+	if c.NotarizationThreshold == 0 {
+		return c.GroupThreshold
+	}
+
+	return c.NotarizationThreshold
 }
 
 // NewNode creates a new node.
@@ -69,11 +127,18 @@ func NewNode(chain *Chain, sk SK, net *gateway, cfg Config, store *storage) *Nod
 		notarizeChs:    make(map[uint64][]chan *BlockProposal),
 		cancelNotarize: make(map[uint64]func()),
 		recvBlockTime:  make(map[uint64]time.Time),
+		deadlines:      make(map[uint64]time.Time),
 	}
 	chain.n = n
 	return n
 }
 
+// Replica returns true if the node is a read-only replica, a member
+// of no group.
+func (n *Node) Replica() bool {
+	return len(n.memberships) == 0
+}
+
 // Chain returns node's block chain.
 func (n *Node) Chain() *Chain {
 	return n.chain
@@ -84,7 +149,27 @@ func (n *Node) Start(host string, port int, seedAddr string) error {
 	return n.gateway.Start(host, port, seedAddr)
 }
 
-func (n *Node) proposeBlock(round uint64, group int, lastRoundEndTime time.Time) {
+// roundInterval returns the round interval. The interval adapts to
+// the notarization latency within the bounds set in the leader state,
+// it is fixed to cfg.BlockTime if the bounds are not set.
+//
+// must be called with mutex held
+func (n *Node) roundInterval() time.Duration {
+	_, s, _ := n.chain.Leader()
+	b, ok := s.(RoundIntervalBounder)
+	if !ok {
+		return n.cfg.BlockTime
+	}
+
+	min, max, ok := b.RoundIntervalBounds()
+	if !ok {
+		return n.cfg.BlockTime
+	}
+
+	return adaptiveInterval(n.latencies, min, max)
+}
+
+func (n *Node) proposeBlock(round uint64, group int, lastRoundEndTime time.Time, blockTime time.Duration) {
 	n.chain.WaitUntil(round)
 	n.mu.Lock()
 	nodeRound := n.round
@@ -95,29 +180,41 @@ func (n *Node) proposeBlock(round uint64, group int, lastRoundEndTime time.Time)
 		return
 	}
 
-	// at most spend blockTime/3 for proposing block, to avoid
-	// delayed block time when there are too many transactions to
-	// be included in the block proposal
-	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.BlockTime/3)
+	// the txn selection must finish by blockTime/3 after the
+	// last round ended, to avoid delayed block time when there
+	// are too many transactions to be included in the block
+	// proposal. The deadline is from the round start rather than
+	// from now, a proposer starting late has less time, and
+	// proposes the txns recorded so far when it passes.
+	ctx, cancel := context.WithDeadline(context.Background(), lastRoundEndTime.Add(blockTime/3))
 	defer cancel()
 
 	start := time.Now()
 	log.Debug("start propose block", "owner", n.addr, "round", round, "group", group, "since last round end", time.Now().Sub(lastRoundEndTime))
 	bp := n.chain.ProposeBlock(ctx, n.sk, round)
-	h := bp.Hash()
 	if bp != nil {
+		if d := faultProposalDelay(n.addr); d > 0 {
+			time.Sleep(d)
+		}
+
+		h := bp.Hash()
 		log.Info("propose block done", "owner", n.addr, "round", round, "hash", h, "group", group, "since last round end", time.Now().Sub(lastRoundEndTime), "dur", time.Now().Sub(start))
 		n.gateway.recvBlockProposal(n.gateway.addr, bp, h)
 	}
 
 }
 
-func (n *Node) notarizeBlock(notary *Notary, inCh chan *BlockProposal, cancelCtx context.Context, lastRoundEndTime time.Time, round uint64, group int) {
+func (n *Node) notarizeBlock(notary *Notary, inCh chan *BlockProposal, cancelCtx context.Context, lastRoundEndTime time.Time, blockTime time.Duration, round uint64, group int) {
 	log.Debug("begin notarize", "group", group, "round", round)
 	onNotarize := func(s *NtShare, spentTime time.Duration) {
+		if faultDropShare(n.addr) {
+			return
+		}
+
+		faultCorruptNtShare(n.sk, s)
 		h := s.Hash()
 		sinceLastRoundEnd := time.Now().Sub(lastRoundEndTime)
-		remainTime := n.cfg.BlockTime - spentTime - sinceLastRoundEnd
+		remainTime := blockTime - spentTime - sinceLastRoundEnd
 		log.Info("produced one notarization share", "group", group, "round", round, "notarized proposal", s.BP, "hash", h, "since last round end", sinceLastRoundEnd, "remain time", remainTime)
 		if remainTime <= 0 {
 			go n.gateway.recvNtShare(n.gateway.addr, s, h)
@@ -128,9 +225,9 @@ func (n *Node) notarizeBlock(notary *Notary, inCh chan *BlockProposal, cancelCtx
 		}
 	}
 
-	ctx, cancel := context.WithDeadline(context.Background(), lastRoundEndTime.Add(n.cfg.BlockTime))
+	ctx, cancel := context.WithDeadline(context.Background(), lastRoundEndTime.Add(blockTime))
 	defer cancel()
-	notary.Notarize(ctx, cancelCtx, inCh, onNotarize)
+	notary.Notarize(ctx, cancelCtx, round, inCh, onNotarize)
 }
 
 // StartRound marks the start of the given round. It happens when the
@@ -149,13 +246,15 @@ func (n *Node) StartRound(round uint64) {
 	}
 
 	n.round = round
+	blockTime := n.roundInterval()
+	n.deadlines[round] = recvLastRoundBlock.Add(blockTime)
 	var ntCancelCtx context.Context
 	rbGroup, bpGroup, ntGroup := n.chain.randomBeacon.Committees(round)
-	log.Info("start round", "round", round, "rand beacon", SHA3(n.chain.randomBeacon.History()[round].Sig), "rb group", rbGroup, "bp group", bpGroup, "nt group", ntGroup)
+	log.Info("start round", "round", round, "rand beacon", SHA3(n.chain.randomBeacon.RandBeaconSig(round).Sig), "rb group", rbGroup, "bp group", bpGroup, "nt group", ntGroup)
 
 	for _, m := range n.memberships {
 		if m.groupID == bpGroup {
-			go n.proposeBlock(round, bpGroup, recvLastRoundBlock)
+			go n.proposeBlock(round, bpGroup, recvLastRoundBlock, blockTime)
 		}
 
 		if m.groupID == ntGroup {
@@ -166,7 +265,7 @@ func (n *Node) StartRound(round uint64) {
 			notary := NewNotary(n.addr, n.sk, m.skShare, n.chain, n.store)
 			inCh := make(chan *BlockProposal, 20)
 			n.notarizeChs[round] = append(n.notarizeChs[round], inCh)
-			go n.notarizeBlock(notary, inCh, ntCancelCtx, recvLastRoundBlock, round, ntGroup)
+			go n.notarizeBlock(notary, inCh, ntCancelCtx, recvLastRoundBlock, blockTime, round, ntGroup)
 		}
 	}
 
@@ -187,7 +286,24 @@ func (n *Node) BlockForRoundProduced(round uint64) {
 	defer n.mu.Unlock()
 
 	if _, ok := n.recvBlockTime[round]; !ok {
-		n.recvBlockTime[round] = time.Now()
+		now := time.Now()
+		n.recvBlockTime[round] = now
+		if d, ok := n.deadlines[round]; ok {
+			n.recordLatency(now.Sub(d))
+			delete(n.deadlines, round)
+		}
+	}
+}
+
+// must be called with mutex held
+func (n *Node) recordLatency(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+
+	n.latencies = append(n.latencies, d)
+	if len(n.latencies) > latencyRounds {
+		n.latencies = n.latencies[len(n.latencies)-latencyRounds:]
 	}
 }
 
@@ -195,11 +311,13 @@ func (n *Node) BlockForRoundProduced(round uint64) {
 // block for the given round is received.
 func (n *Node) EndRound(round uint64) {
 	log.Info("end round", "round", round)
+	n.mu.Lock()
 	delete(n.notarizeChs, round)
 	if c := n.cancelNotarize[round]; c != nil {
 		c()
 		delete(n.cancelNotarize, round)
 	}
+	n.mu.Unlock()
 
 	rb, _, _ := n.chain.randomBeacon.Committees(round)
 	for _, m := range n.memberships {
@@ -216,9 +334,14 @@ func (n *Node) EndRound(round uint64) {
 		// signature.
 		keyShare := m.skShare
 		go func() {
-			history := n.chain.randomBeacon.History()
-			lastSigHash := SHA3(history[round].Sig)
-			s := signRandBeaconSigShare(n.sk, keyShare, round+1, lastSigHash)
+			beacon := n.chain.randomBeacon
+			lastSigHash := SHA3(beacon.RandBeaconSig(round).Sig)
+			s := signRandBeaconSigShare(n.sk, keyShare, round+1, lastSigHash, beacon.Checkpoint(round))
+			if faultDropShare(n.addr) {
+				return
+			}
+
+			faultCorruptRandBeaconSigShare(n.sk, s)
 			n.gateway.recvRandBeaconSigShare(n.gateway.addr, s)
 		}()
 	}
@@ -227,6 +350,11 @@ func (n *Node) EndRound(round uint64) {
 // RecvBlockProposal tells the node that a valid block proposal of the
 // current round is received.
 func (n *Node) recvBPForNotary(bp *BlockProposal) {
+	if n.Replica() {
+		// a replica never notarizes.
+		return
+	}
+
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
@@ -248,17 +376,72 @@ func (n *Node) SendTxn(t []byte) {
 
 // MakeNode makes a new node with the given configurations.
 func MakeNode(credentials NodeCredentials, cfg Config, genesis Genesis, state State, txnPool TxnPool, u Updater, proposerPK []byte) *Node {
+	if genesis.NetworkID != cfg.NetworkID {
+		panic(fmt.Errorf("genesis is of network %v, expected %v", genesis.NetworkID, cfg.NetworkID))
+	}
+
 	randSeed := Rand(SHA3([]byte("dex")))
 	err := state.Deserialize(genesis.State)
 	if err != nil {
 		panic(err)
 	}
 
-	store := newStorage()
+	store, err := newNodeStorage(cfg)
+	if err != nil {
+		panic(err)
+	}
+
 	chain := NewChain(&genesis.Block, state, randSeed, cfg, txnPool, u, store, proposerPK)
-	net := newNetwork(credentials.SK)
-	gateway := newGateway(net, chain, store, cfg.GroupThreshold)
-	net.onPeerConnect = gateway.onPeerConnect
+	return makeNodeWithNetwork(credentials, cfg, chain, store)
+}
+
+// MakeNodeFromSnapshot makes a new node bootstrapped from the
+// snapshot.
+func MakeNodeFromSnapshot(credentials NodeCredentials, cfg Config, snapshot *Snapshot, state State, txnPool TxnPool, u Updater, proposerPK []byte) (*Node, error) {
+	if snapshot.NetworkID != cfg.NetworkID {
+		return nil, fmt.Errorf("snapshot is of network %v, expected %v", snapshot.NetworkID, cfg.NetworkID)
+	}
+
+	randSeed := Rand(SHA3([]byte("dex")))
+	err := state.Deserialize(snapshot.State)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := newNodeStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := NewChainFromSnapshot(snapshot, state, randSeed, cfg, txnPool, u, store, proposerPK)
+	if err != nil {
+		return nil, err
+	}
+
+	return makeNodeWithNetwork(credentials, cfg, chain, store), nil
+}
+
+func newNodeStorage(cfg Config) (*storage, error) {
+	store := newStorage()
+	if cfg.ColdStorageDir != "" {
+		var err error
+		store.cold, err = openColdStore(cfg.ColdStorageDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+func makeNodeWithNetwork(credentials NodeCredentials, cfg Config, chain *Chain, store *storage) *Node {
+	net := newNetwork(credentials.SK, cfg.NetworkID)
+	node := assembleNode(credentials, cfg, chain, store, net)
+	net.onPeerConnect = node.gateway.onPeerConnect
+	return node
+}
+
+func assembleNode(credentials NodeCredentials, cfg Config, chain *Chain, store *storage, net transport) *Node {
+	gateway := newGateway(net, chain, store, cfg.GroupThreshold, cfg.ntThreshold())
 	node := NewNode(chain, credentials.SK, gateway, cfg, store)
 	for j := range credentials.Groups {
 		share := credentials.GroupShares[j]
@@ -29,8 +29,10 @@ func NewNotary(owner Addr, sk, share SK, chain *Chain, store *storage) *Notary {
 // it will notarize the highest weight accumulated block
 // proposals. And it will keep notarizing the newly collected block
 // proposal if the weight is equal to or greater than the collected
-// block proposals until cancel context is done.
-func (n *Notary) Notarize(ctx, cancel context.Context, bCh chan *BlockProposal, onNotarize func(*NtShare, time.Duration)) {
+// block proposals until cancel context is done. If no block proposal
+// is collected when ctx is done, it notarizes the empty block
+// proposal of the round.
+func (n *Notary) Notarize(ctx, cancel context.Context, round uint64, bCh chan *BlockProposal, onNotarize func(*NtShare, time.Duration)) {
 	var bestRankBPs []*BlockProposal
 	bestRank := uint16(math.MaxUint16)
 	recvBestRank := false
@@ -71,6 +73,11 @@ func (n *Notary) Notarize(ctx, cancel context.Context, bCh chan *BlockProposal,
 			notarize()
 			return
 		case <-ctx.Done():
+			if len(bestRankBPs) == 0 {
+				if bp := n.emptyBlockProposal(round); bp != nil {
+					bestRankBPs = []*BlockProposal{bp}
+				}
+			}
 			notarize()
 			return
 		case bp := <-bCh:
@@ -103,6 +110,21 @@ func (n *Notary) Notarize(ctx, cancel context.Context, bCh chan *BlockProposal,
 	}
 }
 
+// emptyBlockProposal returns the empty block proposal of the round
+// on top of the leader block, nil if the leader block is not of the
+// previous round.
+func (n *Notary) emptyBlockProposal(round uint64) *BlockProposal {
+	leader, _, _ := n.chain.Leader()
+	if leader.Round+1 != round {
+		return nil
+	}
+
+	bp := EmptyBlockProposal(round, leader)
+	n.store.AddBlockProposal(bp, bp.Hash())
+	log.Info("no block proposal received before timeout, notarize empty block", "round", round)
+	return bp
+}
+
 func (n *Notary) notarize(bp *BlockProposal, pool TxnPool) (*NtShare, time.Duration) {
 	bpHash := bp.Hash()
 	nts := &NtShare{
@@ -121,21 +143,40 @@ func (n *Notary) notarize(bp *BlockProposal, pool TxnPool) (*NtShare, time.Durat
 	}
 
 	start := time.Now()
-	newState, _, err := state.CommitTxns(bp.Txns, pool, bp.Round)
+	newState, _, err := n.chain.commitTxns(state, bp, pool)
 	if err != nil {
-		panic("should not happen, record block proposal transaction error, could be due to adversary: " + err.Error())
+		// could be due to adversary, e.g., txns not in the
+		// canonical order.
+		log.Warn("record block proposal transaction error, skip notarizing", "bp", bpHash, "err", err)
+		return nil, 0
 	}
 
 	dur := time.Now().Sub(start)
 	log.Debug("notarize record txns done", "round", nts.Round, "bp", nts.BP, "dur", dur)
 
+	txnRoot, err := TxnRoot(bp.Txns)
+	if err != nil {
+		log.Warn("decode block proposal transaction error, skip notarizing", "bp", bpHash, "err", err)
+		return nil, 0
+	}
+
+	beacon, checkpoint, err := n.chain.randomBeacon.BlockBeacon(bp.Round)
+	if err != nil {
+		log.Warn("get random beacon of block proposal error, skip notarizing", "bp", bpHash, "err", err)
+		return nil, 0
+	}
+
 	stateRoot := newState.Hash()
 	blk := &Block{
-		Owner:         bp.Owner,
-		Round:         bp.Round,
-		StateRoot:     stateRoot,
-		BlockProposal: bpHash,
-		PrevBlock:     bp.PrevBlock,
+		Owner:          bp.Owner,
+		Round:          bp.Round,
+		StateRoot:      stateRoot,
+		TxnRoot:        txnRoot,
+		RandBeacon:     beacon,
+		RandCheckpoint: checkpoint,
+		BlockProposal:  bpHash,
+		PrevBlock:      bp.PrevBlock,
+		Timestamp:      bp.Timestamp,
 	}
 
 	nts.StateRoot = stateRoot
@@ -0,0 +1,158 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// DefaultRandBeaconCheckpointInterval is the default number of the
+// rounds between two random beacon checkpoints.
+const DefaultRandBeaconCheckpointInterval = 10000
+
+// RandBeaconCheckpoint is the random beacon state after a checkpoint
+// round. The random beacon signature of the next round signs the
+// checkpoint hash as well, so a checkpoint is verified by the
+// signature following it, without the signatures before it.
+//
+// Each checkpoint links to the previous checkpoint and commits to the
+// signatures in between, the checkpoints form a skiplist over the
+// random beacon history.
+type RandBeaconCheckpoint struct {
+	Round uint64
+	// Prev is the hash of the previous checkpoint, zero for the
+	// first checkpoint.
+	Prev Hash
+	// Digest is the hash chain of the signatures after the
+	// previous checkpoint up to the checkpoint round.
+	Digest Hash
+	// SigHash is the hash of the signature of the checkpoint
+	// round, the random beacon output.
+	SigHash Hash
+	RBRand  Rand
+	NtRand  Rand
+	BPRand  Rand
+}
+
+// Encode encodes the checkpoint.
+func (c *RandBeaconCheckpoint) Encode() []byte {
+	b, err := rlp.EncodeToBytes(c)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+// Hash returns the hash of the checkpoint.
+func (c *RandBeaconCheckpoint) Hash() Hash {
+	return SHA3(c.Encode())
+}
+
+func (c Config) randBeaconCheckpointInterval() uint64 {
+	if c.RandBeaconCheckpointInterval == 0 {
+		return DefaultRandBeaconCheckpointInterval
+	}
+
+	return c.RandBeaconCheckpointInterval
+}
+
+func digestRandBeaconSig(digest Hash, s *RandBeaconSig) Hash {
+	h := s.Hash()
+	return SHA3(digest[:], h[:])
+}
+
+// NewRandomBeaconFromCheckpoint creates a new random beacon starting
+// from the checkpoint, the history before the checkpoint is pruned.
+// sig is the random beacon signature of the checkpoint round.
+func NewRandomBeaconFromCheckpoint(cp *RandBeaconCheckpoint, sig *RandBeaconSig, groups []*group, cfg Config) (*RandomBeacon, error) {
+	if sig.Round != cp.Round || SHA3(sig.Sig) != cp.SigHash {
+		return nil, fmt.Errorf("random beacon signature does not match the checkpoint of round %d", cp.Round)
+	}
+
+	if cp.Round == 0 || cp.Round%cfg.randBeaconCheckpointInterval() != 0 {
+		return nil, fmt.Errorf("round %d is not a random beacon checkpoint round", cp.Round)
+	}
+
+	if len(groups) == 0 {
+		return nil, errors.New("no random beacon group")
+	}
+
+	return &RandomBeacon{
+		cfg:               cfg,
+		groups:            groups,
+		rbRand:            cp.RBRand,
+		bpRand:            cp.BPRand,
+		ntRand:            cp.NtRand,
+		roundWaitCh:       make(map[uint64]chan struct{}),
+		nextRBCmteHistory: []int{cp.RBRand.Mod(len(groups))},
+		nextNtCmteHistory: []int{cp.NtRand.Mod(len(groups))},
+		nextBPCmteHistory: []int{cp.BPRand.Mod(len(groups))},
+		nextBPRandHistory: []Rand{cp.BPRand},
+		base:              cp.Round,
+		sigHistory:        []*RandBeaconSig{sig},
+		checkpoints:       []*RandBeaconCheckpoint{cp},
+	}, nil
+}
+
+// replayRandBeacon verifies and adds the random beacon signatures
+// following the first signature in sigs, which is the signature of
+// the checkpoint round, or round 1 if cp is nil. The signature after
+// the checkpoint verifies the checkpoint.
+func replayRandBeacon(groups []*group, seed Rand, cp *RandBeaconCheckpoint, sigs []*RandBeaconSig, cfg Config) (*RandomBeacon, error) {
+	var rb *RandomBeacon
+	if cp == nil {
+		rb = NewRandomBeacon(seed, groups, cfg)
+	} else {
+		if len(sigs) < 2 {
+			return nil, errors.New("no random beacon signature verifies the checkpoint")
+		}
+
+		var err error
+		rb, err = NewRandomBeaconFromCheckpoint(cp, sigs[0], groups, cfg)
+		if err != nil {
+			return nil, err
+		}
+		sigs = sigs[1:]
+	}
+
+	for _, sig := range sigs {
+		err := rb.verifyRandBeaconSig(sig)
+		if err != nil {
+			return nil, err
+		}
+
+		rb.AddRandBeaconSig(sig, false)
+	}
+
+	return rb, nil
+}
+
+// VerifyRandBeacon verifies the random beacon from the checkpoint
+// against the genesis groups without the signatures before the
+// checkpoint, e.g., for a light client. sigs starts from the
+// signature of the checkpoint round, the signature after it verifies
+// the checkpoint. The random beacon output of the last round is
+// SHA3 of the last signature.
+func VerifyRandBeacon(genesis *Block, cp *RandBeaconCheckpoint, sigs []*RandBeaconSig, cfg Config) error {
+	if cp == nil {
+		return errors.New("no random beacon checkpoint")
+	}
+
+	_, err := replayRandBeacon(genesisSysState(genesis).groups, Rand{}, cp, sigs, cfg)
+	return err
+}
+
+// VerifyCheckpointLinks verifies that the checkpoints in the
+// increasing round order link to each other, so the older
+// checkpoints are verified by the last verified one.
+func VerifyCheckpointLinks(cps []*RandBeaconCheckpoint) error {
+	for i := 1; i < len(cps); i++ {
+		if cps[i].Prev != cps[i-1].Hash() {
+			return fmt.Errorf("checkpoint of round %d does not link to the checkpoint of round %d", cps[i].Round, cps[i-1].Round)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeRandBeaconSigs(t *testing.T, rb *RandomBeacon, sks []SK, rounds int) []*RandBeaconSig {
+	var sigs []*RandBeaconSig
+	for i := 0; i < rounds; i++ {
+		round := rb.Round()
+		g, _, _ := rb.Committees(round)
+		lastSigHash := SHA3(rb.RandBeaconSig(round).Sig)
+		s := &RandBeaconSig{Round: round + 1, LastSigHash: lastSigHash}
+		s.Sig = sks[g].Sign(rb.sigMsg(s.Round, lastSigHash))
+		assert.Nil(t, rb.verifyRandBeaconSig(s))
+		assert.True(t, rb.AddRandBeaconSig(s, false))
+		sigs = append(sigs, s)
+	}
+	return sigs
+}
+
+func TestRandBeaconCheckpoint(t *testing.T) {
+	sks := []SK{RandSK(), RandSK()}
+	groups := []*group{newGroup(sks[0].MustPK()), newGroup(sks[1].MustPK())}
+	cfg := Config{RandBeaconCheckpointInterval: 3}
+	seed := Rand(SHA3([]byte("seed")))
+	rb := NewRandomBeacon(seed, groups, cfg)
+	sigs := makeRandBeaconSigs(t, rb, sks, 8)
+
+	cps := rb.Checkpoints()
+	assert.Equal(t, 2, len(cps))
+	assert.Equal(t, uint64(3), cps[0].Round)
+	assert.Equal(t, uint64(6), cps[1].Round)
+	assert.Equal(t, SHA3(sigs[5].Sig), cps[1].SigHash)
+	assert.Nil(t, VerifyCheckpointLinks(cps))
+	assert.Nil(t, rb.Checkpoint(4))
+
+	// a full replay derives the same checkpoints.
+	full, err := replayRandBeacon(groups, seed, nil, sigs, cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, cps, full.Checkpoints())
+
+	// verify from the checkpoint without the earlier signatures,
+	// the earlier history is pruned.
+	pruned, err := replayRandBeacon(groups, Rand{}, cps[0], sigs[2:], cfg)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(8), pruned.Round())
+	assert.Nil(t, pruned.RandBeaconSig(2))
+	assert.Equal(t, sigs[7], pruned.RandBeaconSig(8))
+	a, b, c := rb.Committees(8)
+	pa, pb, pc := pruned.Committees(8)
+	assert.Equal(t, []int{a, b, c}, []int{pa, pb, pc})
+	assert.Equal(t, cps, pruned.Checkpoints())
+
+	// the signature after the checkpoint does not sign a forged
+	// checkpoint.
+	forged := *cps[0]
+	forged.NtRand = Rand{1}
+	_, err = replayRandBeacon(groups, Rand{}, &forged, sigs[2:], cfg)
+	assert.NotNil(t, err)
+
+	_, err = replayRandBeacon(groups, Rand{}, cps[0], sigs[2:3], cfg)
+	assert.NotNil(t, err, "checkpoint not verified")
+
+	assert.NotNil(t, VerifyCheckpointLinks([]*RandBeaconCheckpoint{cps[1], cps[0]}))
+}
+
+func TestBlockBeacon(t *testing.T) {
+	sks := []SK{RandSK(), RandSK()}
+	groups := []*group{newGroup(sks[0].MustPK()), newGroup(sks[1].MustPK())}
+	rb := NewRandomBeacon(Rand(SHA3([]byte("seed"))), groups, Config{RandBeaconCheckpointInterval: 3})
+	sigs := makeRandBeaconSigs(t, rb, sks, 4)
+	cps := rb.Checkpoints()
+
+	beacon, checkpoint, err := rb.BlockBeacon(2)
+	assert.Nil(t, err)
+	assert.Equal(t, SHA3(sigs[1].Sig), beacon)
+	assert.Equal(t, Hash{}, checkpoint, "before the first checkpoint")
+	for _, round := range []uint64{3, 4} {
+		beacon, checkpoint, err = rb.BlockBeacon(round)
+		assert.Nil(t, err)
+		assert.Equal(t, SHA3(sigs[round-1].Sig), beacon)
+		assert.Equal(t, cps[0].Hash(), checkpoint)
+	}
+
+	_, _, err = rb.BlockBeacon(5)
+	assert.NotNil(t, err, "not reached")
+
+	b := &Block{Round: 4, RandBeacon: beacon, RandCheckpoint: checkpoint}
+	assert.Nil(t, rb.validateBlockBeacon(b))
+	b.RandBeacon = SHA3(sigs[2].Sig)
+	assert.NotNil(t, rb.validateBlockBeacon(b))
+	b.RandBeacon, b.RandCheckpoint = beacon, Hash{}
+	assert.NotNil(t, rb.validateBlockBeacon(b))
+}
@@ -2,6 +2,7 @@ package consensus
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 
 	log "github.com/helinwang/log15"
@@ -25,7 +26,16 @@ type RandomBeacon struct {
 	ntRand Rand
 	bpRand Rand
 
+	// base is the round of the first signature in sigHistory and
+	// the first entries of the committee histories, it is not 0
+	// if the history before a checkpoint is pruned.
+	base       uint64
 	sigHistory []*RandBeaconSig
+	// checkpoints is the checkpoints in the increasing round
+	// order, digest is the hash chain of the signatures after
+	// the last checkpoint.
+	checkpoints []*RandBeaconCheckpoint
+	digest      Hash
 }
 
 // NewRandomBeacon creates a new random beacon
@@ -77,7 +87,7 @@ func (r *RandomBeacon) AddRandBeaconSigShares(shares []*RandBeaconSigShare, grou
 		return nil
 	}
 
-	msg := randBeaconSigMsg(s.Round, s.LastSigHash)
+	msg := randBeaconSigMsg(s.Round, s.LastSigHash, r.checkpoint(s.Round-1))
 	if !sig.Verify(r.groups[groupID].PK, msg) {
 		panic("impossible: random beacon group signature verification failed")
 	}
@@ -109,7 +119,11 @@ func (r *RandomBeacon) AddRandBeaconSig(s *RandBeaconSig, syncDone bool) bool {
 
 	r.deriveRand(SHA3(s.Sig))
 	r.sigHistory = append(r.sigHistory, s)
+	r.digest = digestRandBeaconSig(r.digest, s)
 	round := r.round()
+	if round%r.cfg.randBeaconCheckpointInterval() == 0 {
+		r.addCheckpoint(round, s)
+	}
 	if ch, ok := r.roundWaitCh[round]; ok {
 		close(ch)
 		delete(r.roundWaitCh, round)
@@ -121,8 +135,85 @@ func (r *RandomBeacon) AddRandBeaconSig(s *RandBeaconSig, syncDone bool) bool {
 	return true
 }
 
+func (r *RandomBeacon) addCheckpoint(round uint64, s *RandBeaconSig) {
+	cp := &RandBeaconCheckpoint{
+		Round:   round,
+		Digest:  r.digest,
+		SigHash: SHA3(s.Sig),
+		RBRand:  r.rbRand,
+		NtRand:  r.ntRand,
+		BPRand:  r.bpRand,
+	}
+	if len(r.checkpoints) > 0 {
+		cp.Prev = r.checkpoints[len(r.checkpoints)-1].Hash()
+	}
+
+	r.checkpoints = append(r.checkpoints, cp)
+	r.digest = Hash{}
+	log.Info("random beacon checkpoint", "round", round, "hash", cp.Hash())
+}
+
+// verifyRandBeaconSig verifies that the signature is the next random
+// beacon signature.
+func (r *RandomBeacon) verifyRandBeaconSig(s *RandBeaconSig) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	round := r.round()
+	if s.Round != round+1 {
+		return fmt.Errorf("random beacon signature of round %d does not follow round %d", s.Round, round)
+	}
+
+	if s.LastSigHash != SHA3(r.sigHistory[round-r.base].Sig) {
+		return fmt.Errorf("random beacon signature of round %d does not follow the last signature", s.Round)
+	}
+
+	g := r.groups[r.nextRBCmteHistory[round-r.base]]
+	if !s.Sig.Verify(g.PK, randBeaconSigMsg(s.Round, s.LastSigHash, r.checkpoint(round))) {
+		return fmt.Errorf("validate random beacon signature of round %d failed", s.Round)
+	}
+
+	return nil
+}
+
+// checkpoint returns the checkpoint of the round, nil if the round is
+// not a checkpoint round.
+func (r *RandomBeacon) checkpoint(round uint64) *RandBeaconCheckpoint {
+	i := sort.Search(len(r.checkpoints), func(i int) bool {
+		return r.checkpoints[i].Round >= round
+	})
+	if i == len(r.checkpoints) || r.checkpoints[i].Round != round {
+		return nil
+	}
+
+	return r.checkpoints[i]
+}
+
+// Checkpoint returns the checkpoint of the round, nil if the round is
+// not a checkpoint round.
+func (r *RandomBeacon) Checkpoint(round uint64) *RandBeaconCheckpoint {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.checkpoint(round)
+}
+
+// Checkpoints returns the checkpoints in the increasing round order.
+func (r *RandomBeacon) Checkpoints() []*RandBeaconCheckpoint {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.checkpoints
+}
+
+// sigMsg returns the message signed by the random beacon signature
+// of the round.
+func (r *RandomBeacon) sigMsg(round uint64, lastSigHash Hash) []byte {
+	return randBeaconSigMsg(round, lastSigHash, r.Checkpoint(round-1))
+}
+
 func (r *RandomBeacon) round() uint64 {
-	return uint64(len(r.sigHistory) - 1)
+	return r.base + uint64(len(r.sigHistory)-1)
 }
 
 // Round returns the round of the random beacon.
@@ -160,7 +251,7 @@ func (r *RandomBeacon) Rank(addr Addr, round uint64) (uint16, error) {
 	}
 
 	r.mu.Lock()
-	bp := r.nextBPCmteHistory[round]
+	bp := r.nextBPCmteHistory[round-r.base]
 	g := r.groups[bp]
 	idx := -1
 	for i := range g.Members {
@@ -175,7 +266,7 @@ func (r *RandomBeacon) Rank(addr Addr, round uint64) (uint16, error) {
 		return 0, fmt.Errorf("addr %v not in the current block proposal group %d, round: %d", addr, bp, round)
 	}
 
-	perm := r.nextBPRandHistory[round].Perm(idx+1, len(g.Members))
+	perm := r.nextBPRandHistory[round-r.base].Perm(idx+1, len(g.Members))
 	r.mu.Unlock()
 	return uint16(perm[idx]), nil
 }
@@ -190,29 +281,85 @@ func (r *RandomBeacon) deriveRand(h Hash) {
 	r.nextBPRandHistory = append(r.nextBPRandHistory, r.bpRand)
 }
 
+// HasRound returns true if the committees of the round are known,
+// i.e., the round is reached and not pruned.
+func (r *RandomBeacon) HasRound(round uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return round >= r.base && round <= r.round()
+}
+
 // Committees returns the current random beacon, block proposal,
-// notarization groups.
+// notarization groups. The round must not be before the pruned
+// history.
 func (r *RandomBeacon) Committees(round uint64) (rb, bp, nt int) {
 	r.mu.Lock()
-	rb = r.nextRBCmteHistory[round]
-	bp = r.nextBPCmteHistory[round]
-	nt = r.nextNtCmteHistory[round]
+	rb = r.nextRBCmteHistory[round-r.base]
+	bp = r.nextBPCmteHistory[round-r.base]
+	nt = r.nextNtCmteHistory[round-r.base]
 	r.mu.Unlock()
 	return
 }
 
+// TxnOrderSeed returns the seed that determines the canonical
+// transaction order of the block in the given round.
+func (r *RandomBeacon) TxnOrderSeed(round uint64) Rand {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.nextBPRandHistory[round-r.base].Derive([]byte("txn order seed"))
+}
+
+// RandBeaconSig returns the random beacon signature of the round,
+// nil if the round is not reached or pruned.
 func (r *RandomBeacon) RandBeaconSig(round uint64) *RandBeaconSig {
-	if round > r.round() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if round < r.base || round > r.round() {
 		return nil
 	}
 
-	return r.sigHistory[round]
+	return r.sigHistory[round-r.base]
 }
 
-// History returns the random beacon signature history.
-func (r *RandomBeacon) History() []*RandBeaconSig {
+// BlockBeacon returns the random beacon output hash of the round and
+// the hash of the latest checkpoint not after the round, for the
+// header of the block of the round.
+func (r *RandomBeacon) BlockBeacon(round uint64) (beacon, checkpoint Hash, err error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	return r.sigHistory
+	if round < r.base || round > r.round() {
+		return Hash{}, Hash{}, fmt.Errorf("random beacon of round %d is not available, base: %d, round: %d", round, r.base, r.round())
+	}
+
+	beacon = SHA3(r.sigHistory[round-r.base].Sig)
+	i := sort.Search(len(r.checkpoints), func(i int) bool {
+		return r.checkpoints[i].Round > round
+	})
+	if i > 0 {
+		checkpoint = r.checkpoints[i-1].Hash()
+	}
+	return beacon, checkpoint, nil
+}
+
+// validateBlockBeacon validates that the block is bound to the random
+// beacon chain.
+func (r *RandomBeacon) validateBlockBeacon(b *Block) error {
+	beacon, checkpoint, err := r.BlockBeacon(b.Round)
+	if err != nil {
+		return err
+	}
+
+	if b.RandBeacon != beacon {
+		return fmt.Errorf("block random beacon %v does not match round %d's %v", b.RandBeacon, b.Round, beacon)
+	}
+
+	if b.RandCheckpoint != checkpoint {
+		return fmt.Errorf("block random beacon checkpoint %v does not match round %d's %v", b.RandCheckpoint, b.Round, checkpoint)
+	}
+
+	return nil
 }
@@ -0,0 +1,47 @@
+package consensus
+
+import (
+	"sort"
+	"time"
+)
+
+// latencyFactor is the ratio of the adaptive round interval to the
+// median notarization latency of the recent rounds.
+const latencyFactor = 4
+
+// latencyRounds is the number of the recent rounds whose
+// notarization latency is measured.
+const latencyRounds = 10
+
+// RoundIntervalBounder is implemented by the application state that
+// bounds the adaptive round interval by governance.
+type RoundIntervalBounder interface {
+	// RoundIntervalBounds returns the bounds, ok is false if the
+	// round interval is fixed.
+	RoundIntervalBounds() (min, max time.Duration, ok bool)
+}
+
+// adaptiveInterval returns latencyFactor times the median of the
+// latencies within the bounds, max if no latency is measured.
+func adaptiveInterval(latencies []time.Duration, min, max time.Duration) time.Duration {
+	if len(latencies) == 0 {
+		return max
+	}
+
+	sorted := make([]time.Duration, len(latencies))
+	copy(sorted, latencies)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	d := latencyFactor * sorted[len(sorted)/2]
+	if d < min {
+		return min
+	}
+
+	if d > max {
+		return max
+	}
+
+	return d
+}
@@ -0,0 +1,16 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdaptiveInterval(t *testing.T) {
+	ms := time.Millisecond
+	assert.Equal(t, 800*ms, adaptiveInterval(nil, 200*ms, 800*ms))
+	assert.Equal(t, 400*ms, adaptiveInterval([]time.Duration{500 * ms, 100 * ms, 90 * ms}, 200*ms, 800*ms))
+	assert.Equal(t, 200*ms, adaptiveInterval([]time.Duration{10 * ms}, 200*ms, 800*ms))
+	assert.Equal(t, 800*ms, adaptiveInterval([]time.Duration{time.Second}, 200*ms, 800*ms))
+}
@@ -52,15 +52,22 @@ func recoverRandBeaconSig(shares []*RandBeaconSigShare) (Sig, error) {
 	return Sig(sign.Serialize()), nil
 }
 
-func randBeaconSigMsg(round uint64, lastSigHash Hash) []byte {
+// randBeaconSigMsg returns the message of the random beacon signature,
+// the signature following a checkpoint signs the checkpoint as well.
+func randBeaconSigMsg(round uint64, lastSigHash Hash, cp *RandBeaconCheckpoint) []byte {
 	var rbs RandBeaconSig
 	rbs.LastSigHash = lastSigHash
 	rbs.Round = round
-	return rbs.Encode(false)
+	msg := rbs.Encode(false)
+	if cp != nil {
+		h := cp.Hash()
+		msg = append(msg, h[:]...)
+	}
+	return msg
 }
 
-func signRandBeaconSigShare(sk, keyShare SK, round uint64, lastSigHash Hash) *RandBeaconSigShare {
-	msg := randBeaconSigMsg(round, lastSigHash)
+func signRandBeaconSigShare(sk, keyShare SK, round uint64, lastSigHash Hash, cp *RandBeaconCheckpoint) *RandBeaconSigShare {
+	msg := randBeaconSigMsg(round, lastSigHash, cp)
 	share := keyShare.Sign(msg)
 	s := &RandBeaconSigShare{
 		Owner:       sk.MustPK().Addr(),
@@ -0,0 +1,560 @@
+package consensus
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// simNet is a simulated network for the consensus scenario tests. The
+// latency, the drops and the partitions of the packets are decided
+// by a seeded random source in the order that the packets are sent,
+// and the packets are delivered in the order of the delivery time,
+// the ties are broken by the send order.
+type simNet struct {
+	mu         sync.Mutex
+	rand       *rand.Rand
+	seq        uint64
+	queue      simQueue
+	wake       chan struct{}
+	stopped    bool
+	transports map[string]*simTransport
+	// latency is the base latency, a random jitter in [0,
+	// jitter) is added to each packet.
+	latency time.Duration
+	jitter  time.Duration
+	// dropRate is the probability of dropping a packet.
+	dropRate float64
+	// partition is the partition of the nodes, the nodes of
+	// different partitions can not reach each other.
+	partition map[string]int
+	// tamper modifies or drops (returns false) the packet sent
+	// from the node, it simulates the byzantine nodes.
+	tamper func(from string, p packet) (packet, bool)
+}
+
+type simPacket struct {
+	at  time.Time
+	seq uint64
+	to  *simTransport
+	pac packetAndAddr
+}
+
+type simQueue []*simPacket
+
+func (q simQueue) Len() int { return len(q) }
+
+func (q simQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+
+func (q simQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *simQueue) Push(x interface{}) { *q = append(*q, x.(*simPacket)) }
+
+func (q *simQueue) Pop() interface{} {
+	old := *q
+	p := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return p
+}
+
+func newSimNet(seed int64) *simNet {
+	n := &simNet{
+		rand:       rand.New(rand.NewSource(seed)),
+		wake:       make(chan struct{}, 1),
+		transports: make(map[string]*simTransport),
+		partition:  make(map[string]int),
+	}
+	go n.dispatch()
+	return n
+}
+
+func (n *simNet) transport(addr string) *simTransport {
+	t := &simTransport{net: n, addr: unicastAddr{Addr: addr}, ch: make(chan packetAndAddr, 1000)}
+	n.mu.Lock()
+	n.transports[addr] = t
+	n.mu.Unlock()
+	return t
+}
+
+// setPartition moves the nodes to the partition, the nodes are all in
+// the partition 0 initially.
+func (n *simNet) setPartition(p int, addrs ...string) {
+	n.mu.Lock()
+	for _, a := range addrs {
+		n.partition[a] = p
+	}
+	n.mu.Unlock()
+}
+
+// heal puts all nodes back into the same partition. The nodes of
+// different partitions reconnect, like the peers whose connections
+// broke during the partition.
+func (n *simNet) heal() {
+	n.mu.Lock()
+	partition := n.partition
+	n.partition = make(map[string]int)
+	var reconnect [][2]*simTransport
+	for _, a := range n.transports {
+		for _, b := range n.transports {
+			if partition[a.addr.Addr] != partition[b.addr.Addr] {
+				reconnect = append(reconnect, [2]*simTransport{a, b})
+			}
+		}
+	}
+	n.mu.Unlock()
+
+	for _, pair := range reconnect {
+		pair[0].connect(pair[1])
+	}
+}
+
+// schedule decides the delivery of the packet, it returns false if
+// the packet is dropped.
+//
+// must be called with mutex held
+func (n *simNet) schedule(from, to string, now time.Time) (time.Time, bool) {
+	// always draw the same number of random values, so the
+	// decisions of the later packets do not depend on whether
+	// the packet is dropped.
+	drop := n.rand.Float64() < n.dropRate
+	var jitter time.Duration
+	if n.jitter > 0 {
+		jitter = time.Duration(n.rand.Int63n(int64(n.jitter)))
+	}
+
+	if drop || n.partition[from] != n.partition[to] {
+		return time.Time{}, false
+	}
+
+	return now.Add(n.latency + jitter), true
+}
+
+func (n *simNet) send(from *simTransport, to *simTransport, p packet) {
+	// the peers never share the memory of a packet.
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(p)
+	if err != nil {
+		panic(err)
+	}
+
+	n.mu.Lock()
+	if n.stopped {
+		n.mu.Unlock()
+		return
+	}
+
+	at, ok := n.schedule(from.addr.Addr, to.addr.Addr, time.Now())
+	if !ok {
+		n.mu.Unlock()
+		return
+	}
+
+	var dup packet
+	err = gob.NewDecoder(&buf).Decode(&dup)
+	if err != nil {
+		panic(err)
+	}
+
+	n.seq++
+	heap.Push(&n.queue, &simPacket{at: at, seq: n.seq, to: to, pac: packetAndAddr{A: from.addr, P: dup}})
+	n.mu.Unlock()
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stop stops delivering the packets, the nodes on the network become
+// idle.
+func (n *simNet) stop() {
+	n.mu.Lock()
+	n.stopped = true
+	n.queue = nil
+	n.mu.Unlock()
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (n *simNet) dispatch() {
+	for {
+		n.mu.Lock()
+		if n.stopped {
+			n.mu.Unlock()
+			return
+		}
+
+		var wait time.Duration = time.Hour
+		for n.queue.Len() > 0 {
+			p := n.queue[0]
+			if d := time.Until(p.at); d > 0 {
+				wait = d
+				break
+			}
+
+			heap.Pop(&n.queue)
+			p.to.ch <- p.pac
+		}
+		n.mu.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-n.wake:
+		}
+	}
+}
+
+type simTransport struct {
+	net           *simNet
+	addr          unicastAddr
+	ch            chan packetAndAddr
+	onPeerConnect func(addr unicastAddr)
+
+	mu    sync.Mutex
+	peers map[string]*simTransport
+}
+
+func (t *simTransport) Start(host string, port int) (unicastAddr, error) {
+	return t.addr, nil
+}
+
+// ConnectSeed connects to all the nodes on the simulated network.
+func (t *simTransport) ConnectSeed(addr string) error {
+	t.net.mu.Lock()
+	var peers []*simTransport
+	for _, p := range t.net.transports {
+		if p != t {
+			peers = append(peers, p)
+		}
+	}
+	t.net.mu.Unlock()
+
+	for _, p := range peers {
+		t.connect(p)
+		p.connect(t)
+	}
+	return nil
+}
+
+func (t *simTransport) connect(p *simTransport) {
+	t.mu.Lock()
+	if t.peers == nil {
+		t.peers = make(map[string]*simTransport)
+	}
+	t.peers[p.addr.Addr] = p
+	t.mu.Unlock()
+
+	if t.onPeerConnect != nil {
+		go t.onPeerConnect(p.addr)
+	}
+}
+
+func (t *simTransport) Send(addr netAddr, p packet) error {
+	if t.net.tamper != nil {
+		var ok bool
+		p, ok = t.net.tamper(t.addr.Addr, p)
+		if !ok {
+			return nil
+		}
+	}
+
+	t.mu.Lock()
+	var peers []*simTransport
+	switch v := addr.(type) {
+	case unicastAddr:
+		peer, ok := t.peers[v.Addr]
+		if !ok {
+			t.mu.Unlock()
+			return fmt.Errorf("not connected to %s", v.Addr)
+		}
+		peers = append(peers, peer)
+	case broadcast:
+		for _, peer := range t.peers {
+			peers = append(peers, peer)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, peer := range peers {
+		t.net.send(t, peer, p)
+	}
+	return nil
+}
+
+func (t *simTransport) Recv() (unicastAddr, packet) {
+	p := <-t.ch
+	return p.A, p.P
+}
+
+func (t *simTransport) Peers() []unicastAddr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var r []unicastAddr
+	for _, p := range t.peers {
+		r = append(r, p.addr)
+	}
+	return r
+}
+
+// simState is the state whose hash is derived from the round, the
+// simulated nodes agree on the state roots without a txn engine.
+type simState struct {
+	round uint64
+}
+
+func (s *simState) Hash() Hash {
+	return SHA3([]byte(fmt.Sprintf("sim state %d", s.round)))
+}
+
+func (s *simState) Transition(round, timestamp uint64, proposerPK []byte) Transition {
+	return &simTransition{round: round}
+}
+
+func (s *simState) Serialize() (TrieBlob, error) { return TrieBlob{}, nil }
+
+func (s *simState) Deserialize(TrieBlob) error { return nil }
+
+func (s *simState) CommitCache() {}
+
+func (s *simState) CommitTxns(txns []byte, pool TxnPool, round, timestamp uint64, seed Rand) (State, int, error) {
+	return &simState{round: round}, 0, nil
+}
+
+type simTransition struct {
+	round uint64
+}
+
+func (t *simTransition) Record(*Txn) error { return nil }
+
+func (t *simTransition) Txns() []byte { return nil }
+
+func (t *simTransition) Commit() State { return &simState{round: t.round} }
+
+func (t *simTransition) StateHash() Hash { return t.Commit().Hash() }
+
+type simTxnPool struct{}
+
+func (simTxnPool) Add(b []byte) (*Txn, bool) { return nil, false }
+
+func (simTxnPool) Get(hash Hash) *Txn { return nil }
+
+func (simTxnPool) NotSeen(hash Hash) bool { return true }
+
+func (simTxnPool) Txns() []*Txn { return nil }
+
+func (simTxnPool) Remove(hash Hash) {}
+
+func (simTxnPool) Size() int { return 0 }
+
+// makeSimNodes starts the nodes on the simulated network, the nodes
+// are in 4 groups of size 3 with threshold 2.
+func makeSimNodes(net *simNet, numNode int) []*Node {
+	return makeSimNodesWithReplicas(net, numNode, 0)
+}
+
+// makeSimNodesWithReplicas starts the nodes and the read-only
+// replicas after them on the simulated network.
+func makeSimNodesWithReplicas(net *simNet, numNode, numReplica int) []*Node {
+	cfg := Config{BlockTime: 100 * time.Millisecond, GroupSize: 3, GroupThreshold: 2}
+	groups := MakeGenesisGroups(numNode, 4, 3, 2, Rand(SHA3([]byte("sim"))))
+	genesis := &Block{StateRoot: (&simState{}).Hash(), SysTxns: groups.SysTxns}
+	credentials := groups.Nodes
+	for i := 0; i < numReplica; i++ {
+		credentials = append(credentials, ReplicaCredentials())
+	}
+
+	nodes := make([]*Node, len(credentials))
+	for i, c := range credentials {
+		store := newStorage()
+		chain := NewChain(genesis, &simState{}, Rand(SHA3([]byte("dex"))), cfg, simTxnPool{}, &myUpdater{}, store, nil)
+		t := net.transport(fmt.Sprintf("sim-%d", i))
+		nodes[i] = assembleNode(c, cfg, chain, store, t)
+		t.onPeerConnect = nodes[i].gateway.onPeerConnect
+	}
+
+	for _, n := range nodes {
+		err := n.Start("", 0, "sim")
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	for _, n := range nodes {
+		n.EndRound(0)
+	}
+	return nodes
+}
+
+func waitRound(nodes []*Node, round uint64, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for {
+		done := true
+		for _, n := range nodes {
+			if n.Chain().FinalizedRound() < round {
+				done = false
+			}
+		}
+
+		if done {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestSimNetSchedule(t *testing.T) {
+	now := time.Now()
+	decisions := func() []time.Time {
+		n := &simNet{rand: rand.New(rand.NewSource(1)), partition: map[string]int{"c": 1}, jitter: time.Second, dropRate: 0.3}
+		var r []time.Time
+		for i := 0; i < 20; i++ {
+			at, _ := n.schedule("a", []string{"b", "c"}[i%2], now)
+			r = append(r, at)
+		}
+		return r
+	}
+
+	r := decisions()
+	assert.Equal(t, r, decisions())
+	for i := 1; i < len(r); i += 2 {
+		assert.True(t, r[i].IsZero(), "partitioned packets are dropped")
+	}
+}
+
+func TestSimLatency(t *testing.T) {
+	net := newSimNet(1)
+	defer net.stop()
+	net.latency = 5 * time.Millisecond
+	net.jitter = 10 * time.Millisecond
+	nodes := makeSimNodes(net, 4)
+	assert.True(t, waitRound(nodes, 5, 20*time.Second))
+}
+
+func TestSimDebugState(t *testing.T) {
+	net := newSimNet(5)
+	defer net.stop()
+	net.latency = time.Millisecond
+	nodes := makeSimNodes(net, 4)
+	assert.True(t, waitRound(nodes, 3, 20*time.Second))
+
+	s := nodes[0].DebugState()
+	assert.True(t, s.Round >= 3)
+	assert.True(t, s.RandBeaconDepth >= s.FinalizedRound)
+	assert.Equal(t, []string{"sim-1", "sim-2", "sim-3"}, s.Peers)
+	assert.NotEmpty(t, s.Groups)
+	assert.NotEmpty(t, s.Committees)
+	last := s.Committees[len(s.Committees)-1]
+	assert.Equal(t, s.RandBeaconDepth, last.Round)
+}
+
+func TestSimCommitteeAssignment(t *testing.T) {
+	net := newSimNet(6)
+	defer net.stop()
+	net.latency = time.Millisecond
+	nodes := makeSimNodes(net, 4)
+	assert.True(t, waitRound(nodes, 2, 20*time.Second))
+
+	a, err := nodes[0].CommitteeAssignment(2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), a.Round)
+	assert.Equal(t, 3, len(a.RandBeacon))
+	assert.Equal(t, 3, len(a.Notarization))
+	for i, m := range a.Proposal {
+		assert.Equal(t, uint16(i), m.Rank)
+	}
+
+	_, err = nodes[0].CommitteeAssignment(math.MaxUint64)
+	assert.NotNil(t, err)
+}
+
+func TestSimSilentProposer(t *testing.T) {
+	net := newSimNet(4)
+	defer net.stop()
+	net.latency = time.Millisecond
+	net.tamper = func(from string, p packet) (packet, bool) {
+		if _, ok := p.Data.(*BlockProposal); ok && from == "sim-0" {
+			return p, false
+		}
+		return p, true
+	}
+	nodes := makeSimNodes(net, 4)
+	assert.True(t, waitRound(nodes, 5, 20*time.Second))
+}
+
+func TestSimPartition(t *testing.T) {
+	net := newSimNet(2)
+	defer net.stop()
+	net.latency = time.Millisecond
+	nodes := makeSimNodes(net, 4)
+	assert.True(t, waitRound(nodes, 3, 20*time.Second))
+
+	// the chain stalls when the committee of a round can not
+	// reach the threshold within a partition, and recovers once
+	// the partition heals.
+	net.setPartition(1, "sim-0", "sim-1")
+	time.Sleep(time.Second)
+	net.heal()
+	assert.True(t, waitRound(nodes, nodes[0].Chain().FinalizedRound()+3, 20*time.Second))
+}
+
+func TestSimByzantineShares(t *testing.T) {
+	net := newSimNet(3)
+	defer net.stop()
+	net.latency = time.Millisecond
+	net.tamper = func(from string, p packet) (packet, bool) {
+		if from != "sim-0" {
+			return p, true
+		}
+
+		if s, ok := p.Data.(*NtShare); ok {
+			dup := *s
+			dup.SigShare = append(Sig(nil), s.SigShare...)
+			dup.SigShare[len(dup.SigShare)-1] ^= 1
+			return packet{Data: &dup}, true
+		}
+		return p, true
+	}
+	nodes := makeSimNodes(net, 4)
+	assert.True(t, waitRound(nodes, 5, 20*time.Second))
+}
+
+func TestSimReplica(t *testing.T) {
+	net := newSimNet(6)
+	defer net.stop()
+	net.latency = time.Millisecond
+	nodes := makeSimNodesWithReplicas(net, 4, 1)
+	replica := nodes[4]
+	assert.True(t, replica.Replica())
+	assert.False(t, nodes[0].Replica())
+	// the replica follows the chain that the other nodes produce.
+	assert.True(t, waitRound(nodes, 5, 20*time.Second))
+
+	s := replica.DebugState()
+	assert.True(t, s.Replica)
+	assert.Empty(t, s.Groups)
+	assert.Empty(t, s.NotarizingRounds)
+}
@@ -0,0 +1,146 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Snapshot is a finalized block with its serialized state, a new
+// node bootstraps from it without replaying the chain. The random
+// beacon signatures are the history from the random beacon
+// checkpoint, or from the genesis if there is no checkpoint, up to
+// the block's round. They derive the committees, including the
+// notarization committee of the block, from the genesis groups.
+type Snapshot struct {
+	NetworkID NetworkID
+	Genesis   Block
+	Block     Block
+	// RandBeaconCheckpoint is the last random beacon checkpoint
+	// before the block's round, RandBeaconSigs starts from the
+	// signature of its round. It's nil if there is no
+	// checkpoint, RandBeaconSigs starts from round 1.
+	RandBeaconCheckpoint *RandBeaconCheckpoint
+	RandBeaconSigs       []*RandBeaconSig
+	State                TrieBlob
+}
+
+// Snapshot returns the snapshot of the latest finalized block.
+func (c *Chain) Snapshot() (*Snapshot, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	round := uint64(len(c.finalized) - 1)
+	state, err := c.lastFinalizedState.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	rb := c.randomBeacon
+	if rb.Round() < round {
+		return nil, errors.New("random beacon history is behind the finalized round")
+	}
+
+	var cp *RandBeaconCheckpoint
+	from := uint64(1)
+	cps := rb.Checkpoints()
+	for i := len(cps) - 1; i >= 0; i-- {
+		if cps[i].Round < round {
+			cp = cps[i]
+			from = cp.Round
+			break
+		}
+	}
+
+	var sigs []*RandBeaconSig
+	for r := from; r <= round; r++ {
+		sig := rb.RandBeaconSig(r)
+		if sig == nil {
+			return nil, fmt.Errorf("random beacon signature of round %d is pruned", r)
+		}
+		sigs = append(sigs, sig)
+	}
+
+	return &Snapshot{
+		NetworkID:            c.cfg.NetworkID,
+		Genesis:              *c.store.Block(c.finalized[0]),
+		Block:                *c.store.Block(c.finalized[round]),
+		RandBeaconCheckpoint: cp,
+		RandBeaconSigs:       sigs,
+		State:                state,
+	}, nil
+}
+
+// NewChainFromSnapshot creates a new chain whose latest finalized
+// block is the snapshot's block. The random beacon signatures, the
+// block notarization and the state root are verified against the
+// genesis groups. The state must be deserialized from the snapshot.
+// The blocks between the genesis and the snapshot block are unknown
+// to the chain, and the random beacon history before the checkpoint
+// is pruned.
+func NewChainFromSnapshot(snapshot *Snapshot, state State, seed Rand, cfg Config, txnPool TxnPool, u Updater, store *storage, proposerPK []byte) (*Chain, error) {
+	genesis := &snapshot.Genesis
+	b := &snapshot.Block
+	from := uint64(1)
+	if cp := snapshot.RandBeaconCheckpoint; cp != nil {
+		if cp.Round >= b.Round {
+			return nil, fmt.Errorf("random beacon checkpoint round %d is not before snapshot block round %d", cp.Round, b.Round)
+		}
+		from = cp.Round
+	}
+
+	if b.Round+1 != from+uint64(len(snapshot.RandBeaconSigs)) {
+		return nil, fmt.Errorf("snapshot block round %d does not match random beacon signature count %d", b.Round, len(snapshot.RandBeaconSigs))
+	}
+
+	if state.Hash() != b.StateRoot {
+		return nil, fmt.Errorf("snapshot state hash and block state root does not match, state hash: %v, block state root: %v", state.Hash(), b.StateRoot)
+	}
+
+	sysState := genesisSysState(genesis)
+	rb, err := replayRandBeacon(sysState.groups, seed, snapshot.RandBeaconCheckpoint, snapshot.RandBeaconSigs, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.Round > 0 {
+		_, _, nt := rb.Committees(b.Round)
+		if !b.Notarization.Verify(rb.groups[nt].PK, b.Encode(false)) {
+			return nil, fmt.Errorf("validate snapshot block group sig failed, group: %d", nt)
+		}
+	} else if b.Hash() != genesis.Hash() {
+		return nil, errors.New("snapshot block of round 0 is not the genesis block")
+	}
+
+	u.Update(state)
+	gh := genesis.Hash()
+	h := b.Hash()
+	store.AddBlock(genesis, gh)
+	store.AddBlock(b, h)
+
+	// the hashes of the unknown blocks between the genesis and
+	// the snapshot block are left zero.
+	finalized := make([]Hash, b.Round+1)
+	finalized[0] = gh
+	finalized[b.Round] = h
+	return &Chain{
+		cfg:                   cfg,
+		proposerPK:            proposerPK,
+		store:                 store,
+		updater:               u,
+		txnPool:               txnPool,
+		randomBeacon:          rb,
+		finalized:             finalized,
+		lastFinalizedState:    state,
+		lastFinalizedSysState: sysState,
+		unFinalizedState:      make(map[Hash]State),
+		bestRanks:             make(map[uint64]uint16),
+		roundWaitCh:           make(map[uint64]chan struct{}),
+		exec:                  newExecCache(),
+		lastEndRoundTime:      time.Now(),
+		tip:                   h,
+		headNotify:            make(chan struct{}),
+		txnBlocks:             make(map[Hash][]txnBlock),
+		roundTxns:             make(map[uint64][]Hash),
+	}, nil
+}
@@ -0,0 +1,33 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	genesis := &Block{Owner: Addr{1}}
+	chain := NewChain(genesis, &myState{}, Rand{}, Config{}, nil, &myUpdater{}, newStorage(), nil)
+	snapshot, err := chain.Snapshot()
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, *genesis, snapshot.Block)
+	assert.Equal(t, 0, len(snapshot.RandBeaconSigs))
+
+	restored, err := NewChainFromSnapshot(snapshot, &myState{}, Rand{}, Config{}, nil, &myUpdater{}, newStorage(), nil)
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, chain.Finality(), restored.Finality())
+
+	snapshot.Block.StateRoot = Hash{1}
+	_, err = NewChainFromSnapshot(snapshot, &myState{}, Rand{}, Config{}, nil, &myUpdater{}, newStorage(), nil)
+	assert.NotNil(t, err)
+
+	snapshot.Block = Block{Round: 1}
+	_, err = NewChainFromSnapshot(snapshot, &myState{}, Rand{}, Config{}, nil, &myUpdater{}, newStorage(), nil)
+	assert.NotNil(t, err)
+}
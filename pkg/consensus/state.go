@@ -5,11 +5,22 @@ import "errors"
 // State is the blockchain state.
 type State interface {
 	Hash() Hash
-	Transition(round uint64, proposerPK []byte) Transition
+	// Transition returns the transition of the block of the
+	// round, the timestamp is the block's in Unix milliseconds.
+	Transition(round, timestamp uint64, proposerPK []byte) Transition
 	Serialize() (TrieBlob, error)
 	Deserialize(TrieBlob) error
 	CommitCache()
-	CommitTxns([]byte, TxnPool, uint64) (State, int, error)
+	// CommitTxns applies the serialized transactions of the
+	// block of the round and the timestamp, the transactions
+	// must be in the canonical order determined by the seed.
+	CommitTxns(txns []byte, pool TxnPool, round, timestamp uint64, seed Rand) (State, int, error)
+}
+
+// Finalizer is implemented by the application state that is notified
+// when the block that produced the state is finalized.
+type Finalizer interface {
+	Finalized()
 }
 
 var ErrTxnNonceTooBig = errors.New("txn's nonce is too big, but txn can be used for future")
@@ -19,7 +30,8 @@ type Transition interface {
 	// Record records a transition to the state transition.
 	Record(*Txn) error
 
-	// Txns returns the serialized recorded transactions.
+	// Txns returns the serialized recorded transactions, the
+	// rlp encoded list of the raw transactions, see DecodeTxns.
 	Txns() []byte
 
 	// Commit commits the transition, creating a new state.
@@ -35,8 +47,19 @@ type Txn struct {
 	Decoded     interface{}
 	MinerFeeTxn bool
 	Owner       Addr
-	Nonce       uint64
-	Raw         []byte
+	// Lane is the owner's nonce lane of the txn, the nonces of a
+	// lane are sequential and independent of the other lanes.
+	Lane  uint8
+	Nonce uint64
+	// ValidUntil is the last round that the txn can be included
+	// in, 0 means no limit.
+	ValidUntil uint64
+	Raw        []byte
+}
+
+// Expired returns true if the txn can not be included in the round.
+func (t *Txn) Expired(round uint64) bool {
+	return t.ValidUntil != 0 && t.ValidUntil < round
 }
 
 // TxnPool is the pool that stores the received transactions.
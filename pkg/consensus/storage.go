@@ -2,6 +2,8 @@ package consensus
 
 import (
 	"sync"
+
+	log "github.com/helinwang/log15"
 )
 
 // storage stores the blockchain data.
@@ -20,6 +22,9 @@ type storage struct {
 	lastRoundRandBeaconSig      map[Hash]*RandBeaconSig
 	lastRandBeaconSigShareRound uint64
 	lastRoundRandBeaconSigShare map[Hash]*RandBeaconSigShare
+	// cold stores the archived blocks and block proposals, it is
+	// nil when the blocks are only kept in memory.
+	cold *coldStore
 }
 
 func newStorage() *storage {
@@ -65,6 +70,14 @@ func (s *storage) Block(h Hash) *Block {
 	s.mu.Lock()
 	b := s.blocks[h]
 	s.mu.Unlock()
+	if b != nil || s.cold == nil {
+		return b
+	}
+
+	b, err := s.cold.Block(h)
+	if err != nil {
+		log.Error("read block from cold storage error", "hash", h, "err", err)
+	}
 	return b
 }
 
@@ -72,9 +85,53 @@ func (s *storage) BlockProposal(h Hash) *BlockProposal {
 	s.mu.Lock()
 	b := s.blockProposals[h]
 	s.mu.Unlock()
+	if b != nil || s.cold == nil {
+		return b
+	}
+
+	b, err := s.cold.BlockProposal(h)
+	if err != nil {
+		log.Error("read block proposal from cold storage error", "hash", h, "err", err)
+	}
 	return b
 }
 
+// Archive moves the finalized block and its block proposal from
+// memory to the cold storage. It is a no-op when there is no cold
+// storage.
+func (s *storage) Archive(h Hash) error {
+	if s.cold == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	b := s.blocks[h]
+	if b == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	bp := s.blockProposals[b.BlockProposal]
+	s.mu.Unlock()
+
+	if bp != nil {
+		err := s.cold.PutBlockProposal(bp, b.BlockProposal)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.cold.PutBlock(b, h)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.blocks, h)
+	delete(s.blockProposals, b.BlockProposal)
+	s.mu.Unlock()
+	return nil
+}
+
 func (s *storage) keepLastRoundBlock(b *Block, h Hash) {
 	if b.Round < s.lastBlockRound {
 		return
@@ -124,6 +124,16 @@ func (s *syncer) syncBlock(addr unicastAddr, hash Hash, round uint64) (b *Block,
 		return
 	}
 
+	if b.Timestamp != bp.Timestamp {
+		err = errors.New("block timestamp does not match the block proposal's")
+		return
+	}
+
+	err = s.chain.randomBeacon.validateBlockBeacon(b)
+	if err != nil {
+		return
+	}
+
 	_, _, nt := s.chain.randomBeacon.Committees(b.Round)
 	success := b.Notarization.Verify(s.chain.randomBeacon.groups[nt].PK, b.Encode(false))
 	if !success {
@@ -131,15 +141,19 @@ func (s *syncer) syncBlock(addr unicastAddr, hash Hash, round uint64) (b *Block,
 		return
 	}
 
-	rank, err := s.chain.randomBeacon.Rank(b.Owner, b.Round)
-	if err != nil {
-		err = fmt.Errorf("error get rank, but group sig is valid: %v", err)
-		return
+	var rank uint16
+	if !bp.Empty() {
+		var rankErr error
+		rank, rankErr = s.chain.randomBeacon.Rank(b.Owner, b.Round)
+		if rankErr != nil {
+			err = fmt.Errorf("error get rank, but group sig is valid: %v", rankErr)
+			return
+		}
 	}
-	weight = rankToWeight(rank)
+	weight = s.chain.blockWeight(b.Round, rank, bp.Empty())
 
 	state := s.chain.BlockState(b.PrevBlock)
-	newState, count, err := state.CommitTxns(bp.Txns, s.chain.txnPool, bp.Round)
+	newState, count, err := s.chain.commitTxns(state, bp, s.chain.txnPool)
 	if err != nil {
 		return
 	}
@@ -149,6 +163,16 @@ func (s *syncer) syncBlock(addr unicastAddr, hash Hash, round uint64) (b *Block,
 		return
 	}
 
+	txnRoot, err := TxnRoot(bp.Txns)
+	if err != nil {
+		return
+	}
+
+	if txnRoot != b.TxnRoot {
+		err = errors.New("invalid txn root")
+		return
+	}
+
 	broadcast, err = s.chain.AddBlock(b, newState, weight, count)
 	if err != nil {
 		return
@@ -157,6 +181,11 @@ func (s *syncer) syncBlock(addr unicastAddr, hash Hash, round uint64) (b *Block,
 	return
 }
 
+// emptyBlockWeight is the weight of the empty block, lower than the
+// weight of any proposed block. It must be positive, otherwise a fork
+// of empty blocks has no heaviest block.
+const emptyBlockWeight = math.SmallestNonzeroFloat64
+
 func rankToWeight(rank uint16) float64 {
 	if rank < 0 {
 		panic(rank)
@@ -197,27 +226,49 @@ func (s *syncer) SyncBlockProposal(addr unicastAddr, hash Hash) (bp *BlockPropos
 		return
 	}
 
-	// make sure proposer is in the current proposal group
-	_, err = s.chain.randomBeacon.Rank(bp.Owner, bp.Round)
+	err = validateTimestamp(bp, prev, time.Now())
 	if err != nil {
 		return
 	}
 
-	pk, ok := s.chain.lastFinalizedSysState.addrToPK[bp.Owner]
-	if !ok {
-		err = errors.New("block proposal owner not found")
-		return
-	}
+	var rank uint16
+	if bp.Empty() {
+		err = validateEmptyProposal(bp, hash, prev)
+		if err != nil {
+			return
+		}
+	} else {
+		// make sure proposer is in the current proposal group
+		rank, err = s.chain.randomBeacon.Rank(bp.Owner, bp.Round)
+		if err != nil {
+			return
+		}
 
-	if !bp.OwnerSig.Verify(pk, bp.Encode(false)) {
-		err = errors.New("invalid block proposal signature")
-		return
+		pk, ok := s.chain.lastFinalizedSysState.addrToPK[bp.Owner]
+		if !ok {
+			err = errors.New("block proposal owner not found")
+			return
+		}
+
+		if !bp.OwnerSig.Verify(pk, bp.Encode(false)) {
+			err = errors.New("invalid block proposal signature")
+			return
+		}
 	}
 
 	broadcast = s.store.AddBlockProposal(bp, hash)
+	if !bp.Empty() {
+		s.chain.seeProposal(bp.Round, rank)
+	}
 
 	if broadcast {
-		go s.node.recvBPForNotary(bp)
+		// the notaries derive the empty block proposal
+		// themselves when the round times out, a received
+		// one is not notarized before that.
+		if !bp.Empty() {
+			go s.node.recvBPForNotary(bp)
+		}
+		go s.chain.preExecute(bp)
 	}
 	return
 }
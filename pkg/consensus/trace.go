@@ -0,0 +1,31 @@
+package consensus
+
+// Tracer receives the spans of the block and txn executions, e.g.,
+// to export them as OpenTelemetry spans. The spans are tagged with
+// the round, the block proposal hash and the txn hash, which are the
+// same on all the nodes, so a txn can be traced across the nodes.
+type Tracer interface {
+	// StartSpan starts the span with the key value pairs, the
+	// returned function ends the span with its error.
+	StartSpan(name string, ctx ...interface{}) (end func(err error))
+}
+
+var tracer Tracer
+
+// SetTracer sets the tracer of the spans, nil disables the tracing.
+// It must be called before the node starts.
+func SetTracer(t Tracer) {
+	tracer = t
+}
+
+func endNothing(error) {}
+
+// StartSpan starts the span with the tracer, the returned function
+// ends the span with its error.
+func StartSpan(name string, ctx ...interface{}) (end func(err error)) {
+	if tracer == nil {
+		return endNothing
+	}
+
+	return tracer.StartSpan(name, ctx...)
+}
@@ -0,0 +1,89 @@
+package consensus
+
+import (
+	"bytes"
+	"sort"
+)
+
+// TxnOrder is the position of a transaction in the canonical
+// transaction order of a block.
+//
+// The transactions are ordered by the hash of the owner salted with
+// the round's random seed, then by the nonce lane and the nonce, and
+// then by the hash of the transaction. A block whose transactions are
+// not in this order fails the validation, so the proposer can not
+// freely arrange the transactions it includes.
+//
+// It does not prevent front-running: the seed is derived from the
+// round's random beacon, which the proposer knows when it builds the
+// block. The proposer can choose which transactions to include, and
+// can grind the owner addresses of its own transactions so that they
+// are ordered before the transactions of the others.
+//
+// Conflicting transactions of the same owner, lane and nonce are
+// ordered by their hash, every proposer records the one with the
+// smaller hash and drops the others, regardless of the order in which
+// they arrived.
+type TxnOrder struct {
+	Key   Hash
+	Lane  uint8
+	Nonce uint64
+	Txn   Hash
+}
+
+// NewTxnOrder returns the order of the transaction.
+func NewTxnOrder(txn *Txn, seed Rand) TxnOrder {
+	return TxnOrder{Key: SHA3(seed[:], txn.Owner[:]), Lane: txn.Lane, Nonce: txn.Nonce, Txn: SHA3(txn.Raw)}
+}
+
+// Less returns true if o must be placed before v.
+func (o TxnOrder) Less(v TxnOrder) bool {
+	c := bytes.Compare(o.Key[:], v.Key[:])
+	if c != 0 {
+		return c < 0
+	}
+
+	if o.Lane != v.Lane {
+		return o.Lane < v.Lane
+	}
+
+	if o.Nonce != v.Nonce {
+		return o.Nonce < v.Nonce
+	}
+
+	return bytes.Compare(o.Txn[:], v.Txn[:]) < 0
+}
+
+// Conflicts returns true if o and v are of the same owner, lane and
+// nonce, at most one of them can be in a block.
+func (o TxnOrder) Conflicts(v TxnOrder) bool {
+	return o.Key == v.Key && o.Lane == v.Lane && o.Nonce == v.Nonce
+}
+
+// SortTxns sorts the transactions into the canonical order.
+func SortTxns(txns []*Txn, seed Rand) {
+	orders := make([]TxnOrder, len(txns))
+	for i, txn := range txns {
+		orders[i] = NewTxnOrder(txn, seed)
+	}
+
+	sort.Sort(&txnSorter{txns: txns, orders: orders})
+}
+
+type txnSorter struct {
+	txns   []*Txn
+	orders []TxnOrder
+}
+
+func (s *txnSorter) Len() int {
+	return len(s.txns)
+}
+
+func (s *txnSorter) Less(i, j int) bool {
+	return s.orders[i].Less(s.orders[j])
+}
+
+func (s *txnSorter) Swap(i, j int) {
+	s.txns[i], s.txns[j] = s.txns[j], s.txns[i]
+	s.orders[i], s.orders[j] = s.orders[j], s.orders[i]
+}
@@ -0,0 +1,83 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortTxns(t *testing.T) {
+	seed := Rand(SHA3([]byte("seed")))
+	a := Addr(SHA3([]byte("a")).Addr())
+	b := Addr(SHA3([]byte("b")).Addr())
+	txns := []*Txn{
+		{Owner: a, Nonce: 1},
+		{Owner: b, Nonce: 0},
+		{Owner: a, Nonce: 0},
+		{Owner: b, Nonce: 1},
+	}
+
+	SortTxns(txns, seed)
+	for i := 1; i < len(txns); i++ {
+		assert.True(t, NewTxnOrder(txns[i-1], seed).Less(NewTxnOrder(txns[i], seed)))
+	}
+
+	// nonces of the same owner are increasing
+	var nonces []uint64
+	for _, txn := range txns {
+		if txn.Owner == a {
+			nonces = append(nonces, txn.Nonce)
+		}
+	}
+	assert.Equal(t, []uint64{0, 1}, nonces)
+}
+
+func TestTxnOrderDependsOnSeed(t *testing.T) {
+	var owners []Addr
+	for i := 0; i < 10; i++ {
+		owners = append(owners, SHA3([]byte{byte(i)}).Addr())
+	}
+
+	order := func(seed Rand) []Addr {
+		txns := make([]*Txn, len(owners))
+		for i := range owners {
+			txns[i] = &Txn{Owner: owners[i]}
+		}
+		SortTxns(txns, seed)
+		r := make([]Addr, len(txns))
+		for i := range txns {
+			r[i] = txns[i].Owner
+		}
+		return r
+	}
+
+	assert.Equal(t, order(Rand{1}), order(Rand{1}))
+	assert.NotEqual(t, order(Rand{1}), order(Rand{2}))
+}
+
+func TestTxnOrderConflicts(t *testing.T) {
+	seed := Rand(SHA3([]byte("seed")))
+	a := Addr(SHA3([]byte("a")).Addr())
+	x := &Txn{Owner: a, Nonce: 0, Raw: []byte("x")}
+	y := &Txn{Owner: a, Nonce: 0, Raw: []byte("y")}
+	ox, oy := NewTxnOrder(x, seed), NewTxnOrder(y, seed)
+	assert.True(t, ox.Conflicts(oy))
+	assert.False(t, ox.Conflicts(NewTxnOrder(&Txn{Owner: a, Nonce: 1}, seed)))
+	// the same nonce of another lane does not conflict, the lanes
+	// are ordered before the nonces.
+	oz := NewTxnOrder(&Txn{Owner: a, Lane: 1, Nonce: 0}, seed)
+	assert.False(t, ox.Conflicts(oz))
+	assert.True(t, NewTxnOrder(&Txn{Owner: a, Nonce: 5}, seed).Less(oz))
+
+	// the conflicting txns are ordered by hash regardless of the
+	// order in which they arrived.
+	assert.NotEqual(t, ox.Less(oy), oy.Less(ox))
+	first := x
+	if oy.Less(ox) {
+		first = y
+	}
+	for _, txns := range [][]*Txn{{x, y}, {y, x}} {
+		SortTxns(txns, seed)
+		assert.Equal(t, first, txns[0])
+	}
+}
@@ -0,0 +1,223 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	log "github.com/helinwang/log15"
+)
+
+// txnIndexRounds is the number of the latest rounds whose txns are
+// indexed for the inclusion proofs.
+const txnIndexRounds = 1000
+
+// the prefixes of the Merkle tree nodes, so that an inner node can
+// not be passed off as a txn.
+var (
+	txnLeafPrefix  = []byte{0}
+	txnInnerPrefix = []byte{1}
+)
+
+// DecodeTxns decodes the serialized txns of a block proposal, which
+// is the rlp encoded list of the raw txns.
+func DecodeTxns(b []byte) ([][]byte, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	var txns [][]byte
+	err := rlp.DecodeBytes(b, &txns)
+	if err != nil {
+		return nil, err
+	}
+
+	return txns, nil
+}
+
+// TxnRoot returns the Merkle root of the serialized txns of a block
+// proposal, it's the zero hash when there is no txn.
+func TxnRoot(b []byte) (Hash, error) {
+	txns, err := DecodeTxns(b)
+	if err != nil {
+		return Hash{}, err
+	}
+
+	root, _ := txnTree(txns, -1)
+	return root, nil
+}
+
+// ProofNode is a sibling on the path from a txn to the txn root.
+type ProofNode struct {
+	Hash Hash
+	// Left is true if the sibling is the left child.
+	Left bool
+}
+
+// TxnProof proves that a txn is included in a notarized block.
+type TxnProof struct {
+	Txn   Hash
+	Index uint32
+	// Header is the notarized block whose TxnRoot the proof leads
+	// to, its notarization is verified with the notary group's
+	// public key.
+	Header    Block
+	Block     Hash
+	Finalized bool
+	Path      []ProofNode
+}
+
+// Verify returns if the proof leads from the txn to the txn root of
+// the block header.
+func (p *TxnProof) Verify() bool {
+	return p.Header.Hash() == p.Block && VerifyTxnPath(p.Txn, p.Path, p.Header.TxnRoot)
+}
+
+// VerifyTxnPath returns if the path leads from the txn hash to the
+// txn root.
+func VerifyTxnPath(txn Hash, path []ProofNode, root Hash) bool {
+	h := SHA3(txnLeafPrefix, txn[:])
+	for _, n := range path {
+		if n.Left {
+			h = SHA3(txnInnerPrefix, n.Hash[:], h[:])
+		} else {
+			h = SHA3(txnInnerPrefix, h[:], n.Hash[:])
+		}
+	}
+	return h == root
+}
+
+// txnTree returns the Merkle root of the txns and the path of the
+// txn at index, the path is nil if index is negative. The odd node of
+// a level is carried to the next level.
+func txnTree(txns [][]byte, index int) (Hash, []ProofNode) {
+	if len(txns) == 0 {
+		return Hash{}, nil
+	}
+
+	level := make([]Hash, len(txns))
+	for i, txn := range txns {
+		h := SHA3(txn)
+		level[i] = SHA3(txnLeafPrefix, h[:])
+	}
+
+	var path []ProofNode
+	for len(level) > 1 {
+		next := make([]Hash, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+
+			switch index {
+			case i:
+				path = append(path, ProofNode{Hash: level[i+1]})
+			case i + 1:
+				path = append(path, ProofNode{Hash: level[i], Left: true})
+			}
+			next = append(next, SHA3(txnInnerPrefix, level[i][:], level[i+1][:]))
+		}
+
+		if index >= 0 {
+			index /= 2
+		}
+		level = next
+	}
+
+	return level[0], path
+}
+
+// txnBlock is a block that includes an indexed txn.
+type txnBlock struct {
+	Block Hash
+	Round uint64
+}
+
+// indexTxns indexes the txns of the block for the inclusion proofs.
+//
+// must be called with mutex held
+func (c *Chain) indexTxns(b *Block, h Hash) {
+	bp := c.store.BlockProposal(b.BlockProposal)
+	if bp == nil {
+		return
+	}
+
+	txns, err := DecodeTxns(bp.Txns)
+	if err != nil {
+		log.Error("decode block proposal txns error", "bp", b.BlockProposal, "err", err)
+		return
+	}
+
+	for _, txn := range txns {
+		th := SHA3(txn)
+		c.txnBlocks[th] = append(c.txnBlocks[th], txnBlock{Block: h, Round: b.Round})
+		c.roundTxns[b.Round] = append(c.roundTxns[b.Round], th)
+	}
+}
+
+// pruneTxnIndex removes the txns of the round from the index.
+//
+// must be called with mutex held
+func (c *Chain) pruneTxnIndex(round uint64) {
+	for _, th := range c.roundTxns[round] {
+		var blocks []txnBlock
+		for _, b := range c.txnBlocks[th] {
+			if b.Round != round {
+				blocks = append(blocks, b)
+			}
+		}
+
+		if len(blocks) == 0 {
+			delete(c.txnBlocks, th)
+		} else {
+			c.txnBlocks[th] = blocks
+		}
+	}
+	delete(c.roundTxns, round)
+}
+
+// TxnProof returns the inclusion proof of the txn in the heaviest
+// chain, the txn must be in the latest txnIndexRounds rounds.
+func (c *Chain) TxnProof(txn Hash) (*TxnProof, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	blocks := c.txnBlocks[txn]
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("txn %v is not found in the latest %d rounds", txn, txnIndexRounds)
+	}
+
+	tip, _, _ := c.leader()
+	tipHash := tip.Hash()
+	for _, tb := range blocks {
+		h := tb.Block
+		if ancestor, _, ok := c.commonAncestor(h, tipHash); !ok || ancestor != h {
+			// the block is on a fork.
+			continue
+		}
+
+		b := c.store.Block(h)
+		bp := c.store.BlockProposal(b.BlockProposal)
+		if bp == nil {
+			return nil, fmt.Errorf("block proposal %v not found", b.BlockProposal)
+		}
+
+		txns, err := DecodeTxns(bp.Txns)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, t := range txns {
+			if SHA3(t) != txn {
+				continue
+			}
+
+			_, path := txnTree(txns, i)
+			finalized := b.Round < uint64(len(c.finalized)) && c.finalized[b.Round] == h
+			return &TxnProof{Txn: txn, Index: uint32(i), Header: *b, Block: h, Finalized: finalized, Path: path}, nil
+		}
+	}
+
+	return nil, errors.New("txn is only included in the blocks of the forks")
+}
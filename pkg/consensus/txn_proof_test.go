@@ -0,0 +1,81 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxnTree(t *testing.T) {
+	root, err := TxnRoot(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, Hash{}, root)
+	_, err = TxnRoot([]byte{1, 2, 3})
+	assert.NotNil(t, err)
+
+	for n := 1; n <= 9; n++ {
+		var txns [][]byte
+		for i := 0; i < n; i++ {
+			txns = append(txns, []byte{byte(i)})
+		}
+		b, err := rlp.EncodeToBytes(txns)
+		assert.Nil(t, err)
+		root, err := TxnRoot(b)
+		assert.Nil(t, err)
+
+		for i := range txns {
+			r, path := txnTree(txns, i)
+			assert.Equal(t, root, r)
+			assert.True(t, VerifyTxnPath(SHA3(txns[i]), path, root), "txn %d of %d", i, n)
+			assert.False(t, VerifyTxnPath(SHA3([]byte{byte(n)}), path, root), "txn not included")
+			if len(path) > 0 {
+				path[0].Left = !path[0].Left
+				assert.False(t, VerifyTxnPath(SHA3(txns[i]), path, root), "tampered path")
+			}
+		}
+	}
+}
+
+func TestChainTxnProof(t *testing.T) {
+	genesis := &Block{}
+	chain := NewChain(genesis, &myState{}, Rand{}, Config{}, nil, &myUpdater{}, newStorage(), nil)
+	addBlock := func(prev *Block, owner Addr, txns ...[]byte) *Block {
+		b, err := rlp.EncodeToBytes(txns)
+		assert.Nil(t, err)
+		bp := &BlockProposal{Round: prev.Round + 1, PrevBlock: prev.Hash(), Txns: b, Owner: owner}
+		chain.store.AddBlockProposal(bp, bp.Hash())
+		root, err := TxnRoot(b)
+		assert.Nil(t, err)
+		blk := &Block{Round: bp.Round, PrevBlock: bp.PrevBlock, Owner: owner, BlockProposal: bp.Hash(), TxnRoot: root}
+		_, err = chain.AddBlock(blk, &myState{}, float64(owner[0]), 0)
+		assert.Nil(t, err)
+		return blk
+	}
+
+	b1 := addBlock(genesis, Addr{1}, []byte{1}, []byte{2}, []byte{3})
+	fork := addBlock(b1, Addr{1}, []byte{4})
+	addBlock(b1, Addr{2}, []byte{5})
+
+	proof, err := chain.TxnProof(SHA3([]byte{2}))
+	assert.Nil(t, err)
+	assert.True(t, proof.Verify())
+	assert.Equal(t, b1.Hash(), proof.Block)
+	assert.Equal(t, uint32(1), proof.Index)
+	assert.False(t, proof.Finalized)
+
+	proof, err = chain.TxnProof(SHA3([]byte{5}))
+	assert.Nil(t, err)
+	assert.True(t, proof.Verify())
+
+	_, err = chain.TxnProof(SHA3([]byte{4}))
+	assert.NotNil(t, err, "only in the lighter fork %v", fork.Hash())
+	_, err = chain.TxnProof(SHA3([]byte{6}))
+	assert.NotNil(t, err, "not found")
+
+	chain.mu.Lock()
+	chain.pruneTxnIndex(1)
+	chain.mu.Unlock()
+	_, err = chain.TxnProof(SHA3([]byte{2}))
+	assert.NotNil(t, err, "pruned")
+}
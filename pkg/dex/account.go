@@ -1,6 +1,7 @@
 package dex
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -81,17 +82,24 @@ type PendingOrder struct {
 // Account is a cached proxy to the account data inside the state
 // trie.
 type Account struct {
-	state          *State
-	addr           consensus.Addr
-	pk             PK
-	pkDirty        bool
-	nonce          uint64
-	nonceLoaded    bool
-	nonceDirty     bool
+	state       *State
+	addr        consensus.Addr
+	pk          PK
+	pkDirty     bool
+	nonce       uint64
+	nonceLoaded bool
+	nonceDirty  bool
+	// laneNonces is the loaded nonces of the lanes other than
+	// lane 0, which is nonce.
+	laneNonces     map[uint8]uint64
+	dirtyLanes     map[uint8]bool
 	balances       map[TokenID]Balance
 	balanceDirty   bool
 	reportIdx      *uint32
 	reportIdxDirty bool
+	// orders is the pending orders changed since the last
+	// CommitCache, a nil order is removed.
+	orders map[OrderID]*PendingOrder
 }
 
 func (a *Account) ExecutionReports() []ExecutionReport {
@@ -105,6 +113,7 @@ func (a *Account) AddExecutionReport(e ExecutionReport) {
 	a.state.AddExecutionReport(a.addr, e, *a.reportIdx)
 	*a.reportIdx++
 	a.reportIdxDirty = true
+	a.state.markDirty(a)
 }
 
 func (a *Account) loadReportIdx() {
@@ -123,6 +132,7 @@ func (a *Account) IncrementNonce() {
 	a.nonce++
 	a.nonceLoaded = true
 	a.nonceDirty = true
+	a.state.markDirty(a)
 }
 
 func (a *Account) loadNonce() {
@@ -130,20 +140,91 @@ func (a *Account) loadNonce() {
 	a.nonceLoaded = true
 }
 
+// LaneNonce returns the nonce of the next txn of the lane.
+func (a *Account) LaneNonce(lane uint8) uint64 {
+	if lane == 0 {
+		return a.Nonce()
+	}
+
+	if a.laneNonces == nil {
+		a.laneNonces = make(map[uint8]uint64)
+	}
+
+	n, ok := a.laneNonces[lane]
+	if !ok {
+		n = a.state.LaneNonce(a.addr, lane)
+		a.laneNonces[lane] = n
+	}
+	return n
+}
+
+func (a *Account) IncrementLaneNonce(lane uint8) {
+	if lane == 0 {
+		a.IncrementNonce()
+		return
+	}
+
+	n := a.LaneNonce(lane)
+	a.laneNonces[lane] = n + 1
+	if a.dirtyLanes == nil {
+		a.dirtyLanes = make(map[uint8]bool)
+	}
+	a.dirtyLanes[lane] = true
+	a.state.markDirty(a)
+}
+
 func (a *Account) PendingOrder(id OrderID) (PendingOrder, bool) {
+	if p, ok := a.orders[id]; ok {
+		if p == nil {
+			return PendingOrder{}, false
+		}
+		return *p, true
+	}
+
 	return a.state.PendingOrder(a.addr, id)
 }
 
 func (a *Account) UpdatePendingOrder(p PendingOrder) {
-	a.state.UpdatePendingOrder(a.addr, p)
+	a.setOrder(p.ID, &p)
 }
 
 func (a *Account) RemovePendingOrder(id OrderID) {
-	a.state.RemovePendingOrder(a.addr, id)
+	a.setOrder(id, nil)
 }
 
+func (a *Account) setOrder(id OrderID, p *PendingOrder) {
+	if a.orders == nil {
+		a.orders = make(map[OrderID]*PendingOrder)
+	}
+	a.orders[id] = p
+	a.state.markDirty(a)
+}
+
+// PendingOrders returns the pending orders in the order of the trie
+// paths.
 func (a *Account) PendingOrders() []PendingOrder {
-	return a.state.PendingOrders(a.addr)
+	orders := a.state.PendingOrders(a.addr)
+	if len(a.orders) == 0 {
+		return orders
+	}
+
+	r := orders[:0]
+	for _, o := range orders {
+		if _, ok := a.orders[o.ID]; !ok {
+			r = append(r, o)
+		}
+	}
+
+	for _, p := range a.orders {
+		if p != nil {
+			r = append(r, *p)
+		}
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		return bytes.Compare(r[i].ID.Bytes(), r[j].ID.Bytes()) < 0
+	})
+	return r
 }
 
 func (a *Account) Balance(tokenID TokenID) Balance {
@@ -165,25 +246,34 @@ func (a *Account) UpdateBalance(tokenID TokenID, balance Balance) {
 	if a.balances == nil {
 		a.loadBalances()
 	}
+	a.state.recordBalance(a.addr, tokenID, a.balances[tokenID], balance)
 	a.balances[tokenID] = balance
 	a.balanceDirty = true
+	a.state.markDirty(a)
 }
 
 func (a *Account) PK() PK {
 	return a.pk
 }
 
-func (a *Account) CommitCache(s *State) {
+// writeBack writes the changes since the last write back to the
+// trie, s.mu must be held.
+func (a *Account) writeBack(s *State) {
 	if a.pkDirty {
-		a.state.UpdatePK(a.pk)
+		s.updatePK(a.pk)
 		a.pkDirty = false
 	}
 
 	if a.nonceDirty {
-		a.state.UpdateNonce(a.addr, a.nonce)
+		s.updateLaneNonce(a.addr, 0, a.nonce)
 		a.nonceDirty = false
 	}
 
+	for lane := range a.dirtyLanes {
+		s.updateLaneNonce(a.addr, lane, a.laneNonces[lane])
+	}
+	a.dirtyLanes = nil
+
 	if a.balanceDirty {
 		balances := make([]Balance, len(a.balances))
 		ids := make([]TokenID, len(a.balances))
@@ -205,12 +295,21 @@ func (a *Account) CommitCache(s *State) {
 			balances[i] = a.balances[ids[i]]
 		}
 
-		a.state.UpdateBalances(a.addr, balances, ids)
+		s.updateBalances(a.addr, balances, ids)
 		a.balanceDirty = false
 	}
 
 	if a.reportIdxDirty {
-		a.state.UpdateReportIdx(a.addr, *a.reportIdx)
+		s.updateReportIdx(a.addr, *a.reportIdx)
 		a.reportIdxDirty = false
 	}
+
+	for id, p := range a.orders {
+		if p == nil {
+			s.RemovePendingOrder(a.addr, id)
+			continue
+		}
+		s.UpdatePendingOrder(a.addr, *p)
+	}
+	a.orders = nil
 }
@@ -0,0 +1,376 @@
+package dex
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+const (
+	// accountStreamQueueSize is the max number of the events
+	// queued for a subscription, the oldest events are dropped
+	// when the subscriber falls behind.
+	accountStreamQueueSize = 1000
+	// accountStreamTimeout is how long a subscription lives
+	// without being polled.
+	accountStreamTimeout = 5 * time.Minute
+	challengeTimeout     = time.Minute
+	// MaxAccountPollWait is the max duration that a poll waits for
+	// new events.
+	MaxAccountPollWait = 30 * time.Second
+)
+
+// the types of the account events.
+const (
+	// OrderAckEvent is sent when an order is placed.
+	OrderAckEvent = "order_ack"
+	// FillEvent is sent when an order is filled.
+	FillEvent = "fill"
+	// OrderClosedEvent is sent when an order is removed from the
+	// pending orders: filled, cancelled or expired.
+	OrderClosedEvent = "order_closed"
+	// BalanceEvent is sent when a balance is changed.
+	BalanceEvent = "balance"
+	// DepositEvent is sent when the account receives a deposit to
+	// a deposit address.
+	DepositEvent = "deposit"
+	// ConflictEvent is sent when two txns of the account with the
+	// same nonce but different contents are seen, the account key
+	// may be compromised.
+	ConflictEvent = "txn_conflict"
+)
+
+// accountStreamMsgPrefix is signed with the challenge, so that the
+// signature can not be replayed as a txn signature.
+var accountStreamMsgPrefix = []byte("dex account stream:")
+
+// AccountEvent is a change of an account. The events are derived from
+// the chain head seen by the node, a fork could replace them.
+type AccountEvent struct {
+	// Seq is the sequence number of the event in the
+	// subscription, starting from 1.
+	Seq   uint64
+	Type  string
+	Order PendingOrder
+	Fill  ExecutionReport
+	Token TokenID
+	// Balance is the balance after the change.
+	Balance  Balance
+	Deposit  Deposit
+	Conflict TxnConflict
+}
+
+type SubscribeAccountArgs struct {
+	Addr      consensus.Addr
+	Challenge []byte
+	// Sig is the signature of the challenge prefixed with "dex
+	// account stream:", signed by the account key.
+	Sig Sig
+}
+
+type PollAccountArgs struct {
+	Session string
+	// After is the sequence number of the last received event.
+	After uint64
+	Wait  time.Duration
+}
+
+type AccountEvents struct {
+	Events []AccountEvent
+	// Gap is true if the events after the After sequence number
+	// were dropped, the subscriber should reload the wallet
+	// state.
+	Gap bool
+}
+
+// AccountStreamMsg returns the message that the account signs to
+// subscribe with the challenge.
+func AccountStreamMsg(challenge []byte) []byte {
+	return append(append([]byte(nil), accountStreamMsgPrefix...), challenge...)
+}
+
+type accountSnapshot struct {
+	balances     map[TokenID]Balance
+	orders       map[OrderID]PendingOrder
+	reportIdx    uint32
+	depositCount uint32
+}
+
+func takeAccountSnapshot(s *State, addr consensus.Addr) accountSnapshot {
+	snapshot := accountSnapshot{
+		balances: make(map[TokenID]Balance),
+		orders:   make(map[OrderID]PendingOrder),
+	}
+
+	bs, ids := s.Balances(addr)
+	for i := range bs {
+		snapshot.balances[ids[i]] = bs[i]
+	}
+
+	for _, o := range s.PendingOrders(addr) {
+		snapshot.orders[o.ID] = o
+	}
+
+	snapshot.reportIdx = s.ReportIdx(addr)
+	snapshot.depositCount = s.DepositCount(addr)
+	return snapshot
+}
+
+func balanceEqual(a, b Balance) bool {
+	if a.Available != b.Available || a.Pending != b.Pending || len(a.Frozen) != len(b.Frozen) {
+		return false
+	}
+
+	for i := range a.Frozen {
+		if a.Frozen[i] != b.Frozen[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedOrders(m map[OrderID]PendingOrder) []PendingOrder {
+	r := make([]PendingOrder, 0, len(m))
+	for _, o := range m {
+		r = append(r, o)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return orderIDLess(r[i].ID, r[j].ID)
+	})
+	return r
+}
+
+type accountSession struct {
+	addr     consensus.Addr
+	lastPoll time.Time
+	snapshot accountSnapshot
+	nextSeq  uint64
+	events   []AccountEvent
+	// notify is closed when new events are queued.
+	notify chan struct{}
+}
+
+func (a *accountSession) add(e AccountEvent) {
+	a.nextSeq++
+	e.Seq = a.nextSeq
+	a.events = append(a.events, e)
+	if len(a.events) > accountStreamQueueSize {
+		a.events = a.events[len(a.events)-accountStreamQueueSize:]
+	}
+}
+
+// update queues the events of the changes from the last snapshot to
+// the state.
+func (a *accountSession) update(s *State) {
+	prev := a.snapshot
+	cur := takeAccountSnapshot(s, a.addr)
+	n := a.nextSeq
+
+	for _, o := range sortedOrders(cur.orders) {
+		if _, ok := prev.orders[o.ID]; !ok {
+			a.add(AccountEvent{Type: OrderAckEvent, Order: o})
+		}
+	}
+
+	for idx := prev.reportIdx; idx < cur.reportIdx; idx++ {
+		r, ok := s.ExecutionReport(a.addr, idx)
+		if ok {
+			a.add(AccountEvent{Type: FillEvent, Fill: r})
+		}
+	}
+
+	for seq := prev.depositCount; seq < cur.depositCount; seq++ {
+		d, ok := s.Deposit(a.addr, seq)
+		if ok {
+			a.add(AccountEvent{Type: DepositEvent, Deposit: d})
+		}
+	}
+
+	for _, o := range sortedOrders(prev.orders) {
+		if _, ok := cur.orders[o.ID]; !ok {
+			a.add(AccountEvent{Type: OrderClosedEvent, Order: o})
+		}
+	}
+
+	ids := make([]TokenID, 0, len(cur.balances)+len(prev.balances))
+	for id := range cur.balances {
+		ids = append(ids, id)
+	}
+	for id := range prev.balances {
+		if _, ok := cur.balances[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		b, ok := cur.balances[id]
+		if p, pok := prev.balances[id]; !ok || !pok || !balanceEqual(p, b) {
+			a.add(AccountEvent{Type: BalanceEvent, Token: id, Balance: b})
+		}
+	}
+
+	a.snapshot = cur
+	if a.nextSeq != n {
+		close(a.notify)
+		a.notify = make(chan struct{})
+	}
+}
+
+type streamChallenge struct {
+	addr   consensus.Addr
+	expiry time.Time
+}
+
+// accountStreams is the subscriptions of the accounts to their own
+// order and balance changes. A subscriber proves the ownership of the
+// account by signing a challenge.
+type accountStreams struct {
+	mu         sync.Mutex
+	challenges map[string]streamChallenge
+	sessions   map[string]*accountSession
+}
+
+func newAccountStreams() *accountStreams {
+	return &accountStreams{
+		challenges: make(map[string]streamChallenge),
+		sessions:   make(map[string]*accountSession),
+	}
+}
+
+func randHex(n int) string {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (a *accountStreams) expire(now time.Time) {
+	for k, c := range a.challenges {
+		if now.After(c.expiry) {
+			delete(a.challenges, k)
+		}
+	}
+
+	for k, s := range a.sessions {
+		if now.Sub(s.lastPoll) > accountStreamTimeout {
+			delete(a.sessions, k)
+		}
+	}
+}
+
+func (a *accountStreams) challenge(now time.Time, addr consensus.Addr) []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expire(now)
+	c := randHex(32)
+	a.challenges[c] = streamChallenge{addr: addr, expiry: now.Add(challengeTimeout)}
+	b, _ := hex.DecodeString(c)
+	return b
+}
+
+// subscribe verifies the signed challenge and returns the session ID
+// of the new subscription.
+func (a *accountStreams) subscribe(now time.Time, s *State, args SubscribeAccountArgs) (string, error) {
+	acc := s.Account(args.Addr)
+	if acc == nil {
+		return "", fmt.Errorf("account %v does not exist", args.Addr)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.expire(now)
+	key := hex.EncodeToString(args.Challenge)
+	c, ok := a.challenges[key]
+	if !ok || c.addr != args.Addr {
+		return "", errors.New("unknown or expired challenge")
+	}
+
+	if !args.Sig.Verify(AccountStreamMsg(args.Challenge), acc.PK()) {
+		return "", errors.New("invalid challenge signature")
+	}
+
+	delete(a.challenges, key)
+	id := randHex(16)
+	a.sessions[id] = &accountSession{
+		addr:     args.Addr,
+		lastPoll: now,
+		snapshot: takeAccountSnapshot(s, args.Addr),
+		notify:   make(chan struct{}),
+	}
+	return id, nil
+}
+
+func (a *accountStreams) update(s *State) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, session := range a.sessions {
+		session.update(s)
+	}
+}
+
+func (a *accountStreams) conflict(c TxnConflict) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, session := range a.sessions {
+		if session.addr != c.Owner {
+			continue
+		}
+
+		session.add(AccountEvent{Type: ConflictEvent, Conflict: c})
+		close(session.notify)
+		session.notify = make(chan struct{})
+	}
+}
+
+// poll returns the events after args.After, it waits up to args.Wait
+// for new events if there is none.
+func (a *accountStreams) poll(args PollAccountArgs, e *AccountEvents) error {
+	wait := args.Wait
+	if wait > MaxAccountPollWait {
+		wait = MaxAccountPollWait
+	}
+	timeout := time.After(wait)
+
+	for {
+		a.mu.Lock()
+		session, ok := a.sessions[args.Session]
+		if !ok {
+			a.mu.Unlock()
+			return errors.New("unknown or expired session")
+		}
+
+		session.lastPoll = time.Now()
+		i := 0
+		for i < len(session.events) && session.events[i].Seq <= args.After {
+			i++
+		}
+		// the acknowledged events are removed.
+		session.events = session.events[i:]
+		notify := session.notify
+		if len(session.events) > 0 || wait <= 0 {
+			e.Events = append([]AccountEvent(nil), session.events...)
+			e.Gap = len(e.Events) > 0 && e.Events[0].Seq > args.After+1
+			a.mu.Unlock()
+			return nil
+		}
+		a.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-timeout:
+			wait = 0
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package dex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountStream(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	pkOther, skOther := RandKeyPair()
+	addr := pk.Addr()
+	s.NewAccount(pk)
+	s.NewAccount(pkOther)
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+	trans := s.Transition(1, 0, nil).(*Transition)
+	trans.state.Account(addr).UpdateBalance(0, Balance{Available: 100})
+	s = trans.Commit().(*State)
+
+	a := newAccountStreams()
+	now := time.Now()
+	c := a.challenge(now, addr)
+	_, err := a.subscribe(now, s, SubscribeAccountArgs{Addr: addr, Challenge: c, Sig: skOther.Sign(AccountStreamMsg(c))})
+	assert.NotNil(t, err, "signed by another key")
+	_, err = a.subscribe(now, s, SubscribeAccountArgs{Addr: pkOther.Addr(), Challenge: c, Sig: skOther.Sign(AccountStreamMsg(c))})
+	assert.NotNil(t, err, "challenge of another account")
+	_, err = a.subscribe(now, s, SubscribeAccountArgs{Addr: addr, Challenge: c, Sig: sk.Sign(c)})
+	assert.NotNil(t, err, "signed without the prefix")
+	session, err := a.subscribe(now, s, SubscribeAccountArgs{Addr: addr, Challenge: c, Sig: sk.Sign(AccountStreamMsg(c))})
+	assert.Nil(t, err)
+	_, err = a.subscribe(now, s, SubscribeAccountArgs{Addr: addr, Challenge: c, Sig: sk.Sign(AccountStreamMsg(c))})
+	assert.NotNil(t, err, "the challenge is used once")
+
+	market := MarketSymbol{Base: 1, Quote: 0}
+	trans = s.Transition(2, 0, nil).(*Transition)
+	order := PlaceOrderTxn{Quant: 40, Price: 100000000, Market: market}
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, order, 0), pker))
+	s = trans.Commit().(*State)
+	a.update(s)
+
+	var e AccountEvents
+	assert.Nil(t, a.poll(PollAccountArgs{Session: session}, &e))
+	assert.False(t, e.Gap)
+	id := OrderID{ID: 0, Market: market}
+	assert.Equal(t, []AccountEvent{
+		{Seq: 1, Type: OrderAckEvent, Order: PendingOrder{ID: id, Order: Order{Owner: addr, Quant: 40, Price: 100000000}}},
+		{Seq: 2, Type: BalanceEvent, Token: 0, Balance: Balance{Available: 60, Pending: 40, Frozen: []Frozen{}}},
+	}, e.Events)
+
+	// the poll waits for the next events.
+	done := make(chan AccountEvents)
+	go func() {
+		var e AccountEvents
+		assert.Nil(t, a.poll(PollAccountArgs{Session: session, After: 2, Wait: time.Minute}, &e))
+		done <- e
+	}()
+
+	trans = s.Transition(3, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeCancelOrderTxn(sk, addr, id, 1), pker))
+	s = trans.Commit().(*State)
+	a.update(s)
+
+	e = <-done
+	assert.Equal(t, []AccountEvent{
+		{Seq: 3, Type: OrderClosedEvent, Order: PendingOrder{ID: id, Order: Order{Owner: addr, Quant: 40, Price: 100000000}}},
+		{Seq: 4, Type: BalanceEvent, Token: 0, Balance: Balance{Available: 100, Frozen: []Frozen{}}},
+	}, e.Events)
+
+	// the acknowledged events are removed.
+	assert.Nil(t, a.poll(PollAccountArgs{Session: session, After: 4}, &e))
+	assert.Empty(t, e.Events)
+
+	_, err = a.subscribe(now, s, SubscribeAccountArgs{Addr: addr})
+	assert.NotNil(t, err)
+	a.expire(now.Add(2 * accountStreamTimeout))
+	assert.NotNil(t, a.poll(PollAccountArgs{Session: session}, &e), "the session expired")
+}
+
+func TestAccountStreamGap(t *testing.T) {
+	a := &accountSession{notify: make(chan struct{})}
+	for i := 0; i < accountStreamQueueSize+10; i++ {
+		a.add(AccountEvent{Type: BalanceEvent})
+	}
+
+	streams := newAccountStreams()
+	streams.sessions["s"] = a
+	a.lastPoll = time.Now()
+	var e AccountEvents
+	assert.Nil(t, streams.poll(PollAccountArgs{Session: "s", After: 5}, &e))
+	assert.True(t, e.Gap)
+	assert.Equal(t, uint64(11), e.Events[0].Seq)
+	assert.Nil(t, streams.poll(PollAccountArgs{Session: "s", After: 11}, &e))
+	assert.False(t, e.Gap)
+}
@@ -20,6 +20,34 @@ func TestAccountCommitCache(t *testing.T) {
 	assert.Equal(t, acc, acc0)
 }
 
+func TestAccountPendingOrderWriteBack(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	pk, _ := RandKeyPair()
+	acc := s.NewAccount(pk)
+	m := MarketSymbol{Base: 1, Quote: 0}
+	o0 := PendingOrder{ID: OrderID{ID: 0, Market: m}}
+	o1 := PendingOrder{ID: OrderID{ID: 1, Market: m}}
+	acc.UpdatePendingOrder(o1)
+	acc.UpdatePendingOrder(o0)
+	s.CommitCache()
+	h := s.Hash()
+
+	o1.Executed = 10
+	acc.UpdatePendingOrder(o1)
+	o1.Executed = 20
+	acc.UpdatePendingOrder(o1)
+	acc.RemovePendingOrder(o0.ID)
+	// the changes are not written to the trie until CommitCache.
+	assert.Equal(t, h, s.Hash())
+	_, ok := acc.PendingOrder(o0.ID)
+	assert.False(t, ok)
+	assert.Equal(t, []PendingOrder{o1}, acc.PendingOrders())
+
+	s.CommitCache()
+	assert.NotEqual(t, h, s.Hash())
+	assert.Equal(t, []PendingOrder{o1}, s.PendingOrders(pk.Addr()))
+}
+
 func TestOrderIDEncodeDecode(t *testing.T) {
 	const str = "1_2_3"
 	var id OrderID
@@ -0,0 +1,112 @@
+package dex
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// AllowListLooseningDelay is the number of rounds before a looser
+// withdrawal allow-list applies, so that a leaked key can not send
+// the funds to an address of its own before the operator notices.
+const AllowListLooseningDelay = 200
+
+const maxAllowListAddrs = 100
+
+// AllowList is the addresses that the account's SendTokenTxn and
+// SendToDepositTxn may target when it's enabled, the account can
+// always send to itself.
+type AllowList struct {
+	Enabled bool
+	// Addrs is sorted.
+	Addrs []consensus.Addr
+}
+
+func (l AllowList) allows(addr consensus.Addr) bool {
+	if !l.Enabled {
+		return true
+	}
+
+	for _, a := range l.Addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// within returns true if l allows no address that v does not allow.
+func (l AllowList) within(v AllowList) bool {
+	if !l.Enabled {
+		return !v.Enabled
+	}
+
+	for _, a := range l.Addrs {
+		if !v.allows(a) {
+			return false
+		}
+	}
+	return true
+}
+
+// AllowLists is the account's withdrawal allow-list, and the looser
+// allow-list that applies from NextRound.
+type AllowLists struct {
+	Current   AllowList
+	Next      AllowList
+	NextRound uint64
+}
+
+// At returns the allow-list at the round.
+func (l AllowLists) At(round uint64) AllowList {
+	if l.NextRound > 0 && round >= l.NextRound {
+		return l.Next
+	}
+
+	return l.Current
+}
+
+func (t *Transition) setAllowList(owner *Account, txn *SetAllowListTxn) error {
+	list := txn.List
+	if !list.Enabled && len(list.Addrs) > 0 {
+		return fmt.Errorf("disabled allow-list should have no address")
+	}
+
+	if len(list.Addrs) > maxAllowListAddrs {
+		return fmt.Errorf("allow-list has %d addresses, the max is %d", len(list.Addrs), maxAllowListAddrs)
+	}
+
+	for i := 1; i < len(list.Addrs); i++ {
+		if bytes.Compare(list.Addrs[i-1][:], list.Addrs[i][:]) >= 0 {
+			return fmt.Errorf("allow-list addresses should be sorted and unique")
+		}
+	}
+
+	addr := owner.PK().Addr()
+	cur := t.state.AllowLists(addr).At(t.round)
+	l := AllowLists{Current: cur}
+	if list.within(cur) {
+		l.Current = list
+	} else {
+		l.Next = list
+		l.NextRound = t.round + AllowListLooseningDelay
+	}
+
+	t.state.UpdateAllowLists(addr, l)
+	return nil
+}
+
+// checkAllowList returns an error if the owner's withdrawal
+// allow-list does not allow sending to the address.
+func (t *Transition) checkAllowList(owner consensus.Addr, to consensus.Addr) error {
+	if owner == to {
+		return nil
+	}
+
+	if !t.state.AllowLists(owner).At(t.round).allows(to) {
+		return txnErrorf(ErrCodeUnauthorized, "%v is not in the withdrawal allow-list of account %v", to, owner)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowList(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	pkCold, _ := RandKeyPair()
+	pkAttacker, _ := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 1000})
+	addr := pk.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	unsorted := AllowList{Enabled: true, Addrs: []consensus.Addr{pkCold.Addr(), pkCold.Addr()}}
+	assert.NotNil(t, recordTxn(t, trans, MakeSetAllowListTxn(sk, addr, SetAllowListTxn{List: unsorted}, 0), pker))
+	// enabling the allow-list applies immediately.
+	list := AllowList{Enabled: true, Addrs: []consensus.Addr{pkCold.Addr()}}
+	assert.Nil(t, recordTxn(t, trans, MakeSetAllowListTxn(sk, addr, SetAllowListTxn{List: list}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkCold, 0, 100, 1), pker))
+	err := recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkAttacker, 0, 100, 2), pker)
+	assert.Equal(t, ErrCodeUnauthorized, ErrorCode(err))
+
+	// the leaked key adds its address, it is delayed.
+	loose := AllowList{Enabled: true, Addrs: []consensus.Addr{pkCold.Addr(), pkAttacker.Addr()}}
+	sortAddrs(loose.Addrs)
+	assert.Nil(t, recordTxn(t, trans, MakeSetAllowListTxn(sk, addr, SetAllowListTxn{List: loose}, 2), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, AllowLists{Current: list, Next: loose, NextRound: 1 + AllowListLooseningDelay}, s.AllowLists(addr))
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.NotNil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkAttacker, 0, 100, 3), pker))
+	trans = s.Transition(1+AllowListLooseningDelay, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkAttacker, 0, 100, 3), pker))
+
+	// a tighter allow-list drops the pending looser one.
+	trans = s.Transition(3, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeSetAllowListTxn(sk, addr, SetAllowListTxn{List: AllowList{Enabled: true}}, 3), pker))
+	s = trans.Commit().(*State)
+	l := s.AllowLists(addr)
+	assert.True(t, l.Current.Enabled)
+	assert.Empty(t, l.Current.Addrs)
+	assert.Equal(t, uint64(0), l.NextRound)
+
+	trans = s.Transition(4, 0, nil).(*Transition)
+	assert.NotNil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkCold, 0, 100, 4), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pk, 0, 100, 4), pker), "sending to itself")
+}
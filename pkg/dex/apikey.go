@@ -0,0 +1,309 @@
+package dex
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// the HTTP headers of the RPC connection request authenticated by an
+// API key.
+const (
+	APIKeyHeader       = "X-Dex-Api-Key"
+	APITimestampHeader = "X-Dex-Api-Timestamp"
+	APISignatureHeader = "X-Dex-Api-Signature"
+)
+
+const (
+	// apiSignatureWindow is how far the timestamp of a signed
+	// connection request can be from the node's clock.
+	apiSignatureWindow = 30 * time.Second
+	// apiKeyReloadInterval is how often the node checks the key
+	// file for the changes made by the api_key tool.
+	apiKeyReloadInterval = 5 * time.Second
+)
+
+// the permissions of the API keys.
+const (
+	// PermRead permits the queries.
+	PermRead = "read"
+	// PermTrade permits sending txns.
+	PermTrade = "trade"
+)
+
+// tradeMethods is the RPC methods that need PermTrade, the other
+// methods need PermRead.
+var tradeMethods = map[string]bool{
+	"WalletService.SendTxn":               true,
+	"WalletService.RegisterCancelSession": true,
+}
+
+// APIKey is a key for the hosted access to the node's RPC.
+type APIKey struct {
+	ID string
+	// Secret is the hex encoded HMAC key.
+	Secret      string
+	Permissions []string
+	Quota
+	Revoked bool
+	Created time.Time
+}
+
+func (k *APIKey) has(perm string) bool {
+	for _, p := range k.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *APIKey) permits(method string) bool {
+	if tradeMethods[method] {
+		return k.has(PermTrade)
+	}
+	return k.has(PermRead)
+}
+
+// APIKeyCredential is the API key held by an RPC client.
+type APIKeyCredential struct {
+	ID     string
+	Secret string
+}
+
+// APISignature returns the hex encoded HMAC-SHA256 of the API key ID
+// and the unix timestamp of the connection request.
+func APISignature(secret []byte, id, timestamp string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id + "\n" + timestamp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// DialRPC connects to the node's RPC, authenticated by the API key if
+// it's not nil.
+func DialRPC(addr string, key *APIKeyCredential) (*rpc.Client, error) {
+	if key == nil {
+		return rpc.DialHTTP("tcp", addr)
+	}
+
+	secret, err := hex.DecodeString(key.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("API key secret must be hex encoded: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest("CONNECT", rpc.DefaultRPCPath, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Host = addr
+	req.Header.Set(APIKeyHeader, key.ID)
+	req.Header.Set(APITimestampHeader, ts)
+	req.Header.Set(APISignatureHeader, APISignature(secret, key.ID, ts))
+	err = req.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("error connecting to RPC: %s", resp.Status)
+	}
+
+	return rpc.NewClient(conn), nil
+}
+
+// APIKeyStore is the API keys of a node, saved as a JSON file. The
+// api_key tool edits the file and the node reloads it.
+type APIKeyStore struct {
+	path string
+
+	mu        sync.Mutex
+	keys      []APIKey
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// OpenAPIKeyStore opens the key file, the file is created on the
+// first save if it does not exist.
+func OpenAPIKeyStore(path string) (*APIKeyStore, error) {
+	s := &APIKeyStore{path: path}
+	err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *APIKeyStore) load() error {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		s.keys = nil
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	var keys []APIKey
+	err = json.Unmarshal(b, &keys)
+	if err != nil {
+		return fmt.Errorf("error decoding API key file %s: %v", s.path, err)
+	}
+
+	s.keys = keys
+	s.modTime = info.ModTime()
+	return nil
+}
+
+func (s *APIKeyStore) save() error {
+	b, err := json.MarshalIndent(s.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// the file is replaced atomically, the node never reads a
+	// partially written file.
+	tmp := s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+// Create creates a new API key.
+func (s *APIKeyStore) Create(perms []string, q Quota) (APIKey, error) {
+	for _, p := range perms {
+		if p != PermRead && p != PermTrade {
+			return APIKey{}, fmt.Errorf("unknown permission: %s, should be one of %s and %s", p, PermRead, PermTrade)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := APIKey{
+		ID:          randHex(8),
+		Secret:      randHex(32),
+		Permissions: perms,
+		Quota:       q,
+		Created:     time.Now().UTC(),
+	}
+	s.keys = append(s.keys, key)
+	return key, s.save()
+}
+
+// Revoke revokes the API key, the connections authenticated by it
+// are closed on their next call.
+func (s *APIKeyStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.keys {
+		if s.keys[i].ID == id {
+			s.keys[i].Revoked = true
+			return s.save()
+		}
+	}
+
+	return fmt.Errorf("API key %s not found", id)
+}
+
+// Keys returns the API keys.
+func (s *APIKeyStore) Keys() []APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]APIKey(nil), s.keys...)
+}
+
+// get returns the API key if it is not revoked.
+func (s *APIKeyStore) get(id string, now time.Time) (APIKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastCheck) >= apiKeyReloadInterval {
+		s.lastCheck = now
+		info, err := os.Stat(s.path)
+		if err == nil && !info.ModTime().Equal(s.modTime) {
+			// keep the loaded keys if the file is
+			// corrupted.
+			keys := s.keys
+			err = s.load()
+			if err != nil {
+				s.keys = keys
+			}
+		}
+	}
+
+	for _, k := range s.keys {
+		if k.ID == id {
+			return k, !k.Revoked
+		}
+	}
+	return APIKey{}, false
+}
+
+var errInvalidAPISignature = errors.New("invalid API key signature")
+
+// verify verifies the signed connection request.
+func (s *APIKeyStore) verify(id, timestamp, sig string, now time.Time) (APIKey, error) {
+	key, ok := s.get(id, now)
+	if !ok {
+		return APIKey{}, errors.New("unknown or revoked API key")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return APIKey{}, errInvalidAPISignature
+	}
+
+	d := now.Sub(time.Unix(ts, 0))
+	if d > apiSignatureWindow || d < -apiSignatureWindow {
+		return APIKey{}, errors.New("API key signature expired, please check the clock")
+	}
+
+	secret, err := hex.DecodeString(key.Secret)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	expected := APISignature(secret, id, timestamp)
+	if !hmac.Equal([]byte(expected), []byte(sig)) {
+		return APIKey{}, errInvalidAPISignature
+	}
+
+	return key, nil
+}
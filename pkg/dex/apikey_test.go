@@ -0,0 +1,109 @@
+package dex
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAPIKeyStore(t *testing.T) (*APIKeyStore, func()) {
+	dir, err := ioutil.TempDir("", "apikey")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenAPIKeyStore(filepath.Join(dir, "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func TestAPIKeyVerify(t *testing.T) {
+	s, done := newTestAPIKeyStore(t)
+	defer done()
+
+	key, err := s.Create([]string{PermRead}, Quota{Rate: 1, Burst: 1})
+	assert.Nil(t, err)
+	_, err = s.Create([]string{"admin"}, Quota{})
+	assert.NotNil(t, err)
+
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	secret, _ := hex.DecodeString(key.Secret)
+	sig := APISignature(secret, key.ID, ts)
+
+	k, err := s.verify(key.ID, ts, sig, now)
+	assert.Nil(t, err)
+	assert.Equal(t, key.Quota, k.Quota)
+
+	_, err = s.verify(key.ID, ts, APISignature([]byte("wrong"), key.ID, ts), now)
+	assert.NotNil(t, err)
+	_, err = s.verify(key.ID, ts, sig, now.Add(time.Minute))
+	assert.NotNil(t, err, "expired signature")
+
+	// the keys are saved and loaded.
+	loaded, err := OpenAPIKeyStore(s.path)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{PermRead}, loaded.Keys()[0].Permissions)
+
+	assert.Nil(t, s.Revoke(key.ID))
+	_, err = s.verify(key.ID, ts, sig, now)
+	assert.NotNil(t, err, "revoked key")
+	assert.NotNil(t, s.Revoke("unknown"))
+}
+
+func TestAPIKeyPermits(t *testing.T) {
+	read := APIKey{Permissions: []string{PermRead}}
+	assert.True(t, read.permits("WalletService.WalletState"))
+	assert.False(t, read.permits("WalletService.SendTxn"))
+
+	trade := APIKey{Permissions: []string{PermTrade}}
+	assert.True(t, trade.permits("WalletService.SendTxn"))
+	assert.False(t, trade.permits("WalletService.WalletState"))
+}
+
+func TestRPCHandlerAPIKey(t *testing.T) {
+	s, done := newTestAPIKeyStore(t)
+	defer done()
+
+	key, err := s.Create([]string{PermRead}, Quota{})
+	assert.Nil(t, err)
+
+	server := rpc.NewServer()
+	assert.Nil(t, server.RegisterName("Echo", echoService{}))
+	ts := httptest.NewServer(&rpcHandler{server: server, limiter: newRateLimiter(), keys: s, requireKey: true})
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	_, err = DialRPC(addr, nil)
+	assert.NotNil(t, err, "key required")
+	_, err = DialRPC(addr, &APIKeyCredential{ID: key.ID, Secret: hex.EncodeToString([]byte("wrong"))})
+	assert.NotNil(t, err, "wrong secret")
+
+	client, err := DialRPC(addr, &APIKeyCredential{ID: key.ID, Secret: key.Secret})
+	assert.Nil(t, err)
+	defer client.Close()
+
+	var r int
+	assert.Nil(t, client.Call("Echo.Echo", 1, &r))
+	assert.Equal(t, 1, r)
+
+	// the SendTxn method needs the trade permission.
+	err = client.Call("WalletService.SendTxn", 1, &r)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "permission denied")
+
+	// the connection stays usable after a denied call.
+	assert.Nil(t, client.Call("Echo.Echo", 2, &r))
+	assert.Equal(t, 2, r)
+}
@@ -0,0 +1,179 @@
+package dex
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/helinwang/dex/pkg/consensus"
+	log "github.com/helinwang/log15"
+)
+
+// the reason codes of the balance mutations not caused by the
+// execution of a txn. The reason code of a txn execution is the name
+// of the txn type, e.g., SendToken.
+const (
+	AuditFee       = "fee"
+	AuditFeeRefund = "fee_refund"
+	AuditMinerFee  = "miner_fee"
+	// AuditStreamSettle is the settlement of the payment streams
+	// of the txn owner before the txn is applied.
+	AuditStreamSettle = "stream_settle"
+)
+
+// AuditEntry is a balance mutation of an account, written to the
+// audit log as a JSON line when the block of the mutation is
+// finalized.
+type AuditEntry struct {
+	Round uint64
+	// Txn is the hash of the txn that caused the mutation, it is
+	// empty for the mutations at the end of the round.
+	Txn     string `json:",omitempty"`
+	Reason  string
+	Account string
+	Token   TokenID
+	Before  AuditBalance
+	After   AuditBalance
+}
+
+// AuditBalance is a balance in the audit log, Frozen is the sum of
+// the frozen quantities.
+type AuditBalance struct {
+	Available uint64
+	Pending   uint64
+	Frozen    uint64
+}
+
+func auditBalance(b Balance) AuditBalance {
+	r := AuditBalance{Available: b.Available, Pending: b.Pending}
+	for _, f := range b.Frozen {
+		r.Frozen += f.Quant
+	}
+	return r
+}
+
+// AuditLog is the append-only log of the balance mutations of the
+// finalized blocks.
+type AuditLog struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// NewAuditLog creates a new audit log writing to w.
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w, enc: json.NewEncoder(w)}
+}
+
+// OpenAuditLog opens the audit log file for appending.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAuditLog(f), nil
+}
+
+func (l *AuditLog) write(entries []AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := range entries {
+		err := l.enc.Encode(&entries[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	if f, ok := l.w.(*os.File); ok {
+		return f.Sync()
+	}
+	return nil
+}
+
+// auditContext is the cause of the balance mutations being recorded.
+type auditContext struct {
+	round  uint64
+	txn    consensus.Hash
+	reason string
+}
+
+// txnReason returns the reason code of the txn execution.
+func txnReason(decoded interface{}) string {
+	t := reflect.TypeOf(decoded)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return strings.TrimSuffix(t.Name(), "Txn")
+}
+
+// SetAuditLog sets the audit log of the state and the states derived
+// from it.
+func (s *State) SetAuditLog(l *AuditLog) {
+	s.mu.Lock()
+	s.auditLog = l
+	s.mu.Unlock()
+}
+
+// setAudit sets the cause of the following balance mutations of the
+// transition, txn is nil for the mutations at the end of the round.
+func (t *Transition) setAudit(txn *consensus.Txn, reason string) {
+	s := t.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.auditLog == nil {
+		return
+	}
+
+	s.auditCtx = auditContext{round: t.round, reason: reason}
+	if txn != nil {
+		s.auditCtx.txn = consensus.SHA3(txn.Raw)
+	}
+}
+
+func (s *State) recordBalance(addr consensus.Addr, id TokenID, before, after Balance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.auditLog == nil {
+		return
+	}
+
+	e := AuditEntry{
+		Round:   s.auditCtx.round,
+		Reason:  s.auditCtx.reason,
+		Account: addr.Encode(networkID),
+		Token:   id,
+		Before:  auditBalance(before),
+		After:   auditBalance(after),
+	}
+	if s.auditCtx.txn != (consensus.Hash{}) {
+		e.Txn = s.auditCtx.txn.Hex()
+	}
+	s.auditEntries = append(s.auditEntries, e)
+}
+
+// Finalized writes the balance mutations of the transition that
+// produced the state to the audit log. The transitions of the blocks
+// not finalized are never written.
+func (s *State) Finalized() {
+	s.mu.Lock()
+	l := s.auditLog
+	entries := s.auditEntries
+	s.auditEntries = nil
+	s.mu.Unlock()
+
+	if l == nil || len(entries) == 0 {
+		return
+	}
+
+	err := l.write(entries)
+	if err != nil {
+		log.Error("error writing audit log", "err", err)
+	}
+}
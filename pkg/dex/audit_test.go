@@ -0,0 +1,62 @@
+package dex
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pkProposer, _ := RandKeyPair()
+	pk, sk := RandKeyPair()
+	pkTo, _ := RandKeyPair()
+	addr := pk.Addr()
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 100 + 2*flatFee})
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+
+	var buf bytes.Buffer
+	s.SetAuditLog(NewAuditLog(&buf))
+	trans := s.Transition(1, 0, pkProposer).(*Transition)
+	send := MakeSendTokenTxn(sk, addr, pkTo, 0, 20, 0)
+	assert.Nil(t, recordTxn(t, trans, send, pker))
+	tooMuch := MakeSendTokenTxn(sk, addr, pkTo, 0, 1000, 1)
+	assert.NotNil(t, recordTxn(t, trans, tooMuch, pker))
+	s = trans.Commit().(*State)
+	// only the finalized transitions are written.
+	assert.Equal(t, 0, buf.Len())
+
+	s.Finalized()
+	var entries []AuditEntry
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e AuditEntry
+		assert.Nil(t, dec.Decode(&e))
+		entries = append(entries, e)
+	}
+
+	sendHash := consensus.SHA3(send).Hex()
+	tooMuchHash := consensus.SHA3(tooMuch).Hex()
+	from, to := addr.Encode(networkID), pkTo.Addr().Encode(networkID)
+	balance := func(available uint64) AuditBalance {
+		return AuditBalance{Available: available}
+	}
+	assert.Equal(t, []AuditEntry{
+		{Round: 1, Txn: sendHash, Reason: AuditFee, Account: from, Before: balance(100 + 2*flatFee), After: balance(100 + flatFee)},
+		{Round: 1, Txn: sendHash, Reason: "SendToken", Account: from, Before: balance(100 + flatFee), After: balance(80 + flatFee)},
+		{Round: 1, Txn: sendHash, Reason: "SendToken", Account: to, After: balance(20)},
+		{Round: 1, Txn: tooMuchHash, Reason: AuditFee, Account: from, Before: balance(80 + flatFee), After: balance(80)},
+		{Round: 1, Txn: tooMuchHash, Reason: AuditFeeRefund, Account: from, Before: balance(80), After: balance(80 + flatFee)},
+		{Round: 1, Txn: entries[5].Txn, Reason: AuditMinerFee, Account: pkProposer.Addr().Encode(networkID), After: balance(flatFee)},
+	}, entries)
+	assert.NotEmpty(t, entries[5].Txn)
+
+	// the entries are written once.
+	s.Finalized()
+	assert.Equal(t, 0, buf.Len())
+}
@@ -0,0 +1,37 @@
+package dex
+
+import (
+	"math"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// stakePerFreeTxn is the quantity of the frozen native token that
+// grants the account one fee-free txn per round.
+var stakePerFreeTxn = uint64(math.Pow10(int(BNBInfo.Decimals)))
+
+// FreeTxnQuota returns the number of fee-free txns per round that
+// the account's frozen native token grants.
+func FreeTxnQuota(acc *Account) uint64 {
+	var frozen uint64
+	for _, f := range acc.Balance(0).Frozen {
+		frozen += f.Quant
+	}
+	return frozen / stakePerFreeTxn
+}
+
+// useFreeTxn consumes one fee-free txn of the account's quota of the
+// current round, returns false if the quota is used up.
+func (t *Transition) useFreeTxn(acc *Account) bool {
+	addr := acc.PK().Addr()
+	if t.freeTxns[addr] >= FreeTxnQuota(acc) {
+		return false
+	}
+
+	t.freeTxns[addr]++
+	return true
+}
+
+func (t *Transition) refundFreeTxn(addr consensus.Addr) {
+	t.freeTxns[addr]--
+}
@@ -0,0 +1,37 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreeTxnQuota(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pkProposer, _ := RandKeyPair()
+	pk, sk := RandKeyPair()
+	pkTo, _ := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 2*stakePerFreeTxn + flatFee + 10})
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	addr := pk.Addr()
+
+	trans := s.Transition(1, 0, pkProposer).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeFreezeTokenTxn(sk, addr, FreezeTokenTxn{TokenID: 0, AvailableRound: 100, Quant: 2 * stakePerFreeTxn}, 0), pker))
+	assert.Equal(t, 2, int(FreeTxnQuota(trans.state.Account(addr))))
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkTo, 0, 1, 1), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkTo, 0, 1, 2), pker))
+	// the quota is used up.
+	err := recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkTo, 0, 1, 3), pker)
+	assert.Contains(t, err.Error(), "sufficient balance to pay fee")
+	s = trans.Commit().(*State)
+	assert.Equal(t, 8, int(s.Account(addr).Balance(0).Available))
+
+	// the quota is renewed in the next round.
+	trans = s.Transition(2, 0, pkProposer).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkTo, 0, 1, 3), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, 7, int(s.Account(addr).Balance(0).Available))
+}
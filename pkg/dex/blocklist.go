@@ -0,0 +1,197 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+const (
+	maxBlockReasonLen = 64
+	// maxBlocklistEvents is the number of the latest blocklist
+	// events kept for the subscribers.
+	maxBlocklistEvents = 10000
+)
+
+// BlockedAddr is an address on the governance blocklist, e.g., of
+// sanctioned funds. The txns of the address are rejected, and no
+// tokens can be sent to it. Its orders, including the orders of its
+// margin and perpetual accounts, are cancelled, its recurring orders
+// are dropped, and its accounts are not liquidated.
+type BlockedAddr struct {
+	Reason string
+	// Round is the round that the address is blocked.
+	Round uint64
+}
+
+// BlocklistChange is an address blocked or unblocked by the governor.
+type BlocklistChange struct {
+	Addr    consensus.Addr
+	Blocked bool
+	Reason  string
+}
+
+// BlocklistLog is the blocklist changes of the latest round that
+// changed the blocklist.
+type BlocklistLog struct {
+	Round   uint64
+	Changes []BlocklistChange
+}
+
+func (t *Transition) blockAddress(owner *Account, txn *BlockAddressTxn) error {
+	if err := t.checkGovernor(owner); err != nil {
+		return err
+	}
+
+	if len(txn.Reason) > maxBlockReasonLen {
+		return fmt.Errorf("block reason is longer than %d bytes", maxBlockReasonLen)
+	}
+
+	_, blocked := t.state.Blocked(txn.Addr)
+	if blocked == txn.Block {
+		return fmt.Errorf("address %v is already in the requested state, blocked: %t", txn.Addr, blocked)
+	}
+
+	if txn.Block {
+		if txn.Addr == owner.PK().Addr() {
+			return errors.New("governor can not block itself")
+		}
+
+		t.state.UpdateBlocked(txn.Addr, BlockedAddr{Reason: txn.Reason, Round: t.round})
+		t.cancelBlockedOrders(txn.Addr)
+	} else {
+		t.state.RemoveBlocked(txn.Addr)
+	}
+
+	l := t.state.BlocklistLog()
+	if l.Round != t.round {
+		l = BlocklistLog{Round: t.round}
+	}
+	l.Changes = append(l.Changes, BlocklistChange{Addr: txn.Addr, Blocked: txn.Block, Reason: txn.Reason})
+	t.state.UpdateBlocklistLog(l)
+	return nil
+}
+
+// cancelBlockedOrders cancels the orders of the address's account
+// and of its margin and perpetual accounts, so that the blocked
+// address is not in any order settlement.
+func (t *Transition) cancelBlockedOrders(addr consensus.Addr) {
+	if acc := t.state.Account(addr); acc != nil {
+		t.cancelAllOrders(acc, &CancelAllOrdersTxn{})
+	}
+
+	tokens := t.state.Tokens()
+	for _, base := range tokens {
+		for _, quote := range tokens {
+			m := MarketSymbol{Base: base.ID, Quote: quote.ID}
+			if !m.Valid() {
+				continue
+			}
+
+			marginAddr := MarginAddr(addr, m)
+			if _, ok := t.state.MarginDebt(marginAddr); ok {
+				t.cancelPendingOrders(t.state.Account(marginAddr))
+			}
+
+			perpAddr := PerpAddr(addr, m)
+			if _, ok := t.state.PerpPosition(perpAddr); ok {
+				t.cancelPerpOrders(t.state.Account(perpAddr), m)
+			}
+		}
+	}
+}
+
+// checkBlocked returns an error if any of the addresses is blocked.
+func (t *Transition) checkBlocked(addrs ...consensus.Addr) error {
+	for _, addr := range addrs {
+		if b, ok := t.state.Blocked(addr); ok {
+			return txnErrorf(ErrCodeBlocked, "address %v is blocked: %s", addr, b.Reason)
+		}
+	}
+
+	return nil
+}
+
+// BlocklistEvent is a blocklist change.
+type BlocklistEvent struct {
+	// Seq is the sequence number of the event, starting from 1.
+	// It's local to the node.
+	Seq   uint64
+	Round uint64
+	BlocklistChange
+}
+
+type BlocklistEventsArgs struct {
+	// After is the sequence number of the last received event.
+	After uint64
+}
+
+type BlocklistEvents struct {
+	Events []BlocklistEvent
+	// Gap is true when the events after After are dropped.
+	Gap bool
+}
+
+// blocklistFeed keeps the latest blocklist events for the compliance
+// monitoring.
+type blocklistFeed struct {
+	mu     sync.Mutex
+	round  uint64
+	seq    uint64
+	events []BlocklistEvent
+}
+
+func newBlocklistFeed() *blocklistFeed {
+	return &blocklistFeed{}
+}
+
+// update adds the blocklist changes of the state. The states of the
+// rounds not after the last added round are ignored.
+func (f *blocklistFeed) update(s *State) {
+	s.mu.Lock()
+	round := s.round
+	s.mu.Unlock()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if round <= f.round {
+		return
+	}
+	f.round = round
+
+	l := s.BlocklistLog()
+	if l.Round != round {
+		return
+	}
+
+	for _, c := range l.Changes {
+		f.seq++
+		f.events = append(f.events, BlocklistEvent{Seq: f.seq, Round: round, BlocklistChange: c})
+	}
+
+	if len(f.events) > maxBlocklistEvents {
+		f.events = f.events[len(f.events)-maxBlocklistEvents:]
+	}
+}
+
+// after returns the events after the sequence number after.
+func (f *blocklistFeed) after(after uint64) (BlocklistEvents, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if after > f.seq {
+		return BlocklistEvents{}, errors.New("sequence number not reached, the node restarted")
+	}
+
+	i := len(f.events)
+	for i > 0 && f.events[i-1].Seq > after {
+		i--
+	}
+
+	events := append([]BlocklistEvent(nil), f.events[i:]...)
+	gap := after < f.seq && (len(events) == 0 || events[0].Seq > after+1)
+	return BlocklistEvents{Events: events, Gap: gap}, nil
+}
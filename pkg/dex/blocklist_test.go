@@ -0,0 +1,124 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockAddress(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pkGov, skGov := RandKeyPair()
+	pk, sk := RandKeyPair()
+	pkOther, skOther := RandKeyPair()
+	s.NewAccount(pkGov)
+	s.NewAccount(pk).UpdateBalance(1, Balance{Available: 300})
+	s.NewAccount(pkOther).UpdateBalance(1, Balance{Available: 300})
+	s.UpdateGovernor(pkGov.Addr())
+	addr, gov := pk.Addr(), pkGov.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{gov: pkGov, addr: pk, pkOther.Addr(): pkOther}}
+	m := MarketSymbol{Base: 1, Quote: 0}
+	price := uint64(math.Pow10(OrderPriceDecimals))
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 100, Price: price, Market: m}, 0), pker))
+	block := BlockAddressTxn{Addr: addr, Block: true, Reason: "sanctioned"}
+	err := recordTxn(t, trans, MakeBlockAddressTxn(skOther, pkOther.Addr(), block, 0), pker)
+	assert.Equal(t, ErrCodeUnauthorized, ErrorCode(err))
+	assert.Nil(t, recordTxn(t, trans, MakeBlockAddressTxn(skGov, gov, block, 0), pker))
+	assert.NotNil(t, recordTxn(t, trans, MakeBlockAddressTxn(skGov, gov, block, 1), pker), "already blocked")
+	s = trans.Commit().(*State)
+	acc := s.Account(addr)
+	assert.Empty(t, acc.PendingOrders())
+	assert.Equal(t, 300, int(acc.Balance(1).Available))
+	b, ok := s.Blocked(addr)
+	assert.True(t, ok)
+	assert.Equal(t, BlockedAddr{Reason: "sanctioned", Round: 1}, b)
+	assert.Equal(t, BlocklistLog{Round: 1, Changes: []BlocklistChange{{Addr: addr, Blocked: true, Reason: "sanctioned"}}}, s.BlocklistLog())
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	err = recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkOther, 1, 100, 1), pker)
+	assert.Equal(t, ErrCodeBlocked, ErrorCode(err))
+	err = recordTxn(t, trans, MakeSendTokenTxn(skOther, pkOther.Addr(), pk, 1, 100, 0), pker)
+	assert.Equal(t, ErrCodeBlocked, ErrorCode(err))
+
+	assert.Nil(t, recordTxn(t, trans, MakeBlockAddressTxn(skGov, gov, BlockAddressTxn{Addr: addr}, 1), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(skOther, pkOther.Addr(), pk, 1, 100, 0), pker))
+	s = trans.Commit().(*State)
+	_, ok = s.Blocked(addr)
+	assert.False(t, ok)
+	assert.Equal(t, 400, int(s.Account(addr).Balance(1).Available))
+}
+
+func TestBlockAddressCancelsAutomaticOrders(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	m := MarketSymbol{Base: 0, Quote: 1}
+	price := uint64(math.Pow10(OrderPriceDecimals))
+	s.UpdateRefPrice(m, RefPrice{Price: price})
+	pkGov, skGov := RandKeyPair()
+	pk, sk := RandKeyPair()
+	s.NewAccount(pkGov)
+	s.NewAccount(pk).UpdateBalance(1, Balance{Available: 1000})
+	s.UpdateGovernor(pkGov.Addr())
+	addr, gov := pk.Addr(), pkGov.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{gov: pkGov, addr: pk}}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	recurring := RecurringOrderTxn{Quant: 10, Price: price, Market: m, Interval: 2, Count: 3}
+	assert.Nil(t, recordTxn(t, trans, MakeRecurringOrderTxn(sk, addr, recurring, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeMarginTransferTxn(sk, addr, MarginTransferTxn{Market: m, TokenID: 1, Quant: 100}, 1), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeMarginOrderTxn(sk, addr, PlaceOrderTxn{Quant: 10, Price: price, Market: m}, 2), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePerpTransferTxn(sk, addr, PerpTransferTxn{Market: m, Quant: 100}, 3), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePerpOrderTxn(sk, addr, PlaceOrderTxn{Quant: 10, Price: price, Market: m}, 4), pker))
+	s = trans.Commit().(*State)
+	marginAddr, perpAddr := MarginAddr(addr, m), PerpAddr(addr, m)
+	assert.Equal(t, 1, len(s.Account(marginAddr).PendingOrders()))
+	assert.Equal(t, 1, len(s.Account(perpAddr).PendingOrders()))
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeBlockAddressTxn(skGov, gov, BlockAddressTxn{Addr: addr, Block: true}, 0), pker))
+	s = trans.Commit().(*State)
+	assert.Empty(t, s.Account(marginAddr).PendingOrders())
+	assert.Equal(t, 100, int(s.Account(marginAddr).Balance(1).Available))
+	assert.Equal(t, 0, int(s.Account(marginAddr).Balance(1).Pending))
+	assert.Empty(t, s.Account(perpAddr).PendingOrders())
+	bids, _ := s.MarketOrders(m)
+	assert.Empty(t, bids)
+
+	// the recurring child orders are not placed.
+	for round := uint64(3); round < 9; round++ {
+		s = s.Transition(round, 0, nil).(*Transition).Commit().(*State)
+		assert.Empty(t, s.Account(addr).PendingOrders())
+	}
+	assert.Equal(t, 800, int(s.Account(addr).Balance(1).Available))
+}
+
+func TestBlocklistFeed(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	var addr consensus.Addr
+	addr[0] = 1
+	s.UpdateBlocklistLog(BlocklistLog{Round: 1, Changes: []BlocklistChange{{Addr: addr, Blocked: true}}})
+	s.round = 1
+
+	f := newBlocklistFeed()
+	f.update(s)
+	f.update(s)
+	e, err := f.after(0)
+	assert.Nil(t, err)
+	assert.Equal(t, []BlocklistEvent{{Seq: 1, Round: 1, BlocklistChange: BlocklistChange{Addr: addr, Blocked: true}}}, e.Events)
+
+	// the log of an older round is not added again.
+	s.round = 2
+	f.update(s)
+	e, err = f.after(1)
+	assert.Nil(t, err)
+	assert.Empty(t, e.Events)
+	assert.False(t, e.Gap)
+}
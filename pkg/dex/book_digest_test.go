@@ -0,0 +1,46 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyBooks(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	m := MarketSymbol{Base: 1, Quote: 0}
+	book := newOrderBook()
+	book.Limit(Order{Quant: 10, Price: 100})
+	book.Limit(Order{Quant: 10, Price: 200, SellSide: true})
+	d := s.saveOrderBook(m, book)
+	s.UpdateBookDigests([]BookDigest{{Market: m, Digest: d}})
+
+	checks := s.VerifyBooks()
+	assert.Equal(t, []BookCheck{{Market: m, Committed: d, Computed: d}}, checks)
+
+	// a book changed without committing its digest.
+	book.Limit(Order{Quant: 5, Price: 90})
+	s.saveOrderBook(m, book)
+	checks = s.VerifyBooks()
+	assert.Equal(t, "digest mismatch", checks[0].Err)
+
+	// a book that can not be decoded.
+	s.trie.Update(marketPath(m.Encode()), []byte{1, 2, 3})
+	checks = s.VerifyBooks()
+	assert.Contains(t, checks[0].Err, "error decoding order book")
+}
+
+func TestOrderBookCheck(t *testing.T) {
+	book := newOrderBook()
+	book.Limit(Order{Quant: 10, Price: 100})
+	book.Limit(Order{Quant: 10, Price: 90})
+	assert.Nil(t, book.check())
+
+	book.bidMax.NextPoint.Price = 110
+	assert.NotNil(t, book.check())
+	book.bidMax.NextPoint.Price = 90
+
+	book.nextOrderID = 1
+	assert.NotNil(t, book.check())
+}
@@ -0,0 +1,218 @@
+package dex
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// The bridge moves ERC-20 tokens between Ethereum and the DEX. The
+// bridge group (a threshold BLS group whose public key is set in the
+// genesis state) observes the Ethereum lock contract, and
+// authorizes the mints of the wrapped tokens and the withdrawals
+// from the lock contract with threshold signatures.
+
+// EthAddr is an Ethereum address.
+type EthAddr [20]byte
+
+// BridgeDeposit is a deposit locked in the Ethereum lock contract.
+type BridgeDeposit struct {
+	// TxHash and LogIndex identify the deposit event on
+	// Ethereum.
+	TxHash   consensus.Hash
+	LogIndex uint64
+	Token    EthAddr
+	To       PK
+	Quant    uint64
+}
+
+func (d *BridgeDeposit) key() consensus.Hash {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, d.LogIndex)
+	return consensus.SHA3(d.TxHash[:], b)
+}
+
+// BridgeWithdrawal is a withdrawal of the ERC-20 tokens from the
+// lock contract.
+type BridgeWithdrawal struct {
+	ID    uint64
+	Token EthAddr
+	To    EthAddr
+	Quant uint64
+	Round uint64
+	// Sig is the bridge group's signature of
+	// BridgeWithdrawalMsg, it's empty until the withdrawal is
+	// authorized.
+	Sig consensus.Sig
+}
+
+func groupMsg(kind string, v interface{}) []byte {
+	b, err := rlp.EncodeToBytes(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return append([]byte(kind), b...)
+}
+
+// BridgeRegisterMsg returns the message that the bridge group signs
+// to register the wrapped token of the ERC-20 token.
+func BridgeRegisterMsg(token EthAddr, info TokenInfo) []byte {
+	return groupMsg("bridge register", []interface{}{token, info})
+}
+
+// BridgeDepositMsg returns the message that the bridge group signs
+// to mint the deposit.
+func BridgeDepositMsg(d BridgeDeposit) []byte {
+	return groupMsg("bridge deposit", d)
+}
+
+// BridgeWithdrawalMsg returns the message that the bridge group
+// signs to authorize the withdrawal.
+func BridgeWithdrawalMsg(w BridgeWithdrawal) []byte {
+	w.Sig = nil
+	return groupMsg("bridge withdrawal", w)
+}
+
+func (t *Transition) verifyBridgeSig(sig consensus.Sig, msg []byte) error {
+	pk := t.state.BridgeGroup()
+	if len(pk) == 0 {
+		return errors.New("bridge is not enabled")
+	}
+
+	if !sig.Verify(pk, msg) {
+		return errors.New("invalid bridge group signature")
+	}
+
+	return nil
+}
+
+func (t *Transition) bridgeRegister(owner *Account, txn *BridgeRegisterTxn) error {
+	err := t.verifyBridgeSig(txn.Sig, BridgeRegisterMsg(txn.Token, txn.Info))
+	if err != nil {
+		return err
+	}
+
+	if _, ok := t.state.BridgeToken(txn.Token); ok {
+		return fmt.Errorf("ERC-20 token %x is already registered", txn.Token)
+	}
+
+	if txn.Info.TotalUnits != 0 {
+		return errors.New("wrapped token should be registered with 0 total units")
+	}
+
+	id, err := t.createToken(txn.Info)
+	if err != nil {
+		return err
+	}
+
+	t.state.UpdateBridgeToken(txn.Token, id)
+	return nil
+}
+
+func (t *Transition) bridgeMint(owner *Account, txn *BridgeMintTxn) error {
+	d := txn.Deposit
+	err := t.verifyBridgeSig(txn.Sig, BridgeDepositMsg(d))
+	if err != nil {
+		return err
+	}
+
+	if d.Quant == 0 {
+		return errors.New("deposit quantity is 0")
+	}
+
+	key := d.key()
+	if t.state.BridgeDepositMinted(key) {
+		return fmt.Errorf("deposit %x:%d is already minted", d.TxHash, d.LogIndex)
+	}
+
+	id, ok := t.state.BridgeToken(d.Token)
+	if !ok {
+		return fmt.Errorf("ERC-20 token %x is not registered", d.Token)
+	}
+
+	info := t.tokenCache.Info(id)
+	if info == zeroInfo {
+		return fmt.Errorf("wrapped token %d does not exist yet", id)
+	}
+
+	// a balance can not overflow if the total supply does not.
+	if info.TotalUnits+d.Quant < info.TotalUnits {
+		return fmt.Errorf("deposit quantity %d overflows the total supply %d of wrapped token %d", d.Quant, info.TotalUnits, id)
+	}
+
+	toAcc := t.state.Account(d.To.Addr())
+	if toAcc == nil {
+		toAcc = t.state.NewAccount(d.To)
+	}
+
+	b := toAcc.Balance(id)
+	b.Available += d.Quant
+	toAcc.UpdateBalance(id, b)
+	info.TotalUnits += d.Quant
+	t.state.UpdateToken(Token{ID: id, TokenInfo: info})
+	t.tokenCache.Update(id, info)
+	t.state.MarkBridgeDeposit(key)
+	return nil
+}
+
+func (t *Transition) bridgeWithdraw(owner *Account, txn *BridgeWithdrawTxn) error {
+	if txn.Quant == 0 {
+		return errors.New("withdrawal quantity is 0")
+	}
+
+	token, ok := t.state.BridgeEthToken(txn.TokenID)
+	if !ok {
+		return fmt.Errorf("token %d is not a wrapped token", txn.TokenID)
+	}
+
+	b := owner.Balance(txn.TokenID)
+	if b.Available < txn.Quant {
+		return txnErrorf(ErrCodeInsufficientBalance, "insufficient available token balance, tokenID: %v, quant: %d, available: %d", txn.TokenID, txn.Quant, b.Available)
+	}
+
+	if err := t.checkTransferThreshold(owner.PK().Addr(), txn.TokenID, txn.Quant); err != nil {
+		return err
+	}
+
+	info := t.tokenCache.Info(txn.TokenID)
+	b.Available -= txn.Quant
+	owner.UpdateBalance(txn.TokenID, b)
+	t.addOutflow(owner.PK().Addr(), txn.TokenID, txn.Quant)
+	info.TotalUnits -= txn.Quant
+	t.state.UpdateToken(Token{ID: txn.TokenID, TokenInfo: info})
+	t.tokenCache.Update(txn.TokenID, info)
+
+	w := BridgeWithdrawal{
+		ID:    t.state.NextBridgeWithdrawalID(),
+		Token: token,
+		To:    txn.To,
+		Quant: txn.Quant,
+		Round: t.round,
+	}
+	t.state.UpdateBridgeWithdrawal(w)
+	return nil
+}
+
+func (t *Transition) bridgeAuthorize(owner *Account, txn *BridgeAuthorizeTxn) error {
+	w, ok := t.state.BridgeWithdrawal(txn.ID)
+	if !ok {
+		return fmt.Errorf("can not find withdrawal %d", txn.ID)
+	}
+
+	if len(w.Sig) > 0 {
+		return fmt.Errorf("withdrawal %d is already authorized", txn.ID)
+	}
+
+	err := t.verifyBridgeSig(txn.Sig, BridgeWithdrawalMsg(w))
+	if err != nil {
+		return err
+	}
+
+	w.Sig = txn.Sig
+	t.state.UpdateBridgeWithdrawal(w)
+	return nil
+}
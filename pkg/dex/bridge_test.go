@@ -0,0 +1,73 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBridge(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	groupSK := consensus.RandSK()
+	s.UpdateBridgeGroup(groupSK.MustPK())
+	pk, sk := RandKeyPair()
+	s.NewAccount(pk)
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	addr := pk.Addr()
+
+	var token EthAddr
+	token[0] = 1
+	info := TokenInfo{Symbol: "WETH", Decimals: 8}
+	trans := s.Transition(1, 0, nil).(*Transition)
+	register := BridgeRegisterTxn{Token: token, Info: info, Sig: consensus.RandSK().Sign(BridgeRegisterMsg(token, info))}
+	err := recordTxn(t, trans, MakeBridgeRegisterTxn(sk, addr, register, 0), pker)
+	assert.Contains(t, err.Error(), "signature")
+	register.Sig = groupSK.Sign(BridgeRegisterMsg(token, info))
+	assert.Nil(t, recordTxn(t, trans, MakeBridgeRegisterTxn(sk, addr, register, 0), pker))
+	s = trans.Commit().(*State)
+
+	id, ok := s.BridgeToken(token)
+	assert.True(t, ok)
+	assert.Equal(t, TokenID(1), id)
+
+	deposit := BridgeDeposit{LogIndex: 2, Token: token, To: pk, Quant: 100}
+	mint := BridgeMintTxn{Deposit: deposit, Sig: groupSK.Sign(BridgeDepositMsg(deposit))}
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeBridgeMintTxn(sk, addr, mint, 1), pker))
+	err = recordTxn(t, trans, MakeBridgeMintTxn(sk, addr, mint, 2), pker)
+	assert.Contains(t, err.Error(), "already minted")
+
+	huge := BridgeDeposit{LogIndex: 3, Token: token, To: pk, Quant: math.MaxUint64 - 50}
+	mint = BridgeMintTxn{Deposit: huge, Sig: groupSK.Sign(BridgeDepositMsg(huge))}
+	err = recordTxn(t, trans, MakeBridgeMintTxn(sk, addr, mint, 2), pker)
+	assert.Contains(t, err.Error(), "overflows the total supply")
+
+	var to EthAddr
+	to[0] = 2
+	assert.Nil(t, recordTxn(t, trans, MakeBridgeWithdrawTxn(sk, addr, BridgeWithdrawTxn{TokenID: id, Quant: 40, To: to}, 2), pker))
+	s = trans.Commit().(*State)
+
+	assert.Equal(t, 60, int(s.Account(addr).Balance(id).Available))
+	w, ok := s.BridgeWithdrawal(0)
+	assert.True(t, ok)
+	assert.Equal(t, token, w.Token)
+	assert.Equal(t, to, w.To)
+	assert.Equal(t, 40, int(w.Quant))
+	assert.Equal(t, 0, len(w.Sig))
+	for _, tk := range s.Tokens() {
+		if tk.ID == id {
+			assert.Equal(t, 60, int(tk.TotalUnits))
+		}
+	}
+
+	trans = s.Transition(3, 0, nil).(*Transition)
+	sig := groupSK.Sign(BridgeWithdrawalMsg(w))
+	assert.Nil(t, recordTxn(t, trans, MakeBridgeAuthorizeTxn(sk, addr, BridgeAuthorizeTxn{ID: 0, Sig: sig}, 3), pker))
+	s = trans.Commit().(*State)
+	w, _ = s.BridgeWithdrawal(0)
+	assert.Equal(t, sig, w.Sig)
+}
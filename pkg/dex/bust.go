@@ -0,0 +1,107 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// TradeBustWindow is the number of the rounds after a trade
+	// within which the governor can bust it.
+	TradeBustWindow = 100
+	// maxBustJustification is the max length of the
+	// justification of a bust.
+	maxBustJustification = 1000
+)
+
+// TradeRecord is a matched trade kept on chain for TradeBustWindow
+// rounds, so that the governor can bust it.
+type TradeRecord struct {
+	MatchedTrade
+	Busted    bool
+	BustRound uint64
+	// Justification is the governor's reason of the bust.
+	Justification string
+}
+
+// recordTrades keeps the matched trades of the round for the bust
+// window, and removes the trades out of the window.
+func (t *Transition) recordTrades() {
+	if len(t.matches) > 0 {
+		records := make([]TradeRecord, len(t.matches))
+		for i, m := range t.matches {
+			records[i] = TradeRecord{MatchedTrade: m}
+		}
+		t.state.UpdateTradeRecords(t.round, records)
+	}
+
+	if t.round > TradeBustWindow {
+		t.state.RemoveTradeRecords(t.round - TradeBustWindow)
+	}
+}
+
+// bustTrade reverses the trade: the buyer returns the base token and
+// gets back the quote token paid, the seller the opposite. The
+// trading fees are not refunded and the filled orders are not
+// restored.
+func (t *Transition) bustTrade(owner *Account, txn *BustTradeTxn) error {
+	if err := t.checkGovernor(owner); err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(txn.Justification) == "" {
+		return errors.New("bust justification is empty")
+	}
+
+	if len(txn.Justification) > maxBustJustification {
+		return fmt.Errorf("bust justification is longer than %d bytes", maxBustJustification)
+	}
+
+	if txn.Round >= t.round {
+		return fmt.Errorf("trades of round %d are not recorded yet", txn.Round)
+	}
+
+	if txn.Round+TradeBustWindow <= t.round {
+		return txnErrorf(ErrCodeExpired, "bust window of round %d passed", txn.Round)
+	}
+
+	records := t.state.TradeRecords(txn.Round)
+	if int(txn.Index) >= len(records) {
+		return fmt.Errorf("trade %d of round %d not found", txn.Index, txn.Round)
+	}
+
+	r := &records[txn.Index]
+	if r.Busted {
+		return errors.New("trade is already busted")
+	}
+
+	buyer := t.state.Account(r.Buyer)
+	seller := t.state.Account(r.Seller)
+	m := r.Market
+	if b := buyer.Balance(m.Base); b.Available < r.Quant {
+		return txnErrorf(ErrCodeInsufficientBalance, "buyer's available base token %d is less than the traded %d", b.Available, r.Quant)
+	}
+
+	if b := seller.Balance(m.Quote); b.Available < r.QuoteQuant {
+		return txnErrorf(ErrCodeInsufficientBalance, "seller's available quote token %d is less than the paid %d", b.Available, r.QuoteQuant)
+	}
+
+	// a self trade nets to zero, the balances are read after each
+	// update.
+	b := buyer.Balance(m.Base)
+	b.Available -= r.Quant
+	buyer.UpdateBalance(m.Base, b)
+	credit(buyer, m.Quote, r.QuoteQuant)
+	b = seller.Balance(m.Quote)
+	b.Available -= r.QuoteQuant
+	seller.UpdateBalance(m.Quote, b)
+	credit(seller, m.Base, r.Quant)
+
+	r.Busted = true
+	r.BustRound = t.round
+	r.Justification = txn.Justification
+	t.state.UpdateTradeRecords(txn.Round, records)
+	t.logger().Warn("trade busted", "trade_round", txn.Round, "index", txn.Index, "market", m, "buyer", r.Buyer, "seller", r.Seller, "quant", r.Quant, "justification", txn.Justification)
+	return nil
+}
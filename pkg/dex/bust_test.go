@@ -0,0 +1,109 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBustTrade(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	one := uint64(math.Pow10(OrderPriceDecimals))
+	market := MarketSymbol{Base: 1, Quote: 0}
+	pkGov, skGov := RandKeyPair()
+	pkSeller, skSeller := RandKeyPair()
+	pkBuyer, skBuyer := RandKeyPair()
+	gov, seller, buyer := pkGov.Addr(), pkSeller.Addr(), pkBuyer.Addr()
+	s.NewAccount(pkGov)
+	s.NewAccount(pkSeller).UpdateBalance(1, Balance{Available: 1000})
+	s.NewAccount(pkBuyer).UpdateBalance(0, Balance{Available: 5000})
+	s.UpdateGovernor(gov)
+	pker := &myPKer{m: map[consensus.Addr]PK{gov: pkGov, seller: pkSeller, buyer: pkBuyer}}
+
+	// a fat-finger buy at four times the price.
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skBuyer, buyer, PlaceOrderTxn{Quant: 1000, Price: one * 4, Market: market}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skSeller, seller, PlaceOrderTxn{SellSide: true, Quant: 1000, Price: one / 10, Market: market}, 0), pker))
+	s = trans.Commit().(*State)
+	records := s.TradeRecords(1)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, MatchedTrade{Round: 1, Market: market, Buyer: buyer, Seller: seller, Price: one * 4, Quant: 1000, QuoteQuant: 4000}, records[0].MatchedTrade)
+
+	bust := func(sk SK, owner consensus.Addr, txn BustTradeTxn, nonce uint64) error {
+		return recordTxn(t, trans, MakeBustTradeTxn(sk, owner, txn, nonce), pker)
+	}
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.NotNil(t, bust(skSeller, seller, BustTradeTxn{Round: 1, Justification: "fat finger"}, 1), "not the governor")
+	assert.NotNil(t, bust(skGov, gov, BustTradeTxn{Round: 1}, 0), "no justification")
+	assert.NotNil(t, bust(skGov, gov, BustTradeTxn{Round: 1, Index: 1, Justification: "fat finger"}, 0), "trade not found")
+	assert.Nil(t, bust(skGov, gov, BustTradeTxn{Round: 1, Justification: "fat finger"}, 0))
+	assert.NotNil(t, bust(skGov, gov, BustTradeTxn{Round: 1, Justification: "fat finger"}, 1), "already busted")
+	s = trans.Commit().(*State)
+
+	assert.Equal(t, 1000, int(s.Account(seller).Balance(1).Available))
+	assert.Equal(t, 0, int(s.Account(seller).Balance(0).Available))
+	assert.Equal(t, 5000, int(s.Account(buyer).Balance(0).Available))
+	assert.Equal(t, 0, int(s.Account(buyer).Balance(1).Available))
+	var r []TradeRecord
+	assert.Nil(t, queryTradeRecords(s, 1, &r))
+	assert.True(t, r[0].Busted)
+	assert.Equal(t, uint64(2), r[0].BustRound)
+	assert.Equal(t, "fat finger", r[0].Justification)
+
+	// the records are removed after the bust window.
+	trans = s.Transition(1+TradeBustWindow, 0, nil).(*Transition)
+	assert.NotNil(t, bust(skGov, gov, BustTradeTxn{Round: 1, Justification: "late"}, 1), "window passed")
+	s = trans.Commit().(*State)
+	assert.Empty(t, s.TradeRecords(1))
+}
+
+func TestBustAuctionTrade(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	one := uint64(math.Pow10(OrderPriceDecimals))
+	market := MarketSymbol{Base: 1, Quote: 0}
+	s.UpdateMarketConfig(market, MarketConfigInfo{BatchAuction: true})
+	s.UpdateFeeSchedule(FeeSchedule{TakerFee: 20 * feeRateDenominator / 1000, MakerFee: 10 * feeRateDenominator / 1000})
+	pkGov, skGov := RandKeyPair()
+	pkSeller, skSeller := RandKeyPair()
+	pkBuyer, skBuyer := RandKeyPair()
+	gov, seller, buyer := pkGov.Addr(), pkSeller.Addr(), pkBuyer.Addr()
+	s.NewAccount(pkGov)
+	// the extra balances pay the fees that a bust does not
+	// refund.
+	sellerAcc := s.NewAccount(pkSeller)
+	sellerAcc.UpdateBalance(0, Balance{Available: 2})
+	sellerAcc.UpdateBalance(1, Balance{Available: 1000})
+	buyerAcc := s.NewAccount(pkBuyer)
+	buyerAcc.UpdateBalance(0, Balance{Available: 5000})
+	buyerAcc.UpdateBalance(1, Balance{Available: 10})
+	s.UpdateGovernor(gov)
+	pker := &myPKer{m: map[consensus.Addr]PK{gov: pkGov, seller: pkSeller, buyer: pkBuyer}}
+
+	// the orders clear in the auction at the end of the round, the
+	// newer sell order is the taker.
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skBuyer, buyer, PlaceOrderTxn{Quant: 1000, Price: one * 4, Market: market}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skSeller, seller, PlaceOrderTxn{SellSide: true, Quant: 1000, Price: one / 10, Market: market}, 0), pker))
+	s = trans.Commit().(*State)
+	records := s.TradeRecords(1)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, MatchedTrade{Round: 1, Market: market, Buyer: buyer, Seller: seller, Price: one / 10, Quant: 1000, QuoteQuant: 100}, records[0].MatchedTrade)
+	assert.Equal(t, 100, int(s.Account(seller).Balance(0).Available), "taker fee")
+	assert.Equal(t, 1000, int(s.Account(buyer).Balance(1).Available), "maker fee")
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeBustTradeTxn(skGov, gov, BustTradeTxn{Round: 1, Justification: "halt failure"}, 0), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, 1000, int(s.Account(seller).Balance(1).Available))
+	assert.Equal(t, 0, int(s.Account(seller).Balance(0).Available))
+	assert.Equal(t, 0, int(s.Account(buyer).Balance(1).Available))
+	assert.Equal(t, 5000, int(s.Account(buyer).Balance(0).Available))
+	assert.True(t, s.TradeRecords(1)[0].Busted)
+}
@@ -0,0 +1,142 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+// the bounds of the grace period of a cancel-on-disconnect session.
+const (
+	MinCancelSessionGrace = time.Second
+	MaxCancelSessionGrace = 10 * time.Minute
+)
+
+// CancelSessionArgs registers the cancel-on-disconnect session of
+// the API key of the connection. Txn is a signed CancelAllOrdersTxn,
+// it is sent if all the connections of the key are closed for
+// longer than Grace. The txn must have the next nonce of its owner
+// when it is sent, so the client should register a new one after
+// sending other txns. A nil Txn unregisters the session.
+type CancelSessionArgs struct {
+	Txn   []byte
+	Grace time.Duration
+	// keyID is the API key of the connection, set by the codec.
+	keyID string
+}
+
+type cancelSession struct {
+	txn   []byte
+	grace time.Duration
+	timer *time.Timer
+}
+
+// cancelSessions sends the cancel-all-orders txns of the API keys
+// whose connections are dropped, limiting the risk of the stale
+// quotes of a disconnected market maker.
+type cancelSessions struct {
+	mu       sync.Mutex
+	send     func([]byte)
+	conns    map[string]int
+	sessions map[string]*cancelSession
+}
+
+func newCancelSessions(send func([]byte)) *cancelSessions {
+	return &cancelSessions{
+		send:     send,
+		conns:    make(map[string]int),
+		sessions: make(map[string]*cancelSession),
+	}
+}
+
+func (c *cancelSessions) register(keyID string, txn []byte, grace time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s := c.sessions[keyID]; s != nil && s.timer != nil {
+		s.timer.Stop()
+	}
+
+	if txn == nil {
+		delete(c.sessions, keyID)
+		return
+	}
+
+	c.sessions[keyID] = &cancelSession{txn: txn, grace: grace}
+}
+
+func (c *cancelSessions) connect(keyID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conns[keyID]++
+	if s := c.sessions[keyID]; s != nil && s.timer != nil {
+		// reconnected within the grace period.
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+func (c *cancelSessions) disconnect(keyID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conns[keyID]--
+	if c.conns[keyID] > 0 {
+		return
+	}
+
+	delete(c.conns, keyID)
+	s := c.sessions[keyID]
+	if s == nil {
+		return
+	}
+
+	s.timer = time.AfterFunc(s.grace, func() {
+		c.mu.Lock()
+		if c.sessions[keyID] != s || s.timer == nil {
+			c.mu.Unlock()
+			return
+		}
+		delete(c.sessions, keyID)
+		c.mu.Unlock()
+
+		log.Warn("API key disconnected longer than the grace period, sending its cancel-all-orders txn", "key", keyID, "grace", s.grace)
+		c.send(s.txn)
+	})
+}
+
+func (r *RPCServer) registerCancelSession(args CancelSessionArgs) error {
+	if args.keyID == "" {
+		return errors.New("cancel-on-disconnect session needs a connection with an API key")
+	}
+
+	if args.Txn == nil {
+		r.sessions.register(args.keyID, nil, 0)
+		return nil
+	}
+
+	if args.Grace < MinCancelSessionGrace || args.Grace > MaxCancelSessionGrace {
+		return fmt.Errorf("grace period should be between %v and %v, got: %v", MinCancelSessionGrace, MaxCancelSessionGrace, args.Grace)
+	}
+
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	txn, err := parseTxn(args.Txn, s)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := txn.Decoded.(*CancelAllOrdersTxn); !ok {
+		return fmt.Errorf("session txn should be a CancelAllOrdersTxn, got: %T", txn.Decoded)
+	}
+
+	r.sessions.register(args.keyID, args.Txn, args.Grace)
+	return nil
+}
@@ -0,0 +1,130 @@
+package dex
+
+import (
+	"math"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCancelAllOrders(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 2, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	acc := s.NewAccount(pk)
+	acc.UpdateBalance(1, Balance{Available: 300})
+	acc.UpdateBalance(2, Balance{Available: 300})
+	addr := pk.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+
+	price := uint64(math.Pow10(OrderPriceDecimals))
+	m1, m2 := MarketSymbol{Base: 1, Quote: 0}, MarketSymbol{Base: 2, Quote: 0}
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 100, Price: price, Market: m1}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 100, Price: price, Market: m1}, 1), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 100, Price: price, Market: m2}, 2), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeCancelAllOrdersTxn(sk, addr, CancelAllOrdersTxn{Markets: []MarketSymbol{m1}}, 3), pker))
+	s = trans.Commit().(*State)
+	acc = s.Account(addr)
+	assert.Equal(t, 1, len(acc.PendingOrders()))
+	assert.Equal(t, 300, int(acc.Balance(1).Available))
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeCancelAllOrdersTxn(sk, addr, CancelAllOrdersTxn{}, 4), pker))
+	s = trans.Commit().(*State)
+	acc = s.Account(addr)
+	assert.Empty(t, acc.PendingOrders())
+	assert.Equal(t, 300, int(acc.Balance(2).Available))
+}
+
+func TestCancelSessions(t *testing.T) {
+	sent := make(chan []byte, 10)
+	c := newCancelSessions(func(b []byte) { sent <- b })
+	grace := 50 * time.Millisecond
+
+	c.connect("a")
+	c.register("a", []byte{1}, grace)
+	c.connect("a")
+	c.disconnect("a")
+	c.disconnect("a")
+	// reconnected within the grace period.
+	time.Sleep(grace / 5)
+	c.connect("a")
+	time.Sleep(2 * grace)
+	assert.Empty(t, sent)
+
+	c.disconnect("a")
+	select {
+	case b := <-sent:
+		assert.Equal(t, []byte{1}, b)
+	case <-time.After(time.Second):
+		t.Fatal("cancel-all-orders txn not sent")
+	}
+
+	// the session is sent only once.
+	c.connect("a")
+	c.disconnect("a")
+	time.Sleep(2 * grace)
+	assert.Empty(t, sent)
+
+	c.connect("b")
+	c.register("b", []byte{2}, grace)
+	c.register("b", nil, 0)
+	c.disconnect("b")
+	time.Sleep(2 * grace)
+	assert.Empty(t, sent)
+}
+
+type sessionService struct {
+	keyIDs chan string
+}
+
+func (s sessionService) Register(args CancelSessionArgs, _ *int) error {
+	s.keyIDs <- args.keyID
+	return nil
+}
+
+func TestRPCHandlerCancelSession(t *testing.T) {
+	keys, done := newTestAPIKeyStore(t)
+	defer done()
+
+	key, err := keys.Create([]string{PermRead}, Quota{})
+	assert.Nil(t, err)
+
+	sent := make(chan []byte, 1)
+	sessions := newCancelSessions(func(b []byte) { sent <- b })
+	svc := sessionService{keyIDs: make(chan string, 1)}
+	server := rpc.NewServer()
+	assert.Nil(t, server.RegisterName("Session", svc))
+	ts := httptest.NewServer(&rpcHandler{server: server, limiter: newRateLimiter(), keys: keys, sessions: sessions})
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	client, err := DialRPC(addr, &APIKeyCredential{ID: key.ID, Secret: key.Secret})
+	assert.Nil(t, err)
+	assert.Nil(t, client.Call("Session.Register", CancelSessionArgs{Txn: []byte{1}}, nil))
+	assert.Equal(t, key.ID, <-svc.keyIDs, "the codec sets the API key of the connection")
+
+	sessions.register(key.ID, []byte{1}, MinCancelSessionGrace)
+	client.Close()
+	select {
+	case b := <-sent:
+		assert.Equal(t, []byte{1}, b)
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancel-all-orders txn not sent on disconnect")
+	}
+
+	client, err = DialRPC(addr, nil)
+	assert.Nil(t, err)
+	defer client.Close()
+	assert.Nil(t, client.Call("Session.Register", CancelSessionArgs{}, nil))
+	assert.Equal(t, "", <-svc.keyIDs, "no API key")
+}
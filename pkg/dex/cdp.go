@@ -0,0 +1,411 @@
+package dex
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// The stablecoin is a token pegged to 1 USD, minted against the
+// collateral locked in collateralized debt positions (CDPs). The
+// collateral is valued at its oracle price. A CDP whose collateral
+// ratio falls below the liquidation ratio is liquidated by selling
+// its collateral for the stablecoin through the order book of the
+// collateral/stablecoin market, the proceeds are burned to repay
+// the debt.
+
+// CDPConfig is the governor's configuration of a collateral token.
+type CDPConfig struct {
+	// MinRatio is the minimum ratio in percent of the value of
+	// the collateral to the debt after minting or withdrawing.
+	MinRatio uint64
+	// LiquidationRatio is the ratio in percent below which the
+	// CDP is liquidated.
+	LiquidationRatio uint64
+}
+
+// CDP is a collateralized debt position. The collateral is held by
+// an isolated account per owner and collateral token.
+type CDP struct {
+	Owner       consensus.Addr
+	Collateral  TokenID
+	Debt        uint64
+	Liquidating bool
+}
+
+// cdpPK returns the public key of the owner's CDP account of the
+// collateral token. The key does not have a corresponding secret
+// key, the CDP account is controlled by its owner's transactions.
+func cdpPK(owner consensus.Addr, collateral TokenID) PK {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(collateral))
+	pk := append([]byte("cdp"), owner[:]...)
+	return PK(append(pk, b...))
+}
+
+// CDPAddr returns the address of the owner's CDP account of the
+// collateral token.
+func CDPAddr(owner consensus.Addr, collateral TokenID) consensus.Addr {
+	return cdpPK(owner, collateral).Addr()
+}
+
+func (t *Transition) createStablecoin(owner *Account, txn *CreateStablecoinTxn) error {
+	if err := t.checkGovernor(owner); err != nil {
+		return err
+	}
+
+	if _, ok := t.state.Stablecoin(); ok {
+		return errors.New("stablecoin already exists")
+	}
+
+	if txn.Info.TotalUnits != 0 {
+		return errors.New("stablecoin should be created with 0 total units")
+	}
+
+	id, err := t.createToken(txn.Info)
+	if err != nil {
+		return err
+	}
+
+	t.state.UpdateStablecoin(id)
+	return nil
+}
+
+func (t *Transition) configCDP(owner *Account, txn *ConfigCDPTxn) error {
+	if err := t.checkGovernor(owner); err != nil {
+		return err
+	}
+
+	if t.tokenCache.Info(txn.Collateral) == zeroInfo {
+		return fmt.Errorf("trying to configure non-existent collateral token: %d", txn.Collateral)
+	}
+
+	if id, ok := t.state.Stablecoin(); ok && id == txn.Collateral {
+		return errors.New("stablecoin can not be the collateral")
+	}
+
+	if txn.LiquidationRatio <= 100 || txn.MinRatio < txn.LiquidationRatio {
+		return fmt.Errorf("liquidation ratio %d should be above 100 and not above the min ratio %d", txn.LiquidationRatio, txn.MinRatio)
+	}
+
+	t.state.UpdateCDPConfig(txn.Collateral, CDPConfig{MinRatio: txn.MinRatio, LiquidationRatio: txn.LiquidationRatio})
+	return nil
+}
+
+// stablecoin returns the stablecoin's ID and info.
+func (t *Transition) stablecoin() (TokenID, TokenInfo, error) {
+	id, ok := t.state.Stablecoin()
+	if !ok {
+		return 0, zeroInfo, errors.New("stablecoin does not exist")
+	}
+
+	info := t.tokenCache.Info(id)
+	if info == zeroInfo {
+		return 0, zeroInfo, fmt.Errorf("stablecoin %d does not exist yet", id)
+	}
+
+	return id, info, nil
+}
+
+// cdpValue returns the value of the CDP's collateral in the
+// stablecoin at the collateral's oracle price.
+func (t *Transition) cdpValue(acc *Account, c CDP, price uint64) uint64 {
+	_, stableInfo, err := t.stablecoin()
+	if err != nil {
+		return 0
+	}
+
+	info := t.tokenCache.Info(c.Collateral)
+	b := acc.Balance(c.Collateral)
+	return calcQuoteQuant(b.Available+b.Pending, stableInfo.Decimals, price, OrderPriceDecimals, info.Decimals)
+}
+
+// cdpHealthy returns true if the CDP's ratio of the collateral value
+// to the debt is at least the given ratio.
+func (t *Transition) cdpHealthy(acc *Account, c CDP, ratio uint64) (bool, error) {
+	if c.Debt == 0 {
+		return true, nil
+	}
+
+	p, ok := t.state.OraclePrice(c.Collateral)
+	if !ok {
+		return false, fmt.Errorf("token %d has no oracle price", c.Collateral)
+	}
+
+	return ratioAtLeast(t.cdpValue(acc, c, p.Price), c.Debt, ratio), nil
+}
+
+func (t *Transition) cdpLock(owner *Account, txn *CDPLockTxn) error {
+	if txn.Quant == 0 {
+		return errors.New("lock quantity should not be 0")
+	}
+
+	ownerAddr := owner.PK().Addr()
+	addr := CDPAddr(ownerAddr, txn.Collateral)
+	c, ok := t.state.CDP(addr)
+	if txn.Withdraw {
+		if !ok {
+			return fmt.Errorf("CDP of collateral %d does not exist", txn.Collateral)
+		}
+
+		if c.Liquidating {
+			return errors.New("CDP is being liquidated")
+		}
+
+		acc := t.state.Account(addr)
+		b := acc.Balance(txn.Collateral)
+		if b.Available < txn.Quant {
+			return txnErrorf(ErrCodeInsufficientBalance, "insufficient CDP collateral, token id: %v, quantity: %d, available: %d", txn.Collateral, txn.Quant, b.Available)
+		}
+
+		cfg, _ := t.state.CDPConfig(txn.Collateral)
+		b.Available -= txn.Quant
+		acc.UpdateBalance(txn.Collateral, b)
+		healthy, err := t.cdpHealthy(acc, c, cfg.MinRatio)
+		if err == nil && !healthy {
+			err = errors.New("withdrawal would make the CDP undercollateralized")
+		}
+
+		if err != nil {
+			b.Available += txn.Quant
+			acc.UpdateBalance(txn.Collateral, b)
+			return err
+		}
+
+		ob := owner.Balance(txn.Collateral)
+		ob.Available += txn.Quant
+		owner.UpdateBalance(txn.Collateral, ob)
+		return nil
+	}
+
+	if _, ok := t.state.CDPConfig(txn.Collateral); !ok {
+		return fmt.Errorf("token %d is not accepted as collateral", txn.Collateral)
+	}
+
+	ob := owner.Balance(txn.Collateral)
+	if ob.Available < txn.Quant {
+		return txnErrorf(ErrCodeInsufficientBalance, "insufficient available token balance, token id: %v, quantity: %d, available: %d", txn.Collateral, txn.Quant, ob.Available)
+	}
+
+	var acc *Account
+	if ok {
+		acc = t.state.Account(addr)
+	} else {
+		acc = t.state.NewAccount(cdpPK(ownerAddr, txn.Collateral))
+		t.state.UpdateCDP(addr, CDP{Owner: ownerAddr, Collateral: txn.Collateral})
+	}
+
+	ob.Available -= txn.Quant
+	owner.UpdateBalance(txn.Collateral, ob)
+	b := acc.Balance(txn.Collateral)
+	b.Available += txn.Quant
+	acc.UpdateBalance(txn.Collateral, b)
+	return nil
+}
+
+func (t *Transition) cdpMint(owner *Account, txn *CDPMintTxn) error {
+	if txn.Quant == 0 {
+		return errors.New("mint quantity should not be 0")
+	}
+
+	addr := CDPAddr(owner.PK().Addr(), txn.Collateral)
+	c, ok := t.state.CDP(addr)
+	if !ok {
+		return fmt.Errorf("CDP of collateral %d does not exist", txn.Collateral)
+	}
+
+	id, _, err := t.stablecoin()
+	if err != nil {
+		return err
+	}
+
+	acc := t.state.Account(addr)
+	if txn.Repay {
+		if c.Debt < txn.Quant {
+			return fmt.Errorf("repay quantity is greater than the debt, debt: %d, repay: %d", c.Debt, txn.Quant)
+		}
+
+		b := owner.Balance(id)
+		if b.Available < txn.Quant {
+			return txnErrorf(ErrCodeInsufficientBalance, "insufficient available stablecoin balance, quantity: %d, available: %d", txn.Quant, b.Available)
+		}
+
+		t.burnStablecoin(owner, id, txn.Quant)
+		c.Debt -= txn.Quant
+		t.updateCDP(acc, c)
+		return nil
+	}
+
+	if c.Liquidating {
+		return errors.New("CDP is being liquidated")
+	}
+
+	cfg, _ := t.state.CDPConfig(txn.Collateral)
+	c.Debt += txn.Quant
+	healthy, err := t.cdpHealthy(acc, c, cfg.MinRatio)
+	if err == nil && !healthy {
+		err = errors.New("mint would make the CDP undercollateralized")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	t.mintStablecoin(owner, id, txn.Quant)
+	t.updateCDP(acc, c)
+	return nil
+}
+
+func (t *Transition) mintStablecoin(acc *Account, id TokenID, quant uint64) {
+	b := acc.Balance(id)
+	b.Available += quant
+	acc.UpdateBalance(id, b)
+	info := t.tokenCache.Info(id)
+	info.TotalUnits += quant
+	t.state.UpdateToken(Token{ID: id, TokenInfo: info})
+	t.tokenCache.Update(id, info)
+}
+
+func (t *Transition) burnStablecoin(acc *Account, id TokenID, quant uint64) {
+	b := acc.Balance(id)
+	b.Available -= quant
+	acc.UpdateBalance(id, b)
+	info := t.tokenCache.Info(id)
+	info.TotalUnits -= quant
+	t.state.UpdateToken(Token{ID: id, TokenInfo: info})
+	t.tokenCache.Update(id, info)
+}
+
+// updateCDP saves the CDP, and keeps the index of the CDPs with
+// debt up to date.
+func (t *Transition) updateCDP(acc *Account, c CDP) {
+	addr := acc.PK().Addr()
+	if c.Debt == 0 {
+		c.Liquidating = false
+		t.state.RemoveCDPAccount(addr)
+	} else {
+		t.state.AddCDPAccount(addr)
+	}
+	t.state.UpdateCDP(addr, c)
+}
+
+// liquidateCDPs liquidates the CDPs whose collateral ratio at the
+// oracle price falls below the liquidation ratio. A CDP under
+// liquidation stays under liquidation until its debt is fully
+// repaid.
+func (t *Transition) liquidateCDPs() {
+	addrs := t.state.CDPAccounts()
+	if len(addrs) == 0 {
+		return
+	}
+
+	id, _, err := t.stablecoin()
+	if err != nil {
+		t.logger().Error("can not liquidate CDPs", "err", err)
+		return
+	}
+
+	for _, addr := range addrs {
+		c, ok := t.state.CDP(addr)
+		if !ok {
+			t.logger().Error("can not find CDP", "addr", addr)
+			continue
+		}
+
+		// the CDP of a blocked owner is frozen.
+		if t.checkBlocked(c.Owner) != nil {
+			continue
+		}
+
+		p, ok := t.state.OraclePrice(c.Collateral)
+		if !ok {
+			continue
+		}
+
+		acc := t.state.Account(addr)
+		if !c.Liquidating {
+			cfg, _ := t.state.CDPConfig(c.Collateral)
+			value := t.cdpValue(acc, c, p.Price)
+			if ratioAtLeast(value, c.Debt, cfg.LiquidationRatio) {
+				continue
+			}
+
+			t.logger().Info("liquidating CDP", "owner", c.Owner, "collateral", c.Collateral, "value", value, "debt", c.Debt)
+			c.Liquidating = true
+		}
+
+		t.liquidateCDP(acc, &c, id, p.Price)
+		t.updateCDP(acc, c)
+	}
+}
+
+// liquidateCDP cancels the CDP's pending orders, burns the
+// stablecoin proceeds to repay the debt, and places a sell order of
+// the collateral at liquidationSlippage below the oracle price to
+// repay the remaining debt.
+func (t *Transition) liquidateCDP(acc *Account, c *CDP, stable TokenID, price uint64) {
+	t.cancelPendingOrders(acc)
+	t.repayCDPHoldings(acc, c, stable)
+	if c.Debt > 0 {
+		m := MarketSymbol{Base: c.Collateral, Quote: stable}
+		stableInfo := t.tokenCache.Info(stable)
+		info := t.tokenCache.Info(c.Collateral)
+		p := liquidationPrice(price, false)
+		quant := calcBaseQuant(c.Debt, stableInfo.Decimals, p, OrderPriceDecimals, info.Decimals) + 1
+		if available := acc.Balance(c.Collateral).Available; quant > available {
+			quant = available
+		}
+
+		if quant > 0 {
+			err := t.placeOrderImpl(acc, &PlaceOrderTxn{SellSide: true, Quant: quant, Price: p, Market: m}, t.round)
+			if err != nil {
+				t.logger().Warn("failed to place CDP liquidation order", "owner", c.Owner, "market", m, "err", err)
+			}
+		}
+
+		// repay with the proceeds of the liquidation order
+		// that is filled immediately.
+		t.repayCDPHoldings(acc, c, stable)
+	}
+
+	if c.Debt == 0 {
+		t.cancelPendingOrders(acc)
+		// the surplus proceeds belong to the owner.
+		if surplus := acc.Balance(stable).Available; surplus > 0 {
+			owner := t.state.Account(c.Owner)
+			if owner != nil {
+				sb := acc.Balance(stable)
+				sb.Available = 0
+				acc.UpdateBalance(stable, sb)
+				b := owner.Balance(stable)
+				b.Available += surplus
+				owner.UpdateBalance(stable, b)
+			}
+		}
+		return
+	}
+
+	if len(acc.PendingOrders()) > 0 {
+		return
+	}
+
+	// the collateral is exhausted, the remaining debt is written
+	// off and the stablecoin in circulation is undercollateralized
+	// by the amount.
+	t.logger().Warn("writing off CDP bad debt", "owner", c.Owner, "collateral", c.Collateral, "debt", c.Debt)
+	c.Debt = 0
+}
+
+func (t *Transition) repayCDPHoldings(acc *Account, c *CDP, stable TokenID) {
+	quant := acc.Balance(stable).Available
+	if quant > c.Debt {
+		quant = c.Debt
+	}
+
+	if quant > 0 {
+		t.burnStablecoin(acc, stable, quant)
+		c.Debt -= quant
+	}
+}
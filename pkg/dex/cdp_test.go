@@ -0,0 +1,82 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCDP(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	one := uint64(math.Pow10(OrderPriceDecimals))
+	s.UpdateOraclePrice(0, OraclePrice{Price: one})
+	pkGov, skGov := RandKeyPair()
+	pkBuyer, skBuyer := RandKeyPair()
+	pk, sk := RandKeyPair()
+	s.NewAccount(pkGov)
+	s.NewAccount(pkBuyer)
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 1000})
+	s.UpdateGovernor(pkGov.Addr())
+	pker := &myPKer{m: map[consensus.Addr]PK{
+		pkGov.Addr():   pkGov,
+		pkBuyer.Addr(): pkBuyer,
+		pk.Addr():      pk,
+	}}
+	addr := pk.Addr()
+	gov := pkGov.Addr()
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	info := TokenInfo{Symbol: "USDX", Decimals: 8}
+	assert.NotNil(t, recordTxn(t, trans, MakeCreateStablecoinTxn(sk, addr, CreateStablecoinTxn{Info: info}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeCreateStablecoinTxn(skGov, gov, CreateStablecoinTxn{Info: info}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeConfigCDPTxn(skGov, gov, ConfigCDPTxn{Collateral: 0, MinRatio: 150, LiquidationRatio: 120}, 1), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeCDPLockTxn(sk, addr, CDPLockTxn{Collateral: 0, Quant: 1000}, 0), pker))
+	s = trans.Commit().(*State)
+
+	stable, ok := s.Stablecoin()
+	assert.True(t, ok)
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeCDPMintTxn(sk, addr, CDPMintTxn{Collateral: 0, Quant: 600}, 1), pker))
+	// 1000 / 700 is below the min ratio
+	err := recordTxn(t, trans, MakeCDPMintTxn(sk, addr, CDPMintTxn{Collateral: 0, Quant: 100}, 2), pker)
+	assert.Contains(t, err.Error(), "undercollateralized")
+	err = recordTxn(t, trans, MakeCDPLockTxn(sk, addr, CDPLockTxn{Collateral: 0, Quant: 200, Withdraw: true}, 2), pker)
+	assert.Contains(t, err.Error(), "undercollateralized")
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkBuyer, stable, 600, 2), pker))
+	s = trans.Commit().(*State)
+
+	cdpAddr := CDPAddr(addr, 0)
+	c, ok := s.CDP(cdpAddr)
+	assert.True(t, ok)
+	assert.Equal(t, 600, int(c.Debt))
+	assert.Equal(t, []consensus.Addr{cdpAddr}, s.CDPAccounts())
+
+	// the price drops to 0.7 after the opening auction of the
+	// market, the CDP is liquidated against the resting buy
+	// order at 0.75.
+	market := MarketSymbol{Base: 0, Quote: stable}
+	s.UpdateOraclePrice(0, OraclePrice{Price: 7 * one / 10})
+	trans = s.Transition(2+openingAuctionRounds, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skBuyer, pkBuyer.Addr(), PlaceOrderTxn{Quant: 800, Price: 75 * one / 100, Market: market}, 0), pker))
+	s = trans.Commit().(*State)
+
+	acc := s.Account(cdpAddr)
+	assert.Equal(t, 200, int(acc.Balance(0).Available))
+	assert.Equal(t, 0, int(acc.Balance(0).Pending))
+	assert.Equal(t, 0, int(acc.Balance(stable).Available))
+	assert.Equal(t, 0, len(acc.PendingOrders()))
+	assert.Equal(t, 800, int(s.Account(pkBuyer.Addr()).Balance(0).Available))
+	c, _ = s.CDP(cdpAddr)
+	assert.Equal(t, 0, int(c.Debt))
+	assert.False(t, c.Liquidating)
+	assert.Equal(t, 0, len(s.CDPAccounts()))
+	for _, tk := range s.Tokens() {
+		if tk.ID == stable {
+			assert.Equal(t, 0, int(tk.TotalUnits))
+		}
+	}
+}
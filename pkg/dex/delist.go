@@ -0,0 +1,121 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Delisting is the governor's decision to delist the token. The
+// markets of the token stop accepting new orders at Round, their
+// resting orders are cancelled and refunded at the end of
+// RetireRound, and the markets are closed.
+type Delisting struct {
+	Round       uint64
+	RetireRound uint64
+}
+
+func (t *Transition) delist(owner *Account, txn *DelistTxn) error {
+	if err := t.checkGovernor(owner); err != nil {
+		return err
+	}
+
+	if int(txn.Token) >= t.tokenCache.Size() {
+		return fmt.Errorf("token %d does not exist", txn.Token)
+	}
+
+	if txn.RetireRound <= t.round {
+		return fmt.Errorf("retire round %d should be later than the current round %d", txn.RetireRound, t.round)
+	}
+
+	if _, ok := t.state.Delisting(txn.Token); ok {
+		return errors.New("token is already delisted")
+	}
+
+	t.state.Delist(txn.Token, Delisting{Round: t.round, RetireRound: txn.RetireRound})
+	return nil
+}
+
+// checkListed returns an error if the market does not accept new
+// orders, since one of its tokens is delisted.
+func (t *Transition) checkListed(m MarketSymbol) error {
+	for _, id := range []TokenID{m.Base, m.Quote} {
+		if d, ok := t.state.Delisting(id); ok {
+			return txnErrorf(ErrCodeBadMarket, "market %v does not accept new orders, token %d is delisted at round %d", m, id, d.Round)
+		}
+	}
+
+	return nil
+}
+
+// retireMarkets cancels and refunds the resting orders of the
+// markets of the tokens that retire at the current round, and closes
+// the markets.
+func (t *Transition) retireMarkets() {
+	tokens := t.state.RetiringTokens(t.round)
+	if len(tokens) == 0 {
+		return
+	}
+
+	t.state.RemoveRetiringTokens(t.round)
+	for _, token := range tokens {
+		// the token IDs are sequential, the markets of the
+		// token are the pairs with all the other tokens.
+		for i := 0; i < t.tokenCache.Size(); i++ {
+			other := TokenID(i)
+			if other == token {
+				continue
+			}
+
+			t.retireMarket(MarketSymbol{Base: token, Quote: other})
+			t.retireMarket(MarketSymbol{Base: other, Quote: token})
+		}
+	}
+}
+
+func (t *Transition) retireMarket(m MarketSymbol) {
+	if _, ok := t.state.MarketClosed(m); ok {
+		return
+	}
+
+	book := t.orderBooks[m]
+	if book == nil {
+		book = t.state.loadOrderBook(m)
+		if book == nil {
+			// the market never had an order.
+			return
+		}
+		t.orderBooks[m] = book
+	}
+
+	var entries []orderBookEntryData
+	for _, p := range append(flatten(book.bidMax), flatten(book.askMin)...) {
+		entries = append(entries, p.Entries...)
+	}
+
+	for _, e := range entries {
+		book.Cancel(e.ID)
+		acc := t.state.Account(e.Owner)
+		if acc == nil {
+			t.logger().Error("can not find the owner of the retiring order", "owner", e.Owner)
+			continue
+		}
+
+		id := OrderID{ID: e.ID, Market: m}
+		order, ok := acc.PendingOrder(id)
+		if !ok {
+			t.logger().Error("can not find retiring order", "order", id)
+			continue
+		}
+
+		acc.RemovePendingOrder(id)
+		t.refundAfterCancel(acc, order, m)
+		if order.ExpireRound > 0 {
+			// removes the expiration like a filled order.
+			t.filledOrders = append(t.filledOrders, order)
+		}
+	}
+
+	t.dirtyOrderBooks[m] = true
+	t.state.CloseMarket(m, t.round)
+	t.logger().Info("market retired", "market", m, "cancelled", len(entries))
+}
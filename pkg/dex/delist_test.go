@@ -0,0 +1,72 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelist(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 2, TokenInfo: BNBInfo})
+	pkGov, skGov := RandKeyPair()
+	pk, sk := RandKeyPair()
+	s.NewAccount(pkGov)
+	acc := s.NewAccount(pk)
+	acc.UpdateBalance(1, Balance{Available: 300})
+	acc.UpdateBalance(2, Balance{Available: 300})
+	s.UpdateGovernor(pkGov.Addr())
+	gov, addr := pkGov.Addr(), pk.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{gov: pkGov, addr: pk}}
+
+	delisted := MarketSymbol{Base: 1, Quote: 2}
+	listed := MarketSymbol{Base: 2, Quote: 0}
+	price := uint64(math.Pow10(OrderPriceDecimals))
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 100, Price: 2 * price, Market: delisted}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{Quant: 100, Price: price, ExpireRound: 5, Market: delisted}, 1), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 50, Price: price, Market: listed}, 2), pker))
+	s = trans.Commit().(*State)
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.NotNil(t, recordTxn(t, trans, MakeDelistTxn(sk, addr, DelistTxn{Token: 1, RetireRound: 3}, 3), pker), "not the governor")
+	assert.NotNil(t, recordTxn(t, trans, MakeDelistTxn(skGov, gov, DelistTxn{Token: 1, RetireRound: 2}, 0), pker), "retire round passed")
+	assert.NotNil(t, recordTxn(t, trans, MakeDelistTxn(skGov, gov, DelistTxn{Token: 3, RetireRound: 3}, 0), pker), "token does not exist")
+	assert.Nil(t, recordTxn(t, trans, MakeDelistTxn(skGov, gov, DelistTxn{Token: 1, RetireRound: 3}, 0), pker))
+	assert.NotNil(t, recordTxn(t, trans, MakeDelistTxn(skGov, gov, DelistTxn{Token: 1, RetireRound: 4}, 1), pker), "already delisted")
+	assert.NotNil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 100, Price: 2 * price, Market: delisted}, 3), pker), "market stopped")
+	s = trans.Commit().(*State)
+
+	d, ok := s.Delisting(1)
+	assert.True(t, ok)
+	assert.Equal(t, Delisting{Round: 2, RetireRound: 3}, d)
+	acc = s.Account(addr)
+	assert.Equal(t, 3, len(acc.PendingOrders()), "resting orders stay until the retire round")
+
+	trans = s.Transition(3, 0, nil).(*Transition)
+	s = trans.Commit().(*State)
+	acc = s.Account(addr)
+	assert.Equal(t, []PendingOrder{{ID: OrderID{ID: 0, Market: listed}, Order: Order{Owner: addr, SellSide: true, Quant: 50, Price: price}}}, acc.PendingOrders())
+	assert.Equal(t, 300, int(acc.Balance(1).Available))
+	assert.Equal(t, 0, int(acc.Balance(1).Pending))
+	assert.Equal(t, 250, int(acc.Balance(2).Available))
+	assert.Equal(t, 50, int(acc.Balance(2).Pending))
+	round, ok := s.MarketClosed(delisted)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3), round)
+	_, ok = s.MarketClosed(listed)
+	assert.False(t, ok)
+	assert.Empty(t, s.RetiringTokens(3))
+
+	// the expiration of the cancelled order is removed.
+	trans = s.Transition(4, 0, nil).(*Transition)
+	s = trans.Commit().(*State)
+	acc = s.Account(addr)
+	assert.Equal(t, 1, len(acc.PendingOrders()))
+	assert.Equal(t, 250, int(acc.Balance(2).Available))
+}
@@ -0,0 +1,107 @@
+package dex
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// DepositAddr is a receive-only sub-address of an account, e.g., for
+// an exchange crediting the deposits of each customer to one
+// account. The tokens sent to it are credited to the account, and
+// the deposit is recorded with the index.
+type DepositAddr struct {
+	Addr  consensus.Addr
+	Index uint32
+}
+
+// Encode returns the bech32 encoding of the deposit address on the
+// network, it's longer than the account addresses.
+func (d DepositAddr) Encode(network consensus.NetworkID) string {
+	b := make([]byte, len(d.Addr)+4)
+	copy(b, d.Addr[:])
+	binary.BigEndian.PutUint32(b[len(d.Addr):], d.Index)
+	return consensus.EncodeBech32(network, b)
+}
+
+// DecodeDepositAddr decodes the bech32 encoded deposit address of
+// the network.
+func DecodeDepositAddr(network consensus.NetworkID, str string) (DepositAddr, error) {
+	var d DepositAddr
+	b, err := consensus.DecodeBech32(network, str)
+	if err != nil {
+		return d, err
+	}
+
+	if len(b) != len(d.Addr)+4 {
+		return d, fmt.Errorf("%s is not a deposit address", str)
+	}
+
+	copy(d.Addr[:], b)
+	d.Index = binary.BigEndian.Uint32(b[len(d.Addr):])
+	return d, nil
+}
+
+// Deposit is a transfer to a deposit address of the account.
+type Deposit struct {
+	// Index is the index of the deposit address.
+	Index   uint32
+	From    consensus.Addr
+	TokenID TokenID
+	Quant   uint64
+	Round   uint64
+}
+
+func (t *Transition) sendToDeposit(owner *Account, txn *SendToDepositTxn) error {
+	if txn.Quant == 0 {
+		return errors.New("send token quantity is 0")
+	}
+
+	b := owner.Balance(txn.TokenID)
+	if b.Available < txn.Quant {
+		return txnErrorf(ErrCodeInsufficientBalance, "insufficient available token balance, tokenID: %v, quant: %d, available: %d", txn.TokenID, txn.Quant, b.Available)
+	}
+
+	// the deposit address does not carry the public key, the
+	// account must exist.
+	toAcc := t.state.Account(txn.To.Addr)
+	if toAcc == nil {
+		return fmt.Errorf("account of the deposit address not found: %v", txn.To.Addr)
+	}
+
+	from := owner.PK().Addr()
+	for _, addr := range []consensus.Addr{from, txn.To.Addr} {
+		if err := t.checkHolder(txn.TokenID, addr); err != nil {
+			return err
+		}
+	}
+
+	if err := t.checkAllowList(from, txn.To.Addr); err != nil {
+		return err
+	}
+
+	if err := t.checkTransferThreshold(from, txn.TokenID, txn.Quant); err != nil {
+		return err
+	}
+
+	if err := t.checkBlocked(txn.To.Addr); err != nil {
+		return err
+	}
+
+	b.Available -= txn.Quant
+	owner.UpdateBalance(txn.TokenID, b)
+	t.addOutflow(from, txn.TokenID, txn.Quant)
+	toBalance := toAcc.Balance(txn.TokenID)
+	toBalance.Available += txn.Quant
+	toAcc.UpdateBalance(txn.TokenID, toBalance)
+	t.state.AddDeposit(txn.To.Addr, Deposit{
+		Index:   txn.To.Index,
+		From:    from,
+		TokenID: txn.TokenID,
+		Quant:   txn.Quant,
+		Round:   t.round,
+	})
+	return nil
+}
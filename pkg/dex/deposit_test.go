@@ -0,0 +1,59 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDepositAddrEncode(t *testing.T) {
+	pk, _ := RandKeyPair()
+	d := DepositAddr{Addr: pk.Addr(), Index: 70000}
+	str := d.Encode(consensus.Devnet)
+	decoded, err := DecodeDepositAddr(consensus.Devnet, str)
+	assert.Nil(t, err)
+	assert.Equal(t, d, decoded)
+
+	_, err = DecodeDepositAddr(consensus.Mainnet, str)
+	assert.NotNil(t, err, "address of another network")
+	_, err = consensus.DecodeAddr(consensus.Devnet, str)
+	assert.NotNil(t, err, "not an account address")
+	_, err = DecodeDepositAddr(consensus.Devnet, pk.Addr().Encode(consensus.Devnet))
+	assert.NotNil(t, err, "not a deposit address")
+}
+
+func TestSendToDeposit(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	pkExchange, _ := RandKeyPair()
+	pkUnknown, _ := RandKeyPair()
+	addr, exchange := pk.Addr(), pkExchange.Addr()
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 300})
+	s.NewAccount(pkExchange)
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	send := func(to DepositAddr, quant uint64, nonce uint64) error {
+		return recordTxn(t, trans, MakeSendToDepositTxn(sk, addr, SendToDepositTxn{TokenID: 0, To: to, Quant: quant}, nonce), pker)
+	}
+	assert.Nil(t, send(DepositAddr{Addr: exchange, Index: 7}, 100, 0))
+	assert.Nil(t, send(DepositAddr{Addr: exchange, Index: 8}, 50, 1))
+	assert.NotNil(t, send(DepositAddr{Addr: pkUnknown.Addr(), Index: 1}, 50, 2), "account not found")
+	assert.NotNil(t, send(DepositAddr{Addr: exchange, Index: 7}, 500, 2), "insufficient balance")
+	s = trans.Commit().(*State)
+
+	assert.Equal(t, 150, int(s.Account(exchange).Balance(0).Available))
+	assert.Equal(t, 150, int(s.Account(addr).Balance(0).Available))
+	var p DepositPage
+	assert.Nil(t, queryDeposits(s, DepositsArgs{Addr: exchange, PageArgs: PageArgs{Limit: 1}}, &p))
+	assert.Equal(t, []Deposit{{Index: 7, From: addr, TokenID: 0, Quant: 100, Round: 1}}, p.Deposits)
+	assert.True(t, p.More)
+
+	p = DepositPage{}
+	assert.Nil(t, queryDeposits(s, DepositsArgs{Addr: exchange, PageArgs: PageArgs{Cursor: 1}}, &p))
+	assert.Equal(t, []Deposit{{Index: 8, From: addr, TokenID: 0, Quant: 50, Round: 1}}, p.Deposits)
+	assert.False(t, p.More)
+}
@@ -0,0 +1,181 @@
+package dex
+
+import (
+	"errors"
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// MaxDepthLevels is the max number of the levels of each side in a
+// depth snapshot.
+const MaxDepthLevels = 1000
+
+// ErrDepthChecksum is returned when the checksum of the depth after
+// applying a delta does not match, the subscriber missed an update
+// and should request a new snapshot.
+var ErrDepthChecksum = errors.New("depth checksum mismatch")
+
+// ErrDepthSeq is returned when a delta does not follow the last
+// applied delta, the subscriber should recover from the depth feed.
+var ErrDepthSeq = errors.New("depth delta out of sequence")
+
+// DepthLevel is the total quantity of the orders at a price.
+type DepthLevel struct {
+	Price uint64
+	Quant uint64
+}
+
+// Depth is the top levels of the order book of a market, the best
+// level is the first.
+type Depth struct {
+	Market MarketSymbol
+	// Seq is the sequence number of the last delta applied, the
+	// deltas after it update the depth.
+	Seq      uint64
+	Bids     []DepthLevel
+	Asks     []DepthLevel
+	Checksum uint32
+}
+
+// DepthDelta is the change of the top levels between two depth
+// snapshots. A level of quantity 0 is removed, Checksum is the
+// checksum of the depth after applying the delta.
+type DepthDelta struct {
+	Market MarketSymbol
+	// Seq is the sequence number of the delta in the depth feed,
+	// 0 if the delta is not from a feed.
+	Seq      uint64
+	Round    uint64
+	Bids     []DepthLevel
+	Asks     []DepthLevel
+	Checksum uint32
+}
+
+// depth returns the top n non-empty levels of each side.
+func (o *orderBook) depth(n int) (bids, asks []DepthLevel) {
+	side := func(p *pricePoint) []DepthLevel {
+		var r []DepthLevel
+		for ; p != nil && len(r) < n; p = p.NextPoint {
+			q := p.quant()
+			if q > 0 {
+				r = append(r, DepthLevel{Price: p.Price, Quant: q})
+			}
+		}
+		return r
+	}
+	return side(o.bidMax), side(o.askMin)
+}
+
+// Depth returns the top levels of the order book of the market.
+func (s *State) Depth(m MarketSymbol, levels int) Depth {
+	d := Depth{Market: m}
+	book := s.loadOrderBook(m)
+	if book != nil {
+		d.Bids, d.Asks = book.depth(levels)
+	}
+	d.Checksum = depthChecksum(d.Bids, d.Asks)
+	return d
+}
+
+// depthChecksum is the CRC32 (IEEE) of the levels interleaved from
+// the best to the worst as "bid_price:bid_quant:ask_price:ask_quant:
+// ...", a side that runs out of levels is skipped.
+func depthChecksum(bids, asks []DepthLevel) uint32 {
+	var b []byte
+	add := func(l DepthLevel) {
+		if len(b) > 0 {
+			b = append(b, ':')
+		}
+		b = strconv.AppendUint(b, l.Price, 10)
+		b = append(b, ':')
+		b = strconv.AppendUint(b, l.Quant, 10)
+	}
+
+	for i := 0; i < len(bids) || i < len(asks); i++ {
+		if i < len(bids) {
+			add(bids[i])
+		}
+		if i < len(asks) {
+			add(asks[i])
+		}
+	}
+	return crc32.ChecksumIEEE(b)
+}
+
+func diffLevels(prev, cur []DepthLevel) []DepthLevel {
+	m := make(map[uint64]uint64, len(prev))
+	for _, l := range prev {
+		m[l.Price] = l.Quant
+	}
+
+	var r []DepthLevel
+	for _, l := range cur {
+		q, ok := m[l.Price]
+		delete(m, l.Price)
+		if !ok || q != l.Quant {
+			r = append(r, l)
+		}
+	}
+
+	for _, l := range prev {
+		if _, ok := m[l.Price]; ok {
+			r = append(r, DepthLevel{Price: l.Price})
+		}
+	}
+	return r
+}
+
+// DiffDepth returns the delta that changes prev to cur.
+func DiffDepth(prev, cur Depth) DepthDelta {
+	return DepthDelta{
+		Market:   cur.Market,
+		Bids:     diffLevels(prev.Bids, cur.Bids),
+		Asks:     diffLevels(prev.Asks, cur.Asks),
+		Checksum: cur.Checksum,
+	}
+}
+
+func applyLevels(levels, delta []DepthLevel, better func(a, b uint64) bool) []DepthLevel {
+	m := make(map[uint64]uint64, len(levels))
+	for _, l := range levels {
+		m[l.Price] = l.Quant
+	}
+	for _, l := range delta {
+		if l.Quant == 0 {
+			delete(m, l.Price)
+		} else {
+			m[l.Price] = l.Quant
+		}
+	}
+
+	r := make([]DepthLevel, 0, len(m))
+	for p, q := range m {
+		r = append(r, DepthLevel{Price: p, Quant: q})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return better(r[i].Price, r[j].Price)
+	})
+	return r
+}
+
+// Apply applies the delta to the depth, it returns ErrDepthSeq if a
+// delta of a feed does not follow the sequence number of the depth,
+// and ErrDepthChecksum if the checksum of the result does not match
+// the delta's.
+func (d *Depth) Apply(delta DepthDelta) error {
+	if delta.Seq > 0 {
+		if delta.Seq != d.Seq+1 {
+			return ErrDepthSeq
+		}
+		d.Seq = delta.Seq
+	}
+
+	d.Bids = applyLevels(d.Bids, delta.Bids, func(a, b uint64) bool { return a > b })
+	d.Asks = applyLevels(d.Asks, delta.Asks, func(a, b uint64) bool { return a < b })
+	d.Checksum = depthChecksum(d.Bids, d.Asks)
+	if d.Checksum != delta.Checksum {
+		return ErrDepthChecksum
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package dex
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDepthBuffer is the default number of the recent
+	// deltas retained for each depth feed.
+	DefaultDepthBuffer = 1000
+	// MaxDepthPollWait is the max duration that a poll waits for
+	// new deltas.
+	MaxDepthPollWait = 30 * time.Second
+)
+
+type DepthDeltasArgs struct {
+	Market MarketSymbol
+	Levels int
+	// After is the sequence number of the subscriber's depth.
+	After uint64
+	// Wait is the max duration to wait for new deltas.
+	Wait time.Duration
+}
+
+// DepthDeltas is the deltas after the subscriber's depth. If the
+// deltas after it are no longer buffered, Snapshot is the current
+// depth and Deltas is empty.
+type DepthDeltas struct {
+	Deltas   []DepthDelta
+	Snapshot *Depth
+}
+
+// depthFeedKey identifies a depth feed, the feeds of different
+// levels have different deltas.
+type depthFeedKey struct {
+	Market MarketSymbol
+	Levels int
+}
+
+type depthFeed struct {
+	depth  Depth
+	deltas []DepthDelta
+	notify chan struct{}
+}
+
+// depthFeeds is the sequenced depth deltas of the markets, a
+// subscriber gets the depth at a sequence number and applies the
+// buffered deltas after it. A reconnecting subscriber resumes from its
+// last sequence number, it gets a snapshot only if the deltas after
+// it are dropped. A feed is tracked from the first request for it, the
+// sequence numbers are local to the node.
+type depthFeeds struct {
+	mu     sync.Mutex
+	buffer int
+	feeds  map[depthFeedKey]*depthFeed
+}
+
+func newDepthFeeds() *depthFeeds {
+	return &depthFeeds{buffer: DefaultDepthBuffer, feeds: make(map[depthFeedKey]*depthFeed)}
+}
+
+func (d *depthFeeds) setBuffer(n int) {
+	if n <= 0 {
+		n = DefaultDepthBuffer
+	}
+
+	d.mu.Lock()
+	d.buffer = n
+	d.mu.Unlock()
+}
+
+// update publishes the deltas of the tracked depths in the state.
+func (d *depthFeeds) update(s *State) {
+	s.mu.Lock()
+	round := s.round
+	s.mu.Unlock()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for k, f := range d.feeds {
+		cur := s.Depth(k.Market, k.Levels)
+		delta := DiffDepth(f.depth, cur)
+		if len(delta.Bids) == 0 && len(delta.Asks) == 0 {
+			continue
+		}
+
+		delta.Seq = f.depth.Seq + 1
+		delta.Round = round
+		cur.Seq = delta.Seq
+		f.depth = cur
+		f.deltas = append(f.deltas, delta)
+		if len(f.deltas) > d.buffer {
+			f.deltas = f.deltas[len(f.deltas)-d.buffer:]
+		}
+		close(f.notify)
+		f.notify = make(chan struct{})
+	}
+}
+
+// feed returns the feed of the key, it starts tracking the depth of
+// the state.
+func (d *depthFeeds) feed(s *State, k depthFeedKey) (*depthFeed, error) {
+	if k.Levels <= 0 || k.Levels > MaxDepthLevels {
+		return nil, fmt.Errorf("levels must be between 1 and %d, got: %d", MaxDepthLevels, k.Levels)
+	}
+
+	f := d.feeds[k]
+	if f == nil {
+		f = &depthFeed{depth: s.Depth(k.Market, k.Levels), notify: make(chan struct{})}
+		d.feeds[k] = f
+	}
+	return f, nil
+}
+
+func copyDepth(d Depth) *Depth {
+	d.Bids = append([]DepthLevel(nil), d.Bids...)
+	d.Asks = append([]DepthLevel(nil), d.Asks...)
+	return &d
+}
+
+func (d *depthFeeds) snapshot(s *State, args DepthArgs, r *Depth) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, err := d.feed(s, depthFeedKey{Market: args.Market, Levels: args.Levels})
+	if err != nil {
+		return err
+	}
+
+	*r = *copyDepth(f.depth)
+	return nil
+}
+
+func (d *depthFeeds) poll(s *State, args DepthDeltasArgs, r *DepthDeltas) error {
+	wait := args.Wait
+	if wait > MaxDepthPollWait {
+		wait = MaxDepthPollWait
+	}
+	timeout := time.After(wait)
+
+	for {
+		d.mu.Lock()
+		f, err := d.feed(s, depthFeedKey{Market: args.Market, Levels: args.Levels})
+		if err != nil {
+			d.mu.Unlock()
+			return err
+		}
+
+		seq := f.depth.Seq
+		i := sort.Search(len(f.deltas), func(i int) bool { return f.deltas[i].Seq > args.After })
+		if args.After > seq || args.After < seq && (i == len(f.deltas) || f.deltas[i].Seq > args.After+1) {
+			// the deltas after args.After are dropped, or
+			// the node restarted the feed.
+			r.Snapshot = copyDepth(f.depth)
+			d.mu.Unlock()
+			return nil
+		}
+
+		notify := f.notify
+		if i < len(f.deltas) || wait <= 0 {
+			r.Deltas = append([]DepthDelta(nil), f.deltas[i:]...)
+			d.mu.Unlock()
+			return nil
+		}
+		d.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-timeout:
+			wait = 0
+		}
+	}
+}
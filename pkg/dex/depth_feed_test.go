@@ -0,0 +1,65 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDepthFeedRecovery(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	m := MarketSymbol{Base: 1, Quote: 0}
+	book := newOrderBook()
+	book.Add(Order{Price: 100, Quant: 5})
+	s.saveOrderBook(m, book)
+
+	feeds := newDepthFeeds()
+	feeds.setBuffer(2)
+	args := DepthArgs{Market: m, Levels: 2}
+	var local Depth
+	assert.Nil(t, feeds.snapshot(s, args, &local))
+	assert.Equal(t, uint64(0), local.Seq)
+
+	update := func(round uint64, o Order) {
+		book.Add(o)
+		s.saveOrderBook(m, book)
+		s.setTrades(round, nil)
+		feeds.update(s)
+	}
+	update(1, Order{Price: 99, Quant: 1})
+	update(2, Order{SellSide: true, Price: 101, Quant: 2})
+	feeds.update(s)
+
+	// the subscriber applies the buffered deltas after its
+	// snapshot.
+	var d DepthDeltas
+	assert.Nil(t, feeds.poll(s, DepthDeltasArgs{Market: m, Levels: 2, After: local.Seq}, &d))
+	assert.Nil(t, d.Snapshot)
+	assert.Equal(t, 2, len(d.Deltas), "no delta without a change")
+	for _, delta := range d.Deltas {
+		assert.Nil(t, local.Apply(delta))
+	}
+	assert.Equal(t, s.Depth(m, 2).Checksum, local.Checksum)
+	assert.Equal(t, uint64(2), local.Seq)
+	assert.Equal(t, ErrDepthSeq, local.Apply(d.Deltas[0]))
+
+	// a subscriber further behind than the buffer recovers from
+	// the snapshot.
+	update(3, Order{Price: 100, Quant: 3})
+	d = DepthDeltas{}
+	assert.Nil(t, feeds.poll(s, DepthDeltasArgs{Market: m, Levels: 2, After: 0}, &d))
+	assert.Empty(t, d.Deltas)
+	assert.Equal(t, uint64(3), d.Snapshot.Seq)
+	assert.Equal(t, s.Depth(m, 2).Checksum, d.Snapshot.Checksum)
+
+	d = DepthDeltas{}
+	assert.Nil(t, feeds.poll(s, DepthDeltasArgs{Market: m, Levels: 2, After: local.Seq}, &d))
+	assert.Nil(t, d.Snapshot)
+	assert.Equal(t, 1, len(d.Deltas))
+	assert.Nil(t, local.Apply(d.Deltas[0]))
+
+	d = DepthDeltas{}
+	assert.Nil(t, feeds.poll(s, DepthDeltasArgs{Market: m, Levels: 2, After: 10}, &d))
+	assert.NotNil(t, d.Snapshot, "the feed restarted")
+}
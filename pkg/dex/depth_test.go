@@ -0,0 +1,69 @@
+package dex
+
+import (
+	"hash/crc32"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDepth(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	m := MarketSymbol{Base: 1, Quote: 0}
+	book := newOrderBook()
+	book.Add(Order{Price: 100, Quant: 5})
+	book.Add(Order{Price: 100, Quant: 3})
+	book.Add(Order{Price: 99, Quant: 1})
+	book.Add(Order{Price: 98, Quant: 7})
+	cancelled := book.Add(Order{Price: 97, Quant: 2})
+	book.Add(Order{Price: 96, Quant: 2})
+	book.Add(Order{SellSide: true, Price: 101, Quant: 4})
+	book.Cancel(cancelled)
+	s.saveOrderBook(m, book)
+
+	d := s.Depth(m, 3)
+	assert.Equal(t, []DepthLevel{{100, 8}, {99, 1}, {98, 7}}, d.Bids)
+	assert.Equal(t, []DepthLevel{{101, 4}}, d.Asks)
+	assert.Equal(t, crc32.ChecksumIEEE([]byte("100:8:101:4:99:1:98:7")), d.Checksum)
+
+	d = s.Depth(m, 4)
+	assert.Equal(t, []DepthLevel{{100, 8}, {99, 1}, {98, 7}, {96, 2}}, d.Bids, "the cancelled level is skipped")
+
+	empty := s.Depth(MarketSymbol{Base: 2, Quote: 0}, 3)
+	assert.Empty(t, empty.Bids)
+	assert.Empty(t, empty.Asks)
+}
+
+func TestDepthDelta(t *testing.T) {
+	d0 := Depth{
+		Bids: []DepthLevel{{100, 8}, {99, 1}, {98, 7}},
+		Asks: []DepthLevel{{101, 4}, {103, 1}},
+	}
+	d0.Checksum = depthChecksum(d0.Bids, d0.Asks)
+	d1 := Depth{
+		Bids: []DepthLevel{{100, 6}, {98, 7}, {97, 3}},
+		Asks: []DepthLevel{{101, 4}, {102, 2}, {103, 1}},
+	}
+	d1.Checksum = depthChecksum(d1.Bids, d1.Asks)
+	d2 := Depth{
+		Bids: []DepthLevel{{100, 6}, {98, 7}, {97, 3}},
+		Asks: []DepthLevel{{102, 2}, {103, 1}},
+	}
+	d2.Checksum = depthChecksum(d2.Bids, d2.Asks)
+
+	delta1 := DiffDepth(d0, d1)
+	assert.Equal(t, []DepthLevel{{100, 6}, {97, 3}, {99, 0}}, delta1.Bids)
+	assert.Equal(t, []DepthLevel{{102, 2}}, delta1.Asks)
+	delta2 := DiffDepth(d1, d2)
+
+	local := d0
+	assert.Nil(t, local.Apply(delta1))
+	assert.Equal(t, d1, local)
+	assert.Nil(t, local.Apply(delta2))
+	assert.Equal(t, d2, local)
+
+	// the subscriber that missed delta1 detects it.
+	local = d0
+	assert.Equal(t, ErrDepthChecksum, local.Apply(delta2))
+}
@@ -0,0 +1,171 @@
+package dex
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// the votes of the buyer and the seller of an escrow.
+const (
+	escrowNoVote uint8 = iota
+	escrowVoteRelease
+	escrowVoteRefund
+)
+
+// Escrow locks the buyer's tokens for the seller. The tokens are
+// frozen in the buyer's balance until RefundRound, so they are
+// refunded by the frozen balance release if no decision is made in
+// time.
+type Escrow struct {
+	Buyer       consensus.Addr
+	Seller      PK
+	Arbiter     consensus.Addr
+	TokenID     TokenID
+	Quant       uint64
+	RefundRound uint64
+	BuyerVote   uint8
+	SellerVote  uint8
+}
+
+// EscrowID returns the ID of the escrow opened by the buyer's txn
+// with the nonce.
+func EscrowID(buyer consensus.Addr, nonce uint64) consensus.Hash {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, nonce)
+	return consensus.SHA3(buyer[:], b)
+}
+
+func (t *Transition) escrowOpen(owner *Account, txn *EscrowOpenTxn) error {
+	if txn.Quant == 0 {
+		return errors.New("escrow quantity is 0")
+	}
+
+	if txn.RefundRound <= t.round {
+		return fmt.Errorf("refund round %d should be after the current round %d", txn.RefundRound, t.round)
+	}
+
+	buyer := owner.PK().Addr()
+	seller := txn.Seller.Addr()
+	if buyer == seller || txn.Arbiter == buyer || txn.Arbiter == seller {
+		return errors.New("the buyer, the seller and the arbiter of the escrow should be different")
+	}
+
+	for _, addr := range []consensus.Addr{buyer, seller} {
+		if err := t.checkHolder(txn.TokenID, addr); err != nil {
+			return err
+		}
+	}
+
+	b := owner.Balance(txn.TokenID)
+	if b.Available < txn.Quant {
+		return txnErrorf(ErrCodeInsufficientBalance, "insufficient available token balance, tokenID: %v, quant: %d, available: %d", txn.TokenID, txn.Quant, b.Available)
+	}
+
+	if err := t.checkTransferThreshold(buyer, txn.TokenID, txn.Quant); err != nil {
+		return err
+	}
+
+	b.Available -= txn.Quant
+	b.Frozen = append(b.Frozen, Frozen{AvailableRound: txn.RefundRound, Quant: txn.Quant})
+	owner.UpdateBalance(txn.TokenID, b)
+	t.addOutflow(buyer, txn.TokenID, txn.Quant)
+	t.state.FreezeToken(txn.RefundRound, freezeToken{Addr: buyer, TokenID: txn.TokenID, Quant: txn.Quant})
+
+	id := EscrowID(buyer, owner.Nonce())
+	t.state.UpdateEscrow(id, Escrow{
+		Buyer:       buyer,
+		Seller:      txn.Seller,
+		Arbiter:     txn.Arbiter,
+		TokenID:     txn.TokenID,
+		Quant:       txn.Quant,
+		RefundRound: txn.RefundRound,
+	})
+	// the tokens are released by t.releaseTokens one round
+	// before RefundRound.
+	t.state.AddEscrowExpiration(txn.RefundRound-1, id)
+	return nil
+}
+
+func (t *Transition) escrowDecide(owner *Account, txn *EscrowDecideTxn) error {
+	e, ok := t.state.Escrow(txn.ID)
+	if !ok {
+		return fmt.Errorf("can not find escrow %v", txn.ID)
+	}
+
+	vote := escrowVoteRefund
+	if txn.Release {
+		vote = escrowVoteRelease
+	}
+
+	switch owner.PK().Addr() {
+	case e.Arbiter:
+		t.settleEscrow(txn.ID, e, txn.Release)
+		return nil
+	case e.Buyer:
+		e.BuyerVote = vote
+	case e.Seller.Addr():
+		e.SellerVote = vote
+	default:
+		return errors.New("only the buyer, the seller or the arbiter can decide the escrow")
+	}
+
+	if e.BuyerVote == e.SellerVote {
+		t.settleEscrow(txn.ID, e, txn.Release)
+		return nil
+	}
+
+	t.state.UpdateEscrow(txn.ID, e)
+	return nil
+}
+
+// settleEscrow releases the escrowed tokens to the seller, or
+// refunds them to the buyer, before the refund round.
+func (t *Transition) settleEscrow(id consensus.Hash, e Escrow, release bool) {
+	buyer := t.state.Account(e.Buyer)
+	b := buyer.Balance(e.TokenID)
+	removeIdx := -1
+	for i, f := range b.Frozen {
+		if f.AvailableRound == e.RefundRound && f.Quant == e.Quant {
+			removeIdx = i
+			break
+		}
+	}
+	if removeIdx < 0 {
+		panic(fmt.Errorf("can not find the frozen tokens of escrow %v", id))
+	}
+	b.Frozen = append(b.Frozen[:removeIdx], b.Frozen[removeIdx+1:]...)
+	t.state.UnfreezeToken(e.RefundRound, freezeToken{Addr: e.Buyer, TokenID: e.TokenID, Quant: e.Quant})
+
+	if release {
+		buyer.UpdateBalance(e.TokenID, b)
+		sellerAcc := t.state.Account(e.Seller.Addr())
+		if sellerAcc == nil {
+			sellerAcc = t.state.NewAccount(e.Seller)
+		}
+		credit(sellerAcc, e.TokenID, e.Quant)
+	} else {
+		b.Available += e.Quant
+		buyer.UpdateBalance(e.TokenID, b)
+	}
+
+	t.state.RemoveEscrow(id)
+	t.state.RemoveEscrowExpiration(e.RefundRound-1, id)
+}
+
+// expireEscrows removes the escrows whose tokens are refunded in the
+// current round.
+func (t *Transition) expireEscrows() {
+	ids := t.state.EscrowExpirations(t.round)
+	if len(ids) == 0 {
+		return
+	}
+
+	t.state.RemoveEscrowExpirations(t.round)
+	for _, id := range ids {
+		t.logger().Debug("escrow expired", "id", id)
+		t.state.RemoveEscrow(id)
+	}
+}
@@ -0,0 +1,68 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscrow(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pkBuyer, skBuyer := RandKeyPair()
+	pkSeller, skSeller := RandKeyPair()
+	pkArbiter, skArbiter := RandKeyPair()
+	s.NewAccount(pkBuyer).UpdateBalance(1, Balance{Available: 300})
+	s.NewAccount(pkSeller)
+	s.NewAccount(pkArbiter)
+	buyer, seller, arbiter := pkBuyer.Addr(), pkSeller.Addr(), pkArbiter.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{buyer: pkBuyer, seller: pkSeller, arbiter: pkArbiter}}
+
+	open := EscrowOpenTxn{Seller: pkSeller, Arbiter: arbiter, TokenID: 1, Quant: 100, RefundRound: 10}
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.NotNil(t, recordTxn(t, trans, MakeEscrowOpenTxn(skBuyer, buyer, EscrowOpenTxn{Seller: pkSeller, Arbiter: seller, TokenID: 1, Quant: 100, RefundRound: 10}, 0), pker), "seller is the arbiter")
+	assert.NotNil(t, recordTxn(t, trans, MakeEscrowOpenTxn(skBuyer, buyer, EscrowOpenTxn{Seller: pkSeller, Arbiter: arbiter, TokenID: 1, Quant: 400, RefundRound: 10}, 0), pker), "insufficient balance")
+	assert.Nil(t, recordTxn(t, trans, MakeEscrowOpenTxn(skBuyer, buyer, open, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeEscrowOpenTxn(skBuyer, buyer, open, 1), pker))
+	open.RefundRound = 3
+	assert.Nil(t, recordTxn(t, trans, MakeEscrowOpenTxn(skBuyer, buyer, open, 2), pker))
+	s = trans.Commit().(*State)
+	b := s.Account(buyer).Balance(1)
+	assert.Equal(t, 0, int(b.Available))
+	assert.Equal(t, []Frozen{{AvailableRound: 10, Quant: 100}, {AvailableRound: 10, Quant: 100}, {AvailableRound: 3, Quant: 100}}, b.Frozen)
+
+	released, refunded, expiring := EscrowID(buyer, 0), EscrowID(buyer, 1), EscrowID(buyer, 2)
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeEscrowDecideTxn(skBuyer, buyer, EscrowDecideTxn{ID: released, Release: true}, 3), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeEscrowDecideTxn(skSeller, seller, EscrowDecideTxn{ID: refunded, Release: true}, 0), pker))
+	assert.NotNil(t, recordTxn(t, trans, MakeEscrowDecideTxn(skArbiter, arbiter, EscrowDecideTxn{ID: EscrowID(buyer, 3)}, 0), pker), "escrow does not exist")
+	s = trans.Commit().(*State)
+	e, ok := s.Escrow(released)
+	assert.True(t, ok, "waiting for the seller")
+	assert.Equal(t, escrowVoteRelease, e.BuyerVote)
+	// the escrow expiring at round 3 is refunded at the end of
+	// round 2.
+	_, ok = s.Escrow(expiring)
+	assert.False(t, ok)
+	b = s.Account(buyer).Balance(1)
+	assert.Equal(t, 100, int(b.Available))
+	assert.Equal(t, 2, len(b.Frozen))
+
+	trans = s.Transition(3, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeEscrowDecideTxn(skSeller, seller, EscrowDecideTxn{ID: released, Release: true}, 1), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeEscrowDecideTxn(skArbiter, arbiter, EscrowDecideTxn{ID: refunded}, 0), pker))
+	s = trans.Commit().(*State)
+	_, ok = s.Escrow(released)
+	assert.False(t, ok)
+	_, ok = s.Escrow(refunded)
+	assert.False(t, ok)
+	assert.Equal(t, 100, int(s.Account(seller).Balance(1).Available))
+	b = s.Account(buyer).Balance(1)
+	assert.Equal(t, 200, int(b.Available))
+	assert.Empty(t, b.Frozen)
+	assert.Empty(t, s.GetFreezeTokens(10))
+	assert.Empty(t, s.EscrowExpirations(9))
+}
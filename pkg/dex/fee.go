@@ -0,0 +1,341 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// feeRateDenominator is the denominator of the trading fee rates,
+// the rates are in parts per million.
+const feeRateDenominator = 1000000
+
+// volumeRounds is the number of recent rounds that the traded
+// volume of an account is summed over for the fee tiers.
+const volumeRounds = 30
+
+// FeeSchedule is the governor's schedule of the trading fees. The
+// taker of a trade pays the fee in the token it receives, a share of
+// the fee is credited to the taker's referrer, and a rebate is paid
+// to the maker according to its traded volume. The maker pays the
+// maker fee in the token it receives. The rest goes to the fee pool.
+// No fee is charged when the schedule is not set.
+type FeeSchedule struct {
+	// TakerFee is the rate of the taker's proceeds charged as
+	// the fee.
+	TakerFee uint64
+	// MakerFee is the rate of the maker's proceeds charged as
+	// the fee.
+	MakerFee uint64
+	// Tiers discount the fee rates of the accounts by their
+	// traded volume, sorted by MinVolume in ascending order.
+	Tiers []FeeTier
+	// ReferralShare is the percentage of the fee credited to the
+	// taker's referrer.
+	ReferralShare uint64
+	// RebateTiers are sorted by MinVolume in ascending order.
+	RebateTiers []RebateTier
+}
+
+// FeeTier is the fee rates of the accounts whose traded volume in
+// USD over the recent volumeRounds rounds reaches MinVolume.
+type FeeTier struct {
+	MinVolume uint64
+	TakerFee  uint64
+	MakerFee  uint64
+}
+
+// RebateTier is the maker rebate rate of the taker's proceeds for the
+// makers whose traded volume in USD over the recent volumeRounds
+// rounds reaches MinVolume.
+type RebateTier struct {
+	MinVolume uint64
+	Rebate    uint64
+}
+
+// rates returns the taker and maker fee rates for the traded
+// volume.
+func (f *FeeSchedule) rates(volume uint64) (taker, maker uint64) {
+	taker, maker = f.TakerFee, f.MakerFee
+	for _, tier := range f.Tiers {
+		if volume < tier.MinVolume {
+			break
+		}
+		taker, maker = tier.TakerFee, tier.MakerFee
+	}
+	return
+}
+
+// rebate returns the maker rebate rate for the traded volume.
+func (f *FeeSchedule) rebate(volume uint64) uint64 {
+	var rate uint64
+	for _, tier := range f.RebateTiers {
+		if volume < tier.MinVolume {
+			break
+		}
+		rate = tier.Rebate
+	}
+	return rate
+}
+
+// VolumeSample is the traded volume of an account in a round.
+type VolumeSample struct {
+	Round  uint64
+	Volume uint64
+}
+
+// RollingVolume is the traded volume of an account in USD with
+// OrderPriceDecimals decimals over the recent volumeRounds rounds.
+type RollingVolume struct {
+	Samples []VolumeSample
+}
+
+// Total returns the traded volume of the volumeRounds rounds up to
+// the round.
+func (v RollingVolume) Total(round uint64) uint64 {
+	var total uint64
+	for _, s := range v.Samples {
+		if s.Round+volumeRounds > round {
+			total += s.Volume
+		}
+	}
+	return total
+}
+
+// add adds the volume traded in the round and drops the samples
+// older than volumeRounds rounds.
+func (v *RollingVolume) add(round, volume uint64) {
+	samples := v.Samples[:0]
+	for _, s := range v.Samples {
+		if s.Round+volumeRounds > round {
+			samples = append(samples, s)
+		}
+	}
+
+	if n := len(samples); n > 0 && samples[n-1].Round == round {
+		samples[n-1].Volume += volume
+	} else {
+		samples = append(samples, VolumeSample{Round: round, Volume: volume})
+	}
+	v.Samples = samples
+}
+
+func (f *FeeSchedule) valid() error {
+	if f.TakerFee >= feeRateDenominator {
+		return fmt.Errorf("taker fee rate %d should be less than %d", f.TakerFee, feeRateDenominator)
+	}
+
+	if f.MakerFee >= feeRateDenominator {
+		return fmt.Errorf("maker fee rate %d should be less than %d", f.MakerFee, feeRateDenominator)
+	}
+
+	for i, tier := range f.Tiers {
+		if i > 0 && tier.MinVolume <= f.Tiers[i-1].MinVolume {
+			return errors.New("fee tiers should be sorted by min volume in ascending order")
+		}
+
+		if tier.TakerFee >= feeRateDenominator || tier.MakerFee >= feeRateDenominator {
+			return fmt.Errorf("fee tier rates should be less than %d", feeRateDenominator)
+		}
+	}
+
+	if f.ReferralShare > 100 {
+		return fmt.Errorf("referral share %d should not be greater than 100", f.ReferralShare)
+	}
+
+	for i, tier := range f.RebateTiers {
+		if i > 0 && tier.MinVolume <= f.RebateTiers[i-1].MinVolume {
+			return errors.New("rebate tiers should be sorted by min volume in ascending order")
+		}
+
+		if tier.Rebate >= feeRateDenominator {
+			return fmt.Errorf("rebate rate %d should be less than %d", tier.Rebate, feeRateDenominator)
+		}
+	}
+
+	return nil
+}
+
+var feePoolPK = PK("fee pool")
+
+// FeePoolAddr returns the address of the account that collects the
+// trading fees.
+func FeePoolAddr() consensus.Addr {
+	return feePoolPK.Addr()
+}
+
+func (t *Transition) setFeeSchedule(owner *Account, txn *SetFeeScheduleTxn) error {
+	if err := t.checkGovernor(owner); err != nil {
+		return err
+	}
+
+	if err := txn.Schedule.valid(); err != nil {
+		return err
+	}
+
+	t.state.UpdateFeeSchedule(txn.Schedule)
+	return nil
+}
+
+func (t *Transition) registerReferrer(owner *Account, txn *RegisterReferrerTxn) error {
+	addr := owner.PK().Addr()
+	if txn.Referrer == addr {
+		return errors.New("can not refer oneself")
+	}
+
+	if _, ok := t.state.Referrer(addr); ok {
+		return errors.New("referrer is already registered")
+	}
+
+	if t.state.Account(txn.Referrer) == nil {
+		return fmt.Errorf("referrer account %v does not exist", txn.Referrer)
+	}
+
+	t.state.UpdateReferrer(addr, txn.Referrer)
+	return nil
+}
+
+// chargeFees charges the fees of the trades, and records the traded
+// volume of the accounts. Each taker execution is followed by the
+// execution of its maker. In an auction, the newer order of each
+// matched pair is the taker.
+func (t *Transition) chargeFees(market MarketSymbol, executions []orderExecution, baseInfo, quoteInfo TokenInfo) {
+	schedule := t.state.FeeSchedule()
+	volumes := make([]uint64, len(executions))
+	for i, exec := range executions {
+		volumes[i] = t.state.TradedVolume(exec.Owner).Total(t.round)
+	}
+
+	for i, exec := range executions {
+		quoteQuant := calcQuoteQuant(exec.Quant, quoteInfo.Decimals, exec.Price, OrderPriceDecimals, baseInfo.Decimals)
+		id, proceeds := market.Quote, quoteQuant
+		if !exec.SellSide {
+			id, proceeds = market.Base, exec.Quant
+		}
+
+		takerRate, makerRate := schedule.rates(volumes[i])
+		if exec.Taker {
+			var maker consensus.Addr
+			var makerVolume uint64
+			if i+1 < len(executions) {
+				maker = executions[i+1].Owner
+				makerVolume = volumes[i+1]
+			}
+
+			t.chargeTakerFee(&schedule, takerRate, exec.Owner, maker, makerVolume, id, proceeds)
+		} else if fee := mulDiv(proceeds, makerRate, feeRateDenominator); fee > 0 {
+			acc := t.state.Account(exec.Owner)
+			b := acc.Balance(id)
+			b.Available -= fee
+			acc.UpdateBalance(id, b)
+			credit(t.feePool(), id, fee)
+		}
+
+		if volume := t.usdValue(market.Quote, quoteQuant); volume > 0 {
+			v := t.state.TradedVolume(exec.Owner)
+			v.add(t.round, volume)
+			t.state.UpdateTradedVolume(exec.Owner, v)
+		}
+	}
+}
+
+func (t *Transition) chargeTakerFee(schedule *FeeSchedule, rate uint64, taker, maker consensus.Addr, makerVolume uint64, id TokenID, proceeds uint64) {
+	fee := mulDiv(proceeds, rate, feeRateDenominator)
+	if fee == 0 {
+		return
+	}
+
+	takerAcc := t.state.Account(taker)
+	b := takerAcc.Balance(id)
+	b.Available -= fee
+	takerAcc.UpdateBalance(id, b)
+
+	rest := fee
+	if referrer, ok := t.state.Referrer(taker); ok {
+		share := fee * schedule.ReferralShare / 100
+		if acc := t.state.Account(referrer); acc != nil && share > 0 {
+			credit(acc, id, share)
+			rest -= share
+		}
+	}
+
+	if rate := schedule.rebate(makerVolume); rate > 0 {
+		rebate := mulDiv(proceeds, rate, feeRateDenominator)
+		if rebate > rest {
+			rebate = rest
+		}
+
+		if rebate > 0 {
+			credit(t.state.Account(maker), id, rebate)
+			rest -= rebate
+		}
+	}
+
+	if rest > 0 {
+		credit(t.feePool(), id, rest)
+	}
+}
+
+// txnFee returns the token and the quantity of the flat txn fee that
+// the account pays. The fee is paid in the native token. An order txn
+// of an account without sufficient native token pays the fee
+// converted from the market's quote token at the reference price.
+func (t *Transition) txnFee(acc *Account, decoded interface{}) (TokenID, uint64, error) {
+	errInsufficient := txnErrorf(ErrCodeInsufficientBalance, "account don't have sufficient balance to pay fee")
+	if acc.Balance(0).Available >= flatFee {
+		return 0, flatFee, nil
+	}
+
+	var quote TokenID
+	switch tx := decoded.(type) {
+	case *PlaceOrderTxn:
+		quote = tx.Market.Quote
+	case *CancelOrderTxn:
+		quote = tx.ID.Market.Quote
+	default:
+		return 0, 0, errInsufficient
+	}
+
+	fee := t.convertFee(quote)
+	if fee == 0 || acc.Balance(quote).Available < fee {
+		return 0, 0, errInsufficient
+	}
+
+	return quote, fee, nil
+}
+
+// convertFee returns the flat txn fee in the token at the reference
+// price of the token's market with the native token, 0 if the token
+// has no such market or the market has no reference price.
+func (t *Transition) convertFee(id TokenID) uint64 {
+	if id == 0 {
+		return 0
+	}
+
+	info := t.tokenCache.Info(id)
+	if p, ok := t.state.RefPrice(MarketSymbol{Base: 0, Quote: id}); ok && p.Price > 0 {
+		return calcQuoteQuant(flatFee, info.Decimals, p.Price, OrderPriceDecimals, BNBInfo.Decimals)
+	}
+
+	if p, ok := t.state.RefPrice(MarketSymbol{Base: id, Quote: 0}); ok && p.Price > 0 {
+		return calcBaseQuant(flatFee, BNBInfo.Decimals, p.Price, OrderPriceDecimals, info.Decimals)
+	}
+
+	return 0
+}
+
+func (t *Transition) feePool() *Account {
+	pool := t.state.Account(FeePoolAddr())
+	if pool == nil {
+		pool = t.state.NewAccount(feePoolPK)
+	}
+	return pool
+}
+
+// credit adds the quantity to the account's available balance.
+func credit(acc *Account, id TokenID, quant uint64) {
+	b := acc.Balance(id)
+	b.Available += quant
+	acc.UpdateBalance(id, b)
+}
@@ -0,0 +1,198 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeeSchedule(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	one := uint64(math.Pow10(OrderPriceDecimals))
+	s.UpdateOraclePrice(1, OraclePrice{Price: one})
+	market := MarketSymbol{Base: 0, Quote: 1}
+	pkGov, skGov := RandKeyPair()
+	pkMaker, skMaker := RandKeyPair()
+	pkTaker, skTaker := RandKeyPair()
+	pkReferrer, _ := RandKeyPair()
+	s.NewAccount(pkGov)
+	s.NewAccount(pkReferrer)
+	s.NewAccount(pkMaker).UpdateBalance(0, Balance{Available: 2000000})
+	s.NewAccount(pkTaker).UpdateBalance(1, Balance{Available: 2000000})
+	s.UpdateGovernor(pkGov.Addr())
+	pker := &myPKer{m: map[consensus.Addr]PK{
+		pkGov.Addr():   pkGov,
+		pkMaker.Addr(): pkMaker,
+		pkTaker.Addr(): pkTaker,
+	}}
+	maker := pkMaker.Addr()
+	taker := pkTaker.Addr()
+
+	schedule := FeeSchedule{
+		TakerFee:      1000,
+		ReferralShare: 20,
+		RebateTiers:   []RebateTier{{MinVolume: 1000000, Rebate: 200}},
+	}
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.NotNil(t, recordTxn(t, trans, MakeSetFeeScheduleTxn(skMaker, maker, SetFeeScheduleTxn{Schedule: schedule}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeSetFeeScheduleTxn(skGov, pkGov.Addr(), SetFeeScheduleTxn{Schedule: schedule}, 0), pker))
+	assert.NotNil(t, recordTxn(t, trans, MakeRegisterReferrerTxn(skTaker, taker, RegisterReferrerTxn{Referrer: taker}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeRegisterReferrerTxn(skTaker, taker, RegisterReferrerTxn{Referrer: pkReferrer.Addr()}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skMaker, maker, PlaceOrderTxn{SellSide: true, Quant: 1000000, Price: one, Market: market}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skTaker, taker, PlaceOrderTxn{Quant: 1000000, Price: one, Market: market}, 1), pker))
+	s = trans.Commit().(*State)
+
+	// the taker pays 1000 in the base token, 200 goes to the
+	// referrer, the maker has no volume for the rebate.
+	assert.Equal(t, 999000, int(s.Account(taker).Balance(0).Available))
+	assert.Equal(t, 200, int(s.Account(pkReferrer.Addr()).Balance(0).Available))
+	assert.Equal(t, 800, int(s.Account(FeePoolAddr()).Balance(0).Available))
+	assert.Equal(t, 1000000, int(s.Account(maker).Balance(1).Available))
+	assert.Equal(t, 1000000, int(s.TradedVolume(maker).Total(1)))
+	assert.Equal(t, 1000000, int(s.TradedVolume(taker).Total(1)))
+
+	// the maker reaches the rebate tier.
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skMaker, maker, PlaceOrderTxn{SellSide: true, Quant: 1000000, Price: one, Market: market}, 1), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skTaker, taker, PlaceOrderTxn{Quant: 1000000, Price: one, Market: market}, 2), pker))
+	s = trans.Commit().(*State)
+
+	assert.Equal(t, 1998000, int(s.Account(taker).Balance(0).Available))
+	assert.Equal(t, 400, int(s.Account(pkReferrer.Addr()).Balance(0).Available))
+	assert.Equal(t, 200, int(s.Account(maker).Balance(0).Available))
+	assert.Equal(t, 1400, int(s.Account(FeePoolAddr()).Balance(0).Available))
+}
+
+func TestRollingVolume(t *testing.T) {
+	var v RollingVolume
+	v.add(1, 10)
+	v.add(1, 5)
+	v.add(2, 20)
+	assert.Equal(t, 35, int(v.Total(2)))
+	assert.Equal(t, 20, int(v.Total(volumeRounds+1)))
+	v.add(volumeRounds+1, 1)
+	assert.Equal(t, []VolumeSample{{Round: 2, Volume: 20}, {Round: volumeRounds + 1, Volume: 1}}, v.Samples)
+}
+
+func TestFeeTiers(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	one := uint64(math.Pow10(OrderPriceDecimals))
+	s.UpdateOraclePrice(1, OraclePrice{Price: one})
+	s.UpdateFeeSchedule(FeeSchedule{
+		TakerFee: 1000,
+		MakerFee: 500,
+		Tiers:    []FeeTier{{MinVolume: 1000000, TakerFee: 500}},
+	})
+	market := MarketSymbol{Base: 0, Quote: 1}
+	pkMaker, skMaker := RandKeyPair()
+	pkTaker, skTaker := RandKeyPair()
+	s.NewAccount(pkMaker).UpdateBalance(0, Balance{Available: 3000000})
+	s.NewAccount(pkTaker).UpdateBalance(1, Balance{Available: 3000000})
+	pker := &myPKer{m: map[consensus.Addr]PK{
+		pkMaker.Addr(): pkMaker,
+		pkTaker.Addr(): pkTaker,
+	}}
+	maker := pkMaker.Addr()
+	taker := pkTaker.Addr()
+
+	var nonce uint64
+	trade := func(round uint64) {
+		trans := s.Transition(round, 0, nil).(*Transition)
+		assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skMaker, maker, PlaceOrderTxn{SellSide: true, Quant: 1000000, Price: one, Market: market}, nonce), pker))
+		assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skTaker, taker, PlaceOrderTxn{Quant: 1000000, Price: one, Market: market}, nonce), pker))
+		nonce++
+		s = trans.Commit().(*State)
+	}
+
+	trade(1)
+	assert.Equal(t, 999000, int(s.Account(taker).Balance(0).Available))
+	assert.Equal(t, 999500, int(s.Account(maker).Balance(1).Available))
+
+	// both reach the discounted tier.
+	trade(2)
+	assert.Equal(t, 1998500, int(s.Account(taker).Balance(0).Available))
+	assert.Equal(t, 1999500, int(s.Account(maker).Balance(1).Available))
+
+	// the volume of the first two rounds rolls out of the window.
+	trade(2 + volumeRounds)
+	assert.Equal(t, 2997500, int(s.Account(taker).Balance(0).Available))
+	assert.Equal(t, 2999000, int(s.Account(maker).Balance(1).Available))
+	assert.Equal(t, 1000000, int(s.TradedVolume(taker).Total(2+volumeRounds)))
+}
+
+func TestConvertedTxnFee(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	one := uint64(math.Pow10(OrderPriceDecimals))
+	market := MarketSymbol{Base: 0, Quote: 1}
+	s.UpdateRefPrice(market, RefPrice{Price: 2 * one})
+	pkProposer, _ := RandKeyPair()
+	pk, sk := RandKeyPair()
+	pkTo, _ := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(1, Balance{Available: 1000000})
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	addr := pk.Addr()
+
+	trans := s.Transition(1, 0, pkProposer).(*Transition)
+	// only the order txns could pay the fee in the quote token.
+	err := recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkTo, 1, 10, 0), pker)
+	assert.Contains(t, err.Error(), "sufficient balance to pay fee")
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{Quant: 100, Price: one, Market: market}, 0), pker))
+	s = trans.Commit().(*State)
+
+	fee := 2 * flatFee
+	acc := s.Account(addr)
+	assert.Equal(t, 1000000-fee-100, acc.Balance(1).Available)
+	assert.Equal(t, fee, s.Account(pkProposer.Addr()).Balance(1).Available)
+}
+
+func TestReplayMinerFee(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	one := uint64(math.Pow10(OrderPriceDecimals))
+	market := MarketSymbol{Base: 0, Quote: 1}
+	s.UpdateRefPrice(market, RefPrice{Price: 2 * one})
+	pkProposer, _ := RandKeyPair()
+	pk, sk := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(1, Balance{Available: 1000000})
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	order := MakePlaceOrderTxn(sk, pk.Addr(), PlaceOrderTxn{Quant: 100, Price: one, Market: market}, 0)
+
+	trans := s.Transition(1, 0, pkProposer).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, order, pker))
+	var txns [][]byte
+	assert.Nil(t, rlp.DecodeBytes(trans.Txns(), &txns))
+	assert.Equal(t, 2, len(txns))
+	proposed := trans.Commit().(*State)
+
+	replay := func(feeTxn []byte) (*Transition, error) {
+		trans := s.Transition(1, 0, nil).(*Transition)
+		_, err := trans.RecordSerialized(encodeTxns([][]byte{order, feeTxn}), NewTxnPool(pker), fuzzSeed)
+		return trans, err
+	}
+
+	trans, err := replay(txns[1])
+	assert.Nil(t, err)
+	assert.Equal(t, proposed.Hash(), trans.Commit().(*State).Hash())
+
+	// the fees paid to the proposer must match the collected
+	// fees.
+	for _, f := range []MinerFeeTxn{
+		{Miner: pkProposer, Converted: []ConvertedFee{{ID: 1, Quant: 2*flatFee + 1}}},
+		{Miner: pkProposer, Converted: []ConvertedFee{{ID: 1, Quant: 2 * flatFee}, {ID: 2, Quant: 1}}},
+		{Miner: pkProposer, Fee: 1, Converted: []ConvertedFee{{ID: 1, Quant: 2 * flatFee}}},
+	} {
+		_, err = replay(rlpEncode(Txn{T: MinerFee, Data: rlpEncode(f)}))
+		assert.NotNil(t, err)
+	}
+}
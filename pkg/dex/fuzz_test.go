@@ -0,0 +1,205 @@
+package dex
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+const (
+	fuzzAccounts = 3
+	fuzzFund     = 1000000
+	// fuzzOpSize is the number of the input bytes consumed by a
+	// fuzzed txn.
+	fuzzOpSize = 8
+	fuzzMaxOps = 64
+)
+
+var fuzzSeed = consensus.Rand{1}
+
+type fuzzKeys struct {
+	pks  []PK
+	sks  []SK
+	pker *myPKer
+}
+
+func newFuzzKeys() *fuzzKeys {
+	k := &fuzzKeys{pker: &myPKer{m: make(map[consensus.Addr]PK)}}
+	for i := 0; i < fuzzAccounts; i++ {
+		pk, sk := RandKeyPair()
+		k.pks = append(k.pks, pk)
+		k.sks = append(k.sks, sk)
+		k.pker.m[pk.Addr()] = pk
+	}
+	return k
+}
+
+// state returns a state with two tokens evenly distributed to the
+// accounts.
+func (k *fuzzKeys) state() *State {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: TokenInfo{Symbol: "BNB", Decimals: 8, TotalUnits: fuzzAccounts * fuzzFund}})
+	s.UpdateToken(Token{ID: 1, TokenInfo: TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: fuzzAccounts * fuzzFund}})
+	for _, pk := range k.pks {
+		acc := s.NewAccount(pk)
+		acc.UpdateBalance(0, Balance{Available: fuzzFund})
+		acc.UpdateBalance(1, Balance{Available: fuzzFund})
+	}
+	s.CommitCache()
+	return s
+}
+
+// checkConservation checks that the balances of each token held by
+// the accounts and the collected fees sum up to the token's total
+// units.
+func checkConservation(s *State, pks []PK, fees map[TokenID]uint64) error {
+	for _, token := range s.Tokens() {
+		sum := fees[token.ID]
+		for _, pk := range pks {
+			b := s.Account(pk.Addr()).Balance(token.ID)
+			sum += b.Available + b.Pending
+			for _, f := range b.Frozen {
+				sum += f.Quant
+			}
+		}
+
+		if sum != token.TotalUnits {
+			return fmt.Errorf("token %s is not conserved, total units: %d, sum of balances: %d", token.Symbol, token.TotalUnits, sum)
+		}
+	}
+	return nil
+}
+
+func encodeTxns(txns [][]byte) []byte {
+	b, err := rlp.EncodeToBytes(txns)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// FuzzRecordSerialized feeds arbitrary bytes as the serialized txns
+// of a block.
+func FuzzRecordSerialized(f *testing.F) {
+	k := newFuzzKeys()
+	addr := k.pks[0].Addr()
+	f.Add([]byte{})
+	f.Add(encodeTxns(nil))
+	f.Add(encodeTxns([][]byte{MakeSendTokenTxn(k.sks[0], addr, k.pks[1], 0, 20, 0)}))
+	f.Add(encodeTxns([][]byte{MakePlaceOrderTxn(k.sks[0], addr, PlaceOrderTxn{Quant: 10, Price: 1, Market: MarketSymbol{Quote: 1, Base: 0}}, 0)}))
+	f.Add(encodeTxns([][]byte{MakeCancelOrderTxn(k.sks[0], addr, OrderID{}, 0), {0xc0}, {}}))
+
+	f.Fuzz(func(t *testing.T, blob []byte) {
+		s := k.state()
+		trans := s.Transition(1, 0, nil).(*Transition)
+		_, err := trans.RecordSerialized(blob, NewTxnPool(k.pker), fuzzSeed)
+		if err != nil {
+			return
+		}
+
+		fees := map[TokenID]uint64{0: trans.fee}
+		for id, fee := range trans.convertedFees {
+			fees[id] += fee
+		}
+		if err := checkConservation(trans.state, k.pks, fees); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// FuzzTransitionRecord records a sequence of valid-looking txns
+// derived from the input, every fuzzOpSize bytes is a txn.
+func FuzzTransitionRecord(f *testing.F) {
+	k := newFuzzKeys()
+	f.Add([]byte{})
+	f.Add([]byte{0, 0, 1, 0, 0, 10, 0, 0})
+	f.Add([]byte{
+		1, 0, 0, 0, 0, 40, 0, 2,
+		1, 1, 1, 0, 0, 55, 0, 2,
+		2, 0, 0, 0, 0, 0, 0, 0,
+	})
+	f.Add([]byte{
+		3, 0, 0, 0, 0, 50, 0, 3,
+		4, 1, 0, 1, 0, 99, 0, 0,
+		5, 2, 0, 0, 1, 0, 0, 0,
+	})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s := k.state()
+		nonces := make([]uint64, fuzzAccounts)
+		trans := s.Transition(1, 0, nil).(*Transition)
+		for i := 0; i+fuzzOpSize <= len(data) && i < fuzzMaxOps*fuzzOpSize; i += fuzzOpSize {
+			op := data[i : i+fuzzOpSize]
+			from := int(op[1]) % fuzzAccounts
+			b := k.makeTxn(trans.state, op, from, nonces[from])
+			if b == nil {
+				continue
+			}
+
+			txn, err := parseTxn(b, k.pker)
+			if err != nil {
+				t.Fatalf("error parse txn: %v", err)
+			}
+
+			if trans.Record(txn) == nil {
+				nonces[from]++
+			}
+		}
+
+		if err := checkConservation(trans.state, k.pks, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		s = trans.Commit().(*State)
+		if err := checkConservation(s, k.pks, nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// makeTxn returns the txn of the fuzzed op, or nil if the op does
+// not make a txn.
+func (k *fuzzKeys) makeTxn(s *State, op []byte, from int, nonce uint64) []byte {
+	sk := k.sks[from]
+	addr := k.pks[from].Addr()
+	to := k.pks[int(op[2])%fuzzAccounts]
+	token := TokenID(op[3] % 3)
+	quant := uint64(binary.BigEndian.Uint16(op[4:6]))
+	price := uint64(binary.BigEndian.Uint16(op[6:8])) * uint64(math.Pow10(OrderPriceDecimals-2))
+	switch op[0] % 7 {
+	case 0:
+		return MakeSendTokenTxn(sk, addr, to, token, quant, nonce)
+	case 1:
+		order := PlaceOrderTxn{
+			SellSide: op[2]%2 == 1,
+			Quant:    quant,
+			Price:    price,
+			Market:   MarketSymbol{Quote: 1, Base: 0},
+		}
+		return MakePlaceOrderTxn(sk, addr, order, nonce)
+	case 2:
+		orders := s.Account(addr).PendingOrders()
+		if len(orders) == 0 {
+			return nil
+		}
+		return MakeCancelOrderTxn(sk, addr, orders[int(op[2])%len(orders)].ID, nonce)
+	case 3:
+		return MakeFreezeTokenTxn(sk, addr, FreezeTokenTxn{TokenID: token, AvailableRound: uint64(op[2]), Quant: quant}, nonce)
+	case 4:
+		return MakeBurnTokenTxn(sk, addr, BurnTokenTxn{ID: token, Quant: quant}, nonce)
+	case 5:
+		orders := s.Account(addr).PendingOrders()
+		if len(orders) == 0 {
+			return nil
+		}
+		return MakeReduceOrderTxn(sk, addr, ReduceOrderTxn{ID: orders[int(op[2])%len(orders)].ID, Quant: quant}, nonce)
+	default:
+		info := TokenInfo{Symbol: TokenSymbol(fmt.Sprintf("T%d", op[2])), Decimals: op[3] % 10, TotalUnits: quant}
+		return MakeIssueTokenTxn(sk, addr, info, nonce)
+	}
+}
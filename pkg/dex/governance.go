@@ -0,0 +1,45 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The governor is the account set in the genesis state that
+// configures the chain wide parameters, governance is disabled if
+// the governor is not set.
+
+func (t *Transition) checkGovernor(owner *Account) error {
+	g, ok := t.state.Governor()
+	if !ok {
+		return errors.New("governance is not enabled")
+	}
+
+	if owner.PK().Addr() != g {
+		return txnErrorf(ErrCodeUnauthorized, "only the governor can change the configuration")
+	}
+
+	return nil
+}
+
+// RoundIntervalBounds is the bounds of the round interval in
+// milliseconds. The nodes adjust the round interval within the
+// bounds based on the measured notarization latency.
+type RoundIntervalBounds struct {
+	Min uint64
+	Max uint64
+}
+
+func (t *Transition) setRoundInterval(owner *Account, txn *SetRoundIntervalTxn) error {
+	if err := t.checkGovernor(owner); err != nil {
+		return err
+	}
+
+	b := txn.Bounds
+	if b.Min == 0 || b.Min > b.Max {
+		return fmt.Errorf("invalid round interval bounds: %d - %d ms", b.Min, b.Max)
+	}
+
+	t.state.UpdateRoundIntervalBounds(b)
+	return nil
+}
@@ -0,0 +1,36 @@
+package dex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetRoundInterval(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pkGov, skGov := RandKeyPair()
+	pk, sk := RandKeyPair()
+	s.NewAccount(pkGov)
+	s.NewAccount(pk)
+	s.UpdateGovernor(pkGov.Addr())
+	pker := &myPKer{m: map[consensus.Addr]PK{pkGov.Addr(): pkGov, pk.Addr(): pk}}
+	_, _, ok := s.RoundIntervalBounds()
+	assert.False(t, ok)
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	bounds := RoundIntervalBounds{Min: 200, Max: 2000}
+	assert.NotNil(t, recordTxn(t, trans, MakeSetRoundIntervalTxn(sk, pk.Addr(), SetRoundIntervalTxn{Bounds: bounds}, 0), pker))
+	assert.NotNil(t, recordTxn(t, trans, MakeSetRoundIntervalTxn(skGov, pkGov.Addr(), SetRoundIntervalTxn{Bounds: RoundIntervalBounds{Min: 300, Max: 200}}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeSetRoundIntervalTxn(skGov, pkGov.Addr(), SetRoundIntervalTxn{Bounds: bounds}, 0), pker))
+	s = trans.Commit().(*State)
+
+	min, max, ok := s.RoundIntervalBounds()
+	assert.True(t, ok)
+	assert.Equal(t, 200*time.Millisecond, min)
+	assert.Equal(t, 2*time.Second, max)
+	var _ consensus.RoundIntervalBounder = s
+}
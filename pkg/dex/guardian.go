@@ -0,0 +1,92 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// GuardianChangeDelay is the number of rounds before a change or
+// removal of an account's guardian applies, so that the guardian can
+// pull the kill switch before a leaked key replaces it.
+const GuardianChangeDelay = 20
+
+// Guardian is the address that can disable and re-enable an account
+// with its kill switch. Next replaces Addr from NextRound.
+type Guardian struct {
+	Addr      consensus.Addr
+	Next      consensus.Addr
+	NextRound uint64
+	Disabled  bool
+}
+
+// At returns the guardian address at the round.
+func (g Guardian) At(round uint64) consensus.Addr {
+	if g.NextRound > 0 && round >= g.NextRound {
+		return g.Next
+	}
+
+	return g.Addr
+}
+
+func (t *Transition) setGuardian(owner *Account, txn *SetGuardianTxn) error {
+	addr := owner.PK().Addr()
+	if txn.Guardian == addr {
+		return errors.New("account can not guard itself")
+	}
+
+	g := t.state.Guardian(addr)
+	cur := g.At(t.round)
+	g.Addr = cur
+	g.Next = consensus.Addr{}
+	g.NextRound = 0
+	if cur == (consensus.Addr{}) {
+		g.Addr = txn.Guardian
+	} else if txn.Guardian != cur {
+		g.Next = txn.Guardian
+		g.NextRound = t.round + GuardianChangeDelay
+	}
+
+	t.state.UpdateGuardian(addr, g)
+	return nil
+}
+
+func (t *Transition) killSwitch(owner *Account, txn *KillSwitchTxn) error {
+	acc := t.state.Account(txn.Account)
+	if acc == nil {
+		return fmt.Errorf("account not found: %v", txn.Account)
+	}
+
+	g := t.state.Guardian(txn.Account)
+	if g.At(t.round) != owner.PK().Addr() {
+		return txnErrorf(ErrCodeUnauthorized, "%v is not the guardian of account %v", owner.PK().Addr(), txn.Account)
+	}
+
+	if g.Disabled == txn.Disable {
+		return fmt.Errorf("account %v is already in the requested state, disabled: %t", txn.Account, g.Disabled)
+	}
+
+	if txn.Disable {
+		t.cancelAllOrders(acc, &CancelAllOrdersTxn{})
+		// drop the pending guardian change, it could be made
+		// with the leaked key.
+		g.Addr = g.At(t.round)
+		g.Next = consensus.Addr{}
+		g.NextRound = 0
+	}
+
+	g.Disabled = txn.Disable
+	t.state.UpdateGuardian(txn.Account, g)
+	return nil
+}
+
+// checkEnabled returns an error if the account is disabled by its
+// guardian.
+func (t *Transition) checkEnabled(addr consensus.Addr) error {
+	if t.state.Guardian(addr).Disabled {
+		return txnErrorf(ErrCodeUnauthorized, "account %v is disabled by its guardian", addr)
+	}
+
+	return nil
+}
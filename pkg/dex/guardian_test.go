@@ -0,0 +1,50 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKillSwitch(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	pkGuardian, skGuardian := RandKeyPair()
+	pkAttacker, _ := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(1, Balance{Available: 300})
+	s.NewAccount(pkGuardian)
+	addr, guardian := pk.Addr(), pkGuardian.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk, guardian: pkGuardian}}
+	m := MarketSymbol{Base: 1, Quote: 0}
+	price := uint64(math.Pow10(OrderPriceDecimals))
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.NotNil(t, recordTxn(t, trans, MakeKillSwitchTxn(skGuardian, guardian, KillSwitchTxn{Account: addr, Disable: true}, 0), pker), "not the guardian yet")
+	assert.Nil(t, recordTxn(t, trans, MakeSetGuardianTxn(sk, addr, SetGuardianTxn{Guardian: guardian}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 100, Price: price, Market: m}, 1), pker))
+	// the leaked key tries to replace the guardian, it is delayed.
+	assert.Nil(t, recordTxn(t, trans, MakeSetGuardianTxn(sk, addr, SetGuardianTxn{Guardian: pkAttacker.Addr()}, 2), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, guardian, s.Guardian(addr).At(2))
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeKillSwitchTxn(skGuardian, guardian, KillSwitchTxn{Account: addr, Disable: true}, 0), pker))
+	assert.NotNil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkAttacker, 1, 100, 3), pker), "disabled")
+	s = trans.Commit().(*State)
+	acc := s.Account(addr)
+	assert.Empty(t, acc.PendingOrders())
+	assert.Equal(t, 300, int(acc.Balance(1).Available))
+	assert.Equal(t, Guardian{Addr: guardian, Disabled: true}, s.Guardian(addr))
+
+	trans = s.Transition(2+GuardianChangeDelay, 0, nil).(*Transition)
+	assert.NotNil(t, recordTxn(t, trans, MakeKillSwitchTxn(skGuardian, guardian, KillSwitchTxn{Account: addr, Disable: true}, 1), pker), "already disabled")
+	assert.Nil(t, recordTxn(t, trans, MakeKillSwitchTxn(skGuardian, guardian, KillSwitchTxn{Account: addr}, 1), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkGuardian, 1, 100, 3), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, 100, int(s.Account(guardian).Balance(1).Available))
+}
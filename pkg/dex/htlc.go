@@ -0,0 +1,112 @@
+package dex
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// HTLC is a hash time locked contract. The hash lock uses SHA-256 so
+// that the same preimage unlocks the counterpart contracts on
+// Bitcoin and Ethereum for atomic cross-chain swaps.
+type HTLC struct {
+	Owner       consensus.Addr
+	To          PK
+	TokenID     TokenID
+	Quant       uint64
+	Hash        consensus.Hash
+	RefundRound uint64
+}
+
+// HTLCID returns the ID of the owner's hash time locked contract
+// with the hash lock.
+func HTLCID(owner consensus.Addr, hash consensus.Hash) consensus.Hash {
+	return consensus.SHA3(owner[:], hash[:])
+}
+
+func (t *Transition) htlcLock(owner *Account, txn *HTLCLockTxn) error {
+	if txn.Quant == 0 {
+		return errors.New("lock quantity is 0")
+	}
+
+	if txn.RefundRound <= t.round {
+		return fmt.Errorf("refund round %d should be after the current round %d", txn.RefundRound, t.round)
+	}
+
+	addr := owner.PK().Addr()
+	id := HTLCID(addr, txn.Hash)
+	if _, ok := t.state.HTLC(id); ok {
+		return fmt.Errorf("hash time locked contract %v already exists", id)
+	}
+
+	b := owner.Balance(txn.TokenID)
+	if b.Available < txn.Quant {
+		return txnErrorf(ErrCodeInsufficientBalance, "insufficient available token balance, tokenID: %v, quant: %d, available: %d", txn.TokenID, txn.Quant, b.Available)
+	}
+
+	if err := t.checkTransferThreshold(addr, txn.TokenID, txn.Quant); err != nil {
+		return err
+	}
+
+	b.Available -= txn.Quant
+	owner.UpdateBalance(txn.TokenID, b)
+	t.addOutflow(addr, txn.TokenID, txn.Quant)
+	t.state.UpdateHTLC(id, HTLC{
+		Owner:       addr,
+		To:          txn.To,
+		TokenID:     txn.TokenID,
+		Quant:       txn.Quant,
+		Hash:        txn.Hash,
+		RefundRound: txn.RefundRound,
+	})
+	return nil
+}
+
+func (t *Transition) htlcClaim(owner *Account, txn *HTLCClaimTxn) error {
+	h, ok := t.state.HTLC(txn.ID)
+	if !ok {
+		return fmt.Errorf("can not find hash time locked contract %v", txn.ID)
+	}
+
+	if t.round >= h.RefundRound {
+		return txnErrorf(ErrCodeExpired, "hash time locked contract %v expired at round %d", txn.ID, h.RefundRound)
+	}
+
+	if consensus.Hash(sha256.Sum256(txn.Preimage)) != h.Hash {
+		return errors.New("preimage does not match the hash lock")
+	}
+
+	toAcc := t.state.Account(h.To.Addr())
+	if toAcc == nil {
+		toAcc = t.state.NewAccount(h.To)
+	}
+
+	b := toAcc.Balance(h.TokenID)
+	b.Available += h.Quant
+	toAcc.UpdateBalance(h.TokenID, b)
+	t.state.RemoveHTLC(txn.ID)
+	return nil
+}
+
+func (t *Transition) htlcRefund(owner *Account, txn *HTLCRefundTxn) error {
+	h, ok := t.state.HTLC(txn.ID)
+	if !ok {
+		return fmt.Errorf("can not find hash time locked contract %v", txn.ID)
+	}
+
+	if h.Owner != owner.PK().Addr() {
+		return errors.New("only the owner can refund the hash time locked contract")
+	}
+
+	if t.round < h.RefundRound {
+		return fmt.Errorf("hash time locked contract %v can not be refunded until round %d", txn.ID, h.RefundRound)
+	}
+
+	b := owner.Balance(h.TokenID)
+	b.Available += h.Quant
+	owner.UpdateBalance(h.TokenID, b)
+	t.state.RemoveHTLC(txn.ID)
+	return nil
+}
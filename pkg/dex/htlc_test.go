@@ -0,0 +1,65 @@
+package dex
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTLC(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	pkTo, skTo := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 100})
+	s.NewAccount(pkTo)
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk, pkTo.Addr(): pkTo}}
+	addr := pk.Addr()
+
+	preimage := []byte("secret")
+	hash := consensus.Hash(sha256.Sum256(preimage))
+	id := HTLCID(addr, hash)
+	trans := s.Transition(1, 0, nil).(*Transition)
+	lock := HTLCLockTxn{To: pkTo, TokenID: 0, Quant: 30, Hash: hash, RefundRound: 3}
+	assert.Nil(t, recordTxn(t, trans, MakeHTLCLockTxn(sk, addr, lock, 0), pker))
+	err := recordTxn(t, trans, MakeHTLCRefundTxn(sk, addr, HTLCRefundTxn{ID: id}, 1), pker)
+	assert.Contains(t, err.Error(), "can not be refunded")
+	err = recordTxn(t, trans, MakeHTLCClaimTxn(skTo, pkTo.Addr(), HTLCClaimTxn{ID: id, Preimage: []byte("wrong")}, 0), pker)
+	assert.Contains(t, err.Error(), "preimage")
+	assert.Nil(t, recordTxn(t, trans, MakeHTLCClaimTxn(skTo, pkTo.Addr(), HTLCClaimTxn{ID: id, Preimage: preimage}, 0), pker))
+	s = trans.Commit().(*State)
+
+	assert.Equal(t, 70, int(s.Account(addr).Balance(0).Available))
+	assert.Equal(t, 30, int(s.Account(pkTo.Addr()).Balance(0).Available))
+	_, ok := s.HTLC(id)
+	assert.False(t, ok)
+}
+
+func TestHTLCRefund(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	pkTo, skTo := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 100})
+	s.NewAccount(pkTo)
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk, pkTo.Addr(): pkTo}}
+	addr := pk.Addr()
+
+	preimage := []byte("secret")
+	hash := consensus.Hash(sha256.Sum256(preimage))
+	id := HTLCID(addr, hash)
+	trans := s.Transition(1, 0, nil).(*Transition)
+	lock := HTLCLockTxn{To: pkTo, TokenID: 0, Quant: 30, Hash: hash, RefundRound: 2}
+	assert.Nil(t, recordTxn(t, trans, MakeHTLCLockTxn(sk, addr, lock, 0), pker))
+	s = trans.Commit().(*State)
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	err := recordTxn(t, trans, MakeHTLCClaimTxn(skTo, pkTo.Addr(), HTLCClaimTxn{ID: id, Preimage: preimage}, 0), pker)
+	assert.Contains(t, err.Error(), "expired")
+	assert.Nil(t, recordTxn(t, trans, MakeHTLCRefundTxn(sk, addr, HTLCRefundTxn{ID: id}, 1), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, 100, int(s.Account(addr).Balance(0).Available))
+}
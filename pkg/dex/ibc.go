@@ -0,0 +1,481 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// The inter-chain communication module moves tokens between the DEX
+// and the other app chains. Each counterpart chain is tracked by a
+// light client that verifies its headers, a verified header provides
+// the root of the packet commitments of the counterpart chain at the
+// header's height. A channel connects the DEX to a counterpart
+// chain through a light client, the packets of a channel are
+// delivered in the order of their sequences.
+//
+// Sending tokens escrows them in the channel's escrow account (or
+// burns them if they are the vouchers of the channel), and commits
+// the packet into the root of the round that the counterpart
+// chain's light client of the DEX verifies. Receiving tokens
+// verifies the packet commitment against the counterpart chain's
+// root, and mints the vouchers (or releases the escrowed tokens if
+// they return home).
+
+// LightClient verifies the headers of a counterpart chain.
+type LightClient interface {
+	// Update verifies the header against the client state, it
+	// returns the updated client state, and the height and the
+	// packet commitment root of the header.
+	Update(state, header []byte) (newState []byte, height uint64, root consensus.Hash, err error)
+}
+
+var lightClients = map[string]LightClient{
+	GroupLightClientType: groupLightClient{},
+}
+
+// RegisterLightClient registers the light client of the client
+// type, it must be called before the chain starts.
+func RegisterLightClient(clientType string, c LightClient) {
+	lightClients[clientType] = c
+}
+
+// GroupLightClientType is the type of the light client of the
+// chains whose headers are signed by a threshold BLS group.
+const GroupLightClientType = "group"
+
+// GroupClientState is the state of the group light client.
+type GroupClientState struct {
+	GroupPK consensus.PK
+	Height  uint64
+}
+
+// GroupHeader is a header verified by the group light client.
+type GroupHeader struct {
+	Height uint64
+	Root   consensus.Hash
+	Sig    consensus.Sig
+}
+
+// GroupHeaderMsg returns the message that the counterpart chain's
+// group signs for the header.
+func GroupHeaderMsg(h GroupHeader) []byte {
+	h.Sig = nil
+	return groupMsg("ibc header", h)
+}
+
+type groupLightClient struct{}
+
+func (groupLightClient) Update(state, header []byte) ([]byte, uint64, consensus.Hash, error) {
+	var s GroupClientState
+	err := rlp.DecodeBytes(state, &s)
+	if err != nil {
+		return nil, 0, consensus.Hash{}, fmt.Errorf("error decoding client state: %v", err)
+	}
+
+	var h GroupHeader
+	err = rlp.DecodeBytes(header, &h)
+	if err != nil {
+		return nil, 0, consensus.Hash{}, fmt.Errorf("error decoding header: %v", err)
+	}
+
+	if h.Height <= s.Height {
+		return nil, 0, consensus.Hash{}, fmt.Errorf("header height %d should be higher than the client height %d", h.Height, s.Height)
+	}
+
+	if !h.Sig.Verify(s.GroupPK, GroupHeaderMsg(h)) {
+		return nil, 0, consensus.Hash{}, errors.New("invalid header signature")
+	}
+
+	s.Height = h.Height
+	b, err := rlp.EncodeToBytes(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return b, h.Height, h.Root, nil
+}
+
+// IBCClient is a light client of a counterpart chain.
+type IBCClient struct {
+	ID     uint64
+	Type   string
+	State  []byte
+	Height uint64
+}
+
+// IBCChannel connects the DEX to a channel of a counterpart chain.
+type IBCChannel struct {
+	ID       uint64
+	ClientID uint64
+	// Counterparty is the ID of the channel on the counterpart
+	// chain.
+	Counterparty uint64
+	NextSendSeq  uint64
+	NextRecvSeq  uint64
+}
+
+// IBCPacket is a token transfer between the chains.
+type IBCPacket struct {
+	Sequence   uint64
+	SrcChannel uint64
+	DstChannel uint64
+	// Denom is the token symbol on the sending chain.
+	Denom    string
+	Decimals uint8
+	Quant    uint64
+	Sender   []byte
+	Receiver []byte
+}
+
+// Commitment returns the packet commitment.
+func (p *IBCPacket) Commitment() consensus.Hash {
+	b, err := rlp.EncodeToBytes(p)
+	if err != nil {
+		panic(err)
+	}
+
+	return consensus.SHA3([]byte("ibc packet"), b)
+}
+
+// IBCPacketRoot is the root of the packet commitments sent in a
+// round, it's verified by the counterpart chains' light clients of
+// the DEX. The commitments are kept for the relayers to make the
+// proofs.
+type IBCPacketRoot struct {
+	Root        consensus.Hash
+	Commitments []consensus.Hash
+}
+
+// ibcDenomPrefix returns the symbol prefix of the vouchers minted
+// by the channel.
+func ibcDenomPrefix(channel uint64) string {
+	return fmt.Sprintf("channel-%d/", channel)
+}
+
+func ibcEscrowPK(channel uint64) PK {
+	return PK(append([]byte("ibc escrow"), []byte(ibcDenomPrefix(channel))...))
+}
+
+// MerkleProof proves the inclusion of a leaf in a binary Merkle
+// tree. A node without a sibling is promoted to the next level.
+type MerkleProof struct {
+	Index    uint64
+	Count    uint64
+	Siblings []consensus.Hash
+}
+
+func merkleParent(l, r consensus.Hash) consensus.Hash {
+	return consensus.SHA3([]byte{1}, l[:], r[:])
+}
+
+// merkleLevel returns the next level of the tree.
+func merkleLevel(level []consensus.Hash) []consensus.Hash {
+	next := make([]consensus.Hash, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 < len(level) {
+			next = append(next, merkleParent(level[i], level[i+1]))
+		} else {
+			next = append(next, level[i])
+		}
+	}
+	return next
+}
+
+// MerkleRoot returns the root of the binary Merkle tree of the
+// leaves.
+func MerkleRoot(leaves []consensus.Hash) consensus.Hash {
+	if len(leaves) == 0 {
+		return consensus.Hash{}
+	}
+
+	level := leaves
+	for len(level) > 1 {
+		level = merkleLevel(level)
+	}
+
+	return level[0]
+}
+
+// MakeMerkleProof returns the proof of the leaf at the index.
+func MakeMerkleProof(leaves []consensus.Hash, index int) MerkleProof {
+	p := MerkleProof{Index: uint64(index), Count: uint64(len(leaves))}
+	level := leaves
+	for len(level) > 1 {
+		if index%2 == 1 {
+			p.Siblings = append(p.Siblings, level[index-1])
+		} else if index+1 < len(level) {
+			p.Siblings = append(p.Siblings, level[index+1])
+		}
+
+		level = merkleLevel(level)
+		index /= 2
+	}
+
+	return p
+}
+
+// Verify verifies that the leaf is included in the tree of the
+// root.
+func (p *MerkleProof) Verify(root, leaf consensus.Hash) bool {
+	if p.Index >= p.Count {
+		return false
+	}
+
+	h := leaf
+	idx, n := p.Index, p.Count
+	siblings := p.Siblings
+	for n > 1 {
+		if idx%2 == 1 || idx+1 < n {
+			if len(siblings) == 0 {
+				return false
+			}
+
+			if idx%2 == 1 {
+				h = merkleParent(siblings[0], h)
+			} else {
+				h = merkleParent(h, siblings[0])
+			}
+			siblings = siblings[1:]
+		}
+
+		idx /= 2
+		n = (n + 1) / 2
+	}
+
+	return len(siblings) == 0 && h == root
+}
+
+func (t *Transition) ibcCreateClient(owner *Account, txn *IBCCreateClientTxn) error {
+	if _, ok := lightClients[txn.Type]; !ok {
+		return fmt.Errorf("unknown light client type: %s", txn.Type)
+	}
+
+	c := IBCClient{
+		ID:    t.state.NextIBCClientID(),
+		Type:  txn.Type,
+		State: txn.State,
+	}
+	t.state.UpdateIBCClient(c)
+	return nil
+}
+
+func (t *Transition) ibcUpdateClient(owner *Account, txn *IBCUpdateClientTxn) error {
+	c, ok := t.state.IBCClient(txn.ClientID)
+	if !ok {
+		return fmt.Errorf("can not find light client %d", txn.ClientID)
+	}
+
+	state, height, root, err := lightClients[c.Type].Update(c.State, txn.Header)
+	if err != nil {
+		return err
+	}
+
+	if height <= c.Height {
+		return fmt.Errorf("header height %d should be higher than the client height %d", height, c.Height)
+	}
+
+	c.State = state
+	c.Height = height
+	t.state.UpdateIBCClient(c)
+	t.state.UpdateIBCRoot(c.ID, height, root)
+	return nil
+}
+
+func (t *Transition) ibcOpenChannel(owner *Account, txn *IBCOpenChannelTxn) error {
+	if _, ok := t.state.IBCClient(txn.ClientID); !ok {
+		return fmt.Errorf("can not find light client %d", txn.ClientID)
+	}
+
+	ch := IBCChannel{
+		ID:           t.state.NextIBCChannelID(),
+		ClientID:     txn.ClientID,
+		Counterparty: txn.Counterparty,
+	}
+	t.state.UpdateIBCChannel(ch)
+	return nil
+}
+
+func (t *Transition) ibcTransfer(owner *Account, txn *IBCTransferTxn) error {
+	if txn.Quant == 0 {
+		return errors.New("transfer quantity is 0")
+	}
+
+	if len(txn.Receiver) == 0 {
+		return errors.New("receiver is empty")
+	}
+
+	ch, ok := t.state.IBCChannel(txn.Channel)
+	if !ok {
+		return fmt.Errorf("can not find channel %d", txn.Channel)
+	}
+
+	info := t.tokenCache.Info(txn.TokenID)
+	if info == zeroInfo {
+		return fmt.Errorf("trying to transfer non-existent token: %d", txn.TokenID)
+	}
+
+	b := owner.Balance(txn.TokenID)
+	if b.Available < txn.Quant {
+		return txnErrorf(ErrCodeInsufficientBalance, "insufficient available token balance, tokenID: %v, quant: %d, available: %d", txn.TokenID, txn.Quant, b.Available)
+	}
+
+	addr := owner.PK().Addr()
+	if err := t.checkTransferThreshold(addr, txn.TokenID, txn.Quant); err != nil {
+		return err
+	}
+
+	b.Available -= txn.Quant
+	owner.UpdateBalance(txn.TokenID, b)
+	t.addOutflow(addr, txn.TokenID, txn.Quant)
+
+	denom := string(info.Symbol)
+	if strings.HasPrefix(denom, ibcDenomPrefix(ch.ID)) {
+		// the voucher returns to the counterpart chain.
+		info.TotalUnits -= txn.Quant
+		t.state.UpdateToken(Token{ID: txn.TokenID, TokenInfo: info})
+		t.tokenCache.Update(txn.TokenID, info)
+	} else {
+		escrow := t.ibcEscrow(ch.ID)
+		eb := escrow.Balance(txn.TokenID)
+		eb.Available += txn.Quant
+		escrow.UpdateBalance(txn.TokenID, eb)
+	}
+
+	p := IBCPacket{
+		Sequence:   ch.NextSendSeq,
+		SrcChannel: ch.ID,
+		DstChannel: ch.Counterparty,
+		Denom:      denom,
+		Decimals:   info.Decimals,
+		Quant:      txn.Quant,
+		Sender:     addr[:],
+		Receiver:   txn.Receiver,
+	}
+	ch.NextSendSeq++
+	t.state.UpdateIBCChannel(ch)
+	t.state.UpdateIBCPacket(p)
+	t.ibcPackets = append(t.ibcPackets, p.Commitment())
+	return nil
+}
+
+func (t *Transition) ibcEscrow(channel uint64) *Account {
+	pk := ibcEscrowPK(channel)
+	acc := t.state.Account(pk.Addr())
+	if acc == nil {
+		acc = t.state.NewAccount(pk)
+	}
+	return acc
+}
+
+func (t *Transition) ibcRecvPacket(owner *Account, txn *IBCRecvPacketTxn) error {
+	p := txn.Packet
+	ch, ok := t.state.IBCChannel(p.DstChannel)
+	if !ok {
+		return fmt.Errorf("can not find channel %d", p.DstChannel)
+	}
+
+	if p.SrcChannel != ch.Counterparty {
+		return fmt.Errorf("packet source channel %d does not match the counterparty channel %d", p.SrcChannel, ch.Counterparty)
+	}
+
+	if p.Sequence != ch.NextRecvSeq {
+		return fmt.Errorf("packet sequence %d, expected: %d", p.Sequence, ch.NextRecvSeq)
+	}
+
+	if p.Quant == 0 {
+		return errors.New("packet quantity is 0")
+	}
+
+	root, ok := t.state.IBCRoot(ch.ClientID, txn.ProofHeight)
+	if !ok {
+		return fmt.Errorf("light client %d has no header at height %d", ch.ClientID, txn.ProofHeight)
+	}
+
+	if !txn.Proof.Verify(root, p.Commitment()) {
+		return errors.New("invalid packet commitment proof")
+	}
+
+	receiver := PK(p.Receiver)
+	toAcc := t.state.Account(receiver.Addr())
+	if toAcc == nil {
+		toAcc = t.state.NewAccount(receiver)
+	}
+
+	prefix := ibcDenomPrefix(p.SrcChannel)
+	if strings.HasPrefix(p.Denom, prefix) {
+		// the token returns home, release it from the
+		// escrow.
+		id, ok := t.tokenIDBySymbol(TokenSymbol(strings.TrimPrefix(p.Denom, prefix)))
+		if !ok {
+			return fmt.Errorf("can not find token %s", strings.TrimPrefix(p.Denom, prefix))
+		}
+
+		escrow := t.ibcEscrow(ch.ID)
+		eb := escrow.Balance(id)
+		if eb.Available < p.Quant {
+			return txnErrorf(ErrCodeInsufficientBalance, "insufficient escrowed token, tokenID: %v, quant: %d, escrowed: %d", id, p.Quant, eb.Available)
+		}
+
+		eb.Available -= p.Quant
+		escrow.UpdateBalance(id, eb)
+		b := toAcc.Balance(id)
+		b.Available += p.Quant
+		toAcc.UpdateBalance(id, b)
+	} else {
+		symbol := TokenSymbol(ibcDenomPrefix(ch.ID) + p.Denom)
+		id, ok := t.state.IBCVoucher(symbol)
+		if !ok {
+			var err error
+			id, err = t.createToken(TokenInfo{Symbol: symbol, Decimals: p.Decimals, TotalUnits: p.Quant})
+			if err != nil {
+				return err
+			}
+
+			t.state.UpdateIBCVoucher(symbol, id)
+		} else {
+			info := t.tokenCache.Info(id)
+			if info == zeroInfo {
+				return fmt.Errorf("voucher token %d does not exist yet", id)
+			}
+
+			info.TotalUnits += p.Quant
+			t.state.UpdateToken(Token{ID: id, TokenInfo: info})
+			t.tokenCache.Update(id, info)
+		}
+
+		b := toAcc.Balance(id)
+		b.Available += p.Quant
+		toAcc.UpdateBalance(id, b)
+	}
+
+	ch.NextRecvSeq++
+	t.state.UpdateIBCChannel(ch)
+	return nil
+}
+
+func (t *Transition) tokenIDBySymbol(symbol TokenSymbol) (TokenID, bool) {
+	for _, token := range t.tokenCache.Tokens() {
+		if token.Symbol == symbol {
+			return token.ID, true
+		}
+	}
+
+	return 0, false
+}
+
+// commitIBCPackets records the root of the packet commitments sent
+// in the round.
+func (t *Transition) commitIBCPackets() {
+	if len(t.ibcPackets) == 0 {
+		return
+	}
+
+	t.state.UpdateIBCPacketRoot(t.round, IBCPacketRoot{
+		Root:        MerkleRoot(t.ibcPackets),
+		Commitments: t.ibcPackets,
+	})
+}
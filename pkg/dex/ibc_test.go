@@ -0,0 +1,123 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		leaves := make([]consensus.Hash, n)
+		for i := range leaves {
+			leaves[i] = consensus.SHA3([]byte{byte(i)})
+		}
+
+		root := MerkleRoot(leaves)
+		for i := range leaves {
+			p := MakeMerkleProof(leaves, i)
+			assert.True(t, p.Verify(root, leaves[i]), "n: %d, i: %d", n, i)
+			assert.False(t, p.Verify(root, consensus.SHA3([]byte("x"))))
+		}
+	}
+}
+
+func groupHeader(t *testing.T, sk consensus.SK, height uint64, root consensus.Hash) []byte {
+	h := GroupHeader{Height: height, Root: root}
+	h.Sig = sk.Sign(GroupHeaderMsg(h))
+	b, err := rlp.EncodeToBytes(h)
+	assert.Nil(t, err)
+	return b
+}
+
+func TestIBCTransfer(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	acc := s.NewAccount(pk)
+	acc.UpdateBalance(0, Balance{Available: 1000})
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	addr := pk.Addr()
+
+	groupSK := consensus.RandSK()
+	state, err := rlp.EncodeToBytes(GroupClientState{GroupPK: groupSK.MustPK()})
+	assert.Nil(t, err)
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeIBCCreateClientTxn(sk, addr, IBCCreateClientTxn{Type: GroupLightClientType, State: state}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeIBCOpenChannelTxn(sk, addr, IBCOpenChannelTxn{ClientID: 0, Counterparty: 7}, 1), pker))
+	s = trans.Commit().(*State)
+
+	// the counterpart chain sends ATOM to the DEX.
+	in := IBCPacket{SrcChannel: 7, DstChannel: 0, Denom: "ATOM", Decimals: 6, Quant: 50, Receiver: pk}
+	other := IBCPacket{Sequence: 1, SrcChannel: 7, DstChannel: 0, Denom: "ATOM", Decimals: 6, Quant: 20, Receiver: pk}
+	leaves := []consensus.Hash{in.Commitment(), other.Commitment(), consensus.SHA3([]byte("unrelated"))}
+	root := MerkleRoot(leaves)
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	update := IBCUpdateClientTxn{ClientID: 0, Header: groupHeader(t, consensus.RandSK(), 10, root)}
+	err = recordTxn(t, trans, MakeIBCUpdateClientTxn(sk, addr, update, 2), pker)
+	assert.Contains(t, err.Error(), "signature")
+	update.Header = groupHeader(t, groupSK, 10, root)
+	assert.Nil(t, recordTxn(t, trans, MakeIBCUpdateClientTxn(sk, addr, update, 2), pker))
+
+	recv := IBCRecvPacketTxn{Packet: other, ProofHeight: 10, Proof: MakeMerkleProof(leaves, 1)}
+	err = recordTxn(t, trans, MakeIBCRecvPacketTxn(sk, addr, recv, 3), pker)
+	assert.Contains(t, err.Error(), "sequence")
+	recv = IBCRecvPacketTxn{Packet: in, ProofHeight: 10, Proof: MakeMerkleProof(leaves, 1)}
+	err = recordTxn(t, trans, MakeIBCRecvPacketTxn(sk, addr, recv, 3), pker)
+	assert.Contains(t, err.Error(), "proof")
+	recv.Proof = MakeMerkleProof(leaves, 0)
+	assert.Nil(t, recordTxn(t, trans, MakeIBCRecvPacketTxn(sk, addr, recv, 3), pker))
+	s = trans.Commit().(*State)
+
+	voucher, ok := s.IBCVoucher("channel-0/ATOM")
+	assert.True(t, ok)
+	assert.Equal(t, 50, int(s.Account(addr).Balance(voucher).Available))
+
+	trans = s.Transition(3, 0, nil).(*Transition)
+	recv = IBCRecvPacketTxn{Packet: other, ProofHeight: 10, Proof: MakeMerkleProof(leaves, 1)}
+	assert.Nil(t, recordTxn(t, trans, MakeIBCRecvPacketTxn(sk, addr, recv, 4), pker))
+	// the vouchers return to the counterpart chain, and BNB
+	// leaves the DEX.
+	assert.Nil(t, recordTxn(t, trans, MakeIBCTransferTxn(sk, addr, IBCTransferTxn{Channel: 0, TokenID: voucher, Quant: 30, Receiver: []byte("cosmos")}, 5), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeIBCTransferTxn(sk, addr, IBCTransferTxn{Channel: 0, TokenID: 0, Quant: 100, Receiver: []byte("cosmos")}, 6), pker))
+	s = trans.Commit().(*State)
+
+	assert.Equal(t, 40, int(s.Account(addr).Balance(voucher).Available))
+	assert.Equal(t, 900, int(s.Account(addr).Balance(0).Available))
+	assert.Equal(t, 100, int(s.Account(ibcEscrowPK(0).Addr()).Balance(0).Available))
+	for _, tk := range s.Tokens() {
+		if tk.ID == voucher {
+			assert.Equal(t, 40, int(tk.TotalUnits))
+		}
+	}
+
+	p0, ok := s.IBCPacket(0, 0)
+	assert.True(t, ok)
+	assert.Equal(t, "channel-0/ATOM", p0.Denom)
+	p1, ok := s.IBCPacket(0, 1)
+	assert.True(t, ok)
+	assert.Equal(t, "BNB", p1.Denom)
+	assert.Equal(t, uint64(7), p1.DstChannel)
+	r, ok := s.IBCPacketRoot(3)
+	assert.True(t, ok)
+	assert.Equal(t, []consensus.Hash{p0.Commitment(), p1.Commitment()}, r.Commitments)
+	assert.Equal(t, MerkleRoot(r.Commitments), r.Root)
+
+	// the counterpart chain returns the BNB vouchers.
+	back := IBCPacket{Sequence: 2, SrcChannel: 7, DstChannel: 0, Denom: "channel-7/BNB", Quant: 60, Receiver: pk}
+	leaves = []consensus.Hash{back.Commitment()}
+	trans = s.Transition(4, 0, nil).(*Transition)
+	update = IBCUpdateClientTxn{ClientID: 0, Header: groupHeader(t, groupSK, 11, MerkleRoot(leaves))}
+	assert.Nil(t, recordTxn(t, trans, MakeIBCUpdateClientTxn(sk, addr, update, 7), pker))
+	recv = IBCRecvPacketTxn{Packet: back, ProofHeight: 11, Proof: MakeMerkleProof(leaves, 0)}
+	assert.Nil(t, recordTxn(t, trans, MakeIBCRecvPacketTxn(sk, addr, recv, 8), pker))
+	s = trans.Commit().(*State)
+
+	assert.Equal(t, 960, int(s.Account(addr).Balance(0).Available))
+	assert.Equal(t, 40, int(s.Account(ibcEscrowPK(0).Addr()).Balance(0).Available))
+}
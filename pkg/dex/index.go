@@ -0,0 +1,218 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	// IndexWeightDenominator is the denominator of the weights of
+	// the index components, a weight of IndexWeightDenominator
+	// counts the market's price once.
+	IndexWeightDenominator = 1000000
+	maxIndexNameLen        = 16
+	maxIndexComponents     = 50
+	// maxIndexEvents is the number of the latest index events
+	// kept for the subscribers.
+	maxIndexEvents = 10000
+)
+
+// IndexComponent is a market of the index basket with its weight.
+type IndexComponent struct {
+	Market MarketSymbol
+	Weight uint64
+}
+
+// Index is a synthetic composite market, its value is the weighted
+// sum of the reference prices of its component markets, with
+// OrderPriceDecimals decimals.
+type Index struct {
+	Name       string
+	Components []IndexComponent
+}
+
+// IndexPrice is the value of an index in the round that it's last
+// changed.
+type IndexPrice struct {
+	Value uint64
+	Round uint64
+}
+
+func validIndexName(name string) bool {
+	if len(name) == 0 || len(name) > maxIndexNameLen {
+		return false
+	}
+
+	for _, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (t *Transition) setIndex(owner *Account, txn *SetIndexTxn) error {
+	if err := t.checkGovernor(owner); err != nil {
+		return err
+	}
+
+	idx := txn.Index
+	if !validIndexName(idx.Name) {
+		return fmt.Errorf("invalid index name %q, should be 1 to %d letters, digits or underscores", idx.Name, maxIndexNameLen)
+	}
+
+	if len(idx.Components) > maxIndexComponents {
+		return fmt.Errorf("index has %d components, the max is %d", len(idx.Components), maxIndexComponents)
+	}
+
+	seen := make(map[MarketSymbol]bool)
+	for _, c := range idx.Components {
+		if !c.Market.Valid() || t.tokenCache.Info(c.Market.Base) == zeroInfo || t.tokenCache.Info(c.Market.Quote) == zeroInfo {
+			return txnErrorf(ErrCodeBadMarket, "index component market is invalid: %v", c.Market)
+		}
+
+		if c.Weight == 0 {
+			return fmt.Errorf("weight of index component %v is 0", c.Market)
+		}
+
+		if seen[c.Market] {
+			return fmt.Errorf("index component %v is duplicated", c.Market)
+		}
+		seen[c.Market] = true
+	}
+
+	if len(idx.Components) == 0 {
+		if _, ok := t.state.indexByName(idx.Name); !ok {
+			return errors.New("index to remove does not exist")
+		}
+	}
+
+	t.state.UpdateIndex(idx)
+	return nil
+}
+
+// indexValue returns the value of the index at the reference prices
+// of its component markets, it returns false if a market has no
+// reference price.
+func (t *Transition) indexValue(idx Index) (uint64, bool) {
+	var v uint64
+	for _, c := range idx.Components {
+		p, ok := t.state.RefPrice(c.Market)
+		if !ok {
+			return 0, false
+		}
+
+		v += mulDiv(p.Price, c.Weight, IndexWeightDenominator)
+	}
+	return v, true
+}
+
+// updateIndexes updates the values of the indexes after the reference
+// prices are updated, an index is priced once all of its component
+// markets are traded.
+func (t *Transition) updateIndexes() {
+	for _, idx := range t.state.Indexes() {
+		v, ok := t.indexValue(idx)
+		if !ok {
+			continue
+		}
+
+		if p, ok := t.state.IndexPrice(idx.Name); ok && p.Value == v {
+			continue
+		}
+
+		t.state.UpdateIndexPrice(idx.Name, IndexPrice{Value: v, Round: t.round})
+	}
+}
+
+// IndexEvent is a change of an index value.
+type IndexEvent struct {
+	// Seq is the sequence number of the event, starting from 1.
+	// It's local to the node.
+	Seq   uint64
+	Round uint64
+	Name  string
+	Value uint64
+}
+
+type IndexEventsArgs struct {
+	// After is the sequence number of the last received event.
+	After uint64
+}
+
+type IndexEvents struct {
+	Events []IndexEvent
+	// Gap is true when the events after After are dropped, the
+	// consumer must reload the indexes.
+	Gap bool
+}
+
+// IndexState is an index with its latest value, Price is zero if the
+// index is not priced yet.
+type IndexState struct {
+	Index
+	Price IndexPrice
+}
+
+// indexFeed keeps the latest index events.
+type indexFeed struct {
+	mu     sync.Mutex
+	round  uint64
+	seq    uint64
+	events []IndexEvent
+}
+
+func newIndexFeed() *indexFeed {
+	return &indexFeed{}
+}
+
+// update adds the index changes of the state. The states of the
+// rounds not after the last added round are ignored.
+func (f *indexFeed) update(s *State) {
+	s.mu.Lock()
+	round := s.round
+	s.mu.Unlock()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if round <= f.round {
+		return
+	}
+	f.round = round
+
+	for _, idx := range s.Indexes() {
+		p, ok := s.IndexPrice(idx.Name)
+		if !ok || p.Round != round {
+			continue
+		}
+
+		f.seq++
+		f.events = append(f.events, IndexEvent{Seq: f.seq, Round: round, Name: idx.Name, Value: p.Value})
+	}
+
+	if len(f.events) > maxIndexEvents {
+		f.events = f.events[len(f.events)-maxIndexEvents:]
+	}
+}
+
+// after returns the events after the sequence number after.
+func (f *indexFeed) after(after uint64) (IndexEvents, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if after > f.seq {
+		return IndexEvents{}, errors.New("sequence number not reached, the node restarted")
+	}
+
+	i := len(f.events)
+	for i > 0 && f.events[i-1].Seq > after {
+		i--
+	}
+
+	events := append([]IndexEvent(nil), f.events[i:]...)
+	gap := after < f.seq && (len(events) == 0 || events[0].Seq > after+1)
+	return IndexEvents{Events: events, Gap: gap}, nil
+}
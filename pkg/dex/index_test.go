@@ -0,0 +1,95 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetIndex(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: TokenInfo{Symbol: "ETH", Decimals: 8, TotalUnits: 1e10}})
+	s.UpdateToken(Token{ID: 2, TokenInfo: TokenInfo{Symbol: "XRP", Decimals: 8, TotalUnits: 1e10}})
+	pkGov, skGov := RandKeyPair()
+	pk, sk := RandKeyPair()
+	s.NewAccount(pkGov)
+	s.NewAccount(pk)
+	s.UpdateGovernor(pkGov.Addr())
+	pker := &myPKer{m: map[consensus.Addr]PK{pkGov.Addr(): pkGov, pk.Addr(): pk}}
+
+	m0 := MarketSymbol{Base: 1, Quote: 0}
+	m1 := MarketSymbol{Base: 2, Quote: 0}
+	idx := Index{Name: "CRYPTO", Components: []IndexComponent{{Market: m0, Weight: 500000}, {Market: m1, Weight: 500000}}}
+	trans := s.Transition(1, 0, nil).(*Transition)
+	err := recordTxn(t, trans, MakeSetIndexTxn(sk, pk.Addr(), SetIndexTxn{Index: idx}, 0), pker)
+	assert.Equal(t, ErrCodeUnauthorized, ErrorCode(err))
+
+	bad := Index{Name: "BAD NAME", Components: idx.Components}
+	assert.NotNil(t, recordTxn(t, trans, MakeSetIndexTxn(skGov, pkGov.Addr(), SetIndexTxn{Index: bad}, 0), pker))
+	bad = Index{Name: "DUP", Components: []IndexComponent{{Market: m0, Weight: 1}, {Market: m0, Weight: 1}}}
+	assert.NotNil(t, recordTxn(t, trans, MakeSetIndexTxn(skGov, pkGov.Addr(), SetIndexTxn{Index: bad}, 0), pker))
+	bad = Index{Name: "NOMARKET", Components: []IndexComponent{{Market: MarketSymbol{Base: 3, Quote: 0}, Weight: 1}}}
+	err = recordTxn(t, trans, MakeSetIndexTxn(skGov, pkGov.Addr(), SetIndexTxn{Index: bad}, 0), pker)
+	assert.Equal(t, ErrCodeBadMarket, ErrorCode(err))
+
+	assert.Nil(t, recordTxn(t, trans, MakeSetIndexTxn(skGov, pkGov.Addr(), SetIndexTxn{Index: idx}, 0), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, []Index{idx}, s.Indexes())
+
+	// not priced until all the markets have reference prices.
+	s.UpdateRefPrice(m0, RefPrice{Price: 4e8, Round: 1})
+	trans = s.Transition(2, 0, nil).(*Transition)
+	trans.updateIndexes()
+	s = trans.Commit().(*State)
+	_, ok := s.IndexPrice("CRYPTO")
+	assert.False(t, ok)
+
+	s.UpdateRefPrice(m1, RefPrice{Price: 2e8, Round: 2})
+	trans = s.Transition(3, 0, nil).(*Transition)
+	trans.updateIndexes()
+	s = trans.Commit().(*State)
+	p, ok := s.IndexPrice("CRYPTO")
+	assert.True(t, ok)
+	assert.Equal(t, IndexPrice{Value: 3e8, Round: 3}, p)
+
+	// removing the index removes its value.
+	trans = s.Transition(4, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeSetIndexTxn(skGov, pkGov.Addr(), SetIndexTxn{Index: Index{Name: "CRYPTO"}}, 1), pker))
+	assert.NotNil(t, recordTxn(t, trans, MakeSetIndexTxn(skGov, pkGov.Addr(), SetIndexTxn{Index: Index{Name: "CRYPTO"}}, 2), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, 0, len(s.Indexes()))
+	_, ok = s.IndexPrice("CRYPTO")
+	assert.False(t, ok)
+}
+
+func TestIndexFeed(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateIndex(Index{Name: "A", Components: []IndexComponent{{Market: MarketSymbol{Base: 1}, Weight: 1}}})
+	s.UpdateIndexPrice("A", IndexPrice{Value: 10, Round: 1})
+	s.round = 1
+
+	f := newIndexFeed()
+	f.update(s)
+	// the same round is not added again.
+	f.update(s)
+	e, err := f.after(0)
+	assert.Nil(t, err)
+	assert.Equal(t, []IndexEvent{{Seq: 1, Round: 1, Name: "A", Value: 10}}, e.Events)
+	assert.False(t, e.Gap)
+
+	e, err = f.after(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(e.Events))
+
+	_, err = f.after(2)
+	assert.NotNil(t, err)
+
+	f.events = nil
+	f.seq = 5
+	e, err = f.after(1)
+	assert.Nil(t, err)
+	assert.True(t, e.Gap)
+}
@@ -0,0 +1,246 @@
+package dex
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+const (
+	// LargeTransferDelay is the number of rounds between the
+	// announcement of a large transfer and its execution, in which
+	// the account's guardian can veto it. A looser large transfer
+	// threshold applies after the same delay.
+	LargeTransferDelay = 100
+	// LargeTransferWindow is the number of the latest rounds whose
+	// outflow of a token is limited by the large transfer
+	// threshold, so that a large transfer can not be split into
+	// smaller ones.
+	LargeTransferWindow = 100
+)
+
+// TransferThresholds is the account's large transfer threshold of a
+// token, and the looser threshold that applies from NextRound. The
+// account's outflow of the token in the last LargeTransferWindow
+// rounds can not exceed the threshold, a larger transfer must be
+// announced instead. 0 means no threshold.
+//
+// The outflow is what leaves the account's control: the sends, the
+// quantity locked by the orders since they can fill against any
+// counterparty, the escrows, the hash time locked contracts, the
+// payment stream deposits, the withdrawals to the other chains, and
+// the transfers to the margin and perpetual accounts.
+type TransferThresholds struct {
+	Current   uint64
+	Next      uint64
+	NextRound uint64
+}
+
+// At returns the threshold at the round.
+func (l TransferThresholds) At(round uint64) uint64 {
+	if l.NextRound > 0 && round >= l.NextRound {
+		return l.Next
+	}
+
+	return l.Current
+}
+
+// Outflow is the quantity of a token that left the account in the
+// round.
+type Outflow struct {
+	Round uint64
+	Quant uint64
+}
+
+// PendingTransfer is an announced large transfer. The quantity is
+// taken from the owner's balance when announced, and refunded if the
+// transfer is vetoed.
+type PendingTransfer struct {
+	ID        consensus.Hash
+	Owner     consensus.Addr
+	TokenID   TokenID
+	To        PK
+	Quant     uint64
+	ExecRound uint64
+}
+
+// TransferID returns the ID of the transfer announced by the owner's
+// txn with the nonce.
+func TransferID(owner consensus.Addr, nonce uint64) consensus.Hash {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, nonce)
+	return consensus.SHA3([]byte("transfer"), owner[:], b)
+}
+
+func (t *Transition) setTransferThreshold(owner *Account, txn *SetTransferThresholdTxn) error {
+	if t.tokenCache.Info(txn.TokenID) == zeroInfo {
+		return fmt.Errorf("token %d does not exist", txn.TokenID)
+	}
+
+	addr := owner.PK().Addr()
+	cur := t.state.TransferThresholds(addr, txn.TokenID).At(t.round)
+	l := TransferThresholds{Current: cur}
+	if txn.Threshold != 0 && (cur == 0 || txn.Threshold <= cur) {
+		l.Current = txn.Threshold
+	} else if txn.Threshold != cur {
+		l.Next = txn.Threshold
+		l.NextRound = t.round + LargeTransferDelay
+	}
+
+	t.state.UpdateTransferThresholds(addr, txn.TokenID, l)
+	return nil
+}
+
+// recentOutflows returns the owner's outflows of the token in the
+// last LargeTransferWindow rounds.
+func (t *Transition) recentOutflows(owner consensus.Addr, id TokenID) []Outflow {
+	os := t.state.TransferOutflows(owner, id)
+	i := 0
+	for i < len(os) && os[i].Round+LargeTransferWindow <= t.round {
+		i++
+	}
+	return os[i:]
+}
+
+// checkTransferThreshold returns an error if the quantity sent by the
+// owner would make its outflow in the last LargeTransferWindow
+// rounds exceed its large transfer threshold.
+func (t *Transition) checkTransferThreshold(owner consensus.Addr, id TokenID, quant uint64) error {
+	th := t.state.TransferThresholds(owner, id).At(t.round)
+	if th == 0 {
+		return nil
+	}
+
+	var sum uint64
+	for _, o := range t.recentOutflows(owner, id) {
+		sum += o.Quant
+	}
+
+	if sum >= th || quant > th-sum {
+		return txnErrorf(ErrCodeUnauthorized, "transfer quantity %d plus the outflow %d of the last %d rounds is above the large transfer threshold %d, it must be announced", quant, sum, LargeTransferWindow, th)
+	}
+
+	return nil
+}
+
+// addOutflow records the owner's outflow of the token, it must be
+// checked by checkTransferThreshold. The outflow is only recorded
+// when the owner has a threshold.
+func (t *Transition) addOutflow(owner consensus.Addr, id TokenID, quant uint64) {
+	if t.state.TransferThresholds(owner, id).At(t.round) == 0 {
+		return
+	}
+
+	os := t.recentOutflows(owner, id)
+	if n := len(os); n > 0 && os[n-1].Round == t.round {
+		os[n-1].Quant += quant
+	} else {
+		os = append(os, Outflow{Round: t.round, Quant: quant})
+	}
+	t.state.UpdateTransferOutflows(owner, id, os)
+}
+
+// orderOutflow returns the token and the quantity locked by the
+// order.
+func (t *Transition) orderOutflow(txn *PlaceOrderTxn) (TokenID, uint64) {
+	if txn.SellSide {
+		return txn.Market.Base, txn.Quant
+	}
+
+	baseInfo := t.tokenCache.Info(txn.Market.Base)
+	quoteInfo := t.tokenCache.Info(txn.Market.Quote)
+	return txn.Market.Quote, calcQuoteQuant(txn.Quant, quoteInfo.Decimals, txn.Price, OrderPriceDecimals, baseInfo.Decimals)
+}
+
+func (t *Transition) announceTransfer(owner *Account, txn *AnnounceTransferTxn) error {
+	if txn.Quant == 0 {
+		return errors.New("transfer quantity is 0")
+	}
+
+	b := owner.Balance(txn.TokenID)
+	if b.Available < txn.Quant {
+		return txnErrorf(ErrCodeInsufficientBalance, "insufficient available token balance, tokenID: %v, quant: %d, available: %d", txn.TokenID, txn.Quant, b.Available)
+	}
+
+	addr := owner.PK().Addr()
+	toAddr := txn.To.Addr()
+	for _, a := range []consensus.Addr{addr, toAddr} {
+		if err := t.checkHolder(txn.TokenID, a); err != nil {
+			return err
+		}
+	}
+
+	if err := t.checkAllowList(addr, toAddr); err != nil {
+		return err
+	}
+
+	if err := t.checkBlocked(toAddr); err != nil {
+		return err
+	}
+
+	b.Available -= txn.Quant
+	owner.UpdateBalance(txn.TokenID, b)
+	t.state.UpdatePendingTransfer(PendingTransfer{
+		ID:        TransferID(addr, owner.Nonce()),
+		Owner:     addr,
+		TokenID:   txn.TokenID,
+		To:        txn.To,
+		Quant:     txn.Quant,
+		ExecRound: t.round + LargeTransferDelay,
+	})
+	return nil
+}
+
+func (t *Transition) executeTransfer(owner *Account, txn *ExecuteTransferTxn) error {
+	addr := owner.PK().Addr()
+	p, ok := t.state.PendingTransfer(addr, txn.ID)
+	if !ok {
+		return fmt.Errorf("can not find pending transfer %v", txn.ID)
+	}
+
+	if t.round < p.ExecRound {
+		return fmt.Errorf("transfer %v can be executed from round %d, current round: %d", txn.ID, p.ExecRound, t.round)
+	}
+
+	// the allow-list could be tightened after the announcement.
+	toAddr := p.To.Addr()
+	if err := t.checkAllowList(addr, toAddr); err != nil {
+		return err
+	}
+
+	if err := t.checkHolder(p.TokenID, toAddr); err != nil {
+		return err
+	}
+
+	if err := t.checkBlocked(toAddr); err != nil {
+		return err
+	}
+
+	toAcc := t.state.Account(toAddr)
+	if toAcc == nil {
+		toAcc = t.state.NewAccount(p.To)
+	}
+	credit(toAcc, p.TokenID, p.Quant)
+	t.state.RemovePendingTransfer(addr, txn.ID)
+	return nil
+}
+
+// vetoTransfer refunds the pending transfer to its owner, the owner
+// or its guardian can veto it.
+func (t *Transition) vetoTransfer(owner *Account, txn *VetoTransferTxn) error {
+	p, ok := t.state.PendingTransfer(txn.Owner, txn.ID)
+	if !ok {
+		return fmt.Errorf("can not find pending transfer %v", txn.ID)
+	}
+
+	addr := owner.PK().Addr()
+	if addr != p.Owner && addr != t.state.Guardian(p.Owner).At(t.round) {
+		return txnErrorf(ErrCodeUnauthorized, "only the owner or its guardian can veto transfer %v", txn.ID)
+	}
+
+	credit(t.state.Account(p.Owner), p.TokenID, p.Quant)
+	t.state.RemovePendingTransfer(p.Owner, txn.ID)
+	return nil
+}
@@ -0,0 +1,99 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLargeTransfer(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	pkGuardian, skGuardian := RandKeyPair()
+	pkTo, _ := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(1, Balance{Available: 1000})
+	s.NewAccount(pkGuardian)
+	addr, guardian := pk.Addr(), pkGuardian.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk, guardian: pkGuardian}}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeSetGuardianTxn(sk, addr, SetGuardianTxn{Guardian: guardian}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeSetTransferThresholdTxn(sk, addr, SetTransferThresholdTxn{TokenID: 1, Threshold: 100}, 1), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkTo, 1, 100, 2), pker))
+	err := recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkTo, 1, 101, 3), pker)
+	assert.Equal(t, ErrCodeUnauthorized, ErrorCode(err))
+
+	// removing the threshold is delayed.
+	assert.Nil(t, recordTxn(t, trans, MakeSetTransferThresholdTxn(sk, addr, SetTransferThresholdTxn{TokenID: 1}, 3), pker))
+	assert.Equal(t, TransferThresholds{Current: 100, NextRound: 1 + LargeTransferDelay}, trans.state.TransferThresholds(addr, 1))
+
+	assert.Nil(t, recordTxn(t, trans, MakeAnnounceTransferTxn(sk, addr, AnnounceTransferTxn{TokenID: 1, To: pkTo, Quant: 300}, 4), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeAnnounceTransferTxn(sk, addr, AnnounceTransferTxn{TokenID: 1, To: pkTo, Quant: 200}, 5), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, 400, int(s.Account(addr).Balance(1).Available))
+	transfers := s.PendingTransfers(addr)
+	assert.Equal(t, 2, len(transfers))
+	id0, id1 := TransferID(addr, 4), TransferID(addr, 5)
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.NotNil(t, recordTxn(t, trans, MakeExecuteTransferTxn(sk, addr, ExecuteTransferTxn{ID: id0}, 6), pker), "too early")
+	// the guardian vetoes a transfer made with the leaked key.
+	assert.Nil(t, recordTxn(t, trans, MakeVetoTransferTxn(skGuardian, guardian, VetoTransferTxn{Owner: addr, ID: id1}, 0), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, 600, int(s.Account(addr).Balance(1).Available))
+
+	trans = s.Transition(1+LargeTransferDelay, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeExecuteTransferTxn(sk, addr, ExecuteTransferTxn{ID: id0}, 6), pker))
+	assert.NotNil(t, recordTxn(t, trans, MakeExecuteTransferTxn(sk, addr, ExecuteTransferTxn{ID: id0}, 7), pker), "already executed")
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkTo, 1, 200, 7), pker), "threshold removed")
+	s = trans.Commit().(*State)
+	assert.Equal(t, 600, int(s.Account(pkTo.Addr()).Balance(1).Available))
+	assert.Empty(t, s.PendingTransfers(addr))
+}
+
+func TestLargeTransferWindow(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	pkTo, _ := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(1, Balance{Available: 1000})
+	addr := pk.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+	m := MarketSymbol{Base: 1, Quote: 0}
+	price := uint64(math.Pow10(OrderPriceDecimals))
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeSetTransferThresholdTxn(sk, addr, SetTransferThresholdTxn{TokenID: 1, Threshold: 100}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkTo, 1, 60, 1), pker))
+	// the rest of a split transfer is rejected.
+	err := recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkTo, 1, 50, 2), pker)
+	assert.Equal(t, ErrCodeUnauthorized, ErrorCode(err))
+	s = trans.Commit().(*State)
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	err = recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkTo, 1, 50, 2), pker)
+	assert.Equal(t, ErrCodeUnauthorized, ErrorCode(err))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 30, Price: price, Market: m}, 2), pker))
+	// an order could fill against the counterparty of the
+	// attacker.
+	err = recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 20, Price: price, Market: m}, 3), pker)
+	assert.Equal(t, ErrCodeUnauthorized, ErrorCode(err))
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkTo, 1, 10, 3), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, []Outflow{{Round: 1, Quant: 60}, {Round: 2, Quant: 40}}, s.TransferOutflows(addr, 1))
+
+	// the outflow of round 1 leaves the window.
+	trans = s.Transition(1+LargeTransferWindow, 0, nil).(*Transition)
+	err = recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkTo, 1, 61, 4), pker)
+	assert.Equal(t, ErrCodeUnauthorized, ErrorCode(err))
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkTo, 1, 60, 4), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, []Outflow{{Round: 2, Quant: 40}, {Round: 1 + LargeTransferWindow, Quant: 60}}, s.TransferOutflows(addr, 1))
+	assert.Equal(t, 130, int(s.Account(pkTo.Addr()).Balance(1).Available))
+}
@@ -0,0 +1,230 @@
+package dex
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// the formats of the ledger export.
+const (
+	LedgerCSV       = "csv"
+	LedgerBeancount = "beancount"
+)
+
+// the ledger account components of the balance buckets.
+const (
+	ledgerAvailable = "Available"
+	ledgerPending   = "Pending"
+	ledgerFrozen    = "Frozen"
+)
+
+// LedgerPosting is a debit or a credit of a ledger account, the
+// quantity is in the token's smallest unit.
+//
+// The account balances are the exchange's liabilities to the account
+// owners: an increase of a balance is a credit. The other side of the
+// balance mutations of a txn that do not net to zero, e.g., a deposit
+// or a fee, is posted to the clearing account of the reason.
+type LedgerPosting struct {
+	Account string
+	Token   TokenID
+	Debit   Uint128
+	Credit  Uint128
+}
+
+// LedgerTxn is the balanced postings of the balance mutations with the
+// same cause.
+type LedgerTxn struct {
+	Round    uint64
+	Txn      string
+	Reason   string
+	Postings []LedgerPosting
+}
+
+// ReadAuditLog reads the audit log entries in JSON lines.
+func ReadAuditLog(r io.Reader) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var e AuditEntry
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			return nil, fmt.Errorf("decode audit log entry %d error: %v", len(entries), err)
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, scanner.Err()
+}
+
+// ledgerComponent returns s as a ledger account component, which
+// starts with an upper case letter or a digit and only contains the
+// letters, the digits and "-".
+func ledgerComponent(s string) string {
+	r := []rune(s)
+	for i, c := range r {
+		if c > unicode.MaxASCII || !(unicode.IsLetter(c) || unicode.IsDigit(c)) {
+			r[i] = '-'
+		}
+	}
+
+	if len(r) == 0 || r[0] == '-' {
+		return "X" + string(r)
+	}
+
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// LedgerAccount returns the ledger account of the balance bucket of
+// the bech32 encoded account.
+func LedgerAccount(account, bucket string) string {
+	return "Liabilities:" + ledgerComponent(strings.ToUpper(account)) + ":" + bucket
+}
+
+// ClearingAccount returns the clearing account of the reason.
+func ClearingAccount(reason string) string {
+	return "Equity:Clearing:" + ledgerComponent(reason)
+}
+
+// post adds the posting of the change of a balance bucket.
+func post(postings []LedgerPosting, account string, token TokenID, before, after uint64) []LedgerPosting {
+	switch {
+	case after > before:
+		return append(postings, LedgerPosting{Account: account, Token: token, Credit: NewUint128(after - before)})
+	case after < before:
+		return append(postings, LedgerPosting{Account: account, Token: token, Debit: NewUint128(before - after)})
+	default:
+		return postings
+	}
+}
+
+// LedgerTxns renders the audit log entries as the double-entry ledger
+// txns. The consecutive entries of the same round, txn and reason are
+// one ledger txn.
+func LedgerTxns(entries []AuditEntry) []LedgerTxn {
+	var txns []LedgerTxn
+	for i := 0; i < len(entries); {
+		e := entries[i]
+		t := LedgerTxn{Round: e.Round, Txn: e.Txn, Reason: e.Reason}
+		var tokens []TokenID
+		debit := make(map[TokenID]Uint128)
+		credit := make(map[TokenID]Uint128)
+		for ; i < len(entries); i++ {
+			e := entries[i]
+			if e.Round != t.Round || e.Txn != t.Txn || e.Reason != t.Reason {
+				break
+			}
+
+			n := len(t.Postings)
+			t.Postings = post(t.Postings, LedgerAccount(e.Account, ledgerAvailable), e.Token, e.Before.Available, e.After.Available)
+			t.Postings = post(t.Postings, LedgerAccount(e.Account, ledgerPending), e.Token, e.Before.Pending, e.After.Pending)
+			t.Postings = post(t.Postings, LedgerAccount(e.Account, ledgerFrozen), e.Token, e.Before.Frozen, e.After.Frozen)
+			for _, p := range t.Postings[n:] {
+				if _, ok := debit[p.Token]; !ok {
+					tokens = append(tokens, p.Token)
+				}
+				// a sum of the uint64 quantities does
+				// not overflow an Uint128.
+				debit[p.Token], _ = debit[p.Token].Add(p.Debit)
+				credit[p.Token], _ = credit[p.Token].Add(p.Credit)
+			}
+		}
+
+		for _, token := range tokens {
+			d, c := debit[token], credit[token]
+			switch d.Cmp(c) {
+			case 1:
+				diff, _ := d.Sub(c)
+				t.Postings = append(t.Postings, LedgerPosting{Account: ClearingAccount(t.Reason), Token: token, Credit: diff})
+			case -1:
+				diff, _ := c.Sub(d)
+				t.Postings = append(t.Postings, LedgerPosting{Account: ClearingAccount(t.Reason), Token: token, Debit: diff})
+			}
+		}
+
+		if len(t.Postings) > 0 {
+			txns = append(txns, t)
+		}
+	}
+	return txns
+}
+
+// WriteLedgerCSV writes the ledger txns as CSV, one posting per row.
+// The token column is the symbol if known, otherwise the token ID.
+func WriteLedgerCSV(w io.Writer, txns []LedgerTxn, symbols map[TokenID]TokenSymbol) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"round", "txn", "reason", "account", "token", "debit", "credit"})
+	if err != nil {
+		return err
+	}
+
+	for _, t := range txns {
+		for _, p := range t.Postings {
+			token := string(symbols[p.Token])
+			if token == "" {
+				token = strconv.FormatUint(uint64(p.Token), 10)
+			}
+
+			err = cw.Write([]string{strconv.FormatUint(t.Round, 10), t.Txn, t.Reason, p.Account, token, p.Debit.String(), p.Credit.String()})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+var beancountCommodityRE = regexp.MustCompile(`^[A-Z][A-Z0-9'._-]{0,22}[A-Z0-9]$`)
+
+// beancountCommodity returns the commodity of the token, the upper
+// case symbol if it's a valid commodity, otherwise "T" and the token
+// ID.
+func beancountCommodity(id TokenID, symbols map[TokenID]TokenSymbol) string {
+	if s := strings.ToUpper(string(symbols[id])); beancountCommodityRE.MatchString(s) {
+		return s
+	}
+	return "T" + strconv.FormatUint(uint64(id), 10)
+}
+
+// WriteBeancount writes the ledger txns in the Beancount format. The
+// audit log has no time, the txns are dated date, with the round and
+// the txn hash in the metadata. A debit is a positive amount.
+func WriteBeancount(w io.Writer, txns []LedgerTxn, symbols map[TokenID]TokenSymbol, date time.Time) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "plugin \"beancount.plugins.auto_accounts\"\n")
+	day := date.Format("2006-01-02")
+	for _, t := range txns {
+		fmt.Fprintf(bw, "\n%s * %q %q\n", day, t.Reason, fmt.Sprintf("round %d", t.Round))
+		fmt.Fprintf(bw, "  round: %d\n", t.Round)
+		if t.Txn != "" {
+			fmt.Fprintf(bw, "  txn: %q\n", t.Txn)
+		}
+
+		for _, p := range t.Postings {
+			amount := p.Debit.String()
+			if p.Debit == (Uint128{}) {
+				amount = "-" + p.Credit.String()
+			}
+			fmt.Fprintf(bw, "  %s  %s %s\n", p.Account, amount, beancountCommodity(p.Token, symbols))
+		}
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,62 @@
+package dex
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedgerExport(t *testing.T) {
+	entries := []AuditEntry{
+		{Round: 1, Txn: "aa", Reason: AuditFee, Account: "dex1a", Before: AuditBalance{Available: 110}, After: AuditBalance{Available: 100}},
+		{Round: 1, Txn: "aa", Reason: "SendToken", Account: "dex1a", Before: AuditBalance{Available: 100}, After: AuditBalance{Available: 80}},
+		{Round: 1, Txn: "aa", Reason: "SendToken", Account: "dex1b", Token: 1, Before: AuditBalance{Available: 5}, After: AuditBalance{Available: 5}},
+		{Round: 1, Txn: "aa", Reason: "SendToken", Account: "dex1b", After: AuditBalance{Available: 20}},
+		{Round: 2, Txn: "bb", Reason: "PlaceOrder", Account: "dex1b", Before: AuditBalance{Available: 20}, After: AuditBalance{Available: 5, Frozen: 15}},
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(t, NewAuditLog(&buf).write(entries))
+	read, err := ReadAuditLog(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, entries, read)
+
+	a, b := "Liabilities:DEX1A:", "Liabilities:DEX1B:"
+	txns := LedgerTxns(entries)
+	assert.Equal(t, []LedgerTxn{
+		{Round: 1, Txn: "aa", Reason: AuditFee, Postings: []LedgerPosting{
+			{Account: a + "Available", Debit: NewUint128(10)},
+			{Account: "Equity:Clearing:Fee", Credit: NewUint128(10)},
+		}},
+		// the transfer is balanced without the clearing account.
+		{Round: 1, Txn: "aa", Reason: "SendToken", Postings: []LedgerPosting{
+			{Account: a + "Available", Debit: NewUint128(20)},
+			{Account: b + "Available", Credit: NewUint128(20)},
+		}},
+		{Round: 2, Txn: "bb", Reason: "PlaceOrder", Postings: []LedgerPosting{
+			{Account: b + "Available", Debit: NewUint128(15)},
+			{Account: b + "Frozen", Credit: NewUint128(15)},
+		}},
+	}, txns)
+
+	buf.Reset()
+	assert.Nil(t, WriteLedgerCSV(&buf, txns[:1], map[TokenID]TokenSymbol{0: "BNB"}))
+	assert.Equal(t, `round,txn,reason,account,token,debit,credit
+1,aa,fee,Liabilities:DEX1A:Available,BNB,10,0
+1,aa,fee,Equity:Clearing:Fee,BNB,0,10
+`, buf.String())
+
+	buf.Reset()
+	date := time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC)
+	assert.Nil(t, WriteBeancount(&buf, txns[:1], map[TokenID]TokenSymbol{0: "ibc/x"}, date))
+	assert.Equal(t, `plugin "beancount.plugins.auto_accounts"
+
+2018-07-01 * "fee" "round 1"
+  round: 1
+  txn: "aa"
+  Liabilities:DEX1A:Available  10 T0
+  Equity:Clearing:Fee  -10 T0
+`, buf.String())
+}
@@ -0,0 +1,193 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// the per round interest rate of borrowing from a lending pool in
+// parts per lendingRateDenominator is lendingBaseRate plus
+// lendingSlopeRate times the utilization of the pool.
+const (
+	lendingBaseRate        = 2
+	lendingSlopeRate       = 40
+	lendingRateDenominator = 1000000000
+)
+
+// LendingPool is the pool of a token that the suppliers deposit to
+// earn interest, and margin accounts borrow from.
+type LendingPool struct {
+	// Supplied is the quantity owned by the suppliers,
+	// including the accrued interest.
+	Supplied uint64
+	Borrowed uint64
+	// Shares is the total shares of the suppliers, a supplier
+	// owns Supplied * shares / Shares of the pool.
+	Shares uint64
+}
+
+// Liquidity returns the quantity that can be borrowed or withdrawn
+// from the pool.
+func (p LendingPool) Liquidity() uint64 {
+	return p.Supplied - p.Borrowed
+}
+
+// Rate returns the per round interest rate of borrowing from the
+// pool in parts per lendingRateDenominator.
+func (p LendingPool) Rate() uint64 {
+	if p.Supplied == 0 {
+		return lendingBaseRate
+	}
+
+	return lendingBaseRate + mulDiv(lendingSlopeRate, p.Borrowed, p.Supplied)
+}
+
+// Value returns the quantity that the shares own.
+func (p LendingPool) Value(shares uint64) uint64 {
+	if p.Shares == 0 {
+		return 0
+	}
+
+	return mulDiv(shares, p.Supplied, p.Shares)
+}
+
+// mulDiv returns a * b / c rounded down.
+func mulDiv(a, b, c uint64) uint64 {
+	var r big.Int
+	r.SetUint64(a)
+	r.Mul(&r, new(big.Int).SetUint64(b))
+	r.Div(&r, new(big.Int).SetUint64(c))
+	return r.Uint64()
+}
+
+// mulDivCeil returns a * b / c rounded up.
+func mulDivCeil(a, b, c uint64) uint64 {
+	var r, m big.Int
+	r.SetUint64(a)
+	r.Mul(&r, new(big.Int).SetUint64(b))
+	r.DivMod(&r, new(big.Int).SetUint64(c), &m)
+	if m.Sign() > 0 {
+		r.Add(&r, big.NewInt(1))
+	}
+	return r.Uint64()
+}
+
+func (t *Transition) supply(owner *Account, txn *SupplyTxn) error {
+	if txn.Quant == 0 {
+		return errors.New("supply quantity should not be 0")
+	}
+
+	if t.tokenCache.Info(txn.TokenID) == zeroInfo {
+		return fmt.Errorf("trying to supply non-existent token: %d", txn.TokenID)
+	}
+
+	addr := owner.PK().Addr()
+	pool := t.state.LendingPool(txn.TokenID)
+	owned := t.state.LendingShares(addr, txn.TokenID)
+	b := owner.Balance(txn.TokenID)
+	if txn.Withdraw {
+		if pool.Supplied < txn.Quant {
+			return txnErrorf(ErrCodeInsufficientBalance, "insufficient supplied token, supplied: %d, withdraw: %d", pool.Value(owned), txn.Quant)
+		}
+
+		shares := mulDivCeil(txn.Quant, pool.Shares, pool.Supplied)
+		if owned < shares {
+			return txnErrorf(ErrCodeInsufficientBalance, "insufficient supplied token, supplied: %d, withdraw: %d", pool.Value(owned), txn.Quant)
+		}
+
+		if pool.Liquidity() < txn.Quant {
+			return txnErrorf(ErrCodeInsufficientBalance, "insufficient lending pool liquidity, liquidity: %d, withdraw: %d", pool.Liquidity(), txn.Quant)
+		}
+
+		owned -= shares
+		pool.Shares -= shares
+		pool.Supplied -= txn.Quant
+		b.Available += txn.Quant
+	} else {
+		if b.Available < txn.Quant {
+			return txnErrorf(ErrCodeInsufficientBalance, "insufficient available token balance, token id: %v, quantity: %d, available: %d", txn.TokenID, txn.Quant, b.Available)
+		}
+
+		shares := txn.Quant
+		if pool.Shares > 0 && pool.Supplied > 0 {
+			shares = mulDiv(txn.Quant, pool.Shares, pool.Supplied)
+		}
+
+		if shares == 0 {
+			return errors.New("supply quantity is too small")
+		}
+
+		owned += shares
+		pool.Shares += shares
+		pool.Supplied += txn.Quant
+		b.Available -= txn.Quant
+	}
+
+	owner.UpdateBalance(txn.TokenID, b)
+	t.state.UpdateLendingShares(addr, txn.TokenID, owned)
+	t.state.UpdateLendingPool(txn.TokenID, pool)
+	return nil
+}
+
+// accrueInterest adds the interest of the round to the debts of the
+// margin accounts, and to the quantity owned by the suppliers of
+// the lending pools.
+func (t *Transition) accrueInterest() {
+	addrs := t.state.MarginAccounts()
+	if len(addrs) == 0 {
+		return
+	}
+
+	pools := make(map[TokenID]*LendingPool)
+	rates := make(map[TokenID]uint64)
+	for _, addr := range addrs {
+		d, ok := t.state.MarginDebt(addr)
+		if !ok {
+			t.logger().Error("can not find margin account debt", "addr", addr)
+			continue
+		}
+
+		changed := false
+		for _, id := range []TokenID{d.Market.Base, d.Market.Quote} {
+			debt := d.debt(id)
+			if *debt == 0 {
+				continue
+			}
+
+			pool, ok := pools[id]
+			if !ok {
+				p := t.state.LendingPool(id)
+				pool = &p
+				pools[id] = pool
+				// the rate of the round is determined
+				// by the pool before the accrual.
+				rates[id] = p.Rate()
+			}
+
+			interest := mulDiv(*debt, rates[id], lendingRateDenominator)
+			if interest == 0 {
+				continue
+			}
+
+			*debt += interest
+			pool.Borrowed += interest
+			pool.Supplied += interest
+			changed = true
+		}
+
+		if changed {
+			t.state.UpdateMarginDebt(addr, d)
+		}
+	}
+
+	ids := make([]TokenID, 0, len(pools))
+	for id := range pools {
+		ids = append(ids, id)
+	}
+	sortTokenIDs(ids)
+
+	for _, id := range ids {
+		t.state.UpdateLendingPool(id, *pools[id])
+	}
+}
@@ -0,0 +1,61 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLendingPoolRate(t *testing.T) {
+	assert.Equal(t, lendingBaseRate, int(LendingPool{}.Rate()))
+	p := LendingPool{Supplied: 100, Borrowed: 50}
+	assert.Equal(t, lendingBaseRate+lendingSlopeRate/2, int(p.Rate()))
+}
+
+func TestLendingInterest(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	market := MarketSymbol{Base: 0, Quote: 1}
+	s.UpdateRefPrice(market, RefPrice{Price: uint64(math.Pow10(OrderPriceDecimals))})
+	pkLender, skLender := RandKeyPair()
+	pk, sk := RandKeyPair()
+	const supply = 1000000000000
+	s.NewAccount(pkLender).UpdateBalance(1, Balance{Available: supply})
+	s.NewAccount(pk).UpdateBalance(1, Balance{Available: supply})
+	pker := &myPKer{m: map[consensus.Addr]PK{
+		pkLender.Addr(): pkLender,
+		pk.Addr():       pk,
+	}}
+	addr := pk.Addr()
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeSupplyTxn(skLender, pkLender.Addr(), SupplyTxn{TokenID: 1, Quant: supply}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeMarginTransferTxn(sk, addr, MarginTransferTxn{Market: market, TokenID: 1, Quant: supply}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeMarginBorrowTxn(sk, addr, MarginBorrowTxn{Market: market, TokenID: 1, Quant: supply / 2}, 1), pker))
+	s = trans.Commit().(*State)
+
+	// the borrow is recorded before the accrual of the round
+	rate := LendingPool{Supplied: supply, Borrowed: supply / 2}.Rate()
+	interest := supply / 2 * rate / lendingRateDenominator
+	assert.NotEqual(t, 0, int(interest))
+	d, _ := s.MarginDebt(MarginAddr(addr, market))
+	assert.Equal(t, supply/2+interest, d.QuoteDebt)
+	pool := s.LendingPool(1)
+	assert.Equal(t, supply+interest, pool.Supplied)
+	assert.Equal(t, supply/2+interest, pool.Borrowed)
+
+	// the supplier earns the interest
+	shares := s.LendingShares(pkLender.Addr(), 1)
+	assert.Equal(t, supply+interest, pool.Value(shares))
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	err := recordTxn(t, trans, MakeSupplyTxn(skLender, pkLender.Addr(), SupplyTxn{TokenID: 1, Quant: supply, Withdraw: true}, 1), pker)
+	assert.Contains(t, err.Error(), "liquidity")
+	assert.Nil(t, recordTxn(t, trans, MakeSupplyTxn(skLender, pkLender.Addr(), SupplyTxn{TokenID: 1, Quant: supply / 4, Withdraw: true}, 1), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, supply/4, int(s.Account(pkLender.Addr()).Balance(1).Available))
+}
@@ -0,0 +1,462 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+const (
+	// marginInitialRatio is the minimum ratio in percent of the
+	// margin account's assets to debts after borrowing or
+	// withdrawing, e.g., 150 allows up to 3x leverage.
+	marginInitialRatio = 150
+	// marginMaintenanceRatio is the ratio in percent of the
+	// margin account's assets to debts below which the margin
+	// account is liquidated.
+	marginMaintenanceRatio = 110
+	// liquidationSlippage is the percentage from the reference
+	// price that the liquidation orders are placed at.
+	liquidationSlippage = 5
+)
+
+// MarginDebt is the debt of a margin account. A margin account is an
+// isolated account per owner and market, it holds the collateral
+// and the borrowed tokens of the market's base and quote tokens,
+// and places orders like a normal account.
+type MarginDebt struct {
+	Owner       consensus.Addr
+	Market      MarketSymbol
+	BaseDebt    uint64
+	QuoteDebt   uint64
+	Liquidating bool
+}
+
+func (d *MarginDebt) debt(id TokenID) *uint64 {
+	if id == d.Market.Base {
+		return &d.BaseDebt
+	}
+	return &d.QuoteDebt
+}
+
+// Empty returns true if the margin account has no debt.
+func (d *MarginDebt) Empty() bool {
+	return d.BaseDebt == 0 && d.QuoteDebt == 0
+}
+
+// marginPK returns the public key of the owner's margin account of
+// the market. The key does not have a corresponding secret key, the
+// margin account is controlled by its owner's transactions.
+func marginPK(owner consensus.Addr, m MarketSymbol) PK {
+	pk := append([]byte("margin"), owner[:]...)
+	return PK(append(pk, m.Encode()...))
+}
+
+// MarginAddr returns the address of the owner's margin account of
+// the market.
+func MarginAddr(owner consensus.Addr, m MarketSymbol) consensus.Addr {
+	return marginPK(owner, m).Addr()
+}
+
+func calcBaseQuant(quoteQuantUnit uint64, quoteDecimals uint8, priceQuantUnit uint64, priceDecimals, baseDecimals uint8) uint64 {
+	if priceQuantUnit == 0 {
+		return 0
+	}
+
+	var result big.Int
+	var v big.Int
+	result.SetUint64(quoteQuantUnit)
+	v.SetUint64(uint64(math.Pow10(int(baseDecimals))))
+	result.Mul(&result, &v)
+	v.SetUint64(uint64(math.Pow10(int(priceDecimals))))
+	result.Mul(&result, &v)
+	v.SetUint64(priceQuantUnit)
+	result.Div(&result, &v)
+	v.SetUint64(uint64(math.Pow10(int(quoteDecimals))))
+	result.Div(&result, &v)
+	return result.Uint64()
+}
+
+// ratioAtLeast returns true if assets / debts >= percent / 100.
+func ratioAtLeast(assets, debts, percent uint64) bool {
+	var a, d big.Int
+	a.SetUint64(assets)
+	a.Mul(&a, big.NewInt(100))
+	d.SetUint64(debts)
+	d.Mul(&d, new(big.Int).SetUint64(percent))
+	return a.Cmp(&d) >= 0
+}
+
+// marginValue returns the value of the margin account's assets and
+// debts in the quote token at the given price.
+func (t *Transition) marginValue(acc *Account, d MarginDebt, price uint64) (assets, debts uint64) {
+	baseInfo := t.tokenCache.Info(d.Market.Base)
+	quoteInfo := t.tokenCache.Info(d.Market.Quote)
+	base := acc.Balance(d.Market.Base)
+	quote := acc.Balance(d.Market.Quote)
+	assets = quote.Available + quote.Pending + calcQuoteQuant(base.Available+base.Pending, quoteInfo.Decimals, price, OrderPriceDecimals, baseInfo.Decimals)
+	debts = d.QuoteDebt + calcQuoteQuant(d.BaseDebt, quoteInfo.Decimals, price, OrderPriceDecimals, baseInfo.Decimals)
+	return
+}
+
+// marginHealthy returns true if the margin account's ratio of assets
+// to debts is at least the given ratio at the market's reference
+// price.
+func (t *Transition) marginHealthy(acc *Account, d MarginDebt, ratio uint64) (bool, error) {
+	if d.Empty() {
+		return true, nil
+	}
+
+	ref, ok := t.state.RefPrice(d.Market)
+	if !ok {
+		return false, fmt.Errorf("market %v has no reference price", d.Market)
+	}
+
+	assets, debts := t.marginValue(acc, d, ref.Price)
+	return ratioAtLeast(assets, debts, ratio), nil
+}
+
+// marginAccount returns the owner's margin account of the market
+// and its debt, a new margin account is created if create is true.
+func (t *Transition) marginAccount(owner consensus.Addr, m MarketSymbol, create bool) (*Account, MarginDebt, error) {
+	addr := MarginAddr(owner, m)
+	d, ok := t.state.MarginDebt(addr)
+	if ok {
+		return t.state.Account(addr), d, nil
+	}
+
+	if !create {
+		return nil, d, fmt.Errorf("margin account of market %v does not exist", m)
+	}
+
+	d = MarginDebt{Owner: owner, Market: m}
+	t.state.UpdateMarginDebt(addr, d)
+	return t.state.NewAccount(marginPK(owner, m)), d, nil
+}
+
+func (t *Transition) marginTransfer(owner *Account, txn *MarginTransferTxn) error {
+	if !txn.Market.Valid() {
+		return txnErrorf(ErrCodeBadMarket, "margin account's market is invalid: %v", txn.Market)
+	}
+
+	if txn.TokenID != txn.Market.Base && txn.TokenID != txn.Market.Quote {
+		return fmt.Errorf("token %d is not traded in market %v", txn.TokenID, txn.Market)
+	}
+
+	if txn.Quant == 0 {
+		return errors.New("transfer quantity should not be 0")
+	}
+
+	if txn.Withdraw {
+		acc, d, err := t.marginAccount(owner.PK().Addr(), txn.Market, false)
+		if err != nil {
+			return err
+		}
+
+		if d.Liquidating {
+			return errors.New("margin account is being liquidated")
+		}
+
+		b := acc.Balance(txn.TokenID)
+		if b.Available < txn.Quant {
+			return txnErrorf(ErrCodeInsufficientBalance, "insufficient margin account balance, token id: %v, quantity: %d, available: %d", txn.TokenID, txn.Quant, b.Available)
+		}
+
+		b.Available -= txn.Quant
+		acc.UpdateBalance(txn.TokenID, b)
+		healthy, err := t.marginHealthy(acc, d, marginInitialRatio)
+		if err == nil && !healthy {
+			err = errors.New("withdrawal would make the margin account undercollateralized")
+		}
+
+		if err != nil {
+			b.Available += txn.Quant
+			acc.UpdateBalance(txn.TokenID, b)
+			return err
+		}
+
+		ob := owner.Balance(txn.TokenID)
+		ob.Available += txn.Quant
+		owner.UpdateBalance(txn.TokenID, ob)
+		return nil
+	}
+
+	if t.tokenCache.Info(txn.Market.Base) == zeroInfo || t.tokenCache.Info(txn.Market.Quote) == zeroInfo {
+		return fmt.Errorf("trying to open margin account on market with nonexistent token: %v", txn.Market)
+	}
+
+	ob := owner.Balance(txn.TokenID)
+	if ob.Available < txn.Quant {
+		return txnErrorf(ErrCodeInsufficientBalance, "insufficient available token balance, token id: %v, quantity: %d, available: %d", txn.TokenID, txn.Quant, ob.Available)
+	}
+
+	addr := owner.PK().Addr()
+	if err := t.checkTransferThreshold(addr, txn.TokenID, txn.Quant); err != nil {
+		return err
+	}
+
+	acc, _, err := t.marginAccount(addr, txn.Market, true)
+	if err != nil {
+		return err
+	}
+
+	ob.Available -= txn.Quant
+	owner.UpdateBalance(txn.TokenID, ob)
+	t.addOutflow(addr, txn.TokenID, txn.Quant)
+	b := acc.Balance(txn.TokenID)
+	b.Available += txn.Quant
+	acc.UpdateBalance(txn.TokenID, b)
+	return nil
+}
+
+func (t *Transition) marginBorrow(owner *Account, txn *MarginBorrowTxn) error {
+	if txn.TokenID != txn.Market.Base && txn.TokenID != txn.Market.Quote {
+		return fmt.Errorf("token %d is not traded in market %v", txn.TokenID, txn.Market)
+	}
+
+	if txn.Quant == 0 {
+		return errors.New("borrow quantity should not be 0")
+	}
+
+	acc, d, err := t.marginAccount(owner.PK().Addr(), txn.Market, false)
+	if err != nil {
+		return err
+	}
+
+	if txn.Repay {
+		debt := *d.debt(txn.TokenID)
+		if debt < txn.Quant {
+			return fmt.Errorf("repay quantity is greater than the debt, debt: %d, repay: %d", debt, txn.Quant)
+		}
+
+		b := acc.Balance(txn.TokenID)
+		if b.Available < txn.Quant {
+			return txnErrorf(ErrCodeInsufficientBalance, "insufficient margin account balance, token id: %v, quantity: %d, available: %d", txn.TokenID, txn.Quant, b.Available)
+		}
+
+		t.repayMargin(acc, &d, txn.TokenID, txn.Quant)
+		t.updateMarginDebt(acc, d)
+		return nil
+	}
+
+	if d.Liquidating {
+		return errors.New("margin account is being liquidated")
+	}
+
+	pool := t.state.LendingPool(txn.TokenID)
+	if pool.Liquidity() < txn.Quant {
+		return txnErrorf(ErrCodeInsufficientBalance, "insufficient lending pool liquidity, liquidity: %d, borrow: %d", pool.Liquidity(), txn.Quant)
+	}
+
+	b := acc.Balance(txn.TokenID)
+	b.Available += txn.Quant
+	acc.UpdateBalance(txn.TokenID, b)
+	*d.debt(txn.TokenID) += txn.Quant
+	healthy, err := t.marginHealthy(acc, d, marginInitialRatio)
+	if err == nil && !healthy {
+		err = errors.New("borrow would make the margin account undercollateralized")
+	}
+
+	if err != nil {
+		b.Available -= txn.Quant
+		acc.UpdateBalance(txn.TokenID, b)
+		return err
+	}
+
+	pool.Borrowed += txn.Quant
+	t.state.UpdateLendingPool(txn.TokenID, pool)
+	t.updateMarginDebt(acc, d)
+	return nil
+}
+
+func (t *Transition) marginOrder(owner *Account, txn *MarginOrderTxn) error {
+	acc, d, err := t.marginAccount(owner.PK().Addr(), txn.Market, false)
+	if err != nil {
+		return err
+	}
+
+	if d.Liquidating {
+		return errors.New("margin account is being liquidated")
+	}
+
+	return t.placeOrder(acc, &txn.PlaceOrderTxn, t.round)
+}
+
+func (t *Transition) marginCancelOrder(owner *Account, txn *MarginCancelOrderTxn) error {
+	acc, _, err := t.marginAccount(owner.PK().Addr(), txn.ID.Market, false)
+	if err != nil {
+		return err
+	}
+
+	return t.cancelOrder(acc, &CancelOrderTxn{ID: txn.ID})
+}
+
+// repayMargin repays the margin account's debt of the token from
+// its available balance.
+func (t *Transition) repayMargin(acc *Account, d *MarginDebt, id TokenID, quant uint64) {
+	b := acc.Balance(id)
+	b.Available -= quant
+	acc.UpdateBalance(id, b)
+	*d.debt(id) -= quant
+
+	pool := t.state.LendingPool(id)
+	pool.Borrowed -= quant
+	t.state.UpdateLendingPool(id, pool)
+}
+
+// updateMarginDebt saves the margin account's debt, and keeps the
+// index of the margin accounts with debt up to date.
+func (t *Transition) updateMarginDebt(acc *Account, d MarginDebt) {
+	addr := acc.PK().Addr()
+	if d.Empty() {
+		d.Liquidating = false
+		t.state.RemoveMarginAccount(addr)
+	} else {
+		t.state.AddMarginAccount(addr)
+	}
+	t.state.UpdateMarginDebt(addr, d)
+}
+
+// liquidateMargins liquidates the margin accounts whose ratio of
+// assets to debts at the reference price falls below
+// marginMaintenanceRatio. A margin account under liquidation stays
+// under liquidation until its debt is fully repaid.
+func (t *Transition) liquidateMargins() {
+	for _, addr := range t.state.MarginAccounts() {
+		d, ok := t.state.MarginDebt(addr)
+		if !ok {
+			t.logger().Error("can not find margin account debt", "addr", addr)
+			continue
+		}
+
+		// the margin account of a blocked owner is frozen.
+		if t.checkBlocked(d.Owner) != nil {
+			continue
+		}
+
+		ref, ok := t.state.RefPrice(d.Market)
+		if !ok {
+			continue
+		}
+
+		acc := t.state.Account(addr)
+		if !d.Liquidating {
+			assets, debts := t.marginValue(acc, d, ref.Price)
+			if ratioAtLeast(assets, debts, marginMaintenanceRatio) {
+				continue
+			}
+
+			t.logger().Info("liquidating margin account", "owner", d.Owner, "market", d.Market, "assets", assets, "debts", debts)
+			d.Liquidating = true
+		}
+
+		t.liquidate(acc, &d, ref.Price)
+		t.updateMarginDebt(acc, d)
+	}
+}
+
+// liquidationPrice returns the price liquidationSlippage above the
+// price for a buy order, or below it for a sell order. The buy price
+// is capped at the max price, the loosest limit of a buy order.
+func liquidationPrice(price uint64, buy bool) uint64 {
+	if !buy {
+		return mulDiv(price, 100-liquidationSlippage, 100)
+	}
+
+	if price > math.MaxUint64/(100+liquidationSlippage)*100 {
+		return math.MaxUint64
+	}
+
+	return mulDiv(price, 100+liquidationSlippage, 100)
+}
+
+// liquidate cancels the margin account's pending orders, repays the
+// debt with the holdings, and places orders at liquidationSlippage
+// from the reference price to convert the remaining holdings to
+// repay the remaining debt.
+func (t *Transition) liquidate(acc *Account, d *MarginDebt, price uint64) {
+	m := d.Market
+	t.cancelPendingOrders(acc)
+
+	t.repayHoldings(acc, d)
+	baseInfo := t.tokenCache.Info(m.Base)
+	quoteInfo := t.tokenCache.Info(m.Quote)
+	if d.BaseDebt > 0 {
+		p := liquidationPrice(price, true)
+		quant := calcBaseQuant(acc.Balance(m.Quote).Available, quoteInfo.Decimals, p, OrderPriceDecimals, baseInfo.Decimals)
+		if quant > d.BaseDebt {
+			quant = d.BaseDebt
+		}
+
+		if quant > 0 {
+			err := t.placeOrderImpl(acc, &PlaceOrderTxn{Quant: quant, Price: p, Market: m}, t.round)
+			if err != nil {
+				t.logger().Warn("failed to place liquidation order", "owner", d.Owner, "market", m, "err", err)
+			}
+		}
+	} else if d.QuoteDebt > 0 {
+		p := liquidationPrice(price, false)
+		quant := calcBaseQuant(d.QuoteDebt, quoteInfo.Decimals, p, OrderPriceDecimals, baseInfo.Decimals) + 1
+		if available := acc.Balance(m.Base).Available; quant > available {
+			quant = available
+		}
+
+		if quant > 0 {
+			err := t.placeOrderImpl(acc, &PlaceOrderTxn{SellSide: true, Quant: quant, Price: p, Market: m}, t.round)
+			if err != nil {
+				t.logger().Warn("failed to place liquidation order", "owner", d.Owner, "market", m, "err", err)
+			}
+		}
+	}
+
+	// repay with the proceeds of the liquidation orders that
+	// are filled immediately.
+	t.repayHoldings(acc, d)
+
+	if d.Empty() || len(acc.PendingOrders()) > 0 {
+		return
+	}
+
+	// nothing is left to be converted to repay the debt, the
+	// remaining debt is written off and absorbed by the lending
+	// pool.
+	t.logger().Warn("writing off margin account bad debt", "owner", d.Owner, "market", m, "base debt", d.BaseDebt, "quote debt", d.QuoteDebt)
+	for _, id := range []TokenID{m.Base, m.Quote} {
+		debt := *d.debt(id)
+		if debt == 0 {
+			continue
+		}
+
+		pool := t.state.LendingPool(id)
+		pool.Borrowed -= debt
+		pool.Supplied -= debt
+		t.state.UpdateLendingPool(id, pool)
+		*d.debt(id) = 0
+	}
+}
+
+// cancelPendingOrders cancels all the pending orders of the account.
+func (t *Transition) cancelPendingOrders(acc *Account) {
+	for _, o := range acc.PendingOrders() {
+		t.getOrderBook(o.ID.Market).Cancel(o.ID.ID)
+		t.dirtyOrderBooks[o.ID.Market] = true
+		acc.RemovePendingOrder(o.ID)
+		t.refundAfterCancel(acc, o, o.ID.Market)
+	}
+}
+
+func (t *Transition) repayHoldings(acc *Account, d *MarginDebt) {
+	for _, id := range []TokenID{d.Market.Base, d.Market.Quote} {
+		quant := acc.Balance(id).Available
+		if debt := *d.debt(id); quant > debt {
+			quant = debt
+		}
+
+		if quant > 0 {
+			t.repayMargin(acc, d, id, quant)
+		}
+	}
+}
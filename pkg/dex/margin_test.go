@@ -0,0 +1,126 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func recordTxn(t *testing.T, trans *Transition, b []byte, pker *myPKer) error {
+	pt, err := parseTxn(b, pker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return trans.Record(pt)
+}
+
+func TestMarginBorrow(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	market := MarketSymbol{Base: 0, Quote: 1}
+	s.UpdateRefPrice(market, RefPrice{Price: uint64(math.Pow10(OrderPriceDecimals))})
+	pkLender, skLender := RandKeyPair()
+	pk, sk := RandKeyPair()
+	s.NewAccount(pkLender).UpdateBalance(1, Balance{Available: 1000})
+	s.NewAccount(pk).UpdateBalance(1, Balance{Available: 100})
+	pker := &myPKer{m: map[consensus.Addr]PK{
+		pkLender.Addr(): pkLender,
+		pk.Addr():       pk,
+	}}
+	addr := pk.Addr()
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeSupplyTxn(skLender, pkLender.Addr(), SupplyTxn{TokenID: 1, Quant: 1000}, 0), pker))
+	assert.NotNil(t, recordTxn(t, trans, MakeMarginBorrowTxn(sk, addr, MarginBorrowTxn{Market: market, TokenID: 1, Quant: 10}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeMarginTransferTxn(sk, addr, MarginTransferTxn{Market: market, TokenID: 1, Quant: 100}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeMarginBorrowTxn(sk, addr, MarginBorrowTxn{Market: market, TokenID: 1, Quant: 150}, 1), pker))
+	// 350 / 250 is below the initial ratio
+	err := recordTxn(t, trans, MakeMarginBorrowTxn(sk, addr, MarginBorrowTxn{Market: market, TokenID: 1, Quant: 100}, 2), pker)
+	assert.Contains(t, err.Error(), "undercollateralized")
+	err = recordTxn(t, trans, MakeMarginTransferTxn(sk, addr, MarginTransferTxn{Market: market, TokenID: 1, Quant: 50, Withdraw: true}, 2), pker)
+	assert.Contains(t, err.Error(), "undercollateralized")
+	s = trans.Commit().(*State)
+
+	marginAddr := MarginAddr(addr, market)
+	assert.Equal(t, 250, int(s.Account(marginAddr).Balance(1).Available))
+	d, ok := s.MarginDebt(marginAddr)
+	assert.True(t, ok)
+	assert.Equal(t, 150, int(d.QuoteDebt))
+	assert.Equal(t, []consensus.Addr{marginAddr}, s.MarginAccounts())
+	assert.Equal(t, LendingPool{Supplied: 1000, Borrowed: 150, Shares: 1000}, s.LendingPool(1))
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeMarginBorrowTxn(sk, addr, MarginBorrowTxn{Market: market, TokenID: 1, Quant: 150, Repay: true}, 2), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeMarginTransferTxn(sk, addr, MarginTransferTxn{Market: market, TokenID: 1, Quant: 100, Withdraw: true}, 3), pker))
+	s = trans.Commit().(*State)
+
+	assert.Equal(t, 0, len(s.MarginAccounts()))
+	assert.Equal(t, LendingPool{Supplied: 1000, Shares: 1000}, s.LendingPool(1))
+	assert.Equal(t, 100, int(s.Account(addr).Balance(1).Available))
+}
+
+func TestMarginLiquidation(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	market := MarketSymbol{Base: 0, Quote: 1}
+	one := uint64(math.Pow10(OrderPriceDecimals))
+	s.UpdateRefPrice(market, RefPrice{Price: one})
+	pkLender, skLender := RandKeyPair()
+	pk, sk := RandKeyPair()
+	lender := s.NewAccount(pkLender)
+	lender.UpdateBalance(0, Balance{Available: 1000})
+	lender.UpdateBalance(1, Balance{Available: 1000})
+	s.NewAccount(pk).UpdateBalance(1, Balance{Available: 100})
+	pker := &myPKer{m: map[consensus.Addr]PK{
+		pkLender.Addr(): pkLender,
+		pk.Addr():       pk,
+	}}
+	addr := pk.Addr()
+
+	// short 100 base at price 1.0
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeSupplyTxn(skLender, pkLender.Addr(), SupplyTxn{TokenID: 0, Quant: 500}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skLender, pkLender.Addr(), PlaceOrderTxn{Quant: 100, Price: one, Market: market}, 1), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeMarginTransferTxn(sk, addr, MarginTransferTxn{Market: market, TokenID: 1, Quant: 100}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeMarginBorrowTxn(sk, addr, MarginBorrowTxn{Market: market, TokenID: 0, Quant: 100}, 1), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeMarginOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 100, Price: one, Market: market}, 2), pker))
+	s = trans.Commit().(*State)
+
+	marginAddr := MarginAddr(addr, market)
+	assert.Equal(t, 200, int(s.Account(marginAddr).Balance(1).Available))
+
+	// the price moves to 2.0, the margin account is liquidated
+	// against the resting sell order at 2.1.
+	s.UpdateRefPrice(market, RefPrice{Price: 2 * one})
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skLender, pkLender.Addr(), PlaceOrderTxn{SellSide: true, Quant: 100, Price: 21 * one / 10, Market: market}, 2), pker))
+	s = trans.Commit().(*State)
+
+	// 95 base bought for 199 quote and repaid, the remaining
+	// debt of 5 base is written off.
+	acc := s.Account(marginAddr)
+	assert.Equal(t, 0, int(acc.Balance(0).Available))
+	assert.Equal(t, 1, int(acc.Balance(1).Available))
+	assert.Equal(t, 0, len(acc.PendingOrders()))
+	d, _ := s.MarginDebt(marginAddr)
+	assert.True(t, d.Empty())
+	assert.False(t, d.Liquidating)
+	assert.Equal(t, 0, len(s.MarginAccounts()))
+	assert.Equal(t, LendingPool{Supplied: 495, Shares: 500}, s.LendingPool(0))
+}
+
+func TestLiquidationPrice(t *testing.T) {
+	assert.Equal(t, 210, int(liquidationPrice(200, true)))
+	assert.Equal(t, 190, int(liquidationPrice(200, false)))
+
+	// the prices near the max price do not wrap.
+	p := uint64(math.MaxUint64 / 100 * 99)
+	assert.Equal(t, uint64(math.MaxUint64), liquidationPrice(p, true))
+	assert.True(t, liquidationPrice(math.MaxUint64, false) > math.MaxUint64/100*94)
+}
@@ -0,0 +1,217 @@
+package dex
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// mmSpreadDenominator is the denominator of the market maker
+// spreads, the spreads are in parts per million of the mid price.
+const mmSpreadDenominator = 1000000
+
+// MMProgram is the governor's market maker incentive program of a
+// market. In each round, a registered market maker quoting both sides
+// within MaxSpread is up, and scores MaxSpread - spread + 1, so a
+// tighter quote scores more. At the end of each epoch the fee pool
+// distributes Budget of RewardToken to the makers in proportion to
+// their scores, or the pool's balance if it's less.
+type MMProgram struct {
+	RewardToken TokenID
+	// Budget is the reward of each epoch.
+	Budget      uint64
+	EpochRounds uint64
+	// MaxSpread is the max spread between the maker's best bid
+	// and best ask in parts per million of their mid price.
+	MaxSpread uint64
+	// MinQuant is the min quantity of a quoting order.
+	MinQuant uint64
+}
+
+// MMScore is the quoting record of a market maker in an epoch.
+type MMScore struct {
+	Addr consensus.Addr
+	// Uptime is the number of the rounds quoted within the max
+	// spread.
+	Uptime uint64
+	Score  uint64
+}
+
+// MMReward is the reward of a market maker for an epoch.
+type MMReward struct {
+	MMScore
+	Reward uint64
+}
+
+// MMLedger is the market maker incentive ledger of a market.
+type MMLedger struct {
+	// Makers is the registered market makers with their record
+	// of the current epoch, sorted by address.
+	Makers []MMScore
+	// Epoch is the last distributed epoch, Rewards is its
+	// rewards.
+	Epoch   uint64
+	Rewards []MMReward
+}
+
+func (p MMProgram) valid() error {
+	if p.EpochRounds == 0 {
+		return errors.New("epoch rounds is 0")
+	}
+
+	if p.MaxSpread == 0 || p.MaxSpread >= mmSpreadDenominator {
+		return fmt.Errorf("max spread %d should be between 1 and %d", p.MaxSpread, mmSpreadDenominator-1)
+	}
+
+	return nil
+}
+
+func (t *Transition) setMMProgram(owner *Account, txn *SetMMProgramTxn) error {
+	if err := t.checkGovernor(owner); err != nil {
+		return err
+	}
+
+	if !txn.Market.Valid() {
+		return txnErrorf(ErrCodeBadMarket, "market is invalid: %v", txn.Market)
+	}
+
+	if txn.Program == (MMProgram{}) {
+		t.state.RemoveMMProgram(txn.Market)
+		return nil
+	}
+
+	if err := txn.Program.valid(); err != nil {
+		return err
+	}
+
+	if t.tokenCache.Info(txn.Program.RewardToken) == zeroInfo {
+		return fmt.Errorf("reward token %d does not exist", txn.Program.RewardToken)
+	}
+
+	t.state.UpdateMMProgram(txn.Market, txn.Program)
+	return nil
+}
+
+func (t *Transition) registerMarketMaker(owner *Account, txn *RegisterMarketMakerTxn) error {
+	if _, ok := t.state.MMProgram(txn.Market); !ok {
+		return fmt.Errorf("market %v has no market maker program", txn.Market)
+	}
+
+	addr := owner.PK().Addr()
+	l := t.state.MMLedger(txn.Market)
+	i := sort.Search(len(l.Makers), func(i int) bool { return bytes.Compare(l.Makers[i].Addr[:], addr[:]) >= 0 })
+	if i < len(l.Makers) && l.Makers[i].Addr == addr {
+		return errors.New("market maker is already registered")
+	}
+
+	l.Makers = append(l.Makers, MMScore{})
+	copy(l.Makers[i+1:], l.Makers[i:])
+	l.Makers[i] = MMScore{Addr: addr}
+	t.state.UpdateMMLedger(txn.Market, l)
+	return nil
+}
+
+// mmSpread returns the spread of the best bid and best ask in parts
+// per million of their mid price.
+func mmSpread(bid, ask uint64) uint64 {
+	return mulDiv(ask-bid, 2*mmSpreadDenominator, ask+bid)
+}
+
+// quotes returns the best bid and ask price of the owners' orders of
+// at least minQuant.
+func (o *orderBook) quotes(minQuant uint64) (bids, asks map[consensus.Addr]uint64) {
+	side := func(p *pricePoint) map[consensus.Addr]uint64 {
+		r := make(map[consensus.Addr]uint64)
+		for ; p != nil; p = p.NextPoint {
+			for e := p.ListHead; e != nil; e = e.Next {
+				if e.Quant == 0 || e.Quant < minQuant {
+					continue
+				}
+
+				if _, ok := r[e.Owner]; !ok {
+					r[e.Owner] = p.Price
+				}
+			}
+		}
+		return r
+	}
+	return side(o.bidMax), side(o.askMin)
+}
+
+// measureMarketMakers records the quotes of the registered market
+// makers at the end of the round, and distributes the rewards at the
+// end of the epochs.
+func (t *Transition) measureMarketMakers() {
+	for _, m := range t.state.MMProgramMarkets() {
+		p, _ := t.state.MMProgram(m)
+		l := t.state.MMLedger(m)
+		if len(l.Makers) == 0 {
+			continue
+		}
+
+		bids, asks := t.getOrderBook(m).quotes(p.MinQuant)
+		for i := range l.Makers {
+			s := &l.Makers[i]
+			bid, okBid := bids[s.Addr]
+			ask, okAsk := asks[s.Addr]
+			if !okBid || !okAsk {
+				continue
+			}
+
+			spread := mmSpread(bid, ask)
+			if spread > p.MaxSpread {
+				continue
+			}
+
+			s.Uptime++
+			s.Score += p.MaxSpread - spread + 1
+		}
+
+		if t.round%p.EpochRounds == 0 {
+			t.distributeMMRewards(m, p, &l)
+		}
+		t.state.UpdateMMLedger(m, l)
+	}
+}
+
+func (t *Transition) distributeMMRewards(m MarketSymbol, p MMProgram, l *MMLedger) {
+	var total uint64
+	for _, s := range l.Makers {
+		total += s.Score
+	}
+
+	budget := p.Budget
+	pool := t.state.Account(FeePoolAddr())
+	if pool == nil {
+		budget = 0
+	} else if b := pool.Balance(p.RewardToken); b.Available < budget {
+		budget = b.Available
+	}
+
+	l.Epoch = t.round / p.EpochRounds
+	l.Rewards = l.Rewards[:0]
+	var paid uint64
+	for i, s := range l.Makers {
+		var reward uint64
+		if total > 0 {
+			reward = mulDiv(budget, s.Score, total)
+		}
+
+		if reward > 0 {
+			credit(t.state.Account(s.Addr), p.RewardToken, reward)
+			paid += reward
+		}
+		l.Rewards = append(l.Rewards, MMReward{MMScore: s, Reward: reward})
+		l.Makers[i] = MMScore{Addr: s.Addr}
+	}
+
+	if paid > 0 {
+		b := pool.Balance(p.RewardToken)
+		b.Available -= paid
+		pool.UpdateBalance(p.RewardToken, b)
+		t.logger().Info("market maker rewards distributed", "market", m, "epoch", l.Epoch, "token", p.RewardToken, "reward", paid)
+	}
+}
@@ -0,0 +1,79 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarketMakerProgram(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	one := uint64(math.Pow10(OrderPriceDecimals))
+	market := MarketSymbol{Base: 1, Quote: 0}
+	pkGov, skGov := RandKeyPair()
+	pkA, skA := RandKeyPair()
+	pkB, skB := RandKeyPair()
+	s.NewAccount(pkGov)
+	for _, pk := range []PK{pkA, pkB} {
+		acc := s.NewAccount(pk)
+		acc.UpdateBalance(0, Balance{Available: 1000000000})
+		acc.UpdateBalance(1, Balance{Available: 1000000000})
+	}
+	s.NewAccount(feePoolPK).UpdateBalance(0, Balance{Available: 1000})
+	s.UpdateGovernor(pkGov.Addr())
+	pker := &myPKer{m: map[consensus.Addr]PK{
+		pkGov.Addr(): pkGov,
+		pkA.Addr():   pkA,
+		pkB.Addr():   pkB,
+	}}
+	a, b := pkA.Addr(), pkB.Addr()
+
+	program := MMProgram{RewardToken: 0, Budget: 900, EpochRounds: 2, MaxSpread: 20000, MinQuant: 10}
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.NotNil(t, recordTxn(t, trans, MakeRegisterMarketMakerTxn(skA, a, RegisterMarketMakerTxn{Market: market}, 0), pker), "no program")
+	assert.NotNil(t, recordTxn(t, trans, MakeSetMMProgramTxn(skA, a, SetMMProgramTxn{Market: market, Program: program}, 0), pker), "not the governor")
+	assert.Nil(t, recordTxn(t, trans, MakeSetMMProgramTxn(skGov, pkGov.Addr(), SetMMProgramTxn{Market: market, Program: program}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeRegisterMarketMakerTxn(skA, a, RegisterMarketMakerTxn{Market: market}, 0), pker))
+	assert.NotNil(t, recordTxn(t, trans, MakeRegisterMarketMakerTxn(skA, a, RegisterMarketMakerTxn{Market: market}, 1), pker), "already registered")
+	assert.Nil(t, recordTxn(t, trans, MakeRegisterMarketMakerTxn(skB, b, RegisterMarketMakerTxn{Market: market}, 0), pker))
+
+	quote := func(sk SK, addr consensus.Addr, bid, ask uint64, nonce uint64) {
+		assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{Quant: 1000, Price: bid, Market: market}, nonce), pker))
+		assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 1000, Price: ask, Market: market}, nonce+1), pker))
+	}
+	// a quotes a 2% spread, b a 1% spread.
+	quote(skA, a, one*99/100, one*101/100, 1)
+	quote(skB, b, one*995/1000, one*1005/1000, 1)
+	s = trans.Commit().(*State)
+
+	l := s.MMLedger(market)
+	assert.Equal(t, 2, len(l.Makers))
+	for _, m := range l.Makers {
+		assert.Equal(t, uint64(1), m.Uptime)
+	}
+
+	// the epoch ends at round 2.
+	trans = s.Transition(2, 0, nil).(*Transition)
+	s = trans.Commit().(*State)
+
+	var r MMLedgerState
+	assert.Nil(t, queryMMLedger(s, MMLedgerArgs{Market: market}, &r))
+	assert.True(t, r.Enabled)
+	assert.Equal(t, uint64(1), r.Ledger.Epoch)
+	rewards := make(map[consensus.Addr]MMReward)
+	for _, reward := range r.Ledger.Rewards {
+		rewards[reward.Addr] = reward
+	}
+	assert.Equal(t, MMReward{MMScore: MMScore{Addr: a, Uptime: 2, Score: 2}, Reward: 0}, rewards[a])
+	assert.Equal(t, MMReward{MMScore: MMScore{Addr: b, Uptime: 2, Score: 20002}, Reward: 899}, rewards[b])
+	assert.Equal(t, 1000000000-1000*995/1000+899, int(s.Account(b).Balance(0).Available))
+	assert.Equal(t, 101, int(s.Account(FeePoolAddr()).Balance(0).Available))
+	for _, m := range r.Ledger.Makers {
+		assert.Equal(t, MMScore{Addr: m.Addr}, m, "the scores are reset for the next epoch")
+	}
+}
@@ -0,0 +1,155 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// OracleConfig is the whitelist of the oracles that post the
+// external prices of the tokens. The price of a token is updated in
+// a round only if at least Quorum oracles report it.
+type OracleConfig struct {
+	Oracles []consensus.Addr
+	Quorum  uint64
+}
+
+func (c *OracleConfig) isOracle(addr consensus.Addr) bool {
+	for _, o := range c.Oracles {
+		if o == addr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OracleReport is an oracle's price of a token in USD with
+// OrderPriceDecimals decimals.
+type OracleReport struct {
+	TokenID TokenID
+	Price   uint64
+}
+
+// OraclePrice is the median of the oracles' prices of a token in
+// the round that it's last updated.
+type OraclePrice struct {
+	Price   uint64
+	Round   uint64
+	Reports uint64
+}
+
+// median returns the median of the prices, the mean of the two
+// middle prices if the number of prices is even.
+func median(prices []uint64) uint64 {
+	if len(prices) == 0 {
+		return 0
+	}
+
+	sorted := make([]uint64, len(prices))
+	copy(sorted, prices)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+
+	return sorted[mid-1] + (sorted[mid]-sorted[mid-1])/2
+}
+
+func (t *Transition) setOracles(owner *Account, txn *SetOraclesTxn) error {
+	if err := t.checkGovernor(owner); err != nil {
+		return err
+	}
+
+	if txn.Quorum == 0 || txn.Quorum > uint64(len(txn.Oracles)) {
+		return fmt.Errorf("quorum %d should be between 1 and the number of oracles %d", txn.Quorum, len(txn.Oracles))
+	}
+
+	t.state.UpdateOracleConfig(OracleConfig{Oracles: txn.Oracles, Quorum: txn.Quorum})
+	return nil
+}
+
+func (t *Transition) reportOraclePrices(owner *Account, txn *ReportPricesTxn) error {
+	if len(txn.Reports) == 0 {
+		return errors.New("no price is reported")
+	}
+
+	addr := owner.PK().Addr()
+	cfg := t.state.OracleConfig()
+	if !cfg.isOracle(addr) {
+		return errors.New("only the whitelisted oracles can report prices")
+	}
+
+	if t.oracleReporters[addr] {
+		return errors.New("oracle already reported in the current round")
+	}
+
+	seen := make(map[TokenID]bool)
+	for _, r := range txn.Reports {
+		if t.tokenCache.Info(r.TokenID) == zeroInfo {
+			return fmt.Errorf("trying to report the price of non-existent token: %d", r.TokenID)
+		}
+
+		if r.Price == 0 {
+			return fmt.Errorf("reported price of token %d is 0", r.TokenID)
+		}
+
+		if seen[r.TokenID] {
+			return fmt.Errorf("price of token %d is reported twice", r.TokenID)
+		}
+		seen[r.TokenID] = true
+	}
+
+	for _, r := range txn.Reports {
+		t.oracleReports[r.TokenID] = append(t.oracleReports[r.TokenID], r.Price)
+	}
+	t.oracleReporters[addr] = true
+	return nil
+}
+
+// medianizeOraclePrices updates the oracle prices of the tokens
+// reported by at least the quorum of the oracles in the round.
+func (t *Transition) medianizeOraclePrices() {
+	if len(t.oracleReports) == 0 {
+		return
+	}
+
+	ids := make([]TokenID, 0, len(t.oracleReports))
+	for id := range t.oracleReports {
+		ids = append(ids, id)
+	}
+	sortTokenIDs(ids)
+
+	cfg := t.state.OracleConfig()
+	for _, id := range ids {
+		prices := t.oracleReports[id]
+		if uint64(len(prices)) < cfg.Quorum {
+			continue
+		}
+
+		t.state.UpdateOraclePrice(id, OraclePrice{
+			Price:   median(prices),
+			Round:   t.round,
+			Reports: uint64(len(prices)),
+		})
+	}
+}
+
+// usdValue returns the value of the token quantity in USD with
+// OrderPriceDecimals decimals at the token's oracle price, 0 is
+// returned if the token has no oracle price.
+func (t *Transition) usdValue(id TokenID, quant uint64) uint64 {
+	p, ok := t.state.OraclePrice(id)
+	if !ok {
+		return 0
+	}
+
+	info := t.tokenCache.Info(id)
+	return calcQuoteQuant(quant, OrderPriceDecimals, p.Price, OrderPriceDecimals, info.Decimals)
+}
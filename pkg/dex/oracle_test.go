@@ -0,0 +1,68 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMedian(t *testing.T) {
+	assert.Equal(t, 0, int(median(nil)))
+	assert.Equal(t, 5, int(median([]uint64{5})))
+	assert.Equal(t, 5, int(median([]uint64{9, 1, 5})))
+	assert.Equal(t, 4, int(median([]uint64{9, 1, 3, 5})))
+}
+
+func TestOraclePrice(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pker := &myPKer{m: make(map[consensus.Addr]PK)}
+	pks := make([]PK, 4)
+	sks := make([]SK, 4)
+	for i := range pks {
+		pks[i], sks[i] = RandKeyPair()
+		s.NewAccount(pks[i])
+		pker.m[pks[i].Addr()] = pks[i]
+	}
+	governor := pks[3].Addr()
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	oracles := SetOraclesTxn{Oracles: []consensus.Addr{pks[0].Addr(), pks[1].Addr(), pks[2].Addr()}, Quorum: 2}
+	err := recordTxn(t, trans, MakeSetOraclesTxn(sks[3], governor, oracles, 0), pker)
+	assert.Contains(t, err.Error(), "not enabled")
+
+	s.UpdateGovernor(governor)
+	trans = s.Transition(1, 0, nil).(*Transition)
+	err = recordTxn(t, trans, MakeSetOraclesTxn(sks[0], pks[0].Addr(), oracles, 0), pker)
+	assert.Contains(t, err.Error(), "governor")
+	assert.Nil(t, recordTxn(t, trans, MakeSetOraclesTxn(sks[3], governor, oracles, 0), pker))
+	s = trans.Commit().(*State)
+
+	report := func(i int, price uint64, nonce uint64) []byte {
+		txn := ReportPricesTxn{Reports: []OracleReport{{TokenID: 0, Price: price}}}
+		return MakeReportPricesTxn(sks[i], pks[i].Addr(), txn, nonce)
+	}
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	err = recordTxn(t, trans, report(3, 100, 1), pker)
+	assert.Contains(t, err.Error(), "whitelisted")
+	assert.Nil(t, recordTxn(t, trans, report(0, 100, 0), pker))
+	err = recordTxn(t, trans, report(0, 100, 1), pker)
+	assert.Contains(t, err.Error(), "already reported")
+	assert.Nil(t, recordTxn(t, trans, report(1, 120, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, report(2, 1000, 0), pker))
+	s = trans.Commit().(*State)
+
+	p, ok := s.OraclePrice(0)
+	assert.True(t, ok)
+	assert.Equal(t, OraclePrice{Price: 120, Round: 2, Reports: 3}, p)
+
+	// the price is not updated without the quorum.
+	trans = s.Transition(3, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, report(0, 200, 1), pker))
+	s = trans.Commit().(*State)
+	p, _ = s.OraclePrice(0)
+	assert.Equal(t, OraclePrice{Price: 120, Round: 2, Reports: 3}, p)
+}
@@ -1,7 +1,9 @@
 package dex
 
 import (
+	"fmt"
 	"io"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/helinwang/dex/pkg/consensus"
@@ -35,6 +37,11 @@ type orderBook struct {
 	bidMax      *pricePoint
 	askMin      *pricePoint
 	idToEntry   map[uint64]*orderBookEntry
+	// proRata allocates a price level that can not be filled
+	// entirely in proportion to the remaining quantities of its
+	// orders instead of in time priority. It's the market's
+	// config, not serialized with the order book.
+	proRata bool
 }
 
 type orderExecution struct {
@@ -75,20 +82,52 @@ func (o *orderBook) Cancel(id uint64) {
 	}
 }
 
+// Reduce lowers the quantity of the order in place, it keeps the
+// order's position in the queue of its price.
+func (o *orderBook) Reduce(id, quant uint64) {
+	entry := o.idToEntry[id]
+	if entry == nil {
+		return
+	}
+
+	if entry.Quant < quant {
+		panic(fmt.Errorf("impossible: order %d quantity %d is less than the reduced %d", id, entry.Quant, quant))
+	}
+	entry.Quant -= quant
+}
+
 func (o *orderBook) getEntry(data orderBookEntryData) *orderBookEntry {
 	e := &orderBookEntry{orderBookEntryData: data}
 	o.idToEntry[data.ID] = e
 	return e
 }
 
-// Limit processes a incoming limit order.
+// Limit processes a incoming limit order. The book is never left
+// crossed or locked: the residual crossing of the orders added
+// without matching is matched before the incoming order.
 func (o *orderBook) Limit(order Order) (id uint64, executions []orderExecution) {
+	executions = o.uncross()
+	id, e := o.limit(order)
+	executions = append(executions, e...)
+	if o.crossed() {
+		panic(fmt.Errorf("impossible: order book is crossed after matching order %d", id))
+	}
+	return
+}
+
+func (o *orderBook) limit(order Order) (id uint64, executions []orderExecution) {
 	id = o.nextOrderID
 	o.nextOrderID++
 
 	if !order.SellSide {
 		// match the incoming buy order
 		for o.askMin != nil && order.Price >= o.askMin.Price {
+			if o.proRata && order.Quant < o.askMin.quant() {
+				// the price point is not emptied.
+				executions = matchProRata(o.askMin, id, order, executions)
+				return
+			}
+
 			entry := o.askMin.ListHead
 			for entry != nil {
 				if entry.Quant >= order.Quant {
@@ -155,45 +194,15 @@ func (o *orderBook) Limit(order Order) (id uint64, executions []orderExecution)
 
 		// TODO: if a IOC order, do not need to insert
 		// no more matching orders, add to the order book
-		entry := o.getEntry(orderBookEntryData{
-			ID:    id,
-			Owner: order.Owner,
-			Quant: order.Quant,
-		})
-
-		if o.bidMax == nil || order.Price > o.bidMax.Price {
-			o.bidMax = &pricePoint{
-				Price:     order.Price,
-				NextPoint: o.bidMax,
-				ListHead:  entry,
-				ListTail:  entry,
-			}
-		} else if order.Price == o.bidMax.Price {
-			o.bidMax.ListTail.Next = entry
-			o.bidMax.ListTail = entry
-		} else {
-			prev := o.bidMax
-			cur := o.bidMax.NextPoint
-			for ; ; prev, cur = cur, cur.NextPoint {
-				if cur == nil || cur.Price < order.Price {
-					point := &pricePoint{
-						Price:     order.Price,
-						NextPoint: cur,
-						ListHead:  entry,
-						ListTail:  entry,
-					}
-					prev.NextPoint = point
-					break
-				} else if cur.Price == order.Price {
-					cur.ListTail.Next = entry
-					cur.ListTail = entry
-					break
-				}
-			}
-		}
+		o.insert(id, order)
 	} else {
 		// match the incoming sell order
 		for o.bidMax != nil && order.Price <= o.bidMax.Price {
+			if o.proRata && order.Quant < o.bidMax.quant() {
+				executions = matchProRata(o.bidMax, id, order, executions)
+				return
+			}
+
 			entry := o.bidMax.ListHead
 			for entry != nil {
 				if entry.Quant >= order.Quant {
@@ -257,6 +266,60 @@ func (o *orderBook) Limit(order Order) (id uint64, executions []orderExecution)
 		}
 
 		// TODO: if a IOC order, do not need to insert
+		o.insert(id, order)
+	}
+
+	return
+}
+
+// Add adds the order to the order book without matching, it's used
+// when the market is in an auction phase.
+func (o *orderBook) Add(order Order) (id uint64) {
+	id = o.nextOrderID
+	o.nextOrderID++
+	o.insert(id, order)
+	return
+}
+
+func (o *orderBook) insert(id uint64, order Order) {
+	if !order.SellSide {
+		entry := o.getEntry(orderBookEntryData{
+			ID:    id,
+			Owner: order.Owner,
+			Quant: order.Quant,
+		})
+
+		if o.bidMax == nil || order.Price > o.bidMax.Price {
+			o.bidMax = &pricePoint{
+				Price:     order.Price,
+				NextPoint: o.bidMax,
+				ListHead:  entry,
+				ListTail:  entry,
+			}
+		} else if order.Price == o.bidMax.Price {
+			o.bidMax.ListTail.Next = entry
+			o.bidMax.ListTail = entry
+		} else {
+			prev := o.bidMax
+			cur := o.bidMax.NextPoint
+			for ; ; prev, cur = cur, cur.NextPoint {
+				if cur == nil || cur.Price < order.Price {
+					point := &pricePoint{
+						Price:     order.Price,
+						NextPoint: cur,
+						ListHead:  entry,
+						ListTail:  entry,
+					}
+					prev.NextPoint = point
+					break
+				} else if cur.Price == order.Price {
+					cur.ListTail.Next = entry
+					cur.ListTail = entry
+					break
+				}
+			}
+		}
+	} else {
 		entry := o.getEntry(orderBookEntryData{
 			ID:    id,
 			Owner: order.Owner,
@@ -294,10 +357,318 @@ func (o *orderBook) Limit(order Order) (id uint64, executions []orderExecution)
 			}
 		}
 	}
+}
 
+// bestLive returns the first price point with an unfilled and
+// uncancelled order.
+func bestLive(p *pricePoint) *pricePoint {
+	for ; p != nil; p = p.NextPoint {
+		if p.quant() > 0 {
+			return p
+		}
+	}
+	return nil
+}
+
+// crossed returns true if the best bid is at or above the best ask.
+func (o *orderBook) crossed() bool {
+	bid := bestLive(o.bidMax)
+	ask := bestLive(o.askMin)
+	return bid != nil && ask != nil && bid.Price >= ask.Price
+}
+
+// trimPoints drops the filled and cancelled entries at the head of
+// the side, and returns its first price point with a live order.
+func trimPoints(p *pricePoint) *pricePoint {
+	for ; p != nil; p = p.NextPoint {
+		for p.ListHead != nil && p.ListHead.Quant == 0 {
+			p.ListHead = p.ListHead.Next
+		}
+
+		if p.ListHead != nil {
+			return p
+		}
+	}
+	return nil
+}
+
+// uncross matches the best bid with the best ask until the book is no
+// longer crossed. Of each pair, the newer order is the taker and
+// trades at the older order's price, as if it arrived after the older
+// order rested, so the repair is deterministic.
+func (o *orderBook) uncross() (executions []orderExecution) {
+	for {
+		o.bidMax = trimPoints(o.bidMax)
+		o.askMin = trimPoints(o.askMin)
+		if o.bidMax == nil || o.askMin == nil || o.bidMax.Price < o.askMin.Price {
+			return
+		}
+
+		bid, ask := o.bidMax.ListHead, o.askMin.ListHead
+		q := bid.Quant
+		if ask.Quant < q {
+			q = ask.Quant
+		}
+
+		bidExec := orderExecution{Owner: bid.Owner, ID: bid.ID, Quant: q}
+		askExec := orderExecution{Owner: ask.Owner, ID: ask.ID, SellSide: true, Quant: q}
+		if bid.ID < ask.ID {
+			bidExec.Price, askExec.Price = o.bidMax.Price, o.bidMax.Price
+			askExec.Taker = true
+			executions = append(executions, askExec, bidExec)
+		} else {
+			bidExec.Price, askExec.Price = o.askMin.Price, o.askMin.Price
+			bidExec.Taker = true
+			executions = append(executions, bidExec, askExec)
+		}
+
+		bid.Quant -= q
+		ask.Quant -= q
+	}
+}
+
+// clearingPrice returns the uniform price that maximizes the
+// executable volume of the (possibly crossed) order book. Ties are
+// broken by the smaller imbalance between demand and supply, and
+// then by the lower price.
+func (o *orderBook) clearingPrice() (price, volume uint64) {
+	// the price points whose orders are all cancelled are not
+	// candidates, a cancelled order must not set the price.
+	var candidates []uint64
+	for p := o.bidMax; p != nil; p = p.NextPoint {
+		if p.quant() > 0 {
+			candidates = append(candidates, p.Price)
+		}
+	}
+	for p := o.askMin; p != nil; p = p.NextPoint {
+		if p.quant() > 0 {
+			candidates = append(candidates, p.Price)
+		}
+	}
+
+	var minImbalance uint64
+	for _, c := range candidates {
+		var demand, supply uint64
+		for p := o.bidMax; p != nil && p.Price >= c; p = p.NextPoint {
+			demand += p.quant()
+		}
+		for p := o.askMin; p != nil && p.Price <= c; p = p.NextPoint {
+			supply += p.quant()
+		}
+
+		v := demand
+		imbalance := demand - supply
+		if supply < demand {
+			v = supply
+		} else {
+			imbalance = supply - demand
+		}
+
+		if v == 0 {
+			continue
+		}
+
+		if v > volume || (v == volume && (imbalance < minImbalance || (imbalance == minImbalance && c < price))) {
+			price = c
+			volume = v
+			minImbalance = imbalance
+		}
+	}
 	return
 }
 
+func (p *pricePoint) quant() uint64 {
+	var q uint64
+	for e := p.ListHead; e != nil; e = e.Next {
+		q += e.Quant
+	}
+	return q
+}
+
+// proRata returns the allocation of the quantity to the entries of
+// the price point, in the order of the entries, the quantity must be
+// less than the price point's. Each live entry gets its share of the
+// quantity in proportion to its remaining quantity rounded down, the
+// units left by the rounding go one each to the entries in time
+// priority, so the allocation is deterministic.
+func proRata(p *pricePoint, quant uint64) []uint64 {
+	var total big.Int
+	for e := p.ListHead; e != nil; e = e.Next {
+		total.Add(&total, new(big.Int).SetUint64(e.Quant))
+	}
+
+	var alloc []uint64
+	left := quant
+	for e := p.ListHead; e != nil; e = e.Next {
+		var share big.Int
+		share.SetUint64(quant)
+		share.Mul(&share, new(big.Int).SetUint64(e.Quant))
+		share.Div(&share, &total)
+		alloc = append(alloc, share.Uint64())
+		left -= share.Uint64()
+	}
+
+	// left is less than the number of the live entries, and a
+	// share rounded down is less than the entry's quantity since
+	// quant is less than the total.
+	i := 0
+	for e := p.ListHead; e != nil && left > 0; e = e.Next {
+		if alloc[i] < e.Quant {
+			alloc[i]++
+			left--
+		}
+		i++
+	}
+	return alloc
+}
+
+// matchProRata fills the incoming order with the price point's
+// entries in the pro-rata allocation.
+func matchProRata(p *pricePoint, id uint64, order Order, executions []orderExecution) []orderExecution {
+	alloc := proRata(p, order.Quant)
+	i := 0
+	for e := p.ListHead; e != nil; e = e.Next {
+		q := alloc[i]
+		i++
+		if q == 0 {
+			continue
+		}
+
+		execA := orderExecution{
+			Owner:    order.Owner,
+			ID:       id,
+			SellSide: order.SellSide,
+			Quant:    q,
+			Price:    p.Price,
+			Taker:    true,
+		}
+
+		execB := orderExecution{
+			Owner:    e.Owner,
+			ID:       e.ID,
+			SellSide: !order.SellSide,
+			Quant:    q,
+			Price:    p.Price,
+			Taker:    false,
+		}
+		executions = append(executions, execA, execB)
+		e.Quant -= q
+	}
+	return executions
+}
+
+func fillAtPrice(p *pricePoint, sellSide bool, price, volume uint64, proRataLevel bool, executions []orderExecution) []orderExecution {
+	for ; p != nil && volume > 0; p = p.NextPoint {
+		if proRataLevel && volume < p.quant() {
+			alloc := proRata(p, volume)
+			i := 0
+			for e := p.ListHead; e != nil; e = e.Next {
+				q := alloc[i]
+				i++
+				if q == 0 {
+					continue
+				}
+
+				executions = append(executions, orderExecution{
+					Owner:    e.Owner,
+					ID:       e.ID,
+					SellSide: sellSide,
+					Quant:    q,
+					Price:    price,
+				})
+				e.Quant -= q
+			}
+			return executions
+		}
+
+		for e := p.ListHead; e != nil && volume > 0; e = e.Next {
+			if e.Quant == 0 {
+				continue
+			}
+
+			q := e.Quant
+			if q > volume {
+				q = volume
+			}
+
+			executions = append(executions, orderExecution{
+				Owner:    e.Owner,
+				ID:       e.ID,
+				SellSide: sellSide,
+				Quant:    q,
+				Price:    price,
+			})
+			e.Quant -= q
+			volume -= q
+		}
+	}
+	return executions
+}
+
+// pairFills pairs the buy and the sell fills of an auction into
+// trades, in the fills' priority order. Both sides must fill the same
+// quantity. Of each pair, the newer order is the taker and comes
+// first, followed by its maker, as in uncross.
+func pairFills(buys, sells []orderExecution) (executions []orderExecution) {
+	i, j := 0, 0
+	for i < len(buys) && j < len(sells) {
+		bid, ask := buys[i], sells[j]
+		q := bid.Quant
+		if ask.Quant < q {
+			q = ask.Quant
+		}
+
+		bid.Quant, ask.Quant = q, q
+		if bid.ID < ask.ID {
+			ask.Taker = true
+			executions = append(executions, ask, bid)
+		} else {
+			bid.Taker = true
+			executions = append(executions, bid, ask)
+		}
+
+		buys[i].Quant -= q
+		sells[j].Quant -= q
+		if buys[i].Quant == 0 {
+			i++
+		}
+		if sells[j].Quant == 0 {
+			j++
+		}
+	}
+	return
+}
+
+// Auction uncrosses the order book at a single clearing price, all
+// the executions are at the clearing price. Orders are filled in
+// price-time priority, or pro-rata at the last filled price of a
+// pro-rata market, the buy and the sell fills are paired into taker
+// and maker executions by pairFills. A residual crossing, which the
+// clearing price should leave none of, is matched by uncross.
+func (o *orderBook) Auction() (price uint64, executions []orderExecution) {
+	price, volume := o.clearingPrice()
+	if volume == 0 {
+		return
+	}
+
+	buys := fillAtPrice(o.bidMax, false, price, volume, o.proRata, nil)
+	sells := fillAtPrice(o.askMin, true, price, volume, o.proRata, nil)
+	executions = pairFills(buys, sells)
+	executions = append(executions, o.uncross()...)
+	o.compact()
+	return
+}
+
+// compact removes the filled and cancelled entries from the order
+// book.
+func (o *orderBook) compact() {
+	askPoints := flatten(o.askMin)
+	bidPoints := flatten(o.bidMax)
+	o.idToEntry = make(map[uint64]*orderBookEntry)
+	o.askMin = o.unflatten(askPoints)
+	o.bidMax = o.unflatten(bidPoints)
+}
+
 type orderBookPointToMarshal struct {
 	Price   uint64
 	Entries []orderBookEntryData
@@ -1,9 +1,13 @@
 package dex
 
 import (
+	"math"
+	"math/rand"
+	"sort"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/helinwang/dex/pkg/consensus"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -203,3 +207,446 @@ func TestCancelOrder(t *testing.T) {
 	assert.Equal(t, 1, int(book.bidMax.Price))
 	assert.Equal(t, 0, int(book.bidMax.ListHead.Quant))
 }
+
+func TestOrderBookAuction(t *testing.T) {
+	book := newOrderBook()
+	book.Add(Order{Price: 3, Quant: 10})
+	book.Add(Order{Price: 2, Quant: 10})
+	book.Add(Order{Price: 1, Quant: 15, SellSide: true})
+	book.Add(Order{Price: 2, Quant: 10, SellSide: true})
+	// the book is crossed since there is no matching
+	assert.Equal(t, 3, int(book.bidMax.Price))
+	assert.Equal(t, 1, int(book.askMin.Price))
+
+	price, executions := book.Auction()
+	assert.Equal(t, 2, int(price))
+	assert.Equal(t, []orderExecution{
+		{ID: 2, Quant: 10, Price: 2, SellSide: true, Taker: true},
+		{ID: 0, Quant: 10, Price: 2},
+		{ID: 2, Quant: 5, Price: 2, SellSide: true, Taker: true},
+		{ID: 1, Quant: 5, Price: 2},
+		{ID: 3, Quant: 5, Price: 2, SellSide: true, Taker: true},
+		{ID: 1, Quant: 5, Price: 2},
+	}, executions)
+	assert.Nil(t, book.bidMax)
+	assert.Equal(t, 2, int(book.askMin.Price))
+	assert.Equal(t, 5, int(book.askMin.ListHead.Quant))
+}
+
+func TestOrderBookProRata(t *testing.T) {
+	book := newOrderBook()
+	book.proRata = true
+	book.Limit(Order{Price: 5, Quant: 10, SellSide: true})
+	book.Cancel(book.Add(Order{Price: 5, Quant: 50, SellSide: true}))
+	book.Limit(Order{Price: 5, Quant: 30, SellSide: true})
+	book.Limit(Order{Price: 5, Quant: 60, SellSide: true})
+
+	// the shares of 7 are 0.7, 2.1 and 4.2 rounded down, the unit
+	// left goes to the first order in time priority.
+	_, executions := book.Limit(Order{Price: 5, Quant: 7})
+	assert.Equal(t, []orderExecution{
+		{ID: 4, Quant: 1, Price: 5, Taker: true},
+		{ID: 0, Quant: 1, Price: 5, SellSide: true},
+		{ID: 4, Quant: 2, Price: 5, Taker: true},
+		{ID: 2, Quant: 2, Price: 5, SellSide: true},
+		{ID: 4, Quant: 4, Price: 5, Taker: true},
+		{ID: 3, Quant: 4, Price: 5, SellSide: true},
+	}, executions)
+
+	// the order that takes the whole price level fills in time
+	// priority.
+	_, executions = book.Limit(Order{Price: 5, Quant: 100})
+	assert.Equal(t, 6, len(executions))
+	assert.Nil(t, book.askMin)
+	assert.Equal(t, 7, int(book.bidMax.ListHead.Quant))
+}
+
+func TestOrderBookAuctionProRata(t *testing.T) {
+	book := newOrderBook()
+	book.proRata = true
+	book.Add(Order{Price: 10, Quant: 1})
+	book.Add(Order{Price: 10, Quant: 3})
+	book.Add(Order{Price: 10, Quant: 2, SellSide: true})
+	price, executions := book.Auction()
+	assert.Equal(t, 10, int(price))
+	assert.Equal(t, []orderExecution{
+		{ID: 2, Quant: 1, Price: 10, SellSide: true, Taker: true},
+		{ID: 0, Quant: 1, Price: 10},
+		{ID: 2, Quant: 1, Price: 10, SellSide: true, Taker: true},
+		{ID: 1, Quant: 1, Price: 10},
+	}, executions)
+}
+
+func TestOrderBookAuctionNotCrossed(t *testing.T) {
+	book := newOrderBook()
+	book.Add(Order{Price: 1, Quant: 10})
+	book.Add(Order{Price: 2, Quant: 10, SellSide: true})
+	price, executions := book.Auction()
+	assert.Equal(t, 0, int(price))
+	assert.Equal(t, 0, len(executions))
+	assert.Equal(t, 1, int(book.bidMax.Price))
+	assert.Equal(t, 2, int(book.askMin.Price))
+}
+
+func TestOrderBookAuctionSkipCancelled(t *testing.T) {
+	book := newOrderBook()
+	book.Add(Order{Price: 1, Quant: 10})
+	book.Add(Order{Price: 3, Quant: 10})
+	book.Add(Order{Price: 1, Quant: 10, SellSide: true})
+	book.Cancel(book.Add(Order{Price: 2, Quant: 10, SellSide: true}))
+	// price 2 clears the same volume as price 3 with a lower
+	// price, but only the cancelled order rests at it.
+	price, _ := book.Auction()
+	assert.Equal(t, 3, int(price))
+}
+
+func TestOrderBookUncross(t *testing.T) {
+	book := newOrderBook()
+	a, b := consensus.Addr{1}, consensus.Addr{2}
+	// orders added in an auction phase leave the book crossed.
+	book.Add(Order{Owner: a, Price: 5, Quant: 10, SellSide: true})
+	book.Add(Order{Owner: b, Price: 7, Quant: 4})
+	expired := book.Add(Order{Owner: b, Price: 8, Quant: 6})
+	cancelled := book.Add(Order{Owner: a, Price: 4, Quant: 3, SellSide: true})
+	book.Add(Order{Owner: b, Price: 6, Quant: 8})
+	assert.True(t, book.crossed())
+
+	// an expiration and a cancellation in the same round leave
+	// the exhausted entries at the top of the book.
+	book.Cancel(expired)
+	book.Cancel(cancelled)
+	assert.True(t, book.crossed())
+
+	_, executions := book.Limit(Order{Owner: a, Price: 9, Quant: 1, SellSide: true})
+	assert.Equal(t, []orderExecution{
+		{Owner: b, ID: 1, Quant: 4, Price: 5, Taker: true},
+		{Owner: a, ID: 0, SellSide: true, Quant: 4, Price: 5},
+		{Owner: b, ID: 4, Quant: 6, Price: 5, Taker: true},
+		{Owner: a, ID: 0, SellSide: true, Quant: 6, Price: 5},
+	}, executions)
+	assert.False(t, book.crossed())
+	assert.Equal(t, 6, int(book.bidMax.Price))
+	assert.Equal(t, 2, int(book.bidMax.ListHead.Quant))
+	assert.Equal(t, 9, int(book.askMin.Price))
+
+	// the older order sets the price.
+	book = newOrderBook()
+	book.Add(Order{Owner: b, Price: 7, Quant: 4})
+	book.Add(Order{Owner: a, Price: 5, Quant: 4, SellSide: true})
+	_, executions = book.Limit(Order{Owner: a, Price: 1, Quant: 1})
+	assert.Equal(t, []orderExecution{
+		{Owner: a, ID: 1, SellSide: true, Quant: 4, Price: 7, Taker: true},
+		{Owner: b, ID: 0, Quant: 4, Price: 7},
+	}, executions)
+	assert.Nil(t, book.askMin)
+}
+
+// TestOrderBookNeverCrossed checks that the book is not crossed after
+// any Limit, with the orders added, cancelled and expired in between.
+func TestOrderBookNeverCrossed(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for stream := 0; stream < 100; stream++ {
+		book := newOrderBook()
+		var ids []uint64
+		for i := 0; i < 200; i++ {
+			order := Order{SellSide: r.Intn(2) == 0, Quant: uint64(r.Intn(10) + 1), Price: uint64(r.Intn(10) + 1)}
+			switch n := r.Intn(4); {
+			case n == 0:
+				ids = append(ids, book.Add(order))
+			case n == 1 && len(ids) > 0:
+				book.Cancel(ids[r.Intn(len(ids))])
+			default:
+				id, _ := book.Limit(order)
+				ids = append(ids, id)
+				if !assert.False(t, book.crossed(), "stream: %d, op: %d", stream, i) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// naiveBook is the reference matcher of the order book. It keeps the
+// resting orders in a slice and scans the whole slice for the best
+// order each time, it's slow but obviously correct.
+type naiveBook struct {
+	nextOrderID uint64
+	orders      []naiveOrder
+}
+
+type naiveOrder struct {
+	ID uint64
+	Order
+}
+
+// best returns the index of the resting order with the best price
+// on the side that crosses the price, ties are broken by the lower
+// ID. It returns -1 if no resting order crosses the price.
+func (n *naiveBook) best(sellSide bool, price uint64) int {
+	idx := -1
+	for i, o := range n.orders {
+		if o.SellSide != sellSide || o.Quant == 0 {
+			continue
+		}
+
+		if sellSide && o.Price > price || !sellSide && o.Price < price {
+			continue
+		}
+
+		if idx < 0 {
+			idx = i
+			continue
+		}
+
+		b := n.orders[idx]
+		if o.Price == b.Price && o.ID < b.ID || sellSide && o.Price < b.Price || !sellSide && o.Price > b.Price {
+			idx = i
+		}
+	}
+	return idx
+}
+
+// uncross matches the best bid with the best ask while they cross,
+// the newer order trades at the older order's price.
+func (n *naiveBook) uncross() (executions []orderExecution) {
+	for {
+		b, a := n.best(false, 0), n.best(true, math.MaxUint64)
+		if b < 0 || a < 0 || n.orders[b].Price < n.orders[a].Price {
+			return
+		}
+
+		taker, maker := &n.orders[b], &n.orders[a]
+		if taker.ID < maker.ID {
+			taker, maker = maker, taker
+		}
+
+		q := maker.Quant
+		if q > taker.Quant {
+			q = taker.Quant
+		}
+
+		executions = append(executions,
+			orderExecution{Owner: taker.Owner, ID: taker.ID, SellSide: taker.SellSide, Quant: q, Price: maker.Price, Taker: true},
+			orderExecution{Owner: maker.Owner, ID: maker.ID, SellSide: maker.SellSide, Quant: q, Price: maker.Price},
+		)
+		maker.Quant -= q
+		taker.Quant -= q
+	}
+}
+
+func (n *naiveBook) Limit(order Order) (id uint64, executions []orderExecution) {
+	executions = n.uncross()
+	id = n.nextOrderID
+	n.nextOrderID++
+	for order.Quant > 0 {
+		i := n.best(!order.SellSide, order.Price)
+		if i < 0 {
+			break
+		}
+
+		maker := &n.orders[i]
+		q := maker.Quant
+		if q > order.Quant {
+			q = order.Quant
+		}
+
+		executions = append(executions,
+			orderExecution{Owner: order.Owner, ID: id, SellSide: order.SellSide, Quant: q, Price: maker.Price, Taker: true},
+			orderExecution{Owner: maker.Owner, ID: maker.ID, SellSide: maker.SellSide, Quant: q, Price: maker.Price},
+		)
+		maker.Quant -= q
+		order.Quant -= q
+	}
+
+	if order.Quant > 0 {
+		n.orders = append(n.orders, naiveOrder{ID: id, Order: order})
+	}
+	return
+}
+
+func (n *naiveBook) Add(order Order) uint64 {
+	id := n.nextOrderID
+	n.nextOrderID++
+	n.orders = append(n.orders, naiveOrder{ID: id, Order: order})
+	return id
+}
+
+func (n *naiveBook) Cancel(id uint64) {
+	for i := range n.orders {
+		if n.orders[i].ID == id {
+			n.orders[i].Quant = 0
+		}
+	}
+}
+
+// Auction tries every resting price as the clearing price, and
+// fills the crossing orders in price-time priority at the price.
+func (n *naiveBook) Auction() (price uint64, executions []orderExecution) {
+	var volume, minImbalance uint64
+	for _, c := range n.orders {
+		if c.Quant == 0 {
+			continue
+		}
+
+		var demand, supply uint64
+		for _, o := range n.orders {
+			if !o.SellSide && o.Price >= c.Price {
+				demand += o.Quant
+			} else if o.SellSide && o.Price <= c.Price {
+				supply += o.Quant
+			}
+		}
+
+		v, imbalance := demand, supply-demand
+		if supply < demand {
+			v, imbalance = supply, demand-supply
+		}
+
+		if v == 0 {
+			continue
+		}
+
+		if v > volume || v == volume && (imbalance < minImbalance || imbalance == minImbalance && c.Price < price) {
+			price, volume, minImbalance = c.Price, v, imbalance
+		}
+	}
+
+	// each step fills the best bid and the best ask against each
+	// other, the newer order is the taker.
+	for left := volume; left > 0; {
+		bid, ask := &n.orders[n.best(false, price)], &n.orders[n.best(true, price)]
+		q := bid.Quant
+		if ask.Quant < q {
+			q = ask.Quant
+		}
+		if q > left {
+			q = left
+		}
+
+		bidExec := orderExecution{Owner: bid.Owner, ID: bid.ID, Quant: q, Price: price}
+		askExec := orderExecution{Owner: ask.Owner, ID: ask.ID, SellSide: true, Quant: q, Price: price}
+		if bid.ID < ask.ID {
+			askExec.Taker = true
+			executions = append(executions, askExec, bidExec)
+		} else {
+			bidExec.Taker = true
+			executions = append(executions, bidExec, askExec)
+		}
+		bid.Quant -= q
+		ask.Quant -= q
+		left -= q
+	}
+	executions = append(executions, n.uncross()...)
+	return
+}
+
+// levels returns the resting orders on a side grouped by price, in
+// the same form as the flattened order book.
+func (n *naiveBook) levels(sellSide bool) []orderBookPointToMarshal {
+	var orders []naiveOrder
+	for _, o := range n.orders {
+		if o.SellSide == sellSide && o.Quant > 0 {
+			orders = append(orders, o)
+		}
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		a, b := orders[i], orders[j]
+		if a.Price != b.Price {
+			return sellSide == (a.Price < b.Price)
+		}
+		return a.ID < b.ID
+	})
+
+	var r []orderBookPointToMarshal
+	for _, o := range orders {
+		if len(r) == 0 || r[len(r)-1].Price != o.Price {
+			r = append(r, orderBookPointToMarshal{Price: o.Price})
+		}
+		l := &r[len(r)-1]
+		l.Entries = append(l.Entries, orderBookEntryData{ID: o.ID, Owner: o.Owner, Quant: o.Quant})
+	}
+	return r
+}
+
+func nonEmptyLevels(points []orderBookPointToMarshal) []orderBookPointToMarshal {
+	var r []orderBookPointToMarshal
+	for _, p := range points {
+		if len(p.Entries) > 0 {
+			r = append(r, p)
+		}
+	}
+	return r
+}
+
+// TestOrderBookMatchingOracle replays random order streams against
+// the order book and the naive reference matcher, the executions
+// and the resting orders must be identical.
+func TestOrderBookMatchingOracle(t *testing.T) {
+	const (
+		streams = 100
+		ops     = 300
+	)
+
+	for seed := int64(0); seed < streams; seed++ {
+		r := rand.New(rand.NewSource(seed))
+		book := newOrderBook()
+		naive := &naiveBook{}
+		var ids []uint64
+		for i := 0; i < ops; i++ {
+			order := Order{
+				Owner:    consensus.Addr{byte(r.Intn(4))},
+				SellSide: r.Intn(2) == 0,
+				Quant:    uint64(r.Intn(100) + 1),
+				Price:    uint64(r.Intn(20) + 90),
+			}
+
+			var exec, naiveExec []orderExecution
+			switch n := r.Intn(20); {
+			case n < 14:
+				id, e := book.Limit(order)
+				naiveID, ne := naive.Limit(order)
+				assert.Equal(t, naiveID, id)
+				exec, naiveExec = e, ne
+				ids = append(ids, id)
+			case n < 16:
+				id := book.Add(order)
+				assert.Equal(t, naive.Add(order), id)
+				ids = append(ids, id)
+			case n < 18:
+				if len(ids) > 0 {
+					id := ids[r.Intn(len(ids))]
+					book.Cancel(id)
+					naive.Cancel(id)
+				}
+			case n < 19:
+				price, e := book.Auction()
+				naivePrice, ne := naive.Auction()
+				assert.Equal(t, naivePrice, price)
+				exec, naiveExec = e, ne
+			default:
+				// the order book is serialized at the end
+				// of each block.
+				b, err := rlp.EncodeToBytes(book)
+				if err != nil {
+					panic(err)
+				}
+
+				book = &orderBook{}
+				err = rlp.DecodeBytes(b, book)
+				if err != nil {
+					panic(err)
+				}
+			}
+
+			if !assert.Equal(t, naiveExec, exec, "seed: %d, op: %d", seed, i) {
+				return
+			}
+
+			if !assert.Equal(t, naive.levels(true), nonEmptyLevels(flatten(book.askMin)), "seed: %d, op: %d", seed, i) ||
+				!assert.Equal(t, naive.levels(false), nonEmptyLevels(flatten(book.bidMax)), "seed: %d, op: %d", seed, i) {
+				return
+			}
+		}
+	}
+}
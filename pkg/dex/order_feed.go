@@ -0,0 +1,366 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+const (
+	// orderFeedQueueSize is the max number of the events kept for
+	// each market, a consumer falling further behind must reload
+	// the book.
+	orderFeedQueueSize = 10000
+	// MaxOrderFeedPollWait is the max duration that a poll waits
+	// for new events.
+	MaxOrderFeedPollWait = 30 * time.Second
+)
+
+// the types of the order feed events.
+const (
+	// OrderAddEvent is sent when an order rests in the book, the
+	// orders of a price level are queued by the order ID.
+	OrderAddEvent = "add"
+	// OrderModifyEvent is sent when the remaining quantity of an
+	// order changes without being executed, it keeps the queue
+	// position.
+	OrderModifyEvent = "modify"
+	// OrderCancelEvent is sent when an order is removed without
+	// being fully executed: cancelled, expired or the market is
+	// closed.
+	OrderCancelEvent = "cancel"
+	// OrderExecuteEvent is sent when an order in the book is
+	// executed, it's removed when the remaining quantity is 0.
+	OrderExecuteEvent = "execute"
+)
+
+// BookOrder is an order resting in the order book.
+type BookOrder struct {
+	ID       uint64
+	Owner    consensus.Addr
+	SellSide bool
+	Price    uint64
+	// Quant is the remaining quantity.
+	Quant uint64
+}
+
+// OrderBookL3 is every order of the order book after the event of
+// sequence number Seq, each side is in the price-time priority.
+type OrderBookL3 struct {
+	Market MarketSymbol
+	Seq    uint64
+	Bids   []BookOrder
+	Asks   []BookOrder
+}
+
+// OrderFeedEvent is a change of an order in the order book. The
+// events of a block are ordered by the order ID, the new orders come
+// last.
+type OrderFeedEvent struct {
+	// Seq is the sequence number of the event in the market,
+	// starting from 1.
+	Seq   uint64
+	Round uint64
+	Type  string
+	Order BookOrder
+	// Quant is the executed quantity of an execute event.
+	Quant uint64
+}
+
+type OrderBookL3Args struct {
+	Market     MarketSymbol
+	HideOwners bool
+}
+
+type OrderFeedArgs struct {
+	Market MarketSymbol
+	// After is the sequence number of the last received event.
+	After uint64
+	// Wait is the max duration to wait for new events.
+	Wait       time.Duration
+	HideOwners bool
+}
+
+type OrderFeedEvents struct {
+	Events []OrderFeedEvent
+	// Gap is true when the events after After are dropped, the
+	// consumer must reload the book.
+	Gap bool
+}
+
+// ErrOrderFeedSeq is returned when an event does not follow the
+// sequence number of the book, the book must be reloaded.
+var ErrOrderFeedSeq = errors.New("order feed event out of sequence")
+
+// Apply applies the event following the sequence number of the book.
+func (l *OrderBookL3) Apply(e OrderFeedEvent) error {
+	if e.Seq != l.Seq+1 {
+		return ErrOrderFeedSeq
+	}
+
+	side := &l.Bids
+	better := func(a, b uint64) bool { return a > b }
+	if e.Order.SellSide {
+		side = &l.Asks
+		better = func(a, b uint64) bool { return a < b }
+	}
+
+	orders := *side
+	if e.Type == OrderAddEvent {
+		// the orders of the same price are in the increasing
+		// ID (time) order.
+		i := sort.Search(len(orders), func(i int) bool {
+			o := orders[i]
+			return better(e.Order.Price, o.Price) || e.Order.Price == o.Price && e.Order.ID < o.ID
+		})
+		orders = append(orders, BookOrder{})
+		copy(orders[i+1:], orders[i:])
+		orders[i] = e.Order
+	} else {
+		i := 0
+		for i < len(orders) && orders[i].ID != e.Order.ID {
+			i++
+		}
+		if i == len(orders) {
+			return fmt.Errorf("order %d not found in the book", e.Order.ID)
+		}
+
+		if e.Order.Quant == 0 {
+			orders = append(orders[:i], orders[i+1:]...)
+		} else {
+			orders[i].Quant = e.Order.Quant
+		}
+	}
+
+	*side = orders
+	l.Seq = e.Seq
+	return nil
+}
+
+// setExecuted sets the executed quantities by the order ID of the
+// transition that produced the state.
+func (s *State) setExecuted(executed map[MarketSymbol]map[uint64]uint64) {
+	s.mu.Lock()
+	s.executed = executed
+	s.mu.Unlock()
+}
+
+// bookOrders returns the orders of the book, each side in the
+// price-time priority.
+func (o *orderBook) bookOrders() (bids, asks []BookOrder) {
+	side := func(p *pricePoint, sellSide bool) []BookOrder {
+		var r []BookOrder
+		for ; p != nil; p = p.NextPoint {
+			for e := p.ListHead; e != nil; e = e.Next {
+				if e.Quant == 0 {
+					continue
+				}
+
+				r = append(r, BookOrder{ID: e.ID, Owner: e.Owner, SellSide: sellSide, Price: p.Price, Quant: e.Quant})
+			}
+		}
+		return r
+	}
+	return side(o.bidMax, false), side(o.askMin, true)
+}
+
+// marketFeed is the level-3 feed of a market. orders is the book
+// after the last event.
+type marketFeed struct {
+	seq    uint64
+	orders map[uint64]BookOrder
+	events []OrderFeedEvent
+	notify chan struct{}
+}
+
+func loadBookOrders(s *State, m MarketSymbol) map[uint64]BookOrder {
+	orders := make(map[uint64]BookOrder)
+	bids, asks := s.MarketOrders(m)
+	for _, o := range append(bids, asks...) {
+		orders[o.ID] = o
+	}
+	return orders
+}
+
+func (f *marketFeed) add(e OrderFeedEvent) {
+	f.seq++
+	e.Seq = f.seq
+	f.events = append(f.events, e)
+}
+
+// update publishes the difference between the last published book
+// and the book of the state. The executed quantities tell the
+// executions from the other changes, diffing against the published
+// book keeps the replicas exact when a fork replaces the head.
+func (f *marketFeed) update(round uint64, orders map[uint64]BookOrder, executed map[uint64]uint64) {
+	seq := f.seq
+	ids := make([]uint64, 0, len(f.orders))
+	for id := range f.orders {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var added []uint64
+	for _, id := range ids {
+		prev := f.orders[id]
+		cur, ok := orders[id]
+		exec := executed[id]
+		if ok && (cur.Owner != prev.Owner || cur.SellSide != prev.SellSide || cur.Price != prev.Price) {
+			// a fork placed another order of the same ID.
+			added = append(added, id)
+			ok = false
+			exec = 0
+		} else if ok && cur.Quant == prev.Quant {
+			continue
+		}
+
+		var remaining uint64
+		if ok {
+			remaining = cur.Quant
+		}
+
+		if remaining < prev.Quant {
+			quant := prev.Quant - remaining
+			if exec < quant {
+				quant = exec
+			}
+			if quant > 0 {
+				o := prev
+				o.Quant = prev.Quant - quant
+				f.add(OrderFeedEvent{Round: round, Type: OrderExecuteEvent, Order: o, Quant: quant})
+			}
+
+			if prev.Quant-quant == remaining {
+				continue
+			}
+		}
+
+		if ok {
+			f.add(OrderFeedEvent{Round: round, Type: OrderModifyEvent, Order: cur})
+		} else {
+			o := prev
+			o.Quant = 0
+			f.add(OrderFeedEvent{Round: round, Type: OrderCancelEvent, Order: o})
+		}
+	}
+
+	for id := range orders {
+		if _, ok := f.orders[id]; !ok {
+			added = append(added, id)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i] < added[j] })
+	for _, id := range added {
+		f.add(OrderFeedEvent{Round: round, Type: OrderAddEvent, Order: orders[id]})
+	}
+
+	f.orders = orders
+	if len(f.events) > orderFeedQueueSize {
+		f.events = f.events[len(f.events)-orderFeedQueueSize:]
+	}
+
+	if f.seq > seq {
+		close(f.notify)
+		f.notify = make(chan struct{})
+	}
+}
+
+func (f *marketFeed) snapshot(m MarketSymbol) OrderBookL3 {
+	l3 := OrderBookL3{Market: m, Seq: f.seq}
+	for _, o := range f.orders {
+		if o.SellSide {
+			l3.Asks = append(l3.Asks, o)
+		} else {
+			l3.Bids = append(l3.Bids, o)
+		}
+	}
+	sort.Slice(l3.Bids, func(i, j int) bool {
+		a, b := l3.Bids[i], l3.Bids[j]
+		return a.Price > b.Price || a.Price == b.Price && a.ID < b.ID
+	})
+	sort.Slice(l3.Asks, func(i, j int) bool {
+		a, b := l3.Asks[i], l3.Asks[j]
+		return a.Price < b.Price || a.Price == b.Price && a.ID < b.ID
+	})
+	return l3
+}
+
+// orderFeeds is the level-3 feeds of the markets. A market is tracked
+// from the first request of its book or events, the sequence numbers
+// are local to the node.
+type orderFeeds struct {
+	mu      sync.Mutex
+	markets map[MarketSymbol]*marketFeed
+}
+
+func newOrderFeeds() *orderFeeds {
+	return &orderFeeds{markets: make(map[MarketSymbol]*marketFeed)}
+}
+
+// update publishes the changes of the tracked markets in the state.
+func (o *orderFeeds) update(s *State) {
+	s.mu.Lock()
+	round, executed := s.round, s.executed
+	s.mu.Unlock()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for m, f := range o.markets {
+		f.update(round, loadBookOrders(s, m), executed[m])
+	}
+}
+
+// market returns the feed of the market, it starts tracking the
+// market from the book of the state.
+func (o *orderFeeds) market(s *State, m MarketSymbol) *marketFeed {
+	f := o.markets[m]
+	if f == nil {
+		f = &marketFeed{orders: loadBookOrders(s, m), notify: make(chan struct{})}
+		o.markets[m] = f
+	}
+	return f
+}
+
+func (o *orderFeeds) book(s *State, m MarketSymbol) OrderBookL3 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.market(s, m).snapshot(m)
+}
+
+func (o *orderFeeds) poll(s *State, args OrderFeedArgs, e *OrderFeedEvents) error {
+	wait := args.Wait
+	if wait > MaxOrderFeedPollWait {
+		wait = MaxOrderFeedPollWait
+	}
+	timeout := time.After(wait)
+
+	for {
+		o.mu.Lock()
+		f := o.market(s, args.Market)
+		if args.After > f.seq {
+			o.mu.Unlock()
+			return errors.New("sequence number not reached, the feed restarted")
+		}
+
+		i := sort.Search(len(f.events), func(i int) bool { return f.events[i].Seq > args.After })
+		notify := f.notify
+		if i < len(f.events) || wait <= 0 {
+			e.Events = append([]OrderFeedEvent(nil), f.events[i:]...)
+			e.Gap = args.After < f.seq && (len(e.Events) == 0 || e.Events[0].Seq > args.After+1)
+			o.mu.Unlock()
+			return nil
+		}
+		o.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-timeout:
+			wait = 0
+		}
+	}
+}
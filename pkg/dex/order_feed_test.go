@@ -0,0 +1,72 @@
+package dex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderFeed(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	m := MarketSymbol{Base: 1, Quote: 0}
+	book := newOrderBook()
+	a := book.Add(Order{Price: 100, Quant: 5})
+	b := book.Add(Order{Price: 100, Quant: 3})
+	c := book.Add(Order{Price: 99, Quant: 2})
+	book.Add(Order{SellSide: true, Price: 102, Quant: 4})
+	s.saveOrderBook(m, book)
+
+	feeds := newOrderFeeds()
+	replica := feeds.book(s, m)
+	assert.Equal(t, uint64(0), replica.Seq)
+	assert.Equal(t, []uint64{a, b, c}, []uint64{replica.Bids[0].ID, replica.Bids[1].ID, replica.Bids[2].ID})
+
+	// a sell order fills a and partially fills b, c is cancelled
+	// and a bid is added.
+	_, execs := book.Limit(Order{SellSide: true, Price: 100, Quant: 6})
+	executed := make(map[uint64]uint64)
+	for _, e := range execs {
+		executed[e.ID] += e.Quant
+	}
+	book.Cancel(c)
+	d := book.Add(Order{Price: 100, Quant: 1})
+	s.saveOrderBook(m, book)
+	s.setTrades(1, nil)
+	s.setExecuted(map[MarketSymbol]map[uint64]uint64{m: executed})
+	feeds.update(s)
+
+	var e OrderFeedEvents
+	assert.Nil(t, feeds.poll(s, OrderFeedArgs{Market: m}, &e))
+	assert.False(t, e.Gap)
+	var types []string
+	for _, event := range e.Events {
+		types = append(types, event.Type)
+		assert.Nil(t, replica.Apply(event))
+	}
+	assert.Equal(t, []string{OrderExecuteEvent, OrderExecuteEvent, OrderCancelEvent, OrderAddEvent}, types)
+	assert.Equal(t, uint64(5), e.Events[0].Quant)
+	assert.Equal(t, uint64(2), e.Events[1].Order.Quant, "the remaining quantity")
+	assert.Equal(t, feeds.book(s, m), replica)
+	assert.Equal(t, d, replica.Bids[1].ID)
+	assert.Equal(t, ErrOrderFeedSeq, replica.Apply(e.Events[0]))
+
+	// a poll waits for the events after the last one.
+	done := make(chan OrderFeedEvents)
+	go func() {
+		var e OrderFeedEvents
+		assert.Nil(t, feeds.poll(s, OrderFeedArgs{Market: m, After: replica.Seq, Wait: time.Minute}, &e))
+		done <- e
+	}()
+	time.Sleep(10 * time.Millisecond)
+	book.Cancel(d)
+	s.saveOrderBook(m, book)
+	s.setTrades(2, nil)
+	s.setExecuted(nil)
+	feeds.update(s)
+	e = <-done
+	assert.Equal(t, 1, len(e.Events))
+	assert.Equal(t, OrderCancelEvent, e.Events[0].Type)
+	assert.Equal(t, replica.Seq+1, e.Events[0].Seq)
+}
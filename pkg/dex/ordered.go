@@ -0,0 +1,58 @@
+package dex
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// The state and the audit log must not depend on Go's randomized map
+// iteration order, so the transition iterates its maps in the order
+// of the sorted keys.
+
+func sortMarkets(ms []MarketSymbol) {
+	sort.Slice(ms, func(i, j int) bool {
+		if ms[i].Base != ms[j].Base {
+			return ms[i].Base < ms[j].Base
+		}
+
+		return ms[i].Quote < ms[j].Quote
+	})
+}
+
+func sortRounds(rounds []uint64) {
+	sort.Slice(rounds, func(i, j int) bool {
+		return rounds[i] < rounds[j]
+	})
+}
+
+func sortTokenIDs(ids []TokenID) {
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+}
+
+func sortAddrs(addrs []consensus.Addr) {
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i][:], addrs[j][:]) < 0
+	})
+}
+
+func bookMarkets(books map[MarketSymbol]*orderBook) []MarketSymbol {
+	ms := make([]MarketSymbol, 0, len(books))
+	for m := range books {
+		ms = append(ms, m)
+	}
+	sortMarkets(ms)
+	return ms
+}
+
+func tradedMarkets(trades map[MarketSymbol][]PriceSample) []MarketSymbol {
+	ms := make([]MarketSymbol, 0, len(trades))
+	for m := range trades {
+		ms = append(ms, m)
+	}
+	sortMarkets(ms)
+	return ms
+}
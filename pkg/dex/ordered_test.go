@@ -0,0 +1,71 @@
+package dex
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortMarkets(t *testing.T) {
+	ms := []MarketSymbol{{Base: 2, Quote: 1}, {Base: 1, Quote: 2}, {Base: 1, Quote: 0}}
+	sortMarkets(ms)
+	assert.Equal(t, []MarketSymbol{{Base: 1, Quote: 0}, {Base: 1, Quote: 2}, {Base: 2, Quote: 1}}, ms)
+}
+
+// TestTransitionDeterministic runs the same rounds many times, the
+// state hash and the audit log must not depend on the map iteration
+// order.
+func TestTransitionDeterministic(t *testing.T) {
+	const tokens = 8
+	pkSeller, skSeller := RandKeyPair()
+	pkBuyer, skBuyer := RandKeyPair()
+	seller, buyer := pkSeller.Addr(), pkBuyer.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{seller: pkSeller, buyer: pkBuyer}}
+	price := uint64(math.Pow10(OrderPriceDecimals))
+
+	run := func() (consensus.Hash, []byte) {
+		s := NewState(ethdb.NewMemDatabase())
+		acc := s.NewAccount(pkSeller)
+		for i := 0; i < tokens; i++ {
+			s.UpdateToken(Token{ID: TokenID(i), TokenInfo: BNBInfo})
+			acc.UpdateBalance(TokenID(i), Balance{Available: 1000})
+		}
+		s.NewAccount(pkBuyer).UpdateBalance(0, Balance{Available: 10000})
+
+		var buf bytes.Buffer
+		s.SetAuditLog(NewAuditLog(&buf))
+		trans := s.Transition(1, 0, nil).(*Transition)
+		var sellerNonce, buyerNonce uint64
+		for i := 1; i < tokens; i++ {
+			m := MarketSymbol{Base: TokenID(i), Quote: 0}
+			for j := 0; j < 3; j++ {
+				order := PlaceOrderTxn{SellSide: true, Quant: 100, Price: price, Market: m, ExpireRound: uint64(2 + j)}
+				assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skSeller, seller, order, sellerNonce), pker))
+				sellerNonce++
+			}
+
+			order := PlaceOrderTxn{Quant: 150, Price: price, Market: m}
+			assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skBuyer, buyer, order, buyerNonce), pker))
+			buyerNonce++
+		}
+		s = trans.Commit().(*State)
+
+		trans = s.Transition(2, 0, nil).(*Transition)
+		h := trans.StateHash()
+		s = trans.Commit().(*State)
+		s.Finalized()
+		return h, buf.Bytes()
+	}
+
+	hash, log := run()
+	assert.NotEmpty(t, log)
+	for i := 0; i < 10; i++ {
+		h, l := run()
+		assert.Equal(t, hash, h)
+		assert.Equal(t, log, l)
+	}
+}
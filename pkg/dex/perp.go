@@ -0,0 +1,489 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+const (
+	// perpInitialMargin is the minimum collateral in percent of
+	// the notional value of the position and the open orders
+	// after placing an order or withdrawing, e.g., 10 allows up
+	// to 10x leverage.
+	perpInitialMargin = 10
+	// perpMaintenanceMargin is the collateral in percent of the
+	// notional value of the position below which the position is
+	// liquidated.
+	perpMaintenanceMargin = 5
+	// perpFundingInterval is the number of rounds between two
+	// funding payments.
+	perpFundingInterval = 8
+	// perpMaxFundingRate is the maximum funding rate per
+	// funding interval in parts per perpFundingDenominator.
+	perpMaxFundingRate     = 1000
+	perpFundingDenominator = 1000000
+)
+
+// PerpPosition is the position of a perpetual account. A perpetual
+// account is an account per owner and market that holds the
+// market's quote token as collateral. Its position is synthetic:
+// trading the perpetual market does not move the base token, the
+// profit and loss is settled in the collateral.
+//
+// The perpetual market tracks the spot market of the same symbol,
+// whose reference price is used as the index price for margin,
+// liquidation and funding.
+type PerpPosition struct {
+	Owner  consensus.Addr
+	Market MarketSymbol
+	Size   uint64
+	Short  bool
+	// Entry is the average entry price of the position.
+	Entry uint64
+}
+
+// perpPK returns the public key of the owner's perpetual account of
+// the market. The key does not have a corresponding secret key, the
+// perpetual account is controlled by its owner's transactions.
+func perpPK(owner consensus.Addr, m MarketSymbol) PK {
+	pk := append([]byte("perp"), owner[:]...)
+	return PK(append(pk, m.Encode()...))
+}
+
+// PerpAddr returns the address of the owner's perpetual account of
+// the market.
+func PerpAddr(owner consensus.Addr, m MarketSymbol) consensus.Addr {
+	return perpPK(owner, m).Addr()
+}
+
+func (t *Transition) getPerpBook(m MarketSymbol) *orderBook {
+	book := t.perpBooks[m]
+	if book == nil {
+		book = t.state.loadBook(perpBookPath(m))
+		if book == nil {
+			book = newOrderBook()
+		}
+		t.perpBooks[m] = book
+	}
+
+	return book
+}
+
+func (t *Transition) savePerpBooks() {
+	for _, m := range bookMarkets(t.perpBooks) {
+		t.state.saveBook(perpBookPath(m), t.perpBooks[m])
+	}
+}
+
+// perpAccount returns the owner's perpetual account of the market
+// and its position, a new perpetual account is created if create is
+// true.
+func (t *Transition) perpAccount(owner consensus.Addr, m MarketSymbol, create bool) (*Account, PerpPosition, error) {
+	addr := PerpAddr(owner, m)
+	p, ok := t.state.PerpPosition(addr)
+	if ok {
+		return t.state.Account(addr), p, nil
+	}
+
+	if !create {
+		return nil, p, fmt.Errorf("perpetual account of market %v does not exist", m)
+	}
+
+	p = PerpPosition{Owner: owner, Market: m}
+	t.state.UpdatePerpPosition(addr, p)
+	return t.state.NewAccount(perpPK(owner, m)), p, nil
+}
+
+// pnl returns the profit and loss of closing quant of the position
+// at the given price in the quote token.
+func (t *Transition) pnl(p PerpPosition, quant, price uint64) (profit, loss uint64) {
+	baseInfo := t.tokenCache.Info(p.Market.Base)
+	quoteInfo := t.tokenCache.Info(p.Market.Quote)
+	gain := price >= p.Entry
+	diff := price - p.Entry
+	if !gain {
+		diff = p.Entry - price
+	}
+
+	v := calcQuoteQuant(quant, quoteInfo.Decimals, diff, OrderPriceDecimals, baseInfo.Decimals)
+	if gain != p.Short {
+		return v, 0
+	}
+	return 0, v
+}
+
+func (t *Transition) notional(m MarketSymbol, quant, price uint64) uint64 {
+	baseInfo := t.tokenCache.Info(m.Base)
+	quoteInfo := t.tokenCache.Info(m.Quote)
+	return calcQuoteQuant(quant, quoteInfo.Decimals, price, OrderPriceDecimals, baseInfo.Decimals)
+}
+
+// perpMarginSufficient returns true if the collateral plus the
+// unrealized profit and loss at the index price is at least percent
+// of the notional value of the position plus extra.
+func (t *Transition) perpMarginSufficient(acc *Account, p PerpPosition, index, extra, percent uint64) bool {
+	profit, loss := t.pnl(p, p.Size, index)
+	var equity, required big.Int
+	equity.SetUint64(acc.Balance(p.Market.Quote).Available)
+	equity.Add(&equity, new(big.Int).SetUint64(profit))
+	equity.Sub(&equity, new(big.Int).SetUint64(loss))
+	equity.Mul(&equity, big.NewInt(100))
+	required.SetUint64(t.notional(p.Market, p.Size, index))
+	required.Add(&required, new(big.Int).SetUint64(extra))
+	required.Mul(&required, new(big.Int).SetUint64(percent))
+	return equity.Cmp(&required) >= 0
+}
+
+// openOrdersNotional returns the notional value of the remaining
+// quantity of the account's open orders.
+func (t *Transition) openOrdersNotional(acc *Account) uint64 {
+	var sum uint64
+	for _, o := range acc.PendingOrders() {
+		sum += t.notional(o.ID.Market, o.Quant-o.Executed, o.Price)
+	}
+	return sum
+}
+
+func (t *Transition) perpTransfer(owner *Account, txn *PerpTransferTxn) error {
+	if !txn.Market.Valid() {
+		return txnErrorf(ErrCodeBadMarket, "perpetual account's market is invalid: %v", txn.Market)
+	}
+
+	if txn.Quant == 0 {
+		return errors.New("transfer quantity should not be 0")
+	}
+
+	m := txn.Market
+	if txn.Withdraw {
+		acc, p, err := t.perpAccount(owner.PK().Addr(), m, false)
+		if err != nil {
+			return err
+		}
+
+		b := acc.Balance(m.Quote)
+		if b.Available < txn.Quant {
+			return txnErrorf(ErrCodeInsufficientBalance, "insufficient perpetual account collateral, quantity: %d, available: %d", txn.Quant, b.Available)
+		}
+
+		b.Available -= txn.Quant
+		acc.UpdateBalance(m.Quote, b)
+		if p.Size > 0 || len(acc.PendingOrders()) > 0 {
+			ref, ok := t.state.RefPrice(m)
+			if !ok || !t.perpMarginSufficient(acc, p, ref.Price, t.openOrdersNotional(acc), perpInitialMargin) {
+				b.Available += txn.Quant
+				acc.UpdateBalance(m.Quote, b)
+				return errors.New("withdrawal would make the perpetual account undercollateralized")
+			}
+		}
+
+		ob := owner.Balance(m.Quote)
+		ob.Available += txn.Quant
+		owner.UpdateBalance(m.Quote, ob)
+		return nil
+	}
+
+	if t.tokenCache.Info(m.Base) == zeroInfo || t.tokenCache.Info(m.Quote) == zeroInfo {
+		return fmt.Errorf("trying to open perpetual account on market with nonexistent token: %v", m)
+	}
+
+	ob := owner.Balance(m.Quote)
+	if ob.Available < txn.Quant {
+		return txnErrorf(ErrCodeInsufficientBalance, "insufficient available token balance, token id: %v, quantity: %d, available: %d", m.Quote, txn.Quant, ob.Available)
+	}
+
+	addr := owner.PK().Addr()
+	if err := t.checkTransferThreshold(addr, m.Quote, txn.Quant); err != nil {
+		return err
+	}
+
+	acc, _, err := t.perpAccount(addr, m, true)
+	if err != nil {
+		return err
+	}
+
+	ob.Available -= txn.Quant
+	owner.UpdateBalance(m.Quote, ob)
+	t.addOutflow(addr, m.Quote, txn.Quant)
+	b := acc.Balance(m.Quote)
+	b.Available += txn.Quant
+	acc.UpdateBalance(m.Quote, b)
+	return nil
+}
+
+func (t *Transition) perpOrder(owner *Account, txn *PerpOrderTxn) error {
+	if txn.Quant == 0 || txn.Price == 0 {
+		return errors.New("perpetual order quantity and price should not be 0")
+	}
+
+	if txn.ExpireRound > 0 {
+		return errors.New("perpetual orders can not expire")
+	}
+
+	acc, p, err := t.perpAccount(owner.PK().Addr(), txn.Market, false)
+	if err != nil {
+		return err
+	}
+
+	ref, ok := t.state.RefPrice(txn.Market)
+	if !ok {
+		return fmt.Errorf("market %v has no index price", txn.Market)
+	}
+
+	extra := t.openOrdersNotional(acc) + t.notional(txn.Market, txn.Quant, txn.Price)
+	if !t.perpMarginSufficient(acc, p, ref.Price, extra, perpInitialMargin) {
+		return txnErrorf(ErrCodeInsufficientBalance, "insufficient perpetual account collateral for the order")
+	}
+
+	t.placePerpOrder(acc, &txn.PlaceOrderTxn)
+	return nil
+}
+
+func (t *Transition) placePerpOrder(acc *Account, txn *PlaceOrderTxn) {
+	order := Order{
+		Owner:    acc.PK().Addr(),
+		SellSide: txn.SellSide,
+		Quant:    txn.Quant,
+		Price:    txn.Price,
+	}
+
+	orderID, executions := t.getPerpBook(txn.Market).Limit(order)
+	acc.UpdatePendingOrder(PendingOrder{
+		ID:    OrderID{ID: orderID, Market: txn.Market},
+		Order: order,
+	})
+	t.settlePerp(txn.Market, executions)
+}
+
+func (t *Transition) perpCancelOrder(owner *Account, txn *PerpCancelOrderTxn) error {
+	acc, _, err := t.perpAccount(owner.PK().Addr(), txn.ID.Market, false)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := acc.PendingOrder(txn.ID); !ok {
+		return fmt.Errorf("can not find the order to cancel: %v", txn.ID)
+	}
+
+	// perpetual orders do not lock collateral, there is nothing
+	// to refund.
+	t.getPerpBook(txn.ID.Market).Cancel(txn.ID.ID)
+	acc.RemovePendingOrder(txn.ID)
+	return nil
+}
+
+// cancelPerpOrders cancels the open orders of the perpetual account
+// of the market.
+func (t *Transition) cancelPerpOrders(acc *Account, m MarketSymbol) {
+	book := t.getPerpBook(m)
+	for _, o := range acc.PendingOrders() {
+		book.Cancel(o.ID.ID)
+		acc.RemovePendingOrder(o.ID)
+	}
+}
+
+// settlePerp updates the positions of the executed perpetual
+// orders.
+func (t *Transition) settlePerp(m MarketSymbol, executions []orderExecution) {
+	for _, exec := range executions {
+		acc := t.state.Account(exec.Owner)
+		orderID := OrderID{ID: exec.ID, Market: m}
+		acc.AddExecutionReport(ExecutionReport{
+			Round:      t.round,
+			ID:         orderID,
+			SellSide:   exec.SellSide,
+			TradePrice: exec.Price,
+			Quant:      exec.Quant,
+		})
+		if exec.SellSide {
+			t.perpTrades[m] = append(t.perpTrades[m], PriceSample{Round: t.round, Price: exec.Price, Volume: exec.Quant})
+		}
+
+		executedOrder, ok := acc.PendingOrder(orderID)
+		if !ok {
+			panic(fmt.Errorf("impossible: can not find matched perpetual order %d, market: %v, executed order: %v", exec.ID, m, exec))
+		}
+
+		executedOrder.Executed += exec.Quant
+		if executedOrder.Executed == executedOrder.Quant {
+			acc.RemovePendingOrder(orderID)
+		} else {
+			acc.UpdatePendingOrder(executedOrder)
+		}
+
+		p, ok := t.state.PerpPosition(exec.Owner)
+		if !ok {
+			panic(fmt.Errorf("impossible: can not find perpetual position of %v", exec.Owner))
+		}
+
+		t.fillPerp(acc, &p, exec.SellSide, exec.Quant, exec.Price)
+		t.updatePerpPosition(exec.Owner, p)
+	}
+}
+
+// fillPerp updates the position with a fill, the profit and loss of
+// the closed quantity is settled in the collateral.
+func (t *Transition) fillPerp(acc *Account, p *PerpPosition, sellSide bool, quant, price uint64) {
+	if p.Size == 0 || p.Short == sellSide {
+		var entry big.Int
+		entry.SetUint64(p.Entry)
+		entry.Mul(&entry, new(big.Int).SetUint64(p.Size))
+		var v big.Int
+		v.SetUint64(price)
+		v.Mul(&v, new(big.Int).SetUint64(quant))
+		entry.Add(&entry, &v)
+		p.Size += quant
+		entry.Div(&entry, new(big.Int).SetUint64(p.Size))
+		p.Entry = entry.Uint64()
+		p.Short = sellSide
+		return
+	}
+
+	closed := quant
+	if closed > p.Size {
+		closed = p.Size
+	}
+
+	profit, loss := t.pnl(*p, closed, price)
+	b := acc.Balance(p.Market.Quote)
+	b.Available += profit
+	if b.Available < loss {
+		t.logger().Warn("perpetual account loss exceeds collateral", "owner", p.Owner, "market", p.Market, "loss", loss, "collateral", b.Available)
+		loss = b.Available
+	}
+	b.Available -= loss
+	acc.UpdateBalance(p.Market.Quote, b)
+
+	p.Size -= closed
+	if p.Size == 0 {
+		p.Entry = 0
+	}
+
+	if rest := quant - closed; rest > 0 {
+		p.Size = rest
+		p.Short = sellSide
+		p.Entry = price
+	}
+}
+
+// updatePerpPosition saves the position, and keeps the index of the
+// perpetual accounts with open positions up to date.
+func (t *Transition) updatePerpPosition(addr consensus.Addr, p PerpPosition) {
+	if p.Size == 0 {
+		t.state.RemovePerpAccount(addr)
+	} else {
+		t.state.AddPerpAccount(addr)
+	}
+	t.state.UpdatePerpPosition(addr, p)
+}
+
+// fundingRate returns the funding rate of the perpetual market in
+// parts per perpFundingDenominator, and whether the longs pay the
+// shorts. The rate is the premium of the perpetual market's
+// reference price over the index price, capped at
+// perpMaxFundingRate.
+func (t *Transition) fundingRate(m MarketSymbol) (rate uint64, longPays bool, ok bool) {
+	index, ok := t.state.RefPrice(m)
+	if !ok || index.Price == 0 {
+		return 0, false, false
+	}
+
+	mark, ok := t.state.PerpRefPrice(m)
+	if !ok {
+		return 0, false, false
+	}
+
+	longPays = mark.Price >= index.Price
+	diff := mark.Price - index.Price
+	if !longPays {
+		diff = index.Price - mark.Price
+	}
+
+	rate = mulDiv(diff, perpFundingDenominator, index.Price)
+	if rate > perpMaxFundingRate {
+		rate = perpMaxFundingRate
+	}
+	return rate, longPays, true
+}
+
+// payFunding exchanges the funding payments between the longs and
+// the shorts every perpFundingInterval rounds.
+func (t *Transition) payFunding() {
+	if t.round%perpFundingInterval != 0 {
+		return
+	}
+
+	for _, addr := range t.state.PerpAccounts() {
+		p, ok := t.state.PerpPosition(addr)
+		if !ok {
+			t.logger().Error("can not find perpetual position", "addr", addr)
+			continue
+		}
+
+		rate, longPays, ok := t.fundingRate(p.Market)
+		if !ok || rate == 0 {
+			continue
+		}
+
+		index, _ := t.state.RefPrice(p.Market)
+		payment := mulDiv(t.notional(p.Market, p.Size, index.Price), rate, perpFundingDenominator)
+		acc := t.state.Account(addr)
+		b := acc.Balance(p.Market.Quote)
+		if longPays != p.Short {
+			if b.Available < payment {
+				payment = b.Available
+			}
+			b.Available -= payment
+		} else {
+			b.Available += payment
+		}
+		acc.UpdateBalance(p.Market.Quote, b)
+	}
+}
+
+// liquidatePerps closes the positions whose collateral plus the
+// unrealized profit and loss at the index price falls below
+// perpMaintenanceMargin of the position's notional value. The
+// position's open orders are cancelled, and an order closing the
+// position is placed in the perpetual market at
+// liquidationSlippage from the index price.
+func (t *Transition) liquidatePerps() {
+	for _, addr := range t.state.PerpAccounts() {
+		p, ok := t.state.PerpPosition(addr)
+		if !ok {
+			t.logger().Error("can not find perpetual position", "addr", addr)
+			continue
+		}
+
+		// the position of a blocked owner is frozen.
+		if t.checkBlocked(p.Owner) != nil {
+			continue
+		}
+
+		index, ok := t.state.RefPrice(p.Market)
+		if !ok {
+			continue
+		}
+
+		acc := t.state.Account(addr)
+		if t.perpMarginSufficient(acc, p, index.Price, 0, perpMaintenanceMargin) {
+			continue
+		}
+
+		t.logger().Info("liquidating perpetual position", "owner", p.Owner, "market", p.Market, "size", p.Size, "short", p.Short)
+		t.cancelPerpOrders(acc, p.Market)
+		price := liquidationPrice(index.Price, p.Short)
+
+		t.placePerpOrder(acc, &PlaceOrderTxn{SellSide: !p.Short, Quant: p.Size, Price: price, Market: p.Market})
+	}
+}
+
+func (t *Transition) updatePerpRefPrices() {
+	for _, m := range tradedMarkets(t.perpTrades) {
+		p, _ := t.state.PerpRefPrice(m)
+		p.update(t.round, t.perpTrades[m])
+		t.state.UpdatePerpRefPrice(m, p)
+	}
+}
@@ -0,0 +1,112 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+// openPerpPositions opens a long position of quant for the first
+// key pair, and a short position of quant for the second key pair
+// at price 1.0, each with collateral of collateral.
+func openPerpPositions(t *testing.T, quant, collateral uint64) (s *State, pks []PK, sks []SK, pker *myPKer) {
+	s = NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	market := MarketSymbol{Base: 0, Quote: 1}
+	one := uint64(math.Pow10(OrderPriceDecimals))
+	s.UpdateRefPrice(market, RefPrice{Price: one})
+	pker = &myPKer{m: make(map[consensus.Addr]PK)}
+	for i := 0; i < 2; i++ {
+		pk, sk := RandKeyPair()
+		s.NewAccount(pk).UpdateBalance(1, Balance{Available: collateral})
+		pker.m[pk.Addr()] = pk
+		pks = append(pks, pk)
+		sks = append(sks, sk)
+	}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	for i := range pks {
+		assert.Nil(t, recordTxn(t, trans, MakePerpTransferTxn(sks[i], pks[i].Addr(), PerpTransferTxn{Market: market, Quant: collateral}, 0), pker))
+	}
+	assert.Nil(t, recordTxn(t, trans, MakePerpOrderTxn(sks[0], pks[0].Addr(), PlaceOrderTxn{Quant: quant, Price: one, Market: market}, 1), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePerpOrderTxn(sks[1], pks[1].Addr(), PlaceOrderTxn{SellSide: true, Quant: quant, Price: one, Market: market}, 1), pker))
+	s = trans.Commit().(*State)
+	return
+}
+
+func TestPerpTrade(t *testing.T) {
+	market := MarketSymbol{Base: 0, Quote: 1}
+	one := uint64(math.Pow10(OrderPriceDecimals))
+	s, pks, sks, pker := openPerpPositions(t, 100, 100)
+
+	long, _ := s.PerpPosition(PerpAddr(pks[0].Addr(), market))
+	assert.Equal(t, PerpPosition{Owner: pks[0].Addr(), Market: market, Size: 100, Entry: one}, long)
+	short, _ := s.PerpPosition(PerpAddr(pks[1].Addr(), market))
+	assert.Equal(t, PerpPosition{Owner: pks[1].Addr(), Market: market, Size: 100, Short: true, Entry: one}, short)
+	assert.Equal(t, 2, len(s.PerpAccounts()))
+	// the base token is not moved
+	assert.Equal(t, 0, int(s.Account(PerpAddr(pks[0].Addr(), market)).Balance(0).Available))
+
+	// exceeds the initial margin
+	trans := s.Transition(2, 0, nil).(*Transition)
+	err := recordTxn(t, trans, MakePerpOrderTxn(sks[0], pks[0].Addr(), PlaceOrderTxn{Quant: 1000, Price: one, Market: market}, 2), pker)
+	assert.Contains(t, err.Error(), "insufficient")
+
+	// close the positions at 1.2
+	price := 12 * one / 10
+	s.UpdateRefPrice(market, RefPrice{Price: price})
+	assert.Nil(t, recordTxn(t, trans, MakePerpOrderTxn(sks[0], pks[0].Addr(), PlaceOrderTxn{SellSide: true, Quant: 100, Price: price, Market: market}, 2), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePerpOrderTxn(sks[1], pks[1].Addr(), PlaceOrderTxn{Quant: 100, Price: price, Market: market}, 2), pker))
+	s = trans.Commit().(*State)
+
+	assert.Equal(t, 120, int(s.Account(PerpAddr(pks[0].Addr(), market)).Balance(1).Available))
+	assert.Equal(t, 80, int(s.Account(PerpAddr(pks[1].Addr(), market)).Balance(1).Available))
+	assert.Equal(t, 0, len(s.PerpAccounts()))
+
+	trans = s.Transition(3, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakePerpTransferTxn(sks[0], pks[0].Addr(), PerpTransferTxn{Market: market, Quant: 120, Withdraw: true}, 3), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, 120, int(s.Account(pks[0].Addr()).Balance(1).Available))
+}
+
+func TestPerpFunding(t *testing.T) {
+	market := MarketSymbol{Base: 0, Quote: 1}
+	one := uint64(math.Pow10(OrderPriceDecimals))
+	quant := 100 * one
+	s, pks, _, _ := openPerpPositions(t, quant, quant)
+
+	// the perpetual market trades at a premium, the longs pay
+	// the shorts the capped rate.
+	s.UpdatePerpRefPrice(market, RefPrice{Price: 2 * one})
+	for r := uint64(2); r <= perpFundingInterval; r++ {
+		s = s.Transition(r, 0, nil).Commit().(*State)
+	}
+
+	payment := quant * perpMaxFundingRate / perpFundingDenominator
+	assert.Equal(t, quant-payment, s.Account(PerpAddr(pks[0].Addr(), market)).Balance(1).Available)
+	assert.Equal(t, quant+payment, s.Account(PerpAddr(pks[1].Addr(), market)).Balance(1).Available)
+}
+
+func TestPerpLiquidation(t *testing.T) {
+	market := MarketSymbol{Base: 0, Quote: 1}
+	one := uint64(math.Pow10(OrderPriceDecimals))
+	s, pks, sks, pker := openPerpPositions(t, 100, 100)
+
+	// the long rests a sell order at 1.9, the index moves to 1.96
+	// and the short's equity of 4 is below the maintenance margin.
+	s.UpdateRefPrice(market, RefPrice{Price: 196 * one / 100})
+	trans := s.Transition(2, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakePerpOrderTxn(sks[0], pks[0].Addr(), PlaceOrderTxn{SellSide: true, Quant: 100, Price: 19 * one / 10, Market: market}, 2), pker))
+	s = trans.Commit().(*State)
+
+	short := PerpAddr(pks[1].Addr(), market)
+	p, _ := s.PerpPosition(short)
+	assert.Equal(t, 0, int(p.Size))
+	assert.Equal(t, 10, int(s.Account(short).Balance(1).Available))
+	assert.Equal(t, 0, len(s.Account(short).PendingOrders()))
+	assert.Equal(t, 190, int(s.Account(PerpAddr(pks[0].Addr(), market)).Balance(1).Available))
+}
@@ -0,0 +1,142 @@
+package dex
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+type PortfolioArgs struct {
+	Addr consensus.Addr
+	// Quote is the token that the portfolio is valued in.
+	Quote TokenID
+}
+
+// PortfolioBalance is the value of an account's balance of a token.
+type PortfolioBalance struct {
+	Token TokenID
+	// Quant is the sum of the available, pending and frozen
+	// quantities.
+	Quant uint64
+	// Price is the price of the token in the quote token with
+	// OrderPriceDecimals decimals.
+	Price uint64
+	Value uint64
+	// Priced is false if the token has no reference price in the
+	// quote token, its value is not counted in the total.
+	Priced bool
+}
+
+// PortfolioOrder is the value of the quantity locked by an open order.
+type PortfolioOrder struct {
+	ID OrderID
+	// Token is the locked token: the base token of a sell order or
+	// the quote token of a buy order.
+	Token  TokenID
+	Locked uint64
+	Value  uint64
+	Priced bool
+}
+
+// Portfolio is the valuation of an account at the reference prices.
+// The quantities locked by the open orders are part of the pending
+// balances, Orders breaks them down and is not counted in Total again.
+type Portfolio struct {
+	Quote    TokenID
+	Round    uint64
+	Total    uint64
+	Balances []PortfolioBalance
+	Orders   []PortfolioOrder
+}
+
+// tokenPrice returns the price of the token in the quote token with
+// OrderPriceDecimals decimals, from the reference price of the market
+// of the tokens, either way around.
+func tokenPrice(s *State, id, quote TokenID) (uint64, bool) {
+	if id == quote {
+		return uint64(math.Pow10(OrderPriceDecimals)), true
+	}
+
+	if p, ok := s.RefPrice(MarketSymbol{Base: id, Quote: quote}); ok && p.Price > 0 {
+		return p.Price, true
+	}
+
+	if p, ok := s.RefPrice(MarketSymbol{Base: quote, Quote: id}); ok && p.Price > 0 {
+		one := uint64(math.Pow10(OrderPriceDecimals))
+		return mulDiv(one, one, p.Price), true
+	}
+
+	return 0, false
+}
+
+// valuePortfolio values the account's balances and open orders in the
+// quote token at the reference prices.
+func valuePortfolio(s *State, args PortfolioArgs) (Portfolio, error) {
+	tokens := s.tokenCache()
+	quoteInfo := tokens.Info(args.Quote)
+	if quoteInfo == zeroInfo {
+		return Portfolio{}, fmt.Errorf("token %d does not exist", args.Quote)
+	}
+
+	acc := s.Account(args.Addr)
+	if acc == nil {
+		return Portfolio{}, fmt.Errorf("account %v does not exist", args.Addr)
+	}
+
+	value := func(id TokenID, quant uint64) (price, v uint64, ok bool) {
+		price, ok = tokenPrice(s, id, args.Quote)
+		if !ok {
+			return
+		}
+
+		v = calcQuoteQuant(quant, quoteInfo.Decimals, price, OrderPriceDecimals, tokens.Info(id).Decimals)
+		return
+	}
+
+	s.mu.Lock()
+	round := s.round
+	s.mu.Unlock()
+
+	p := Portfolio{Quote: args.Quote, Round: round}
+	var total Uint128
+	acc.loadBalances()
+	ids := make([]TokenID, 0, len(acc.balances))
+	for id, b := range acc.balances {
+		if !b.Empty() {
+			ids = append(ids, id)
+		}
+	}
+	sortTokenIDs(ids)
+
+	for _, id := range ids {
+		b := acc.Balance(id)
+		quants := []uint64{b.Available, b.Pending}
+		for _, f := range b.Frozen {
+			quants = append(quants, f.Quant)
+		}
+
+		r := PortfolioBalance{Token: id, Quant: sumQuant(quants...).Saturate()}
+		r.Price, r.Value, r.Priced = value(id, r.Quant)
+		total, _ = total.Add(NewUint128(r.Value))
+		p.Balances = append(p.Balances, r)
+	}
+	p.Total = total.Saturate()
+
+	for _, o := range acc.PendingOrders() {
+		r := PortfolioOrder{ID: o.ID}
+		remain := o.Quant - o.Executed
+		if o.SellSide {
+			r.Token = o.ID.Market.Base
+			r.Locked = remain
+		} else {
+			r.Token = o.ID.Market.Quote
+			r.Locked = calcQuoteQuant(remain, tokens.Info(o.ID.Market.Quote).Decimals, o.Price, OrderPriceDecimals, tokens.Info(o.ID.Market.Base).Decimals)
+		}
+
+		_, r.Value, r.Priced = value(r.Token, r.Locked)
+		p.Orders = append(p.Orders, r)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,47 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValuePortfolio(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 1e18}})
+	s.UpdateToken(Token{ID: 2, TokenInfo: TokenInfo{Symbol: "ETH", Decimals: 8, TotalUnits: 1e18}})
+	s.UpdateToken(Token{ID: 3, TokenInfo: TokenInfo{Symbol: "XRP", Decimals: 8, TotalUnits: 1e18}})
+	pk, sk := RandKeyPair()
+	acc := s.NewAccount(pk)
+	acc.UpdateBalance(0, Balance{Available: 1e8})
+	acc.UpdateBalance(1, Balance{Available: 2e8})
+	acc.UpdateBalance(2, Balance{Available: 10e8})
+	acc.UpdateBalance(3, Balance{Available: 100e8})
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+
+	// ETH priced in BTC directly, BTC priced in XRP is inverted.
+	s.UpdateRefPrice(MarketSymbol{Base: 2, Quote: 1}, RefPrice{Price: 5e6})
+	s.UpdateRefPrice(MarketSymbol{Base: 1, Quote: 3}, RefPrice{Price: 1e12})
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	order := PlaceOrderTxn{SellSide: true, Quant: 4e8, Price: 6e6, Market: MarketSymbol{Base: 2, Quote: 1}}
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, pk.Addr(), order, 0), pker))
+	s = trans.Commit().(*State)
+
+	p, err := valuePortfolio(s, PortfolioArgs{Addr: pk.Addr(), Quote: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(p.Balances))
+	assert.False(t, p.Balances[0].Priced)
+	assert.Equal(t, PortfolioBalance{Token: 1, Quant: 2e8, Price: 1e8, Value: 2e8, Priced: true}, p.Balances[1])
+	// the pending quantity of the sell order is valued too.
+	assert.Equal(t, PortfolioBalance{Token: 2, Quant: 10e8, Price: 5e6, Value: 5e7, Priced: true}, p.Balances[2])
+	assert.Equal(t, PortfolioBalance{Token: 3, Quant: 100e8, Price: 1e4, Value: 1e6, Priced: true}, p.Balances[3])
+	assert.Equal(t, uint64(2e8+5e7+1e6), p.Total)
+	assert.Equal(t, []PortfolioOrder{{ID: OrderID{ID: 0, Market: order.Market}, Token: 2, Locked: 4e8, Value: 2e7, Priced: true}}, p.Orders)
+
+	_, err = valuePortfolio(s, PortfolioArgs{Addr: pk.Addr(), Quote: 9})
+	assert.NotNil(t, err)
+}
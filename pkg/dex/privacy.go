@@ -0,0 +1,26 @@
+package dex
+
+import "github.com/helinwang/dex/pkg/consensus"
+
+// The book and trade APIs hide the owners of the orders and the
+// trades, as the zero address, when either the node's policy or the
+// request asks for it, so that an operator can publish anonymized
+// feeds. A request can hide the owners on top of the node's policy,
+// but can not reveal them against it.
+
+func (r *RPCServer) ownersHidden(hide bool) bool {
+	return r.hideOwners || hide
+}
+
+func hideBookOwners(orders []BookOrder) {
+	for i := range orders {
+		orders[i].Owner = consensus.Addr{}
+	}
+}
+
+func hideTradeOwners(records []TradeRecord) {
+	for i := range records {
+		records[i].Buyer = consensus.Addr{}
+		records[i].Seller = consensus.Addr{}
+	}
+}
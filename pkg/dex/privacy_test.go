@@ -0,0 +1,47 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHideOwners(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	m := MarketSymbol{Base: 1, Quote: 0}
+	a, b := consensus.Addr{1}, consensus.Addr{2}
+	book := newOrderBook()
+	book.Limit(Order{Owner: a, Price: 10, Quant: 5})
+	book.Limit(Order{Owner: b, Price: 11, Quant: 6, SellSide: true})
+	s.saveOrderBook(m, book)
+	s.UpdateTradeRecords(1, []TradeRecord{{MatchedTrade: MatchedTrade{Round: 1, Market: m, Buyer: a, Seller: b, Quant: 1}}})
+
+	r := NewRPCServer()
+	r.snap.Store(s)
+	var l3 OrderBookL3
+	var records []TradeRecord
+	assert.Nil(t, r.orderBookL3(OrderBookL3Args{Market: m}, &l3))
+	assert.Equal(t, a, l3.Bids[0].Owner)
+	assert.Nil(t, r.tradeRecords(TradeRecordsArgs{Round: 1}, &records))
+	assert.Equal(t, b, records[0].Seller)
+
+	// the request hides the owners.
+	assert.Nil(t, r.orderBookL3(OrderBookL3Args{Market: m, HideOwners: true}, &l3))
+	assert.Equal(t, consensus.Addr{}, l3.Bids[0].Owner)
+	assert.Equal(t, consensus.Addr{}, l3.Asks[0].Owner)
+	assert.Nil(t, r.tradeRecords(TradeRecordsArgs{Round: 1, HideOwners: true}, &records))
+	assert.Equal(t, consensus.Addr{}, records[0].Buyer)
+	assert.Equal(t, consensus.Addr{}, records[0].Seller)
+	assert.Equal(t, uint64(1), records[0].Quant)
+
+	// the node's policy applies to every request.
+	r.HideOrderOwners()
+	var o MarketOrderState
+	assert.Nil(t, r.marketOrders(MarketOrdersArgs{Market: m}, &o))
+	assert.True(t, o.OwnersHidden)
+	assert.Equal(t, consensus.Addr{}, o.Bids[0].Owner)
+	assert.Nil(t, r.tradeRecords(TradeRecordsArgs{Round: 1}, &records))
+	assert.Equal(t, consensus.Addr{}, records[0].Buyer)
+}
@@ -0,0 +1,297 @@
+package dex
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+const (
+	// MaxPageLimit is the max number of the items of a page.
+	MaxPageLimit     = 1000
+	defaultPageLimit = 100
+	// walletStateReports is the number of the latest execution
+	// reports in the wallet state, the older ones are queried
+	// with the ExecutionReports RPC.
+	walletStateReports = 100
+	// maxPageScan is the max number of the items scanned for a
+	// page, a page with a selective filter could return fewer
+	// items than the limit, along with the cursor to continue.
+	maxPageScan = 10 * MaxPageLimit
+)
+
+// PageArgs selects a page of a query. Cursor is the NextCursor of the
+// previous page, or 0 for the first page.
+type PageArgs struct {
+	Cursor uint64
+	// Limit is the max number of the items in the page, 0 means
+	// the default limit of 100.
+	Limit int
+}
+
+func (p PageArgs) limit() (int, error) {
+	if p.Limit < 0 || p.Limit > MaxPageLimit {
+		return 0, fmt.Errorf("page limit must be between 0 and %d, got: %d", MaxPageLimit, p.Limit)
+	}
+
+	if p.Limit == 0 {
+		return defaultPageLimit, nil
+	}
+	return p.Limit, nil
+}
+
+// QueryFilter filters the items of a query.
+type QueryFilter struct {
+	// Market is nil for all the markets.
+	Market *MarketSymbol
+	// FromRound and ToRound is the inclusive round range, 0 means
+	// unbounded.
+	FromRound uint64
+	ToRound   uint64
+}
+
+func (f QueryFilter) match(m MarketSymbol, round uint64) bool {
+	if f.Market != nil && *f.Market != m {
+		return false
+	}
+
+	if f.FromRound > 0 && round < f.FromRound {
+		return false
+	}
+
+	return f.ToRound == 0 || round <= f.ToRound
+}
+
+type ExecutionReportsArgs struct {
+	Addr consensus.Addr
+	QueryFilter
+	PageArgs
+}
+
+// ExecutionReportPage is a page of the execution reports in the
+// order of execution. More is false if it is the last page.
+type ExecutionReportPage struct {
+	Reports    []ExecutionReport
+	NextCursor uint64
+	More       bool
+}
+
+func queryExecutionReports(s *State, args ExecutionReportsArgs, p *ExecutionReportPage) error {
+	limit, err := args.limit()
+	if err != nil {
+		return err
+	}
+
+	// the cursor is the index of the next report.
+	n := uint64(s.ReportIdx(args.Addr))
+	idx := args.Cursor
+	scanned := 0
+	for ; idx < n && len(p.Reports) < limit && scanned < maxPageScan; idx++ {
+		scanned++
+		r, ok := s.ExecutionReport(args.Addr, uint32(idx))
+		if !ok || !args.match(r.ID.Market, r.Round) {
+			continue
+		}
+
+		p.Reports = append(p.Reports, r)
+	}
+
+	p.NextCursor = idx
+	p.More = idx < n
+	return nil
+}
+
+type DepositsArgs struct {
+	Addr consensus.Addr
+	PageArgs
+}
+
+// DepositPage is a page of the deposits to the deposit addresses of
+// the account in the order of the deposits. More is false if it is
+// the last page.
+type DepositPage struct {
+	Deposits   []Deposit
+	NextCursor uint64
+	More       bool
+}
+
+func queryDeposits(s *State, args DepositsArgs, p *DepositPage) error {
+	limit, err := args.limit()
+	if err != nil {
+		return err
+	}
+
+	// the cursor is the sequence number of the next deposit.
+	n := uint64(s.DepositCount(args.Addr))
+	seq := args.Cursor
+	for ; seq < n && len(p.Deposits) < limit; seq++ {
+		d, ok := s.Deposit(args.Addr, uint32(seq))
+		if ok {
+			p.Deposits = append(p.Deposits, d)
+		}
+	}
+
+	p.NextCursor = seq
+	p.More = seq < n
+	return nil
+}
+
+// latestExecutionReports returns the latest n execution reports in
+// the order of execution.
+func latestExecutionReports(s *State, addr consensus.Addr, n int) []ExecutionReport {
+	end := s.ReportIdx(addr)
+	start := uint32(0)
+	if end > uint32(n) {
+		start = end - uint32(n)
+	}
+
+	var r []ExecutionReport
+	for idx := start; idx < end; idx++ {
+		e, ok := s.ExecutionReport(addr, idx)
+		if ok {
+			r = append(r, e)
+		}
+	}
+	return r
+}
+
+type PendingOrdersArgs struct {
+	Addr consensus.Addr
+	// Market is nil for all the markets.
+	Market *MarketSymbol
+	PageArgs
+}
+
+// PendingOrderPage is a page of the pending orders sorted by market
+// and order ID. More is false if it is the last page.
+type PendingOrderPage struct {
+	Orders     []PendingOrder
+	NextCursor uint64
+	More       bool
+}
+
+func queryPendingOrders(s *State, args PendingOrdersArgs, p *PendingOrderPage) error {
+	limit, err := args.limit()
+	if err != nil {
+		return err
+	}
+
+	var orders []PendingOrder
+	for _, o := range s.PendingOrders(args.Addr) {
+		if args.Market == nil || *args.Market == o.ID.Market {
+			orders = append(orders, o)
+		}
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		return orderIDLess(orders[i].ID, orders[j].ID)
+	})
+
+	// the cursor is the position of the next order, the orders
+	// placed or removed between the pages shift the positions.
+	start := args.Cursor
+	if start > uint64(len(orders)) {
+		start = uint64(len(orders))
+	}
+	end := start + uint64(limit)
+	if end > uint64(len(orders)) {
+		end = uint64(len(orders))
+	}
+
+	p.Orders = orders[start:end]
+	p.NextCursor = end
+	p.More = end < uint64(len(orders))
+	return nil
+}
+
+type MarketOrdersArgs struct {
+	Market MarketSymbol
+	// Owner marks the owner's orders, e.g., for a depth view with
+	// the own orders. The zero address marks none.
+	Owner      consensus.Addr
+	HideOwners bool
+}
+
+// MarketOrder is a resting order of a market, Own is true if it's an
+// order of the requested owner.
+type MarketOrder struct {
+	BookOrder
+	Own bool
+}
+
+// MarketOrderState is the resting orders of a market, each side in
+// the price-time priority. When OwnersHidden is true the owners of
+// the orders other than the requested owner's are the zero address.
+type MarketOrderState struct {
+	Market       MarketSymbol
+	OwnersHidden bool
+	Bids         []MarketOrder
+	Asks         []MarketOrder
+}
+
+func queryMarketOrders(s *State, args MarketOrdersArgs, hideOwners bool, r *MarketOrderState) error {
+	if !args.Market.Valid() {
+		return fmt.Errorf("market is invalid: %v", args.Market)
+	}
+
+	var zero consensus.Addr
+	side := func(orders []BookOrder) []MarketOrder {
+		r := make([]MarketOrder, len(orders))
+		for i, o := range orders {
+			r[i] = MarketOrder{BookOrder: o, Own: args.Owner != zero && o.Owner == args.Owner}
+			if hideOwners && !r[i].Own {
+				r[i].Owner = zero
+			}
+		}
+		return r
+	}
+
+	bids, asks := s.MarketOrders(args.Market)
+	*r = MarketOrderState{Market: args.Market, OwnersHidden: hideOwners, Bids: side(bids), Asks: side(asks)}
+	return nil
+}
+
+func orderIDLess(a, b OrderID) bool {
+	if a.Market.Base != b.Market.Base {
+		return a.Market.Base < b.Market.Base
+	}
+	if a.Market.Quote != b.Market.Quote {
+		return a.Market.Quote < b.Market.Quote
+	}
+	return a.ID < b.ID
+}
+
+type MMLedgerArgs struct {
+	Market MarketSymbol
+}
+
+// MMLedgerState is the market maker program of a market and its
+// ledger.
+type MMLedgerState struct {
+	Program MMProgram
+	Enabled bool
+	Ledger  MMLedger
+}
+
+func queryMMLedger(s *State, args MMLedgerArgs, r *MMLedgerState) error {
+	r.Program, r.Enabled = s.MMProgram(args.Market)
+	r.Ledger = s.MMLedger(args.Market)
+	if !r.Enabled && len(r.Ledger.Makers) == 0 {
+		return fmt.Errorf("market %v has no market maker program", args.Market)
+	}
+
+	return nil
+}
+
+type TradeRecordsArgs struct {
+	Round      uint64
+	HideOwners bool
+}
+
+// queryTradeRecords returns the matched trades of the round within
+// the bust window, with their index.
+func queryTradeRecords(s *State, round uint64, r *[]TradeRecord) error {
+	*r = s.TradeRecords(round)
+	return nil
+}
@@ -0,0 +1,120 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryExecutionReports(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	pk, _ := RandKeyPair()
+	addr := pk.Addr()
+	m0 := MarketSymbol{Base: 1, Quote: 0}
+	m1 := MarketSymbol{Base: 2, Quote: 0}
+	for i := uint32(0); i < 10; i++ {
+		m := m0
+		if i%2 == 1 {
+			m = m1
+		}
+		s.AddExecutionReport(addr, ExecutionReport{Round: uint64(i + 1), ID: OrderID{ID: uint64(i), Market: m}}, i)
+	}
+	s.UpdateReportIdx(addr, 10)
+
+	var p ExecutionReportPage
+	assert.Nil(t, queryExecutionReports(s, ExecutionReportsArgs{Addr: addr, PageArgs: PageArgs{Limit: 4}}, &p))
+	assert.Equal(t, 4, len(p.Reports))
+	assert.Equal(t, uint64(1), p.Reports[0].Round)
+	assert.Equal(t, uint64(4), p.NextCursor)
+	assert.True(t, p.More)
+
+	var ids []uint64
+	args := ExecutionReportsArgs{Addr: addr, QueryFilter: QueryFilter{Market: &m1, FromRound: 3}, PageArgs: PageArgs{Limit: 2}}
+	for {
+		var p ExecutionReportPage
+		assert.Nil(t, queryExecutionReports(s, args, &p))
+		for _, r := range p.Reports {
+			ids = append(ids, r.ID.ID)
+		}
+		if !p.More {
+			break
+		}
+		args.Cursor = p.NextCursor
+	}
+	assert.Equal(t, []uint64{3, 5, 7, 9}, ids)
+
+	p = ExecutionReportPage{}
+	assert.Nil(t, queryExecutionReports(s, ExecutionReportsArgs{Addr: addr, QueryFilter: QueryFilter{ToRound: 2}}, &p))
+	assert.Equal(t, 2, len(p.Reports))
+
+	assert.Equal(t, []ExecutionReport{
+		{Round: 9, ID: OrderID{ID: 8, Market: m0}},
+		{Round: 10, ID: OrderID{ID: 9, Market: m1}},
+	}, latestExecutionReports(s, addr, 2))
+
+	assert.NotNil(t, queryExecutionReports(s, ExecutionReportsArgs{Addr: addr, PageArgs: PageArgs{Limit: MaxPageLimit + 1}}, &p))
+}
+
+func TestQueryPendingOrders(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	pk, _ := RandKeyPair()
+	addr := pk.Addr()
+	m0 := MarketSymbol{Base: 1, Quote: 0}
+	m1 := MarketSymbol{Base: 2, Quote: 0}
+	for i := uint64(0); i < 5; i++ {
+		s.UpdatePendingOrder(addr, PendingOrder{ID: OrderID{ID: i, Market: m1}})
+		s.UpdatePendingOrder(addr, PendingOrder{ID: OrderID{ID: i, Market: m0}})
+	}
+
+	var p PendingOrderPage
+	assert.Nil(t, queryPendingOrders(s, PendingOrdersArgs{Addr: addr, PageArgs: PageArgs{Limit: 6}}, &p))
+	assert.Equal(t, 6, len(p.Orders))
+	assert.Equal(t, OrderID{ID: 0, Market: m0}, p.Orders[0].ID)
+	assert.Equal(t, OrderID{ID: 0, Market: m1}, p.Orders[5].ID)
+	assert.True(t, p.More)
+
+	assert.Nil(t, queryPendingOrders(s, PendingOrdersArgs{Addr: addr, PageArgs: PageArgs{Cursor: p.NextCursor, Limit: 6}}, &p))
+	assert.Equal(t, 4, len(p.Orders))
+	assert.False(t, p.More)
+
+	assert.Nil(t, queryPendingOrders(s, PendingOrdersArgs{Addr: addr, Market: &m1, PageArgs: PageArgs{Cursor: 3}}, &p))
+	assert.Equal(t, []PendingOrder{{ID: OrderID{ID: 3, Market: m1}}, {ID: OrderID{ID: 4, Market: m1}}}, p.Orders)
+	assert.False(t, p.More)
+}
+
+func TestQueryMarketOrders(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	m := MarketSymbol{Base: 1, Quote: 0}
+	a, b := consensus.Addr{1}, consensus.Addr{2}
+	book := newOrderBook()
+	book.Limit(Order{Owner: a, Price: 10, Quant: 5})
+	book.Limit(Order{Owner: b, Price: 11, Quant: 6})
+	book.Cancel(book.Add(Order{Owner: a, Price: 9, Quant: 1}))
+	book.Limit(Order{Owner: b, Price: 12, Quant: 7, SellSide: true})
+	s.saveOrderBook(m, book)
+
+	var r MarketOrderState
+	assert.NotNil(t, queryMarketOrders(s, MarketOrdersArgs{Market: MarketSymbol{}}, false, &r))
+	assert.Nil(t, queryMarketOrders(s, MarketOrdersArgs{Market: m, Owner: a}, false, &r))
+	assert.Equal(t, MarketOrderState{
+		Market: m,
+		Bids: []MarketOrder{
+			{BookOrder: BookOrder{ID: 1, Owner: b, Price: 11, Quant: 6}},
+			{BookOrder: BookOrder{ID: 0, Owner: a, Price: 10, Quant: 5}, Own: true},
+		},
+		Asks: []MarketOrder{{BookOrder: BookOrder{ID: 3, Owner: b, SellSide: true, Price: 12, Quant: 7}}},
+	}, r)
+
+	// only the requested owner is shown when the owners are hidden.
+	assert.Nil(t, queryMarketOrders(s, MarketOrdersArgs{Market: m, Owner: a}, true, &r))
+	assert.True(t, r.OwnersHidden)
+	assert.Equal(t, consensus.Addr{}, r.Bids[0].Owner)
+	assert.Equal(t, a, r.Bids[1].Owner)
+	assert.Equal(t, consensus.Addr{}, r.Asks[0].Owner)
+
+	assert.Nil(t, queryMarketOrders(s, MarketOrdersArgs{Market: MarketSymbol{Base: 2, Quote: 0}}, false, &r))
+	assert.Empty(t, r.Bids)
+	assert.Empty(t, r.Asks)
+}
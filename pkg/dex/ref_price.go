@@ -0,0 +1,114 @@
+package dex
+
+import "sort"
+
+const (
+	// refPriceRounds is the number of recent rounds that the
+	// reference price is computed over.
+	refPriceRounds = 20
+	// priceBandDenominator is the denominator of the price band.
+	priceBandDenominator = 1000000
+)
+
+// PriceSample is the traded price and volume of a market in a
+// round.
+type PriceSample struct {
+	Round  uint64
+	Price  uint64
+	Volume uint64
+}
+
+// RefPrice is the reference price of a market. It's the volume
+// weighted median of the per round traded prices over the recent
+// refPriceRounds rounds, a single large trade at an outlying price
+// can not move the reference price unless it's backed by the
+// majority of the traded volume.
+type RefPrice struct {
+	Price uint64
+	// Round is the round that the price is last updated.
+	Round   uint64
+	Samples []PriceSample
+}
+
+// weightedMedian returns the volume weighted median price of the
+// samples.
+func weightedMedian(samples []PriceSample) uint64 {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	sorted := make([]PriceSample, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Price < sorted[j].Price
+	})
+
+	var total uint64
+	for _, s := range sorted {
+		total += s.Volume
+	}
+
+	var sum uint64
+	for _, s := range sorted {
+		sum += s.Volume
+		if sum*2 >= total {
+			return s.Price
+		}
+	}
+
+	return sorted[len(sorted)-1].Price
+}
+
+// update adds the trades of the round to the reference price and
+// drops the samples older than refPriceRounds rounds.
+func (r *RefPrice) update(round uint64, trades []PriceSample) {
+	sample := PriceSample{Round: round, Price: weightedMedian(trades)}
+	for _, t := range trades {
+		sample.Volume += t.Volume
+	}
+
+	samples := r.Samples[:0]
+	for _, s := range r.Samples {
+		if s.Round+refPriceRounds > round {
+			samples = append(samples, s)
+		}
+	}
+
+	r.Samples = append(samples, sample)
+	r.Price = weightedMedian(r.Samples)
+	r.Round = round
+}
+
+// priceBand returns the lowest and the highest prices within band
+// parts per million of the reference price.
+func priceBand(ref, band uint64) (low, high uint64) {
+	d := mulDiv(ref, band, priceBandDenominator)
+	if d < ref {
+		low = ref - d
+	}
+
+	high = sumQuant(ref, d).Saturate()
+	return
+}
+
+// checkPriceBand returns an error if the order's price is out of the
+// market's price band, the band does not apply before the market
+// has a reference price.
+func (t *Transition) checkPriceBand(txn *PlaceOrderTxn) error {
+	band := t.state.MarketConfig(txn.Market).PriceBand
+	if band == 0 {
+		return nil
+	}
+
+	ref, ok := t.state.RefPrice(txn.Market)
+	if !ok || ref.Price == 0 {
+		return nil
+	}
+
+	low, high := priceBand(ref.Price, band)
+	if txn.Price < low || txn.Price > high {
+		return txnErrorf(ErrCodePriceBand, "order price %d is out of the price band [%d, %d] of the reference price %d", txn.Price, low, high, ref.Price)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWeightedMedian(t *testing.T) {
+	assert.Equal(t, 0, int(weightedMedian(nil)))
+	samples := []PriceSample{
+		{Price: 100, Volume: 1},
+		{Price: 3, Volume: 10},
+		{Price: 2, Volume: 5},
+	}
+	assert.Equal(t, 3, int(weightedMedian(samples)))
+	// the input is not modified
+	assert.Equal(t, 100, int(samples[0].Price))
+}
+
+func TestRefPriceUpdate(t *testing.T) {
+	var p RefPrice
+	p.update(1, []PriceSample{{Price: 10, Volume: 5}, {Price: 12, Volume: 1}})
+	assert.Equal(t, 10, int(p.Price))
+	assert.Equal(t, 6, int(p.Samples[0].Volume))
+
+	// a large outlying trade backed by little volume does not
+	// move the price
+	p.update(2, []PriceSample{{Price: 1000, Volume: 1}})
+	assert.Equal(t, 10, int(p.Price))
+	assert.Equal(t, 2, int(p.Round))
+
+	// old samples are dropped
+	p.update(refPriceRounds+1, []PriceSample{{Price: 20, Volume: 1}})
+	assert.Equal(t, 2, len(p.Samples))
+	assert.Equal(t, 20, int(p.Price))
+}
+
+func TestPriceBand(t *testing.T) {
+	low, high := priceBand(100, 200000)
+	assert.Equal(t, 80, int(low))
+	assert.Equal(t, 120, int(high))
+	low, high = priceBand(100, 2000000)
+	assert.Equal(t, 0, int(low))
+	assert.Equal(t, 300, int(high))
+	_, high = priceBand(math.MaxUint64, 1)
+	assert.Equal(t, uint64(math.MaxUint64), high)
+
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	one := uint64(math.Pow10(OrderPriceDecimals))
+	market := MarketSymbol{Base: 1, Quote: 0}
+	pk, sk := RandKeyPair()
+	addr := pk.Addr()
+	acc := s.NewAccount(pk)
+	acc.UpdateBalance(0, Balance{Available: 1000000})
+	acc.UpdateBalance(1, Balance{Available: 1000000})
+	s.UpdateTokenIssuer(1, addr)
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	config := MarketConfigTxn{Market: market, MarketConfigInfo: MarketConfigInfo{PriceBand: 200000}}
+	assert.Nil(t, recordTxn(t, trans, MakeMarketConfigTxn(sk, addr, config, 0), pker))
+	// no reference price yet, the band does not apply.
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{Quant: 100, Price: one * 10, Market: market}, 1), pker))
+	s = trans.Commit().(*State)
+
+	s.UpdateRefPrice(market, RefPrice{Price: one})
+	trans = s.Transition(2, 0, nil).(*Transition)
+	err := recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{Quant: 100, Price: one * 121 / 100, Market: market}, 2), pker)
+	assert.Equal(t, ErrCodePriceBand, ErrorCode(err))
+	err = recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 100, Price: one * 79 / 100, Market: market}, 2), pker)
+	assert.Equal(t, ErrCodePriceBand, ErrorCode(err))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{Quant: 100, Price: one * 12 / 10, Market: market}, 2), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 100, Price: one * 8 / 10, Market: market}, 3), pker))
+}
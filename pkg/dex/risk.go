@@ -0,0 +1,93 @@
+package dex
+
+import (
+	"fmt"
+)
+
+// RiskLimitLooseningDelay is the number of rounds before a looser
+// risk limit applies, so that a runaway algo with the account's key
+// can not lift the limit right away.
+const RiskLimitLooseningDelay = 20
+
+// RiskLimit is the self-imposed limit of an account's orders in a
+// market, 0 means no limit. MaxOrderQuant is the max quantity of a
+// single order in the base token, MaxOpenNotional is the max total
+// value of the pending orders in the quote token.
+type RiskLimit struct {
+	MaxOrderQuant   uint64
+	MaxOpenNotional uint64
+}
+
+// within returns true if the limit is not looser than v.
+func (l RiskLimit) within(v RiskLimit) bool {
+	le := func(a, b uint64) bool {
+		return b == 0 || (a != 0 && a <= b)
+	}
+	return le(l.MaxOrderQuant, v.MaxOrderQuant) && le(l.MaxOpenNotional, v.MaxOpenNotional)
+}
+
+// RiskLimits is the account's risk limit of a market, and the looser
+// limit that applies from NextRound.
+type RiskLimits struct {
+	Current   RiskLimit
+	Next      RiskLimit
+	NextRound uint64
+}
+
+// At returns the risk limit at the round.
+func (l RiskLimits) At(round uint64) RiskLimit {
+	if l.NextRound > 0 && round >= l.NextRound {
+		return l.Next
+	}
+
+	return l.Current
+}
+
+func (t *Transition) setRiskLimit(owner *Account, txn *SetRiskLimitTxn) error {
+	if !txn.Market.Valid() {
+		return txnErrorf(ErrCodeBadMarket, "market is invalid: %v", txn.Market)
+	}
+
+	addr := owner.PK().Addr()
+	cur := t.state.RiskLimits(addr, txn.Market).At(t.round)
+	l := RiskLimits{Current: cur}
+	if txn.Limit.within(cur) {
+		l.Current = txn.Limit
+	} else {
+		l.Next = txn.Limit
+		l.NextRound = t.round + RiskLimitLooseningDelay
+	}
+
+	t.state.UpdateRiskLimits(addr, txn.Market, l)
+	return nil
+}
+
+// checkRiskLimit returns an error if the order exceeds the owner's
+// risk limit of the market.
+func (t *Transition) checkRiskLimit(owner *Account, txn *PlaceOrderTxn) error {
+	l := t.state.RiskLimits(owner.PK().Addr(), txn.Market).At(t.round)
+	if l.MaxOrderQuant > 0 && txn.Quant > l.MaxOrderQuant {
+		return fmt.Errorf("order quantity %d exceeds the risk limit %d", txn.Quant, l.MaxOrderQuant)
+	}
+
+	if l.MaxOpenNotional == 0 {
+		return nil
+	}
+
+	baseInfo := t.tokenCache.Info(txn.Market.Base)
+	quoteInfo := t.tokenCache.Info(txn.Market.Quote)
+	notional := calcQuoteQuant(txn.Quant, quoteInfo.Decimals, txn.Price, OrderPriceDecimals, baseInfo.Decimals)
+	for _, o := range owner.PendingOrders() {
+		if o.ID.Market != txn.Market {
+			continue
+		}
+
+		notional += calcQuoteQuant(o.Quant-o.Executed, quoteInfo.Decimals, o.Price, OrderPriceDecimals, baseInfo.Decimals)
+	}
+
+	if notional > l.MaxOpenNotional {
+		return fmt.Errorf("open notional %d exceeds the risk limit %d", notional, l.MaxOpenNotional)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRiskLimitWithin(t *testing.T) {
+	assert.True(t, RiskLimit{MaxOrderQuant: 10}.within(RiskLimit{}))
+	assert.True(t, RiskLimit{MaxOrderQuant: 10}.within(RiskLimit{MaxOrderQuant: 20}))
+	assert.False(t, RiskLimit{}.within(RiskLimit{MaxOrderQuant: 20}))
+	assert.False(t, RiskLimit{MaxOrderQuant: 10}.within(RiskLimit{MaxOrderQuant: 20, MaxOpenNotional: 5}))
+}
+
+func TestRiskLimit(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(1, Balance{Available: 1000})
+	addr := pk.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+	m := MarketSymbol{Base: 1, Quote: 0}
+	price := 2 * uint64(math.Pow10(OrderPriceDecimals))
+	order := func(quant uint64, nonce uint64) []byte {
+		return MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: quant, Price: price, Market: m}, nonce)
+	}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeSetRiskLimitTxn(sk, addr, SetRiskLimitTxn{Market: m, Limit: RiskLimit{MaxOrderQuant: 100, MaxOpenNotional: 500}}, 0), pker))
+	assert.NotNil(t, recordTxn(t, trans, order(101, 1), pker), "fat finger")
+	assert.Nil(t, recordTxn(t, trans, order(100, 1), pker))
+	assert.Nil(t, recordTxn(t, trans, order(100, 2), pker))
+	assert.NotNil(t, recordTxn(t, trans, order(100, 3), pker), "open notional 600 over 500")
+	assert.Nil(t, recordTxn(t, trans, order(50, 3), pker))
+	// loosening is delayed.
+	assert.Nil(t, recordTxn(t, trans, MakeSetRiskLimitTxn(sk, addr, SetRiskLimitTxn{Market: m}, 4), pker))
+	assert.NotNil(t, recordTxn(t, trans, order(200, 5), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, RiskLimits{Current: RiskLimit{MaxOrderQuant: 100, MaxOpenNotional: 500}, NextRound: 1 + RiskLimitLooseningDelay}, s.RiskLimits(addr, m))
+
+	trans = s.Transition(1+RiskLimitLooseningDelay, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, order(200, 5), pker))
+	// tightening applies immediately.
+	assert.Nil(t, recordTxn(t, trans, MakeSetRiskLimitTxn(sk, addr, SetRiskLimitTxn{Market: m, Limit: RiskLimit{MaxOrderQuant: 10}}, 6), pker))
+	assert.NotNil(t, recordTxn(t, trans, order(11, 7), pker))
+	// the liquidation orders are not subject to the risk limit.
+	assert.Nil(t, trans.placeOrderImpl(s.Account(addr), &PlaceOrderTxn{SellSide: true, Quant: 11, Price: price, Market: m}, trans.round))
+}
@@ -0,0 +1,330 @@
+package dex
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/rpc"
+	"sync"
+	"time"
+
+	log "github.com/helinwang/log15"
+)
+
+const (
+	// maxRateLimitDelay is the max delay of a call over the rate
+	// limit, the connection is closed if the call has to wait
+	// longer.
+	maxRateLimitDelay = 10 * time.Second
+	// rateLimitIdle is how long the bucket of a client is kept
+	// without calls.
+	rateLimitIdle = 10 * time.Minute
+)
+
+var (
+	errRateLimited   = errors.New("rate limit exceeded")
+	errAPIKeyRevoked = errors.New("API key revoked")
+)
+
+// Quota is the limit of the RPC calls and connections of a client.
+type Quota struct {
+	// Rate is the calls per second, with bursts of Burst calls.
+	// 0 means no limit.
+	Rate  float64
+	Burst int
+	// MaxConns is the max number of the connections, 0 means no
+	// limit.
+	MaxConns int
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	conns  int
+}
+
+// rateLimiter limits the RPC calls and connections of each client
+// with a token bucket.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	lastGC  time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+func (l *rateLimiter) bucket(key string, q Quota, now time.Time) *tokenBucket {
+	if now.Sub(l.lastGC) > rateLimitIdle {
+		for k, b := range l.buckets {
+			if b.conns == 0 && now.Sub(b.last) > rateLimitIdle {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastGC = now
+	}
+
+	b := l.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: float64(q.Burst), last: now}
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// reserve takes a token of the client, it returns how long the call
+// must wait for the token.
+func (l *rateLimiter) reserve(key string, q Quota, now time.Time) time.Duration {
+	if q.Rate <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, q, now)
+	b.tokens += now.Sub(b.last).Seconds() * q.Rate
+	if b.tokens > float64(q.Burst) {
+		b.tokens = float64(q.Burst)
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / q.Rate * float64(time.Second))
+}
+
+// allow takes a token of the client without waiting, it returns
+// false if there is no token.
+func (l *rateLimiter) allow(key string, q Quota, now time.Time) bool {
+	if l.reserve(key, q, now) > 0 {
+		l.cancel(key)
+		return false
+	}
+
+	return true
+}
+
+// cancel returns the token of a call that does not wait.
+func (l *rateLimiter) cancel(key string) {
+	l.mu.Lock()
+	if b := l.buckets[key]; b != nil {
+		b.tokens++
+	}
+	l.mu.Unlock()
+}
+
+func (l *rateLimiter) connect(key string, q Quota, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, q, now)
+	if q.MaxConns > 0 && b.conns >= q.MaxConns {
+		return false
+	}
+	b.conns++
+	return true
+}
+
+func (l *rateLimiter) disconnect(key string) {
+	l.mu.Lock()
+	l.buckets[key].conns--
+	l.mu.Unlock()
+}
+
+// rpcClient is the client of an RPC connection, identified by its API
+// key, or by its IP if it did not present a key.
+type rpcClient struct {
+	// key is the rate limiter key of the client.
+	key   string
+	quota Quota
+	// keys is nil if the client did not present an API key. The
+	// key is looked up for every call, so that a revocation or a
+	// quota change applies to the open connections.
+	keys  *APIKeyStore
+	keyID string
+}
+
+// limitedCodec is the gob codec of net/rpc, delaying the calls over
+// the rate limit of the client and rejecting the calls not permitted
+// by its API key.
+type limitedCodec struct {
+	rwc     io.ReadWriteCloser
+	dec     *gob.Decoder
+	enc     *gob.Encoder
+	encBuf  *bufio.Writer
+	limiter *rateLimiter
+	client  rpcClient
+	closed  bool
+}
+
+func newLimitedCodec(conn io.ReadWriteCloser, l *rateLimiter, client rpcClient) *limitedCodec {
+	buf := bufio.NewWriter(conn)
+	return &limitedCodec{
+		rwc:     conn,
+		dec:     gob.NewDecoder(conn),
+		enc:     gob.NewEncoder(buf),
+		encBuf:  buf,
+		limiter: l,
+		client:  client,
+	}
+}
+
+func (c *limitedCodec) ReadRequestHeader(r *rpc.Request) error {
+	err := c.dec.Decode(r)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	q := c.client.quota
+	var key APIKey
+	if c.client.keys != nil {
+		var ok bool
+		key, ok = c.client.keys.get(c.client.keyID, now)
+		if !ok {
+			log.Warn("closing RPC connection of a revoked API key", "key", c.client.keyID)
+			return errAPIKeyRevoked
+		}
+		q = key.Quota
+	}
+
+	d := c.limiter.reserve(c.client.key, q, now)
+	if d > maxRateLimitDelay {
+		c.limiter.cancel(c.client.key)
+		log.Warn("closing RPC connection over the rate limit", "client", c.client.key)
+		return errRateLimited
+	}
+	time.Sleep(d)
+
+	if c.client.keys != nil && !key.permits(r.ServiceMethod) {
+		// net/rpc has no hook to reject a call, a method
+		// that does not exist makes the server discard the
+		// args and reply with an error containing the name.
+		r.ServiceMethod = fmt.Sprintf("permission denied: API key %s can not call %s", key.ID, r.ServiceMethod)
+	}
+	return nil
+}
+
+func (c *limitedCodec) ReadRequestBody(body interface{}) error {
+	err := c.dec.Decode(body)
+	if err != nil {
+		return err
+	}
+
+	if args, ok := body.(*CancelSessionArgs); ok {
+		// the session belongs to the API key of the
+		// connection, net/rpc does not pass the connection
+		// to the methods.
+		args.keyID = c.client.keyID
+	}
+	return nil
+}
+
+func (c *limitedCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	err := c.enc.Encode(r)
+	if err != nil {
+		if c.encBuf.Flush() == nil {
+			c.Close()
+		}
+		return err
+	}
+
+	err = c.enc.Encode(body)
+	if err != nil {
+		if c.encBuf.Flush() == nil {
+			c.Close()
+		}
+		return err
+	}
+
+	return c.encBuf.Flush()
+}
+
+func (c *limitedCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
+
+// rpcHandler serves net/rpc over HTTP like rpc.Server.ServeHTTP. The
+// clients presenting an API key are limited by the quota of the key,
+// the other clients by the quota of their IP.
+type rpcHandler struct {
+	server  *rpc.Server
+	limiter *rateLimiter
+	// ipQuota is the quota of each client IP without an API key.
+	ipQuota Quota
+	// keys is nil if the API keys are disabled.
+	keys *APIKeyStore
+	// requireKey rejects the clients without an API key.
+	requireKey bool
+	// sessions is notified of the connections with an API key,
+	// it can be nil.
+	sessions *cancelSessions
+}
+
+func (h *rpcHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "CONNECT" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		io.WriteString(w, "405 must CONNECT\n")
+		return
+	}
+
+	client, err := h.authenticate(req, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if !h.limiter.connect(client.key, client.quota, time.Now()) {
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
+	}
+	defer h.limiter.disconnect(client.key)
+	if h.sessions != nil && client.keyID != "" {
+		h.sessions.connect(client.keyID)
+		defer h.sessions.disconnect(client.keyID)
+	}
+
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		log.Error("error hijacking RPC connection", "client", req.RemoteAddr, "err", err)
+		return
+	}
+
+	io.WriteString(conn, "HTTP/1.0 200 Connected to Go RPC\n\n")
+	h.server.ServeCodec(newLimitedCodec(conn, h.limiter, client))
+}
+
+func (h *rpcHandler) authenticate(req *http.Request, now time.Time) (rpcClient, error) {
+	id := req.Header.Get(APIKeyHeader)
+	if id == "" || h.keys == nil {
+		if h.requireKey {
+			return rpcClient{}, errors.New("API key required")
+		}
+
+		ip, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			ip = req.RemoteAddr
+		}
+		return rpcClient{key: "ip:" + ip, quota: h.ipQuota}, nil
+	}
+
+	key, err := h.keys.verify(id, req.Header.Get(APITimestampHeader), req.Header.Get(APISignatureHeader), now)
+	if err != nil {
+		return rpcClient{}, err
+	}
+
+	return rpcClient{key: "key:" + key.ID, quota: key.Quota, keys: h.keys, keyID: key.ID}, nil
+}
@@ -0,0 +1,67 @@
+package dex
+
+import (
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := newRateLimiter()
+	q := Quota{Rate: 10, Burst: 2, MaxConns: 1}
+	now := time.Now()
+	assert.Equal(t, time.Duration(0), l.reserve("a", q, now))
+	assert.Equal(t, time.Duration(0), l.reserve("a", q, now))
+	assert.Equal(t, 100*time.Millisecond, l.reserve("a", q, now))
+	assert.Equal(t, 200*time.Millisecond, l.reserve("a", q, now))
+	// the clients are limited separately.
+	assert.Equal(t, time.Duration(0), l.reserve("b", q, now))
+	// the bucket refills at the rate up to the burst.
+	assert.Equal(t, time.Duration(0), l.reserve("a", q, now.Add(time.Hour)))
+	assert.Equal(t, time.Duration(0), l.reserve("a", q, now.Add(time.Hour)))
+	assert.Equal(t, 100*time.Millisecond, l.reserve("a", q, now.Add(time.Hour)))
+
+	assert.True(t, l.connect("a", q, now))
+	assert.False(t, l.connect("a", q, now))
+	l.disconnect("a")
+	assert.True(t, l.connect("a", q, now))
+}
+
+type echoService struct{}
+
+func (echoService) Echo(n int, r *int) error {
+	*r = n
+	return nil
+}
+
+func TestRPCHandlerRateLimit(t *testing.T) {
+	server := rpc.NewServer()
+	assert.Nil(t, server.RegisterName("Echo", echoService{}))
+	ts := httptest.NewServer(&rpcHandler{
+		server:  server,
+		limiter: newRateLimiter(),
+		ipQuota: Quota{Rate: 20, Burst: 1, MaxConns: 1},
+	})
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	client, err := rpc.DialHTTP("tcp", addr)
+	assert.Nil(t, err)
+	defer client.Close()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		var r int
+		assert.Nil(t, client.Call("Echo.Echo", i, &r))
+		assert.Equal(t, i, r)
+	}
+	// the calls after the burst are delayed to 20 per second.
+	assert.True(t, time.Since(start) >= 150*time.Millisecond)
+
+	_, err = rpc.DialHTTP("tcp", addr)
+	assert.NotNil(t, err, "over the connection limit")
+}
@@ -6,7 +6,8 @@ import (
 	"net"
 	"net/http"
 	"net/rpc"
-	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/helinwang/dex/pkg/consensus"
 	log "github.com/helinwang/log15"
@@ -18,20 +19,63 @@ type TxnSender interface {
 
 type ChainStater interface {
 	ChainStatus() consensus.ChainStatus
+	Finality() consensus.Finality
+	FinalizedState() consensus.State
+	Snapshot() (*consensus.Snapshot, error)
 	Graphviz(int) string
 	TxnPoolSize() int
+	HeadEvents(after uint64, wait time.Duration) ([]consensus.HeadEvent, bool, error)
+	TxnProof(txn consensus.Hash) (*consensus.TxnProof, error)
+}
+
+// MaxHeadPollWait is the max duration that a poll of the chain head
+// events waits for new events.
+const MaxHeadPollWait = 30 * time.Second
+
+type HeadEventsArgs struct {
+	// After is the sequence number of the last received event.
+	After uint64
+	// Wait is the max duration to wait for new events.
+	Wait time.Duration
+}
+
+type HeadEvents struct {
+	Events []consensus.HeadEvent
+	// Gap is true when the events after After are dropped, the
+	// consumer must treat the data derived from the unfinalized
+	// blocks as invalid.
+	Gap bool
 }
 
 type RPCServer struct {
-	sender TxnSender
+	sender  TxnSender
+	tickers *tickers
+	streams *accountStreams
+	feeds   *orderFeeds
+	depths  *depthFeeds
+	indexes *indexFeed
+	blocked *blocklistFeed
+	// surveillance is nil unless enabled.
+	surveillance *Surveillance
+	limiter      *rateLimiter
+	ipQuota      Quota
+	keys         *APIKeyStore
+	// requireKey rejects the clients without an API key.
+	requireKey bool
+	sessions   *cancelSessions
+	// hideOwners hides the owners in the book and trade APIs.
+	hideOwners bool
 
-	mu    sync.Mutex
 	chain ChainStater
-	s     *State
+	// snap is the *State snapshot of the latest state taken at
+	// each update, the queries load it without a lock.
+	snap atomic.Value
 }
 
 func NewRPCServer() *RPCServer {
-	return &RPCServer{}
+	r := &RPCServer{tickers: newTickers(), streams: newAccountStreams(), feeds: newOrderFeeds(), depths: newDepthFeeds(), indexes: newIndexFeed(), blocked: newBlocklistFeed(), limiter: newRateLimiter()}
+	r.sessions = newCancelSessions(func(t []byte) { r.sender.SendTxn(t) })
+	return r
 }
 
 // SetSender sets the transaction sender, it must be called before
@@ -40,33 +84,93 @@ func (r *RPCServer) SetSender(sender TxnSender) {
 	r.sender = sender
 }
 
+// SetRateLimit limits the RPC calls of each client IP without an API
+// key to rate calls per second with bursts of burst calls, and the
+// connections of each client IP to maxConns. It must be called before
+// Start.
+func (r *RPCServer) SetRateLimit(rate float64, burst, maxConns int) {
+	r.ipQuota = Quota{Rate: rate, Burst: burst, MaxConns: maxConns}
+}
+
+// SetAPIKeys enables the API keys, the clients presenting a key are
+// limited by the quota of the key instead of the quota of their IP.
+// If requireKey is true the clients without a key are rejected. It
+// must be called before Start.
+func (r *RPCServer) SetAPIKeys(keys *APIKeyStore, requireKey bool) {
+	r.keys = keys
+	r.requireKey = requireKey
+}
+
+// SetDepthBuffer sets the number of the recent deltas retained for
+// each depth feed, a subscriber further behind recovers from a
+// snapshot. It must be called before Start.
+func (r *RPCServer) SetDepthBuffer(n int) {
+	r.depths.setBuffer(n)
+}
+
+// EnableSurveillance indexes the trades of the recent window rounds
+// for the wash trading patterns, it must be called before Start.
+func (r *RPCServer) EnableSurveillance(window uint64) *Surveillance {
+	r.surveillance = NewSurveillance(window)
+	return r.surveillance
+}
+
+// HideOrderOwners hides the owners of the orders and the trades from
+// every request to the book and trade APIs. It must be called before
+// Start.
+func (r *RPCServer) HideOrderOwners() {
+	r.hideOwners = true
+}
+
 // SetStater sets the chain stater, it must be called before Start.
 func (r *RPCServer) SetStater(c ChainStater) {
 	r.chain = c
 }
 
+// ReportTxnConflict reports the conflicting txns to the account
+// streams of the owner, it's the conflict handler of the txn pool.
+func (r *RPCServer) ReportTxnConflict(c TxnConflict) {
+	r.streams.conflict(c)
+}
+
 func (r *RPCServer) Update(state consensus.State) {
 	s := state.(*State)
-	r.mu.Lock()
-	r.s = s
-	r.mu.Unlock()
+	r.tickers.update(time.Now(), s)
+	r.streams.update(s)
+	r.feeds.update(s)
+	r.depths.update(s)
+	r.indexes.update(s)
+	r.blocked.update(s)
+	if r.surveillance != nil {
+		r.surveillance.Update(s)
+	}
+	r.snap.Store(s.querySnapshot())
 }
 
 func (r *RPCServer) Start(addr string) error {
 	w := &WalletService{s: r}
 
-	err := rpc.Register(w)
+	server := rpc.NewServer()
+	err := server.Register(w)
 	if err != nil {
 		return err
 	}
 
-	rpc.HandleHTTP()
+	mux := http.NewServeMux()
+	mux.Handle(rpc.DefaultRPCPath, &rpcHandler{
+		server:     server,
+		limiter:    r.limiter,
+		ipQuota:    r.ipQuota,
+		keys:       r.keys,
+		requireKey: r.requireKey,
+		sessions:   r.sessions,
+	})
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
 	go func() {
-		err = http.Serve(l, nil)
+		err = http.Serve(l, mux)
 		if err != nil {
 			log.Error("error serving RPC server", "err", err)
 		}
@@ -74,8 +178,18 @@ func (r *RPCServer) Start(addr string) error {
 	return nil
 }
 
+// TokenState is the tokens. Issuers is the issuers of the tokens by
+// token ID, the tokens created in the genesis state have no issuer.
+// Verified is the verified issuers, the wallets should warn about the
+// issued tokens without one. Delisted is the delisted tokens.
+// Restricted is the tokens that only the whitelisted addresses can
+// hold.
 type TokenState struct {
-	Tokens []Token
+	Tokens     []Token
+	Issuers    map[TokenID]consensus.Addr
+	Verified   map[TokenID]VerifiedIssuer
+	Delisted   map[TokenID]Delisting
+	Restricted map[TokenID]bool
 }
 
 type UserBalance struct {
@@ -83,21 +197,38 @@ type UserBalance struct {
 	Balance
 }
 
+// WalletState is the state of an account, ExecutionReports is the
+// latest 100 execution reports.
 type WalletState struct {
+	// Nonce is the nonce of the next txn of the account.
+	Nonce            uint64
 	Balances         []UserBalance
 	PendingOrders    []PendingOrder
 	ExecutionReports []ExecutionReport
 }
 
 func (r *RPCServer) walletState(addr consensus.Addr, w *WalletState) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	return accountWalletState(s, addr, w)
+}
 
-	if r.s == nil {
-		return errors.New("waiting for reaching consensus")
+// finalizedWalletState returns the wallet state of the latest
+// finalized block, the deposits in it are irreversible.
+func (r *RPCServer) finalizedWalletState(addr consensus.Addr, w *WalletState) error {
+	s, ok := r.chain.FinalizedState().(*State)
+	if !ok {
+		return errors.New("finalized state not found")
 	}
 
-	acc := r.s.Account(addr)
+	return accountWalletState(s, addr, w)
+}
+
+func accountWalletState(s *State, addr consensus.Addr, w *WalletState) error {
+	acc := s.Account(addr)
 	if acc == nil {
 		return fmt.Errorf("account %v does not exist", addr)
 	}
@@ -116,21 +247,182 @@ func (r *RPCServer) walletState(addr consensus.Addr, w *WalletState) error {
 		bs[i].Balance = acc.Balance(keys[i])
 	}
 
+	w.Nonce = acc.Nonce()
 	w.PendingOrders = acc.PendingOrders()
-	w.ExecutionReports = acc.ExecutionReports()
+	w.ExecutionReports = latestExecutionReports(s, addr, walletStateReports)
 	w.Balances = bs
 	return nil
 }
 
 func (r *RPCServer) tokens(_ int, t *TokenState) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	t.Tokens = s.Tokens()
+	t.Issuers = make(map[TokenID]consensus.Addr)
+	t.Verified = make(map[TokenID]VerifiedIssuer)
+	t.Delisted = make(map[TokenID]Delisting)
+	t.Restricted = make(map[TokenID]bool)
+	for _, token := range t.Tokens {
+		if addr, ok := s.TokenIssuer(token.ID); ok {
+			t.Issuers[token.ID] = addr
+		}
+
+		if v, ok := s.VerifiedIssuer(token.ID); ok {
+			t.Verified[token.ID] = v
+		}
+
+		if d, ok := s.Delisting(token.ID); ok {
+			t.Delisted[token.ID] = d
+		}
+
+		if s.TokenRestricted(token.ID) {
+			t.Restricted[token.ID] = true
+		}
+	}
+	return nil
+}
+
+func (r *RPCServer) portfolio(args PortfolioArgs, p *Portfolio) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	v, err := valuePortfolio(s, args)
+	if err != nil {
+		return err
+	}
+
+	*p = v
+	return nil
+}
+
+func (r *RPCServer) pendingTransfers(addr consensus.Addr, l *[]PendingTransfer) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	*l = s.PendingTransfers(addr)
+	return nil
+}
+
+func (r *RPCServer) blockedAddr(addr consensus.Addr, b *BlockedAddr) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	v, ok := s.Blocked(addr)
+	if !ok {
+		return fmt.Errorf("address %v is not blocked", addr)
+	}
+
+	*b = v
+	return nil
+}
+
+func (r *RPCServer) blocklistEvents(args BlocklistEventsArgs, e *BlocklistEvents) error {
+	events, err := r.blocked.after(args.After)
+	if err != nil {
+		return err
+	}
+
+	*e = events
+	return nil
+}
+
+func (r *RPCServer) refPrice(m MarketSymbol, p *RefPrice) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	ref, ok := s.RefPrice(m)
+	if !ok {
+		return fmt.Errorf("market %v has no reference price", m)
+	}
+
+	*p = ref
+	return nil
+}
+
+func (r *RPCServer) lendingPool(id TokenID, p *LendingPool) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	*p = s.LendingPool(id)
+	return nil
+}
+
+func (r *RPCServer) bridgeWithdrawal(id uint64, w *BridgeWithdrawal) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	withdrawal, ok := s.BridgeWithdrawal(id)
+	if !ok {
+		return fmt.Errorf("withdrawal %d does not exist", id)
+	}
 
-	if r.s == nil {
-		return errors.New("waiting for reaching consensus")
+	*w = withdrawal
+	return nil
+}
+
+func (r *RPCServer) oraclePrice(id TokenID, p *OraclePrice) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	price, ok := s.OraclePrice(id)
+	if !ok {
+		return fmt.Errorf("token %d has no oracle price", id)
 	}
 
-	t.Tokens = r.s.Tokens()
+	*p = price
+	return nil
+}
+
+func (r *RPCServer) ibcPacketRoot(round uint64, root *IBCPacketRoot) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	v, ok := s.IBCPacketRoot(round)
+	if !ok {
+		return fmt.Errorf("no packet is sent in round %d", round)
+	}
+
+	*root = v
+	return nil
+}
+
+// IBCPacketArgs is the argument of the IBCPacket RPC.
+type IBCPacketArgs struct {
+	Channel  uint64
+	Sequence uint64
+}
+
+func (r *RPCServer) ibcPacket(args IBCPacketArgs, p *IBCPacket) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	v, ok := s.IBCPacket(args.Channel, args.Sequence)
+	if !ok {
+		return fmt.Errorf("packet %d of channel %d does not exist", args.Sequence, args.Channel)
+	}
+
+	*p = v
 	return nil
 }
 
@@ -139,6 +431,218 @@ func (r *RPCServer) sendTxn(t []byte, _ *int) error {
 	return nil
 }
 
+type DepthArgs struct {
+	Market MarketSymbol
+	Levels int
+}
+
+func (r *RPCServer) depth(args DepthArgs, d *Depth) error {
+	if args.Levels <= 0 || args.Levels > MaxDepthLevels {
+		return fmt.Errorf("levels must be between 1 and %d, got: %d", MaxDepthLevels, args.Levels)
+	}
+
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	*d = s.Depth(args.Market, args.Levels)
+	return nil
+}
+
+func (r *RPCServer) state() (*State, error) {
+	s, _ := r.snap.Load().(*State)
+	if s == nil {
+		return nil, errors.New("waiting for reaching consensus")
+	}
+
+	return s, nil
+}
+
+func (r *RPCServer) orderBookL3(args OrderBookL3Args, l3 *OrderBookL3) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	*l3 = r.feeds.book(s, args.Market)
+	if r.ownersHidden(args.HideOwners) {
+		hideBookOwners(l3.Bids)
+		hideBookOwners(l3.Asks)
+	}
+	return nil
+}
+
+func (r *RPCServer) marketOrders(args MarketOrdersArgs, o *MarketOrderState) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	return queryMarketOrders(s, args, r.ownersHidden(args.HideOwners), o)
+}
+
+func (r *RPCServer) depthSnapshot(args DepthArgs, d *Depth) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	return r.depths.snapshot(s, args, d)
+}
+
+func (r *RPCServer) depthDeltas(args DepthDeltasArgs, d *DepthDeltas) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	return r.depths.poll(s, args, d)
+}
+
+func (r *RPCServer) orderFeed(args OrderFeedArgs, e *OrderFeedEvents) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	err = r.feeds.poll(s, args, e)
+	if err != nil {
+		return err
+	}
+
+	if r.ownersHidden(args.HideOwners) {
+		for i := range e.Events {
+			e.Events[i].Order.Owner = consensus.Addr{}
+		}
+	}
+	return nil
+}
+
+func (r *RPCServer) tickerState(t *TickerState) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	t.Tickers = r.tickers.tickers(time.Now(), s)
+	return nil
+}
+
+func (r *RPCServer) subscribeAccount(args SubscribeAccountArgs, session *string) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	id, err := r.streams.subscribe(time.Now(), s, args)
+	if err != nil {
+		return err
+	}
+
+	*session = id
+	return nil
+}
+
+func (r *RPCServer) executionReports(args ExecutionReportsArgs, p *ExecutionReportPage) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	return queryExecutionReports(s, args, p)
+}
+
+func (r *RPCServer) deposits(args DepositsArgs, p *DepositPage) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	return queryDeposits(s, args, p)
+}
+
+func (r *RPCServer) mmLedger(args MMLedgerArgs, l *MMLedgerState) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	return queryMMLedger(s, args, l)
+}
+
+func (r *RPCServer) tradeRecords(args TradeRecordsArgs, records *[]TradeRecord) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	err = queryTradeRecords(s, args.Round, records)
+	if err != nil {
+		return err
+	}
+
+	if r.ownersHidden(args.HideOwners) {
+		hideTradeOwners(*records)
+	}
+	return nil
+}
+
+func (r *RPCServer) validatorQueue(q *[]ValidatorRegistration) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	*q = s.ValidatorQueue()
+	return nil
+}
+
+func (r *RPCServer) indexStates(l *[]IndexState) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	indexes := s.Indexes()
+	r2 := make([]IndexState, len(indexes))
+	for i, idx := range indexes {
+		p, _ := s.IndexPrice(idx.Name)
+		r2[i] = IndexState{Index: idx, Price: p}
+	}
+	*l = r2
+	return nil
+}
+
+func (r *RPCServer) indexEvents(args IndexEventsArgs, e *IndexEvents) error {
+	events, err := r.indexes.after(args.After)
+	if err != nil {
+		return err
+	}
+
+	*e = events
+	return nil
+}
+
+func (r *RPCServer) verifyBooks(checks *[]BookCheck) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	*checks = s.VerifyBooks()
+	return nil
+}
+
+func (r *RPCServer) pendingOrders(args PendingOrdersArgs, p *PendingOrderPage) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	return queryPendingOrders(s, args, p)
+}
+
 func (r *RPCServer) round(round *uint64) error {
 	state := r.chain.ChainStatus()
 	*round = state.Round
@@ -160,24 +664,113 @@ func (r *RPCServer) chainStatus(state *consensus.ChainStatus) error {
 	return nil
 }
 
+func (r *RPCServer) finality(f *consensus.Finality) error {
+	*f = r.chain.Finality()
+	return nil
+}
+
+func (r *RPCServer) headEvents(args HeadEventsArgs, e *HeadEvents) error {
+	wait := args.Wait
+	if wait > MaxHeadPollWait {
+		wait = MaxHeadPollWait
+	}
+
+	events, gap, err := r.chain.HeadEvents(args.After, wait)
+	if err != nil {
+		return err
+	}
+
+	*e = HeadEvents{Events: events, Gap: gap}
+	return nil
+}
+
+func (r *RPCServer) txnProof(txn consensus.Hash, p *consensus.TxnProof) error {
+	proof, err := r.chain.TxnProof(txn)
+	if err != nil {
+		return err
+	}
+
+	*p = *proof
+	return nil
+}
+
+func (r *RPCServer) snapshot(s *consensus.Snapshot) error {
+	snapshot, err := r.chain.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	*s = *snapshot
+	return nil
+}
+
+// TxnCheck is the result of the dry run of a txn on the latest state
+// of the node, Code is empty if the txn can be recorded.
+type TxnCheck struct {
+	Code string
+	Err  string
+}
+
+func (r *RPCServer) checkTxn(b []byte, c *TxnCheck) error {
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	txn, err := parseTxn(b, s)
+	if err != nil {
+		if _, ok := err.(*TxnError); ok {
+			*c = TxnCheck{Code: ErrorCode(err), Err: err.Error()}
+			return nil
+		}
+		return err
+	}
+
+	if txn.MinerFeeTxn {
+		return errors.New("can not check the miner fee txn")
+	}
+
+	// the transition is discarded, the fee is charged to check
+	// the owner can pay it.
+	now := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	trans := s.Transition(r.chain.ChainStatus().Round, now, nil).(*Transition)
+	err = trans.RecordImpl(txn, true)
+	if err != nil {
+		*c = TxnCheck{Code: ErrorCode(err), Err: err.Error()}
+	}
+	return nil
+}
+
 func (r *RPCServer) nonce(addr consensus.Addr, nonce *uint64) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	return r.laneNonce(LaneNonceArgs{Addr: addr}, nonce)
+}
+
+// LaneNonceArgs is the account and the nonce lane to get the nonce
+// of.
+type LaneNonceArgs struct {
+	Addr consensus.Addr
+	Lane uint8
+}
 
+func (r *RPCServer) laneNonce(args LaneNonceArgs, nonce *uint64) error {
 	// TODO: returns a nonce that does not collide with the ones
 	// in the pending txns.
 
-	if r.s == nil {
-		return errors.New("waiting for reaching consensus")
+	s, err := r.state()
+	if err != nil {
+		return err
+	}
+
+	if args.Lane >= NonceLanes {
+		return fmt.Errorf("invalid nonce lane %d, there are %d lanes", args.Lane, NonceLanes)
 	}
 
-	acc := r.s.Account(addr)
+	acc := s.Account(args.Addr)
 	if acc == nil {
-		return fmt.Errorf("account %v does not exist", addr)
+		return fmt.Errorf("account %v does not exist", args.Addr)
 	}
 
-	n := acc.Nonce()
-	*nonce = n
+	*nonce = acc.LaneNonce(args.Lane)
 	return nil
 }
 
@@ -190,18 +783,192 @@ func (s *WalletService) WalletState(addr consensus.Addr, w *WalletState) error {
 	return s.s.walletState(addr, w)
 }
 
+func (s *WalletService) FinalizedWalletState(addr consensus.Addr, w *WalletState) error {
+	return s.s.finalizedWalletState(addr, w)
+}
+
 func (s *WalletService) Tokens(d int, t *TokenState) error {
 	return s.s.tokens(d, t)
 }
 
+// Portfolio values the account's balances and open orders in the
+// quote token at the reference prices.
+func (s *WalletService) Portfolio(args PortfolioArgs, p *Portfolio) error {
+	return s.s.portfolio(args, p)
+}
+
+// PendingTransfers returns the account's announced transfers, so the
+// guardian can veto them before they are executed.
+func (s *WalletService) PendingTransfers(addr consensus.Addr, l *[]PendingTransfer) error {
+	return s.s.pendingTransfers(addr, l)
+}
+
+// Blocked returns the blocklist entry of the address, it returns an
+// error if the address is not blocked.
+func (s *WalletService) Blocked(addr consensus.Addr, b *BlockedAddr) error {
+	return s.s.blockedAddr(addr, b)
+}
+
+// BlocklistEvents returns the addresses blocked or unblocked after
+// args.After, for the compliance monitoring.
+func (s *WalletService) BlocklistEvents(args BlocklistEventsArgs, e *BlocklistEvents) error {
+	return s.s.blocklistEvents(args, e)
+}
+
+func (s *WalletService) RefPrice(m MarketSymbol, p *RefPrice) error {
+	return s.s.refPrice(m, p)
+}
+
+func (s *WalletService) LendingPool(id TokenID, p *LendingPool) error {
+	return s.s.lendingPool(id, p)
+}
+
+func (s *WalletService) BridgeWithdrawal(id uint64, w *BridgeWithdrawal) error {
+	return s.s.bridgeWithdrawal(id, w)
+}
+
+func (s *WalletService) OraclePrice(id TokenID, p *OraclePrice) error {
+	return s.s.oraclePrice(id, p)
+}
+
+func (s *WalletService) IBCPacketRoot(round uint64, root *IBCPacketRoot) error {
+	return s.s.ibcPacketRoot(round, root)
+}
+
+func (s *WalletService) IBCPacket(args IBCPacketArgs, p *IBCPacket) error {
+	return s.s.ibcPacket(args, p)
+}
+
+func (s *WalletService) Depth(args DepthArgs, d *Depth) error {
+	return s.s.depth(args, d)
+}
+
+// DepthSnapshot returns the depth with the sequence number of its
+// feed, the deltas after it keep the depth up to date.
+func (s *WalletService) DepthSnapshot(args DepthArgs, d *Depth) error {
+	return s.s.depthSnapshot(args, d)
+}
+
+// DepthDeltas returns the depth deltas after args.After, it blocks
+// until there are new deltas or args.Wait passes. If the deltas are
+// no longer retained, it returns the snapshot to recover from.
+func (s *WalletService) DepthDeltas(args DepthDeltasArgs, d *DepthDeltas) error {
+	return s.s.depthDeltas(args, d)
+}
+
+// OrderBookL3 returns every order of the book of the market with the
+// sequence number of the order feed, the feed events after it keep
+// the book up to date.
+func (s *WalletService) OrderBookL3(args OrderBookL3Args, l3 *OrderBookL3) error {
+	return s.s.orderBookL3(args, l3)
+}
+
+// OrderFeed returns the order events of the market after args.After,
+// it blocks until there are new events or args.Wait passes.
+func (s *WalletService) OrderFeed(args OrderFeedArgs, e *OrderFeedEvents) error {
+	return s.s.orderFeed(args, e)
+}
+
+// MarketOrders returns the resting orders of the market, the orders
+// of args.Owner are marked.
+func (s *WalletService) MarketOrders(args MarketOrdersArgs, o *MarketOrderState) error {
+	return s.s.marketOrders(args, o)
+}
+
+func (s *WalletService) Tickers(_ int, t *TickerState) error {
+	return s.s.tickerState(t)
+}
+
+// AccountChallenge returns a challenge that the account signs to
+// subscribe to its events.
+func (s *WalletService) AccountChallenge(addr consensus.Addr, c *[]byte) error {
+	*c = s.s.streams.challenge(time.Now(), addr)
+	return nil
+}
+
+func (s *WalletService) SubscribeAccount(args SubscribeAccountArgs, session *string) error {
+	return s.s.subscribeAccount(args, session)
+}
+
+// PollAccount returns the events of the subscription, it blocks until
+// there are new events or args.Wait passes.
+func (s *WalletService) PollAccount(args PollAccountArgs, e *AccountEvents) error {
+	return s.s.streams.poll(args, e)
+}
+
+func (s *WalletService) ExecutionReports(args ExecutionReportsArgs, p *ExecutionReportPage) error {
+	return s.s.executionReports(args, p)
+}
+
+func (s *WalletService) Deposits(args DepositsArgs, p *DepositPage) error {
+	return s.s.deposits(args, p)
+}
+
+// MMLedger returns the market maker program of the market with the
+// records of the current epoch and the rewards of the last epoch.
+func (s *WalletService) MMLedger(args MMLedgerArgs, l *MMLedgerState) error {
+	return s.s.mmLedger(args, l)
+}
+
+// TradeRecords returns the matched trades of the round, including
+// the busted ones, if the round is within the bust window.
+func (s *WalletService) TradeRecords(args TradeRecordsArgs, records *[]TradeRecord) error {
+	return s.s.tradeRecords(args, records)
+}
+
+// ValidatorQueue returns the validators queued for the DKG of the
+// next epochs.
+func (s *WalletService) ValidatorQueue(_ int, q *[]ValidatorRegistration) error {
+	return s.s.validatorQueue(q)
+}
+
+// Indexes returns the defined indexes with their latest values.
+func (s *WalletService) Indexes(_ int, l *[]IndexState) error {
+	return s.s.indexStates(l)
+}
+
+// IndexEvents returns the index value changes after args.After, the
+// events of the recent blocks are kept.
+func (s *WalletService) IndexEvents(args IndexEventsArgs, e *IndexEvents) error {
+	return s.s.indexEvents(args, e)
+}
+
+// VerifyBooks recomputes the digests of the stored order books and
+// compares them with the committed digests.
+func (s *WalletService) VerifyBooks(_ int, checks *[]BookCheck) error {
+	return s.s.verifyBooks(checks)
+}
+
+func (s *WalletService) PendingOrders(args PendingOrdersArgs, p *PendingOrderPage) error {
+	return s.s.pendingOrders(args, p)
+}
+
 func (s *WalletService) SendTxn(t []byte, d *int) error {
 	return s.s.sendTxn(t, d)
 }
 
+// RegisterCancelSession registers the cancel-on-disconnect session
+// of the API key of the connection.
+func (s *WalletService) RegisterCancelSession(args CancelSessionArgs, _ *int) error {
+	return s.s.registerCancelSession(args)
+}
+
+// CheckTxn dry runs the txn on the latest state, returning the error
+// code if the txn would be rejected.
+func (s *WalletService) CheckTxn(t []byte, c *TxnCheck) error {
+	return s.s.checkTxn(t, c)
+}
+
 func (s *WalletService) Nonce(addr consensus.Addr, n *uint64) error {
 	return s.s.nonce(addr, n)
 }
 
+// LaneNonce returns the nonce of the next txn of the account's nonce
+// lane.
+func (s *WalletService) LaneNonce(args LaneNonceArgs, n *uint64) error {
+	return s.s.laneNonce(args, n)
+}
+
 func (s *WalletService) Round(_ int, r *uint64) error {
 	return s.s.round(r)
 }
@@ -210,6 +977,30 @@ func (s *WalletService) ChainStatus(_ int, state *consensus.ChainStatus) error {
 	return s.s.chainStatus(state)
 }
 
+func (s *WalletService) Finality(_ int, f *consensus.Finality) error {
+	return s.s.finality(f)
+}
+
+// HeadEvents returns the chain head events after args.After: the new
+// heads, the finalized blocks and the reorgs with their common
+// ancestors. It blocks until there are new events or args.Wait
+// passes.
+func (s *WalletService) HeadEvents(args HeadEventsArgs, e *HeadEvents) error {
+	return s.s.headEvents(args, e)
+}
+
+// TxnProof returns the inclusion proof of the txn of the hash in a
+// notarized block of the heaviest chain, the light clients verify it
+// with consensus.TxnProof.Verify and the notarization of the block
+// header.
+func (s *WalletService) TxnProof(txn consensus.Hash, p *consensus.TxnProof) error {
+	return s.s.txnProof(txn, p)
+}
+
+func (s *WalletService) Snapshot(_ int, snapshot *consensus.Snapshot) error {
+	return s.s.snapshot(snapshot)
+}
+
 func (s *WalletService) Graphviz(_ int, str *string) error {
 	return s.s.graphviz(str)
 }
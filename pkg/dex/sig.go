@@ -2,8 +2,10 @@ package dex
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/crypto/secp256k1"
@@ -19,6 +21,52 @@ type Credential struct {
 	SK SK
 }
 
+// SigScheme signs and verifies the txns of the account keys of a
+// signature scheme.
+type SigScheme interface {
+	Sign(sk SK, msg []byte) Sig
+	Verify(msg []byte, pk PK, sig Sig) bool
+}
+
+const (
+	// Secp256k1Scheme is the first byte of the uncompressed
+	// secp256k1 public keys. The secp256k1 secret keys are the
+	// 32 bytes scalars.
+	Secp256k1Scheme byte = 0x04
+	// Ed25519Scheme is the first byte of the ed25519 public and
+	// secret keys, followed by the key in the standard encoding.
+	Ed25519Scheme byte = 0xed
+)
+
+const secp256k1SKLen = 32
+
+// sigSchemes is the registered signature schemes by the first byte
+// of the public keys, guarded by sigSchemesMu since the txns are
+// verified concurrently.
+var (
+	sigSchemesMu sync.RWMutex
+	sigSchemes   = map[byte]SigScheme{
+		Secp256k1Scheme: secp256k1Scheme{},
+		Ed25519Scheme:   ed25519Scheme{},
+	}
+)
+
+// RegisterSigScheme registers the signature scheme of the public keys
+// that start with the prefix byte.
+func RegisterSigScheme(prefix byte, s SigScheme) {
+	sigSchemesMu.Lock()
+	sigSchemes[prefix] = s
+	sigSchemesMu.Unlock()
+}
+
+func sigScheme(prefix byte) (SigScheme, bool) {
+	sigSchemesMu.RLock()
+	s, ok := sigSchemes[prefix]
+	sigSchemesMu.RUnlock()
+	return s, ok
+}
+
+// RandKeyPair returns a random secp256k1 key pair.
 func RandKeyPair() (PK, SK) {
 	key, err := ecdsa.GenerateKey(secp256k1.S256(), rand.Reader)
 	if err != nil {
@@ -28,13 +76,57 @@ func RandKeyPair() (PK, SK) {
 	return PK(pubkey), SK(math.PaddedBigBytes(key.D, 32))
 }
 
+// RandEd25519KeyPair returns a random ed25519 key pair.
+func RandEd25519KeyPair() (PK, SK) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	return PK(append([]byte{Ed25519Scheme}, pub...)), SK(append([]byte{Ed25519Scheme}, priv...))
+}
+
 func (p PK) Addr() consensus.Addr {
 	return consensus.SHA3(p).Addr()
 }
 
+// Sign signs the message, it returns nil if the secret key is empty
+// or of an unknown scheme. A nil signature never verifies.
 func (s SK) Sign(msg []byte) Sig {
+	if len(s) == secp256k1SKLen {
+		return secp256k1Scheme{}.Sign(s, msg)
+	}
+
+	if len(s) == 0 {
+		return nil
+	}
+
+	scheme, ok := sigScheme(s[0])
+	if !ok {
+		return nil
+	}
+
+	return scheme.Sign(s, msg)
+}
+
+func (s Sig) Verify(msg []byte, pk PK) bool {
+	if len(pk) == 0 {
+		return false
+	}
+
+	scheme, ok := sigScheme(pk[0])
+	if !ok {
+		return false
+	}
+
+	return scheme.Verify(msg, pk, s)
+}
+
+type secp256k1Scheme struct{}
+
+func (secp256k1Scheme) Sign(sk SK, msg []byte) Sig {
 	in := consensus.SHA3(msg)
-	sig, err := secp256k1.Sign(in[:], s)
+	sig, err := secp256k1.Sign(in[:], sk)
 	if err != nil {
 		panic(err)
 	}
@@ -42,7 +134,25 @@ func (s SK) Sign(msg []byte) Sig {
 	return Sig(sig)
 }
 
-func (s Sig) Verify(msg []byte, pk PK) bool {
+func (secp256k1Scheme) Verify(msg []byte, pk PK, sig Sig) bool {
+	if len(sig) < 64 {
+		return false
+	}
+
 	in := consensus.SHA3(msg)
-	return secp256k1.VerifySignature(pk, in[:], s[:64])
+	return secp256k1.VerifySignature(pk, in[:], sig[:64])
+}
+
+type ed25519Scheme struct{}
+
+func (ed25519Scheme) Sign(sk SK, msg []byte) Sig {
+	return Sig(ed25519.Sign(ed25519.PrivateKey(sk[1:]), msg))
+}
+
+func (ed25519Scheme) Verify(msg []byte, pk PK, sig Sig) bool {
+	if len(pk) != ed25519.PublicKeySize+1 {
+		return false
+	}
+
+	return ed25519.Verify(ed25519.PublicKey(pk[1:]), msg, sig)
 }
@@ -12,3 +12,22 @@ func TestVerify(t *testing.T) {
 	sig := sk.Sign(msg)
 	assert.True(t, sig.Verify(msg[:], pk))
 }
+
+func TestVerifyEd25519(t *testing.T) {
+	pk, sk := RandEd25519KeyPair()
+	msg := []byte("hello world")
+	sig := sk.Sign(msg)
+	assert.True(t, sig.Verify(msg, pk))
+	assert.False(t, sig.Verify([]byte("hello"), pk))
+
+	secpPK, _ := RandKeyPair()
+	assert.False(t, sig.Verify(msg, secpPK))
+}
+
+func TestSignEmptyKey(t *testing.T) {
+	pk, _ := RandKeyPair()
+	msg := []byte("hello world")
+	sig := SK(nil).Sign(msg)
+	assert.Nil(t, sig)
+	assert.False(t, sig.Verify(msg, pk))
+}
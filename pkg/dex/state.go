@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethdb"
@@ -14,6 +15,22 @@ import (
 	log "github.com/helinwang/log15"
 )
 
+// MarketConfigInfo is the configuration of a market.
+type MarketConfigInfo struct {
+	// BatchAuction makes the market clear all the orders
+	// received in a round at a single price at the end of the
+	// round, instead of matching them continuously.
+	BatchAuction bool
+	// PriceBand is the max distance of an order's price from the
+	// market's reference price, in parts per million of the
+	// reference price. 0 disables the band.
+	PriceBand uint64
+	// ProRata allocates the fills at a price level in proportion
+	// to the sizes of the resting orders, instead of in time
+	// priority.
+	ProRata bool
+}
+
 // MarketSymbol is the symbol of a trading pair.
 type MarketSymbol struct {
 	Base  TokenID // the unit of the order's quantity
@@ -46,9 +63,35 @@ type State struct {
 	db     *trie.Database
 	diskDB ethdb.Database
 
+	// commitMu serializes CommitCache, so that an overlay is
+	// created only after the changed accounts are written.
+	commitMu     sync.Mutex
 	mu           sync.Mutex
 	trie         *trie.Trie
 	accountCache map[consensus.Addr]*Account
+	// dirtyAccounts is the cached accounts that are changed
+	// since the last CommitCache, only they are written to the
+	// trie, so that the trie hash is updated in proportion to
+	// the changed accounts.
+	dirtyAccounts map[consensus.Addr]*Account
+	// auditLog is nil if the audit log is disabled. auditEntries
+	// is the balance mutations of the transition that produced
+	// the state, written when the state is finalized.
+	auditLog     *AuditLog
+	auditCtx     auditContext
+	auditEntries []AuditEntry
+	// tokens is the token cache of the trie, shared with the
+	// overlays and the transitions. It is nil if it needs to be
+	// rebuilt from the trie.
+	tokens *TokenCache
+	// round, trades, executed and matches is the round, the
+	// trades, the executed order quantities and the matched
+	// trades of the transition that produced the state, they are
+	// not in the trie.
+	round    uint64
+	trades   map[MarketSymbol][]PriceSample
+	executed map[MarketSymbol]map[uint64]uint64
+	matches  []MatchedTrade
 }
 
 var BNBInfo = TokenInfo{
@@ -90,10 +133,11 @@ func CreateGenesisState(recipients []PK, additionalTokens []TokenInfo) *State {
 
 func newState(state *trie.Trie, db *trie.Database, diskDB ethdb.Database) *State {
 	return &State{
-		diskDB:       diskDB,
-		db:           db,
-		trie:         state,
-		accountCache: make(map[consensus.Addr]*Account),
+		diskDB:        diskDB,
+		db:            db,
+		trie:          state,
+		accountCache:  make(map[consensus.Addr]*Account),
+		dirtyAccounts: make(map[consensus.Addr]*Account),
 	}
 }
 
@@ -108,18 +152,363 @@ func NewState(diskDB ethdb.Database) *State {
 }
 
 var (
-	marketPrefix           = []byte{0}
-	tokenPrefix            = []byte{1}
-	orderExpirationPrefix  = []byte{2}
-	freezeAtRoundPrefix    = []byte{3}
-	pkPrefix               = []byte{4}
-	noncePrefix            = []byte{5}
-	balancePrefix          = []byte{6}
-	pendingOrdersPrefix    = []byte{7}
-	executionReportsPrefix = []byte{8}
-	reportIdxPrefix        = []byte{9}
+	marketPrefix             = []byte{0}
+	tokenPrefix              = []byte{1}
+	orderExpirationPrefix    = []byte{2}
+	freezeAtRoundPrefix      = []byte{3}
+	pkPrefix                 = []byte{4}
+	noncePrefix              = []byte{5}
+	balancePrefix            = []byte{6}
+	pendingOrdersPrefix      = []byte{7}
+	executionReportsPrefix   = []byte{8}
+	reportIdxPrefix          = []byte{9}
+	recurringOrderPrefix     = []byte{10}
+	tokenListRoundPrefix     = []byte{11}
+	auctionPrefix            = []byte{12}
+	tokenIssuerPrefix        = []byte{13}
+	marketConfigPrefix       = []byte{14}
+	refPricePrefix           = []byte{17}
+	marginDebtPrefix         = []byte{18}
+	marginAccountsPrefix     = []byte{19}
+	lendingPoolPrefix        = []byte{20}
+	lendingSharesPrefix      = []byte{21}
+	perpBookPrefix           = []byte{22}
+	perpPositionPrefix       = []byte{23}
+	perpAccountsPrefix       = []byte{24}
+	perpRefPricePrefix       = []byte{25}
+	bridgeGroupPrefix        = []byte{26}
+	bridgeTokenPrefix        = []byte{27}
+	bridgeEthTokenPrefix     = []byte{28}
+	bridgeDepositPrefix      = []byte{29}
+	bridgeWithdrawalPrefix   = []byte{30}
+	bridgeWithdrawalIDPrefix = []byte{31}
+	htlcPrefix               = []byte{32}
+	ibcClientPrefix          = []byte{33}
+	ibcRootPrefix            = []byte{34}
+	ibcChannelPrefix         = []byte{35}
+	ibcPacketPrefix          = []byte{36}
+	ibcPacketRootPrefix      = []byte{37}
+	ibcVoucherPrefix         = []byte{38}
+	ibcClientIDPrefix        = []byte{39}
+	ibcChannelIDPrefix       = []byte{40}
+	governorPrefix           = []byte{41}
+	oracleConfigPrefix       = []byte{42}
+	oraclePricePrefix        = []byte{43}
+	stablecoinPrefix         = []byte{44}
+	cdpConfigPrefix          = []byte{45}
+	cdpPrefix                = []byte{46}
+	cdpAccountsPrefix        = []byte{47}
+	feeSchedulePrefix        = []byte{48}
+	referrerPrefix           = []byte{49}
+	tradedVolumePrefix       = []byte{50}
+	roundIntervalPrefix      = []byte{51}
+	verifiedIssuerPrefix     = []byte{52}
+	delistingPrefix          = []byte{53}
+	retirePrefix             = []byte{54}
+	closedMarketPrefix       = []byte{55}
+	tokenRestrictedPrefix    = []byte{56}
+	tokenWhitelistPrefix     = []byte{57}
+	escrowPrefix             = []byte{58}
+	escrowExpirationPrefix   = []byte{59}
+	streamPrefix             = []byte{60}
+	streamAccountsPrefix     = []byte{61}
+	riskLimitPrefix          = []byte{62}
+	guardianPrefix           = []byte{63}
+	depositPrefix            = []byte{64}
+	depositCountPrefix       = []byte{65}
+	mmProgramPrefix          = []byte{66}
+	mmProgramMarketsPrefix   = []byte{67}
+	mmLedgerPrefix           = []byte{68}
+	tradeRecordPrefix        = []byte{69}
+	validatorQueuePrefix     = []byte{70}
+	laneNoncePrefix          = []byte{71}
+	bookDigestsPrefix        = []byte{72}
+	tradingCalendarPrefix    = []byte{73}
+	indexesPrefix            = []byte{74}
+	indexPricePrefix         = []byte{75}
+	allowListPrefix          = []byte{76}
+	transferThresholdPrefix  = []byte{77}
+	pendingTransferPrefix    = []byte{78}
+	blockedPrefix            = []byte{79}
+	blocklistLogPrefix       = []byte{80}
+	transferOutflowPrefix    = []byte{81}
+	recurringCountPrefix     = []byte{82}
 )
 
+func addrReferrerPath(addr consensus.Addr) []byte {
+	return append(referrerPrefix, addr[:]...)
+}
+
+func addrTradedVolumePath(addr consensus.Addr) []byte {
+	return append(tradedVolumePrefix, addr[:]...)
+}
+
+func cdpConfigPath(tokenID TokenID) []byte {
+	path := make([]byte, 64)
+	binary.LittleEndian.PutUint64(path, uint64(tokenID))
+	return append(cdpConfigPrefix, path...)
+}
+
+func addrCDPPath(addr consensus.Addr) []byte {
+	return append(cdpPrefix, addr[:]...)
+}
+
+func oraclePricePath(tokenID TokenID) []byte {
+	path := make([]byte, 64)
+	binary.LittleEndian.PutUint64(path, uint64(tokenID))
+	return append(oraclePricePrefix, path...)
+}
+
+func ibcClientPath(id uint64) []byte {
+	b := make([]byte, 64)
+	binary.LittleEndian.PutUint64(b, id)
+	return append(ibcClientPrefix, b...)
+}
+
+func ibcRootPath(clientID, height uint64) []byte {
+	b := make([]byte, 128)
+	binary.LittleEndian.PutUint64(b, clientID)
+	binary.LittleEndian.PutUint64(b[64:], height)
+	return append(ibcRootPrefix, b...)
+}
+
+func ibcChannelPath(id uint64) []byte {
+	b := make([]byte, 64)
+	binary.LittleEndian.PutUint64(b, id)
+	return append(ibcChannelPrefix, b...)
+}
+
+func ibcPacketPath(channel, seq uint64) []byte {
+	b := make([]byte, 128)
+	binary.LittleEndian.PutUint64(b, channel)
+	binary.LittleEndian.PutUint64(b[64:], seq)
+	return append(ibcPacketPrefix, b...)
+}
+
+func ibcPacketRootPath(round uint64) []byte {
+	b := make([]byte, 64)
+	binary.LittleEndian.PutUint64(b, round)
+	return append(ibcPacketRootPrefix, b...)
+}
+
+func ibcVoucherPath(symbol TokenSymbol) []byte {
+	return append(ibcVoucherPrefix, []byte(symbol)...)
+}
+
+func htlcPath(id consensus.Hash) []byte {
+	return append(htlcPrefix, id[:]...)
+}
+
+func bridgeTokenPath(token EthAddr) []byte {
+	return append(bridgeTokenPrefix, token[:]...)
+}
+
+func bridgeEthTokenPath(tokenID TokenID) []byte {
+	path := make([]byte, 64)
+	binary.LittleEndian.PutUint64(path, uint64(tokenID))
+	return append(bridgeEthTokenPrefix, path...)
+}
+
+func bridgeDepositPath(key consensus.Hash) []byte {
+	return append(bridgeDepositPrefix, key[:]...)
+}
+
+func bridgeWithdrawalPath(id uint64) []byte {
+	b := make([]byte, 64)
+	binary.LittleEndian.PutUint64(b, id)
+	return append(bridgeWithdrawalPrefix, b...)
+}
+
+func perpBookPath(m MarketSymbol) []byte {
+	return append(perpBookPrefix, m.Encode()...)
+}
+
+func addrPerpPositionPath(addr consensus.Addr) []byte {
+	return append(perpPositionPrefix, addr[:]...)
+}
+
+func perpRefPricePath(m MarketSymbol) []byte {
+	return append(perpRefPricePrefix, m.Encode()...)
+}
+
+func addrMarginDebtPath(addr consensus.Addr) []byte {
+	return append(marginDebtPrefix, addr[:]...)
+}
+
+func lendingPoolPath(tokenID TokenID) []byte {
+	path := make([]byte, 64)
+	binary.LittleEndian.PutUint64(path, uint64(tokenID))
+	return append(lendingPoolPrefix, path...)
+}
+
+func addrLendingSharesPath(addr consensus.Addr, tokenID TokenID) []byte {
+	path := make([]byte, 64)
+	binary.LittleEndian.PutUint64(path, uint64(tokenID))
+	p := append(lendingSharesPrefix, addr[:]...)
+	return append(p, path...)
+}
+
+func refPricePath(m MarketSymbol) []byte {
+	return append(refPricePrefix, m.Encode()...)
+}
+
+func tokenIssuerPath(tokenID TokenID) []byte {
+	path := make([]byte, 64)
+	binary.LittleEndian.PutUint64(path, uint64(tokenID))
+	return append(tokenIssuerPrefix, path...)
+}
+
+func verifiedIssuerPath(tokenID TokenID) []byte {
+	path := make([]byte, 64)
+	binary.LittleEndian.PutUint64(path, uint64(tokenID))
+	return append(verifiedIssuerPrefix, path...)
+}
+
+func delistingPath(tokenID TokenID) []byte {
+	path := make([]byte, 64)
+	binary.LittleEndian.PutUint64(path, uint64(tokenID))
+	return append(delistingPrefix, path...)
+}
+
+func retirePath(round uint64) []byte {
+	b := make([]byte, 64)
+	binary.LittleEndian.PutUint64(b, round)
+	return append(retirePrefix, b...)
+}
+
+func closedMarketPath(m MarketSymbol) []byte {
+	return append(closedMarketPrefix, m.Encode()...)
+}
+
+func tokenRestrictedPath(tokenID TokenID) []byte {
+	path := make([]byte, 64)
+	binary.LittleEndian.PutUint64(path, uint64(tokenID))
+	return append(tokenRestrictedPrefix, path...)
+}
+
+func tokenWhitelistPath(tokenID TokenID, addr consensus.Addr) []byte {
+	path := make([]byte, 64)
+	binary.LittleEndian.PutUint64(path, uint64(tokenID))
+	path = append(tokenWhitelistPrefix, path...)
+	return append(path, addr[:]...)
+}
+
+func escrowPath(id consensus.Hash) []byte {
+	return append(escrowPrefix, id[:]...)
+}
+
+func escrowExpirationPath(round uint64) []byte {
+	b := make([]byte, 64)
+	binary.LittleEndian.PutUint64(b, round)
+	return append(escrowExpirationPrefix, b...)
+}
+
+func streamPath(id consensus.Hash) []byte {
+	return append(streamPrefix, id[:]...)
+}
+
+func streamAccountsPath(addr consensus.Addr) []byte {
+	return append(streamAccountsPrefix, addr[:]...)
+}
+
+func riskLimitPath(addr consensus.Addr, m MarketSymbol) []byte {
+	path := append(riskLimitPrefix, addr[:]...)
+	return append(path, m.Encode()...)
+}
+
+func allowListPath(addr consensus.Addr) []byte {
+	return append(allowListPrefix, addr[:]...)
+}
+
+func transferThresholdPath(addr consensus.Addr, id TokenID) []byte {
+	b := make([]byte, 64)
+	binary.LittleEndian.PutUint64(b, uint64(id))
+	path := append(transferThresholdPrefix, addr[:]...)
+	return append(path, b...)
+}
+
+func recurringCountPath(addr consensus.Addr) []byte {
+	return append(recurringCountPrefix, addr[:]...)
+}
+
+func transferOutflowPath(addr consensus.Addr, id TokenID) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(id))
+	path := append(transferOutflowPrefix, addr[:]...)
+	return append(path, b...)
+}
+
+func addrPendingTransfersPath(addr consensus.Addr) []byte {
+	return append(pendingTransferPrefix, addr[:]...)
+}
+
+func pendingTransferPath(addr consensus.Addr, id consensus.Hash) []byte {
+	return append(addrPendingTransfersPath(addr), id[:]...)
+}
+
+func blockedPath(addr consensus.Addr) []byte {
+	return append(blockedPrefix, addr[:]...)
+}
+
+func guardianPath(addr consensus.Addr) []byte {
+	return append(guardianPrefix, addr[:]...)
+}
+
+func depositPath(addr consensus.Addr, seq uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, seq)
+	p := append(depositPrefix, addr[:]...)
+	return append(p, buf...)
+}
+
+func depositCountPath(addr consensus.Addr) []byte {
+	return append(depositCountPrefix, addr[:]...)
+}
+
+func mmProgramPath(m MarketSymbol) []byte {
+	return append(mmProgramPrefix, m.Encode()...)
+}
+
+func tradingCalendarPath(m MarketSymbol) []byte {
+	return append(tradingCalendarPrefix, m.Encode()...)
+}
+
+func indexPricePath(name string) []byte {
+	return append(indexPricePrefix, name...)
+}
+
+func mmLedgerPath(m MarketSymbol) []byte {
+	return append(mmLedgerPrefix, m.Encode()...)
+}
+
+func marketConfigPath(m MarketSymbol) []byte {
+	return append(marketConfigPrefix, m.Encode()...)
+}
+
+func tokenListRoundPath(tokenID TokenID) []byte {
+	path := make([]byte, 64)
+	binary.LittleEndian.PutUint64(path, uint64(tokenID))
+	return append(tokenListRoundPrefix, path...)
+}
+
+func auctionPath(round uint64) []byte {
+	b := make([]byte, 64)
+	binary.LittleEndian.PutUint64(b, round)
+	return append(auctionPrefix, b...)
+}
+
+func tradeRecordPath(round uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, round)
+	return append(tradeRecordPrefix, b...)
+}
+
+func recurringOrderPath(round uint64) []byte {
+	b := make([]byte, 64)
+	binary.LittleEndian.PutUint64(b, round)
+	return append(recurringOrderPrefix, b...)
+}
+
 func addrReportIdxPath(addr consensus.Addr) []byte {
 	return append(reportIdxPrefix, addr[:]...)
 }
@@ -138,6 +527,17 @@ func addrNoncePath(addr consensus.Addr) []byte {
 	return append(noncePrefix, addr[:]...)
 }
 
+// laneNoncePath returns the path of the nonce of the account's lane,
+// lane 0 is the account nonce.
+func laneNoncePath(addr consensus.Addr, lane uint8) []byte {
+	if lane == 0 {
+		return addrNoncePath(addr)
+	}
+
+	p := append(laneNoncePrefix, addr[:]...)
+	return append(p, lane)
+}
+
 func addrBalancePath(addr consensus.Addr) []byte {
 	return append(balancePrefix, addr[:]...)
 }
@@ -193,33 +593,42 @@ func encodePath(str []byte) []byte {
 	return nibbles
 }
 
-func (s *State) cachedAccounts() []*Account {
-	accounts := make([]*Account, len(s.accountCache))
-	i := 0
-	for _, acc := range s.accountCache {
-		accounts[i] = acc
-		i++
+// dirtyCachedAccounts returns and clears the changed cached
+// accounts.
+func (s *State) dirtyCachedAccounts() []*Account {
+	addrs := make([]consensus.Addr, 0, len(s.dirtyAccounts))
+	for addr := range s.dirtyAccounts {
+		addrs = append(addrs, addr)
+	}
+	sortAddrs(addrs)
+
+	accounts := make([]*Account, len(addrs))
+	for i, addr := range addrs {
+		accounts[i] = s.dirtyAccounts[addr]
 	}
+	s.dirtyAccounts = make(map[consensus.Addr]*Account)
 	return accounts
 }
 
-func (s *State) commitCache() {
-	for _, acc := range s.cachedAccounts() {
-		// commit cache calls the methods of s, need to be
-		// outside of s.mu
-		acc.CommitCache(s)
-	}
+func (s *State) markDirty(acc *Account) {
+	s.mu.Lock()
+	s.dirtyAccounts[acc.addr] = acc
+	s.mu.Unlock()
 }
 
+// CommitCache writes the changed cached accounts to the trie.
 func (s *State) CommitCache() {
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+
 	s.mu.Lock()
-	accounts := s.cachedAccounts()
-	s.mu.Unlock()
+	defer s.mu.Unlock()
 
-	for _, acc := range accounts {
-		// commit cache calls the methods of s, need to be
-		// outside of s.mu
-		acc.CommitCache(s)
+	// the accounts are written in a single pass, an account
+	// changed many times since the last CommitCache is written
+	// once.
+	for _, acc := range s.dirtyCachedAccounts() {
+		acc.writeBack(s)
 	}
 }
 
@@ -233,6 +642,7 @@ func (s *State) NewAccount(pk PK) *Account {
 
 	s.mu.Lock()
 	s.accountCache[account.addr] = account
+	s.dirtyAccounts[account.addr] = account
 	s.mu.Unlock()
 	return account
 }
@@ -258,32 +668,46 @@ func (s *State) PK(addr consensus.Addr) PK {
 }
 
 func (s *State) UpdatePK(pk PK) {
-	addr := pk.Addr()
-
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	path := addrPKPath(addr)
+	s.updatePK(pk)
+}
+
+func (s *State) updatePK(pk PK) {
+	path := addrPKPath(pk.Addr())
 	s.trie.Update(path, pk)
 }
 
 func (s *State) UpdateNonce(addr consensus.Addr, nonce uint64) {
+	s.UpdateLaneNonce(addr, 0, nonce)
+}
+
+func (s *State) Nonce(addr consensus.Addr) uint64 {
+	return s.LaneNonce(addr, 0)
+}
+
+// UpdateLaneNonce updates the nonce of the account's lane.
+func (s *State) UpdateLaneNonce(addr consensus.Addr, lane uint8, nonce uint64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.updateLaneNonce(addr, lane, nonce)
+}
 
+func (s *State) updateLaneNonce(addr consensus.Addr, lane uint8, nonce uint64) {
 	b, err := rlp.EncodeToBytes(nonce)
 	if err != nil {
 		panic(err)
 	}
 
-	path := addrNoncePath(addr)
-	s.trie.Update(path, b)
+	s.trie.Update(laneNoncePath(addr, lane), b)
 }
 
-func (s *State) Nonce(addr consensus.Addr) uint64 {
+// LaneNonce returns the nonce of the next txn of the account's lane.
+func (s *State) LaneNonce(addr consensus.Addr, lane uint8) uint64 {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	b := s.trie.Get(addrNoncePath(addr))
+	b := s.trie.Get(laneNoncePath(addr, lane))
 	if len(b) == 0 {
 		return 0
 	}
@@ -305,7 +729,10 @@ type balanceIDs struct {
 func (s *State) UpdateBalances(addr consensus.Addr, balances []Balance, ids []TokenID) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.updateBalances(addr, balances, ids)
+}
 
+func (s *State) updateBalances(addr consensus.Addr, balances []Balance, ids []TokenID) {
 	v := balanceIDs{B: balances, I: ids}
 	b, err := rlp.EncodeToBytes(v)
 	if err != nil {
@@ -456,6 +883,26 @@ func (s *State) ExecutionReports(addr consensus.Addr) []ExecutionReport {
 	return r
 }
 
+// ExecutionReport returns the execution report of the account at the
+// index.
+func (s *State) ExecutionReport(addr consensus.Addr, idx uint32) (ExecutionReport, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(addrExecutionReportPath(addr, idx))
+	if len(b) == 0 {
+		return ExecutionReport{}, false
+	}
+
+	var e ExecutionReport
+	err := rlp.DecodeBytes(b, &e)
+	if err != nil {
+		panic(err)
+	}
+
+	return e, true
+}
+
 func (s *State) UpdateToken(token Token) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -469,6 +916,33 @@ func (s *State) UpdateToken(token Token) {
 	}
 
 	s.trie.Update(path, b)
+	s.tokens = nil
+}
+
+// tokenCache returns a fork of the state's token cache. The tokens
+// are read from the trie only if the cache is invalidated by
+// UpdateToken.
+func (s *State) tokenCache() *TokenCache {
+	s.mu.Lock()
+	c := s.tokens
+	s.mu.Unlock()
+
+	if c == nil {
+		c = newTokenCache(s)
+		s.mu.Lock()
+		s.tokens = c
+		s.mu.Unlock()
+	}
+
+	return c.fork()
+}
+
+// setTokenCache sets the token cache that is consistent with the
+// tokens in the trie, c must not be updated afterwards.
+func (s *State) setTokenCache(c *TokenCache) {
+	s.mu.Lock()
+	s.tokens = c
+	s.mu.Unlock()
 }
 
 func (s *State) Account(addr consensus.Addr) *Account {
@@ -496,10 +970,32 @@ func (s *State) Account(addr consensus.Addr) *Account {
 
 // loadOrderBook deserializes the order from the state trie.
 func (s *State) loadOrderBook(m MarketSymbol) *orderBook {
+	return s.loadBook(marketPath(m.Encode()))
+}
+
+// MarketOrders returns the resting orders of the market with their
+// owners, each side in the price-time priority. The order book indexes
+// the orders by market, the pending orders of the accounts index them
+// by owner.
+func (s *State) MarketOrders(m MarketSymbol) (bids, asks []BookOrder) {
+	book := s.loadOrderBook(m)
+	if book == nil {
+		return nil, nil
+	}
+
+	return book.bookOrders()
+}
+
+// saveOrderBook serializes the order book to the state trie, it
+// returns the digest of the serialized book.
+func (s *State) saveOrderBook(m MarketSymbol, book *orderBook) consensus.Hash {
+	return s.saveBook(marketPath(m.Encode()), book)
+}
+
+func (s *State) loadBook(path []byte) *orderBook {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	path := marketPath(m.Encode())
 	b := s.trie.Get(path)
 	if b == nil {
 		return nil
@@ -514,64 +1010,280 @@ func (s *State) loadOrderBook(m MarketSymbol) *orderBook {
 	return &book
 }
 
-func (s *State) saveOrderBook(m MarketSymbol, book *orderBook) {
+func (s *State) saveBook(path []byte, book *orderBook) consensus.Hash {
 	b, err := rlp.EncodeToBytes(book)
 	if err != nil {
 		panic(err)
 	}
 
 	s.mu.Lock()
-	path := marketPath(m.Encode())
 	s.trie.Update(path, b)
 	s.mu.Unlock()
+	return bookDigest(b)
 }
 
-// Tokens returns all issued tokens
-func (s *State) Tokens() []Token {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// UpdateTradingCalendar sets the trading calendar of the market, an
+// empty calendar removes it.
+func (s *State) UpdateTradingCalendar(m MarketSymbol, c TradingCalendar) {
+	if c.Empty() {
+		s.mu.Lock()
+		s.trie.Delete(tradingCalendarPath(m))
+		s.mu.Unlock()
+		return
+	}
 
-	prefix := encodePath(tokenPrefix)
-	iter := s.trie.NodeIterator(prefix)
+	b, err := rlp.EncodeToBytes(c)
+	if err != nil {
+		panic(err)
+	}
 
-	var r []Token
-	hasNext := true
-	foundPrefix := false
+	s.mu.Lock()
+	s.trie.Update(tradingCalendarPath(m), b)
+	s.mu.Unlock()
+}
 
-	for ; hasNext; hasNext = iter.Next(true) {
-		if err := iter.Error(); err != nil {
-			log.Error("error iterating state trie's tokens", "err", err)
-			break
-		}
+// TradingCalendar returns the trading calendar of the market, it
+// returns false if the market trades all the time.
+func (s *State) TradingCalendar(m MarketSymbol) (TradingCalendar, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		if !iter.Leaf() {
-			continue
-		}
+	var c TradingCalendar
+	b := s.trie.Get(tradingCalendarPath(m))
+	if len(b) == 0 {
+		return c, false
+	}
 
-		path := iter.Path()
-		if !bytes.HasPrefix(path, prefix) {
-			if foundPrefix {
-				break
-			}
+	err := rlp.DecodeBytes(b, &c)
+	if err != nil {
+		panic(err)
+	}
 
-			continue
-		}
-		foundPrefix = true
+	return c, true
+}
 
-		var token Token
-		err := rlp.DecodeBytes(iter.LeafBlob(), &token)
-		if err != nil {
-			panic(err)
-		}
+// Indexes returns the defined indexes, sorted by the name.
+func (s *State) Indexes() []Index {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-		r = append(r, token)
-	}
-	return r
+	return s.indexes()
 }
 
-func (s *State) Serialize() (consensus.TrieBlob, error) {
-	s.CommitCache()
-	return serializeTrie(s.trie, s.db, s.db.DiskDB())
+func (s *State) indexes() []Index {
+	b := s.trie.Get(indexesPrefix)
+	if len(b) == 0 {
+		return nil
+	}
+
+	var r []Index
+	err := rlp.DecodeBytes(b, &r)
+	if err != nil {
+		panic(err)
+	}
+
+	return r
+}
+
+func (s *State) indexByName(name string) (Index, bool) {
+	for _, idx := range s.Indexes() {
+		if idx.Name == name {
+			return idx, true
+		}
+	}
+
+	return Index{}, false
+}
+
+// UpdateIndex defines the index or replaces its definition, an index
+// without components is removed with its value.
+func (s *State) UpdateIndex(idx Index) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var r []Index
+	added := false
+	for _, v := range s.indexes() {
+		if !added && idx.Name <= v.Name {
+			if len(idx.Components) > 0 {
+				r = append(r, idx)
+			}
+			added = true
+			if idx.Name == v.Name {
+				continue
+			}
+		}
+		r = append(r, v)
+	}
+	if !added && len(idx.Components) > 0 {
+		r = append(r, idx)
+	}
+
+	if len(idx.Components) == 0 {
+		s.trie.Delete(indexPricePath(idx.Name))
+	}
+
+	if len(r) == 0 {
+		s.trie.Delete(indexesPrefix)
+		return
+	}
+
+	b, err := rlp.EncodeToBytes(r)
+	if err != nil {
+		panic(err)
+	}
+
+	s.trie.Update(indexesPrefix, b)
+}
+
+func (s *State) UpdateIndexPrice(name string, p IndexPrice) {
+	b, err := rlp.EncodeToBytes(p)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(indexPricePath(name), b)
+	s.mu.Unlock()
+}
+
+// IndexPrice returns the latest value of the index, it returns false
+// if the index is not priced yet.
+func (s *State) IndexPrice(name string) (IndexPrice, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var p IndexPrice
+	b := s.trie.Get(indexPricePath(name))
+	if len(b) == 0 {
+		return p, false
+	}
+
+	err := rlp.DecodeBytes(b, &p)
+	if err != nil {
+		panic(err)
+	}
+
+	return p, true
+}
+
+// BookDigests returns the committed digests of the order books,
+// sorted by the market.
+func (s *State) BookDigests() []BookDigest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.bookDigests()
+}
+
+func (s *State) bookDigests() []BookDigest {
+	b := s.trie.Get(bookDigestsPrefix)
+	if len(b) == 0 {
+		return nil
+	}
+
+	var ds []BookDigest
+	err := rlp.DecodeBytes(b, &ds)
+	if err != nil {
+		panic(err)
+	}
+
+	return ds
+}
+
+// UpdateBookDigests commits the digests of the saved order books,
+// replacing the digests of the same markets.
+func (s *State) UpdateBookDigests(updates []BookDigest) {
+	if len(updates) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	digests := make(map[MarketSymbol]consensus.Hash)
+	for _, d := range s.bookDigests() {
+		digests[d.Market] = d.Digest
+	}
+
+	for _, d := range updates {
+		digests[d.Market] = d.Digest
+	}
+
+	markets := make([]MarketSymbol, 0, len(digests))
+	for m := range digests {
+		markets = append(markets, m)
+	}
+	sortMarkets(markets)
+
+	ds := make([]BookDigest, len(markets))
+	for i, m := range markets {
+		ds[i] = BookDigest{Market: m, Digest: digests[m]}
+	}
+
+	b, err := rlp.EncodeToBytes(ds)
+	if err != nil {
+		panic(err)
+	}
+
+	s.trie.Update(bookDigestsPrefix, b)
+}
+
+// rawOrderBook returns the serialized order book of the market, nil
+// if the market has no order book.
+func (s *State) rawOrderBook(m MarketSymbol) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.trie.Get(marketPath(m.Encode()))
+}
+
+// Tokens returns all issued tokens
+func (s *State) Tokens() []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefix := encodePath(tokenPrefix)
+	iter := s.trie.NodeIterator(prefix)
+
+	var r []Token
+	hasNext := true
+	foundPrefix := false
+
+	for ; hasNext; hasNext = iter.Next(true) {
+		if err := iter.Error(); err != nil {
+			log.Error("error iterating state trie's tokens", "err", err)
+			break
+		}
+
+		if !iter.Leaf() {
+			continue
+		}
+
+		path := iter.Path()
+		if !bytes.HasPrefix(path, prefix) {
+			if foundPrefix {
+				break
+			}
+
+			continue
+		}
+		foundPrefix = true
+
+		var token Token
+		err := rlp.DecodeBytes(iter.LeafBlob(), &token)
+		if err != nil {
+			panic(err)
+		}
+
+		r = append(r, token)
+	}
+	return r
+}
+
+func (s *State) Serialize() (consensus.TrieBlob, error) {
+	s.CommitCache()
+	return serializeTrie(s.trie, s.db, s.db.DiskDB())
 }
 
 func (s *State) Deserialize(b consensus.TrieBlob) error {
@@ -599,27 +1311,53 @@ func (s *State) Hash() consensus.Hash {
 	return consensus.Hash(s.trie.Hash())
 }
 
-// Transition returns the state change transition.
-func (s *State) Transition(round uint64, proposer []byte) consensus.Transition {
+// Overlay returns a copy-on-write overlay of the state. The trie
+// nodes are immutable, the overlay shares them with the state and
+// only copies the paths to the nodes it changes. So the changes to
+// the overlay are not visible to the state, and discarding the
+// overlay is free.
+func (s *State) Overlay() *State {
 	s.CommitCache()
+	tokens := s.tokenCache()
 
 	s.mu.Lock()
 	newTrie := *s.trie
+	auditLog := s.auditLog
 	s.mu.Unlock()
 
-	state := newState(&newTrie, s.db, s.diskDB)
-	return newTransition(state, round, PK(proposer))
+	o := newState(&newTrie, s.db, s.diskDB)
+	o.auditLog = auditLog
+	o.tokens = tokens
+	return o
+}
+
+// querySnapshot returns an overlay of the state to serve the
+// queries. It shares neither the lock nor the caches with s, so the
+// queries do not contend with the transitions on s, and it does not
+// write the audit log.
+func (s *State) querySnapshot() *State {
+	o := s.Overlay()
+	o.auditLog = nil
+	return o
 }
 
-func (s *State) CommitTxns(txns []byte, pool consensus.TxnPool, round uint64) (consensus.State, int, error) {
+// Transition returns the state change transition of the block of the
+// round and the timestamp. The transition operates on an overlay of
+// the state, the transitions of the competing blocks of a round could
+// be evaluated concurrently.
+func (s *State) Transition(round, timestamp uint64, proposer []byte) consensus.Transition {
+	return newTransition(s.Overlay(), round, timestamp, PK(proposer))
+}
+
+func (s *State) CommitTxns(txns []byte, pool consensus.TxnPool, round, timestamp uint64, seed consensus.Rand) (consensus.State, int, error) {
 	// use nil as the proposer argument, since currently is
 	// replaying block txns, rather than proposing block.
-	trans := s.Transition(round, nil).(*Transition)
+	trans := s.Transition(round, timestamp, nil).(*Transition)
 	if len(txns) == 0 {
 		return trans.Commit(), 0, nil
 	}
 
-	count, err := trans.RecordSerialized(txns, pool)
+	count, err := trans.RecordSerialized(txns, pool, seed)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -687,75 +1425,2163 @@ func (s *State) RemoveOrderExpirations(round uint64, ids map[OrderID]bool) {
 	s.trie.Update(path, b)
 }
 
-func (s *State) UpdateReportIdx(addr consensus.Addr, idx uint32) {
-	b, err := rlp.EncodeToBytes(idx)
+// recurringOrder is a recurring order schedule, it's stored under
+// the round that the next child order will be placed.
+type recurringOrder struct {
+	Owner     consensus.Addr
+	Remaining uint64
+	RecurringOrderTxn
+}
+
+// UpdateRecurringCount updates the number of the account's active
+// recurring order schedules.
+func (s *State) UpdateRecurringCount(addr consensus.Addr, n uint64) {
+	if n == 0 {
+		s.mu.Lock()
+		s.trie.Delete(recurringCountPath(addr))
+		s.mu.Unlock()
+		return
+	}
+
+	b, err := rlp.EncodeToBytes(n)
 	if err != nil {
 		panic(err)
 	}
 
 	s.mu.Lock()
-	s.trie.Update(addrReportIdxPath(addr), b)
+	s.trie.Update(recurringCountPath(addr), b)
 	s.mu.Unlock()
 }
 
-func (s *State) ReportIdx(addr consensus.Addr) uint32 {
+// RecurringCount returns the number of the account's active recurring
+// order schedules.
+func (s *State) RecurringCount(addr consensus.Addr) uint64 {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	b := s.trie.Get(addrReportIdxPath(addr))
+	b := s.trie.Get(recurringCountPath(addr))
 	if len(b) == 0 {
 		return 0
 	}
 
-	var r uint32
+	var n uint64
+	err := rlp.DecodeBytes(b, &n)
+	if err != nil {
+		panic(err)
+	}
+
+	return n
+}
+
+func (s *State) RecurringOrders(round uint64) []recurringOrder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.recurringOrders(round)
+}
+
+func (s *State) recurringOrders(round uint64) []recurringOrder {
+	var all []recurringOrder
+	b := s.trie.Get(recurringOrderPath(round))
+	if len(b) > 0 {
+		err := rlp.DecodeBytes(b, &all)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return all
+}
+
+func (s *State) AddRecurringOrders(round uint64, orders []recurringOrder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := s.recurringOrders(round)
+	all = append(all, orders...)
+	b, err := rlp.EncodeToBytes(all)
+	if err != nil {
+		panic(err)
+	}
+
+	s.trie.Update(recurringOrderPath(round), b)
+}
+
+func (s *State) RemoveRecurringOrders(round uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trie.Delete(recurringOrderPath(round))
+}
+
+// UpdateTokenListRound records the round that the token is issued
+// at.
+func (s *State) UpdateTokenListRound(id TokenID, round uint64) {
+	b, err := rlp.EncodeToBytes(round)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(tokenListRoundPath(id), b)
+	s.mu.Unlock()
+}
+
+// TokenListRound returns the round that the token is issued at, it
+// returns false for the tokens created in the genesis state.
+func (s *State) TokenListRound(id TokenID) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(tokenListRoundPath(id))
+	if len(b) == 0 {
+		return 0, false
+	}
+
+	var r uint64
 	err := rlp.DecodeBytes(b, &r)
 	if err != nil {
 		panic(err)
 	}
 
-	return r
+	return r, true
 }
 
-type freezeToken struct {
-	Addr    consensus.Addr
-	TokenID TokenID
-	Quant   uint64
+func (s *State) UpdateTokenIssuer(id TokenID, addr consensus.Addr) {
+	s.mu.Lock()
+	s.trie.Update(tokenIssuerPath(id), addr[:])
+	s.mu.Unlock()
 }
 
-func (s *State) GetFreezeTokens(round uint64) []freezeToken {
+// TokenIssuer returns the issuer of the token, it returns false for
+// the tokens created in the genesis state.
+func (s *State) TokenIssuer(id TokenID) (consensus.Addr, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	return s.getFreezeTokens(round)
+	var addr consensus.Addr
+	b := s.trie.Get(tokenIssuerPath(id))
+	if len(b) == 0 {
+		return addr, false
+	}
+
+	copy(addr[:], b)
+	return addr, true
 }
 
-func (s *State) getFreezeTokens(round uint64) []freezeToken {
-	path := freezeAtRoundToPath(round)
-	b := s.trie.Get(path)
+func (s *State) UpdateVerifiedIssuer(id TokenID, v VerifiedIssuer) {
+	b, err := rlp.EncodeToBytes(v)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(verifiedIssuerPath(id), b)
+	s.mu.Unlock()
+}
+
+func (s *State) RemoveVerifiedIssuer(id TokenID) {
+	s.mu.Lock()
+	s.trie.Delete(verifiedIssuerPath(id))
+	s.mu.Unlock()
+}
+
+// VerifiedIssuer returns the governor's attestation of the token's
+// issuer, it returns false if the issuer is not verified.
+func (s *State) VerifiedIssuer(id TokenID) (VerifiedIssuer, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var v VerifiedIssuer
+	b := s.trie.Get(verifiedIssuerPath(id))
 	if len(b) == 0 {
-		return nil
+		return v, false
 	}
 
-	var all []freezeToken
-	err := rlp.DecodeBytes(b, &all)
+	err := rlp.DecodeBytes(b, &v)
 	if err != nil {
 		panic(err)
 	}
 
-	return all
+	return v, true
 }
 
-func (s *State) FreezeToken(round uint64, f freezeToken) {
+// Delist records the delisting of the token, and schedules the
+// retirement of its markets.
+func (s *State) Delist(id TokenID, d Delisting) {
+	b, err := rlp.EncodeToBytes(d)
+	if err != nil {
+		panic(err)
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	all := s.getFreezeTokens(round)
-	all = append(all, f)
-	b, err := rlp.EncodeToBytes(all)
+	s.trie.Update(delistingPath(id), b)
+	tokens := append(s.retiringTokens(d.RetireRound), id)
+	b, err = rlp.EncodeToBytes(tokens)
 	if err != nil {
 		panic(err)
 	}
 
-	path := freezeAtRoundToPath(round)
-	s.trie.Update(path, b)
+	s.trie.Update(retirePath(d.RetireRound), b)
+}
+
+// Delisting returns the delisting of the token, it returns false if
+// the token is not delisted.
+func (s *State) Delisting(id TokenID) (Delisting, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var d Delisting
+	b := s.trie.Get(delistingPath(id))
+	if len(b) == 0 {
+		return d, false
+	}
+
+	err := rlp.DecodeBytes(b, &d)
+	if err != nil {
+		panic(err)
+	}
+
+	return d, true
+}
+
+// RetiringTokens returns the delisted tokens whose markets retire at
+// the given round.
+func (s *State) RetiringTokens(round uint64) []TokenID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.retiringTokens(round)
+}
+
+func (s *State) retiringTokens(round uint64) []TokenID {
+	var tokens []TokenID
+	b := s.trie.Get(retirePath(round))
+	if len(b) > 0 {
+		err := rlp.DecodeBytes(b, &tokens)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return tokens
+}
+
+func (s *State) RemoveRetiringTokens(round uint64) {
+	s.mu.Lock()
+	s.trie.Delete(retirePath(round))
+	s.mu.Unlock()
+}
+
+// CloseMarket marks the market closed at the given round.
+func (s *State) CloseMarket(m MarketSymbol, round uint64) {
+	b, err := rlp.EncodeToBytes(round)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(closedMarketPath(m), b)
+	s.mu.Unlock()
+}
+
+// MarketClosed returns the round when the market is closed, it
+// returns false if the market is not closed.
+func (s *State) MarketClosed(m MarketSymbol) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(closedMarketPath(m))
+	if len(b) == 0 {
+		return 0, false
+	}
+
+	var round uint64
+	err := rlp.DecodeBytes(b, &round)
+	if err != nil {
+		panic(err)
+	}
+
+	return round, true
+}
+
+// UpdateTokenRestricted sets whether the token can only be held by
+// the whitelisted addresses.
+func (s *State) UpdateTokenRestricted(id TokenID, restricted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !restricted {
+		s.trie.Delete(tokenRestrictedPath(id))
+		return
+	}
+
+	s.trie.Update(tokenRestrictedPath(id), []byte{1})
+}
+
+// TokenRestricted returns true if the token can only be held by the
+// whitelisted addresses.
+func (s *State) TokenRestricted(id TokenID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.trie.Get(tokenRestrictedPath(id))) > 0
+}
+
+func (s *State) UpdateTokenWhitelist(id TokenID, addr consensus.Addr, approved bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !approved {
+		s.trie.Delete(tokenWhitelistPath(id, addr))
+		return
+	}
+
+	s.trie.Update(tokenWhitelistPath(id, addr), []byte{1})
+}
+
+// TokenWhitelisted returns true if the address is approved by the
+// issuer to hold the token.
+func (s *State) TokenWhitelisted(id TokenID, addr consensus.Addr) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.trie.Get(tokenWhitelistPath(id, addr))) > 0
+}
+
+func (s *State) UpdateMarketConfig(m MarketSymbol, c MarketConfigInfo) {
+	b, err := rlp.EncodeToBytes(c)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(marketConfigPath(m), b)
+	s.mu.Unlock()
+}
+
+func (s *State) MarketConfig(m MarketSymbol) MarketConfigInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var c MarketConfigInfo
+	b := s.trie.Get(marketConfigPath(m))
+	if len(b) == 0 {
+		return c
+	}
+
+	err := rlp.DecodeBytes(b, &c)
+	if err != nil {
+		panic(err)
+	}
+
+	return c
+}
+
+func (s *State) UpdateRefPrice(m MarketSymbol, p RefPrice) {
+	b, err := rlp.EncodeToBytes(p)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(refPricePath(m), b)
+	s.mu.Unlock()
+}
+
+// RefPrice returns the reference price of the market, false is
+// returned if the market has never traded.
+func (s *State) RefPrice(m MarketSymbol) (RefPrice, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var p RefPrice
+	b := s.trie.Get(refPricePath(m))
+	if len(b) == 0 {
+		return p, false
+	}
+
+	err := rlp.DecodeBytes(b, &p)
+	if err != nil {
+		panic(err)
+	}
+
+	return p, true
+}
+
+// addrList returns the list of addresses stored at the path.
+func (s *State) addrList(path []byte) []consensus.Addr {
+	var all []consensus.Addr
+	b := s.trie.Get(path)
+	if len(b) > 0 {
+		err := rlp.DecodeBytes(b, &all)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return all
+}
+
+func (s *State) updateAddrList(path []byte, all []consensus.Addr) {
+	if len(all) == 0 {
+		s.trie.Delete(path)
+		return
+	}
+
+	b, err := rlp.EncodeToBytes(all)
+	if err != nil {
+		panic(err)
+	}
+
+	s.trie.Update(path, b)
+}
+
+func (s *State) addToAddrList(path []byte, addr consensus.Addr) {
+	all := s.addrList(path)
+	for _, v := range all {
+		if v == addr {
+			return
+		}
+	}
+
+	s.updateAddrList(path, append(all, addr))
+}
+
+func (s *State) removeFromAddrList(path []byte, addr consensus.Addr) {
+	all := s.addrList(path)
+	for i, v := range all {
+		if v == addr {
+			s.updateAddrList(path, append(all[:i], all[i+1:]...))
+			return
+		}
+	}
+}
+
+func (s *State) UpdateMarginDebt(addr consensus.Addr, d MarginDebt) {
+	b, err := rlp.EncodeToBytes(d)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(addrMarginDebtPath(addr), b)
+	s.mu.Unlock()
+}
+
+// MarginDebt returns the debt of the margin account, false is
+// returned if the margin account does not exist.
+func (s *State) MarginDebt(addr consensus.Addr) (MarginDebt, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var d MarginDebt
+	b := s.trie.Get(addrMarginDebtPath(addr))
+	if len(b) == 0 {
+		return d, false
+	}
+
+	err := rlp.DecodeBytes(b, &d)
+	if err != nil {
+		panic(err)
+	}
+
+	return d, true
+}
+
+// MarginAccounts returns the margin accounts that have debt.
+func (s *State) MarginAccounts() []consensus.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addrList(marginAccountsPrefix)
+}
+
+func (s *State) AddMarginAccount(addr consensus.Addr) {
+	s.mu.Lock()
+	s.addToAddrList(marginAccountsPrefix, addr)
+	s.mu.Unlock()
+}
+
+func (s *State) RemoveMarginAccount(addr consensus.Addr) {
+	s.mu.Lock()
+	s.removeFromAddrList(marginAccountsPrefix, addr)
+	s.mu.Unlock()
+}
+
+func (s *State) UpdatePerpPosition(addr consensus.Addr, p PerpPosition) {
+	b, err := rlp.EncodeToBytes(p)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(addrPerpPositionPath(addr), b)
+	s.mu.Unlock()
+}
+
+// PerpPosition returns the position of the perpetual account, false
+// is returned if the perpetual account does not exist.
+func (s *State) PerpPosition(addr consensus.Addr) (PerpPosition, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var p PerpPosition
+	b := s.trie.Get(addrPerpPositionPath(addr))
+	if len(b) == 0 {
+		return p, false
+	}
+
+	err := rlp.DecodeBytes(b, &p)
+	if err != nil {
+		panic(err)
+	}
+
+	return p, true
+}
+
+// PerpAccounts returns the perpetual accounts that have open
+// positions.
+func (s *State) PerpAccounts() []consensus.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addrList(perpAccountsPrefix)
+}
+
+func (s *State) AddPerpAccount(addr consensus.Addr) {
+	s.mu.Lock()
+	s.addToAddrList(perpAccountsPrefix, addr)
+	s.mu.Unlock()
+}
+
+func (s *State) RemovePerpAccount(addr consensus.Addr) {
+	s.mu.Lock()
+	s.removeFromAddrList(perpAccountsPrefix, addr)
+	s.mu.Unlock()
+}
+
+func (s *State) UpdatePerpRefPrice(m MarketSymbol, p RefPrice) {
+	b, err := rlp.EncodeToBytes(p)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(perpRefPricePath(m), b)
+	s.mu.Unlock()
+}
+
+// PerpRefPrice returns the reference price of the perpetual market,
+// false is returned if the perpetual market has never traded.
+func (s *State) PerpRefPrice(m MarketSymbol) (RefPrice, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var p RefPrice
+	b := s.trie.Get(perpRefPricePath(m))
+	if len(b) == 0 {
+		return p, false
+	}
+
+	err := rlp.DecodeBytes(b, &p)
+	if err != nil {
+		panic(err)
+	}
+
+	return p, true
+}
+
+// UpdateBridgeGroup sets the public key of the group that signs the
+// bridge transactions, it's set in the genesis state.
+func (s *State) UpdateBridgeGroup(pk consensus.PK) {
+	s.mu.Lock()
+	s.trie.Update(bridgeGroupPrefix, pk)
+	s.mu.Unlock()
+}
+
+func (s *State) BridgeGroup() consensus.PK {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return consensus.PK(s.trie.Get(bridgeGroupPrefix))
+}
+
+func (s *State) UpdateBridgeToken(token EthAddr, id TokenID) {
+	b, err := rlp.EncodeToBytes(id)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(bridgeTokenPath(token), b)
+	s.trie.Update(bridgeEthTokenPath(id), token[:])
+	s.mu.Unlock()
+}
+
+// BridgeToken returns the wrapped token of the ERC-20 token.
+func (s *State) BridgeToken(token EthAddr) (TokenID, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(bridgeTokenPath(token))
+	if len(b) == 0 {
+		return 0, false
+	}
+
+	var id TokenID
+	err := rlp.DecodeBytes(b, &id)
+	if err != nil {
+		panic(err)
+	}
+
+	return id, true
+}
+
+// BridgeEthToken returns the ERC-20 token of the wrapped token.
+func (s *State) BridgeEthToken(id TokenID) (EthAddr, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var token EthAddr
+	b := s.trie.Get(bridgeEthTokenPath(id))
+	if len(b) == 0 {
+		return token, false
+	}
+
+	copy(token[:], b)
+	return token, true
+}
+
+// MarkBridgeDeposit marks the deposit as minted.
+func (s *State) MarkBridgeDeposit(key consensus.Hash) {
+	s.mu.Lock()
+	s.trie.Update(bridgeDepositPath(key), []byte{1})
+	s.mu.Unlock()
+}
+
+func (s *State) BridgeDepositMinted(key consensus.Hash) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.trie.Get(bridgeDepositPath(key))) > 0
+}
+
+func (s *State) UpdateBridgeWithdrawal(w BridgeWithdrawal) {
+	b, err := rlp.EncodeToBytes(w)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(bridgeWithdrawalPath(w.ID), b)
+	s.mu.Unlock()
+}
+
+func (s *State) BridgeWithdrawal(id uint64) (BridgeWithdrawal, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var w BridgeWithdrawal
+	b := s.trie.Get(bridgeWithdrawalPath(id))
+	if len(b) == 0 {
+		return w, false
+	}
+
+	err := rlp.DecodeBytes(b, &w)
+	if err != nil {
+		panic(err)
+	}
+
+	return w, true
+}
+
+// NextBridgeWithdrawalID returns the ID for the next withdrawal.
+func (s *State) NextBridgeWithdrawalID() uint64 {
+	return s.nextID(bridgeWithdrawalIDPrefix)
+}
+
+// NextIBCClientID returns the ID for the next light client.
+func (s *State) NextIBCClientID() uint64 {
+	return s.nextID(ibcClientIDPrefix)
+}
+
+// NextIBCChannelID returns the ID for the next channel.
+func (s *State) NextIBCChannelID() uint64 {
+	return s.nextID(ibcChannelIDPrefix)
+}
+
+// nextID returns the counter stored at the path and increments it.
+func (s *State) nextID(path []byte) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var id uint64
+	b := s.trie.Get(path)
+	if len(b) > 0 {
+		err := rlp.DecodeBytes(b, &id)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	b, err := rlp.EncodeToBytes(id + 1)
+	if err != nil {
+		panic(err)
+	}
+
+	s.trie.Update(path, b)
+	return id
+}
+
+func (s *State) UpdateHTLC(id consensus.Hash, h HTLC) {
+	b, err := rlp.EncodeToBytes(h)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(htlcPath(id), b)
+	s.mu.Unlock()
+}
+
+func (s *State) HTLC(id consensus.Hash) (HTLC, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var h HTLC
+	b := s.trie.Get(htlcPath(id))
+	if len(b) == 0 {
+		return h, false
+	}
+
+	err := rlp.DecodeBytes(b, &h)
+	if err != nil {
+		panic(err)
+	}
+
+	return h, true
+}
+
+func (s *State) RemoveHTLC(id consensus.Hash) {
+	s.mu.Lock()
+	s.trie.Delete(htlcPath(id))
+	s.mu.Unlock()
+}
+
+func (s *State) UpdateEscrow(id consensus.Hash, e Escrow) {
+	b, err := rlp.EncodeToBytes(e)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(escrowPath(id), b)
+	s.mu.Unlock()
+}
+
+func (s *State) Escrow(id consensus.Hash) (Escrow, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var e Escrow
+	b := s.trie.Get(escrowPath(id))
+	if len(b) == 0 {
+		return e, false
+	}
+
+	err := rlp.DecodeBytes(b, &e)
+	if err != nil {
+		panic(err)
+	}
+
+	return e, true
+}
+
+func (s *State) RemoveEscrow(id consensus.Hash) {
+	s.mu.Lock()
+	s.trie.Delete(escrowPath(id))
+	s.mu.Unlock()
+}
+
+// EscrowExpirations returns the escrows whose tokens are refunded at
+// the given round.
+func (s *State) EscrowExpirations(round uint64) []consensus.Hash {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.escrowExpirations(round)
+}
+
+func (s *State) escrowExpirations(round uint64) []consensus.Hash {
+	var ids []consensus.Hash
+	b := s.trie.Get(escrowExpirationPath(round))
+	if len(b) > 0 {
+		err := rlp.DecodeBytes(b, &ids)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return ids
+}
+
+func (s *State) updateEscrowExpirations(round uint64, ids []consensus.Hash) {
+	if len(ids) == 0 {
+		s.trie.Delete(escrowExpirationPath(round))
+		return
+	}
+
+	b, err := rlp.EncodeToBytes(ids)
+	if err != nil {
+		panic(err)
+	}
+
+	s.trie.Update(escrowExpirationPath(round), b)
+}
+
+func (s *State) AddEscrowExpiration(round uint64, id consensus.Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.updateEscrowExpirations(round, append(s.escrowExpirations(round), id))
+}
+
+func (s *State) RemoveEscrowExpiration(round uint64, id consensus.Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := s.escrowExpirations(round)
+	for i, v := range ids {
+		if v == id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	s.updateEscrowExpirations(round, ids)
+}
+
+func (s *State) RemoveEscrowExpirations(round uint64) {
+	s.mu.Lock()
+	s.trie.Delete(escrowExpirationPath(round))
+	s.mu.Unlock()
+}
+
+func (s *State) UpdateStream(id consensus.Hash, p PaymentStream) {
+	b, err := rlp.EncodeToBytes(p)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(streamPath(id), b)
+	s.mu.Unlock()
+}
+
+func (s *State) Stream(id consensus.Hash) (PaymentStream, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var p PaymentStream
+	b := s.trie.Get(streamPath(id))
+	if len(b) == 0 {
+		return p, false
+	}
+
+	err := rlp.DecodeBytes(b, &p)
+	if err != nil {
+		panic(err)
+	}
+
+	return p, true
+}
+
+func (s *State) RemoveStream(id consensus.Hash) {
+	s.mu.Lock()
+	s.trie.Delete(streamPath(id))
+	s.mu.Unlock()
+}
+
+// AccountStreams returns the payment streams that the account sends
+// or receives.
+func (s *State) AccountStreams(addr consensus.Addr) []consensus.Hash {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []consensus.Hash
+	b := s.trie.Get(streamAccountsPath(addr))
+	if len(b) > 0 {
+		err := rlp.DecodeBytes(b, &ids)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return ids
+}
+
+func (s *State) UpdateAccountStreams(addr consensus.Addr, ids []consensus.Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(ids) == 0 {
+		s.trie.Delete(streamAccountsPath(addr))
+		return
+	}
+
+	b, err := rlp.EncodeToBytes(ids)
+	if err != nil {
+		panic(err)
+	}
+
+	s.trie.Update(streamAccountsPath(addr), b)
+}
+
+func (s *State) UpdateRiskLimits(addr consensus.Addr, m MarketSymbol, l RiskLimits) {
+	b, err := rlp.EncodeToBytes(l)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(riskLimitPath(addr, m), b)
+	s.mu.Unlock()
+}
+
+// RiskLimits returns the account's risk limits of the market.
+func (s *State) RiskLimits(addr consensus.Addr, m MarketSymbol) RiskLimits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var l RiskLimits
+	b := s.trie.Get(riskLimitPath(addr, m))
+	if len(b) == 0 {
+		return l
+	}
+
+	err := rlp.DecodeBytes(b, &l)
+	if err != nil {
+		panic(err)
+	}
+
+	return l
+}
+
+func (s *State) UpdateAllowLists(addr consensus.Addr, l AllowLists) {
+	b, err := rlp.EncodeToBytes(l)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(allowListPath(addr), b)
+	s.mu.Unlock()
+}
+
+// AllowLists returns the account's withdrawal allow-lists.
+func (s *State) AllowLists(addr consensus.Addr) AllowLists {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var l AllowLists
+	b := s.trie.Get(allowListPath(addr))
+	if len(b) == 0 {
+		return l
+	}
+
+	err := rlp.DecodeBytes(b, &l)
+	if err != nil {
+		panic(err)
+	}
+
+	return l
+}
+
+func (s *State) UpdateTransferThresholds(addr consensus.Addr, id TokenID, l TransferThresholds) {
+	b, err := rlp.EncodeToBytes(l)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(transferThresholdPath(addr, id), b)
+	s.mu.Unlock()
+}
+
+// TransferThresholds returns the account's large transfer thresholds
+// of the token.
+func (s *State) TransferThresholds(addr consensus.Addr, id TokenID) TransferThresholds {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var l TransferThresholds
+	b := s.trie.Get(transferThresholdPath(addr, id))
+	if len(b) == 0 {
+		return l
+	}
+
+	err := rlp.DecodeBytes(b, &l)
+	if err != nil {
+		panic(err)
+	}
+
+	return l
+}
+
+func (s *State) UpdateTransferOutflows(addr consensus.Addr, id TokenID, os []Outflow) {
+	if len(os) == 0 {
+		s.mu.Lock()
+		s.trie.Delete(transferOutflowPath(addr, id))
+		s.mu.Unlock()
+		return
+	}
+
+	b, err := rlp.EncodeToBytes(os)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(transferOutflowPath(addr, id), b)
+	s.mu.Unlock()
+}
+
+// TransferOutflows returns the account's recorded outflows of the
+// token, in the ascending order of the rounds.
+func (s *State) TransferOutflows(addr consensus.Addr, id TokenID) []Outflow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(transferOutflowPath(addr, id))
+	if len(b) == 0 {
+		return nil
+	}
+
+	var os []Outflow
+	err := rlp.DecodeBytes(b, &os)
+	if err != nil {
+		panic(err)
+	}
+
+	return os
+}
+
+func (s *State) UpdatePendingTransfer(p PendingTransfer) {
+	b, err := rlp.EncodeToBytes(p)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(pendingTransferPath(p.Owner, p.ID), b)
+	s.mu.Unlock()
+}
+
+func (s *State) PendingTransfer(addr consensus.Addr, id consensus.Hash) (PendingTransfer, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var p PendingTransfer
+	b := s.trie.Get(pendingTransferPath(addr, id))
+	if len(b) == 0 {
+		return p, false
+	}
+
+	err := rlp.DecodeBytes(b, &p)
+	if err != nil {
+		panic(err)
+	}
+
+	return p, true
+}
+
+func (s *State) RemovePendingTransfer(addr consensus.Addr, id consensus.Hash) {
+	s.mu.Lock()
+	s.trie.Delete(pendingTransferPath(addr, id))
+	s.mu.Unlock()
+}
+
+// PendingTransfers returns the account's announced transfers.
+func (s *State) PendingTransfers(addr consensus.Addr) []PendingTransfer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefix := encodePath(addrPendingTransfersPath(addr))
+	iter := s.trie.NodeIterator(prefix)
+
+	var r []PendingTransfer
+	hasNext := true
+	foundPrefix := false
+
+	for ; hasNext; hasNext = iter.Next(true) {
+		if err := iter.Error(); err != nil {
+			log.Error("error iterating state trie's pending transfers", "err", err)
+			break
+		}
+
+		if !iter.Leaf() {
+			continue
+		}
+
+		path := iter.Path()
+		if !bytes.HasPrefix(path, prefix) {
+			if foundPrefix {
+				break
+			}
+
+			continue
+		}
+		foundPrefix = true
+
+		var p PendingTransfer
+		err := rlp.DecodeBytes(iter.LeafBlob(), &p)
+		if err != nil {
+			panic(err)
+		}
+
+		r = append(r, p)
+	}
+	return r
+}
+
+func (s *State) UpdateBlocked(addr consensus.Addr, b BlockedAddr) {
+	v, err := rlp.EncodeToBytes(b)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(blockedPath(addr), v)
+	s.mu.Unlock()
+}
+
+// Blocked returns the blocklist entry of the address, it returns
+// false if the address is not blocked.
+func (s *State) Blocked(addr consensus.Addr) (BlockedAddr, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b BlockedAddr
+	v := s.trie.Get(blockedPath(addr))
+	if len(v) == 0 {
+		return b, false
+	}
+
+	err := rlp.DecodeBytes(v, &b)
+	if err != nil {
+		panic(err)
+	}
+
+	return b, true
+}
+
+func (s *State) RemoveBlocked(addr consensus.Addr) {
+	s.mu.Lock()
+	s.trie.Delete(blockedPath(addr))
+	s.mu.Unlock()
+}
+
+func (s *State) UpdateBlocklistLog(l BlocklistLog) {
+	b, err := rlp.EncodeToBytes(l)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(blocklistLogPrefix, b)
+	s.mu.Unlock()
+}
+
+// BlocklistLog returns the blocklist changes of the latest round that
+// changed the blocklist.
+func (s *State) BlocklistLog() BlocklistLog {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var l BlocklistLog
+	b := s.trie.Get(blocklistLogPrefix)
+	if len(b) == 0 {
+		return l
+	}
+
+	err := rlp.DecodeBytes(b, &l)
+	if err != nil {
+		panic(err)
+	}
+
+	return l
+}
+
+func (s *State) UpdateGuardian(addr consensus.Addr, g Guardian) {
+	b, err := rlp.EncodeToBytes(g)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(guardianPath(addr), b)
+	s.mu.Unlock()
+}
+
+// AddDeposit records the deposit to the account.
+func (s *State) AddDeposit(addr consensus.Addr, d Deposit) {
+	seq := s.DepositCount(addr)
+	b, err := rlp.EncodeToBytes(d)
+	if err != nil {
+		panic(err)
+	}
+
+	c, err := rlp.EncodeToBytes(seq + 1)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(depositPath(addr, seq), b)
+	s.trie.Update(depositCountPath(addr), c)
+	s.mu.Unlock()
+}
+
+// DepositCount returns the number of the deposits of the account.
+func (s *State) DepositCount(addr consensus.Addr) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(depositCountPath(addr))
+	if len(b) == 0 {
+		return 0
+	}
+
+	var n uint32
+	err := rlp.DecodeBytes(b, &n)
+	if err != nil {
+		panic(err)
+	}
+
+	return n
+}
+
+// Deposit returns the deposit of the account with the sequence
+// number.
+func (s *State) Deposit(addr consensus.Addr, seq uint32) (Deposit, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var d Deposit
+	b := s.trie.Get(depositPath(addr, seq))
+	if len(b) == 0 {
+		return d, false
+	}
+
+	err := rlp.DecodeBytes(b, &d)
+	if err != nil {
+		panic(err)
+	}
+
+	return d, true
+}
+
+// UpdateMMProgram sets the market maker program of the market.
+func (s *State) UpdateMMProgram(m MarketSymbol, p MMProgram) {
+	b, err := rlp.EncodeToBytes(p)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trie.Update(mmProgramPath(m), b)
+	markets := s.mmProgramMarkets()
+	for _, v := range markets {
+		if v == m {
+			return
+		}
+	}
+	s.updateMMProgramMarkets(append(markets, m))
+}
+
+// RemoveMMProgram ends the market maker program of the market, the
+// ledger is kept.
+func (s *State) RemoveMMProgram(m MarketSymbol) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trie.Delete(mmProgramPath(m))
+	markets := s.mmProgramMarkets()
+	for i, v := range markets {
+		if v == m {
+			s.updateMMProgramMarkets(append(markets[:i], markets[i+1:]...))
+			return
+		}
+	}
+}
+
+func (s *State) MMProgram(m MarketSymbol) (MMProgram, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var p MMProgram
+	b := s.trie.Get(mmProgramPath(m))
+	if len(b) == 0 {
+		return p, false
+	}
+
+	err := rlp.DecodeBytes(b, &p)
+	if err != nil {
+		panic(err)
+	}
+
+	return p, true
+}
+
+// MMProgramMarkets returns the markets with a market maker program,
+// in the order the programs are added.
+func (s *State) MMProgramMarkets() []MarketSymbol {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mmProgramMarkets()
+}
+
+func (s *State) mmProgramMarkets() []MarketSymbol {
+	b := s.trie.Get(mmProgramMarketsPrefix)
+	if len(b) == 0 {
+		return nil
+	}
+
+	var markets []MarketSymbol
+	err := rlp.DecodeBytes(b, &markets)
+	if err != nil {
+		panic(err)
+	}
+
+	return markets
+}
+
+func (s *State) updateMMProgramMarkets(markets []MarketSymbol) {
+	if len(markets) == 0 {
+		s.trie.Delete(mmProgramMarketsPrefix)
+		return
+	}
+
+	b, err := rlp.EncodeToBytes(markets)
+	if err != nil {
+		panic(err)
+	}
+
+	s.trie.Update(mmProgramMarketsPrefix, b)
+}
+
+func (s *State) UpdateMMLedger(m MarketSymbol, l MMLedger) {
+	b, err := rlp.EncodeToBytes(l)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(mmLedgerPath(m), b)
+	s.mu.Unlock()
+}
+
+// MMLedger returns the market maker ledger of the market.
+func (s *State) MMLedger(m MarketSymbol) MMLedger {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var l MMLedger
+	b := s.trie.Get(mmLedgerPath(m))
+	if len(b) == 0 {
+		return l
+	}
+
+	err := rlp.DecodeBytes(b, &l)
+	if err != nil {
+		panic(err)
+	}
+
+	return l
+}
+
+func (s *State) Guardian(addr consensus.Addr) Guardian {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var g Guardian
+	b := s.trie.Get(guardianPath(addr))
+	if len(b) == 0 {
+		return g
+	}
+
+	err := rlp.DecodeBytes(b, &g)
+	if err != nil {
+		panic(err)
+	}
+
+	return g
+}
+
+func (s *State) UpdateIBCClient(c IBCClient) {
+	b, err := rlp.EncodeToBytes(c)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(ibcClientPath(c.ID), b)
+	s.mu.Unlock()
+}
+
+func (s *State) IBCClient(id uint64) (IBCClient, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var v IBCClient
+	b := s.trie.Get(ibcClientPath(id))
+	if len(b) == 0 {
+		return v, false
+	}
+
+	err := rlp.DecodeBytes(b, &v)
+	if err != nil {
+		panic(err)
+	}
+
+	return v, true
+}
+
+func (s *State) UpdateIBCRoot(clientID, height uint64, root consensus.Hash) {
+	b, err := rlp.EncodeToBytes(root)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(ibcRootPath(clientID, height), b)
+	s.mu.Unlock()
+}
+
+func (s *State) IBCRoot(clientID, height uint64) (consensus.Hash, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var v consensus.Hash
+	b := s.trie.Get(ibcRootPath(clientID, height))
+	if len(b) == 0 {
+		return v, false
+	}
+
+	err := rlp.DecodeBytes(b, &v)
+	if err != nil {
+		panic(err)
+	}
+
+	return v, true
+}
+
+func (s *State) UpdateIBCChannel(ch IBCChannel) {
+	b, err := rlp.EncodeToBytes(ch)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(ibcChannelPath(ch.ID), b)
+	s.mu.Unlock()
+}
+
+func (s *State) IBCChannel(id uint64) (IBCChannel, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var v IBCChannel
+	b := s.trie.Get(ibcChannelPath(id))
+	if len(b) == 0 {
+		return v, false
+	}
+
+	err := rlp.DecodeBytes(b, &v)
+	if err != nil {
+		panic(err)
+	}
+
+	return v, true
+}
+
+func (s *State) UpdateIBCPacket(p IBCPacket) {
+	b, err := rlp.EncodeToBytes(p)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(ibcPacketPath(p.SrcChannel, p.Sequence), b)
+	s.mu.Unlock()
+}
+
+func (s *State) IBCPacket(channel, seq uint64) (IBCPacket, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var v IBCPacket
+	b := s.trie.Get(ibcPacketPath(channel, seq))
+	if len(b) == 0 {
+		return v, false
+	}
+
+	err := rlp.DecodeBytes(b, &v)
+	if err != nil {
+		panic(err)
+	}
+
+	return v, true
+}
+
+func (s *State) UpdateIBCPacketRoot(round uint64, r IBCPacketRoot) {
+	b, err := rlp.EncodeToBytes(r)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(ibcPacketRootPath(round), b)
+	s.mu.Unlock()
+}
+
+func (s *State) IBCPacketRoot(round uint64) (IBCPacketRoot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var v IBCPacketRoot
+	b := s.trie.Get(ibcPacketRootPath(round))
+	if len(b) == 0 {
+		return v, false
+	}
+
+	err := rlp.DecodeBytes(b, &v)
+	if err != nil {
+		panic(err)
+	}
+
+	return v, true
+}
+
+func (s *State) UpdateIBCVoucher(symbol TokenSymbol, id TokenID) {
+	b, err := rlp.EncodeToBytes(id)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(ibcVoucherPath(symbol), b)
+	s.mu.Unlock()
+}
+
+func (s *State) IBCVoucher(symbol TokenSymbol) (TokenID, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var v TokenID
+	b := s.trie.Get(ibcVoucherPath(symbol))
+	if len(b) == 0 {
+		return v, false
+	}
+
+	err := rlp.DecodeBytes(b, &v)
+	if err != nil {
+		panic(err)
+	}
+
+	return v, true
+}
+
+func (s *State) UpdateGovernor(addr consensus.Addr) {
+	s.mu.Lock()
+	s.trie.Update(governorPrefix, addr[:])
+	s.mu.Unlock()
+}
+
+// Governor returns the governor's address, false if governance is
+// not enabled.
+func (s *State) Governor() (consensus.Addr, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var addr consensus.Addr
+	b := s.trie.Get(governorPrefix)
+	if len(b) == 0 {
+		return addr, false
+	}
+
+	copy(addr[:], b)
+	return addr, true
+}
+
+func (s *State) UpdateOracleConfig(c OracleConfig) {
+	b, err := rlp.EncodeToBytes(c)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(oracleConfigPrefix, b)
+	s.mu.Unlock()
+}
+
+func (s *State) OracleConfig() OracleConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var c OracleConfig
+	b := s.trie.Get(oracleConfigPrefix)
+	if len(b) == 0 {
+		return c
+	}
+
+	err := rlp.DecodeBytes(b, &c)
+	if err != nil {
+		panic(err)
+	}
+
+	return c
+}
+
+func (s *State) UpdateOraclePrice(id TokenID, p OraclePrice) {
+	b, err := rlp.EncodeToBytes(p)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(oraclePricePath(id), b)
+	s.mu.Unlock()
+}
+
+func (s *State) OraclePrice(id TokenID) (OraclePrice, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var p OraclePrice
+	b := s.trie.Get(oraclePricePath(id))
+	if len(b) == 0 {
+		return p, false
+	}
+
+	err := rlp.DecodeBytes(b, &p)
+	if err != nil {
+		panic(err)
+	}
+
+	return p, true
+}
+
+func (s *State) UpdateStablecoin(id TokenID) {
+	b, err := rlp.EncodeToBytes(id)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(stablecoinPrefix, b)
+	s.mu.Unlock()
+}
+
+func (s *State) Stablecoin() (TokenID, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var id TokenID
+	b := s.trie.Get(stablecoinPrefix)
+	if len(b) == 0 {
+		return id, false
+	}
+
+	err := rlp.DecodeBytes(b, &id)
+	if err != nil {
+		panic(err)
+	}
+
+	return id, true
+}
+
+func (s *State) UpdateCDPConfig(collateral TokenID, c CDPConfig) {
+	b, err := rlp.EncodeToBytes(c)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(cdpConfigPath(collateral), b)
+	s.mu.Unlock()
+}
+
+// CDPConfig returns the configuration of the collateral token, false
+// is returned if the token is not accepted as collateral.
+func (s *State) CDPConfig(collateral TokenID) (CDPConfig, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var c CDPConfig
+	b := s.trie.Get(cdpConfigPath(collateral))
+	if len(b) == 0 {
+		return c, false
+	}
+
+	err := rlp.DecodeBytes(b, &c)
+	if err != nil {
+		panic(err)
+	}
+
+	return c, true
+}
+
+func (s *State) UpdateCDP(addr consensus.Addr, c CDP) {
+	b, err := rlp.EncodeToBytes(c)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(addrCDPPath(addr), b)
+	s.mu.Unlock()
+}
+
+// CDP returns the CDP of the CDP account, false is returned if the
+// CDP does not exist.
+func (s *State) CDP(addr consensus.Addr) (CDP, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var c CDP
+	b := s.trie.Get(addrCDPPath(addr))
+	if len(b) == 0 {
+		return c, false
+	}
+
+	err := rlp.DecodeBytes(b, &c)
+	if err != nil {
+		panic(err)
+	}
+
+	return c, true
+}
+
+// CDPAccounts returns the CDP accounts that have debt.
+func (s *State) CDPAccounts() []consensus.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addrList(cdpAccountsPrefix)
+}
+
+func (s *State) AddCDPAccount(addr consensus.Addr) {
+	s.mu.Lock()
+	s.addToAddrList(cdpAccountsPrefix, addr)
+	s.mu.Unlock()
+}
+
+func (s *State) RemoveCDPAccount(addr consensus.Addr) {
+	s.mu.Lock()
+	s.removeFromAddrList(cdpAccountsPrefix, addr)
+	s.mu.Unlock()
+}
+
+func (s *State) UpdateFeeSchedule(f FeeSchedule) {
+	b, err := rlp.EncodeToBytes(f)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(feeSchedulePrefix, b)
+	s.mu.Unlock()
+}
+
+func (s *State) FeeSchedule() FeeSchedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var f FeeSchedule
+	b := s.trie.Get(feeSchedulePrefix)
+	if len(b) == 0 {
+		return f
+	}
+
+	err := rlp.DecodeBytes(b, &f)
+	if err != nil {
+		panic(err)
+	}
+
+	return f
+}
+
+func (s *State) UpdateReferrer(addr, referrer consensus.Addr) {
+	s.mu.Lock()
+	s.trie.Update(addrReferrerPath(addr), referrer[:])
+	s.mu.Unlock()
+}
+
+// Referrer returns the account's referrer, false is returned if the
+// account has no referrer.
+func (s *State) Referrer(addr consensus.Addr) (consensus.Addr, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var referrer consensus.Addr
+	b := s.trie.Get(addrReferrerPath(addr))
+	if len(b) == 0 {
+		return referrer, false
+	}
+
+	copy(referrer[:], b)
+	return referrer, true
+}
+
+func (s *State) UpdateTradedVolume(addr consensus.Addr, v RollingVolume) {
+	b, err := rlp.EncodeToBytes(v)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(addrTradedVolumePath(addr), b)
+	s.mu.Unlock()
+}
+
+func (s *State) TradedVolume(addr consensus.Addr) RollingVolume {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var v RollingVolume
+	b := s.trie.Get(addrTradedVolumePath(addr))
+	if len(b) == 0 {
+		return v
+	}
+
+	err := rlp.DecodeBytes(b, &v)
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+func (s *State) UpdateRoundIntervalBounds(r RoundIntervalBounds) {
+	b, err := rlp.EncodeToBytes(r)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(roundIntervalPrefix, b)
+	s.mu.Unlock()
+}
+
+// RoundIntervalBounds returns the governance set bounds of the
+// adaptive round interval, ok is false if they are not set.
+func (s *State) RoundIntervalBounds() (min, max time.Duration, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(roundIntervalPrefix)
+	if len(b) == 0 {
+		return 0, 0, false
+	}
+
+	var r RoundIntervalBounds
+	err := rlp.DecodeBytes(b, &r)
+	if err != nil {
+		panic(err)
+	}
+
+	return time.Duration(r.Min) * time.Millisecond, time.Duration(r.Max) * time.Millisecond, true
+}
+
+func (s *State) UpdateLendingPool(id TokenID, p LendingPool) {
+	b, err := rlp.EncodeToBytes(p)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(lendingPoolPath(id), b)
+	s.mu.Unlock()
+}
+
+func (s *State) LendingPool(id TokenID) LendingPool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var p LendingPool
+	b := s.trie.Get(lendingPoolPath(id))
+	if len(b) == 0 {
+		return p
+	}
+
+	err := rlp.DecodeBytes(b, &p)
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
+func (s *State) UpdateLendingShares(addr consensus.Addr, id TokenID, shares uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if shares == 0 {
+		s.trie.Delete(addrLendingSharesPath(addr, id))
+		return
+	}
+
+	b, err := rlp.EncodeToBytes(shares)
+	if err != nil {
+		panic(err)
+	}
+
+	s.trie.Update(addrLendingSharesPath(addr, id), b)
+}
+
+// LendingShares returns the account's shares of the token's lending
+// pool.
+func (s *State) LendingShares(addr consensus.Addr, id TokenID) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(addrLendingSharesPath(addr, id))
+	if len(b) == 0 {
+		return 0
+	}
+
+	var shares uint64
+	err := rlp.DecodeBytes(b, &shares)
+	if err != nil {
+		panic(err)
+	}
+
+	return shares
+}
+
+// AuctionMarkets returns the markets whose auction ends at the
+// given round.
+func (s *State) AuctionMarkets(round uint64) []MarketSymbol {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.auctionMarkets(round)
+}
+
+func (s *State) auctionMarkets(round uint64) []MarketSymbol {
+	var all []MarketSymbol
+	b := s.trie.Get(auctionPath(round))
+	if len(b) > 0 {
+		err := rlp.DecodeBytes(b, &all)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return all
+}
+
+func (s *State) AddAuctionMarket(round uint64, m MarketSymbol) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := s.auctionMarkets(round)
+	for _, v := range all {
+		if v == m {
+			return
+		}
+	}
+
+	all = append(all, m)
+	b, err := rlp.EncodeToBytes(all)
+	if err != nil {
+		panic(err)
+	}
+
+	s.trie.Update(auctionPath(round), b)
+}
+
+func (s *State) RemoveAuctionMarkets(round uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trie.Delete(auctionPath(round))
+}
+
+func (s *State) UpdateTradeRecords(round uint64, records []TradeRecord) {
+	b, err := rlp.EncodeToBytes(records)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	s.trie.Update(tradeRecordPath(round), b)
+	s.mu.Unlock()
+}
+
+// TradeRecords returns the matched trades of the round, the trades
+// out of the bust window are removed.
+func (s *State) TradeRecords(round uint64) []TradeRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(tradeRecordPath(round))
+	if len(b) == 0 {
+		return nil
+	}
+
+	var records []TradeRecord
+	err := rlp.DecodeBytes(b, &records)
+	if err != nil {
+		panic(err)
+	}
+
+	return records
+}
+
+func (s *State) RemoveTradeRecords(round uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trie.Delete(tradeRecordPath(round))
+}
+
+func (s *State) UpdateReportIdx(addr consensus.Addr, idx uint32) {
+	s.mu.Lock()
+	s.updateReportIdx(addr, idx)
+	s.mu.Unlock()
+}
+
+func (s *State) updateReportIdx(addr consensus.Addr, idx uint32) {
+	b, err := rlp.EncodeToBytes(idx)
+	if err != nil {
+		panic(err)
+	}
+
+	s.trie.Update(addrReportIdxPath(addr), b)
+}
+
+func (s *State) ReportIdx(addr consensus.Addr) uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(addrReportIdxPath(addr))
+	if len(b) == 0 {
+		return 0
+	}
+
+	var r uint32
+	err := rlp.DecodeBytes(b, &r)
+	if err != nil {
+		panic(err)
+	}
+
+	return r
+}
+
+type freezeToken struct {
+	Addr    consensus.Addr
+	TokenID TokenID
+	Quant   uint64
+}
+
+func (s *State) GetFreezeTokens(round uint64) []freezeToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.getFreezeTokens(round)
+}
+
+func (s *State) getFreezeTokens(round uint64) []freezeToken {
+	path := freezeAtRoundToPath(round)
+	b := s.trie.Get(path)
+	if len(b) == 0 {
+		return nil
+	}
+
+	var all []freezeToken
+	err := rlp.DecodeBytes(b, &all)
+	if err != nil {
+		panic(err)
+	}
+
+	return all
+}
+
+func (s *State) FreezeToken(round uint64, f freezeToken) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := s.getFreezeTokens(round)
+	all = append(all, f)
+	b, err := rlp.EncodeToBytes(all)
+	if err != nil {
+		panic(err)
+	}
+
+	path := freezeAtRoundToPath(round)
+	s.trie.Update(path, b)
+}
+
+// UnfreezeToken removes the frozen tokens from the tokens to be
+// released at the given round, they are released early.
+func (s *State) UnfreezeToken(round uint64, f freezeToken) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := s.getFreezeTokens(round)
+	for i, v := range all {
+		if v == f {
+			all = append(all[:i], all[i+1:]...)
+			break
+		}
+	}
+
+	path := freezeAtRoundToPath(round)
+	if len(all) == 0 {
+		s.trie.Delete(path)
+		return
+	}
+
+	b, err := rlp.EncodeToBytes(all)
+	if err != nil {
+		panic(err)
+	}
+
+	s.trie.Update(path, b)
+}
+
+// ValidatorQueue returns the validators queued for the DKG.
+func (s *State) ValidatorQueue() []ValidatorRegistration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.trie.Get(validatorQueuePrefix)
+	if len(b) == 0 {
+		return nil
+	}
+
+	var queue []ValidatorRegistration
+	err := rlp.DecodeBytes(b, &queue)
+	if err != nil {
+		panic(err)
+	}
+
+	return queue
+}
+
+func (s *State) UpdateValidatorQueue(queue []ValidatorRegistration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(queue) == 0 {
+		s.trie.Delete(validatorQueuePrefix)
+		return
+	}
+
+	b, err := rlp.EncodeToBytes(queue)
+	if err != nil {
+		panic(err)
+	}
+
+	s.trie.Update(validatorQueuePrefix, b)
 }
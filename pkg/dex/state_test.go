@@ -1,6 +1,8 @@
 package dex
 
 import (
+	"math"
+	"sync"
 	"testing"
 	"unsafe"
 
@@ -146,3 +148,144 @@ func TestStateUpdateBalance(t *testing.T) {
 	acc := s.Account(addr)
 	assert.Equal(t, 100, int(acc.Balance(0).Available))
 }
+
+func TestStateQuerySnapshot(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	pk, _ := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 1})
+	snap := s.querySnapshot()
+
+	// the later changes to the live state are not visible in the
+	// snapshot, and reading the snapshot does not take the lock of
+	// the live state.
+	s.UpdateNonce(pk.Addr(), 1)
+	s.mu.Lock()
+	assert.Equal(t, 1, int(snap.Account(pk.Addr()).Balance(0).Available))
+	assert.Equal(t, 0, int(snap.Nonce(pk.Addr())))
+	s.mu.Unlock()
+}
+
+func TestStateTokenCache(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	assert.Nil(t, s.tokens)
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.NotNil(t, s.tokens)
+	info := TokenInfo{Symbol: "BNB", Decimals: 8, TotalUnits: 1}
+	trans.tokenCache.Update(0, info)
+	trans.state.UpdateToken(Token{ID: 0, TokenInfo: info})
+	// the update is copy-on-write, the state's cache is intact.
+	assert.Equal(t, BNBInfo, s.tokens.Info(0))
+
+	_, err := trans.createToken(TokenInfo{Symbol: "XRP", Decimals: 8, TotalUnits: 100})
+	assert.Nil(t, err)
+	s1 := trans.Commit().(*State)
+	// the committed cache is shared with the next transition.
+	assert.Equal(t, 2, s1.tokens.Size())
+	trans1 := s1.Transition(2, 0, nil).(*Transition)
+	assert.Equal(t, TokenSymbol("XRP"), trans1.tokenCache.Info(1).Symbol)
+	assert.Equal(t, 1, int(trans1.tokenCache.Info(0).TotalUnits))
+}
+
+func TestStateCommitDirtyAccounts(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	pk, _ := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 1})
+	s.CommitCache()
+	h := s.Hash()
+	assert.Equal(t, 0, len(s.dirtyAccounts))
+
+	// reading the account does not write it to the trie.
+	assert.Equal(t, 1, int(s.Account(pk.Addr()).Balance(0).Available))
+	assert.Equal(t, 0, len(s.dirtyAccounts))
+
+	s.Account(pk.Addr()).UpdateBalance(0, Balance{Available: 2})
+	assert.Equal(t, 1, len(s.dirtyAccounts))
+	s.CommitCache()
+	assert.NotEqual(t, h, s.Hash())
+}
+
+func TestStateConcurrentTransitions(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 100})
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	s.CommitCache()
+	h := s.Hash()
+
+	recipients := make([]PK, 8)
+	results := make([]*State, len(recipients))
+	var wg sync.WaitGroup
+	for i := range recipients {
+		recipients[i], _ = RandKeyPair()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			trans := s.Transition(1, 0, nil).(*Transition)
+			assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, pk.Addr(), recipients[i], 0, uint64(i+1), 0), pker))
+			results[i] = trans.Commit().(*State)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, h, s.Hash())
+	assert.Equal(t, 100, int(s.Account(pk.Addr()).Balance(0).Available))
+	for i, r := range results {
+		assert.Equal(t, 100-(i+1), int(r.Account(pk.Addr()).Balance(0).Available))
+		assert.Equal(t, i+1, int(r.Account(recipients[i].Addr()).Balance(0).Available))
+		for j := range recipients {
+			if j != i {
+				assert.Nil(t, r.Account(recipients[j].Addr()))
+			}
+		}
+	}
+}
+
+// TestStateForks checks that switching to a competing block of the
+// round rolls back the order books, the order expirations and the
+// frozen token schedules of the abandoned block, since the state of
+// each block is an immutable overlay of its parent's.
+func TestStateForks(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	pkTo, _ := RandKeyPair()
+	addr := pk.Addr()
+	s.NewAccount(pk).UpdateBalance(1, Balance{Available: 1000})
+	s.CommitCache()
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+	m := MarketSymbol{Base: 1, Quote: 0}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	order := PlaceOrderTxn{SellSide: true, Quant: 100, Price: uint64(math.Pow10(OrderPriceDecimals)), Market: m, ExpireRound: 5}
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, order, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeFreezeTokenTxn(sk, addr, FreezeTokenTxn{TokenID: 1, AvailableRound: 10, Quant: 50}, 1), pker))
+	abandoned := trans.Commit().(*State)
+	_, asks := abandoned.MarketOrders(m)
+	assert.Equal(t, 1, len(asks))
+
+	trans = s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkTo, 1, 10, 0), pker))
+	fork := trans.Commit().(*State)
+
+	acc := fork.Account(addr)
+	assert.Empty(t, acc.PendingOrders())
+	assert.Equal(t, 990, int(acc.Balance(1).Available))
+	assert.Equal(t, 0, int(acc.Balance(1).Pending))
+	assert.Empty(t, acc.Balance(1).Frozen)
+	_, asks = fork.MarketOrders(m)
+	assert.Empty(t, asks)
+	assert.Empty(t, fork.GetOrderExpirations(5))
+	assert.Empty(t, fork.GetFreezeTokens(10))
+
+	// the abandoned state is intact, and the fork is the same as
+	// its block replayed on the parent.
+	assert.NotEmpty(t, abandoned.GetOrderExpirations(5))
+	assert.NotEmpty(t, abandoned.GetFreezeTokens(10))
+	trans = s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(sk, addr, pkTo, 1, 10, 0), pker))
+	assert.Equal(t, fork.Hash(), trans.StateHash())
+}
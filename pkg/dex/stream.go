@@ -0,0 +1,210 @@
+package dex
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// maxAccountStreams is the max number of the payment streams that
+// an account sends or receives, they are settled on each of its
+// txns.
+const maxAccountStreams = 64
+
+// PaymentStream streams Rate units of the token per round from the
+// sender to the receiver until StopRound. The streamed tokens are
+// paid from the sender's deposit, they are settled lazily, when the
+// sender or the receiver sends a txn. SettledRound is the round
+// until which the stream is paid.
+type PaymentStream struct {
+	Sender       consensus.Addr
+	Receiver     PK
+	TokenID      TokenID
+	Rate         uint64
+	StopRound    uint64
+	SettledRound uint64
+	Deposit      uint64
+}
+
+// StreamID returns the ID of the payment stream opened by the
+// sender's txn with the nonce.
+func StreamID(sender consensus.Addr, nonce uint64) consensus.Hash {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, nonce)
+	return consensus.SHA3(sender[:], b)
+}
+
+func (t *Transition) streamOpen(owner *Account, txn *StreamOpenTxn) error {
+	if txn.Rate == 0 {
+		return errors.New("stream rate is 0")
+	}
+
+	if txn.StopRound <= t.round {
+		return fmt.Errorf("stop round %d should be after the current round %d", txn.StopRound, t.round)
+	}
+
+	sender := owner.PK().Addr()
+	receiver := txn.Receiver.Addr()
+	if sender == receiver {
+		return errors.New("can not stream to the sender itself")
+	}
+
+	for _, addr := range []consensus.Addr{sender, receiver} {
+		if err := t.checkHolder(txn.TokenID, addr); err != nil {
+			return err
+		}
+
+		if len(t.state.AccountStreams(addr)) >= maxAccountStreams {
+			return fmt.Errorf("account %v has reached the max number of payment streams: %d", addr, maxAccountStreams)
+		}
+	}
+
+	rounds := txn.StopRound - t.round
+	deposit := txn.Rate * rounds
+	if deposit/rounds != txn.Rate {
+		return errors.New("stream deposit overflows")
+	}
+
+	b := owner.Balance(txn.TokenID)
+	if b.Available < deposit {
+		return txnErrorf(ErrCodeInsufficientBalance, "insufficient available token balance for the stream deposit, tokenID: %v, deposit: %d, available: %d", txn.TokenID, deposit, b.Available)
+	}
+
+	if err := t.checkTransferThreshold(sender, txn.TokenID, deposit); err != nil {
+		return err
+	}
+
+	b.Available -= deposit
+	owner.UpdateBalance(txn.TokenID, b)
+	t.addOutflow(sender, txn.TokenID, deposit)
+	if t.state.Account(receiver) == nil {
+		t.state.NewAccount(txn.Receiver)
+	}
+
+	id := StreamID(sender, owner.Nonce())
+	t.state.UpdateStream(id, PaymentStream{
+		Sender:       sender,
+		Receiver:     txn.Receiver,
+		TokenID:      txn.TokenID,
+		Rate:         txn.Rate,
+		StopRound:    txn.StopRound,
+		SettledRound: t.round,
+		Deposit:      deposit,
+	})
+	for _, addr := range []consensus.Addr{sender, receiver} {
+		t.state.UpdateAccountStreams(addr, append(t.state.AccountStreams(addr), id))
+	}
+	return nil
+}
+
+func (t *Transition) streamCancel(owner *Account, txn *StreamCancelTxn) error {
+	p, ok := t.state.Stream(txn.ID)
+	if !ok {
+		return fmt.Errorf("can not find payment stream %v", txn.ID)
+	}
+
+	addr := owner.PK().Addr()
+	if addr != p.Sender && addr != p.Receiver.Addr() {
+		return errors.New("only the sender or the receiver can cancel the payment stream")
+	}
+
+	p = t.settleStream(p)
+	credit(t.state.Account(p.Sender), p.TokenID, p.Deposit)
+	t.removeStream(txn.ID, p)
+	return nil
+}
+
+// settleStreams pays the streamed tokens of the payment streams that
+// the account sends or receives. It returns the function that reverts
+// the settlement, it must be called if the txn that triggered the
+// settlement fails: the failed txn is dropped from the block, so its
+// settlement is not replayed by the validators.
+func (t *Transition) settleStreams(acc *Account) (revert func()) {
+	type settled struct {
+		id   consensus.Hash
+		prev PaymentStream
+		cur  PaymentStream
+	}
+
+	var changes []settled
+	accStreams := make(map[consensus.Addr][]consensus.Hash)
+	for _, id := range t.state.AccountStreams(acc.PK().Addr()) {
+		p, ok := t.state.Stream(id)
+		if !ok {
+			panic(fmt.Errorf("can not find payment stream %v", id))
+		}
+
+		prev := p
+		p = t.settleStream(p)
+		if p.SettledRound == prev.SettledRound {
+			continue
+		}
+
+		changes = append(changes, settled{id: id, prev: prev, cur: p})
+		if p.SettledRound >= p.StopRound {
+			for _, addr := range []consensus.Addr{p.Sender, p.Receiver.Addr()} {
+				if _, ok := accStreams[addr]; !ok {
+					accStreams[addr] = t.state.AccountStreams(addr)
+				}
+			}
+			t.removeStream(id, p)
+		} else {
+			t.state.UpdateStream(id, p)
+		}
+	}
+
+	return func() {
+		for i := len(changes) - 1; i >= 0; i-- {
+			c := changes[i]
+			receiver := t.state.Account(c.cur.Receiver.Addr())
+			b := receiver.Balance(c.cur.TokenID)
+			b.Available -= c.prev.Deposit - c.cur.Deposit
+			receiver.UpdateBalance(c.cur.TokenID, b)
+			t.state.UpdateStream(c.id, c.prev)
+		}
+
+		addrs := make([]consensus.Addr, 0, len(accStreams))
+		for addr := range accStreams {
+			addrs = append(addrs, addr)
+		}
+		sortAddrs(addrs)
+		for _, addr := range addrs {
+			t.state.UpdateAccountStreams(addr, accStreams[addr])
+		}
+	}
+}
+
+// settleStream pays the tokens streamed until the current round to
+// the receiver.
+func (t *Transition) settleStream(p PaymentStream) PaymentStream {
+	end := t.round
+	if end > p.StopRound {
+		end = p.StopRound
+	}
+
+	if end <= p.SettledRound {
+		return p
+	}
+
+	quant := p.Rate * (end - p.SettledRound)
+	credit(t.state.Account(p.Receiver.Addr()), p.TokenID, quant)
+	p.Deposit -= quant
+	p.SettledRound = end
+	return p
+}
+
+func (t *Transition) removeStream(id consensus.Hash, p PaymentStream) {
+	t.state.RemoveStream(id)
+	for _, addr := range []consensus.Addr{p.Sender, p.Receiver.Addr()} {
+		ids := t.state.AccountStreams(addr)
+		for i, v := range ids {
+			if v == id {
+				ids = append(ids[:i], ids[i+1:]...)
+				break
+			}
+		}
+		t.state.UpdateAccountStreams(addr, ids)
+	}
+}
@@ -0,0 +1,103 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	pkStreamSender, skStreamSender     = RandKeyPair()
+	pkStreamReceiver, skStreamReceiver = RandKeyPair()
+)
+
+func TestPaymentStream(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pkSender, skSender := RandKeyPair()
+	pkReceiver, skReceiver := RandKeyPair()
+	s.NewAccount(pkSender).UpdateBalance(1, Balance{Available: 1000})
+	sender, receiver := pkSender.Addr(), pkReceiver.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{sender: pkSender, receiver: pkReceiver}}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.NotNil(t, recordTxn(t, trans, MakeStreamOpenTxn(skSender, sender, StreamOpenTxn{Receiver: pkReceiver, TokenID: 1, Rate: 200, StopRound: 7}, 0), pker), "insufficient deposit")
+	assert.Nil(t, recordTxn(t, trans, MakeStreamOpenTxn(skSender, sender, StreamOpenTxn{Receiver: pkReceiver, TokenID: 1, Rate: 100, StopRound: 5}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeStreamOpenTxn(skSender, sender, StreamOpenTxn{Receiver: pkReceiver, TokenID: 1, Rate: 10, StopRound: 21}, 1), pker))
+	s = trans.Commit().(*State)
+	salary, subscription := StreamID(sender, 0), StreamID(sender, 1)
+	assert.Equal(t, 400, int(s.Account(sender).Balance(1).Available))
+	assert.Equal(t, []consensus.Hash{salary, subscription}, s.AccountStreams(receiver))
+
+	// settled lazily when the receiver sends a txn.
+	trans = s.Transition(3, 0, nil).(*Transition)
+	assert.Equal(t, 0, int(s.Account(receiver).Balance(1).Available))
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(skReceiver, receiver, pkSender, 1, 210, 0), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, 10, int(s.Account(receiver).Balance(1).Available))
+	p, ok := s.Stream(salary)
+	assert.True(t, ok)
+	assert.Equal(t, 3, int(p.SettledRound))
+	assert.Equal(t, 200, int(p.Deposit))
+
+	// the finished stream is removed on settlement.
+	trans = s.Transition(6, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(skReceiver, receiver, pkSender, 1, 10, 1), pker))
+	s = trans.Commit().(*State)
+	_, ok = s.Stream(salary)
+	assert.False(t, ok)
+	assert.Equal(t, []consensus.Hash{subscription}, s.AccountStreams(sender))
+	assert.Equal(t, 200+30, int(s.Account(receiver).Balance(1).Available))
+
+	trans = s.Transition(8, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeStreamCancelTxn(skSender, sender, StreamCancelTxn{ID: subscription}, 2), pker))
+	s = trans.Commit().(*State)
+	_, ok = s.Stream(subscription)
+	assert.False(t, ok)
+	assert.Empty(t, s.AccountStreams(sender))
+	assert.Empty(t, s.AccountStreams(receiver))
+	assert.Equal(t, 200+30+20, int(s.Account(receiver).Balance(1).Available))
+	// the sender received 210 and 10 from the receiver, and paid 400
+	// and 70 to the streams.
+	assert.Equal(t, 1000+210+10-400-70, int(s.Account(sender).Balance(1).Available))
+}
+
+func TestFailedTxnRevertsStreamSettlement(t *testing.T) {
+	pkOther, skOther := RandKeyPair()
+	setup := func() (*State, *myPKer) {
+		s := NewState(ethdb.NewMemDatabase())
+		s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+		s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+		s.NewAccount(pkStreamSender).UpdateBalance(1, Balance{Available: 1000})
+		s.NewAccount(pkStreamReceiver).UpdateBalance(1, Balance{Available: 1})
+		s.NewAccount(pkOther).UpdateBalance(1, Balance{Available: 1})
+		pker := &myPKer{m: map[consensus.Addr]PK{pkStreamSender.Addr(): pkStreamSender, pkStreamReceiver.Addr(): pkStreamReceiver, pkOther.Addr(): pkOther}}
+		trans := s.Transition(1, 0, nil).(*Transition)
+		assert.Nil(t, recordTxn(t, trans, MakeStreamOpenTxn(skStreamSender, pkStreamSender.Addr(), StreamOpenTxn{Receiver: pkStreamReceiver, TokenID: 1, Rate: 100, StopRound: 4}, 0), pker))
+		return trans.Commit().(*State), pker
+	}
+
+	receiver := pkStreamReceiver.Addr()
+	// a txn of an account not in the stream, it does not settle
+	// the stream.
+	valid := MakeSendTokenTxn(skOther, pkOther.Addr(), pkStreamSender, 1, 1, 0)
+	// the receiver can not send more than its balance, including
+	// the streamed tokens, both the settled and the finished
+	// stream settlements must be reverted.
+	for _, round := range []uint64{3, 5} {
+		s, pker := setup()
+		proposer := s.Transition(round, 0, nil).(*Transition)
+		err := recordTxn(t, proposer, MakeSendTokenTxn(skStreamReceiver, receiver, pkStreamSender, 1, 1000, 0), pker)
+		assert.NotNil(t, err)
+		assert.Nil(t, recordTxn(t, proposer, valid, pker))
+
+		// the validators replay the block without the failed txn.
+		s, pker = setup()
+		validator := s.Transition(round, 0, nil).(*Transition)
+		assert.Nil(t, recordTxn(t, validator, valid, pker))
+		assert.Equal(t, validator.StateHash(), proposer.StateHash(), "round %d", round)
+	}
+}
@@ -0,0 +1,321 @@
+package dex
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+const (
+	// DefaultSurveillanceWindow is the default number of the
+	// recent rounds that the trades are analyzed over.
+	DefaultSurveillanceWindow = 1000
+	// maxFlaggedTrades is the number of the latest flagged trades
+	// kept for the report.
+	maxFlaggedTrades = 100
+)
+
+// the flags of the suspicious trades.
+const (
+	// SelfTradeFlag is set when the buyer and the seller are the
+	// same account.
+	SelfTradeFlag = "self_trade"
+	// LinkedTradeFlag is set when the buyer and the seller are
+	// linked: one is the referrer or the guardian of the other.
+	LinkedTradeFlag = "linked_trade"
+	// CircularTradeFlag is set when the base token traded returns
+	// to the seller within the window, directly or through one
+	// other account.
+	CircularTradeFlag = "circular_trade"
+)
+
+// MatchedTrade is a trade between a taker and a maker.
+type MatchedTrade struct {
+	Round  uint64
+	Market MarketSymbol
+	Buyer  consensus.Addr
+	Seller consensus.Addr
+	Price  uint64
+	Quant  uint64
+	// QuoteQuant is the quantity of the quote token paid.
+	QuoteQuant uint64
+}
+
+// FlaggedTrade is a suspicious trade.
+type FlaggedTrade struct {
+	MatchedTrade
+	Flags []string
+}
+
+// SuspicionScore is the trading record of an account in the window.
+// Score is the percentage of its trades that are flagged.
+type SuspicionScore struct {
+	Addr           consensus.Addr
+	Score          uint64
+	Trades         uint64
+	Flagged        uint64
+	SelfTrades     uint64
+	LinkedTrades   uint64
+	CircularTrades uint64
+}
+
+// SurveillanceReport is the accounts with a suspicion score of at
+// least the requested score, the highest first, and the latest
+// flagged trades.
+type SurveillanceReport struct {
+	Window   uint64
+	Round    uint64
+	Accounts []SuspicionScore
+	Trades   []FlaggedTrade
+}
+
+func (t *Transition) addMatch(market MarketSymbol, taker, maker orderExecution, quoteQuant uint64) {
+	m := MatchedTrade{Round: t.round, Market: market, Buyer: taker.Owner, Seller: maker.Owner, Price: taker.Price, Quant: taker.Quant, QuoteQuant: quoteQuant}
+	if taker.SellSide {
+		m.Buyer, m.Seller = maker.Owner, taker.Owner
+	}
+	t.matches = append(t.matches, m)
+}
+
+// setMatches sets the matched trades of the transition that produced
+// the state.
+func (s *State) setMatches(matches []MatchedTrade) {
+	s.mu.Lock()
+	s.matches = matches
+	s.mu.Unlock()
+}
+
+type indexedTrade struct {
+	MatchedTrade
+	flags []string
+}
+
+// flowKey is the flow of a market's base token from the seller to the
+// buyer.
+type flowKey struct {
+	market MarketSymbol
+	from   consensus.Addr
+	to     consensus.Addr
+}
+
+// Surveillance indexes the recent trades from the states, and flags
+// the self-crossing, the linked and the circular trading patterns
+// for the operators. It's not part of the consensus.
+type Surveillance struct {
+	mu      sync.Mutex
+	window  uint64
+	round   uint64
+	trades  []indexedTrade
+	flows   map[flowKey]uint64
+	out     map[MarketSymbol]map[consensus.Addr][]consensus.Addr
+	scores  map[consensus.Addr]*SuspicionScore
+	flagged []FlaggedTrade
+}
+
+// NewSurveillance creates a new surveillance over the trades of the
+// recent window rounds.
+func NewSurveillance(window uint64) *Surveillance {
+	if window == 0 {
+		window = DefaultSurveillanceWindow
+	}
+
+	return &Surveillance{
+		window: window,
+		flows:  make(map[flowKey]uint64),
+		out:    make(map[MarketSymbol]map[consensus.Addr][]consensus.Addr),
+		scores: make(map[consensus.Addr]*SuspicionScore),
+	}
+}
+
+// flowed returns if the base token flowed from one account to the
+// other within the window.
+func (v *Surveillance) flowed(market MarketSymbol, from, to consensus.Addr, round uint64) bool {
+	r, ok := v.flows[flowKey{market: market, from: from, to: to}]
+	return ok && r+v.window > round
+}
+
+func (v *Surveillance) circular(m MatchedTrade) bool {
+	if v.flowed(m.Market, m.Buyer, m.Seller, m.Round) {
+		return true
+	}
+
+	for _, x := range v.out[m.Market][m.Buyer] {
+		if v.flowed(m.Market, m.Buyer, x, m.Round) && v.flowed(m.Market, x, m.Seller, m.Round) {
+			return true
+		}
+	}
+	return false
+}
+
+func linked(s *State, round uint64, a, b consensus.Addr) bool {
+	if r, ok := s.Referrer(a); ok && r == b {
+		return true
+	}
+
+	if r, ok := s.Referrer(b); ok && r == a {
+		return true
+	}
+
+	return s.Guardian(a).At(round) == b || s.Guardian(b).At(round) == a
+}
+
+func (v *Surveillance) score(addr consensus.Addr) *SuspicionScore {
+	sc := v.scores[addr]
+	if sc == nil {
+		sc = &SuspicionScore{Addr: addr}
+		v.scores[addr] = sc
+	}
+	return sc
+}
+
+// count adds the trade to the scores of its parties, or removes it.
+func (v *Surveillance) count(t indexedTrade, add bool) {
+	inc := func(p *uint64) {
+		if add {
+			*p++
+		} else {
+			*p--
+		}
+	}
+
+	parties := []consensus.Addr{t.Buyer}
+	if t.Seller != t.Buyer {
+		parties = append(parties, t.Seller)
+	}
+
+	for _, addr := range parties {
+		sc := v.score(addr)
+		inc(&sc.Trades)
+		if len(t.flags) > 0 {
+			inc(&sc.Flagged)
+		}
+		for _, f := range t.flags {
+			switch f {
+			case SelfTradeFlag:
+				inc(&sc.SelfTrades)
+			case LinkedTradeFlag:
+				inc(&sc.LinkedTrades)
+			case CircularTradeFlag:
+				inc(&sc.CircularTrades)
+			}
+		}
+
+		if sc.Trades == 0 {
+			delete(v.scores, addr)
+		}
+	}
+}
+
+// Update indexes the matched trades of the state. The states of the
+// rounds not after the last indexed round are ignored.
+func (v *Surveillance) Update(s *State) {
+	s.mu.Lock()
+	round, matches := s.round, s.matches
+	s.mu.Unlock()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if round <= v.round {
+		return
+	}
+	v.round = round
+
+	for _, m := range matches {
+		t := indexedTrade{MatchedTrade: m}
+		if m.Buyer == m.Seller {
+			t.flags = append(t.flags, SelfTradeFlag)
+		} else {
+			if linked(s, round, m.Buyer, m.Seller) {
+				t.flags = append(t.flags, LinkedTradeFlag)
+			}
+
+			if v.circular(m) {
+				t.flags = append(t.flags, CircularTradeFlag)
+			}
+
+			k := flowKey{market: m.Market, from: m.Seller, to: m.Buyer}
+			if _, ok := v.flows[k]; !ok {
+				out := v.out[m.Market]
+				if out == nil {
+					out = make(map[consensus.Addr][]consensus.Addr)
+					v.out[m.Market] = out
+				}
+				out[m.Seller] = append(out[m.Seller], m.Buyer)
+			}
+			v.flows[k] = round
+		}
+
+		v.trades = append(v.trades, t)
+		v.count(t, true)
+		if len(t.flags) > 0 {
+			v.flagged = append(v.flagged, FlaggedTrade{MatchedTrade: m, Flags: t.flags})
+			if len(v.flagged) > maxFlaggedTrades {
+				v.flagged = v.flagged[len(v.flagged)-maxFlaggedTrades:]
+			}
+		}
+	}
+
+	v.expire(round)
+}
+
+// expire removes the trades and the flows out of the window.
+func (v *Surveillance) expire(round uint64) {
+	i := 0
+	for i < len(v.trades) && v.trades[i].Round+v.window <= round {
+		v.count(v.trades[i], false)
+		i++
+	}
+	v.trades = v.trades[i:]
+
+	if i == 0 {
+		return
+	}
+
+	for k, r := range v.flows {
+		if r+v.window > round {
+			continue
+		}
+
+		delete(v.flows, k)
+		out := v.out[k.market][k.from]
+		for j, to := range out {
+			if to == k.to {
+				out = append(out[:j], out[j+1:]...)
+				break
+			}
+		}
+		if len(out) == 0 {
+			delete(v.out[k.market], k.from)
+		} else {
+			v.out[k.market][k.from] = out
+		}
+	}
+}
+
+// Report returns the accounts with a suspicion score of at least
+// minScore, and the latest flagged trades.
+func (v *Surveillance) Report(minScore uint64) SurveillanceReport {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	r := SurveillanceReport{Window: v.window, Round: v.round}
+	for _, sc := range v.scores {
+		s := *sc
+		s.Score = s.Flagged * 100 / s.Trades
+		if s.Score >= minScore {
+			r.Accounts = append(r.Accounts, s)
+		}
+	}
+	sort.Slice(r.Accounts, func(i, j int) bool {
+		a, b := r.Accounts[i], r.Accounts[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Flagged > b.Flagged || a.Flagged == b.Flagged && bytes.Compare(a.Addr[:], b.Addr[:]) < 0
+	})
+	r.Trades = append([]FlaggedTrade(nil), v.flagged...)
+	return r
+}
@@ -0,0 +1,89 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSurveillanceSelfTrade(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	one := uint64(math.Pow10(OrderPriceDecimals))
+	market := MarketSymbol{Base: 1, Quote: 0}
+	pk, sk := RandKeyPair()
+	addr := pk.Addr()
+	acc := s.NewAccount(pk)
+	acc.UpdateBalance(0, Balance{Available: 1000000})
+	acc.UpdateBalance(1, Balance{Available: 1000000})
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 1000, Price: one, Market: market}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{Quant: 1000, Price: one, Market: market}, 1), pker))
+	s = trans.Commit().(*State)
+
+	v := NewSurveillance(10)
+	v.Update(s)
+	r := v.Report(0)
+	assert.Equal(t, []SuspicionScore{{Addr: addr, Score: 100, Trades: 1, Flagged: 1, SelfTrades: 1}}, r.Accounts)
+	assert.Equal(t, []FlaggedTrade{{MatchedTrade: MatchedTrade{Round: 1, Market: market, Buyer: addr, Seller: addr, Price: one, Quant: 1000, QuoteQuant: 1000}, Flags: []string{SelfTradeFlag}}}, r.Trades)
+}
+
+func TestSurveillancePatterns(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	m := MarketSymbol{Base: 1, Quote: 0}
+	var addrs []consensus.Addr
+	for i := 0; i < 4; i++ {
+		pk, _ := RandKeyPair()
+		s.NewAccount(pk)
+		addrs = append(addrs, pk.Addr())
+	}
+	a, b, c, d := addrs[0], addrs[1], addrs[2], addrs[3]
+	s.UpdateReferrer(d, a)
+
+	v := NewSurveillance(10)
+	update := func(round uint64, trades ...MatchedTrade) {
+		for i := range trades {
+			trades[i].Round = round
+			trades[i].Market = m
+		}
+		s.setTrades(round, nil)
+		s.setMatches(trades)
+		v.Update(s)
+	}
+	// a sells to b and b to c, c selling back to a closes a
+	// circle, so does a selling to b again.
+	update(1, MatchedTrade{Seller: a, Buyer: b, Quant: 1})
+	update(2, MatchedTrade{Seller: b, Buyer: c, Quant: 1})
+	update(3, MatchedTrade{Seller: c, Buyer: a, Quant: 1}, MatchedTrade{Seller: a, Buyer: b, Quant: 1})
+	r := v.Report(1)
+	assert.Equal(t, 2, len(r.Trades))
+	for _, trade := range r.Trades {
+		assert.Equal(t, []string{CircularTradeFlag}, trade.Flags)
+	}
+	assert.Equal(t, []SuspicionScore{
+		{Addr: a, Score: 66, Trades: 3, Flagged: 2, CircularTrades: 2},
+		{Addr: c, Score: 50, Trades: 2, Flagged: 1, CircularTrades: 1},
+		{Addr: b, Score: 33, Trades: 3, Flagged: 1, CircularTrades: 1},
+	}, r.Accounts)
+
+	// the referrer is linked.
+	update(4, MatchedTrade{Seller: a, Buyer: d, Quant: 1})
+	r = v.Report(100)
+	assert.Equal(t, 1, len(r.Accounts))
+	assert.Equal(t, SuspicionScore{Addr: d, Score: 100, Trades: 1, Flagged: 1, LinkedTrades: 1}, r.Accounts[0])
+
+	// the trades out of the window are forgotten.
+	update(13)
+	r = v.Report(0)
+	assert.Equal(t, 2, len(r.Accounts), "a and d of the trade of round 4")
+	update(14)
+	assert.Empty(t, v.Report(0).Accounts)
+	update(14, MatchedTrade{Seller: b, Buyer: a, Quant: 1})
+	assert.Empty(t, v.Report(0).Accounts, "a fork of the indexed round is ignored")
+}
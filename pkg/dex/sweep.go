@@ -0,0 +1,196 @@
+package dex
+
+import (
+	"errors"
+	"time"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// SweepClient is the wallet RPC calls used by the sweeper.
+type SweepClient interface {
+	WalletState(addr consensus.Addr) (WalletState, error)
+	FinalizedWalletState(addr consensus.Addr) (WalletState, error)
+	SendTxn(txn []byte) error
+}
+
+// SweepRule sweeps the available balance of the token above
+// Threshold to the cold address.
+type SweepRule struct {
+	Token     TokenID
+	Threshold uint64
+	// MinQuant is the min quantity of a sweep, the balance above
+	// the threshold is kept in the hot wallet until it reaches
+	// MinQuant, to avoid paying the txn fee for dust.
+	MinQuant uint64
+}
+
+// SweepConfig is the configuration of the sweeper.
+type SweepConfig struct {
+	Cold  PK
+	Rules []SweepRule
+	// MinInterval is the min duration between two sweeps of the
+	// same token.
+	MinInterval time.Duration
+	// MaxPerWindow is the max number of the sweeps sent in every
+	// Window, 0 means no limit.
+	MaxPerWindow int
+	Window       time.Duration
+	// OnConfirm is called when a sweep is finalized.
+	OnConfirm func(Sweep)
+}
+
+// Sweep is a send token txn from the hot wallet to the cold address.
+type Sweep struct {
+	Token TokenID
+	Quant uint64
+	Nonce uint64
+	Txn   consensus.Hash
+	Sent  time.Time
+}
+
+// Sweeper moves the balances above the thresholds from a hot trading
+// key to a cold address.
+//
+// A sweep is confirmed when the finalized nonce of the hot account
+// passes the nonce of the sweep. The hot key should not send other
+// txns concurrently, a txn that takes the nonce of a sweep makes the
+// sweep confirmed without being executed.
+type Sweeper struct {
+	client SweepClient
+	sk     SK
+	addr   consensus.Addr
+	cfg    SweepConfig
+
+	pending []Sweep
+	last    map[TokenID]time.Time
+	sent    []time.Time
+}
+
+// NewSweeper creates a new sweeper of the hot account.
+func NewSweeper(client SweepClient, sk SK, pk PK, cfg SweepConfig) (*Sweeper, error) {
+	if len(cfg.Cold) == 0 {
+		return nil, errors.New("cold address is not set")
+	}
+
+	if cfg.MaxPerWindow > 0 && cfg.Window <= 0 {
+		return nil, errors.New("window must be positive when the max sweeps per window is set")
+	}
+
+	return &Sweeper{
+		client: client,
+		sk:     sk,
+		addr:   pk.Addr(),
+		cfg:    cfg,
+		last:   make(map[TokenID]time.Time),
+	}, nil
+}
+
+// Pending returns the sweeps sent but not finalized.
+func (s *Sweeper) Pending() []Sweep {
+	return append([]Sweep(nil), s.pending...)
+}
+
+// Sweep confirms the finalized sweeps and sends the new sweeps
+// allowed by the rate limits, it returns the sweeps sent.
+func (s *Sweeper) Sweep(now time.Time) ([]Sweep, error) {
+	err := s.confirm()
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := s.client.WalletState(s.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make(map[TokenID]uint64)
+	for _, b := range state.Balances {
+		balances[b.Token] = b.Available
+	}
+
+	s.expireWindow(now)
+	nonce := state.Nonce
+	var sent []Sweep
+	for _, r := range s.cfg.Rules {
+		if s.isPending(r.Token) || !s.allowed(r.Token, now) {
+			continue
+		}
+
+		avail := balances[r.Token]
+		if avail <= r.Threshold {
+			continue
+		}
+
+		quant := avail - r.Threshold
+		if quant < r.MinQuant {
+			continue
+		}
+
+		txn := MakeSendTokenTxn(s.sk, s.addr, s.cfg.Cold, r.Token, quant, nonce)
+		err := s.client.SendTxn(txn)
+		if err != nil {
+			return sent, err
+		}
+
+		sw := Sweep{Token: r.Token, Quant: quant, Nonce: nonce, Txn: consensus.SHA3(txn), Sent: now}
+		nonce++
+		s.pending = append(s.pending, sw)
+		s.last[r.Token] = now
+		s.sent = append(s.sent, now)
+		sent = append(sent, sw)
+	}
+
+	return sent, nil
+}
+
+func (s *Sweeper) confirm() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	state, err := s.client.FinalizedWalletState(s.addr)
+	if err != nil {
+		return err
+	}
+
+	var pending []Sweep
+	for _, sw := range s.pending {
+		if sw.Nonce >= state.Nonce {
+			pending = append(pending, sw)
+			continue
+		}
+
+		if s.cfg.OnConfirm != nil {
+			s.cfg.OnConfirm(sw)
+		}
+	}
+	s.pending = pending
+	return nil
+}
+
+func (s *Sweeper) isPending(token TokenID) bool {
+	for _, sw := range s.pending {
+		if sw.Token == token {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Sweeper) expireWindow(now time.Time) {
+	i := 0
+	for i < len(s.sent) && now.Sub(s.sent[i]) >= s.cfg.Window {
+		i++
+	}
+	s.sent = s.sent[i:]
+}
+
+func (s *Sweeper) allowed(token TokenID, now time.Time) bool {
+	if s.cfg.MaxPerWindow > 0 && len(s.sent) >= s.cfg.MaxPerWindow {
+		return false
+	}
+
+	last, ok := s.last[token]
+	return !ok || now.Sub(last) >= s.cfg.MinInterval
+}
@@ -0,0 +1,128 @@
+package dex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+type sweepChain struct {
+	t         *testing.T
+	s         *State
+	finalized *State
+	pker      *myPKer
+	round     uint64
+	txns      [][]byte
+}
+
+func (c *sweepChain) WalletState(addr consensus.Addr) (w WalletState, err error) {
+	err = accountWalletState(c.s, addr, &w)
+	return
+}
+
+func (c *sweepChain) FinalizedWalletState(addr consensus.Addr) (w WalletState, err error) {
+	err = accountWalletState(c.finalized, addr, &w)
+	return
+}
+
+func (c *sweepChain) SendTxn(txn []byte) error {
+	c.txns = append(c.txns, txn)
+	return nil
+}
+
+// block records the sent txns in a new block, after setting the
+// available balances of the hot account.
+func (c *sweepChain) block(hot consensus.Addr, balances map[TokenID]uint64) {
+	c.round++
+	trans := c.s.Transition(c.round, 0, nil).(*Transition)
+	acc := trans.state.Account(hot)
+	for id, b := range balances {
+		acc.UpdateBalance(id, Balance{Available: b})
+	}
+	for _, txn := range c.txns {
+		assert.Nil(c.t, recordTxn(c.t, trans, txn, c.pker))
+	}
+	c.txns = nil
+	c.s = trans.Commit().(*State)
+}
+
+func TestSweeper(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	pkCold, _ := RandKeyPair()
+	s.NewAccount(pk)
+	c := &sweepChain{t: t, s: s, pker: &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}}
+	c.block(pk.Addr(), map[TokenID]uint64{0: 1000, 1: 50})
+	c.finalized = c.s
+
+	var confirmed []Sweep
+	sw, err := NewSweeper(c, sk, pk, SweepConfig{
+		Cold: pkCold,
+		Rules: []SweepRule{
+			{Token: 0, Threshold: 100},
+			{Token: 1, Threshold: 10, MinQuant: 50},
+		},
+		MinInterval:  time.Minute,
+		MaxPerWindow: 3,
+		Window:       time.Hour,
+		OnConfirm:    func(s Sweep) { confirmed = append(confirmed, s) },
+	})
+	assert.Nil(t, err)
+
+	now := time.Unix(0, 0)
+	sent, err := sw.Sweep(now)
+	assert.Nil(t, err)
+	// token 1 is below the min quantity.
+	assert.Equal(t, 1, len(sent))
+	assert.Equal(t, Sweep{Token: 0, Quant: 900, Nonce: 0, Txn: consensus.SHA3(c.txns[0]), Sent: now}, sent[0])
+
+	// the sweep is not finalized, token 0 is not swept again.
+	c.block(pk.Addr(), map[TokenID]uint64{0: 2000})
+	sent, err = sw.Sweep(now.Add(2 * time.Minute))
+	assert.Nil(t, err)
+	assert.Empty(t, sent)
+	assert.Empty(t, confirmed)
+	assert.Equal(t, uint64(900), c.s.Account(pkCold.Addr()).Balance(0).Available)
+
+	c.finalized = c.s
+	c.block(pk.Addr(), map[TokenID]uint64{0: 500, 1: 70})
+	sent, err = sw.Sweep(now.Add(3 * time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(confirmed))
+	assert.Equal(t, uint64(900), confirmed[0].Quant)
+	assert.Equal(t, []Sweep{
+		{Token: 0, Quant: 400, Nonce: 1, Txn: consensus.SHA3(c.txns[0]), Sent: now.Add(3 * time.Minute)},
+		{Token: 1, Quant: 60, Nonce: 2, Txn: consensus.SHA3(c.txns[1]), Sent: now.Add(3 * time.Minute)},
+	}, sent)
+	c.block(pk.Addr(), nil)
+	c.finalized = c.s
+	assert.Equal(t, uint64(100), c.s.Account(pk.Addr()).Balance(0).Available)
+	assert.Equal(t, uint64(10), c.s.Account(pk.Addr()).Balance(1).Available)
+
+	// the window allows 3 sweeps per hour.
+	c.block(pk.Addr(), map[TokenID]uint64{0: 500})
+	sent, err = sw.Sweep(now.Add(10 * time.Minute))
+	assert.Nil(t, err)
+	assert.Empty(t, sent)
+	assert.Equal(t, 3, len(confirmed))
+	assert.Empty(t, sw.Pending())
+
+	sent, err = sw.Sweep(now.Add(time.Hour + 3*time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(sent))
+	assert.Equal(t, uint64(400), sent[0].Quant)
+	assert.Equal(t, uint64(3), sent[0].Nonce)
+}
+
+func TestNewSweeperInvalidConfig(t *testing.T) {
+	pk, sk := RandKeyPair()
+	_, err := NewSweeper(nil, sk, pk, SweepConfig{})
+	assert.NotNil(t, err)
+	_, err = NewSweeper(nil, sk, pk, SweepConfig{Cold: pk, MaxPerWindow: 1})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,93 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// the length limits of the symbol of a token issued by a user.
+const (
+	MinSymbolLen = 2
+	MaxSymbolLen = 10
+	// maxIssuerNameLen is the max length of the name in a
+	// verified issuer attestation.
+	maxIssuerNameLen = 64
+)
+
+// ValidateSymbol checks the symbol of a token issued by a user, it
+// must be 2 to 10 ASCII letters and digits starting with a letter.
+// The symbols created by the governance and the IBC vouchers are not
+// subject to the policy.
+func ValidateSymbol(s TokenSymbol) error {
+	if len(s) < MinSymbolLen || len(s) > MaxSymbolLen {
+		return fmt.Errorf("token symbol %q should be %d to %d characters", s, MinSymbolLen, MaxSymbolLen)
+	}
+
+	for i, c := range s {
+		letter := (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+		digit := c >= '0' && c <= '9'
+		if i == 0 && !letter {
+			return fmt.Errorf("token symbol %q should start with a letter", s)
+		}
+
+		if !letter && !digit {
+			return fmt.Errorf("token symbol %q should only contain letters and digits", s)
+		}
+	}
+
+	return nil
+}
+
+// symbolConfusables maps the characters that look alike to the same
+// character.
+var symbolConfusables = strings.NewReplacer("0", "O", "1", "I")
+
+// symbolKey returns the key of the symbol in the symbol namespace. The
+// symbols are unique by key, which is case-insensitive and treats the
+// look-alike characters as the same, so an impostor can not issue
+// "BTc" or "B0B" next to "BTC" or "BOB".
+func symbolKey(s TokenSymbol) TokenSymbol {
+	return TokenSymbol(symbolConfusables.Replace(strings.ToUpper(string(s))))
+}
+
+// SymbolConflicts returns true if the two symbols can not coexist.
+func SymbolConflicts(a, b TokenSymbol) bool {
+	return symbolKey(a) == symbolKey(b)
+}
+
+// VerifiedIssuer is the governor's attestation that the token is
+// issued by the named issuer, the wallets warn about the tokens
+// without it.
+type VerifiedIssuer struct {
+	Name  string
+	Round uint64
+}
+
+func (t *Transition) verifyIssuer(owner *Account, txn *VerifyIssuerTxn) error {
+	if err := t.checkGovernor(owner); err != nil {
+		return err
+	}
+
+	// the token IDs are sequential, including the tokens
+	// created in the current transition.
+	if int(txn.Token) >= t.tokenCache.Size()+len(t.tokenCreations) {
+		return fmt.Errorf("token %d does not exist", txn.Token)
+	}
+
+	if txn.Revoke {
+		if _, ok := t.state.VerifiedIssuer(txn.Token); !ok {
+			return errors.New("token issuer is not verified")
+		}
+
+		t.state.RemoveVerifiedIssuer(txn.Token)
+		return nil
+	}
+
+	if txn.Name == "" || len(txn.Name) > maxIssuerNameLen {
+		return fmt.Errorf("issuer name should be 1 to %d characters", maxIssuerNameLen)
+	}
+
+	t.state.UpdateVerifiedIssuer(txn.Token, VerifiedIssuer{Name: txn.Name, Round: t.round})
+	return nil
+}
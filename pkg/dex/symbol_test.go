@@ -0,0 +1,82 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSymbol(t *testing.T) {
+	for _, s := range []TokenSymbol{"BTC", "eth", "T1", "ABCDEFGHIJ"} {
+		assert.Nil(t, ValidateSymbol(s), string(s))
+	}
+
+	for _, s := range []TokenSymbol{"", "B", "ABCDEFGHIJK", "1BTC", "BTC-2", "channel-0/BTC", "ΒTC"} {
+		assert.NotNil(t, ValidateSymbol(s), string(s))
+	}
+}
+
+func TestSymbolConflicts(t *testing.T) {
+	assert.True(t, SymbolConflicts("BTC", "btc"))
+	assert.True(t, SymbolConflicts("BOB", "B0B"))
+	assert.True(t, SymbolConflicts("ICX", "1CX"))
+	assert.False(t, SymbolConflicts("BTC", "BCC"))
+
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	c := newTokenCache(s)
+	assert.True(t, c.Exists("bnb"))
+	c.Update(1, TokenInfo{Symbol: "eos"})
+	assert.True(t, c.Exists("E0S"))
+}
+
+func TestIssueTokenSymbolPolicy(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	s.NewAccount(pk)
+	addr := pk.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.NotNil(t, recordTxn(t, trans, MakeIssueTokenTxn(sk, addr, TokenInfo{Symbol: "B-T-C", TotalUnits: 1}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeIssueTokenTxn(sk, addr, TokenInfo{Symbol: "BOB", TotalUnits: 1}, 0), pker))
+	assert.NotNil(t, recordTxn(t, trans, MakeIssueTokenTxn(sk, addr, TokenInfo{Symbol: "b0b", TotalUnits: 1}, 1), pker), "conflicts in the same transition")
+	s = trans.Commit().(*State)
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.NotNil(t, recordTxn(t, trans, MakeIssueTokenTxn(sk, addr, TokenInfo{Symbol: "Bob", TotalUnits: 1}, 1), pker))
+}
+
+func TestVerifyIssuer(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pkGov, skGov := RandKeyPair()
+	pk, sk := RandKeyPair()
+	s.NewAccount(pkGov)
+	s.NewAccount(pk)
+	s.UpdateGovernor(pkGov.Addr())
+	gov := pkGov.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{gov: pkGov, pk.Addr(): pk}}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeIssueTokenTxn(sk, pk.Addr(), TokenInfo{Symbol: "XYZ", TotalUnits: 1}, 0), pker))
+	assert.NotNil(t, recordTxn(t, trans, MakeVerifyIssuerTxn(sk, pk.Addr(), VerifyIssuerTxn{Token: 1, Name: "XYZ Labs"}, 1), pker), "not the governor")
+	assert.NotNil(t, recordTxn(t, trans, MakeVerifyIssuerTxn(skGov, gov, VerifyIssuerTxn{Token: 2, Name: "XYZ Labs"}, 0), pker), "token does not exist")
+	assert.Nil(t, recordTxn(t, trans, MakeVerifyIssuerTxn(skGov, gov, VerifyIssuerTxn{Token: 1, Name: "XYZ Labs"}, 0), pker))
+	s = trans.Commit().(*State)
+
+	v, ok := s.VerifiedIssuer(1)
+	assert.True(t, ok)
+	assert.Equal(t, VerifiedIssuer{Name: "XYZ Labs", Round: 1}, v)
+	_, ok = s.VerifiedIssuer(0)
+	assert.False(t, ok)
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeVerifyIssuerTxn(skGov, gov, VerifyIssuerTxn{Token: 1, Revoke: true}, 1), pker))
+	s = trans.Commit().(*State)
+	_, ok = s.VerifiedIssuer(1)
+	assert.False(t, ok)
+}
@@ -0,0 +1,161 @@
+package dex
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// tickerWindow is the rolling window of the ticker statistics.
+const tickerWindow = 24 * time.Hour
+
+// Ticker is the summary of a market over the last 24 hours. The
+// statistics are kept by the node from the blocks it received, they
+// are not part of the consensus state.
+type Ticker struct {
+	Market MarketSymbol
+	// Last is the price of the last trade, it is kept after the
+	// trade leaves the window.
+	Last   uint64
+	Open   uint64
+	High   uint64
+	Low    uint64
+	Volume uint64
+	// BestBid and BestAsk are 0 if the side is empty.
+	BestBid uint64
+	BestAsk uint64
+	// Round is the round of the last trade.
+	Round uint64
+}
+
+type TickerState struct {
+	Tickers []Ticker
+}
+
+// tickerBucket is the trades of a market in a round.
+type tickerBucket struct {
+	time   time.Time
+	open   uint64
+	high   uint64
+	low    uint64
+	volume uint64
+}
+
+type marketTicker struct {
+	last    uint64
+	round   uint64
+	buckets []tickerBucket
+}
+
+// tickers maintains the rolling ticker statistics of the markets.
+type tickers struct {
+	mu      sync.Mutex
+	round   uint64
+	markets map[MarketSymbol]*marketTicker
+}
+
+func newTickers() *tickers {
+	return &tickers{markets: make(map[MarketSymbol]*marketTicker)}
+}
+
+// setTrades sets the trades of the transition that produced the
+// state.
+func (s *State) setTrades(round uint64, trades map[MarketSymbol][]PriceSample) {
+	s.mu.Lock()
+	s.round = round
+	s.trades = trades
+	s.mu.Unlock()
+}
+
+// update adds the trades of the state received at now. The states
+// of the rounds not after the last added round are ignored, a fork
+// could be reported more than once.
+func (t *tickers) update(now time.Time, s *State) {
+	s.mu.Lock()
+	round, trades := s.round, s.trades
+	s.mu.Unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if round <= t.round {
+		return
+	}
+	t.round = round
+
+	for m, samples := range trades {
+		if len(samples) == 0 {
+			continue
+		}
+
+		mt := t.markets[m]
+		if mt == nil {
+			mt = &marketTicker{}
+			t.markets[m] = mt
+		}
+
+		b := tickerBucket{time: now, open: samples[0].Price, high: samples[0].Price, low: samples[0].Price}
+		for _, sample := range samples {
+			if sample.Price > b.high {
+				b.high = sample.Price
+			}
+			if sample.Price < b.low {
+				b.low = sample.Price
+			}
+			b.volume += sample.Volume
+		}
+		mt.buckets = append(mt.buckets, b)
+		mt.last = samples[len(samples)-1].Price
+		mt.round = round
+	}
+}
+
+// tickers returns the tickers of the markets traded since the node
+// started, sorted by market.
+func (t *tickers) tickers(now time.Time, s *State) []Ticker {
+	t.mu.Lock()
+	r := make([]Ticker, 0, len(t.markets))
+	for m, mt := range t.markets {
+		i := 0
+		for i < len(mt.buckets) && now.Sub(mt.buckets[i].time) >= tickerWindow {
+			i++
+		}
+		mt.buckets = mt.buckets[i:]
+
+		ticker := Ticker{Market: m, Last: mt.last, Round: mt.round}
+		for i, b := range mt.buckets {
+			if i == 0 {
+				ticker.Open = b.open
+				ticker.High = b.high
+				ticker.Low = b.low
+			}
+			if b.high > ticker.High {
+				ticker.High = b.high
+			}
+			if b.low < ticker.Low {
+				ticker.Low = b.low
+			}
+			ticker.Volume += b.volume
+		}
+		r = append(r, ticker)
+	}
+	t.mu.Unlock()
+
+	for i := range r {
+		d := s.Depth(r[i].Market, 1)
+		if len(d.Bids) > 0 {
+			r[i].BestBid = d.Bids[0].Price
+		}
+		if len(d.Asks) > 0 {
+			r[i].BestAsk = d.Asks[0].Price
+		}
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Market.Base != r[j].Market.Base {
+			return r[i].Market.Base < r[j].Market.Base
+		}
+		return r[i].Market.Quote < r[j].Market.Quote
+	})
+	return r
+}
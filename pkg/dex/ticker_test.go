@@ -0,0 +1,61 @@
+package dex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTickers(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	m0 := MarketSymbol{Base: 1, Quote: 0}
+	m1 := MarketSymbol{Base: 2, Quote: 0}
+	book := newOrderBook()
+	book.Add(Order{Price: 90, Quant: 1})
+	book.Add(Order{SellSide: true, Price: 110, Quant: 1})
+	s.saveOrderBook(m0, book)
+
+	tk := newTickers()
+	start := time.Unix(0, 0)
+	s.setTrades(1, map[MarketSymbol][]PriceSample{
+		m0: {{Price: 100, Volume: 2}, {Price: 120, Volume: 1}, {Price: 105, Volume: 1}},
+	})
+	tk.update(start, s)
+	s.setTrades(2, map[MarketSymbol][]PriceSample{
+		m0: {{Price: 95, Volume: 3}},
+		m1: {{Price: 7, Volume: 5}},
+	})
+	tk.update(start.Add(time.Hour), s)
+	// the state of a fork of round 2 is ignored.
+	s.setTrades(2, map[MarketSymbol][]PriceSample{
+		m0: {{Price: 1, Volume: 100}},
+	})
+	tk.update(start.Add(time.Hour), s)
+
+	assert.Equal(t, []Ticker{
+		{Market: m0, Last: 95, Open: 100, High: 120, Low: 95, Volume: 7, BestBid: 90, BestAsk: 110, Round: 2},
+		{Market: m1, Last: 7, Open: 7, High: 7, Low: 7, Volume: 5, Round: 2},
+	}, tk.tickers(start.Add(2*time.Hour), s))
+
+	// the trades of round 1 leave the window.
+	assert.Equal(t, []Ticker{
+		{Market: m0, Last: 95, Open: 95, High: 95, Low: 95, Volume: 3, BestBid: 90, BestAsk: 110, Round: 2},
+		{Market: m1, Last: 7, Open: 7, High: 7, Low: 7, Volume: 5, Round: 2},
+	}, tk.tickers(start.Add(tickerWindow), s))
+
+	// the last price is kept when there is no trade in the
+	// window.
+	assert.Equal(t, []Ticker{
+		{Market: m0, Last: 95, BestBid: 90, BestAsk: 110, Round: 2},
+		{Market: m1, Last: 7, Round: 2},
+	}, tk.tickers(start.Add(2*tickerWindow), s))
+}
+
+func TestTransitionSetsTrades(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s = s.Transition(3, 0, nil).Commit().(*State)
+	assert.Equal(t, uint64(3), s.round)
+	assert.NotNil(t, s.trades)
+}
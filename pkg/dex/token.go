@@ -1,9 +1,6 @@
 package dex
 
-import (
-	"sort"
-	"strings"
-)
+import "sort"
 
 type TokenSymbol string
 
@@ -20,27 +17,42 @@ type Token struct {
 	TokenInfo
 }
 
+// TokenCache is the token infos of a state. It is copy-on-write: the
+// cache of a committed state is shared by the transitions on it, and
+// a transition copies the maps on its first update.
 type TokenCache struct {
 	idToInfo map[TokenID]TokenInfo
 	exists   map[TokenSymbol]bool
+	// owned is true if the maps are not shared with another
+	// cache.
+	owned bool
 }
 
 func newTokenCache(s *State) *TokenCache {
 	c := &TokenCache{
 		idToInfo: make(map[TokenID]TokenInfo),
 		exists:   make(map[TokenSymbol]bool),
+		owned:    true,
 	}
 
 	tokens := s.Tokens()
 	for _, t := range tokens {
 		c.idToInfo[t.ID] = t.TokenInfo
-		c.exists[t.Symbol] = true
+		c.exists[symbolKey(t.Symbol)] = true
 	}
 	return c
 }
 
+// fork returns a cache that shares the maps with t until it is
+// updated, t must not be updated after it is forked.
+func (t *TokenCache) fork() *TokenCache {
+	return &TokenCache{idToInfo: t.idToInfo, exists: t.exists}
+}
+
+// Exists returns true if the symbol conflicts with an existing
+// token's, see SymbolConflicts.
 func (t *TokenCache) Exists(s TokenSymbol) bool {
-	return t.exists[s]
+	return t.exists[symbolKey(s)]
 }
 
 var zeroInfo TokenInfo
@@ -50,8 +62,22 @@ func (t *TokenCache) Info(id TokenID) TokenInfo {
 }
 
 func (t *TokenCache) Update(id TokenID, info TokenInfo) {
+	if !t.owned {
+		idToInfo := make(map[TokenID]TokenInfo, len(t.idToInfo)+1)
+		for k, v := range t.idToInfo {
+			idToInfo[k] = v
+		}
+		exists := make(map[TokenSymbol]bool, len(t.exists)+1)
+		for k, v := range t.exists {
+			exists[k] = v
+		}
+		t.idToInfo = idToInfo
+		t.exists = exists
+		t.owned = true
+	}
+
 	t.idToInfo[id] = info
-	t.exists[TokenSymbol(strings.ToUpper(string(info.Symbol)))] = true
+	t.exists[symbolKey(info.Symbol)] = true
 }
 
 func (t *TokenCache) Size() int {
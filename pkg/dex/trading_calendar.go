@@ -0,0 +1,113 @@
+package dex
+
+import "fmt"
+
+// TradingSession is a window of rounds, Open inclusive and Close
+// exclusive.
+type TradingSession struct {
+	Open  uint64
+	Close uint64
+}
+
+func (s TradingSession) contains(round uint64) bool {
+	return round >= s.Open && round < s.Close
+}
+
+// TradingCalendar is the trading hours of a market representing a
+// traditional asset. From round Start, the calendar repeats every
+// Period rounds, e.g., a week, and the market accepts orders only
+// within the Sessions, in the rounds from the start of the period.
+// The market is closed within the Maintenance windows, in absolute
+// rounds, regardless of the sessions. The cancels are accepted when
+// the market is closed.
+type TradingCalendar struct {
+	Start       uint64
+	Period      uint64
+	Sessions    []TradingSession
+	Maintenance []TradingSession
+}
+
+// Empty returns true if the calendar does not close the market.
+func (c TradingCalendar) Empty() bool {
+	return c.Period == 0 && len(c.Maintenance) == 0
+}
+
+func (c TradingCalendar) validate() error {
+	if c.Period == 0 && len(c.Sessions) > 0 {
+		return fmt.Errorf("trading sessions need a period")
+	}
+
+	if c.Period > 0 && len(c.Sessions) == 0 {
+		return fmt.Errorf("trading calendar of period %d has no session", c.Period)
+	}
+
+	var end uint64
+	for i, s := range c.Sessions {
+		if s.Open >= s.Close || s.Close > c.Period {
+			return fmt.Errorf("invalid trading session %d - %d of period %d", s.Open, s.Close, c.Period)
+		}
+
+		if i > 0 && s.Open < end {
+			return fmt.Errorf("trading session %d - %d is not after the previous session", s.Open, s.Close)
+		}
+		end = s.Close
+	}
+
+	for _, s := range c.Maintenance {
+		if s.Open >= s.Close {
+			return fmt.Errorf("invalid maintenance window %d - %d", s.Open, s.Close)
+		}
+	}
+
+	return nil
+}
+
+// Open returns true if the market accepts orders at the round.
+func (c TradingCalendar) Open(round uint64) bool {
+	for _, s := range c.Maintenance {
+		if s.contains(round) {
+			return false
+		}
+	}
+
+	if c.Period == 0 || round < c.Start {
+		return true
+	}
+
+	offset := (round - c.Start) % c.Period
+	for _, s := range c.Sessions {
+		if s.contains(offset) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *Transition) setTradingCalendar(owner *Account, txn *SetTradingCalendarTxn) error {
+	if err := t.checkGovernor(owner); err != nil {
+		return err
+	}
+
+	if !txn.Market.Valid() {
+		return txnErrorf(ErrCodeBadMarket, "market is invalid: %v", txn.Market)
+	}
+
+	if err := txn.Calendar.validate(); err != nil {
+		return err
+	}
+
+	t.state.UpdateTradingCalendar(txn.Market, txn.Calendar)
+	return nil
+}
+
+// checkTradingSession returns an error if the market is closed at the
+// round by its trading calendar.
+func (t *Transition) checkTradingSession(m MarketSymbol, round uint64) error {
+	c, ok := t.state.TradingCalendar(m)
+	if ok && !c.Open(round) {
+		return txnErrorf(ErrCodeBadMarket, "market %v is closed at round %d by its trading calendar", m, round)
+	}
+
+	return nil
+}
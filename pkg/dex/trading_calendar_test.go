@@ -0,0 +1,71 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTradingCalendarOpen(t *testing.T) {
+	c := TradingCalendar{
+		Start:       100,
+		Period:      10,
+		Sessions:    []TradingSession{{Open: 0, Close: 3}, {Open: 5, Close: 8}},
+		Maintenance: []TradingSession{{Open: 50, Close: 60}, {Open: 121, Close: 122}},
+	}
+	assert.Nil(t, c.validate())
+
+	assert.True(t, c.Open(99))
+	assert.False(t, c.Open(55))
+	assert.True(t, c.Open(100))
+	assert.False(t, c.Open(103))
+	assert.True(t, c.Open(115))
+	assert.False(t, c.Open(118))
+	assert.True(t, c.Open(120))
+	assert.False(t, c.Open(121))
+
+	assert.NotNil(t, TradingCalendar{Period: 10}.validate())
+	assert.NotNil(t, TradingCalendar{Period: 10, Sessions: []TradingSession{{Open: 5, Close: 11}}}.validate())
+	assert.NotNil(t, TradingCalendar{Period: 10, Sessions: []TradingSession{{Open: 5, Close: 8}, {Open: 0, Close: 3}}}.validate())
+	assert.NotNil(t, TradingCalendar{Maintenance: []TradingSession{{Open: 5, Close: 5}}}.validate())
+}
+
+func TestSetTradingCalendar(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: TokenInfo{Symbol: "AAPL", Decimals: 8, TotalUnits: 1e10}})
+	pkGov, skGov := RandKeyPair()
+	pk, sk := RandKeyPair()
+	s.NewAccount(pkGov)
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 1e10})
+	s.UpdateGovernor(pkGov.Addr())
+	pker := &myPKer{m: map[consensus.Addr]PK{pkGov.Addr(): pkGov, pk.Addr(): pk}}
+
+	m := MarketSymbol{Base: 1, Quote: 0}
+	cal := SetTradingCalendarTxn{
+		Market:   m,
+		Calendar: TradingCalendar{Start: 0, Period: 10, Sessions: []TradingSession{{Open: 0, Close: 5}}},
+	}
+	trans := s.Transition(1, 0, nil).(*Transition)
+	err := recordTxn(t, trans, MakeSetTradingCalendarTxn(sk, pk.Addr(), cal, 0), pker)
+	assert.Equal(t, ErrCodeUnauthorized, ErrorCode(err))
+	assert.Nil(t, recordTxn(t, trans, MakeSetTradingCalendarTxn(skGov, pkGov.Addr(), cal, 0), pker))
+	s = trans.Commit().(*State)
+
+	order := PlaceOrderTxn{Quant: 1e8, Price: 1e8, Market: m}
+	trans = s.Transition(7, 0, nil).(*Transition)
+	err = recordTxn(t, trans, MakePlaceOrderTxn(sk, pk.Addr(), order, 0), pker)
+	assert.Equal(t, ErrCodeBadMarket, ErrorCode(err))
+
+	trans = s.Transition(12, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(sk, pk.Addr(), order, 0), pker))
+
+	// an empty calendar removes it.
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeSetTradingCalendarTxn(skGov, pkGov.Addr(), SetTradingCalendarTxn{Market: m}, 1), pker))
+	s = trans.Commit().(*State)
+	_, ok := s.TradingCalendar(m)
+	assert.False(t, ok)
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"math"
 	"math/big"
-	"strings"
+	"runtime"
+	"sort"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/helinwang/dex/pkg/consensus"
@@ -14,56 +16,158 @@ import (
 
 var flatFee = uint64(0.0001 * math.Pow10(int(BNBInfo.Decimals)))
 
+// openingAuctionRounds is the number of rounds that a market trading
+// a newly issued token stays in the opening auction phase. During
+// the phase orders are accumulated without matching, and are
+// uncrossed at a single price when the phase ends.
+const openingAuctionRounds = 10
+
+const (
+	// maxRecurringOrderCount is the max number of the child
+	// orders of a recurring order.
+	maxRecurringOrderCount = 1000
+	// maxRecurringOrderInterval is the max number of rounds
+	// between the child orders of a recurring order.
+	maxRecurringOrderInterval = 1000000
+	// maxRecurringOrders is the max number of the active
+	// recurring orders of an account.
+	maxRecurringOrders = 10
+)
+
 type Transition struct {
 	round uint64
-	fee   uint64
+	// timestamp is the block's timestamp in Unix milliseconds.
+	timestamp uint64
+	fee       uint64
+	// convertedFees is the fees paid in the tokens other than
+	// the native token.
+	convertedFees map[TokenID]uint64
+	// freeTxns is the number of fee-free txns of the accounts
+	// recorded in the round.
+	freeTxns map[consensus.Addr]uint64
 	// don't collect fee if proposer is nil, this happens when:
 	// a. replaying a block rather than proposing a block
 	// b. in unit test
-	proposer        PK
-	finalized       bool
+	proposer  PK
+	finalized bool
+	// txn is the hash of the txn being recorded, it tags the log
+	// lines and the spans of the txn.
+	txn             consensus.Hash
 	tokenCreations  []Token
 	txns            [][]byte
 	expirations     map[uint64][]orderExpiration
+	recurringOrders map[uint64][]recurringOrder
 	filledOrders    []PendingOrder
+	trades          map[MarketSymbol][]PriceSample
+	// executed is the executed quantities by the order ID.
+	executed        map[MarketSymbol]map[uint64]uint64
+	matches         []MatchedTrade
+	perpTrades      map[MarketSymbol][]PriceSample
+	perpBooks       map[MarketSymbol]*orderBook
+	ibcPackets      []consensus.Hash
+	oracleReports   map[TokenID][]uint64
+	oracleReporters map[consensus.Addr]bool
 	state           *State
 	orderBooks      map[MarketSymbol]*orderBook
 	dirtyOrderBooks map[MarketSymbol]bool
 	tokenCache      *TokenCache
 }
 
-func newTransition(s *State, round uint64, proposer PK) *Transition {
+func newTransition(s *State, round, timestamp uint64, proposer PK) *Transition {
 	return &Transition{
 		state:           s,
 		round:           round,
+		timestamp:       timestamp,
 		proposer:        proposer,
 		expirations:     make(map[uint64][]orderExpiration),
+		recurringOrders: make(map[uint64][]recurringOrder),
 		orderBooks:      make(map[MarketSymbol]*orderBook),
 		dirtyOrderBooks: make(map[MarketSymbol]bool),
-		tokenCache:      newTokenCache(s),
+		trades:          make(map[MarketSymbol][]PriceSample),
+		executed:        make(map[MarketSymbol]map[uint64]uint64),
+		perpTrades:      make(map[MarketSymbol][]PriceSample),
+		perpBooks:       make(map[MarketSymbol]*orderBook),
+		oracleReports:   make(map[TokenID][]uint64),
+		oracleReporters: make(map[consensus.Addr]bool),
+		convertedFees:   make(map[TokenID]uint64),
+		freeTxns:        make(map[consensus.Addr]uint64),
+		tokenCache:      s.tokenCache(),
 		filledOrders:    make([]PendingOrder, 0, 1000), // optimization: preallocate buffer
 	}
 }
 
-func (t *Transition) RecordSerialized(blob []byte, pool consensus.TxnPool) (int, error) {
+// Timestamp returns the timestamp of the transition's block in Unix
+// milliseconds. The timestamps of the blocks never go back, but
+// the consecutive blocks could have the same timestamp.
+func (t *Transition) Timestamp() uint64 {
+	return t.timestamp
+}
+
+// RecordSerialized records the serialized txns, the txns must be in
+// the canonical order determined by the seed, with no two txns of the
+// same owner and nonce.
+func (t *Transition) RecordSerialized(blob []byte, pool consensus.TxnPool, seed consensus.Rand) (int, error) {
 	var txns [][]byte
 	err := rlp.DecodeBytes(blob, &txns)
 	if err != nil {
 		return 0, err
 	}
 
-	for _, b := range txns {
+	var prev *consensus.TxnOrder
+
+	parsed := addTxns(pool, txns)
+	for i, b := range txns {
 		hash := consensus.SHA3(b)
-		txn := pool.Get(hash)
+		txn := parsed[i]
 		if txn == nil {
-			txn, _ = pool.Add(b)
+			// a block of a newer txn version is not invalid,
+			// the node can not validate it before upgrading.
+			if _, err := parseTxn(b, t.state); ErrorCode(err) == ErrCodeUpgradeRequired {
+				return 0, err
+			}
+			return 0, fmt.Errorf("invalid txn %v in the block", hash)
 		}
 
 		if txn.MinerFeeTxn {
-			t.giveMinerFee(*txn.Decoded.(*MinerFeeTxn))
+			// the miner fee txn is appended by the proposer
+			// after all the other txns.
+			if i != len(txns)-1 {
+				return 0, fmt.Errorf("miner fee txn %v is not the last txn in the block", hash)
+			}
+
+			feeTxn := txn.Decoded.(*MinerFeeTxn)
+			if err := t.checkMinerFee(feeTxn); err != nil {
+				return 0, err
+			}
+
+			t.fee = 0
+			t.convertedFees = make(map[TokenID]uint64)
+			t.setAudit(txn, AuditMinerFee)
+			t.giveMinerFee(*feeTxn)
 			continue
 		}
 
+		order := consensus.NewTxnOrder(txn, seed)
+		if prev != nil {
+			// the txns of an owner are adjacent in the
+			// canonical order, so the duplicates and the
+			// conflicts are adjacent too. The block is
+			// rejected rather than skipping them, the
+			// proposer must have dropped them.
+			if prev.Txn == order.Txn {
+				return 0, fmt.Errorf("duplicate txn %v in the block", hash)
+			}
+
+			if prev.Conflicts(order) {
+				return 0, fmt.Errorf("txn %v conflicts with the previous txn of the same owner and nonce %d", hash, txn.Nonce)
+			}
+
+			if !prev.Less(order) {
+				return 0, errors.New("txns are not in the canonical order")
+			}
+		}
+		prev = &order
+
 		err = t.RecordImpl(txn, true)
 		if err != nil {
 			return 0, err
@@ -74,53 +178,161 @@ func (t *Transition) RecordSerialized(blob []byte, pool consensus.TxnPool) (int,
 	return len(txns), nil
 }
 
+// addTxns returns the parsed txns, the txns not in the pool are
+// added to the pool concurrently, since the signature verification
+// dominates the time of replaying a block. The returned txn is nil
+// if the txn is invalid.
+func addTxns(pool consensus.TxnPool, txns [][]byte) []*consensus.Txn {
+	parsed := make([]*consensus.Txn, len(txns))
+	ch := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				txn := pool.Get(consensus.SHA3(txns[i]))
+				if txn == nil {
+					txn, _ = pool.Add(txns[i])
+				}
+				parsed[i] = txn
+			}
+		}()
+	}
+
+	for i := range txns {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+	return parsed
+}
+
 // Record records a transition to the state transition.
 func (t *Transition) Record(txn *consensus.Txn) (err error) {
-	return t.RecordImpl(txn, false)
+	err = t.RecordImpl(txn, false)
+	if err != nil {
+		countTxnError(err)
+	}
+	return err
 }
 
 func (t *Transition) RecordImpl(txn *consensus.Txn, forceFee bool) (err error) {
 	if t.finalized {
 		panic("record should never be called after finalized")
 	}
+	t.txn = consensus.SHA3(txn.Raw)
+	end := consensus.StartSpan("record_txn", "round", t.round, "txn", t.txn)
+	defer func() {
+		end(err)
+		t.txn = consensus.Hash{}
+	}()
+
 	acc := t.state.Account(txn.Owner)
 	if acc == nil {
 		return errors.New("txn owner not found")
 	}
 
+	if txn.Expired(t.round) {
+		return txnErrorf(ErrCodeExpired, "txn is valid until round %d, current round: %d", txn.ValidUntil, t.round)
+	}
+
 	if !txn.MinerFeeTxn {
-		if nonce := acc.Nonce(); txn.Nonce < nonce {
-			return errors.New("nonce not valid")
+		if nonce := acc.LaneNonce(txn.Lane); txn.Nonce < nonce {
+			return txnErrorf(ErrCodeBadNonce, "nonce not valid, lane: %d, nonce: %d, expected: %d", txn.Lane, txn.Nonce, nonce)
 		} else if txn.Nonce > nonce {
 			return consensus.ErrTxnNonceTooBig
 		}
 	}
 
+	switch txn.Decoded.(type) {
+	case *StreamOpenTxn, *EscrowOpenTxn, *AnnounceTransferTxn:
+		// the stream, escrow and transfer IDs are derived from
+		// the account nonce, which is lane 0's.
+		if txn.Lane != 0 {
+			return fmt.Errorf("%T must use nonce lane 0, lane: %d", txn.Decoded, txn.Lane)
+		}
+	}
+
+	if !txn.MinerFeeTxn {
+		if err := t.checkEnabled(txn.Owner); err != nil {
+			return err
+		}
+
+		if err := t.checkBlocked(txn.Owner); err != nil {
+			return err
+		}
+
+		// the streamed tokens are settled lazily, when the
+		// sender or the receiver sends a txn.
+		t.setAudit(txn, AuditStreamSettle)
+		revert := t.settleStreams(acc)
+		// registered before the fee refund, so it runs after
+		// the refund, when the settled tokens are available.
+		defer func() {
+			if err != nil {
+				t.setAudit(txn, AuditStreamSettle)
+				revert()
+			}
+		}()
+	}
+
 	payFee := forceFee || t.proposer != nil
 
+	var feeToken TokenID
+	var fee uint64
+	var freeTxn bool
+	if payFee && t.useFreeTxn(acc) {
+		payFee = false
+		freeTxn = true
+	}
+
 	if payFee {
-		nativeCoin := acc.Balance(0)
-		if nativeCoin.Available < flatFee {
-			return errors.New("account don't have sufficient balance to pay fee")
+		feeToken, fee, err = t.txnFee(acc, txn.Decoded)
+		if err != nil {
+			if !hasWork(txn.Raw) {
+				return err
+			}
+
+			// the owner can not pay the fee, the txn is
+			// recorded feeless with its proof-of-work.
+			payFee = false
+			err = nil
 		}
+	}
 
-		nativeCoin.Available -= flatFee
-		acc.UpdateBalance(0, nativeCoin)
-		t.fee += flatFee
+	if payFee {
+		t.setAudit(txn, AuditFee)
+		b := acc.Balance(feeToken)
+		b.Available -= fee
+		acc.UpdateBalance(feeToken, b)
+		if feeToken == 0 {
+			t.fee += fee
+		} else {
+			t.convertedFees[feeToken] += fee
+		}
 	}
 	defer func() {
+		if freeTxn && err != nil {
+			t.refundFreeTxn(txn.Owner)
+		}
+
 		if payFee && err != nil {
-			nativeCoin := acc.Balance(0)
-			nativeCoin.Available += flatFee
-			acc.UpdateBalance(0, nativeCoin)
-			t.fee -= flatFee
+			t.setAudit(txn, AuditFeeRefund)
+			credit(acc, feeToken, fee)
+			if feeToken == 0 {
+				t.fee -= fee
+			} else {
+				t.convertedFees[feeToken] -= fee
+			}
 		}
 
 		if !txn.MinerFeeTxn && err == nil {
-			acc.IncrementNonce()
+			acc.IncrementLaneNonce(txn.Lane)
 		}
 	}()
 
+	t.setAudit(txn, txnReason(txn.Decoded))
 	switch tx := txn.Decoded.(type) {
 	case *PlaceOrderTxn:
 		if err := t.placeOrder(acc, tx, t.round); err != nil {
@@ -146,6 +358,228 @@ func (t *Transition) RecordImpl(txn *consensus.Txn, forceFee bool) (err error) {
 		if err := t.burnToken(acc, tx); err != nil {
 			return err
 		}
+	case *RecurringOrderTxn:
+		if err := t.recurringOrder(acc, tx); err != nil {
+			return err
+		}
+	case *MarketConfigTxn:
+		if err := t.configMarket(acc, tx); err != nil {
+			return err
+		}
+	case *SupplyTxn:
+		if err := t.supply(acc, tx); err != nil {
+			return err
+		}
+	case *MarginTransferTxn:
+		if err := t.marginTransfer(acc, tx); err != nil {
+			return err
+		}
+	case *MarginBorrowTxn:
+		if err := t.marginBorrow(acc, tx); err != nil {
+			return err
+		}
+	case *MarginOrderTxn:
+		if err := t.marginOrder(acc, tx); err != nil {
+			return err
+		}
+	case *MarginCancelOrderTxn:
+		if err := t.marginCancelOrder(acc, tx); err != nil {
+			return err
+		}
+	case *PerpTransferTxn:
+		if err := t.perpTransfer(acc, tx); err != nil {
+			return err
+		}
+	case *PerpOrderTxn:
+		if err := t.perpOrder(acc, tx); err != nil {
+			return err
+		}
+	case *PerpCancelOrderTxn:
+		if err := t.perpCancelOrder(acc, tx); err != nil {
+			return err
+		}
+	case *BridgeRegisterTxn:
+		if err := t.bridgeRegister(acc, tx); err != nil {
+			return err
+		}
+	case *BridgeMintTxn:
+		if err := t.bridgeMint(acc, tx); err != nil {
+			return err
+		}
+	case *BridgeWithdrawTxn:
+		if err := t.bridgeWithdraw(acc, tx); err != nil {
+			return err
+		}
+	case *BridgeAuthorizeTxn:
+		if err := t.bridgeAuthorize(acc, tx); err != nil {
+			return err
+		}
+	case *HTLCLockTxn:
+		if err := t.htlcLock(acc, tx); err != nil {
+			return err
+		}
+	case *HTLCClaimTxn:
+		if err := t.htlcClaim(acc, tx); err != nil {
+			return err
+		}
+	case *HTLCRefundTxn:
+		if err := t.htlcRefund(acc, tx); err != nil {
+			return err
+		}
+	case *IBCCreateClientTxn:
+		if err := t.ibcCreateClient(acc, tx); err != nil {
+			return err
+		}
+	case *IBCUpdateClientTxn:
+		if err := t.ibcUpdateClient(acc, tx); err != nil {
+			return err
+		}
+	case *IBCOpenChannelTxn:
+		if err := t.ibcOpenChannel(acc, tx); err != nil {
+			return err
+		}
+	case *IBCTransferTxn:
+		if err := t.ibcTransfer(acc, tx); err != nil {
+			return err
+		}
+	case *IBCRecvPacketTxn:
+		if err := t.ibcRecvPacket(acc, tx); err != nil {
+			return err
+		}
+	case *SetOraclesTxn:
+		if err := t.setOracles(acc, tx); err != nil {
+			return err
+		}
+	case *ReportPricesTxn:
+		if err := t.reportOraclePrices(acc, tx); err != nil {
+			return err
+		}
+	case *CreateStablecoinTxn:
+		if err := t.createStablecoin(acc, tx); err != nil {
+			return err
+		}
+	case *ConfigCDPTxn:
+		if err := t.configCDP(acc, tx); err != nil {
+			return err
+		}
+	case *CDPLockTxn:
+		if err := t.cdpLock(acc, tx); err != nil {
+			return err
+		}
+	case *CDPMintTxn:
+		if err := t.cdpMint(acc, tx); err != nil {
+			return err
+		}
+	case *SetFeeScheduleTxn:
+		if err := t.setFeeSchedule(acc, tx); err != nil {
+			return err
+		}
+	case *RegisterReferrerTxn:
+		if err := t.registerReferrer(acc, tx); err != nil {
+			return err
+		}
+	case *SetRoundIntervalTxn:
+		if err := t.setRoundInterval(acc, tx); err != nil {
+			return err
+		}
+	case *VerifyIssuerTxn:
+		if err := t.verifyIssuer(acc, tx); err != nil {
+			return err
+		}
+	case *DelistTxn:
+		if err := t.delist(acc, tx); err != nil {
+			return err
+		}
+	case *TokenWhitelistTxn:
+		if err := t.tokenWhitelist(acc, tx); err != nil {
+			return err
+		}
+	case *EscrowOpenTxn:
+		if err := t.escrowOpen(acc, tx); err != nil {
+			return err
+		}
+	case *EscrowDecideTxn:
+		if err := t.escrowDecide(acc, tx); err != nil {
+			return err
+		}
+	case *StreamOpenTxn:
+		if err := t.streamOpen(acc, tx); err != nil {
+			return err
+		}
+	case *StreamCancelTxn:
+		if err := t.streamCancel(acc, tx); err != nil {
+			return err
+		}
+	case *CancelAllOrdersTxn:
+		t.cancelAllOrders(acc, tx)
+	case *SetRiskLimitTxn:
+		if err := t.setRiskLimit(acc, tx); err != nil {
+			return err
+		}
+	case *SetGuardianTxn:
+		if err := t.setGuardian(acc, tx); err != nil {
+			return err
+		}
+	case *KillSwitchTxn:
+		if err := t.killSwitch(acc, tx); err != nil {
+			return err
+		}
+	case *SendToDepositTxn:
+		if err := t.sendToDeposit(acc, tx); err != nil {
+			return err
+		}
+	case *SetMMProgramTxn:
+		if err := t.setMMProgram(acc, tx); err != nil {
+			return err
+		}
+	case *RegisterMarketMakerTxn:
+		if err := t.registerMarketMaker(acc, tx); err != nil {
+			return err
+		}
+	case *BustTradeTxn:
+		if err := t.bustTrade(acc, tx); err != nil {
+			return err
+		}
+	case *ReduceOrderTxn:
+		if err := t.reduceOrder(acc, tx); err != nil {
+			return err
+		}
+	case *RegisterValidatorTxn:
+		if err := t.registerValidator(acc, tx); err != nil {
+			return err
+		}
+	case *SetTradingCalendarTxn:
+		if err := t.setTradingCalendar(acc, tx); err != nil {
+			return err
+		}
+	case *SetIndexTxn:
+		if err := t.setIndex(acc, tx); err != nil {
+			return err
+		}
+	case *SetAllowListTxn:
+		if err := t.setAllowList(acc, tx); err != nil {
+			return err
+		}
+	case *SetTransferThresholdTxn:
+		if err := t.setTransferThreshold(acc, tx); err != nil {
+			return err
+		}
+	case *AnnounceTransferTxn:
+		if err := t.announceTransfer(acc, tx); err != nil {
+			return err
+		}
+	case *ExecuteTransferTxn:
+		if err := t.executeTransfer(acc, tx); err != nil {
+			return err
+		}
+	case *VetoTransferTxn:
+		if err := t.vetoTransfer(acc, tx); err != nil {
+			return err
+		}
+	case *BlockAddressTxn:
+		if err := t.blockAddress(acc, tx); err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("unknown txn type: %T", txn.Decoded)
 	}
@@ -177,6 +611,7 @@ func (t *Transition) burnToken(acc *Account, txn *BurnTokenTxn) error {
 	info.TotalUnits -= txn.Quant
 	acc.UpdateBalance(txn.ID, balance)
 	t.state.UpdateToken(Token{ID: txn.ID, TokenInfo: info})
+	t.tokenCache.Update(txn.ID, info)
 	return nil
 }
 
@@ -212,6 +647,16 @@ func calcQuoteQuant(baseQuantUnit uint64, quoteDecimals uint8, priceQuantUnit ui
 	return result.Uint64()
 }
 
+// releasedQuote returns the quote quantity that a buy order at the
+// price releases when quant of its remaining quantity remain is
+// filled or refunded. The order always locks the quote quantity of
+// its remaining quantity, so the rounding of the partial fills, e.g.,
+// of a pro-rata allocation, never leaves a residue locked.
+func releasedQuote(remain, quant, price uint64, quoteInfo, baseInfo TokenInfo) uint64 {
+	locked := calcQuoteQuant(remain, quoteInfo.Decimals, price, OrderPriceDecimals, baseInfo.Decimals)
+	return locked - calcQuoteQuant(remain-quant, quoteInfo.Decimals, price, OrderPriceDecimals, baseInfo.Decimals)
+}
+
 func (t *Transition) cancelOrder(owner *Account, txn *CancelOrderTxn) error {
 	cancel, ok := owner.PendingOrder(txn.ID)
 	if !ok {
@@ -226,13 +671,60 @@ func (t *Transition) cancelOrder(owner *Account, txn *CancelOrderTxn) error {
 	return nil
 }
 
+func (t *Transition) reduceOrder(owner *Account, txn *ReduceOrderTxn) error {
+	order, ok := owner.PendingOrder(txn.ID)
+	if !ok {
+		return fmt.Errorf("can not find the order to reduce: %v", txn.ID)
+	}
+
+	if txn.Quant == 0 {
+		return errors.New("reduce order quantity is 0")
+	}
+
+	remain := order.Quant - order.Executed
+	if txn.Quant >= remain {
+		return fmt.Errorf("reduce quantity %d is not less than the remaining quantity %d, cancel the order instead", txn.Quant, remain)
+	}
+
+	book := t.getOrderBook(txn.ID.Market)
+	book.Reduce(txn.ID.ID, txn.Quant)
+	t.dirtyOrderBooks[txn.ID.Market] = true
+	t.refund(owner, order, txn.Quant, txn.ID.Market)
+	order.Quant -= txn.Quant
+	owner.UpdatePendingOrder(order)
+	return nil
+}
+
+func (t *Transition) cancelAllOrders(owner *Account, txn *CancelAllOrdersTxn) {
+	markets := make(map[MarketSymbol]bool)
+	for _, m := range txn.Markets {
+		markets[m] = true
+	}
+
+	for _, o := range owner.PendingOrders() {
+		if len(markets) > 0 && !markets[o.ID.Market] {
+			continue
+		}
+
+		err := t.cancelOrder(owner, &CancelOrderTxn{ID: o.ID})
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
 func (t *Transition) refundAfterCancel(owner *Account, cancel PendingOrder, market MarketSymbol) {
 	if cancel.Quant <= cancel.Executed {
 		panic(fmt.Errorf("pending order remain amount should be greater than 0, total: %d, executed: %d", cancel.Quant, cancel.Executed))
 	}
 
-	refund := cancel.Quant - cancel.Executed
-	if cancel.SellSide {
+	t.refund(owner, cancel, cancel.Quant-cancel.Executed, market)
+}
+
+// refund returns the balance locked by the refund quantity of the
+// order to the owner.
+func (t *Transition) refund(owner *Account, order PendingOrder, refund uint64, market MarketSymbol) {
+	if order.SellSide {
 		baseBalance := owner.Balance(market.Base)
 
 		if baseBalance.Pending < refund {
@@ -246,7 +738,7 @@ func (t *Transition) refundAfterCancel(owner *Account, cancel PendingOrder, mark
 		quoteBalance := owner.Balance(market.Quote)
 		quoteInfo := t.tokenCache.idToInfo[market.Quote]
 		baseInfo := t.tokenCache.idToInfo[market.Base]
-		pendingQuant := calcQuoteQuant(refund, quoteInfo.Decimals, cancel.Price, OrderPriceDecimals, baseInfo.Decimals)
+		pendingQuant := releasedQuote(order.Quant-order.Executed, refund, order.Price, quoteInfo, baseInfo)
 
 		if quoteBalance.Pending < pendingQuant {
 			panic(fmt.Errorf("pending balance smaller than refund, pending: %d, refund: %d", quoteBalance.Pending, pendingQuant))
@@ -268,21 +760,59 @@ type ExecutionReport struct {
 }
 
 func (t *Transition) placeOrder(owner *Account, txn *PlaceOrderTxn, round uint64) error {
+	if err := t.checkTradingSession(txn.Market, round); err != nil {
+		return err
+	}
+
+	if err := t.checkRiskLimit(owner, txn); err != nil {
+		return err
+	}
+
+	if err := t.checkPriceBand(txn); err != nil {
+		return err
+	}
+
+	addr := owner.PK().Addr()
+	id, quant := t.orderOutflow(txn)
+	if err := t.checkTransferThreshold(addr, id, quant); err != nil {
+		return err
+	}
+
+	if err := t.placeOrderImpl(owner, txn, round); err != nil {
+		return err
+	}
+
+	t.addOutflow(addr, id, quant)
+	return nil
+}
+
+// placeOrderImpl places the order without checking the owner's risk
+// limit and the market's price band, the liquidation orders are not
+// subject to them.
+func (t *Transition) placeOrderImpl(owner *Account, txn *PlaceOrderTxn, round uint64) error {
 	if !txn.Market.Valid() {
-		return fmt.Errorf("order's market is invalid: %v", txn.Market)
+		return txnErrorf(ErrCodeBadMarket, "order's market is invalid: %v", txn.Market)
+	}
+	if err := t.checkListed(txn.Market); err != nil {
+		return err
+	}
+	for _, id := range []TokenID{txn.Market.Base, txn.Market.Quote} {
+		if err := t.checkHolder(id, owner.PK().Addr()); err != nil {
+			return err
+		}
 	}
 	if txn.ExpireRound > 0 && round >= txn.ExpireRound {
-		return fmt.Errorf("order already expired, order expire round: %d, cur round: %d", txn.ExpireRound, round)
+		return txnErrorf(ErrCodeExpired, "order already expired, order expire round: %d, cur round: %d", txn.ExpireRound, round)
 	}
 
 	baseInfo := t.tokenCache.Info(txn.Market.Base)
 	if baseInfo == zeroInfo {
-		return fmt.Errorf("trying to place order on nonexistent token: %d", txn.Market.Base)
+		return txnErrorf(ErrCodeBadMarket, "trying to place order on nonexistent token: %d", txn.Market.Base)
 	}
 
 	quoteInfo := t.tokenCache.Info(txn.Market.Quote)
 	if quoteInfo == zeroInfo {
-		return fmt.Errorf("trying to place order on nonexistent token: %d", txn.Market.Quote)
+		return txnErrorf(ErrCodeBadMarket, "trying to place order on nonexistent token: %d", txn.Market.Quote)
 	}
 
 	if txn.SellSide {
@@ -292,7 +822,7 @@ func (t *Transition) placeOrder(owner *Account, txn *PlaceOrderTxn, round uint64
 
 		baseBalance := owner.Balance(txn.Market.Base)
 		if baseBalance.Available < txn.Quant {
-			return fmt.Errorf("sell failed: insufficient balance, quant: %d, available: %d", txn.Quant, baseBalance.Available)
+			return txnErrorf(ErrCodeInsufficientBalance, "sell failed: insufficient balance, quant: %d, available: %d", txn.Quant, baseBalance.Available)
 		}
 
 		baseBalance.Available -= txn.Quant
@@ -310,7 +840,7 @@ func (t *Transition) placeOrder(owner *Account, txn *PlaceOrderTxn, round uint64
 
 		quoteBalance := owner.Balance(txn.Market.Quote)
 		if quoteBalance.Available < pendingQuant {
-			return fmt.Errorf("buy failed, insufficient balance, required: %d, available %d", pendingQuant, quoteBalance.Available)
+			return txnErrorf(ErrCodeInsufficientBalance, "buy failed, insufficient balance, required: %d, available %d", pendingQuant, quoteBalance.Available)
 		}
 
 		quoteBalance.Available -= pendingQuant
@@ -326,8 +856,20 @@ func (t *Transition) placeOrder(owner *Account, txn *PlaceOrderTxn, round uint64
 		ExpireRound: txn.ExpireRound,
 	}
 
+	cfg := t.state.MarketConfig(txn.Market)
 	book := t.getOrderBook(txn.Market)
-	orderID, executions := book.Limit(order)
+	book.proRata = cfg.ProRata
+	var orderID uint64
+	var executions []orderExecution
+	if end, ok := t.openingAuctionEnd(txn.Market); ok {
+		orderID = book.Add(order)
+		t.state.AddAuctionMarket(end, txn.Market)
+	} else if cfg.BatchAuction {
+		orderID = book.Add(order)
+		t.state.AddAuctionMarket(round, txn.Market)
+	} else {
+		orderID, executions = book.Limit(order)
+	}
 	t.dirtyOrderBooks[txn.Market] = true
 	id := OrderID{ID: orderID, Market: txn.Market}
 	pendingOrder := PendingOrder{
@@ -339,81 +881,261 @@ func (t *Transition) placeOrder(owner *Account, txn *PlaceOrderTxn, round uint64
 		t.expirations[order.ExpireRound] = append(t.expirations[order.ExpireRound], orderExpiration{ID: id, Owner: owner.PK().Addr()})
 	}
 
-	if len(executions) > 0 {
-		for _, exec := range executions {
-			acc := t.state.Account(exec.Owner)
-			orderID := OrderID{ID: exec.ID, Market: txn.Market}
-			report := ExecutionReport{
-				Round:      round,
-				ID:         orderID,
-				SellSide:   exec.SellSide,
-				TradePrice: exec.Price,
-				Quant:      exec.Quant,
-			}
-			acc.AddExecutionReport(report)
-			executedOrder, ok := acc.PendingOrder(orderID)
-			if !ok {
-				panic(fmt.Errorf("impossible: can not find matched order %d, market: %v, executed order: %v", exec.ID, txn.Market, exec))
+	t.settle(txn.Market, executions, round, baseInfo, quoteInfo)
+	return nil
+}
+
+// settle updates the accounts of the executed orders.
+func (t *Transition) settle(market MarketSymbol, executions []orderExecution, round uint64, baseInfo, quoteInfo TokenInfo) {
+	executed := t.executed[market]
+	if executed == nil {
+		executed = make(map[uint64]uint64)
+		t.executed[market] = executed
+	}
+
+	for i, exec := range executions {
+		executed[exec.ID] += exec.Quant
+		if exec.Taker && i+1 < len(executions) {
+			// the taker execution is followed by its
+			// maker's.
+			quoteQuant := calcQuoteQuant(exec.Quant, quoteInfo.Decimals, exec.Price, OrderPriceDecimals, baseInfo.Decimals)
+			t.addMatch(market, exec, executions[i+1], quoteQuant)
+		}
+		acc := t.state.Account(exec.Owner)
+		orderID := OrderID{ID: exec.ID, Market: market}
+		report := ExecutionReport{
+			Round:      round,
+			ID:         orderID,
+			SellSide:   exec.SellSide,
+			TradePrice: exec.Price,
+			Quant:      exec.Quant,
+		}
+		acc.AddExecutionReport(report)
+		if exec.SellSide {
+			// every trade has exactly one sell side
+			// execution.
+			t.trades[market] = append(t.trades[market], PriceSample{Round: round, Price: exec.Price, Volume: exec.Quant})
+		}
+
+		executedOrder, ok := acc.PendingOrder(orderID)
+		if !ok {
+			panic(fmt.Errorf("impossible: can not find matched order %d, market: %v, executed order: %v", exec.ID, market, exec))
+		}
+
+		remain := executedOrder.Quant - executedOrder.Executed
+		executedOrder.Executed += exec.Quant
+		if executedOrder.Executed == executedOrder.Quant {
+			acc.RemovePendingOrder(orderID)
+			t.filledOrders = append(t.filledOrders, executedOrder)
+		} else {
+			acc.UpdatePendingOrder(executedOrder)
+		}
+
+		baseBalance := acc.Balance(market.Base)
+		quoteBalance := acc.Balance(market.Quote)
+		if exec.SellSide {
+			if baseBalance.Pending < exec.Quant {
+				panic(fmt.Errorf("insufficient pending balance, owner: %v, pending %d, executed: %d, sell side, taker: %t", exec.Owner, baseBalance.Pending, exec.Quant, exec.Taker))
 			}
 
-			executedOrder.Executed += exec.Quant
-			if executedOrder.Executed == executedOrder.Quant {
-				acc.RemovePendingOrder(orderID)
-				t.filledOrders = append(t.filledOrders, executedOrder)
-			} else {
-				acc.UpdatePendingOrder(executedOrder)
+			baseBalance.Pending -= exec.Quant
+			recvQuant := calcQuoteQuant(exec.Quant, quoteInfo.Decimals, exec.Price, OrderPriceDecimals, baseInfo.Decimals)
+			quoteBalance.Available += recvQuant
+			acc.UpdateBalance(market.Base, baseBalance)
+			acc.UpdateBalance(market.Quote, quoteBalance)
+		} else {
+			recvQuant := exec.Quant
+			pendingQuant := releasedQuote(remain, exec.Quant, executedOrder.Price, quoteInfo, baseInfo)
+			givenQuant := calcQuoteQuant(exec.Quant, quoteInfo.Decimals, exec.Price, OrderPriceDecimals, baseInfo.Decimals)
+
+			if quoteBalance.Pending < pendingQuant {
+				panic(fmt.Errorf("insufficient pending balance, owner: %v, pending %d, executed: %d, buy side, taker: %t", exec.Owner, quoteBalance.Pending, exec.Quant, exec.Taker))
 			}
 
-			baseBalance := acc.Balance(txn.Market.Base)
-			quoteBalance := acc.Balance(txn.Market.Quote)
-			if exec.SellSide {
-				if baseBalance.Pending < exec.Quant {
-					panic(fmt.Errorf("insufficient pending balance, owner: %v, pending %d, executed: %d, sell side, taker: %t", exec.Owner, baseBalance.Pending, exec.Quant, exec.Taker))
-				}
+			quoteBalance.Pending -= pendingQuant
+			quoteBalance.Available += pendingQuant
+			quoteBalance.Available -= givenQuant
+			baseBalance.Available += recvQuant
+			acc.UpdateBalance(market.Base, baseBalance)
+			acc.UpdateBalance(market.Quote, quoteBalance)
+		}
+	}
 
-				baseBalance.Pending -= exec.Quant
-				recvQuant := calcQuoteQuant(exec.Quant, quoteInfo.Decimals, exec.Price, OrderPriceDecimals, baseInfo.Decimals)
-				quoteBalance.Available += recvQuant
-				acc.UpdateBalance(txn.Market.Base, baseBalance)
-				acc.UpdateBalance(txn.Market.Quote, quoteBalance)
-			} else {
-				recvQuant := exec.Quant
-				pendingQuant := calcQuoteQuant(exec.Quant, quoteInfo.Decimals, executedOrder.Price, OrderPriceDecimals, baseInfo.Decimals)
-				givenQuant := calcQuoteQuant(exec.Quant, quoteInfo.Decimals, exec.Price, OrderPriceDecimals, baseInfo.Decimals)
+	t.chargeFees(market, executions, baseInfo, quoteInfo)
+}
 
-				if quoteBalance.Pending < pendingQuant {
-					panic(fmt.Errorf("insufficient pending balance, owner: %v, pending %d, executed: %d, buy side, taker: %t", exec.Owner, quoteBalance.Pending, exec.Quant, exec.Taker))
-				}
+// openingAuctionEnd returns the last round of the market's opening
+// auction phase, and whether the market is currently in the phase.
+func (t *Transition) openingAuctionEnd(m MarketSymbol) (uint64, bool) {
+	var listRound uint64
+	found := false
+	for _, id := range []TokenID{m.Base, m.Quote} {
+		r, ok := t.state.TokenListRound(id)
+		if !ok {
+			continue
+		}
 
-				quoteBalance.Pending -= pendingQuant
-				quoteBalance.Available += pendingQuant
-				quoteBalance.Available -= givenQuant
-				baseBalance.Available += recvQuant
-				acc.UpdateBalance(txn.Market.Base, baseBalance)
-				acc.UpdateBalance(txn.Market.Quote, quoteBalance)
-			}
+		found = true
+		if r > listRound {
+			listRound = r
+		}
+	}
+
+	if !found {
+		return 0, false
+	}
+
+	end := listRound + openingAuctionRounds - 1
+	return end, t.round <= end
+}
+
+// runAuctions uncrosses the order books of the markets whose
+// auction ends at the current round. It's either the last round of
+// a market's opening auction, or a market in the batch auction mode.
+func (t *Transition) runAuctions() {
+	markets := t.state.AuctionMarkets(t.round)
+	if len(markets) == 0 {
+		return
+	}
+
+	t.state.RemoveAuctionMarkets(t.round)
+	for _, m := range markets {
+		if err := t.checkTradingSession(m, t.round); err != nil {
+			// the auction of a closed market runs when it
+			// opens.
+			t.state.AddAuctionMarket(t.round+1, m)
+			continue
 		}
+
+		book := t.getOrderBook(m)
+		book.proRata = t.state.MarketConfig(m).ProRata
+		price, executions := book.Auction()
+		t.dirtyOrderBooks[m] = true
+		t.settle(m, executions, t.round, t.tokenCache.Info(m.Base), t.tokenCache.Info(m.Quote))
+		t.logger().Debug("auction finished", "market", m, "price", price, "executions", len(executions))
+	}
+}
+
+func (t *Transition) updateRefPrices() {
+	for _, m := range tradedMarkets(t.trades) {
+		p, _ := t.state.RefPrice(m)
+		p.update(t.round, t.trades[m])
+		t.state.UpdateRefPrice(m, p)
+	}
+}
+
+func (t *Transition) configMarket(owner *Account, txn *MarketConfigTxn) error {
+	if !txn.Market.Valid() {
+		return txnErrorf(ErrCodeBadMarket, "market is invalid: %v", txn.Market)
+	}
+
+	if t.tokenCache.Info(txn.Market.Quote) == zeroInfo {
+		return fmt.Errorf("trying to config market of nonexistent token: %d", txn.Market.Quote)
+	}
+
+	issuer, ok := t.state.TokenIssuer(txn.Market.Base)
+	if !ok || issuer != owner.PK().Addr() {
+		return fmt.Errorf("only the issuer of token %d can config the market", txn.Market.Base)
+	}
+
+	t.state.UpdateMarketConfig(txn.Market, txn.MarketConfigInfo)
+	return nil
+}
+
+func (t *Transition) recurringOrder(owner *Account, txn *RecurringOrderTxn) error {
+	if !txn.Market.Valid() {
+		return txnErrorf(ErrCodeBadMarket, "recurring order's market is invalid: %v", txn.Market)
+	}
+
+	if txn.Interval == 0 {
+		return errors.New("recurring order interval should not be 0")
+	}
+
+	if txn.Count == 0 {
+		return errors.New("recurring order count should not be 0")
 	}
+
+	if txn.Quant == 0 {
+		return errors.New("recurring order quantity should not be 0")
+	}
+
+	if t.tokenCache.Info(txn.Market.Base) == zeroInfo {
+		return fmt.Errorf("trying to place recurring order on nonexistent token: %d", txn.Market.Base)
+	}
+
+	if t.tokenCache.Info(txn.Market.Quote) == zeroInfo {
+		return fmt.Errorf("trying to place recurring order on nonexistent token: %d", txn.Market.Quote)
+	}
+
+	if txn.Count > maxRecurringOrderCount {
+		return fmt.Errorf("recurring order count %d is greater than %d", txn.Count, maxRecurringOrderCount)
+	}
+
+	if txn.Interval > maxRecurringOrderInterval {
+		return fmt.Errorf("recurring order interval %d is greater than %d", txn.Interval, maxRecurringOrderInterval)
+	}
+
+	// the bounds keep the product from overflowing.
+	if txn.Interval*txn.Count > math.MaxUint64-t.round {
+		return errors.New("recurring order schedule overflows the round number")
+	}
+
+	addr := owner.PK().Addr()
+	n := t.state.RecurringCount(addr)
+	if n >= maxRecurringOrders {
+		return fmt.Errorf("account already has %d recurring orders, the max is %d", n, maxRecurringOrders)
+	}
+
+	t.state.UpdateRecurringCount(addr, n+1)
+	round := t.round + txn.Interval
+	t.recurringOrders[round] = append(t.recurringOrders[round], recurringOrder{
+		Owner:             addr,
+		Remaining:         txn.Count,
+		RecurringOrderTxn: *txn,
+	})
 	return nil
 }
 
+// endRecurringOrder removes the finished or dropped schedule from the
+// owner's count of recurring orders.
+func (t *Transition) endRecurringOrder(owner consensus.Addr) {
+	if n := t.state.RecurringCount(owner); n > 0 {
+		t.state.UpdateRecurringCount(owner, n-1)
+	}
+}
+
 func (t *Transition) issueToken(owner *Account, txn *IssueTokenTxn) error {
-	if t.tokenCache.Exists(txn.Info.Symbol) {
-		return fmt.Errorf("token symbol %v already exists", txn.Info.Symbol)
+	if err := ValidateSymbol(txn.Info.Symbol); err != nil {
+		return err
+	}
+
+	id, err := t.createToken(txn.Info)
+	if err != nil {
+		return err
+	}
+
+	t.state.UpdateTokenIssuer(id, owner.PK().Addr())
+	owner.UpdateBalance(id, Balance{Available: txn.Info.TotalUnits})
+	return nil
+}
+
+func (t *Transition) createToken(info TokenInfo) (TokenID, error) {
+	if t.tokenCache.Exists(info.Symbol) {
+		return 0, fmt.Errorf("token symbol %v already exists", info.Symbol)
 	}
 
 	for _, v := range t.tokenCreations {
-		if strings.ToUpper(string(txn.Info.Symbol)) == strings.ToUpper(string(v.Symbol)) {
-			return fmt.Errorf("token symbol %v already exists in the current transition", txn.Info.Symbol)
+		if SymbolConflicts(info.Symbol, v.Symbol) {
+			return 0, fmt.Errorf("token symbol %v already exists in the current transition", info.Symbol)
 		}
 	}
 
 	id := TokenID(t.tokenCache.Size() + len(t.tokenCreations))
-	token := Token{ID: id, TokenInfo: txn.Info}
+	token := Token{ID: id, TokenInfo: info}
 	t.tokenCreations = append(t.tokenCreations, token)
 	t.state.UpdateToken(token)
-	owner.UpdateBalance(id, Balance{Available: txn.Info.TotalUnits})
-	return nil
+	t.state.UpdateTokenListRound(id, t.round)
+	return id, nil
 }
 
 func (t *Transition) sendToken(owner *Account, txn *SendTokenTxn) error {
@@ -423,10 +1145,28 @@ func (t *Transition) sendToken(owner *Account, txn *SendTokenTxn) error {
 
 	b := owner.Balance(txn.TokenID)
 	if b.Available < txn.Quant {
-		return fmt.Errorf("insufficient available token balance, tokenID: %v, quant: %d, available: %d", txn.TokenID, txn.Quant, b.Available)
+		return txnErrorf(ErrCodeInsufficientBalance, "insufficient available token balance, tokenID: %v, quant: %d, available: %d", txn.TokenID, txn.Quant, b.Available)
 	}
 
 	toAddr := txn.To.Addr()
+	for _, addr := range []consensus.Addr{owner.PK().Addr(), toAddr} {
+		if err := t.checkHolder(txn.TokenID, addr); err != nil {
+			return err
+		}
+	}
+
+	if err := t.checkAllowList(owner.PK().Addr(), toAddr); err != nil {
+		return err
+	}
+
+	if err := t.checkTransferThreshold(owner.PK().Addr(), txn.TokenID, txn.Quant); err != nil {
+		return err
+	}
+
+	if err := t.checkBlocked(toAddr); err != nil {
+		return err
+	}
+
 	toAcc := t.state.Account(toAddr)
 	if toAcc == nil {
 		toAcc = t.state.NewAccount(txn.To)
@@ -434,6 +1174,7 @@ func (t *Transition) sendToken(owner *Account, txn *SendTokenTxn) error {
 
 	b.Available -= txn.Quant
 	owner.UpdateBalance(txn.TokenID, b)
+	t.addOutflow(owner.PK().Addr(), txn.TokenID, txn.Quant)
 	toAccBalance := toAcc.Balance(txn.TokenID)
 	toAccBalance.Available += txn.Quant
 	toAcc.UpdateBalance(txn.TokenID, toAccBalance)
@@ -465,21 +1206,67 @@ func (t *Transition) giveMinerFee(txn MinerFeeTxn) {
 	nativeCoin := acc.Balance(0)
 	nativeCoin.Available += txn.Fee
 	acc.UpdateBalance(0, nativeCoin)
+	for _, f := range txn.Converted {
+		credit(acc, f.ID, f.Quant)
+	}
+}
+
+// collectedFees returns the fees collected from the recorded txns,
+// the converted fees are sorted by the token ID.
+func (t *Transition) collectedFees() (uint64, []ConvertedFee) {
+	var converted []ConvertedFee
+	for id, quant := range t.convertedFees {
+		if quant > 0 {
+			converted = append(converted, ConvertedFee{ID: id, Quant: quant})
+		}
+	}
+
+	sort.Slice(converted, func(i, j int) bool {
+		return converted[i].ID < converted[j].ID
+	})
+	return t.fee, converted
+}
+
+// checkMinerFee returns an error if the miner fee txn does not pay
+// exactly the fees collected from the block's txns.
+func (t *Transition) checkMinerFee(txn *MinerFeeTxn) error {
+	fee, converted := t.collectedFees()
+	if fee == 0 && len(converted) == 0 {
+		return errors.New("miner fee txn in a block that collected no fee")
+	}
+
+	if txn.Fee != fee {
+		return fmt.Errorf("miner fee %d does not match the collected fee %d", txn.Fee, fee)
+	}
+
+	if len(txn.Converted) != len(converted) {
+		return fmt.Errorf("miner fee has %d converted fees, collected: %d", len(txn.Converted), len(converted))
+	}
+
+	for i, c := range converted {
+		if txn.Converted[i] != c {
+			return fmt.Errorf("miner converted fee %v does not match the collected fee %v", txn.Converted[i], c)
+		}
+	}
+
+	return nil
 }
 
 func (t *Transition) appendFeeTxn() {
 	if t.proposer != nil {
-		if t.fee == 0 {
+		fee, converted := t.collectedFees()
+		if fee == 0 && len(converted) == 0 {
 			return
 		}
 
 		feeTxn := MinerFeeTxn{
-			Miner: t.proposer,
-			Fee:   t.fee,
+			Miner:     t.proposer,
+			Fee:       fee,
+			Converted: converted,
 		}
 		txn := Txn{
 			T:    MinerFee,
-			Data: gobEncode(feeTxn),
+			Data: rlpEncode(feeTxn),
 		}
 
 		b, err := rlp.EncodeToBytes(txn)
@@ -488,14 +1275,65 @@ func (t *Transition) appendFeeTxn() {
 		}
 
 		t.fee = 0
+		t.convertedFees = make(map[TokenID]uint64)
 		t.txns = append(t.txns, b)
+		t.setAudit(&consensus.Txn{Raw: b}, AuditMinerFee)
 		t.giveMinerFee(feeTxn)
 	}
 }
 
 func (t *Transition) finalizeState() {
 	if !t.finalized {
+		end := consensus.StartSpan("finalize_state", "round", t.round)
+		defer end(nil)
 		t.appendFeeTxn()
+		// must be called before t.liquidateCDPs, since the
+		// collateral is valued at the oracle prices.
+		t.medianizeOraclePrices()
+		// must be called before t.liquidateMargins, since
+		// the interest increases the debts.
+		t.setAudit(nil, "accrue_interest")
+		t.accrueInterest()
+		// must be called before t.runAuctions, since the
+		// liquidation orders could join the auction.
+		t.setAudit(nil, "liquidate_margins")
+		t.liquidateMargins()
+		// must be called before t.runAuctions, since the
+		// liquidation orders could join the auction.
+		t.setAudit(nil, "liquidate_cdps")
+		t.liquidateCDPs()
+		// must be called before t.liquidatePerps, since the
+		// payments change the collateral.
+		t.setAudit(nil, "pay_funding")
+		t.payFunding()
+		t.setAudit(nil, "liquidate_perps")
+		t.liquidatePerps()
+		// must be called before
+		// t.removeFilledOrderFromExpiration, since the
+		// child orders could be filled or have expirations.
+		t.setAudit(nil, "recurring_orders")
+		t.placeRecurringOrders()
+		// must be called after t.placeRecurringOrders, since
+		// the child orders could join the auction.
+		t.setAudit(nil, "auctions")
+		t.runAuctions()
+		// must be called after t.runAuctions, so the
+		// auction at the retire round still trades, and
+		// before t.removeFilledOrderFromExpiration, since the
+		// cancelled orders could have expirations.
+		t.setAudit(nil, "retire_markets")
+		t.retireMarkets()
+		// must be called after t.runAuctions, since the
+		// auctions could trade.
+		t.updateRefPrices()
+		// must be called after t.updateRefPrices, the index
+		// values are of the updated reference prices.
+		t.updateIndexes()
+		t.updatePerpRefPrices()
+		t.state.setTrades(t.round, t.trades)
+		t.state.setExecuted(t.executed)
+		t.state.setMatches(t.matches)
+		t.recordTrades()
 		t.removeFilledOrderFromExpiration()
 		// must be called after
 		// t.removeFilledOrderFromExpiration
@@ -503,43 +1341,145 @@ func (t *Transition) finalizeState() {
 		// must be called after t.recordOrderExpirations,
 		// since current round may add expiring orders for the
 		// next round.
+		t.setAudit(nil, "expire_orders")
 		t.expireOrders()
+		// must be called after t.expireOrders, the quotes are
+		// measured on the order books at the end of the
+		// round.
+		t.setAudit(nil, "market_maker_rewards")
+		t.measureMarketMakers()
 		// must be called after t.expireOrders, since it could
 		// make order book dirty.
 		t.saveDirtyOrderBooks()
+		t.savePerpBooks()
+		t.setAudit(nil, "release_tokens")
 		t.releaseTokens()
+		// the escrowed tokens are refunded by
+		// t.releaseTokens.
+		t.expireEscrows()
+		t.setAudit(nil, "ibc_packets")
+		t.commitIBCPackets()
 		t.state.CommitCache()
 		t.finalized = true
 	}
 }
 
+// logger returns the logger tagged with the round, and the hash of
+// the txn being recorded if any. The tags are the same on all the
+// nodes, so the log lines of a txn can be traced across the nodes.
+func (t *Transition) logger() log.Logger {
+	if t.txn == (consensus.Hash{}) {
+		return log.New("round", t.round)
+	}
+
+	return log.New("round", t.round, "txn", t.txn)
+}
+
+func (t *Transition) placeRecurringOrders() {
+	// place the child orders that are due this round
+	due := t.state.RecurringOrders(t.round)
+	if len(due) > 0 {
+		t.state.RemoveRecurringOrders(t.round)
+	}
+
+	for _, o := range due {
+		acc := t.state.Account(o.Owner)
+		if acc == nil {
+			t.logger().Error("can not find recurring order owner", "owner", o.Owner)
+			t.endRecurringOrder(o.Owner)
+			continue
+		}
+
+		// the schedule of a blocked owner is dropped.
+		if err := t.checkBlocked(o.Owner); err != nil {
+			t.logger().Warn("dropped recurring order", "owner", o.Owner, "market", o.Market, "err", err)
+			t.endRecurringOrder(o.Owner)
+			continue
+		}
+
+		child := PlaceOrderTxn{
+			SellSide: o.SellSide,
+			Quant:    o.Quant,
+			Price:    o.Price,
+			Market:   o.Market,
+		}
+		if o.ExpireAfter > 0 {
+			child.ExpireRound = t.round + o.ExpireAfter
+		}
+
+		// the schedule is dropped when a child order can not be
+		// placed (e.g., due to insufficient balance), so that
+		// a schedule the owner can not pay for does not cost
+		// the work of a placement every round.
+		err := t.placeOrder(acc, &child, t.round)
+		if err != nil {
+			t.logger().Warn("dropped recurring order", "owner", o.Owner, "market", o.Market, "err", err)
+			t.endRecurringOrder(o.Owner)
+			continue
+		}
+
+		o.Remaining--
+		if o.Remaining == 0 {
+			t.endRecurringOrder(o.Owner)
+			continue
+		}
+
+		round := t.round + o.Interval
+		t.recurringOrders[round] = append(t.recurringOrders[round], o)
+	}
+
+	rounds := make([]uint64, 0, len(t.recurringOrders))
+	for round := range t.recurringOrders {
+		rounds = append(rounds, round)
+	}
+	sortRounds(rounds)
+
+	for _, round := range rounds {
+		t.state.AddRecurringOrders(round, t.recurringOrders[round])
+	}
+}
+
 func (t *Transition) recordOrderExpirations() {
-	for expireRound, ids := range t.expirations {
-		t.state.AddOrderExpirations(expireRound, ids)
+	rounds := make([]uint64, 0, len(t.expirations))
+	for round := range t.expirations {
+		rounds = append(rounds, round)
+	}
+	sortRounds(rounds)
+
+	for _, round := range rounds {
+		t.state.AddOrderExpirations(round, t.expirations[round])
 	}
 }
 
 func (t *Transition) saveDirtyOrderBooks() {
-	for m, b := range t.orderBooks {
+	var digests []BookDigest
+	for _, m := range bookMarkets(t.orderBooks) {
 		if t.dirtyOrderBooks[m] {
-			t.state.saveOrderBook(m, b)
+			d := t.state.saveOrderBook(m, t.orderBooks[m])
+			digests = append(digests, BookDigest{Market: m, Digest: d})
 		}
 	}
+	t.state.UpdateBookDigests(digests)
 }
 
 func (t *Transition) removeFilledOrderFromExpiration() {
-	rounds := make(map[uint64]int)
+	toRemove := make(map[uint64]int)
 	filled := make(map[OrderID]bool)
+	var rounds []uint64
 	for _, o := range t.filledOrders {
 		if o.ExpireRound == 0 {
 			continue
 		}
 
 		filled[o.ID] = true
-		rounds[o.ExpireRound]++
+		if toRemove[o.ExpireRound] == 0 {
+			rounds = append(rounds, o.ExpireRound)
+		}
+		toRemove[o.ExpireRound]++
 	}
+	sortRounds(rounds)
 
-	for round, toRemove := range rounds {
+	for _, round := range rounds {
 		// remove filled order's expiration from the
 		// to-be-added expirations of this round.
 		expirations := t.expirations[round]
@@ -551,7 +1491,7 @@ func (t *Transition) removeFilledOrderFromExpiration() {
 		}
 		t.expirations[round] = newExpirations
 		removed := len(newExpirations) - len(expirations)
-		if removed == toRemove {
+		if removed == toRemove[round] {
 			continue
 		}
 
@@ -575,7 +1515,9 @@ func (t *Transition) releaseTokens() {
 		b := acc.Balance(token.TokenID)
 		removeIdx := -1
 		for i, f := range b.Frozen {
-			if f.Quant == token.Quant {
+			// the escrows freeze the tokens too, match
+			// the round as well.
+			if f.AvailableRound == t.round+1 && f.Quant == token.Quant {
 				removeIdx = i
 				break
 			}
@@ -603,7 +1545,7 @@ func (t *Transition) expireOrders() {
 
 		order, ok := acc.PendingOrder(o.ID)
 		if !ok {
-			log.Error("can not find expiring order", "order", o.ID)
+			t.logger().Error("can not find expiring order", "order", o.ID)
 			continue
 		}
 
@@ -624,7 +1566,7 @@ func (t *Transition) freezeToken(acc *Account, txn *FreezeTokenTxn) error {
 	b := acc.Balance(txn.TokenID)
 
 	if b.Available < txn.Quant {
-		return fmt.Errorf("insufficient available token balance, token id: %v, quantity: %d, available: %d", txn.TokenID, txn.Quant, b.Available)
+		return txnErrorf(ErrCodeInsufficientBalance, "insufficient available token balance, token id: %v, quantity: %d, available: %d", txn.TokenID, txn.Quant, b.Available)
 	}
 
 	frozen := Frozen{
@@ -638,6 +1580,9 @@ func (t *Transition) freezeToken(acc *Account, txn *FreezeTokenTxn) error {
 	return nil
 }
 
+// StateHash returns the state root hash after the transition. The
+// transition is finalized only once, and only the trie paths of the
+// accounts and the entries that it changed are rehashed.
 func (t *Transition) StateHash() consensus.Hash {
 	t.finalizeState()
 	return t.state.Hash()
@@ -648,6 +1593,10 @@ func (t *Transition) Commit() consensus.State {
 	for _, v := range t.tokenCreations {
 		t.tokenCache.Update(v.ID, v.TokenInfo)
 	}
+	// the token cache is consistent with the committed trie,
+	// the transitions on the new state share it.
+	t.state.setTokenCache(t.tokenCache)
+	t.tokenCache = t.tokenCache.fork()
 
 	return t.state
 }
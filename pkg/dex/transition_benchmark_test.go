@@ -1,8 +1,10 @@
 package dex
 
 import (
+	"fmt"
 	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/helinwang/dex/pkg/consensus"
@@ -16,58 +18,220 @@ func (m *myPKer) PK(addr consensus.Addr) PK {
 	return m.m[addr]
 }
 
-func genStateTxns(p *myPKer) (consensus.State, []byte) {
-	const (
-		accountCount = 10000
-		orderCount   = 10000
-	)
+var benchmarkSeed = consensus.Rand{1}
 
-	accountSKs := make([]SK, accountCount)
-	accountPKs := make([]PK, accountCount)
-	for i := range accountSKs {
-		pk, sk := RandKeyPair()
-		accountSKs[i] = sk
-		accountPKs[i] = pk
-		p.m[pk.Addr()] = pk
+// benchmarkAccountCount is the number of the accounts placing the
+// orders in the benchmarks.
+const benchmarkAccountCount = 1000
+
+// benchmarkPriceTick is the price step of the benchmark orders,
+// 0.001.
+const benchmarkPriceTick = 100000
+
+type benchmarkAccounts struct {
+	pks    []PK
+	sks    []SK
+	nonces []uint64
+	// r is seeded, the benchmarks replay the same workload in
+	// every run. Only the keys are random.
+	r *rand.Rand
+}
+
+func newBenchmarkAccounts(p *myPKer) *benchmarkAccounts {
+	a := &benchmarkAccounts{
+		pks:    make([]PK, benchmarkAccountCount),
+		sks:    make([]SK, benchmarkAccountCount),
+		nonces: make([]uint64, benchmarkAccountCount),
+		r:      rand.New(rand.NewSource(1)),
+	}
+	for i := range a.sks {
+		a.pks[i], a.sks[i] = RandKeyPair()
+		p.m[a.pks[i].Addr()] = a.pks[i]
+	}
+	return a
+}
+
+// block returns the serialized block of the orders in the canonical
+// order, each order is placed by a random account.
+func (a *benchmarkAccounts) block(p *myPKer, orders []PlaceOrderTxn) []byte {
+	txns := make([]*consensus.Txn, len(orders))
+	for i, o := range orders {
+		idx := a.r.Intn(len(a.sks))
+		txn, err := parseTxn(MakePlaceOrderTxn(a.sks[idx], a.pks[idx].Addr(), o, a.nonces[idx]), p)
+		if err != nil {
+			panic(err)
+		}
+		a.nonces[idx]++
+		txns[i] = txn
 	}
 
+	consensus.SortTxns(txns, benchmarkSeed)
+	raw := make([][]byte, len(txns))
+	for i := range txns {
+		raw[i] = txns[i].Raw
+	}
+
+	body, err := rlp.EncodeToBytes(raw)
+	if err != nil {
+		panic(err)
+	}
+	return body
+}
+
+// randOrder returns a random order of the price around 1.0, the
+// orders cross each other.
+func (a *benchmarkAccounts) randOrder() PlaceOrderTxn {
+	return PlaceOrderTxn{
+		SellSide: a.r.Intn(2) == 0,
+		Quant:    uint64(a.r.Intn(100) + 100000),
+		Price:    uint64(a.r.Intn(10)+1000) * benchmarkPriceTick,
+		Market:   MarketSymbol{Base: 0, Quote: 1},
+	}
+}
+
+// restingOrder returns the i-th order of a book of the depth, the
+// resting orders do not cross each other but are reachable by the
+// random orders.
+func (a *benchmarkAccounts) restingOrder(i int) PlaceOrderTxn {
+	o := PlaceOrderTxn{
+		Quant:  uint64(a.r.Intn(100) + 100000),
+		Market: MarketSymbol{Base: 0, Quote: 1},
+	}
+	if i%2 == 0 {
+		o.SellSide = true
+		o.Price = uint64(1005+i%100) * benchmarkPriceTick
+	} else {
+		o.Price = uint64(1004-i%100) * benchmarkPriceTick
+	}
+	return o
+}
+
+// genStateTxns returns a state whose order book is of the depth,
+// and a block of the random orders to replay on the state.
+func genStateTxns(p *myPKer, depth, orderCount int) (consensus.State, []byte) {
+	a := newBenchmarkAccounts(p)
 	var BTCInfo = TokenInfo{
 		Symbol:     "BTC",
 		Decimals:   8,
 		TotalUnits: 200000000 * 100000000,
 	}
-	state := CreateGenesisState(accountPKs, []TokenInfo{BTCInfo})
-	var txns [][]byte
-	for i := 0; i < orderCount; i++ {
-		idx := rand.Intn(len(accountSKs))
-		sk := accountSKs[idx]
-		pk := accountPKs[idx]
-		t := PlaceOrderTxn{
-			SellSide: rand.Intn(2) == 0,
-			Quant:    uint64(rand.Intn(100) + 100000),
-			Price:    uint64(rand.Intn(10) + 1000),
-			Market:   MarketSymbol{Base: 0, Quote: 1},
+	var state consensus.State = CreateGenesisState(a.pks, []TokenInfo{BTCInfo})
+	if depth > 0 {
+		orders := make([]PlaceOrderTxn, depth)
+		for i := range orders {
+			orders[i] = a.restingOrder(i)
 		}
-		txns = append(txns, MakePlaceOrderTxn(sk, pk.Addr(), t, 0))
+
+		var err error
+		state, _, err = state.CommitTxns(a.block(p, orders), NewTxnPool(p), 1, 0, benchmarkSeed)
+		if err != nil {
+			panic(err)
+		}
+		state.CommitCache()
 	}
 
-	body, err := rlp.EncodeToBytes(txns)
-	if err != nil {
-		panic(err)
+	orders := make([]PlaceOrderTxn, orderCount)
+	for i := range orders {
+		orders[i] = a.randOrder()
 	}
+	return state, a.block(p, orders)
+}
 
-	return state, body
+func reportRate(b *testing.B, start time.Time, n int, unit string) {
+	b.ReportMetric(float64(n)/time.Since(start).Seconds(), unit)
 }
 
 func BenchmarkPlaceOrder(b *testing.B) {
+	const orderCount = 10000
 	p := &myPKer{m: make(map[consensus.Addr]PK)}
-	state, body := genStateTxns(p)
+	state, body := genStateTxns(p, 0, orderCount)
 	pool := NewTxnPool(p)
 	// warm up txn pool
-	_, _, _ = state.CommitTxns(body, pool, 1)
+	_, _, err := state.CommitTxns(body, pool, 2, 0, benchmarkSeed)
+	if err != nil {
+		panic(err)
+	}
 
 	b.ResetTimer()
+	start := time.Now()
 	for i := 0; i < b.N; i++ {
-		_, _, _ = state.CommitTxns(body, pool, 1)
+		_, _, _ = state.CommitTxns(body, pool, 2, 0, benchmarkSeed)
+	}
+	reportRate(b, start, b.N*orderCount, "orders/s")
+}
+
+// BenchmarkOrderBookLimit measures the matching engine alone,
+// without the txn decoding and the balance updates.
+func BenchmarkOrderBookLimit(b *testing.B) {
+	a := &benchmarkAccounts{r: rand.New(rand.NewSource(1))}
+	orders := make([]Order, 100000)
+	for i := range orders {
+		o := a.randOrder()
+		orders[i] = Order{SellSide: o.SellSide, Quant: o.Quant, Price: o.Price}
+	}
+
+	book := newOrderBook()
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		book.Limit(orders[i%len(orders)])
+	}
+	reportRate(b, start, b.N, "orders/s")
+}
+
+// BenchmarkStateCommit measures committing the transition of a block
+// that reads every account and updates some of them to the state
+// trie, and hashing the new state. Only the updated accounts are
+// written and rehashed, the cost is proportional to them rather than
+// to the accounts read.
+func BenchmarkStateCommit(b *testing.B) {
+	for _, updated := range []int{10, 100, benchmarkAccountCount} {
+		b.Run(fmt.Sprintf("updated-%d", updated), func(b *testing.B) {
+			p := &myPKer{m: make(map[consensus.Addr]PK)}
+			a := newBenchmarkAccounts(p)
+			state := CreateGenesisState(a.pks, nil)
+			state.CommitCache()
+			var elapsed time.Duration
+			for i := 0; i < b.N; i++ {
+				trans := state.Transition(1, 0, nil).(*Transition)
+				for j, pk := range a.pks {
+					acc := trans.state.Account(pk.Addr())
+					bal := acc.Balance(0)
+					if j < updated {
+						bal.Available -= uint64(i + 1)
+						acc.UpdateBalance(0, bal)
+					}
+				}
+
+				start := time.Now()
+				s := trans.Commit()
+				s.CommitCache()
+				s.Hash()
+				elapsed += time.Since(start)
+			}
+			b.ReportMetric(float64(elapsed.Nanoseconds())/float64(b.N), "ns/commit")
+			b.ReportMetric(float64(b.N)/elapsed.Seconds(), "commits/s")
+		})
+	}
+}
+
+// BenchmarkReplayBlock measures replaying a block of 1000 orders
+// against order books of different depths.
+func BenchmarkReplayBlock(b *testing.B) {
+	for _, depth := range []int{0, 1000, 10000} {
+		b.Run(fmt.Sprintf("depth-%d", depth), func(b *testing.B) {
+			p := &myPKer{m: make(map[consensus.Addr]PK)}
+			state, body := genStateTxns(p, depth, 1000)
+			pool := NewTxnPool(p)
+			_, _, err := state.CommitTxns(body, pool, 2, 0, benchmarkSeed)
+			if err != nil {
+				panic(err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _, _ = state.CommitTxns(body, pool, 2, 0, benchmarkSeed)
+			}
+		})
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/helinwang/dex/pkg/consensus"
 	"github.com/stretchr/testify/assert"
 )
@@ -34,7 +35,7 @@ func TestSendToken(t *testing.T) {
 
 	pkTo, _ := RandKeyPair()
 	txn := MakeSendTokenTxn(sk, addr, pkTo, 0, 20, 0)
-	trans := s.Transition(1, nil)
+	trans := s.Transition(1, 0, nil)
 	pt, err := parseTxn(txn, &myPKer{m: map[consensus.Addr]PK{
 		addr: pk,
 	}})
@@ -61,7 +62,7 @@ func TestFreezeToken(t *testing.T) {
 	addr := pk.Addr()
 	txn := MakeFreezeTokenTxn(sk, addr, FreezeTokenTxn{TokenID: 0, AvailableRound: 3, Quant: 50}, 0)
 
-	trans := s.Transition(1, nil)
+	trans := s.Transition(1, 0, nil)
 	pt, err := parseTxn(txn, &myPKer{m: map[consensus.Addr]PK{
 		addr: pk,
 	}})
@@ -77,7 +78,7 @@ func TestFreezeToken(t *testing.T) {
 	assert.Equal(t, 50, int(acc.Balance(0).Available))
 	assert.Equal(t, []Frozen([]Frozen{Frozen{AvailableRound: 3, Quant: 50}}), acc.Balance(0).Frozen)
 
-	trans = s.Transition(2, nil)
+	trans = s.Transition(2, 0, nil)
 	s = trans.Commit().(*State)
 	acc = s.Account(addr)
 	assert.Equal(t, 100, int(acc.Balance(0).Available))
@@ -95,7 +96,7 @@ func TestIssueToken(t *testing.T) {
 	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
 	pk, sk := RandKeyPair()
 	acc := s.NewAccount(pk)
-	trans := s.Transition(1, nil)
+	trans := s.Transition(1, 0, nil)
 	addr := pk.Addr()
 	txn := MakeIssueTokenTxn(sk, addr, btcInfo, 0)
 	pt, err := parseTxn(txn, &myPKer{m: map[consensus.Addr]PK{
@@ -133,7 +134,7 @@ func TestOrderAlreadyExpired(t *testing.T) {
 		Market:      MarketSymbol{Quote: 0, Base: 1},
 	}
 
-	trans := s.Transition(1, nil)
+	trans := s.Transition(1, 0, nil)
 	pt, err := parseTxn(MakePlaceOrderTxn(sk, addr, order, 0), &myPKer{m: map[consensus.Addr]PK{
 		addr: pk,
 	}})
@@ -164,7 +165,7 @@ func TestBuyOrderExpire(t *testing.T) {
 		ExpireRound: 3,
 		Market:      MarketSymbol{Quote: 1, Base: 0},
 	}
-	trans := s.Transition(1, nil)
+	trans := s.Transition(1, 0, nil)
 	pt, err := parseTxn(MakePlaceOrderTxn(sk, addr, order, 0), &myPKer{m: map[consensus.Addr]PK{
 		addr: pk,
 	}})
@@ -181,7 +182,7 @@ func TestBuyOrderExpire(t *testing.T) {
 	assert.Equal(t, 200, int(acc.Balance(1).Pending))
 	assert.Equal(t, 100, int(acc.Balance(1).Available))
 
-	trans = s.Transition(2, nil)
+	trans = s.Transition(2, 0, nil)
 	s = trans.Commit().(*State)
 	acc = s.Account(addr)
 	assert.Equal(t, 0, len(acc.PendingOrders()))
@@ -205,7 +206,7 @@ func TestSellOrderExpire(t *testing.T) {
 		ExpireRound: 3,
 		Market:      MarketSymbol{Quote: 1, Base: 0},
 	}
-	trans := s.Transition(1, nil)
+	trans := s.Transition(1, 0, nil)
 	pt, err := parseTxn(MakePlaceOrderTxn(sk, addr, order, 0), &myPKer{m: map[consensus.Addr]PK{
 		addr: pk,
 	}})
@@ -222,7 +223,7 @@ func TestSellOrderExpire(t *testing.T) {
 	assert.Equal(t, 100, int(acc.Balance(0).Pending))
 	assert.Equal(t, 200, int(acc.Balance(0).Available))
 
-	trans = s.Transition(2, nil)
+	trans = s.Transition(2, 0, nil)
 	s = trans.Commit().(*State)
 	acc = s.Account(addr)
 	assert.Equal(t, 0, len(acc.PendingOrders()))
@@ -236,7 +237,7 @@ func TestNonce(t *testing.T) {
 	addr := pk.Addr()
 	acc := s.NewAccount(pk)
 	acc.UpdateBalance(0, Balance{Available: 100})
-	trans := s.Transition(1, nil)
+	trans := s.Transition(1, 0, nil)
 
 	to, _ := RandKeyPair()
 	txn := MakeSendTokenTxn(sk, addr, to, 0, 20, 0)
@@ -251,7 +252,7 @@ func TestNonce(t *testing.T) {
 	assert.Nil(t, err)
 	s = trans.Commit().(*State)
 
-	trans = s.Transition(2, nil)
+	trans = s.Transition(2, 0, nil)
 	err = trans.Record(pt)
 	assert.Contains(t, err.Error(), "nonce not valid")
 
@@ -276,7 +277,7 @@ func TestMinerFee(t *testing.T) {
 
 	pkTo, _ := RandKeyPair()
 	txn := MakeSendTokenTxn(sk, addr, pkTo, 0, 20, 0)
-	trans := s.Transition(1, miner)
+	trans := s.Transition(1, 0, miner)
 	pker := &myPKer{m: map[consensus.Addr]PK{
 		addr: pk,
 	}}
@@ -294,12 +295,101 @@ func TestMinerFee(t *testing.T) {
 	assert.Equal(t, flatFee, minerAcc.Balance(0).Available)
 
 	body := trans.Txns()
-	newState0, count, err := s.CommitTxns(body, NewTxnPool(pker), 1)
+	newState0, count, err := s.CommitTxns(body, NewTxnPool(pker), 1, 0, consensus.Rand{})
 	assert.Nil(t, err)
 	assert.Equal(t, 2, count)
 	assert.Equal(t, root, newState0.Hash())
 }
 
+func TestCommitTxnsOrder(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	pker := &myPKer{m: make(map[consensus.Addr]PK)}
+	var txns []*consensus.Txn
+	for i := 0; i < 5; i++ {
+		pk, sk := RandKeyPair()
+		addr := pk.Addr()
+		pker.m[addr] = pk
+		acc := s.NewAccount(pk)
+		acc.UpdateBalance(0, Balance{Available: 100 + flatFee})
+		pkTo, _ := RandKeyPair()
+		pt, err := parseTxn(MakeSendTokenTxn(sk, addr, pkTo, 0, 20, 0), pker)
+		if err != nil {
+			panic(err)
+		}
+		txns = append(txns, pt)
+	}
+	s.CommitCache()
+
+	seed := consensus.Rand{1}
+	consensus.SortTxns(txns, seed)
+	encode := func(txns []*consensus.Txn) []byte {
+		raw := make([][]byte, len(txns))
+		for i := range txns {
+			raw[i] = txns[i].Raw
+		}
+		b, err := rlp.EncodeToBytes(raw)
+		if err != nil {
+			panic(err)
+		}
+		return b
+	}
+
+	_, count, err := s.CommitTxns(encode(txns), NewTxnPool(pker), 1, 0, seed)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, count)
+
+	txns[0], txns[1] = txns[1], txns[0]
+	_, _, err = s.CommitTxns(encode(txns), NewTxnPool(pker), 1, 0, seed)
+	assert.NotNil(t, err)
+}
+
+func TestCommitTxnsConflict(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	pk, sk := RandKeyPair()
+	addr := pk.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 100 + 2*flatFee})
+	s.CommitCache()
+
+	pkTo, _ := RandKeyPair()
+	var txns []*consensus.Txn
+	for _, quant := range []uint64{20, 30} {
+		pt, err := parseTxn(MakeSendTokenTxn(sk, addr, pkTo, 0, quant, 0), pker)
+		if err != nil {
+			panic(err)
+		}
+		txns = append(txns, pt)
+	}
+
+	seed := consensus.Rand{1}
+	consensus.SortTxns(txns, seed)
+	encode := func(txns ...*consensus.Txn) []byte {
+		raw := make([][]byte, len(txns))
+		for i := range txns {
+			raw[i] = txns[i].Raw
+		}
+		b, err := rlp.EncodeToBytes(raw)
+		if err != nil {
+			panic(err)
+		}
+		return b
+	}
+
+	_, _, err := s.CommitTxns(encode(txns[0], txns[0]), NewTxnPool(pker), 1, 0, seed)
+	assert.Contains(t, err.Error(), "duplicate txn")
+	_, _, err = s.CommitTxns(encode(txns[0], txns[1]), NewTxnPool(pker), 1, 0, seed)
+	assert.Contains(t, err.Error(), "conflicts")
+
+	// the proposer records the first of the conflicting txns in
+	// the canonical order and drops the other.
+	trans := s.Transition(1, 0, pk)
+	assert.Nil(t, trans.Record(txns[0]))
+	assert.NotNil(t, trans.Record(txns[1]))
+	_, count, err := s.CommitTxns(trans.Txns(), NewTxnPool(pker), 1, 0, seed)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count, "the recorded txn and the miner fee txn")
+}
+
 func TestBurnToken(t *testing.T) {
 	const burn = 1000
 	s := NewState(ethdb.NewMemDatabase())
@@ -328,7 +418,7 @@ func TestBurnToken(t *testing.T) {
 		panic(err)
 	}
 
-	trans := s.Transition(1, nil)
+	trans := s.Transition(1, 0, nil)
 	err = trans.Record(pt)
 	assert.Nil(t, err)
 	s = trans.Commit().(*State)
@@ -354,7 +444,7 @@ func TestPlaceOrder(t *testing.T) {
 		pkBuy.Addr():  pkBuy,
 		pkSell.Addr(): pkSell,
 	}}
-	trans := s.Transition(1, nil)
+	trans := s.Transition(1, 0, nil)
 
 	// buy 40
 	order := PlaceOrderTxn{
@@ -379,7 +469,7 @@ func TestPlaceOrder(t *testing.T) {
 	assert.Equal(t, 1, len(buyAcc.PendingOrders()))
 
 	// buy 20, sell 55
-	trans = s.Transition(2, nil)
+	trans = s.Transition(2, 0, nil)
 	order = PlaceOrderTxn{
 		SellSide: false,
 		// will be pending 20*3
@@ -427,8 +517,426 @@ func TestPlaceOrder(t *testing.T) {
 	po := buyAcc.PendingOrders()[0]
 	assert.Equal(t, 35, int(po.Executed))
 	assert.Equal(t, 40, int(po.Quant))
+
+	ref, ok := s.RefPrice(MarketSymbol{Quote: 1, Base: 0})
+	assert.True(t, ok)
+	assert.Equal(t, 2*uint64(math.Pow10(OrderPriceDecimals)), ref.Price)
+}
+
+func TestRecurringOrder(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	addr := pk.Addr()
+	acc := s.NewAccount(pk)
+	acc.UpdateBalance(1, Balance{Available: 300})
+
+	// buy 10 every 2 rounds for 2 times, pending 10*2 each time
+	order := RecurringOrderTxn{
+		Quant:    10,
+		Price:    2 * uint64(math.Pow10(OrderPriceDecimals)),
+		Market:   MarketSymbol{Quote: 1, Base: 0},
+		Interval: 2,
+		Count:    2,
+	}
+	trans := s.Transition(1, 0, nil)
+	pt, err := parseTxn(MakeRecurringOrderTxn(sk, addr, order, 0), &myPKer{m: map[consensus.Addr]PK{
+		addr: pk,
+	}})
+	if err != nil {
+		panic(err)
+	}
+
+	err = trans.Record(pt)
+	assert.Nil(t, err)
+	s = trans.Commit().(*State)
+	acc = s.Account(addr)
+	assert.Equal(t, 0, len(acc.PendingOrders()))
+
+	expected := []int{0, 1, 1, 2, 2}
+	for i, count := range expected {
+		trans = s.Transition(uint64(i+2), 0, nil)
+		s = trans.Commit().(*State)
+		acc = s.Account(addr)
+		assert.Equal(t, count, len(acc.PendingOrders()))
+	}
+
+	assert.Equal(t, 40, int(acc.Balance(1).Pending))
+	assert.Equal(t, 260, int(acc.Balance(1).Available))
+	assert.Equal(t, 0, int(s.RecurringCount(addr)))
+}
+
+func TestRecurringOrderLimits(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	addr := pk.Addr()
+	s.NewAccount(pk).UpdateBalance(1, Balance{Available: 30})
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+	order := RecurringOrderTxn{
+		Quant:    10,
+		Price:    uint64(math.Pow10(OrderPriceDecimals)),
+		Market:   MarketSymbol{Quote: 1, Base: 0},
+		Interval: 1,
+		Count:    math.MaxUint64,
+	}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.NotNil(t, recordTxn(t, trans, MakeRecurringOrderTxn(sk, addr, order, 0), pker), "count above the max")
+	order.Count = maxRecurringOrderCount
+	order.Interval = math.MaxUint64
+	assert.NotNil(t, recordTxn(t, trans, MakeRecurringOrderTxn(sk, addr, order, 0), pker), "interval above the max")
+	order.Interval = 1
+	for i := 0; i < maxRecurringOrders; i++ {
+		assert.Nil(t, recordTxn(t, trans, MakeRecurringOrderTxn(sk, addr, order, uint64(i)), pker))
+	}
+	assert.NotNil(t, recordTxn(t, trans, MakeRecurringOrderTxn(sk, addr, order, maxRecurringOrders), pker), "too many schedules")
+	s = trans.Commit().(*State)
+	assert.Equal(t, maxRecurringOrders, int(s.RecurringCount(addr)))
+
+	// the balance covers 3 child orders, the schedules whose
+	// child orders fail are dropped.
+	s = s.Transition(2, 0, nil).(*Transition).Commit().(*State)
+	assert.Equal(t, 3, len(s.Account(addr).PendingOrders()))
+	assert.Equal(t, 3, int(s.RecurringCount(addr)))
+	s = s.Transition(3, 0, nil).(*Transition).Commit().(*State)
+	assert.Equal(t, 3, len(s.Account(addr).PendingOrders()))
+	assert.Equal(t, 0, int(s.RecurringCount(addr)))
+	assert.Empty(t, s.RecurringOrders(4))
+}
+
+func TestRecurringOrderInvalid(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	addr := pk.Addr()
+	s.NewAccount(pk)
+
+	order := RecurringOrderTxn{
+		Quant:    10,
+		Market:   MarketSymbol{Quote: 1, Base: 0},
+		Interval: 0,
+		Count:    2,
+	}
+	trans := s.Transition(1, 0, nil)
+	pt, err := parseTxn(MakeRecurringOrderTxn(sk, addr, order, 0), &myPKer{m: map[consensus.Addr]PK{
+		addr: pk,
+	}})
+	if err != nil {
+		panic(err)
+	}
+
+	err = trans.Record(pt)
+	assert.Contains(t, err.Error(), "interval")
+}
+
+func TestOpeningAuction(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pkIssuer, skIssuer := RandKeyPair()
+	pkBuy, skBuy := RandKeyPair()
+	s.NewAccount(pkIssuer)
+	buyAcc := s.NewAccount(pkBuy)
+	buyAcc.UpdateBalance(0, Balance{Available: 100})
+	pker := &myPKer{m: map[consensus.Addr]PK{
+		pkIssuer.Addr(): pkIssuer,
+		pkBuy.Addr():    pkBuy,
+	}}
+
+	btcInfo := TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 100}
+	trans := s.Transition(1, 0, nil)
+	pt, err := parseTxn(MakeIssueTokenTxn(skIssuer, pkIssuer.Addr(), btcInfo, 0), pker)
+	if err != nil {
+		panic(err)
+	}
+	assert.Nil(t, trans.Record(pt))
+	s = trans.Commit().(*State)
+
+	market := MarketSymbol{Base: 1, Quote: 0}
+	trans = s.Transition(2, 0, nil)
+	sell := PlaceOrderTxn{
+		SellSide: true,
+		Quant:    10,
+		Price:    1 * uint64(math.Pow10(OrderPriceDecimals)),
+		Market:   market,
+	}
+	pt, err = parseTxn(MakePlaceOrderTxn(skIssuer, pkIssuer.Addr(), sell, 1), pker)
+	if err != nil {
+		panic(err)
+	}
+	assert.Nil(t, trans.Record(pt))
+
+	buy := PlaceOrderTxn{
+		Quant:  10,
+		Price:  2 * uint64(math.Pow10(OrderPriceDecimals)),
+		Market: market,
+	}
+	pt, err = parseTxn(MakePlaceOrderTxn(skBuy, pkBuy.Addr(), buy, 0), pker)
+	if err != nil {
+		panic(err)
+	}
+	assert.Nil(t, trans.Record(pt))
+	s = trans.Commit().(*State)
+
+	// the orders are not matched during the opening auction
+	buyAcc = s.Account(pkBuy.Addr())
+	assert.Equal(t, 1, len(buyAcc.PendingOrders()))
+	assert.Equal(t, 20, int(buyAcc.Balance(0).Pending))
+
+	for round := uint64(3); round <= openingAuctionRounds; round++ {
+		s = s.Transition(round, 0, nil).Commit().(*State)
+	}
+
+	// uncrossed at the clearing price 1.0
+	buyAcc = s.Account(pkBuy.Addr())
+	assert.Equal(t, 0, len(buyAcc.PendingOrders()))
+	assert.Equal(t, 10, int(buyAcc.Balance(1).Available))
+	assert.Equal(t, 0, int(buyAcc.Balance(0).Pending))
+	assert.Equal(t, 90, int(buyAcc.Balance(0).Available))
+	sellAcc := s.Account(pkIssuer.Addr())
+	assert.Equal(t, 10, int(sellAcc.Balance(0).Available))
+	assert.Equal(t, 0, len(sellAcc.PendingOrders()))
+}
+
+func TestBatchAuction(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pkIssuer, skIssuer := RandKeyPair()
+	pkBuy, skBuy := RandKeyPair()
+	s.NewAccount(pkIssuer)
+	buyAcc := s.NewAccount(pkBuy)
+	buyAcc.UpdateBalance(0, Balance{Available: 100})
+	pker := &myPKer{m: map[consensus.Addr]PK{
+		pkIssuer.Addr(): pkIssuer,
+		pkBuy.Addr():    pkBuy,
+	}}
+
+	market := MarketSymbol{Base: 1, Quote: 0}
+	btcInfo := TokenInfo{Symbol: "BTC", Decimals: 8, TotalUnits: 100}
+	trans := s.Transition(1, 0, nil)
+	pt, err := parseTxn(MakeIssueTokenTxn(skIssuer, pkIssuer.Addr(), btcInfo, 0), pker)
+	if err != nil {
+		panic(err)
+	}
+	assert.Nil(t, trans.Record(pt))
+
+	config := MarketConfigTxn{Market: market, MarketConfigInfo: MarketConfigInfo{BatchAuction: true}}
+	pt, err = parseTxn(MakeMarketConfigTxn(skBuy, pkBuy.Addr(), config, 0), pker)
+	if err != nil {
+		panic(err)
+	}
+	assert.Contains(t, trans.Record(pt).Error(), "issuer")
+
+	pt, err = parseTxn(MakeMarketConfigTxn(skIssuer, pkIssuer.Addr(), config, 1), pker)
+	if err != nil {
+		panic(err)
+	}
+	assert.Nil(t, trans.Record(pt))
+	s = trans.Commit().(*State)
+	assert.True(t, s.MarketConfig(market).BatchAuction)
+
+	// skip the opening auction
+	round := uint64(openingAuctionRounds + 1)
+	for r := uint64(2); r < round; r++ {
+		s = s.Transition(r, 0, nil).Commit().(*State)
+	}
+
+	trans = s.Transition(round, 0, nil)
+	buy := PlaceOrderTxn{
+		Quant:  10,
+		Price:  2 * uint64(math.Pow10(OrderPriceDecimals)),
+		Market: market,
+	}
+	pt, err = parseTxn(MakePlaceOrderTxn(skBuy, pkBuy.Addr(), buy, 0), pker)
+	if err != nil {
+		panic(err)
+	}
+	assert.Nil(t, trans.Record(pt))
+
+	sell := PlaceOrderTxn{
+		SellSide: true,
+		Quant:    10,
+		Price:    1 * uint64(math.Pow10(OrderPriceDecimals)),
+		Market:   market,
+	}
+	pt, err = parseTxn(MakePlaceOrderTxn(skIssuer, pkIssuer.Addr(), sell, 2), pker)
+	if err != nil {
+		panic(err)
+	}
+	assert.Nil(t, trans.Record(pt))
+	s = trans.Commit().(*State)
+
+	// continuous matching would trade at the resting bid price
+	// 2.0, the batch auction clears at 1.0.
+	buyAcc = s.Account(pkBuy.Addr())
+	assert.Equal(t, 0, len(buyAcc.PendingOrders()))
+	assert.Equal(t, 10, int(buyAcc.Balance(1).Available))
+	assert.Equal(t, 90, int(buyAcc.Balance(0).Available))
+	reports := buyAcc.ExecutionReports()
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, uint64(math.Pow10(OrderPriceDecimals)), reports[0].TradePrice)
+}
+
+func TestReduceOrder(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	price := 2 * uint64(math.Pow10(OrderPriceDecimals))
+	market := MarketSymbol{Quote: 1, Base: 0}
+	pkA, skA := RandKeyPair()
+	pkB, skB := RandKeyPair()
+	a, b := pkA.Addr(), pkB.Addr()
+	s.NewAccount(pkA).UpdateBalance(1, Balance{Available: 1000})
+	s.NewAccount(pkB).UpdateBalance(0, Balance{Available: 1000})
+	pker := &myPKer{m: map[consensus.Addr]PK{a: pkA, b: pkB}}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skA, a, PlaceOrderTxn{Quant: 100, Price: price, Market: market}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skA, a, PlaceOrderTxn{Quant: 100, Price: price, Market: market}, 1), pker))
+	s = trans.Commit().(*State)
+	first := OrderID{ID: 0, Market: market}
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.NotNil(t, recordTxn(t, trans, MakeReduceOrderTxn(skA, a, ReduceOrderTxn{ID: first}, 2), pker), "0 quantity")
+	assert.NotNil(t, recordTxn(t, trans, MakeReduceOrderTxn(skA, a, ReduceOrderTxn{ID: first, Quant: 100}, 2), pker), "not less than the remaining")
+	assert.NotNil(t, recordTxn(t, trans, MakeReduceOrderTxn(skB, b, ReduceOrderTxn{ID: first, Quant: 40}, 0), pker), "not the owner's order")
+	assert.Nil(t, recordTxn(t, trans, MakeReduceOrderTxn(skA, a, ReduceOrderTxn{ID: first, Quant: 40}, 2), pker))
+	s = trans.Commit().(*State)
+	acc := s.Account(a)
+	assert.Equal(t, 320, int(acc.Balance(1).Pending))
+	assert.Equal(t, 680, int(acc.Balance(1).Available))
+
+	// the reduced order keeps its priority.
+	trans = s.Transition(3, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skB, b, PlaceOrderTxn{SellSide: true, Quant: 70, Price: price, Market: market}, 0), pker))
+	s = trans.Commit().(*State)
+	acc = s.Account(a)
+	orders := acc.PendingOrders()
+	assert.Equal(t, 1, len(orders))
+	assert.Equal(t, PendingOrder{ID: OrderID{ID: 1, Market: market}, Executed: 10, Order: Order{Owner: a, Quant: 100, Price: price}}, orders[0])
+	assert.Equal(t, 180, int(acc.Balance(1).Pending))
+	assert.Equal(t, 680, int(acc.Balance(1).Available))
+	assert.Equal(t, 70, int(acc.Balance(0).Available))
+}
+
+func TestProRataRounding(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	price := 15 * uint64(math.Pow10(OrderPriceDecimals-1))
+	market := MarketSymbol{Quote: 1, Base: 0}
+	s.UpdateMarketConfig(market, MarketConfigInfo{ProRata: true})
+	pkA, skA := RandKeyPair()
+	pkB, skB := RandKeyPair()
+	pkC, skC := RandKeyPair()
+	a, b, c := pkA.Addr(), pkB.Addr(), pkC.Addr()
+	s.NewAccount(pkA).UpdateBalance(1, Balance{Available: 100})
+	s.NewAccount(pkB).UpdateBalance(1, Balance{Available: 100})
+	s.NewAccount(pkC).UpdateBalance(0, Balance{Available: 100})
+	pker := &myPKer{m: map[consensus.Addr]PK{a: pkA, b: pkB, c: pkC}}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skA, a, PlaceOrderTxn{Quant: 3, Price: price, Market: market}, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skB, b, PlaceOrderTxn{Quant: 3, Price: price, Market: market}, 0), pker))
+	s = trans.Commit().(*State)
+	// 3 at 1.5 locks 4.5 rounded down.
+	assert.Equal(t, 4, int(s.Account(a).Balance(1).Pending))
+
+	// each sell of 2 is split between the buys, which are filled
+	// 1 at a time.
+	for i := uint64(0); i < 3; i++ {
+		trans = s.Transition(i+2, 0, nil).(*Transition)
+		assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skC, c, PlaceOrderTxn{SellSide: true, Quant: 2, Price: price, Market: market}, i), pker))
+		s = trans.Commit().(*State)
+	}
+
+	for _, addr := range []consensus.Addr{a, b} {
+		acc := s.Account(addr)
+		assert.Equal(t, 3, len(acc.ExecutionReports()))
+		assert.Equal(t, 0, len(acc.PendingOrders()))
+		assert.Equal(t, 3, int(acc.Balance(0).Available))
+		// the fills release the whole locked quantity.
+		assert.Equal(t, 0, int(acc.Balance(1).Pending))
+		assert.Equal(t, 97, int(acc.Balance(1).Available))
+	}
+	assert.Equal(t, 94, int(s.Account(c).Balance(0).Available))
+	assert.Equal(t, 6, int(s.Account(c).Balance(1).Available))
 }
 
 func TestCalcQuoteQuant(t *testing.T) {
 	assert.Equal(t, 40, int(calcQuoteQuant(40, 8, uint64(math.Pow10(OrderPriceDecimals)), 8, 8)))
 }
+
+func TestAddTxns(t *testing.T) {
+	pker := &myPKer{m: make(map[consensus.Addr]PK)}
+	pool := NewTxnPool(pker)
+	pkTo, _ := RandKeyPair()
+	var txns [][]byte
+	for i := 0; i < 20; i++ {
+		pk, sk := RandKeyPair()
+		pker.m[pk.Addr()] = pk
+		txns = append(txns, MakeSendTokenTxn(sk, pk.Addr(), pkTo, 0, 1, 0))
+	}
+	_, sk := RandKeyPair()
+	txns = append(txns, MakeSendTokenTxn(sk, pkTo.Addr(), pkTo, 0, 1, 0))
+
+	parsed := addTxns(pool, txns)
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, txns[i], parsed[i].Raw)
+	}
+	// the txn signed by a key other than the owner's is invalid.
+	assert.Nil(t, parsed[20])
+	assert.Equal(t, 20, pool.Size())
+}
+
+type span struct {
+	name string
+	ctx  []interface{}
+	err  error
+}
+
+type recordingTracer struct {
+	spans []span
+}
+
+func (r *recordingTracer) StartSpan(name string, ctx ...interface{}) func(error) {
+	r.spans = append(r.spans, span{name: name, ctx: ctx})
+	i := len(r.spans) - 1
+	return func(err error) {
+		r.spans[i].err = err
+	}
+}
+
+func TestRecordTxnSpan(t *testing.T) {
+	tracer := &recordingTracer{}
+	consensus.SetTracer(tracer)
+	defer consensus.SetTracer(nil)
+
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	pkTo, _ := RandKeyPair()
+	addr := pk.Addr()
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 100})
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	send := MakeSendTokenTxn(sk, addr, pkTo, 0, 200, 0)
+	err := recordTxn(t, trans, send, pker)
+	assert.NotNil(t, err)
+	trans.StateHash()
+
+	assert.Equal(t, []span{
+		{name: "record_txn", ctx: []interface{}{"round", uint64(1), "txn", consensus.SHA3(send)}, err: err},
+		{name: "finalize_state", ctx: []interface{}{"round", uint64(1)}},
+	}, tracer.spans)
+	assert.Equal(t, consensus.Hash{}, trans.txn)
+}
+
+func TestTransitionTimestamp(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	trans := s.Transition(1, 1500, nil).(*Transition)
+	assert.Equal(t, uint64(1500), trans.Timestamp())
+}
@@ -3,8 +3,7 @@ package dex
 import (
 	"bytes"
 	"encoding/binary"
-	"encoding/gob"
-	"fmt"
+	"errors"
 
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/helinwang/dex/pkg/consensus"
@@ -24,14 +23,106 @@ const (
 	FreezeToken
 	BurnToken
 	MinerFee
+	RecurringOrder
+	MarketConfig
+	Supply
+	MarginTransfer
+	MarginBorrow
+	MarginOrder
+	MarginCancelOrder
+	PerpTransfer
+	PerpOrder
+	PerpCancelOrder
+	BridgeRegister
+	BridgeMint
+	BridgeWithdraw
+	BridgeAuthorize
+	HTLCLock
+	HTLCClaim
+	HTLCRefund
+	IBCCreateClient
+	IBCUpdateClient
+	IBCOpenChannel
+	IBCTransfer
+	IBCRecvPacket
+	SetOracles
+	ReportPrices
+	CreateStablecoin
+	ConfigCDP
+	CDPLock
+	CDPMint
+	SetFeeSchedule
+	RegisterReferrer
+	SetRoundInterval
+	VerifyIssuer
+	Delist
+	TokenWhitelist
+	EscrowOpen
+	EscrowDecide
+	StreamOpen
+	StreamCancel
+	CancelAllOrders
+	SetRiskLimit
+	SetGuardian
+	KillSwitch
+	SendToDeposit
+	SetMMProgram
+	RegisterMarketMaker
+	BustTrade
+	ReduceOrder
+	RegisterValidator
+	SetTradingCalendar
+	SetIndex
+	SetAllowList
+	SetTransferThreshold
+	AnnounceTransfer
+	ExecuteTransfer
+	VetoTransfer
+	BlockAddress
 )
 
+// TxnVersion is the version of the txn types that the node supports.
+// A version appends its txn types after the last type of the previous
+// version, the types are never reordered or reused.
+const TxnVersion = 2
+
+// txnVersionLastTypes is the last txn type of each version, indexed by
+// the version - 1.
+var txnVersionLastTypes = [TxnVersion]TxnType{RegisterValidator, BlockAddress}
+
+// Version returns the txn version that introduced the type, it
+// returns false if the type is of a version higher than TxnVersion.
+func (t TxnType) Version() (int, bool) {
+	for i, last := range txnVersionLastTypes {
+		if t <= last {
+			return i + 1, true
+		}
+	}
+
+	return 0, false
+}
+
+// NonceLanes is the number of the nonce lanes of an account. Lane 0
+// is the account nonce.
+const NonceLanes = 16
+
 type Txn struct {
 	T     TxnType
 	Data  []byte
 	Nonce uint64
 	Owner consensus.Addr
-	Sig   Sig
+	// Lane is the nonce lane of Nonce, each lane of an account
+	// has its own sequential nonce, so that the txns of different
+	// lanes do not wait for each other. It's less than NonceLanes.
+	Lane uint8
+	// ValidUntil is the last round that the txn can be included
+	// in, so that a stale signed txn can not be replayed much
+	// later. 0 means no limit.
+	ValidUntil uint64
+	// Work is the proof-of-work nonce of a txn whose owner can
+	// not pay the fee, see SolveWork.
+	Work uint64
+	Sig  Sig
 }
 
 func (b *Txn) Encode(withSig bool) []byte {
@@ -48,10 +139,55 @@ func (b *Txn) Encode(withSig bool) []byte {
 	return d
 }
 
+// networkID is the network that the txns are signed for, it is
+// prefixed to the signed message so a txn signed for one network is
+// invalid on the others.
+var networkID consensus.NetworkID
+
+// SetNetworkID sets the network that the txns are signed and
+// verified for. It must be called before any txn is made or parsed.
+func SetNetworkID(id consensus.NetworkID) {
+	networkID = id
+}
+
+// SigningMsg returns the message that the txn owner signs, the
+// canonical encoding of the txn without the signature.
+func (b *Txn) SigningMsg() []byte {
+	msg := make([]byte, 4)
+	binary.BigEndian.PutUint32(msg, uint32(networkID))
+	return append(msg, b.Encode(false)...)
+}
+
 func (b *Txn) Bytes() []byte {
 	return b.Encode(true)
 }
 
+// TxnOptions are the envelope fields that the Make functions leave
+// at their defaults.
+type TxnOptions struct {
+	// Lane is the nonce lane, the txn's nonce must be the lane's.
+	Lane       uint8
+	ValidUntil uint64
+}
+
+// WithOptions returns the serialized txn signed again with the
+// options, the proof-of-work is solved again if the txn has one.
+func WithOptions(sk SK, b []byte, opts TxnOptions) ([]byte, error) {
+	var txn Txn
+	err := decodeCanonical(b, &txn)
+	if err != nil {
+		return nil, err
+	}
+
+	txn.Lane = opts.Lane
+	txn.ValidUntil = opts.ValidUntil
+	if txn.Work != 0 {
+		txn.SolveWork()
+	}
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true), nil
+}
+
 type PlaceOrderTxn struct {
 	SellSide bool
 	// quant step size is the decimals of the token, specific when
@@ -65,49 +201,11 @@ type PlaceOrderTxn struct {
 }
 
 func (p *PlaceOrderTxn) Encode() []byte {
-	var buf bytes.Buffer
-	b := make([]byte, 64)
-	n := binary.PutUvarint(b, p.Quant)
-	buf.Write(b[:n])
-	n = binary.PutUvarint(b, p.Price)
-	buf.Write(b[:n])
-	n = binary.PutUvarint(b, p.ExpireRound)
-	buf.Write(b[:n])
-	buf.Write(p.Market.Encode())
-	if p.SellSide {
-		buf.Write([]byte{1})
-	}
-	return buf.Bytes()
+	return rlpEncode(p)
 }
 
 func (p *PlaceOrderTxn) Decode(b []byte) error {
-	var t PlaceOrderTxn
-	v, n := binary.Uvarint(b)
-	t.Quant = v
-	b = b[n:]
-
-	v, n = binary.Uvarint(b)
-	t.Price = v
-	b = b[n:]
-
-	v, n = binary.Uvarint(b)
-	t.ExpireRound = v
-	b = b[n:]
-
-	n, err := t.Market.Decode(b)
-	if err != nil {
-		return err
-	}
-
-	b = b[n:]
-	if len(b) == 1 {
-		t.SellSide = true
-	} else if len(b) > 1 {
-		return fmt.Errorf("unexpected bytes remaining, count: %d", len(b))
-	}
-
-	*p = t
-	return nil
+	return decodeCanonical(b, p)
 }
 
 type CancelOrderTxn struct {
@@ -123,10 +221,10 @@ func MakeCancelOrderTxn(sk SK, owner consensus.Addr, id OrderID, nonce uint64) [
 		T:     CancelOrder,
 		Owner: owner,
 		Nonce: nonce,
-		Data:  gobEncode(t),
+		Data:  rlpEncode(t),
 	}
 
-	txn.Sig = sk.Sign(txn.Encode(false))
+	txn.Sig = sk.Sign(txn.SigningMsg())
 	return txn.Encode(true)
 }
 
@@ -141,10 +239,10 @@ func MakeSendTokenTxn(from SK, owner consensus.Addr, to PK, tokenID TokenID, qua
 		T:     SendToken,
 		Owner: owner,
 		Nonce: nonce,
-		Data:  gobEncode(send),
+		Data:  rlpEncode(send),
 	}
 
-	txn.Sig = from.Sign(txn.Encode(false))
+	txn.Sig = from.Sign(txn.SigningMsg())
 	return txn.Encode(true)
 }
 
@@ -156,7 +254,7 @@ func MakePlaceOrderTxn(sk SK, owner consensus.Addr, t PlaceOrderTxn, nonce uint6
 		Data:  t.Encode(),
 	}
 
-	txn.Sig = sk.Sign(txn.Encode(false))
+	txn.Sig = sk.Sign(txn.SigningMsg())
 	return txn.Encode(true)
 }
 
@@ -164,72 +262,1213 @@ func MakeIssueTokenTxn(sk SK, owner consensus.Addr, info TokenInfo, nonce uint64
 	t := IssueTokenTxn{Info: info}
 	txn := &Txn{
 		T:     IssueToken,
-		Data:  gobEncode(t),
+		Data:  rlpEncode(t),
 		Nonce: nonce,
 		Owner: owner,
 	}
 
-	txn.Sig = sk.Sign(txn.Encode(false))
+	txn.Sig = sk.Sign(txn.SigningMsg())
 	return txn.Encode(true)
 }
 
 func MakeFreezeTokenTxn(sk SK, owner consensus.Addr, t FreezeTokenTxn, nonce uint64) []byte {
 	txn := &Txn{
 		T:     FreezeToken,
-		Data:  gobEncode(t),
+		Data:  rlpEncode(t),
 		Nonce: nonce,
 		Owner: owner,
 	}
 
-	txn.Sig = sk.Sign(txn.Encode(false))
+	txn.Sig = sk.Sign(txn.SigningMsg())
 	return txn.Encode(true)
 }
 
 func MakeBurnTokenTxn(sk SK, owner consensus.Addr, t BurnTokenTxn, nonce uint64) []byte {
 	txn := &Txn{
 		T:     BurnToken,
-		Data:  gobEncode(t),
+		Data:  rlpEncode(t),
 		Nonce: nonce,
 		Owner: owner,
 	}
 
-	txn.Sig = sk.Sign(txn.Encode(false))
+	txn.Sig = sk.Sign(txn.SigningMsg())
 	return txn.Encode(true)
 }
 
-type MinerFeeTxn struct {
-	Miner PK
-	Fee   uint64
+func MakeRecurringOrderTxn(sk SK, owner consensus.Addr, t RecurringOrderTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     RecurringOrder,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
 }
 
-type BurnTokenTxn struct {
-	ID    TokenID
-	Quant uint64
+func MakeMarketConfigTxn(sk SK, owner consensus.Addr, t MarketConfigTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     MarketConfig,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
 }
 
-type IssueTokenTxn struct {
-	Info TokenInfo
+func MakeSupplyTxn(sk SK, owner consensus.Addr, t SupplyTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     Supply,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
 }
 
-type SendTokenTxn struct {
-	TokenID TokenID
-	To      PK
-	Quant   uint64
+func MakeMarginTransferTxn(sk SK, owner consensus.Addr, t MarginTransferTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     MarginTransfer,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
 }
 
-type FreezeTokenTxn struct {
-	TokenID        TokenID
-	AvailableRound uint64
-	Quant          uint64
+func MakeMarginBorrowTxn(sk SK, owner consensus.Addr, t MarginBorrowTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     MarginBorrow,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
 }
 
-func gobEncode(v interface{}) []byte {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(v)
-	if err != nil {
-		// should not happen
-		panic(err)
+func MakeMarginOrderTxn(sk SK, owner consensus.Addr, t PlaceOrderTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     MarginOrder,
+		Data:  rlpEncode(MarginOrderTxn{PlaceOrderTxn: t}),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeMarginCancelOrderTxn(sk SK, owner consensus.Addr, id OrderID, nonce uint64) []byte {
+	txn := &Txn{
+		T:     MarginCancelOrder,
+		Data:  rlpEncode(MarginCancelOrderTxn{ID: id}),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakePerpTransferTxn(sk SK, owner consensus.Addr, t PerpTransferTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     PerpTransfer,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakePerpOrderTxn(sk SK, owner consensus.Addr, t PlaceOrderTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     PerpOrder,
+		Data:  rlpEncode(PerpOrderTxn{PlaceOrderTxn: t}),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakePerpCancelOrderTxn(sk SK, owner consensus.Addr, id OrderID, nonce uint64) []byte {
+	txn := &Txn{
+		T:     PerpCancelOrder,
+		Data:  rlpEncode(PerpCancelOrderTxn{ID: id}),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeBridgeRegisterTxn(sk SK, owner consensus.Addr, t BridgeRegisterTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     BridgeRegister,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeBridgeMintTxn(sk SK, owner consensus.Addr, t BridgeMintTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     BridgeMint,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeBridgeWithdrawTxn(sk SK, owner consensus.Addr, t BridgeWithdrawTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     BridgeWithdraw,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeBridgeAuthorizeTxn(sk SK, owner consensus.Addr, t BridgeAuthorizeTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     BridgeAuthorize,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeHTLCLockTxn(sk SK, owner consensus.Addr, t HTLCLockTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     HTLCLock,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeHTLCClaimTxn(sk SK, owner consensus.Addr, t HTLCClaimTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     HTLCClaim,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeHTLCRefundTxn(sk SK, owner consensus.Addr, t HTLCRefundTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     HTLCRefund,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeIBCCreateClientTxn(sk SK, owner consensus.Addr, t IBCCreateClientTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     IBCCreateClient,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeIBCUpdateClientTxn(sk SK, owner consensus.Addr, t IBCUpdateClientTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     IBCUpdateClient,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeIBCOpenChannelTxn(sk SK, owner consensus.Addr, t IBCOpenChannelTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     IBCOpenChannel,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeIBCTransferTxn(sk SK, owner consensus.Addr, t IBCTransferTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     IBCTransfer,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeIBCRecvPacketTxn(sk SK, owner consensus.Addr, t IBCRecvPacketTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     IBCRecvPacket,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeSetOraclesTxn(sk SK, owner consensus.Addr, t SetOraclesTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     SetOracles,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeReportPricesTxn(sk SK, owner consensus.Addr, t ReportPricesTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     ReportPrices,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeCreateStablecoinTxn(sk SK, owner consensus.Addr, t CreateStablecoinTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     CreateStablecoin,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeConfigCDPTxn(sk SK, owner consensus.Addr, t ConfigCDPTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     ConfigCDP,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeCDPLockTxn(sk SK, owner consensus.Addr, t CDPLockTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     CDPLock,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
 	}
-	return buf.Bytes()
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeCDPMintTxn(sk SK, owner consensus.Addr, t CDPMintTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     CDPMint,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeSetFeeScheduleTxn(sk SK, owner consensus.Addr, t SetFeeScheduleTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     SetFeeSchedule,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeRegisterReferrerTxn(sk SK, owner consensus.Addr, t RegisterReferrerTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     RegisterReferrer,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeSetRoundIntervalTxn(sk SK, owner consensus.Addr, t SetRoundIntervalTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     SetRoundInterval,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeVerifyIssuerTxn(sk SK, owner consensus.Addr, t VerifyIssuerTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     VerifyIssuer,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeDelistTxn(sk SK, owner consensus.Addr, t DelistTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     Delist,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeTokenWhitelistTxn(sk SK, owner consensus.Addr, t TokenWhitelistTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     TokenWhitelist,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeEscrowOpenTxn(sk SK, owner consensus.Addr, t EscrowOpenTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     EscrowOpen,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeEscrowDecideTxn(sk SK, owner consensus.Addr, t EscrowDecideTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     EscrowDecide,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeStreamOpenTxn(sk SK, owner consensus.Addr, t StreamOpenTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     StreamOpen,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeStreamCancelTxn(sk SK, owner consensus.Addr, t StreamCancelTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     StreamCancel,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeCancelAllOrdersTxn(sk SK, owner consensus.Addr, t CancelAllOrdersTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     CancelAllOrders,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeSetRiskLimitTxn(sk SK, owner consensus.Addr, t SetRiskLimitTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     SetRiskLimit,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeSetGuardianTxn(sk SK, owner consensus.Addr, t SetGuardianTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     SetGuardian,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeKillSwitchTxn(sk SK, owner consensus.Addr, t KillSwitchTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     KillSwitch,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeSendToDepositTxn(sk SK, owner consensus.Addr, t SendToDepositTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     SendToDeposit,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeSetMMProgramTxn(sk SK, owner consensus.Addr, t SetMMProgramTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     SetMMProgram,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeRegisterMarketMakerTxn(sk SK, owner consensus.Addr, t RegisterMarketMakerTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     RegisterMarketMaker,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeBustTradeTxn(sk SK, owner consensus.Addr, t BustTradeTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     BustTrade,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeReduceOrderTxn(sk SK, owner consensus.Addr, t ReduceOrderTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     ReduceOrder,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeRegisterValidatorTxn(sk SK, owner consensus.Addr, t RegisterValidatorTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     RegisterValidator,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeSetTradingCalendarTxn(sk SK, owner consensus.Addr, t SetTradingCalendarTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     SetTradingCalendar,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeSetIndexTxn(sk SK, owner consensus.Addr, t SetIndexTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     SetIndex,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeSetAllowListTxn(sk SK, owner consensus.Addr, t SetAllowListTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     SetAllowList,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeSetTransferThresholdTxn(sk SK, owner consensus.Addr, t SetTransferThresholdTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     SetTransferThreshold,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeAnnounceTransferTxn(sk SK, owner consensus.Addr, t AnnounceTransferTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     AnnounceTransfer,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeExecuteTransferTxn(sk SK, owner consensus.Addr, t ExecuteTransferTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     ExecuteTransfer,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeVetoTransferTxn(sk SK, owner consensus.Addr, t VetoTransferTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     VetoTransfer,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+func MakeBlockAddressTxn(sk SK, owner consensus.Addr, t BlockAddressTxn, nonce uint64) []byte {
+	txn := &Txn{
+		T:     BlockAddress,
+		Data:  rlpEncode(t),
+		Nonce: nonce,
+		Owner: owner,
+	}
+
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	return txn.Encode(true)
+}
+
+type MinerFeeTxn struct {
+	Miner PK
+	Fee   uint64
+	// Converted is the fees paid in the tokens other than the
+	// native token, sorted by the token ID.
+	Converted []ConvertedFee
+}
+
+// ConvertedFee is the txn fees paid in a token other than the native
+// token.
+type ConvertedFee struct {
+	ID    TokenID
+	Quant uint64
+}
+
+type BurnTokenTxn struct {
+	ID    TokenID
+	Quant uint64
+}
+
+type IssueTokenTxn struct {
+	Info TokenInfo
+}
+
+type SendTokenTxn struct {
+	TokenID TokenID
+	To      PK
+	Quant   uint64
+}
+
+type FreezeTokenTxn struct {
+	TokenID        TokenID
+	AvailableRound uint64
+	Quant          uint64
+}
+
+// RecurringOrderTxn registers a schedule that places a child order
+// of Quant every Interval rounds, Count times in total. E.g., a
+// dollar-cost-averaging strategy buys a fixed quantity every N
+// rounds. The schedule is dropped when a child order can not be
+// placed.
+type RecurringOrderTxn struct {
+	SellSide bool
+	Quant    uint64
+	Price    uint64
+	Market   MarketSymbol
+	Interval uint64
+	Count    uint64
+	// the child order expires ExpireAfter rounds after it's
+	// placed, 0 means the child order never expires.
+	ExpireAfter uint64
+}
+
+// MarketConfigTxn configures the market, only the issuer of the
+// market's base token can configure the market.
+type MarketConfigTxn struct {
+	Market MarketSymbol
+	MarketConfigInfo
+}
+
+// SupplyTxn supplies tokens to the lending pool, or withdraws the
+// supplied tokens from the pool if Withdraw is true.
+type SupplyTxn struct {
+	TokenID  TokenID
+	Quant    uint64
+	Withdraw bool
+}
+
+// MarginTransferTxn transfers tokens from the owner's account to the
+// owner's margin account of the market, or back if Withdraw is true.
+type MarginTransferTxn struct {
+	Market   MarketSymbol
+	TokenID  TokenID
+	Quant    uint64
+	Withdraw bool
+}
+
+// MarginBorrowTxn borrows tokens from the lending pool into the
+// owner's margin account of the market, or repays the debt from the
+// margin account if Repay is true.
+type MarginBorrowTxn struct {
+	Market  MarketSymbol
+	TokenID TokenID
+	Quant   uint64
+	Repay   bool
+}
+
+// MarginOrderTxn places an order from the owner's margin account of
+// the order's market.
+type MarginOrderTxn struct {
+	PlaceOrderTxn
+}
+
+// MarginCancelOrderTxn cancels an order of the owner's margin
+// account.
+type MarginCancelOrderTxn struct {
+	ID OrderID
+}
+
+// PerpTransferTxn transfers the market's quote token from the
+// owner's account to the owner's perpetual account of the market as
+// collateral, or back if Withdraw is true.
+type PerpTransferTxn struct {
+	Market   MarketSymbol
+	Quant    uint64
+	Withdraw bool
+}
+
+// PerpOrderTxn places an order in the perpetual market that tracks
+// the order's market. Perpetual orders do not expire.
+type PerpOrderTxn struct {
+	PlaceOrderTxn
+}
+
+// PerpCancelOrderTxn cancels an order of the owner's perpetual
+// account.
+type PerpCancelOrderTxn struct {
+	ID OrderID
+}
+
+// BridgeRegisterTxn registers the wrapped token of an ERC-20 token,
+// it must be signed by the bridge group. The wrapped token is
+// created with 0 total units, units are minted by deposits.
+type BridgeRegisterTxn struct {
+	Token EthAddr
+	Info  TokenInfo
+	Sig   consensus.Sig
+}
+
+// BridgeMintTxn mints the wrapped tokens of a deposit locked in the
+// Ethereum lock contract, it must be signed by the bridge group.
+// Anyone can submit it on behalf of the recipient.
+type BridgeMintTxn struct {
+	Deposit BridgeDeposit
+	Sig     consensus.Sig
+}
+
+// BridgeWithdrawTxn burns the wrapped tokens and requests the
+// bridge group to authorize the withdrawal of the ERC-20 tokens
+// from the lock contract to the Ethereum address.
+type BridgeWithdrawTxn struct {
+	TokenID TokenID
+	Quant   uint64
+	To      EthAddr
+}
+
+// BridgeAuthorizeTxn records the bridge group's signature
+// authorizing a withdrawal, the signature is submitted to the lock
+// contract to release the ERC-20 tokens.
+type BridgeAuthorizeTxn struct {
+	ID  uint64
+	Sig consensus.Sig
+}
+
+// HTLCLockTxn locks the owner's tokens in a hash time locked
+// contract. The recipient claims the tokens by revealing the SHA-256
+// preimage of Hash before RefundRound, otherwise the owner can
+// refund the tokens from RefundRound on.
+type HTLCLockTxn struct {
+	To          PK
+	TokenID     TokenID
+	Quant       uint64
+	Hash        consensus.Hash
+	RefundRound uint64
+}
+
+// HTLCClaimTxn claims the tokens of the hash time locked contract
+// for its recipient, anyone knowing the preimage can submit it.
+type HTLCClaimTxn struct {
+	ID       consensus.Hash
+	Preimage []byte
+}
+
+// HTLCRefundTxn refunds the tokens of the expired hash time locked
+// contract to its owner.
+type HTLCRefundTxn struct {
+	ID consensus.Hash
+}
+
+// IBCCreateClientTxn creates a light client of a counterpart
+// chain with the initial client state, the client type must be
+// registered with RegisterLightClient.
+type IBCCreateClientTxn struct {
+	Type  string
+	State []byte
+}
+
+// IBCUpdateClientTxn updates the light client with a header of the
+// counterpart chain.
+type IBCUpdateClientTxn struct {
+	ClientID uint64
+	Header   []byte
+}
+
+// IBCOpenChannelTxn opens a channel to the counterparty channel of
+// the light client's chain.
+type IBCOpenChannelTxn struct {
+	ClientID     uint64
+	Counterparty uint64
+}
+
+// IBCTransferTxn sends the owner's tokens to the receiver on the
+// counterpart chain of the channel.
+type IBCTransferTxn struct {
+	Channel  uint64
+	TokenID  TokenID
+	Quant    uint64
+	Receiver []byte
+}
+
+// IBCRecvPacketTxn delivers a packet sent by the counterpart chain,
+// the proof proves the packet commitment against the root of the
+// counterpart chain at ProofHeight. Anyone can relay the packet.
+type IBCRecvPacketTxn struct {
+	Packet      IBCPacket
+	ProofHeight uint64
+	Proof       MerkleProof
+}
+
+// SetOraclesTxn sets the whitelist of the oracles, only the
+// governor can send it.
+type SetOraclesTxn struct {
+	Oracles []consensus.Addr
+	Quorum  uint64
+}
+
+// ReportPricesTxn reports the oracle's prices of the tokens, an
+// oracle can report once per round.
+type ReportPricesTxn struct {
+	Reports []OracleReport
+}
+
+// CreateStablecoinTxn creates the stablecoin, only the governor can
+// send it.
+type CreateStablecoinTxn struct {
+	Info TokenInfo
+}
+
+// ConfigCDPTxn accepts the token as the collateral of the CDPs, or
+// updates its ratios. Only the governor can send it.
+type ConfigCDPTxn struct {
+	Collateral       TokenID
+	MinRatio         uint64
+	LiquidationRatio uint64
+}
+
+// CDPLockTxn locks the collateral into the owner's CDP of the
+// collateral token, or withdraws it if Withdraw is true.
+type CDPLockTxn struct {
+	Collateral TokenID
+	Quant      uint64
+	Withdraw   bool
+}
+
+// CDPMintTxn mints the stablecoin against the owner's CDP of the
+// collateral token, or repays the debt if Repay is true.
+type CDPMintTxn struct {
+	Collateral TokenID
+	Quant      uint64
+	Repay      bool
+}
+
+// SetFeeScheduleTxn sets the trading fee schedule, only the
+// governor can send it.
+type SetFeeScheduleTxn struct {
+	Schedule FeeSchedule
+}
+
+// RegisterReferrerTxn registers the owner's referrer, it can be
+// registered only once.
+type RegisterReferrerTxn struct {
+	Referrer consensus.Addr
+}
+
+// SetRoundIntervalTxn sets the bounds of the adaptive round
+// interval, only the governor can send it.
+type SetRoundIntervalTxn struct {
+	Bounds RoundIntervalBounds
+}
+
+// VerifyIssuerTxn attests the issuer of the token, or revokes the
+// attestation, only the governor can send it.
+type VerifyIssuerTxn struct {
+	Token  TokenID
+	Name   string
+	Revoke bool
+}
+
+// DelistTxn delists the token, the markets of the token stop
+// accepting new orders immediately, and are closed at the end of the
+// retire round, only the governor can send it.
+type DelistTxn struct {
+	Token       TokenID
+	RetireRound uint64
+}
+
+// TokenWhitelistTxn sets whether the token is restricted to the
+// whitelisted holders, and approves or revokes the holders, only the
+// issuer of the token can send it.
+type TokenWhitelistTxn struct {
+	Token      TokenID
+	Restricted bool
+	Approve    []consensus.Addr
+	Revoke     []consensus.Addr
+}
+
+// EscrowOpenTxn locks the owner's (the buyer's) tokens in an escrow
+// for the seller. The tokens are released to the seller or refunded
+// to the buyer when the buyer and the seller agree, or the arbiter
+// decides, otherwise they are refunded at RefundRound. The ID of the
+// escrow is EscrowID(owner, nonce).
+type EscrowOpenTxn struct {
+	Seller      PK
+	Arbiter     consensus.Addr
+	TokenID     TokenID
+	Quant       uint64
+	RefundRound uint64
+}
+
+// EscrowDecideTxn votes for releasing the escrowed tokens to the
+// seller or refunding them to the buyer, the arbiter's vote is
+// final.
+type EscrowDecideTxn struct {
+	ID      consensus.Hash
+	Release bool
+}
+
+// StreamOpenTxn streams Rate units of the token per round from the
+// owner to the receiver until StopRound. The owner deposits the
+// tokens of all the rounds, the ID of the stream is
+// StreamID(owner, nonce).
+type StreamOpenTxn struct {
+	Receiver  PK
+	TokenID   TokenID
+	Rate      uint64
+	StopRound uint64
+}
+
+// StreamCancelTxn stops the payment stream, the streamed tokens are
+// paid to the receiver and the rest of the deposit is refunded to
+// the sender. Either the sender or the receiver can cancel it.
+type StreamCancelTxn struct {
+	ID consensus.Hash
+}
+
+// CancelAllOrdersTxn cancels all the pending orders of the owner in
+// the markets, or in all the markets if Markets is empty.
+type CancelAllOrdersTxn struct {
+	Markets []MarketSymbol
+}
+
+// SetRiskLimitTxn sets the owner's risk limit of the market. A
+// tighter limit applies immediately, a looser one after
+// RiskLimitLooseningDelay rounds.
+type SetRiskLimitTxn struct {
+	Market MarketSymbol
+	Limit  RiskLimit
+}
+
+// SetGuardianTxn sets the guardian of the owner, a zero address
+// removes it. Setting the first guardian applies immediately, a
+// change or removal after GuardianChangeDelay rounds.
+type SetGuardianTxn struct {
+	Guardian consensus.Addr
+}
+
+// KillSwitchTxn is sent by the guardian of the account. Disabling
+// cancels all the pending orders of the account and rejects its txns
+// until the guardian re-enables it.
+type KillSwitchTxn struct {
+	Account consensus.Addr
+	Disable bool
+}
+
+// SendToDepositTxn sends the token to the deposit address, it's
+// credited to the account of the address and recorded as a deposit
+// of the address index. The account must exist.
+type SendToDepositTxn struct {
+	TokenID TokenID
+	To      DepositAddr
+	Quant   uint64
+}
+
+// SetMMProgramTxn sets the market maker program of the market, only
+// the governor can set it. A zero program ends the program.
+type SetMMProgramTxn struct {
+	Market  MarketSymbol
+	Program MMProgram
+}
+
+// RegisterMarketMakerTxn registers the owner as a market maker of the
+// market's program.
+type RegisterMarketMakerTxn struct {
+	Market MarketSymbol
+}
+
+// BustTradeTxn reverses the trade of the index in the trade records
+// of the round, only the governor can bust a trade, within
+// TradeBustWindow rounds. The justification is recorded on chain.
+type BustTradeTxn struct {
+	Round         uint64
+	Index         uint32
+	Justification string
+}
+
+// ReduceOrderTxn lowers the remaining quantity of the order by Quant
+// in place, the order keeps its price-time priority. Quant must be
+// less than the remaining quantity, cancel the order to remove it.
+type ReduceOrderTxn struct {
+	ID    OrderID
+	Quant uint64
+}
+
+// RegisterValidatorTxn queues the node of the BLS public key for the
+// DKG of the next epoch, locking the bond of the native token. PoP is
+// the signature of ValidatorPoPMsg by the node's BLS key.
+type RegisterValidatorTxn struct {
+	PK   consensus.PK
+	Bond uint64
+	PoP  consensus.Sig
+}
+
+// SetTradingCalendarTxn sets the trading calendar of the market, only
+// the governor can set it. An empty calendar removes it.
+type SetTradingCalendarTxn struct {
+	Market   MarketSymbol
+	Calendar TradingCalendar
+}
+
+// SetIndexTxn defines the index or replaces its definition, only the
+// governor can set it. An index without components is removed.
+type SetIndexTxn struct {
+	Index Index
+}
+
+// SetAllowListTxn sets the owner's withdrawal allow-list. A tighter
+// allow-list applies immediately, a looser one after
+// AllowListLooseningDelay rounds.
+type SetAllowListTxn struct {
+	List AllowList
+}
+
+// SetTransferThresholdTxn sets the owner's large transfer threshold
+// of the token, 0 removes it. A lower threshold applies immediately,
+// a higher one or the removal after LargeTransferDelay rounds.
+type SetTransferThresholdTxn struct {
+	TokenID   TokenID
+	Threshold uint64
+}
+
+// AnnounceTransferTxn announces a transfer, it can be executed after
+// LargeTransferDelay rounds unless it's vetoed.
+type AnnounceTransferTxn struct {
+	TokenID TokenID
+	To      PK
+	Quant   uint64
+}
+
+// ExecuteTransferTxn executes the owner's announced transfer.
+type ExecuteTransferTxn struct {
+	ID consensus.Hash
+}
+
+// VetoTransferTxn refunds the announced transfer of Owner, the txn
+// owner must be Owner or its guardian.
+type VetoTransferTxn struct {
+	Owner consensus.Addr
+	ID    consensus.Hash
+}
+
+// BlockAddressTxn adds the address to the blocklist or removes it,
+// only the governor can change the blocklist.
+type BlockAddressTxn struct {
+	Addr   consensus.Addr
+	Block  bool
+	Reason string
+}
+
+// rlpEncode returns the canonical RLP encoding of the txn data.
+func rlpEncode(v interface{}) []byte {
+	b, err := rlp.EncodeToBytes(v)
+	if err != nil {
+		// should not happen
+		panic(err)
+	}
+	return b
+}
+
+// decodeCanonical decodes the RLP encoded txn data into v, it only
+// accepts the canonical encoding: the decoder rejects the integers
+// with leading zero bytes, the non-minimal sizes and the trailing
+// bytes, and the round trip rejects what the decoder tolerates, so
+// that the same txn can not be encoded as the bytes of a different
+// hash.
+func decodeCanonical(b []byte, v interface{}) error {
+	err := rlp.DecodeBytes(b, v)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(rlpEncode(v), b) {
+		return errors.New("non-canonical encoding")
+	}
+	return nil
 }
@@ -0,0 +1,93 @@
+package dex
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// the codes of the txn errors, the clients can tell the reason of a
+// rejected txn without parsing the error message.
+const (
+	ErrCodeInsufficientBalance = "insufficient_balance"
+	ErrCodeBadMarket           = "bad_market"
+	ErrCodeExpired             = "expired"
+	ErrCodeBadNonce            = "bad_nonce"
+	ErrCodeUnauthorized        = "unauthorized"
+	ErrCodePriceBand           = "price_band"
+	// ErrCodeBlocked is the code of the txns of or to an address
+	// on the governance blocklist.
+	ErrCodeBlocked = "blocked"
+	// ErrCodeUpgradeRequired is the code of the txns of a txn
+	// type newer than the node's TxnVersion, the node must be
+	// upgraded to validate them.
+	ErrCodeUpgradeRequired = "upgrade_required"
+	// ErrCodeOther is the code of the errors not classified.
+	ErrCodeOther = "other"
+)
+
+// TxnError is an error of a txn with its code.
+type TxnError struct {
+	Code string
+	Err  error
+}
+
+func (e *TxnError) Error() string {
+	return e.Err.Error()
+}
+
+func txnErrorf(code, format string, a ...interface{}) error {
+	return &TxnError{Code: code, Err: fmt.Errorf(format, a...)}
+}
+
+// ErrorCode returns the code of the txn error.
+func ErrorCode(err error) string {
+	switch e := err.(type) {
+	case *TxnError:
+		return e.Code
+	}
+
+	if err == consensus.ErrTxnNonceTooBig {
+		return ErrCodeBadNonce
+	}
+
+	return ErrCodeOther
+}
+
+// TxnErrorCount is the number of the txns rejected with the error
+// code by the transitions of the node.
+type TxnErrorCount struct {
+	Code  string
+	Count uint64
+}
+
+var txnErrors = struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}{counts: make(map[string]uint64)}
+
+func countTxnError(err error) {
+	code := ErrorCode(err)
+	txnErrors.mu.Lock()
+	txnErrors.counts[code]++
+	txnErrors.mu.Unlock()
+}
+
+// TxnErrorCounts returns the number of the rejected txns by the error
+// code, sorted by the code.
+func TxnErrorCounts() []TxnErrorCount {
+	txnErrors.mu.Lock()
+	defer txnErrors.mu.Unlock()
+
+	r := make([]TxnErrorCount, 0, len(txnErrors.counts))
+	for code, count := range txnErrors.counts {
+		r = append(r, TxnErrorCount{Code: code, Count: count})
+	}
+
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Code < r[j].Code
+	})
+	return r
+}
@@ -0,0 +1,54 @@
+package dex
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorCode(t *testing.T) {
+	assert.Equal(t, ErrCodeExpired, ErrorCode(txnErrorf(ErrCodeExpired, "expired")))
+	assert.Equal(t, ErrCodeBadNonce, ErrorCode(consensus.ErrTxnNonceTooBig))
+	assert.Equal(t, ErrCodeOther, ErrorCode(errors.New("other")))
+}
+
+func TestRecordErrorCode(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(1, Balance{Available: 100})
+	addr := pk.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{addr: pk}}
+	m := MarketSymbol{Base: 1, Quote: 0}
+
+	count := func(code string) uint64 {
+		for _, c := range TxnErrorCounts() {
+			if c.Code == code {
+				return c.Count
+			}
+		}
+		return 0
+	}
+	before := count(ErrCodeInsufficientBalance)
+
+	trans := s.Transition(2, 0, nil).(*Transition)
+	cases := []struct {
+		txn  []byte
+		code string
+	}{
+		{MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 101, Price: 1, Market: m}, 0), ErrCodeInsufficientBalance},
+		{MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 1, Price: 1, Market: MarketSymbol{Base: 1, Quote: 1}}, 0), ErrCodeBadMarket},
+		{MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 1, Price: 1, Market: m, ExpireRound: 2}, 0), ErrCodeExpired},
+		{MakePlaceOrderTxn(sk, addr, PlaceOrderTxn{SellSide: true, Quant: 1, Price: 1, Market: m}, 1), ErrCodeBadNonce},
+		{MakeSetRoundIntervalTxn(sk, addr, SetRoundIntervalTxn{}, 0), ErrCodeOther},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.code, ErrorCode(recordTxn(t, trans, c.txn, pker)))
+	}
+	assert.Equal(t, before+1, count(ErrCodeInsufficientBalance))
+}
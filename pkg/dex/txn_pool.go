@@ -1,8 +1,6 @@
 package dex
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
 	"sync"
 	"time"
@@ -22,12 +20,40 @@ type txnItem struct {
 	time time.Time
 }
 
+// TxnConflict is two txns of the same account, nonce lane and nonce
+// with different contents, both signed by the account key. It means
+// that the key is used by someone else or the client is buggy.
+type TxnConflict struct {
+	Owner consensus.Addr
+	Lane  uint8
+	Nonce uint64
+	// First is the hash of the txn seen first, Second is the hash
+	// of the conflicting txn.
+	First  consensus.Hash
+	Second consensus.Hash
+}
+
+type nonceKey struct {
+	owner consensus.Addr
+	lane  uint8
+	nonce uint64
+}
+
 type TxnPool struct {
 	pker pker
 
 	mu    sync.Mutex
 	txns  map[consensus.Hash]*consensus.Txn
 	cache *lru.Cache
+	// nonces is the hash of the first seen txn by the owner and
+	// nonce.
+	nonces     *lru.Cache
+	onConflict func(TxnConflict)
+	// orderQuota limits the order txns of each account added to
+	// the pool, the txns over the limit are neither kept nor
+	// relayed, but still parsed for the block validation.
+	orderQuota   Quota
+	orderLimiter *rateLimiter
 }
 
 func NewTxnPool(pker pker) *TxnPool {
@@ -36,24 +62,89 @@ func NewTxnPool(pker pker) *TxnPool {
 		panic(err)
 	}
 
+	nonces, err := lru.New(50000)
+	if err != nil {
+		panic(err)
+	}
+
 	return &TxnPool{
-		pker:  pker,
-		txns:  make(map[consensus.Hash]*consensus.Txn),
-		cache: cache,
+		pker:         pker,
+		txns:         make(map[consensus.Hash]*consensus.Txn),
+		cache:        cache,
+		nonces:       nonces,
+		orderLimiter: newRateLimiter(),
+	}
+}
+
+// SetOrderRateLimit limits the order txns of each account added to
+// the pool to rate per second, with bursts of burst txns. 0 rate means
+// no limit. It must be called before the node starts.
+func (t *TxnPool) SetOrderRateLimit(rate float64, burst int) {
+	t.orderQuota = Quota{Rate: rate, Burst: burst}
+}
+
+// isOrderTxn returns true if the txn places, changes or cancels
+// orders.
+func isOrderTxn(txn *consensus.Txn) bool {
+	switch txn.Decoded.(type) {
+	case *PlaceOrderTxn, *CancelOrderTxn, *RecurringOrderTxn, *MarginOrderTxn, *MarginCancelOrderTxn, *PerpOrderTxn, *PerpCancelOrderTxn, *CancelAllOrdersTxn, *ReduceOrderTxn:
+		return true
+	}
+
+	return false
+}
+
+// SetConflictHandler sets the function called when a txn conflicts
+// with a txn of the same owner and nonce seen before. It must be
+// called before the node starts.
+func (t *TxnPool) SetConflictHandler(f func(TxnConflict)) {
+	t.onConflict = f
+}
+
+func (t *TxnPool) checkConflict(txn *consensus.Txn, hash consensus.Hash) {
+	k := nonceKey{owner: txn.Owner, lane: txn.Lane, nonce: txn.Nonce}
+	t.mu.Lock()
+	v, ok := t.nonces.Get(k)
+	if !ok {
+		t.nonces.Add(k, hash)
+	}
+	t.mu.Unlock()
+
+	if !ok || v.(consensus.Hash) == hash {
+		return
+	}
+
+	c := TxnConflict{Owner: txn.Owner, Lane: txn.Lane, Nonce: txn.Nonce, First: v.(consensus.Hash), Second: hash}
+	log.Warn("conflicting txns of the same nonce, the key may be compromised", "owner", c.Owner, "lane", c.Lane, "nonce", c.Nonce, "first", c.First, "second", c.Second)
+	if t.onConflict != nil {
+		t.onConflict(c)
 	}
 }
 
 func parseTxn(b []byte, pker pker) (*consensus.Txn, error) {
+	// the txn hash is the hash of the raw bytes, only the
+	// canonical encoding is accepted so that a txn has exactly
+	// one hash.
 	var txn Txn
-	err := rlp.DecodeBytes(b, &txn)
+	err := decodeCanonical(b, &txn)
 	if err != nil {
 		return nil, fmt.Errorf("error decode txn: %v", err)
 	}
 
+	if _, ok := txn.T.Version(); !ok {
+		return nil, txnErrorf(ErrCodeUpgradeRequired, "txn type %d is newer than the txn version %d of the node, upgrade required", txn.T, TxnVersion)
+	}
+
 	ret := &consensus.Txn{
-		Raw:   b,
-		Owner: txn.Owner,
-		Nonce: txn.Nonce,
+		Raw:        b,
+		Owner:      txn.Owner,
+		Lane:       txn.Lane,
+		Nonce:      txn.Nonce,
+		ValidUntil: txn.ValidUntil,
+	}
+
+	if txn.Lane >= NonceLanes {
+		return nil, fmt.Errorf("invalid nonce lane %d, there are %d lanes", txn.Lane, NonceLanes)
 	}
 
 	switch txn.T {
@@ -65,59 +156,449 @@ func parseTxn(b []byte, pker pker) (*consensus.Txn, error) {
 		}
 		ret.Decoded = &t
 	case CancelOrder:
-		dec := gob.NewDecoder(bytes.NewReader(txn.Data))
-		var txn CancelOrderTxn
-		err := dec.Decode(&txn)
+		var t CancelOrderTxn
+		err := decodeCanonical(txn.Data, &t)
 		if err != nil {
 			return nil, fmt.Errorf("CancelOrderTxn decode failed: %v", err)
 		}
-		ret.Decoded = &txn
+		ret.Decoded = &t
 	case IssueToken:
-		dec := gob.NewDecoder(bytes.NewReader(txn.Data))
-		var txn IssueTokenTxn
-		err := dec.Decode(&txn)
+		var t IssueTokenTxn
+		err := decodeCanonical(txn.Data, &t)
 		if err != nil {
 			return nil, fmt.Errorf("IssueTokenTxn decode failed: %v", err)
 		}
-		ret.Decoded = &txn
+		ret.Decoded = &t
 	case SendToken:
-		dec := gob.NewDecoder(bytes.NewReader(txn.Data))
-		var txn SendTokenTxn
-		err := dec.Decode(&txn)
+		var t SendTokenTxn
+		err := decodeCanonical(txn.Data, &t)
 		if err != nil {
 			return nil, fmt.Errorf("SendTokenTxn decode failed: %v", err)
 		}
-		ret.Decoded = &txn
+		ret.Decoded = &t
 	case FreezeToken:
-		dec := gob.NewDecoder(bytes.NewReader(txn.Data))
-		var txn FreezeTokenTxn
-		err := dec.Decode(&txn)
+		var t FreezeTokenTxn
+		err := decodeCanonical(txn.Data, &t)
 		if err != nil {
 			return nil, fmt.Errorf("FreezeTokenTxn decode failed: %v", err)
 		}
-		ret.Decoded = &txn
+		ret.Decoded = &t
 	case BurnToken:
-		dec := gob.NewDecoder(bytes.NewReader(txn.Data))
-		var txn BurnTokenTxn
-		err := dec.Decode(&txn)
+		var t BurnTokenTxn
+		err := decodeCanonical(txn.Data, &t)
 		if err != nil {
 			return nil, fmt.Errorf("BurnTokenTxn decode failed: %v", err)
 		}
-		ret.Decoded = &txn
+		ret.Decoded = &t
+	case RecurringOrder:
+		var t RecurringOrderTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("RecurringOrderTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case MarketConfig:
+		var t MarketConfigTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("MarketConfigTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case Supply:
+		var t SupplyTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("SupplyTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case MarginTransfer:
+		var t MarginTransferTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("MarginTransferTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case MarginBorrow:
+		var t MarginBorrowTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("MarginBorrowTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case MarginOrder:
+		var t MarginOrderTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("MarginOrderTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case MarginCancelOrder:
+		var t MarginCancelOrderTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("MarginCancelOrderTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case PerpTransfer:
+		var t PerpTransferTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("PerpTransferTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case PerpOrder:
+		var t PerpOrderTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("PerpOrderTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case PerpCancelOrder:
+		var t PerpCancelOrderTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("PerpCancelOrderTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case BridgeRegister:
+		var t BridgeRegisterTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("BridgeRegisterTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case BridgeMint:
+		var t BridgeMintTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("BridgeMintTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case BridgeWithdraw:
+		var t BridgeWithdrawTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("BridgeWithdrawTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case BridgeAuthorize:
+		var t BridgeAuthorizeTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("BridgeAuthorizeTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case HTLCLock:
+		var t HTLCLockTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("HTLCLockTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case HTLCClaim:
+		var t HTLCClaimTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("HTLCClaimTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case HTLCRefund:
+		var t HTLCRefundTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("HTLCRefundTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case IBCCreateClient:
+		var t IBCCreateClientTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("IBCCreateClientTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case IBCUpdateClient:
+		var t IBCUpdateClientTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("IBCUpdateClientTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case IBCOpenChannel:
+		var t IBCOpenChannelTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("IBCOpenChannelTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case IBCTransfer:
+		var t IBCTransferTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("IBCTransferTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case IBCRecvPacket:
+		var t IBCRecvPacketTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("IBCRecvPacketTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case SetOracles:
+		var t SetOraclesTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("SetOraclesTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case ReportPrices:
+		var t ReportPricesTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("ReportPricesTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case CreateStablecoin:
+		var t CreateStablecoinTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("CreateStablecoinTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case ConfigCDP:
+		var t ConfigCDPTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("ConfigCDPTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case CDPLock:
+		var t CDPLockTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("CDPLockTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case CDPMint:
+		var t CDPMintTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("CDPMintTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case SetFeeSchedule:
+		var t SetFeeScheduleTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("SetFeeScheduleTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case RegisterReferrer:
+		var t RegisterReferrerTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("RegisterReferrerTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case SetRoundInterval:
+		var t SetRoundIntervalTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("SetRoundIntervalTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case VerifyIssuer:
+		var t VerifyIssuerTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("VerifyIssuerTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case Delist:
+		var t DelistTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("DelistTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case TokenWhitelist:
+		var t TokenWhitelistTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("TokenWhitelistTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case EscrowOpen:
+		var t EscrowOpenTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("EscrowOpenTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case EscrowDecide:
+		var t EscrowDecideTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("EscrowDecideTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case StreamOpen:
+		var t StreamOpenTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("StreamOpenTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case StreamCancel:
+		var t StreamCancelTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("StreamCancelTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case CancelAllOrders:
+		var t CancelAllOrdersTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("CancelAllOrdersTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case SetRiskLimit:
+		var t SetRiskLimitTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("SetRiskLimitTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case SetGuardian:
+		var t SetGuardianTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("SetGuardianTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case KillSwitch:
+		var t KillSwitchTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("KillSwitchTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case SendToDeposit:
+		var t SendToDepositTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("SendToDepositTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case SetMMProgram:
+		var t SetMMProgramTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("SetMMProgramTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case RegisterMarketMaker:
+		var t RegisterMarketMakerTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("RegisterMarketMakerTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case BustTrade:
+		var t BustTradeTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("BustTradeTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case ReduceOrder:
+		var t ReduceOrderTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("ReduceOrderTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case RegisterValidator:
+		var t RegisterValidatorTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("RegisterValidatorTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case SetTradingCalendar:
+		var t SetTradingCalendarTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("SetTradingCalendarTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case SetIndex:
+		var t SetIndexTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("SetIndexTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case SetAllowList:
+		var t SetAllowListTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("SetAllowListTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case SetTransferThreshold:
+		var t SetTransferThresholdTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("SetTransferThresholdTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case AnnounceTransfer:
+		var t AnnounceTransferTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("AnnounceTransferTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case ExecuteTransfer:
+		var t ExecuteTransferTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("ExecuteTransferTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case VetoTransfer:
+		var t VetoTransferTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("VetoTransferTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
+	case BlockAddress:
+		var t BlockAddressTxn
+		err := decodeCanonical(txn.Data, &t)
+		if err != nil {
+			return nil, fmt.Errorf("BlockAddressTxn decode failed: %v", err)
+		}
+		ret.Decoded = &t
 	case MinerFee:
-		dec := gob.NewDecoder(bytes.NewReader(txn.Data))
-		var txn MinerFeeTxn
-		err := dec.Decode(&txn)
+		var t MinerFeeTxn
+		err := decodeCanonical(txn.Data, &t)
 		if err != nil {
 			return nil, fmt.Errorf("MinerFeeTxn decode failed: %v", err)
 		}
-		ret.Decoded = &txn
+		ret.Decoded = &t
 		ret.MinerFeeTxn = true
 	default:
 		return nil, fmt.Errorf("unknown txn type: %v", txn.T)
 	}
 
-	if !ret.MinerFeeTxn && !txn.Sig.Verify(txn.Encode(false), pker.PK(txn.Owner)) {
+	if txn.Work != 0 && !txn.HasWork() {
+		return nil, fmt.Errorf("txn proof-of-work verification failed")
+	}
+
+	if !ret.MinerFeeTxn && !txn.Sig.Verify(txn.SigningMsg(), pker.PK(txn.Owner)) {
 		return nil, fmt.Errorf("txn signature verification failed")
 	}
 
@@ -143,6 +624,11 @@ func (t *TxnPool) Add(b []byte) (*consensus.Txn, bool) {
 
 	ret, err := parseTxn(b, t.pker)
 	if err != nil {
+		if ErrorCode(err) == ErrCodeUpgradeRequired {
+			log.Warn("txn of a newer txn version is not added to pool", "hash", hash, "err", err)
+			return nil, false
+		}
+
 		log.Error("error add txn to pool", "err", err)
 		return nil, false
 	}
@@ -151,6 +637,12 @@ func (t *TxnPool) Add(b []byte) (*consensus.Txn, bool) {
 		return ret, false
 	}
 
+	if isOrderTxn(ret) && !t.orderLimiter.allow(ret.Owner.Hex(), t.orderQuota, time.Now()) {
+		log.Debug("order txn over the account's rate limit is not added to pool", "owner", ret.Owner, "hash", hash)
+		return ret, false
+	}
+
+	t.checkConflict(ret, hash)
 	t.cache.Add(hash, ret)
 
 	t.mu.Lock()
@@ -0,0 +1,73 @@
+package dex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxnConflict(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	pk, sk := RandKeyPair()
+	pkOther, _ := RandKeyPair()
+	addr := pk.Addr()
+	s.NewAccount(pk)
+	pool := NewTxnPool(&myPKer{m: map[consensus.Addr]PK{addr: pk}})
+
+	a := newAccountStreams()
+	now := time.Now()
+	c := a.challenge(now, addr)
+	session, err := a.subscribe(now, s, SubscribeAccountArgs{Addr: addr, Challenge: c, Sig: sk.Sign(AccountStreamMsg(c))})
+	assert.Nil(t, err)
+	var conflicts []TxnConflict
+	pool.SetConflictHandler(func(c TxnConflict) {
+		conflicts = append(conflicts, c)
+		a.conflict(c)
+	})
+
+	first := MakeSendTokenTxn(sk, addr, pkOther, 0, 100, 0)
+	_, added := pool.Add(first)
+	assert.True(t, added)
+	pool.Add(first)
+	pool.Add(MakeSendTokenTxn(sk, addr, pkOther, 0, 100, 1))
+	assert.Empty(t, conflicts, "same txn or different nonces")
+
+	second := MakeSendTokenTxn(sk, addr, pkOther, 0, 200, 0)
+	pool.Add(second)
+	expected := TxnConflict{Owner: addr, Nonce: 0, First: consensus.SHA3(first), Second: consensus.SHA3(second)}
+	assert.Equal(t, []TxnConflict{expected}, conflicts)
+
+	var e AccountEvents
+	assert.Nil(t, a.poll(PollAccountArgs{Session: session}, &e))
+	assert.Equal(t, []AccountEvent{{Seq: 1, Type: ConflictEvent, Conflict: expected}}, e.Events)
+}
+
+func TestTxnPoolOrderRateLimit(t *testing.T) {
+	pk, sk := RandKeyPair()
+	pkOther, skOther := RandKeyPair()
+	addr := pk.Addr()
+	pool := NewTxnPool(&myPKer{m: map[consensus.Addr]PK{addr: pk, pkOther.Addr(): pkOther}})
+	pool.SetOrderRateLimit(0.001, 2)
+
+	order := PlaceOrderTxn{Quant: 1, Price: 1, Market: MarketSymbol{Base: 1, Quote: 0}}
+	for i := 0; i < 2; i++ {
+		_, added := pool.Add(MakePlaceOrderTxn(sk, addr, order, uint64(i)))
+		assert.True(t, added)
+	}
+
+	// the order over the limit is parsed, but not kept.
+	txn, added := pool.Add(MakePlaceOrderTxn(sk, addr, order, 2))
+	assert.NotNil(t, txn)
+	assert.False(t, added)
+	assert.Equal(t, 2, pool.Size())
+
+	// the other txns of the account and the orders of the other
+	// accounts are not limited.
+	_, added = pool.Add(MakeSendTokenTxn(sk, addr, pkOther, 0, 100, 3))
+	assert.True(t, added)
+	_, added = pool.Add(MakePlaceOrderTxn(skOther, pkOther.Addr(), order, 0))
+	assert.True(t, added)
+}
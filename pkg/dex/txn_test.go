@@ -1,8 +1,10 @@
 package dex
 
 import (
+	"reflect"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/helinwang/dex/pkg/consensus"
 	"github.com/stretchr/testify/assert"
@@ -44,3 +46,187 @@ func TestPlaceOrderEncodeDecode(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, p, p0)
 }
+
+func TestTxnNetworkIsolation(t *testing.T) {
+	defer SetNetworkID(networkID)
+	pk, sk := RandKeyPair()
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+
+	SetNetworkID(consensus.Testnet)
+	txn := MakeSendTokenTxn(sk, pk.Addr(), pk, 0, 20, 0)
+	_, err := parseTxn(txn, pker)
+	assert.Nil(t, err)
+
+	SetNetworkID(consensus.Mainnet)
+	_, err = parseTxn(txn, pker)
+	assert.NotNil(t, err)
+}
+
+func TestTxnCanonicalEncoding(t *testing.T) {
+	for _, v := range []interface{}{
+		&PlaceOrderTxn{},
+		&CancelOrderTxn{},
+		&IssueTokenTxn{},
+		&SendTokenTxn{},
+		&FreezeTokenTxn{},
+		&BurnTokenTxn{},
+		&RecurringOrderTxn{},
+		&MarketConfigTxn{},
+		&SupplyTxn{},
+		&MarginTransferTxn{},
+		&MarginBorrowTxn{},
+		&MarginOrderTxn{},
+		&MarginCancelOrderTxn{},
+		&PerpTransferTxn{},
+		&PerpOrderTxn{},
+		&PerpCancelOrderTxn{},
+		&BridgeRegisterTxn{},
+		&BridgeMintTxn{},
+		&BridgeWithdrawTxn{},
+		&BridgeAuthorizeTxn{},
+		&HTLCLockTxn{},
+		&HTLCClaimTxn{},
+		&HTLCRefundTxn{},
+		&IBCCreateClientTxn{},
+		&IBCUpdateClientTxn{},
+		&IBCOpenChannelTxn{},
+		&IBCTransferTxn{},
+		&IBCRecvPacketTxn{},
+		&SetOraclesTxn{},
+		&ReportPricesTxn{},
+		&CreateStablecoinTxn{},
+		&ConfigCDPTxn{},
+		&CDPLockTxn{},
+		&CDPMintTxn{},
+		&SetFeeScheduleTxn{},
+		&RegisterReferrerTxn{},
+		&SetRoundIntervalTxn{},
+		&VerifyIssuerTxn{},
+		&DelistTxn{},
+		&TokenWhitelistTxn{},
+		&EscrowOpenTxn{},
+		&EscrowDecideTxn{},
+		&StreamOpenTxn{},
+		&StreamCancelTxn{},
+		&CancelAllOrdersTxn{},
+		&SetRiskLimitTxn{},
+		&SetGuardianTxn{},
+		&KillSwitchTxn{},
+		&SendToDepositTxn{},
+		&SetMMProgramTxn{},
+		&RegisterMarketMakerTxn{},
+		&BustTradeTxn{},
+		&ReduceOrderTxn{},
+		&RegisterValidatorTxn{},
+		&MinerFeeTxn{},
+	} {
+		b := rlpEncode(v)
+		d := reflect.New(reflect.TypeOf(v).Elem()).Interface()
+		assert.Nil(t, decodeCanonical(b, d), "%T", v)
+		assert.Equal(t, b, rlpEncode(d), "%T", v)
+		assert.NotNil(t, decodeCanonical(append(b, 0), d), "trailing bytes %T", v)
+	}
+
+	p := PlaceOrderTxn{Quant: 100, Price: 1000, ExpireRound: 5, Market: MarketSymbol{Base: 1, Quote: 2}}
+	b, err := rlp.EncodeToBytes([]interface{}{false, uint64(100), uint64(1000), uint64(5), []interface{}{uint64(1), uint64(2)}})
+	assert.Nil(t, err)
+	assert.Equal(t, p.Encode(), b)
+	// the same order with the quantity of a leading zero byte.
+	b, err = rlp.EncodeToBytes([]interface{}{false, []byte{0, 100}, uint64(1000), uint64(5), []interface{}{uint64(1), uint64(2)}})
+	assert.Nil(t, err)
+	var p0 PlaceOrderTxn
+	assert.NotNil(t, p0.Decode(b))
+
+	pk, sk := RandKeyPair()
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	txn := MakePlaceOrderTxn(sk, pk.Addr(), p, 0)
+	_, err = parseTxn(txn, pker)
+	assert.Nil(t, err)
+	_, err = parseTxn(append(txn, 0), pker)
+	assert.NotNil(t, err, "trailing bytes")
+}
+
+func TestTxnValidUntil(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	pkTo, _ := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 100})
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+
+	txn, err := WithOptions(sk, MakeSendTokenTxn(sk, pk.Addr(), pkTo, 0, 10, 0), TxnOptions{ValidUntil: 5})
+	assert.Nil(t, err)
+	parsed, err := parseTxn(txn, pker)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), parsed.ValidUntil)
+	assert.False(t, parsed.Expired(5))
+	assert.True(t, parsed.Expired(6))
+
+	trans := s.Transition(6, 0, nil).(*Transition)
+	err = recordTxn(t, trans, txn, pker)
+	assert.Equal(t, ErrCodeExpired, ErrorCode(err))
+	trans = s.Transition(5, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, txn, pker))
+
+	// the round is signed.
+	var tampered Txn
+	assert.Nil(t, rlp.DecodeBytes(txn, &tampered))
+	tampered.ValidUntil = 0
+	_, err = parseTxn(tampered.Encode(true), pker)
+	assert.NotNil(t, err)
+}
+
+func TestTxnTypeVersion(t *testing.T) {
+	v, ok := PlaceOrder.Version()
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	v, ok = RegisterValidator.Version()
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	last := txnVersionLastTypes[TxnVersion-1]
+	v, ok = last.Version()
+	assert.True(t, ok)
+	assert.Equal(t, TxnVersion, v)
+	_, ok = (last + 1).Version()
+	assert.False(t, ok)
+
+	pk, sk := RandKeyPair()
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	txn := Txn{T: last + 1, Owner: pk.Addr()}
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	_, err := parseTxn(txn.Encode(true), pker)
+	assert.Equal(t, ErrCodeUpgradeRequired, ErrorCode(err))
+}
+
+func TestNonceLanes(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pk, sk := RandKeyPair()
+	pkTo, _ := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(0, Balance{Available: 100})
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	send := func(lane uint8, nonce uint64) []byte {
+		txn, err := WithOptions(sk, MakeSendTokenTxn(sk, pk.Addr(), pkTo, 0, 1, nonce), TxnOptions{Lane: lane})
+		assert.Nil(t, err)
+		return txn
+	}
+
+	_, err := parseTxn(send(NonceLanes, 0), pker)
+	assert.NotNil(t, err, "no such lane")
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Equal(t, consensus.ErrTxnNonceTooBig, recordTxn(t, trans, send(1, 1), pker))
+	assert.Nil(t, recordTxn(t, trans, send(1, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, send(0, 0), pker))
+	assert.Nil(t, recordTxn(t, trans, send(1, 1), pker))
+	assert.Equal(t, ErrCodeBadNonce, ErrorCode(recordTxn(t, trans, send(1, 0), pker)))
+	open, err := WithOptions(sk, MakeStreamOpenTxn(sk, pk.Addr(), StreamOpenTxn{Receiver: pkTo, TokenID: 0, Rate: 1, StopRound: 10}, 0), TxnOptions{Lane: 2})
+	assert.Nil(t, err)
+	assert.NotNil(t, recordTxn(t, trans, open, pker), "stream IDs are derived from lane 0")
+	s = trans.Commit().(*State)
+
+	assert.Equal(t, uint64(1), s.Nonce(pk.Addr()))
+	assert.Equal(t, uint64(2), s.LaneNonce(pk.Addr(), 1))
+	assert.Equal(t, uint64(0), s.LaneNonce(pk.Addr(), 2))
+	assert.Equal(t, 97, int(s.Account(pk.Addr()).Balance(0).Available))
+}
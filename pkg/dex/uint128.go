@@ -0,0 +1,82 @@
+package dex
+
+import (
+	"math"
+	"math/big"
+	"math/bits"
+)
+
+// Uint128 is an unsigned 128-bit integer, it's used for the sums of
+// the quantities that may overflow uint64, e.g., the ledger totals.
+type Uint128 struct {
+	Hi uint64
+	Lo uint64
+}
+
+// NewUint128 returns the Uint128 of v.
+func NewUint128(v uint64) Uint128 {
+	return Uint128{Lo: v}
+}
+
+// Add returns u + v, ok is false if the sum overflows.
+func (u Uint128) Add(v Uint128) (sum Uint128, ok bool) {
+	var carry uint64
+	sum.Lo, carry = bits.Add64(u.Lo, v.Lo, 0)
+	sum.Hi, carry = bits.Add64(u.Hi, v.Hi, carry)
+	return sum, carry == 0
+}
+
+// Sub returns u - v, ok is false if v is greater than u.
+func (u Uint128) Sub(v Uint128) (diff Uint128, ok bool) {
+	var borrow uint64
+	diff.Lo, borrow = bits.Sub64(u.Lo, v.Lo, 0)
+	diff.Hi, borrow = bits.Sub64(u.Hi, v.Hi, borrow)
+	return diff, borrow == 0
+}
+
+// Cmp returns -1, 0 or 1 if u is less than, equal to or greater
+// than v.
+func (u Uint128) Cmp(v Uint128) int {
+	switch {
+	case u.Hi < v.Hi || u.Hi == v.Hi && u.Lo < v.Lo:
+		return -1
+	case u == v:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// IsUint64 returns if u fits in an uint64.
+func (u Uint128) IsUint64() bool {
+	return u.Hi == 0
+}
+
+// Saturate returns the uint64 of u, or the max uint64 if u does not
+// fit.
+func (u Uint128) Saturate() uint64 {
+	if !u.IsUint64() {
+		return math.MaxUint64
+	}
+	return u.Lo
+}
+
+// sumQuant returns the sum of the quantities, it does not overflow.
+func sumQuant(quants ...uint64) Uint128 {
+	var sum Uint128
+	for _, q := range quants {
+		sum, _ = sum.Add(NewUint128(q))
+	}
+	return sum
+}
+
+// Big returns the big.Int of u.
+func (u Uint128) Big() *big.Int {
+	b := new(big.Int).SetUint64(u.Hi)
+	b.Lsh(b, 64)
+	return b.Or(b, new(big.Int).SetUint64(u.Lo))
+}
+
+func (u Uint128) String() string {
+	return u.Big().String()
+}
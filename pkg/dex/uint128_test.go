@@ -0,0 +1,31 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUint128(t *testing.T) {
+	max64 := NewUint128(math.MaxUint64)
+	sum, ok := max64.Add(NewUint128(1))
+	assert.True(t, ok)
+	assert.Equal(t, Uint128{Hi: 1}, sum)
+	assert.False(t, sum.IsUint64())
+	assert.Equal(t, uint64(math.MaxUint64), sum.Saturate())
+
+	diff, ok := sum.Sub(NewUint128(1))
+	assert.True(t, ok)
+	assert.Equal(t, max64, diff)
+	_, ok = NewUint128(1).Sub(sum)
+	assert.False(t, ok)
+
+	_, ok = Uint128{Hi: math.MaxUint64, Lo: math.MaxUint64}.Add(NewUint128(1))
+	assert.False(t, ok)
+
+	assert.Equal(t, -1, max64.Cmp(sum))
+	assert.Equal(t, 1, sum.Cmp(max64))
+	assert.Equal(t, 0, sum.Cmp(sum))
+	assert.Equal(t, "18446744073709551616", sum.String())
+}
@@ -0,0 +1,87 @@
+package dex
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+const (
+	// MinValidatorBond is the min bond of the native token that a
+	// validator locks to register, in the token's smallest unit.
+	MinValidatorBond = 1000000000000
+	// ValidatorEpochRounds is the number of the rounds of a DKG
+	// epoch, the validators registered in an epoch are included
+	// in the groups of the next epoch.
+	ValidatorEpochRounds = 10000
+	// maxValidatorQueue is the max number of the queued
+	// validators.
+	maxValidatorQueue = 1000
+)
+
+// ValidatorRegistration is a validator queued for the DKG of the
+// epoch, the bond is locked in the registration.
+type ValidatorRegistration struct {
+	Owner consensus.Addr
+	PK    consensus.PK
+	Bond  uint64
+	Round uint64
+	Epoch uint64
+}
+
+// ValidatorPoPMsg returns the message that the validator's BLS key
+// signs to prove the possession of the key for the owner.
+func ValidatorPoPMsg(owner consensus.Addr, pk consensus.PK) []byte {
+	h := consensus.SHA3([]byte("validator pop"), owner[:], pk)
+	return h[:]
+}
+
+func (t *Transition) registerValidator(owner *Account, txn *RegisterValidatorTxn) error {
+	if txn.Bond < MinValidatorBond {
+		return fmt.Errorf("validator bond %d is less than the min bond %d", txn.Bond, MinValidatorBond)
+	}
+
+	if _, err := txn.PK.Get(); err != nil {
+		return fmt.Errorf("invalid validator public key: %v", err)
+	}
+
+	addr := owner.PK().Addr()
+	if !txn.PoP.Verify(txn.PK, ValidatorPoPMsg(addr, txn.PK)) {
+		return errors.New("invalid proof of possession of the validator key")
+	}
+
+	queue := t.state.ValidatorQueue()
+	if len(queue) >= maxValidatorQueue {
+		return fmt.Errorf("validator queue is full: %d", len(queue))
+	}
+
+	pkAddr := txn.PK.Addr()
+	for _, r := range queue {
+		if r.Owner == addr {
+			return errors.New("owner already has a queued validator")
+		}
+
+		if r.PK.Addr() == pkAddr {
+			return errors.New("validator key is already queued")
+		}
+	}
+
+	b := owner.Balance(0)
+	if b.Available < txn.Bond {
+		return txnErrorf(ErrCodeInsufficientBalance, "insufficient available native token balance for the bond: %d, available: %d", txn.Bond, b.Available)
+	}
+
+	b.Available -= txn.Bond
+	owner.UpdateBalance(0, b)
+	queue = append(queue, ValidatorRegistration{
+		Owner: addr,
+		PK:    txn.PK,
+		Bond:  txn.Bond,
+		Round: t.round,
+		Epoch: t.round/ValidatorEpochRounds + 1,
+	})
+	t.state.UpdateValidatorQueue(queue)
+	t.logger().Info("validator queued", "owner", addr, "pk", pkAddr, "bond", txn.Bond, "epoch", t.round/ValidatorEpochRounds+1)
+	return nil
+}
@@ -0,0 +1,40 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterValidator(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pkA, skA := RandKeyPair()
+	pkB, skB := RandKeyPair()
+	a, b := pkA.Addr(), pkB.Addr()
+	s.NewAccount(pkA).UpdateBalance(0, Balance{Available: 3 * MinValidatorBond})
+	s.NewAccount(pkB).UpdateBalance(0, Balance{Available: MinValidatorBond - 1})
+	pker := &myPKer{m: map[consensus.Addr]PK{a: pkA, b: pkB}}
+
+	nodeSK := consensus.RandSK()
+	nodePK := nodeSK.MustPK()
+	register := func(sk SK, owner consensus.Addr, txn RegisterValidatorTxn, nonce uint64, trans *Transition) error {
+		return recordTxn(t, trans, MakeRegisterValidatorTxn(sk, owner, txn, nonce), pker)
+	}
+
+	trans := s.Transition(ValidatorEpochRounds+1, 0, nil).(*Transition)
+	pop := nodeSK.Sign(ValidatorPoPMsg(a, nodePK))
+	assert.NotNil(t, register(skA, a, RegisterValidatorTxn{PK: nodePK, Bond: MinValidatorBond - 1, PoP: pop}, 0, trans), "bond too small")
+	assert.NotNil(t, register(skA, a, RegisterValidatorTxn{PK: nodePK, Bond: MinValidatorBond, PoP: consensus.RandSK().Sign(ValidatorPoPMsg(a, nodePK))}, 0, trans), "not signed by the node key")
+	assert.NotNil(t, register(skB, b, RegisterValidatorTxn{PK: nodePK, Bond: MinValidatorBond, PoP: pop}, 0, trans), "proof for another owner")
+	assert.Nil(t, register(skA, a, RegisterValidatorTxn{PK: nodePK, Bond: 2 * MinValidatorBond, PoP: pop}, 0, trans))
+	other := consensus.RandSK()
+	assert.NotNil(t, register(skA, a, RegisterValidatorTxn{PK: other.MustPK(), Bond: MinValidatorBond, PoP: other.Sign(ValidatorPoPMsg(a, other.MustPK()))}, 1, trans), "owner already queued")
+	assert.NotNil(t, register(skB, b, RegisterValidatorTxn{PK: nodePK, Bond: MinValidatorBond, PoP: nodeSK.Sign(ValidatorPoPMsg(b, nodePK))}, 0, trans), "key already queued")
+	s = trans.Commit().(*State)
+
+	assert.Equal(t, MinValidatorBond, int(s.Account(a).Balance(0).Available))
+	assert.Equal(t, []ValidatorRegistration{{Owner: a, PK: nodePK, Bond: 2 * MinValidatorBond, Round: ValidatorEpochRounds + 1, Epoch: 2}}, s.ValidatorQueue())
+}
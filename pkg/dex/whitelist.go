@@ -0,0 +1,54 @@
+package dex
+
+import (
+	"fmt"
+
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// maxWhitelistUpdates is the max number of the holders approved or
+// revoked in a single whitelist txn.
+const maxWhitelistUpdates = 256
+
+// tokenWhitelist updates the holder whitelist of the token. A
+// restricted token, e.g., a security token, can only be sent to,
+// sent from and traded by the whitelisted addresses and its issuer.
+func (t *Transition) tokenWhitelist(owner *Account, txn *TokenWhitelistTxn) error {
+	issuer, ok := t.state.TokenIssuer(txn.Token)
+	if !ok || issuer != owner.PK().Addr() {
+		return fmt.Errorf("only the issuer of token %d can update the whitelist", txn.Token)
+	}
+
+	if len(txn.Approve)+len(txn.Revoke) > maxWhitelistUpdates {
+		return fmt.Errorf("whitelist txn can update at most %d holders", maxWhitelistUpdates)
+	}
+
+	t.state.UpdateTokenRestricted(txn.Token, txn.Restricted)
+	for _, addr := range txn.Approve {
+		t.state.UpdateTokenWhitelist(txn.Token, addr, true)
+	}
+
+	for _, addr := range txn.Revoke {
+		t.state.UpdateTokenWhitelist(txn.Token, addr, false)
+	}
+
+	return nil
+}
+
+// checkHolder returns an error if the address can not hold the
+// token.
+func (t *Transition) checkHolder(id TokenID, addr consensus.Addr) error {
+	if !t.state.TokenRestricted(id) {
+		return nil
+	}
+
+	if issuer, ok := t.state.TokenIssuer(id); ok && issuer == addr {
+		return nil
+	}
+
+	if !t.state.TokenWhitelisted(id, addr) {
+		return txnErrorf(ErrCodeUnauthorized, "token %d is restricted, %v is not whitelisted", id, addr)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package dex
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenWhitelist(t *testing.T) {
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	pkIssuer, skIssuer := RandKeyPair()
+	pkA, skA := RandKeyPair()
+	pkB, skB := RandKeyPair()
+	s.NewAccount(pkIssuer)
+	s.NewAccount(pkA)
+	s.NewAccount(pkB).UpdateBalance(0, Balance{Available: 1000})
+	issuer, a, b := pkIssuer.Addr(), pkA.Addr(), pkB.Addr()
+	pker := &myPKer{m: map[consensus.Addr]PK{issuer: pkIssuer, a: pkA, b: pkB}}
+
+	trans := s.Transition(1, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeIssueTokenTxn(skIssuer, issuer, TokenInfo{Symbol: "SEC", TotalUnits: 1000}, 0), pker))
+	assert.NotNil(t, recordTxn(t, trans, MakeTokenWhitelistTxn(skA, a, TokenWhitelistTxn{Token: 1, Restricted: true, Approve: []consensus.Addr{a}}, 0), pker), "not the issuer")
+	assert.NotNil(t, recordTxn(t, trans, MakeTokenWhitelistTxn(skIssuer, issuer, TokenWhitelistTxn{Token: 0, Restricted: true}, 1), pker), "genesis token has no issuer")
+	assert.Nil(t, recordTxn(t, trans, MakeTokenWhitelistTxn(skIssuer, issuer, TokenWhitelistTxn{Token: 1, Restricted: true, Approve: []consensus.Addr{a}}, 1), pker))
+	s = trans.Commit().(*State)
+	assert.True(t, s.TokenRestricted(1))
+	assert.True(t, s.TokenWhitelisted(1, a))
+	assert.False(t, s.TokenWhitelisted(1, b))
+
+	trans = s.Transition(2, 0, nil).(*Transition)
+	assert.NotNil(t, recordTxn(t, trans, MakeSendTokenTxn(skIssuer, issuer, pkB, 1, 10, 2), pker), "recipient not whitelisted")
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(skIssuer, issuer, pkA, 1, 10, 2), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(skA, a, pkIssuer, 1, 5, 0), pker))
+	price := uint64(math.Pow10(OrderPriceDecimals))
+	market := MarketSymbol{Base: 1, Quote: 0}
+	assert.Nil(t, recordTxn(t, trans, MakePlaceOrderTxn(skA, a, PlaceOrderTxn{SellSide: true, Quant: 5, Price: price, Market: market}, 1), pker))
+	assert.NotNil(t, recordTxn(t, trans, MakePlaceOrderTxn(skB, b, PlaceOrderTxn{Quant: 5, Price: price, Market: market}, 0), pker), "buyer not whitelisted")
+	s = trans.Commit().(*State)
+	assert.Equal(t, 0, int(s.Account(a).Balance(1).Available))
+	assert.Equal(t, 5, int(s.Account(a).Balance(1).Pending))
+
+	trans = s.Transition(3, 0, nil).(*Transition)
+	assert.Nil(t, recordTxn(t, trans, MakeTokenWhitelistTxn(skIssuer, issuer, TokenWhitelistTxn{Token: 1, Restricted: true, Revoke: []consensus.Addr{a}}, 3), pker))
+	assert.NotNil(t, recordTxn(t, trans, MakePlaceOrderTxn(skA, a, PlaceOrderTxn{SellSide: true, Quant: 5, Price: price, Market: market}, 2), pker), "revoked")
+	assert.Nil(t, recordTxn(t, trans, MakeTokenWhitelistTxn(skIssuer, issuer, TokenWhitelistTxn{Token: 1}, 4), pker))
+	assert.Nil(t, recordTxn(t, trans, MakeSendTokenTxn(skIssuer, issuer, pkB, 1, 10, 5), pker), "unrestricted")
+	s = trans.Commit().(*State)
+	assert.False(t, s.TokenRestricted(1))
+}
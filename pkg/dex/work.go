@@ -0,0 +1,53 @@
+package dex
+
+import (
+	"math/bits"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/helinwang/dex/pkg/consensus"
+)
+
+// workDifficulty is the number of the leading zero bits of the
+// proof-of-work hash required for a txn to be recorded without the
+// fee.
+var workDifficulty = 20
+
+// HasWork returns true if the txn carries a valid proof-of-work. The
+// work covers the txn without the signature.
+func (b *Txn) HasWork() bool {
+	if b.Work == 0 {
+		return false
+	}
+
+	return leadingZeroBits(consensus.SHA3(b.Encode(false))) >= workDifficulty
+}
+
+// SolveWork searches the proof-of-work nonce of the txn, it must be
+// called before the txn is signed.
+func (b *Txn) SolveWork() {
+	for b.Work = 1; !b.HasWork(); b.Work++ {
+	}
+}
+
+func leadingZeroBits(h consensus.Hash) int {
+	var n int
+	for _, v := range h {
+		n += bits.LeadingZeros8(v)
+		if v != 0 {
+			break
+		}
+	}
+	return n
+}
+
+// hasWork returns true if the serialized txn carries a valid
+// proof-of-work.
+func hasWork(raw []byte) bool {
+	var txn Txn
+	err := rlp.DecodeBytes(raw, &txn)
+	if err != nil {
+		return false
+	}
+
+	return txn.HasWork()
+}
@@ -0,0 +1,54 @@
+package dex
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/helinwang/dex/pkg/consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeelessWorkTxn(t *testing.T) {
+	defer func(d int) { workDifficulty = d }(workDifficulty)
+	workDifficulty = 8
+
+	s := NewState(ethdb.NewMemDatabase())
+	s.UpdateToken(Token{ID: 0, TokenInfo: BNBInfo})
+	s.UpdateToken(Token{ID: 1, TokenInfo: BNBInfo})
+	pkProposer, _ := RandKeyPair()
+	pk, sk := RandKeyPair()
+	pkTo, _ := RandKeyPair()
+	s.NewAccount(pk).UpdateBalance(1, Balance{Available: 100})
+	pker := &myPKer{m: map[consensus.Addr]PK{pk.Addr(): pk}}
+	addr := pk.Addr()
+
+	send := func(nonce uint64, solve bool) *Txn {
+		txn := &Txn{
+			T:     SendToken,
+			Owner: addr,
+			Nonce: nonce,
+			Data:  rlpEncode(SendTokenTxn{TokenID: 1, To: pkTo, Quant: 10}),
+		}
+		if solve {
+			txn.SolveWork()
+		}
+		txn.Sig = sk.Sign(txn.SigningMsg())
+		return txn
+	}
+
+	trans := s.Transition(1, 0, pkProposer).(*Transition)
+	err := recordTxn(t, trans, send(0, false).Bytes(), pker)
+	assert.Contains(t, err.Error(), "sufficient balance to pay fee")
+	assert.Nil(t, recordTxn(t, trans, send(0, true).Bytes(), pker))
+	s = trans.Commit().(*State)
+	assert.Equal(t, 90, int(s.Account(addr).Balance(1).Available))
+
+	txn := send(1, true)
+	txn.Work++
+	for txn.HasWork() {
+		txn.Work++
+	}
+	txn.Sig = sk.Sign(txn.SigningMsg())
+	_, err = parseTxn(txn.Bytes(), pker)
+	assert.NotNil(t, err)
+}